@@ -0,0 +1,43 @@
+// Package api holds the OpenAPI 3 document for the /v1 REST API. The document is the
+// contract clients generate from and the schema incoming requests are validated against
+// (see middleware.ValidateRequests), so it has to change together with the routes.
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+// Load parses the document and checks that it is valid OpenAPI
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// YAMLHandler serves the document as written
+func YAMLHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(spec)
+}
+
+// JSONHandler serves doc as JSON, for tools that do not read YAML
+func JSONHandler(doc *openapi3.T) http.HandlerFunc {
+	body, _ := json.Marshal(doc)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/ircbridge"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// Config is the server's settings. Each one can come from, lowest precedence first, its
+// default, the config file, an environment variable or a command-line flag. The three share
+// a name: the flag -mongo-op-timeout is MONGO_OP_TIMEOUT in the environment and
+// mongo_op_timeout in the file.
+type Config struct {
+	Port           string
+	LogLevel       string
+	LogFormat      string
+	MongoURI       string
+	DatabaseName   string
+	NATSUrl        string
+	AllowedOrigins []string
+	NodeID         int
+	RequestTimeout time.Duration
+
+	JWTPublicKeyPEM string
+	JWTIssuer       string
+	JWTAudience     string
+
+	// When set, keys are discovered from the JWKS URL instead of JWTPublicKeyPEM
+	JWTJWKSURL          string
+	JWKSRefreshInterval time.Duration
+
+	// Names the token claim holding a new user's workspace ID; empty puts every user in the default workspace
+	JWTWorkspaceClaim string
+
+	// When set, a saved profile comes from the GitHub account of the access token sent with it,
+	// which must be the token subject's, rather than from the client
+	GitHubVerifyProfiles bool
+	GitHubAPIURL         string
+	GitHubTimeout        time.Duration
+
+	// TenantIsolation keeps workspaces apart in storage: shared, database or prefix; in database
+	// mode each workspace's database is TenantDatabasePrefix followed by its ID
+	TenantIsolation      string
+	TenantDatabasePrefix string
+
+	PresenceRollupThreshold int
+	PresenceRollupInterval  time.Duration
+	PresenceTTL             time.Duration
+	LastSeenInterval        time.Duration
+
+	WSAuthExpiryWarning time.Duration
+	WSAuthCheckInterval time.Duration
+	WSSendBuffer        int
+	WSEphemeralBuffer   int
+
+	WSCompression          string
+	WSCompressionThreshold int
+
+	WSMaxConnectionsPerUser int
+	WSFrameBudget           int
+
+	ConversationCacheTTL  time.Duration
+	ConversationCacheSize int
+	BadgeCacheTTL         time.Duration
+	UserCache             string
+	UserCacheSize         int
+	UserCacheTTL          time.Duration
+
+	RateLimitIdleTTL time.Duration
+	RateLimitMaxKeys int
+	APIKeyRateLimit  int
+
+	// Client addresses, the per-address limit and the operator-managed ban list
+	TrustedProxies      []string
+	IPRateLimit         int
+	IPBanReloadInterval time.Duration
+
+	MaxBodyBytes       int64
+	ImportMaxBodyBytes int64
+	// AttachmentMaxBytes caps uploaded files; each is stored whole in one MongoDB document
+	AttachmentMaxBytes int64
+	// AttachmentStripMetadata removes EXIF, GPS included, and similar metadata from uploaded images
+	AttachmentStripMetadata bool
+
+	// Malware scanning of uploaded files, which are quarantined until they pass
+	AttachmentScanner       string
+	AttachmentScannerAddr   string
+	AttachmentClassifier    string
+	AttachmentClassifierURL string
+	AttachmentScanInterval  time.Duration
+	AttachmentScanTimeout   time.Duration
+
+	OutboxRelayInterval time.Duration
+	UndoSendWindow      time.Duration
+	PollCloseInterval   time.Duration
+	CallRingTimeout     time.Duration
+	CallSweepInterval   time.Duration
+
+	FeedWindow time.Duration
+
+	OfflineDeliveryLimit int
+	OfflineRetention     time.Duration
+
+	RetentionDefaultDays   int
+	RetentionMinDays       int
+	RetentionMaxDays       int
+	RetentionSweepInterval time.Duration
+
+	QuotaMessagesPerMonth int64
+	QuotaAttachmentBytes  int64
+	QuotaActiveUsers      int64
+	UsageRefreshInterval  time.Duration
+
+	AwaySummaryInterval time.Duration
+
+	UnfurlInterval time.Duration
+	UnfurlCacheTTL time.Duration
+	UnfurlTimeout  time.Duration
+
+	GIFProvider string
+	GIFAPIKey   string
+	GIFRating   string
+	GIFTimeout  time.Duration
+
+	// CAPTCHA challenges for senders the spam heuristics flag
+	CaptchaProvider         string
+	CaptchaSiteKey          string
+	CaptchaSecret           string
+	CaptchaVerifyURL        string
+	CaptchaTimeout          time.Duration
+	CaptchaRepeatedMessages int
+	CaptchaAddressMessages  int
+	CaptchaWindow           time.Duration
+	CaptchaChallengeTTL     time.Duration
+	CaptchaPassTTL          time.Duration
+
+	JournalWebhookURL    string
+	JournalWebhookSecret string
+	JournalMaxBackoff    time.Duration
+
+	NotifyWebhookURL    string
+	NotifyWebhookSecret string
+
+	StreamReconfigCooldown      time.Duration
+	StreamReconfigMaxLag        int
+	StreamReconfigHealthTimeout time.Duration
+
+	MongoOpTimeout        time.Duration
+	MongoMaxRetries       int
+	MongoRetryBackoff     time.Duration
+	MongoBreakerThreshold int
+	MongoBreakerCooldown  time.Duration
+
+	NATSReconnectWait    time.Duration
+	NATSReconnectBufSize int
+
+	// NATS credentials and TLS; see nats.ConnectionConfig
+	NATSCredsFile    string
+	NATSNKeySeedFile string
+	NATSToken        string
+	NATSUser         string
+	NATSPassword     string
+	NATSTLSCAFile    string
+	NATSTLSCertFile  string
+	NATSTLSKeyFile   string
+
+	// The gRPC API listens here when set; it uses the same TLS settings as the HTTP server
+	GRPCAddr string
+
+	// The XMPP gateway connects to this XMPP server component port when set; enable it on one node
+	XMPPComponentAddr string
+	XMPPDomain        string
+	XMPPSecret        string
+
+	// The IRC bridge connects to this IRC server when set; enable it on one node
+	IRCServer   string
+	IRCTLS      bool
+	IRCNick     string
+	IRCPassword string
+	IRCUserID   string
+	IRCChannels []string
+
+	// Texts to this Twilio account's number are bridged to conversations when set
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFromNumber string
+	TwilioWebhookURL string
+	SMSInboxUserID   string
+
+	// Admins can link group conversations to chats this Telegram bot is in when set
+	TelegramBotToken      string
+	TelegramWebhookURL    string
+	TelegramWebhookSecret string
+	TelegramUserID        string
+
+	// pprof and expvar listen here when set; keep it off the public interface
+	DebugAddr  string
+	DebugToken string
+
+	// GET /healthz/details is served to requests bearing this token; empty disables it
+	HealthToken string
+
+	// The operator API under /admin/v1 is served to requests bearing this token; empty disables it
+	AdminToken string
+
+	// The billing provider signs its webhook with this secret; empty disables the webhook
+	BillingWebhookSecret string
+
+	// Sync one workspace's users and groups from this LDAP directory; an empty URL disables it
+	LDAPURL              string
+	LDAPBindDN           string
+	LDAPBindPassword     string
+	LDAPBaseDN           string
+	LDAPUserFilter       string
+	LDAPGroupFilter      string
+	LDAPIDAttribute      string
+	LDAPGroupIDAttribute string
+	LDAPWorkspace        string
+	LDAPSyncInterval     time.Duration
+	LDAPSyncDryRun       bool
+
+	// Serve TLS from these files, or from certificates fetched via ACME for the autocert
+	// domains; neither means plain HTTP behind a terminating proxy
+	TLSCert            string
+	TLSKey             string
+	TLSAutocertDomains []string
+	TLSAutocertEmail   string
+	TLSAutocertCache   string
+	TLSHTTPAddr        string
+
+	// settings holds every setting above as a flag, for overrides and printing
+	settings *flag.FlagSet
+}
+
+// secretSettings are never printed
+var secretSettings = map[string]bool{
+	"jwt-public-key-pem":      true,
+	"journal-webhook-secret":  true,
+	"notify-webhook-secret":   true,
+	"debug-token":             true,
+	"health-token":            true,
+	"admin-token":             true,
+	"billing-webhook-secret":  true,
+	"ldap-bind-password":      true,
+	"nats-token":              true,
+	"nats-password":           true,
+	"gif-api-key":             true,
+	"xmpp-secret":             true,
+	"irc-password":            true,
+	"twilio-auth-token":       true,
+	"telegram-bot-token":      true,
+	"telegram-webhook-secret": true,
+}
+
+// telegramSecretPattern is what Telegram accepts as a webhook secret
+var telegramSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// urlSettings are printed with any password redacted
+var urlSettings = map[string]bool{
+	"mongodb-uri":         true,
+	"nats-url":            true,
+	"journal-webhook-url": true,
+	"notify-webhook-url":  true,
+}
+
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", "8080", "HTTP listen port")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", logging.FormatText, "text, or json for production log pipelines")
+	fs.StringVar(&c.MongoURI, "mongodb-uri", "mongodb://localhost:27017", "MongoDB connection string; must be a replica set")
+	fs.StringVar(&c.DatabaseName, "database-name", "chat_service", "MongoDB database")
+	fs.StringVar(&c.NATSUrl, "nats-url", "nats://localhost:4222", "NATS server URL")
+	c.AllowedOrigins = []string{"http://localhost:3000"}
+	fs.Var((*listValue)(&c.AllowedOrigins), "allowed-origins", "comma-separated CORS origins")
+	fs.IntVar(&c.NodeID, "node-id", 0, "0-31, unique per instance; part of every message ID")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", 60*time.Second, "HTTP handler deadline")
+
+	fs.StringVar(&c.JWTPublicKeyPEM, "jwt-public-key-pem", "", "RS256 public key verifying access tokens")
+	fs.StringVar(&c.JWTIssuer, "jwt-issuer", "chat-service", "required token issuer")
+	fs.StringVar(&c.JWTAudience, "jwt-audience", "chat-frontend", "required token audience")
+	fs.StringVar(&c.JWTJWKSURL, "jwt-jwks-url", "", "discover keys from a JWKS endpoint instead of the PEM")
+	fs.DurationVar(&c.JWKSRefreshInterval, "jwt-jwks-refresh-interval", 15*time.Minute, "how often JWKS keys are refetched")
+	fs.StringVar(&c.JWTWorkspaceClaim, "jwt-workspace-claim", "", "token claim naming the workspace a new user joins; empty uses the default workspace")
+	fs.BoolVar(&c.GitHubVerifyProfiles, "github-verify-profiles", true, "require PUT /v1/users/me to carry the user's GitHub access token in X-GitHub-Token and save GitHub's profile")
+	fs.StringVar(&c.GitHubAPIURL, "github-api-url", services.DefaultGitHubAPIURL, "GitHub REST API base URL; https://<host>/api/v3 for GitHub Enterprise Server")
+	fs.DurationVar(&c.GitHubTimeout, "github-timeout", 5*time.Second, "time allowed for a GitHub API request")
+	fs.StringVar(&c.TenantIsolation, "tenant-isolation", database.TenantsShared, "shared, database (one MongoDB database per workspace) or prefix (per-workspace collections)")
+	fs.StringVar(&c.TenantDatabasePrefix, "tenant-database-prefix", "", "database name prefix in database mode; empty uses the database name and an underscore")
+
+	fs.IntVar(&c.PresenceRollupThreshold, "presence-rollup-threshold", 100, "members above which presence is batched")
+	fs.DurationVar(&c.PresenceRollupInterval, "presence-rollup-interval", 5*time.Second, "batched presence interval")
+	fs.DurationVar(&c.PresenceTTL, "presence-ttl", time.Minute, "how long a silent node's users stay online elsewhere")
+	fs.DurationVar(&c.LastSeenInterval, "last-seen-interval", 30*time.Second, "how often users' last seen times are written; 0 disables")
+
+	fs.DurationVar(&c.WSAuthExpiryWarning, "ws-auth-expiry-warning", 2*time.Minute, "auth.expiring is sent this long before the token lapses")
+	fs.DurationVar(&c.WSAuthCheckInterval, "ws-auth-check-interval", 10*time.Second, "how often WS token expiry is checked")
+	fs.IntVar(&c.WSSendBuffer, "ws-send-buffer", 256, "frames queued per WS client before it counts as slow")
+	fs.IntVar(&c.WSEphemeralBuffer, "ws-ephemeral-buffer", 32, "typing and presence frames queued per WS client; the oldest is dropped when full")
+	fs.StringVar(&c.WSCompression, "ws-compression", services.CompressionNoContextTakeover, "permessage-deflate: off, no-context-takeover or context-takeover")
+	fs.IntVar(&c.WSCompressionThreshold, "ws-compression-threshold", 512, "smallest WS frame, in bytes, that is compressed")
+	fs.IntVar(&c.WSMaxConnectionsPerUser, "ws-max-connections-per-user", 10, "concurrent WS connections per user across nodes; 0 means no limit")
+	fs.IntVar(&c.WSFrameBudget, "ws-frame-budget", 600, "frames a WS connection may send per minute; 0 means no limit")
+
+	fs.DurationVar(&c.ConversationCacheTTL, "conversation-cache-ttl", 30*time.Second, "cached GET /v1/conversations lifetime; 0 disables")
+	fs.IntVar(&c.ConversationCacheSize, "conversation-cache-size", 100000, "conversation lists kept per node; the oldest go first")
+	fs.DurationVar(&c.BadgeCacheTTL, "badge-cache-ttl", 5*time.Second, "cached GET /v1/me/badge lifetime; 0 disables")
+	fs.StringVar(&c.UserCache, "user-cache", services.UserCacheLRU, "user profile cache: lru, kv or off")
+	fs.IntVar(&c.UserCacheSize, "user-cache-size", 10000, "profiles kept per node in lru mode")
+	fs.DurationVar(&c.UserCacheTTL, "user-cache-ttl", 5*time.Minute, "cached profile lifetime")
+
+	fs.DurationVar(&c.RateLimitIdleTTL, "rate-limit-idle-ttl", 10*time.Minute, "rate limit buckets unused this long are dropped")
+	fs.IntVar(&c.RateLimitMaxKeys, "rate-limit-max-keys", 100000, "rate limit buckets kept in memory")
+	fs.IntVar(&c.APIKeyRateLimit, "api-key-rate-limit", 60, "requests per minute for API keys created without a limit")
+
+	fs.Var((*listValue)(&c.TrustedProxies), "trusted-proxies", "comma-separated addresses or CIDR networks of proxies whose X-Forwarded-For is believed")
+	fs.IntVar(&c.IPRateLimit, "ip-rate-limit", 600, "requests per minute from one client address; 0 disables")
+	fs.DurationVar(&c.IPBanReloadInterval, "ip-ban-reload-interval", 30*time.Second, "how often each node reloads the IP ban list")
+
+	fs.Int64Var(&c.MaxBodyBytes, "max-body-bytes", 64<<10, "larger /v1 request bodies are rejected with 413")
+	fs.Int64Var(&c.ImportMaxBodyBytes, "import-max-body-bytes", 32<<20, "larger message import batches are rejected with 413")
+	fs.Int64Var(&c.AttachmentMaxBytes, "attachment-max-bytes", 10<<20, "larger uploaded files are rejected with 413, whatever the plan allows")
+	fs.BoolVar(&c.AttachmentStripMetadata, "attachment-strip-metadata", true, "remove EXIF (GPS included), XMP and similar metadata from uploaded JPEG, PNG and WebP images")
+	fs.StringVar(&c.AttachmentScanner, "attachment-scanner", services.ScannerOff, "malware scanner uploaded files are quarantined for: clamav, icap or off")
+	fs.StringVar(&c.AttachmentScannerAddr, "attachment-scanner-addr", "", "clamd host:port or socket path, or ICAP service URL (icap://host[:port]/service)")
+	fs.StringVar(&c.AttachmentClassifier, "attachment-classifier", services.ClassifierOff, "explicit image classifier uploaded images are quarantined for: http or off")
+	fs.StringVar(&c.AttachmentClassifierURL, "attachment-classifier-url", "", "URL images are posted to, answering {\"score\": 0 to 1}")
+	fs.DurationVar(&c.AttachmentScanInterval, "attachment-scan-interval", 2*time.Second, "how often quarantined uploads are looked for and scanned")
+	fs.DurationVar(&c.AttachmentScanTimeout, "attachment-scan-timeout", 30*time.Second, "time allowed to scan one file")
+
+	fs.DurationVar(&c.OutboxRelayInterval, "outbox-relay-interval", time.Second, "how often unpublished messages are retried; 0 disables the relay")
+	fs.DurationVar(&c.UndoSendWindow, "undo-send-window", 10*time.Second, "how long a sender may retract a message; 0 disables undo")
+	fs.DurationVar(&c.PollCloseInterval, "poll-close-interval", 10*time.Second, "how often polls past their close time are closed; 0 disables closing")
+	fs.DurationVar(&c.CallRingTimeout, "call-ring-timeout", time.Minute, "calls unanswered this long are recorded as missed")
+	fs.DurationVar(&c.CallSweepInterval, "call-sweep-interval", 10*time.Second, "how often unanswered calls are checked; 0 disables the check")
+
+	fs.DurationVar(&c.FeedWindow, "feed-window", 7*24*time.Hour, "how far back the activity feed looks")
+
+	fs.IntVar(&c.OfflineDeliveryLimit, "offline-delivery-limit", 1000, "messages replayed on reconnect; 0 disables offline delivery")
+	fs.DurationVar(&c.OfflineRetention, "offline-retention", 7*24*time.Hour, "parked offline consumers expire after this long unused")
+
+	fs.IntVar(&c.RetentionDefaultDays, "retention-default-days", 0, "default retention; 0 keeps messages indefinitely")
+	fs.IntVar(&c.RetentionMinDays, "retention-min-days", 1, "lower bound for conversation overrides")
+	fs.IntVar(&c.RetentionMaxDays, "retention-max-days", 0, "upper bound for conversation overrides; 0 means unbounded")
+	fs.DurationVar(&c.RetentionSweepInterval, "retention-sweep-interval", time.Hour, "how often expired messages are deleted")
+
+	fs.Int64Var(&c.QuotaMessagesPerMonth, "quota-messages-per-month", 0, "messages a workspace may send per calendar month (UTC); 0 means no limit")
+	fs.Int64Var(&c.QuotaAttachmentBytes, "quota-attachment-bytes", 0, "attachment storage a workspace may use; 0 means no limit")
+	fs.Int64Var(&c.QuotaActiveUsers, "quota-active-users", 0, "users a workspace may have active in a calendar month; 0 means no limit")
+	fs.DurationVar(&c.UsageRefreshInterval, "usage-refresh-interval", 15*time.Minute, "how often attachment storage and active users are measured; 0 disables measuring")
+
+	fs.DurationVar(&c.AwaySummaryInterval, "away-summary-interval", time.Minute, "how often users leaving do-not-disturb get their away summary")
+
+	fs.DurationVar(&c.UnfurlInterval, "unfurl-interval", 2*time.Second, "how often links in new messages are unfurled into previews; 0 disables previews")
+	fs.DurationVar(&c.UnfurlCacheTTL, "unfurl-cache-ttl", 24*time.Hour, "how long a fetched link preview is reused")
+	fs.DurationVar(&c.UnfurlTimeout, "unfurl-timeout", 5*time.Second, "time allowed to fetch a linked page, redirects included")
+
+	fs.StringVar(&c.GIFProvider, "gif-provider", services.GIFProviderOff, "GIF and sticker search: giphy, tenor or off")
+	fs.StringVar(&c.GIFAPIKey, "gif-api-key", "", "API key for the GIF provider")
+	fs.StringVar(&c.GIFRating, "gif-rating", "pg", "highest content rating of GIFs shown: g, pg, pg-13 or r")
+	fs.DurationVar(&c.GIFTimeout, "gif-timeout", 5*time.Second, "time allowed for a GIF provider request")
+
+	fs.StringVar(&c.CaptchaProvider, "captcha-provider", services.CaptchaProviderOff, "CAPTCHA challenges for flagged senders: turnstile, hcaptcha or off")
+	fs.StringVar(&c.CaptchaSiteKey, "captcha-site-key", "", "site key clients show the CAPTCHA widget with")
+	fs.StringVar(&c.CaptchaSecret, "captcha-secret", "", "secret key for verifying CAPTCHA tokens with the provider")
+	fs.StringVar(&c.CaptchaVerifyURL, "captcha-verify-url", "", "token verification endpoint; empty uses the provider's")
+	fs.DurationVar(&c.CaptchaTimeout, "captcha-timeout", 5*time.Second, "time allowed for a CAPTCHA verification request")
+	fs.IntVar(&c.CaptchaRepeatedMessages, "captcha-repeated-messages", 5, "identical messages in a row from one user within the window that call for a CAPTCHA; 0 disables")
+	fs.IntVar(&c.CaptchaAddressMessages, "captcha-address-messages", 120, "messages from one client address within the window that call for a CAPTCHA; 0 disables")
+	fs.DurationVar(&c.CaptchaWindow, "captcha-window", time.Minute, "window the CAPTCHA heuristics count messages in")
+	fs.DurationVar(&c.CaptchaChallengeTTL, "captcha-challenge-ttl", 24*time.Hour, "how long a CAPTCHA challenge stands unsolved")
+	fs.DurationVar(&c.CaptchaPassTTL, "captcha-pass-ttl", time.Hour, "how long a user and address that solved a CAPTCHA are not challenged again")
+
+	fs.StringVar(&c.JournalWebhookURL, "journal-webhook-url", "", "compliance journaling endpoint")
+	fs.StringVar(&c.JournalWebhookSecret, "journal-webhook-secret", "", "HMAC-SHA256 key for X-Journal-Signature")
+	fs.DurationVar(&c.JournalMaxBackoff, "journal-max-backoff", 5*time.Minute, "longest wait between journal delivery attempts")
+	fs.StringVar(&c.NotifyWebhookURL, "notify-webhook-url", "", "push gateway receiving notifications; empty disables them")
+	fs.StringVar(&c.NotifyWebhookSecret, "notify-webhook-secret", "", "HMAC-SHA256 key for X-Notification-Signature")
+
+	fs.DurationVar(&c.StreamReconfigCooldown, "stream-reconfig-cooldown", time.Hour, "minimum spacing between CHAT stream reconfigurations")
+	fs.IntVar(&c.StreamReconfigMaxLag, "stream-reconfig-max-lag", 10000, "pre-check: max undelivered messages for any consumer")
+	fs.DurationVar(&c.StreamReconfigHealthTimeout, "stream-reconfig-health-timeout", 2*time.Minute, "how long a reconfigured stream has to become healthy")
+
+	fs.DurationVar(&c.MongoOpTimeout, "mongo-op-timeout", 5*time.Second, "per-attempt MongoDB limit")
+	fs.IntVar(&c.MongoMaxRetries, "mongo-max-retries", 2, "retries of transient MongoDB failures")
+	fs.DurationVar(&c.MongoRetryBackoff, "mongo-retry-backoff", 100*time.Millisecond, "first retry delay, doubled each time")
+	fs.IntVar(&c.MongoBreakerThreshold, "mongo-breaker-threshold", 5, "consecutive failures that open the circuit breaker; 0 disables it")
+	fs.DurationVar(&c.MongoBreakerCooldown, "mongo-breaker-cooldown", 10*time.Second, "how long requests get 503 before MongoDB is tried again")
+
+	fs.DurationVar(&c.NATSReconnectWait, "nats-reconnect-wait", 2*time.Second, "delay between NATS reconnect attempts")
+	fs.IntVar(&c.NATSReconnectBufSize, "nats-reconnect-buffer", 8<<20, "bytes of publishes buffered while disconnected")
+	fs.StringVar(&c.NATSCredsFile, "nats-creds-file", "", "NATS .creds file (user JWT and seed)")
+	fs.StringVar(&c.NATSNKeySeedFile, "nats-nkey-seed-file", "", "NATS nkey seed file")
+	fs.StringVar(&c.NATSToken, "nats-token", "", "NATS auth token")
+	fs.StringVar(&c.NATSUser, "nats-user", "", "NATS user")
+	fs.StringVar(&c.NATSPassword, "nats-password", "", "NATS password")
+	fs.StringVar(&c.NATSTLSCAFile, "nats-tls-ca-file", "", "CA certificate trusted for the NATS server")
+	fs.StringVar(&c.NATSTLSCertFile, "nats-tls-cert-file", "", "client certificate presented to NATS")
+	fs.StringVar(&c.NATSTLSKeyFile, "nats-tls-key-file", "", "client certificate key")
+
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", "", "gRPC API listen address, e.g. :9090; empty disables")
+
+	fs.StringVar(&c.XMPPComponentAddr, "xmpp-component-addr", "", "XMPP server component port for the XMPP gateway, e.g. xmpp:5347; empty disables")
+	fs.StringVar(&c.XMPPDomain, "xmpp-domain", "", "domain the XMPP gateway serves, e.g. chat.example.com")
+	fs.StringVar(&c.XMPPSecret, "xmpp-secret", "", "shared secret for the XMPP component handshake")
+
+	fs.StringVar(&c.IRCServer, "irc-server", "", "IRC server host:port for the IRC bridge; empty disables")
+	fs.BoolVar(&c.IRCTLS, "irc-tls", true, "connect to the IRC server over TLS")
+	fs.StringVar(&c.IRCNick, "irc-nick", "chatbridge", "IRC bridge nick")
+	fs.StringVar(&c.IRCPassword, "irc-password", "", "IRC server password, if required")
+	fs.StringVar(&c.IRCUserID, "irc-user-id", "", "user that posts IRC lines; must be a member of each bridged conversation")
+	fs.Var((*listValue)(&c.IRCChannels), "irc-channels", "comma-separated <conversation id>=<#channel> pairs to bridge")
+
+	fs.StringVar(&c.TwilioAccountSID, "twilio-account-sid", "", "Twilio account SID for the SMS bridge; empty disables")
+	fs.StringVar(&c.TwilioAuthToken, "twilio-auth-token", "", "Twilio auth token, for API calls and webhook signatures")
+	fs.StringVar(&c.TwilioFromNumber, "twilio-from-number", "", "Twilio phone number texts come from, in E.164")
+	fs.StringVar(&c.TwilioWebhookURL, "twilio-webhook-url", "", "public URL of /webhooks/twilio/sms exactly as configured in Twilio")
+	fs.StringVar(&c.SMSInboxUserID, "sms-inbox-user-id", "", "user a number's first text starts a direct message with")
+
+	fs.StringVar(&c.TelegramBotToken, "telegram-bot-token", "", "Telegram bot token for the Telegram relay; empty disables")
+	fs.StringVar(&c.TelegramWebhookURL, "telegram-webhook-url", "", "public URL of /webhooks/telegram, registered with Telegram at start")
+	fs.StringVar(&c.TelegramWebhookSecret, "telegram-webhook-secret", "", "secret Telegram sends with each update")
+	fs.StringVar(&c.TelegramUserID, "telegram-user-id", "", "user that posts Telegram messages; must be a member of each linked conversation")
+
+	fs.StringVar(&c.DebugAddr, "debug-addr", "", "pprof and expvar listen address; empty disables")
+	fs.StringVar(&c.DebugToken, "debug-token", "", "bearer token required on the debug listener")
+	fs.StringVar(&c.HealthToken, "health-token", "", "operator bearer token for /healthz/details; empty disables it")
+	fs.StringVar(&c.AdminToken, "admin-token", "", "operator bearer token for the /admin/v1 API; empty disables it")
+	fs.StringVar(&c.BillingWebhookSecret, "billing-webhook-secret", "", "HMAC-SHA256 key for X-Billing-Signature on /webhooks/billing; empty disables the webhook")
+
+	fs.StringVar(&c.LDAPURL, "ldap-url", "", "ldap:// or ldaps:// URL of the directory to sync users and groups from; empty disables")
+	fs.StringVar(&c.LDAPBindDN, "ldap-bind-dn", "", "DN the sync binds as; empty binds anonymously")
+	fs.StringVar(&c.LDAPBindPassword, "ldap-bind-password", "", "password for ldap-bind-dn")
+	fs.StringVar(&c.LDAPBaseDN, "ldap-base-dn", "", "DN users and groups are searched under")
+	fs.StringVar(&c.LDAPUserFilter, "ldap-user-filter", "(&(objectCategory=person)(objectClass=user))", "filter selecting the users to sync")
+	fs.StringVar(&c.LDAPGroupFilter, "ldap-group-filter", "(objectClass=group)", "filter selecting the groups synced as group conversations")
+	fs.StringVar(&c.LDAPIDAttribute, "ldap-id-attribute", "userPrincipalName", "user attribute holding the subject of the user's tokens")
+	fs.StringVar(&c.LDAPGroupIDAttribute, "ldap-group-id-attribute", "objectGUID", "group attribute that survives renames and moves")
+	fs.StringVar(&c.LDAPWorkspace, "ldap-workspace", tenant.Default, "workspace the directory's users and groups are synced into")
+	fs.DurationVar(&c.LDAPSyncInterval, "ldap-sync-interval", time.Hour, "how often the LDAP sync runs; 0 runs it only on request")
+	fs.BoolVar(&c.LDAPSyncDryRun, "ldap-sync-dry-run", false, "scheduled LDAP syncs only report what they would change")
+
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "TLS certificate file; with tls-key, the server terminates TLS itself")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "TLS private key file")
+	fs.Var((*listValue)(&c.TLSAutocertDomains), "tls-autocert-domains", "comma-separated domains to obtain Let's Encrypt certificates for")
+	fs.StringVar(&c.TLSAutocertEmail, "tls-autocert-email", "", "contact address given to the ACME CA")
+	fs.StringVar(&c.TLSAutocertCache, "tls-autocert-cache", "autocert-cache", "directory keeping ACME certificates across restarts")
+	fs.StringVar(&c.TLSHTTPAddr, "tls-http-addr", ":80", "ACME challenge and https redirect listener")
+}
+
+// loadConfig builds the configuration from args (without the program name), the environment
+// and the file named by -config or CONFIG_FILE. printOnly is set by -print-config.
+func loadConfig(args []string) (config *Config, printOnly bool, err error) {
+	config = &Config{}
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	config.bind(fs)
+	config.settings = fs
+
+	var configFile string
+	fs.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"), "TOML config file")
+	fs.BoolVar(&printOnly, "print-config", false, "print the effective configuration as a config file and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	// Flags were applied by Parse; the file and the environment fill in the rest
+	fromFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { fromFlags[f.Name] = true })
+
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, false, err
+		}
+		for key, value := range values {
+			name := strings.ReplaceAll(key, "_", "-")
+			if !config.isSetting(name) {
+				return nil, false, fmt.Errorf("%s: unknown setting %q", configFile, key)
+			}
+			if fromFlags[name] || os.Getenv(envName(name)) != "" {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return nil, false, fmt.Errorf("%s: %s: %w", configFile, key, err)
+			}
+		}
+	}
+
+	var errs []error
+	config.eachSetting(func(f *flag.Flag) {
+		value := os.Getenv(envName(f.Name))
+		if value == "" || fromFlags[f.Name] {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+		}
+	})
+	if len(errs) > 0 {
+		return nil, false, errors.Join(errs...)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, false, err
+	}
+	return config, printOnly, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	c.eachSetting(func(f *flag.Flag) {
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case int:
+			check(v >= 0, "%s must not be negative", f.Name)
+		case int64:
+			check(v >= 0, "%s must not be negative", f.Name)
+		case time.Duration:
+			check(v >= 0, "%s must not be negative", f.Name)
+		}
+	})
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port <= 65535, "port must be a TCP port number")
+	check(c.NodeID <= 31, "node-id must be between 0 and 31")
+	check(c.CallSweepInterval == 0 || c.CallRingTimeout > 0, "call-ring-timeout must be positive")
+	check(c.UsageRefreshInterval > 0 || (c.QuotaAttachmentBytes == 0 && c.QuotaActiveUsers == 0),
+		"usage-refresh-interval must be positive with attachment or active user quotas")
+	check(c.LogFormat == logging.FormatText || c.LogFormat == logging.FormatJSON, "log-format must be text or json")
+	check(len(c.AllowedOrigins) > 0, "allowed-origins must list at least one origin")
+	check(c.JWTPublicKeyPEM != "" || c.JWTJWKSURL != "", "jwt-public-key-pem or jwt-jwks-url is required")
+	check(c.JWTIssuer != "", "jwt-issuer is required")
+	check(c.TenantIsolation == database.TenantsShared || c.TenantIsolation == database.TenantsDatabase || c.TenantIsolation == database.TenantsPrefix,
+		"tenant-isolation must be shared, database or prefix")
+	check(c.UserCache == services.UserCacheLRU || c.UserCache == services.UserCacheKV || c.UserCache == services.UserCacheOff,
+		"user-cache must be lru, kv or off")
+	check(c.ConversationCacheTTL <= 0 || c.ConversationCacheSize > 0, "conversation-cache-size must be positive while caching is on")
+	check(c.UserCache != services.UserCacheLRU || c.UserCacheSize > 0, "user-cache-size must be positive in lru mode")
+	check(c.WSFrameBudget >= 0, "ws-frame-budget must not be negative")
+	check(c.WSSendBuffer > 0, "ws-send-buffer must be positive")
+	check(c.WSEphemeralBuffer > 0, "ws-ephemeral-buffer must be positive")
+	check(c.WSCompression == services.CompressionOff || c.WSCompression == services.CompressionNoContextTakeover || c.WSCompression == services.CompressionContextTakeover,
+		"ws-compression must be off, no-context-takeover or context-takeover")
+	check(c.WSCompressionThreshold > 0, "ws-compression-threshold must be positive")
+	check(c.PresenceTTL >= 3*time.Second, "presence-ttl must be at least 3s")
+	check(c.MaxBodyBytes > 0, "max-body-bytes must be positive")
+	check(c.ImportMaxBodyBytes > 0, "import-max-body-bytes must be positive")
+	check(c.AttachmentMaxBytes > 0 && c.AttachmentMaxBytes <= 15<<20, "attachment-max-bytes must be positive and at most 15 MiB, to fit a MongoDB document")
+	check(c.AttachmentScanner == services.ScannerClamAV || c.AttachmentScanner == services.ScannerICAP || c.AttachmentScanner == services.ScannerOff,
+		"attachment-scanner must be clamav, icap or off")
+	check(c.AttachmentScanner == services.ScannerOff || c.AttachmentScannerAddr != "", "attachment-scanner-addr is required unless attachment-scanner is off")
+	_, scannerErr := services.NewAttachmentScanner(c.AttachmentScanner, c.AttachmentScannerAddr)
+	check(c.AttachmentScanner != services.ScannerICAP || c.AttachmentScannerAddr == "" || scannerErr == nil,
+		"attachment-scanner-addr must be an icap://host[:port]/service URL")
+	check(c.AttachmentClassifier == services.ClassifierHTTP || c.AttachmentClassifier == services.ClassifierOff,
+		"attachment-classifier must be http or off")
+	_, classifierErr := services.NewImageClassifier(c.AttachmentClassifier, c.AttachmentClassifierURL)
+	check(c.AttachmentClassifier != services.ClassifierHTTP || classifierErr == nil,
+		"attachment-classifier-url must be an http(s) URL when attachment-classifier is http")
+	check(c.AttachmentScanInterval > 0 && c.AttachmentScanTimeout > 0, "attachment-scan-interval and attachment-scan-timeout must be positive")
+	check(c.RequestTimeout > 0, "request-timeout must be positive")
+	check(c.RateLimitIdleTTL > 0, "rate-limit-idle-ttl must be positive")
+	check(c.RateLimitMaxKeys > 0, "rate-limit-max-keys must be positive")
+	check(c.APIKeyRateLimit > 0 && c.APIKeyRateLimit <= models.MaxAPIKeyRateLimit, "api-key-rate-limit must be between 1 and %d", models.MaxAPIKeyRateLimit)
+	for _, proxy := range c.TrustedProxies {
+		_, err := services.ParseIPPrefix(proxy)
+		check(err == nil, "trusted-proxies: %v", err)
+	}
+	check(c.IPRateLimit >= 0, "ip-rate-limit must not be negative")
+	check(c.IPBanReloadInterval > 0, "ip-ban-reload-interval must be positive")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls-cert and tls-key must be set together")
+	check((c.NATSTLSCertFile == "") == (c.NATSTLSKeyFile == ""), "nats-tls-cert-file and nats-tls-key-file must be set together")
+	natsAuth := 0
+	for _, set := range []bool{c.NATSCredsFile != "", c.NATSNKeySeedFile != "", c.NATSToken != "", c.NATSUser != ""} {
+		if set {
+			natsAuth++
+		}
+	}
+	check(natsAuth <= 1, "set only one of nats-creds-file, nats-nkey-seed-file, nats-token and nats-user")
+	check(c.NATSPassword == "" || c.NATSUser != "", "nats-password needs nats-user")
+	check(c.XMPPComponentAddr == "" || (c.XMPPDomain != "" && c.XMPPSecret != ""), "xmpp-component-addr needs xmpp-domain and xmpp-secret")
+	check(c.IRCServer == "" || (c.IRCNick != "" && c.IRCUserID != ""), "irc-server needs irc-nick and irc-user-id")
+	_, ircErr := ircbridge.ParseChannels(c.IRCChannels)
+	check(ircErr == nil, "irc-channels: %v", ircErr)
+	check(c.TwilioAccountSID == "" || (c.TwilioAuthToken != "" && c.TwilioFromNumber != "" && c.TwilioWebhookURL != "" && c.SMSInboxUserID != ""),
+		"twilio-account-sid needs twilio-auth-token, twilio-from-number, twilio-webhook-url and sms-inbox-user-id")
+	check(c.TelegramBotToken == "" || (c.TelegramWebhookURL != "" && c.TelegramWebhookSecret != "" && c.TelegramUserID != ""),
+		"telegram-bot-token needs telegram-webhook-url, telegram-webhook-secret and telegram-user-id")
+	check(c.TelegramWebhookSecret == "" || telegramSecretPattern.MatchString(c.TelegramWebhookSecret),
+		"telegram-webhook-secret must be 1 to 256 letters, digits, _ or -")
+	check(c.TLSCert == "" || len(c.TLSAutocertDomains) == 0, "tls-cert and tls-autocert-domains are mutually exclusive")
+	check(c.GIFProvider == services.GIFProviderGiphy || c.GIFProvider == services.GIFProviderTenor || c.GIFProvider == services.GIFProviderOff,
+		"gif-provider must be giphy, tenor or off")
+	check(c.GIFProvider == services.GIFProviderOff || c.GIFAPIKey != "", "gif-api-key is required unless gif-provider is off")
+	check(c.GIFRating == "g" || c.GIFRating == "pg" || c.GIFRating == "pg-13" || c.GIFRating == "r", "gif-rating must be g, pg, pg-13 or r")
+	check(c.GIFTimeout > 0, "gif-timeout must be positive")
+	check(c.CaptchaProvider == services.CaptchaProviderTurnstile || c.CaptchaProvider == services.CaptchaProviderHCaptcha || c.CaptchaProvider == services.CaptchaProviderOff,
+		"captcha-provider must be turnstile, hcaptcha or off")
+	check(c.CaptchaProvider == services.CaptchaProviderOff || (c.CaptchaSiteKey != "" && c.CaptchaSecret != ""),
+		"captcha-site-key and captcha-secret are required unless captcha-provider is off")
+	check(c.CaptchaTimeout > 0, "captcha-timeout must be positive")
+	check(c.CaptchaRepeatedMessages >= 0 && c.CaptchaAddressMessages >= 0, "captcha-repeated-messages and captcha-address-messages must not be negative")
+	check(c.CaptchaWindow > 0, "captcha-window must be positive")
+	check(c.CaptchaChallengeTTL > 0 && c.CaptchaPassTTL >= 0, "captcha-challenge-ttl must be positive and captcha-pass-ttl not negative")
+	check(c.GitHubTimeout > 0, "github-timeout must be positive")
+	check(c.LDAPURL == "" || c.LDAPBaseDN != "", "ldap-url needs ldap-base-dn")
+	check(c.LDAPBindDN == "" || c.LDAPBindPassword != "", "ldap-bind-dn needs ldap-bind-password")
+	check(c.LDAPSyncInterval >= 0, "ldap-sync-interval must not be negative")
+	check(c.RetentionMaxDays == 0 || c.RetentionMaxDays >= c.RetentionMinDays, "retention-max-days must not be below retention-min-days")
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration in config file format, secrets left out
+func (c *Config) Print(w io.Writer) {
+	c.eachSetting(func(f *flag.Flag) {
+		if secretSettings[f.Name] {
+			fmt.Fprintf(w, "# %s is secret and not shown\n", settingKey(f.Name))
+			return
+		}
+		fmt.Fprintf(w, "%s = %s\n", settingKey(f.Name), formatValue(f.Value, urlSettings[f.Name]))
+	})
+}
+
+// LogAttrs returns the effective configuration for the startup log, secrets reduced to
+// whether they are set
+func (c *Config) LogAttrs() []slog.Attr {
+	var attrs []slog.Attr
+	c.eachSetting(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case secretSettings[f.Name]:
+			value = strconv.FormatBool(value != "")
+		case urlSettings[f.Name]:
+			value = redactURL(value)
+		}
+		attrs = append(attrs, slog.String(settingKey(f.Name), value))
+	})
+	return attrs
+}
+
+// eachSetting visits every setting in name order, skipping the -config and -print-config flags
+func (c *Config) eachSetting(fn func(*flag.Flag)) {
+	c.settings.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		fn(f)
+	})
+}
+
+func (c *Config) isSetting(name string) bool {
+	return name != "config" && name != "print-config" && c.settings.Lookup(name) != nil
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func settingKey(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func formatValue(value flag.Value, redact bool) string {
+	switch v := value.(flag.Getter).Get().(type) {
+	case int, int64:
+		return fmt.Sprint(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		s := value.String()
+		if redact {
+			s = redactURL(s)
+		}
+		return strconv.Quote(s)
+	}
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// listValue is a comma-separated list flag; setting it replaces the default
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*l = items
+	return nil
+}
+
+func (l *listValue) Get() interface{} {
+	return []string(*l)
+}
+
+// readConfigFile reads the flat subset of TOML the settings need: key = value lines, with
+// quoted strings (durations too), bare integers, string arrays and # comments. Tables are not
+// supported. Values come back in flag syntax, arrays comma-joined.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, i+1)
+		}
+		key = strings.TrimSpace(key)
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, i+1, key, err)
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s set twice", path, i+1, key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", errors.New("unterminated array")
+		}
+		var items []string
+		for _, item := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]"), ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			s, err := strconv.Unquote(item)
+			if err != nil {
+				return "", fmt.Errorf("array items must be quoted strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		// TOML allows 1_000 digit grouping
+		return strings.ReplaceAll(raw, "_", ""), nil
+	}
+}
+
+// stripComment drops a # comment that is not inside a quoted string
+func stripComment(line string) string {
+	inString, escaped := false, false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && inString:
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case r == '#' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+)
+
+// newDebugServer serves pprof and expvar on their own listener so they are never
+// reachable through the public router. When token is set, requests must present it
+// as a bearer token as well.
+func newDebugServer(addr, token string, hub *services.WebSocketHub) *http.Server {
+	expvar.Publish("hub", expvar.Func(func() interface{} { return hub.Stats() }))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           requireBearerToken(token, "Debug token required", mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// requireBearerToken answers 401 with detail unless the request presents token; an empty
+// token lets everything through
+func requireBearerToken(token, detail string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			problem.Error(w, r, detail, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
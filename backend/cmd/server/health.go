@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// healthCheckTimeout bounds each dependency check, so a hung dependency shows up as an error
+// rather than a hung health request
+const healthCheckTimeout = 3 * time.Second
+
+// HealthDetails is the /healthz/details response. Status is "ok" when every dependency
+// answered, else "degraded" with a 503.
+type HealthDetails struct {
+	Status    string          `json:"status"`
+	CheckedAt time.Time       `json:"checkedAt"`
+	StartedAt time.Time       `json:"startedAt"`
+	Build     BuildInfo       `json:"build"`
+	Mongo     MongoHealth     `json:"mongo"`
+	NATS      NATSHealth      `json:"nats"`
+	JetStream JetStreamHealth `json:"jetstream"`
+	WebSocket WebSocketHealth `json:"websocket"`
+}
+
+type MongoHealth struct {
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latencyMs"`
+	Breaker   string  `json:"breaker"` // "closed", or "open" while requests get 503
+	Error     string  `json:"error,omitempty"`
+}
+
+type NATSHealth struct {
+	OK           bool    `json:"ok"`
+	State        string  `json:"state"` // e.g. CONNECTED, RECONNECTING
+	ConnectedURL string  `json:"connectedUrl,omitempty"`
+	RTTMs        float64 `json:"rttMs"`
+	Reconnects   uint64  `json:"reconnects"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// JetStreamHealth describes the CHAT stream
+type JetStreamHealth struct {
+	OK        bool   `json:"ok"`
+	Stream    string `json:"stream"`
+	Messages  uint64 `json:"messages"`
+	Bytes     uint64 `json:"bytes"`
+	FirstSeq  uint64 `json:"firstSeq"`
+	LastSeq   uint64 `json:"lastSeq"`
+	Consumers int    `json:"consumers"`
+	Replicas  int    `json:"replicas"`
+	Leader    string `json:"leader,omitempty"` // clustered streams only
+	Error     string `json:"error,omitempty"`
+}
+
+// WebSocketHealth counts this node's connections, WebSocket and gRPC Chat streams alike
+type WebSocketHealth struct {
+	Connections   int `json:"connections"`
+	Subscriptions int `json:"subscriptions"`
+}
+
+// healthDetailsHandler checks each dependency on every request; it is meant for operators
+// and probes that want more than /healthz, so it is not cached
+func healthDetailsHandler(db *database.MongoDB, nc *nats.NATSConnection, hub *services.WebSocketHub, build BuildInfo, startedAt time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		details := HealthDetails{
+			CheckedAt: time.Now().UTC(),
+			StartedAt: startedAt,
+			Build:     build,
+			Mongo:     checkMongo(r.Context(), db),
+			NATS:      checkNATS(nc),
+			JetStream: checkJetStream(r.Context(), nc),
+		}
+		stats := hub.Stats()
+		details.WebSocket = WebSocketHealth{Connections: stats.Clients, Subscriptions: stats.Subscriptions}
+
+		status := http.StatusOK
+		details.Status = "ok"
+		if !details.Mongo.OK || !details.NATS.OK || !details.JetStream.OK {
+			status = http.StatusServiceUnavailable
+			details.Status = "degraded"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(details)
+	}
+}
+
+func checkMongo(ctx context.Context, db *database.MongoDB) MongoHealth {
+	health := MongoHealth{Breaker: "closed"}
+	if db.Available() != nil {
+		health.Breaker = "open"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	// Straight to the driver: the probe should measure MongoDB, not wait out the breaker
+	err := db.Client.Ping(ctx, readpref.Primary())
+	health.LatencyMs = milliseconds(time.Since(start))
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.OK = true
+	return health
+}
+
+func checkNATS(nc *nats.NATSConnection) NATSHealth {
+	health := NATSHealth{
+		State:        nc.Conn.Status().String(),
+		ConnectedURL: nc.Conn.ConnectedUrlRedacted(),
+		Reconnects:   nc.Conn.Stats().Reconnects,
+	}
+	if !nc.Conn.IsConnected() {
+		health.Error = "not connected"
+		return health
+	}
+
+	// RTT flushes a ping through the server, with the connection's own timeout
+	rtt, err := nc.Conn.RTT()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.RTTMs = milliseconds(rtt)
+	health.OK = true
+	return health
+}
+
+func checkJetStream(ctx context.Context, nc *nats.NATSConnection) JetStreamHealth {
+	health := JetStreamHealth{Stream: nats.ChatStream}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	info, err := nc.ChatStreamInfo(ctx)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+
+	health.OK = true
+	health.Messages = info.State.Msgs
+	health.Bytes = info.State.Bytes
+	health.FirstSeq = info.State.FirstSeq
+	health.LastSeq = info.State.LastSeq
+	health.Consumers = info.State.Consumers
+	health.Replicas = info.Config.Replicas
+	if info.Cluster != nil {
+		health.Leader = info.Cluster.Leader
+	}
+	return health
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -2,73 +2,385 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/api"
+	"github.com/JohnBPerkins/chat-service/backend/internal/grpcapi"
 	"github.com/JohnBPerkins/chat-service/backend/internal/handlers"
+	"github.com/JohnBPerkins/chat-service/backend/internal/ircbridge"
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
 	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/internal/xmppgw"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
-	// Load configuration
-	config := &Config{
-		Port:           getEnv("PORT", "8080"),
-		MongoURI:       getEnv("MONGODB_URI", "mongodb://localhost:27017"),
-		DatabaseName:   getEnv("DATABASE_NAME", "chat_service"),
-		NATSUrl:        getEnv("NATS_URL", "nats://localhost:4222"),
-		AllowedOrigins: getEnv("ALLOWED_ORIGINS", "http://localhost:3000"),
+	config, printOnly, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		config.Print(os.Stdout)
+		return
+	}
+
+	logger, err := logging.New(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		os.Exit(2)
+	}
+	// Anything still using the log package goes through the same handler
+	slog.SetDefault(logger)
+	startedAt := time.Now().UTC()
+	build := buildInfo()
+	logger.Info("Starting chat service", "version", build.Version, "commit", build.Commit, "buildTime", build.BuildTime)
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "Effective configuration", config.LogAttrs()...)
+
+	clk := clock.System()
+
+	var jwtVerifier *middleware.JWTVerifier
+	if config.JWTJWKSURL != "" {
+		jwtVerifier, err = middleware.NewJWKSVerifier(context.Background(), config.JWTJWKSURL, config.JWTIssuer, config.JWTAudience, config.JWKSRefreshInterval, clk)
+	} else {
+		jwtVerifier, err = middleware.NewJWTVerifier(config.JWTPublicKeyPEM, config.JWTIssuer, config.JWTAudience)
+	}
+	if err != nil {
+		fatal("Failed to configure JWT authentication", err)
 	}
 
 	// Initialize MongoDB
-	db, err := database.NewMongoDB(config.MongoURI, config.DatabaseName)
+	db, err := database.NewMongoDB(config.MongoURI, config.DatabaseName, database.Policy{
+		OpTimeout:        config.MongoOpTimeout,
+		MaxRetries:       config.MongoMaxRetries,
+		RetryBackoff:     config.MongoRetryBackoff,
+		BreakerThreshold: config.MongoBreakerThreshold,
+		BreakerCooldown:  config.MongoBreakerCooldown,
+	}, clk)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		fatal("Failed to connect to MongoDB", err)
 	}
 	defer db.Close()
+	tenantPrefix := config.TenantDatabasePrefix
+	if tenantPrefix == "" {
+		tenantPrefix = config.DatabaseName + "_"
+	}
+	tenants, err := database.NewTenantResolver(config.TenantIsolation, db.DB, tenantPrefix)
+	if err != nil {
+		fatal("Failed to configure tenant isolation", err)
+	}
+	db.SetTenantResolver(tenants)
 
 	// Initialize NATS
-	nc, err := nats.NewConnection(config.NATSUrl)
+	nc, err := nats.NewConnection(nats.ConnectionConfig{
+		URL:              config.NATSUrl,
+		ReconnectWait:    config.NATSReconnectWait,
+		ReconnectBufSize: config.NATSReconnectBufSize,
+		CredsFile:        config.NATSCredsFile,
+		NKeySeedFile:     config.NATSNKeySeedFile,
+		Token:            config.NATSToken,
+		User:             config.NATSUser,
+		Password:         config.NATSPassword,
+		TLSCAFile:        config.NATSTLSCAFile,
+		TLSCertFile:      config.NATSTLSCertFile,
+		TLSKeyFile:       config.NATSTLSKeyFile,
+	}, logger)
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		fatal("Failed to connect to NATS", err)
 	}
 	defer nc.Close()
 
 	// Initialize services
-	userService := services.NewUserService(db)
-	conversationService := services.NewConversationService(db, userService)
-	messageService := services.NewMessageService(db, nc, userService)
+	ids, err := services.NewIDGenerator(clk, int64(config.NodeID))
+	if err != nil {
+		fatal("Failed to configure ID generator", err)
+	}
+	var userCache services.UserCache
+	switch config.UserCache {
+	case services.UserCacheLRU:
+		lru := services.NewLRUUserCache(nc, clk, logger, config.UserCacheSize, config.UserCacheTTL)
+		if err := lru.Start(); err != nil {
+			fatal("Failed to start user cache", err)
+		}
+		defer lru.Stop()
+		userCache = lru
+	case services.UserCacheKV:
+		kv, err := services.NewKVUserCache(context.Background(), nc, logger, config.UserCacheTTL)
+		if err != nil {
+			fatal("Failed to create user cache bucket", err)
+		}
+		userCache = kv
+	case services.UserCacheOff:
+	default:
+		fatal("Unknown USER_CACHE (want lru, kv or off)", fmt.Errorf("unknown user cache mode %q", config.UserCache))
+	}
+	userService := services.NewUserService(db, userCache, clk, ids)
+	conversationListCache := services.NewConversationListCache(nc, clk, logger, config.ConversationCacheTTL, config.ConversationCacheSize)
+	if err := conversationListCache.Start(); err != nil {
+		fatal("Failed to start conversation cache", err)
+	}
+	defer conversationListCache.Stop()
+	conversationService := services.NewConversationService(db, userService, conversationListCache, nc, clk, logger, ids)
+	auditService := services.NewAuditService(db, clk, ids)
+	var githubVerifier *services.GitHubVerifier
+	if config.GitHubVerifyProfiles {
+		githubVerifier = services.NewGitHubVerifier(logger, services.GitHubConfig{APIURL: config.GitHubAPIURL, Timeout: config.GitHubTimeout})
+	}
+	workspaceService := services.NewWorkspaceService(db, conversationService, userService, auditService, githubVerifier, clk, logger, config.JWTWorkspaceClaim)
+	billingService := services.NewBillingService(db, auditService, clk, logger, config.BillingWebhookSecret)
+	if err := workspaceService.Start(context.Background()); err != nil {
+		fatal("Failed to prepare workspaces", err)
+	}
+	retentionPolicy := services.RetentionPolicy{
+		DefaultDays: config.RetentionDefaultDays,
+		MinDays:     config.RetentionMinDays,
+		MaxDays:     config.RetentionMaxDays,
+	}
+	settingsService := services.NewSettingsService(db, conversationService, userService, auditService, clk, logger, retentionPolicy)
+	emojiService := services.NewEmojiService(db, conversationService, userService, clk, ids)
+	gifService := services.NewGIFService(logger, services.GIFConfig{
+		Provider: config.GIFProvider,
+		APIKey:   config.GIFAPIKey,
+		Rating:   config.GIFRating,
+		Timeout:  config.GIFTimeout,
+	})
+	challengeService := services.NewChallengeService(db, clk, logger, services.ChallengeConfig{
+		Provider:         config.CaptchaProvider,
+		SiteKey:          config.CaptchaSiteKey,
+		Secret:           config.CaptchaSecret,
+		VerifyURL:        config.CaptchaVerifyURL,
+		Timeout:          config.CaptchaTimeout,
+		RepeatedMessages: config.CaptchaRepeatedMessages,
+		AddressMessages:  config.CaptchaAddressMessages,
+		Window:           config.CaptchaWindow,
+		ChallengeTTL:     config.CaptchaChallengeTTL,
+		PassTTL:          config.CaptchaPassTTL,
+	})
+	usageService := services.NewUsageService(db, clk, logger, models.Quotas{
+		MessagesPerMonth: config.QuotaMessagesPerMonth,
+		AttachmentBytes:  config.QuotaAttachmentBytes,
+		ActiveUsers:      config.QuotaActiveUsers,
+	})
+	messageService := services.NewMessageService(db, nc, userService, settingsService, emojiService, gifService, usageService, clk, logger, ids, config.UndoSendWindow)
+	attachmentScanner, err := services.NewAttachmentScanner(config.AttachmentScanner, config.AttachmentScannerAddr)
+	if err != nil {
+		fatal("Failed to configure attachment scanner", err)
+	}
+	imageClassifier, err := services.NewImageClassifier(config.AttachmentClassifier, config.AttachmentClassifierURL)
+	if err != nil {
+		fatal("Failed to configure image classifier", err)
+	}
+	attachmentService := services.NewAttachmentService(db, conversationService, messageService, usageService, userService, auditService, clk, logger, ids, services.AttachmentConfig{
+		MaxBytes:      config.AttachmentMaxBytes,
+		StripMetadata: config.AttachmentStripMetadata,
+		Scanner:       attachmentScanner,
+		Classifier:    imageClassifier,
+		ScanInterval:  config.AttachmentScanInterval,
+		ScanTimeout:   config.AttachmentScanTimeout,
+	})
+	retentionService := services.NewRetentionService(db, conversationService, userService, auditService, settingsService, clk, logger, retentionPolicy)
+	purgeService := services.NewPurgeService(db, userService, auditService, clk, logger, ids)
+	importService := services.NewImportService(db, userService, auditService, clk, logger, ids)
+	apiKeyService := services.NewAPIKeyService(db, userService, auditService, clk, logger, ids, config.APIKeyRateLimit)
+	journalService := services.NewJournalService(nc, db, userService, clk, logger, ids, services.JournalConfig{
+		WebhookURL: config.JournalWebhookURL,
+		Secret:     config.JournalWebhookSecret,
+		MaxBackoff: config.JournalMaxBackoff,
+	})
+	streamConfigService := services.NewStreamConfigService(nc, db, userService, auditService, clk, logger, ids, services.StreamConfig{
+		Cooldown:      config.StreamReconfigCooldown,
+		MaxLag:        uint64(config.StreamReconfigMaxLag),
+		StoreHeadroom: 0.2,
+		HealthTimeout: config.StreamReconfigHealthTimeout,
+	})
+	watchService := services.NewWatchService(db, conversationService, userService, auditService, clk, logger, ids)
+	botService := services.NewBotService(db, conversationService, auditService, nc, clk, logger)
+	notificationService := services.NewNotificationService(db, settingsService, userService, nc, clk, logger)
+	badgeService := services.NewBadgeService(db, nc, clk, logger, config.BadgeCacheTTL)
+	if err := badgeService.Start(); err != nil {
+		fatal("Failed to start badge cache", err)
+	}
+	defer badgeService.Stop()
+	notificationDispatcher := services.NewNotificationDispatcher(nc, db, conversationService, notificationService, clk, logger, services.DispatchConfig{
+		WebhookURL: config.NotifyWebhookURL,
+		Secret:     config.NotifyWebhookSecret,
+	})
+	unfurlService := services.NewUnfurlService(db, messageService, clk, logger, services.UnfurlConfig{
+		Interval: config.UnfurlInterval,
+		CacheTTL: config.UnfurlCacheTTL,
+		Timeout:  config.UnfurlTimeout,
+	})
+	callService := services.NewCallService(db, conversationService, messageService, userService, clk, logger, ids, config.CallRingTimeout)
+	lockService := services.NewLockService(db, conversationService, messageService, userService, clk, logger, ids)
+	muteService := services.NewMuteService(db, conversationService, auditService, clk, logger)
+	smsService := services.NewSMSService(db, nc, conversationService, messageService, userService, clk, logger, services.SMSConfig{
+		AccountSID:  config.TwilioAccountSID,
+		AuthToken:   config.TwilioAuthToken,
+		FromNumber:  config.TwilioFromNumber,
+		WebhookURL:  config.TwilioWebhookURL,
+		InboxUserID: config.SMSInboxUserID,
+	})
+	telegramService := services.NewTelegramService(db, nc, conversationService, messageService, auditService, clk, logger, services.TelegramConfig{
+		BotToken:      config.TelegramBotToken,
+		WebhookURL:    config.TelegramWebhookURL,
+		WebhookSecret: config.TelegramWebhookSecret,
+		UserID:        config.TelegramUserID,
+	})
+	feedService := services.NewFeedService(db, conversationService, clk, config.FeedWindow)
+	webSocketHub := services.NewWebSocketHub(messageService, conversationService, userService, watchService, botService, challengeService, nc, jwtVerifier, clk, logger, services.HubConfig{
+		PresenceRollupThreshold: config.PresenceRollupThreshold,
+		PresenceRollupInterval:  config.PresenceRollupInterval,
+		AuthExpiryWarning:       config.WSAuthExpiryWarning,
+		AuthCheckInterval:       config.WSAuthCheckInterval,
+		OfflineDeliveryLimit:    config.OfflineDeliveryLimit,
+		OfflineRetention:        config.OfflineRetention,
+		SendBufferSize:          config.WSSendBuffer,
+		EphemeralBufferSize:     config.WSEphemeralBuffer,
+		Compression:             config.WSCompression,
+		CompressionThreshold:    config.WSCompressionThreshold,
+		PresenceTTL:             config.PresenceTTL,
+		NodeName:                strconv.Itoa(config.NodeID),
+		MaxConnectionsPerUser:   config.WSMaxConnectionsPerUser,
+		FrameBudget:             config.WSFrameBudget,
+		LastSeenInterval:        config.LastSeenInterval,
+	})
+	scimService := services.NewSCIMService(db, userService, conversationService, auditService, webSocketHub, clk, logger, ids)
+	ipBanService := services.NewIPBanService(db, clk, logger, ids)
+	ldapSyncService := services.NewLDAPSyncService(db, userService, conversationService, auditService, webSocketHub, clk, logger, ids, services.LDAPConfig{
+		URL:              config.LDAPURL,
+		BindDN:           config.LDAPBindDN,
+		BindPassword:     config.LDAPBindPassword,
+		BaseDN:           config.LDAPBaseDN,
+		UserFilter:       config.LDAPUserFilter,
+		GroupFilter:      config.LDAPGroupFilter,
+		IDAttribute:      config.LDAPIDAttribute,
+		GroupIDAttribute: config.LDAPGroupIDAttribute,
+		Workspace:        config.LDAPWorkspace,
+	})
+	if err := webSocketHub.Start(context.Background()); err != nil {
+		fatal("Failed to start WebSocket fan-out", err)
+	}
+	defer webSocketHub.Stop()
+
+	// Background workers stop when the server shuts down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go webSocketHub.Run(workerCtx)
+	go conversationListCache.RunExpiry(workerCtx)
+	go messageService.RunOutboxRelay(workerCtx, config.OutboxRelayInterval)
+	go messageService.RunPollCloser(workerCtx, config.PollCloseInterval)
+	go callService.RunMissedCallSweeper(workerCtx, config.CallSweepInterval)
+	go retentionService.RunSweeper(workerCtx, config.RetentionSweepInterval)
+	go usageService.RunMeter(workerCtx, config.UsageRefreshInterval)
+	go notificationService.RunAwaySummaries(workerCtx, config.AwaySummaryInterval)
+	go notificationDispatcher.Run(workerCtx)
+	go unfurlService.Run(workerCtx)
+	go attachmentService.RunScanner(workerCtx)
+	go purgeService.Run(workerCtx)
+	go journalService.Run(workerCtx)
+	go streamConfigService.Run(workerCtx)
+	go ldapSyncService.RunSync(workerCtx, config.LDAPSyncInterval, config.LDAPSyncDryRun)
+	go ipBanService.RunReload(workerCtx, config.IPBanReloadInterval)
+	go challengeService.RunCleanup(workerCtx)
+	go xmppgw.New(xmppgw.Config{
+		Addr:   config.XMPPComponentAddr,
+		Domain: config.XMPPDomain,
+		Secret: config.XMPPSecret,
+	}, webSocketHub, userService, conversationService, clk, logger).Run(workerCtx)
+	ircChannels, _ := ircbridge.ParseChannels(config.IRCChannels) // checked with the config
+	go ircbridge.New(ircbridge.Config{
+		Server:   config.IRCServer,
+		TLS:      config.IRCTLS,
+		Nick:     config.IRCNick,
+		Password: config.IRCPassword,
+		UserID:   config.IRCUserID,
+		Channels: ircChannels,
+	}, webSocketHub, userService, conversationService, clk, logger).Run(workerCtx)
+	go smsService.Run(workerCtx)
+	go telegramService.Run(workerCtx)
 
 	// Initialize handlers
 	handlers := &handlers.Handlers{
 		UserService:         userService,
 		ConversationService: conversationService,
 		MessageService:      messageService,
-		WebSocketHub:        services.NewWebSocketHub(messageService, nc),
+		RetentionService:    retentionService,
+		PurgeService:        purgeService,
+		ImportService:       importService,
+		APIKeyService:       apiKeyService,
+		JournalService:      journalService,
+		WatchService:        watchService,
+		StreamConfigService: streamConfigService,
+		BotService:          botService,
+		EmojiService:        emojiService,
+		GIFService:          gifService,
+		CallService:         callService,
+		LockService:         lockService,
+		MuteService:         muteService,
+		FeedService:         feedService,
+		SettingsService:     settingsService,
+		NotificationService: notificationService,
+		BadgeService:        badgeService,
+		SMSService:          smsService,
+		TelegramService:     telegramService,
+		WorkspaceService:    workspaceService,
+		UsageService:        usageService,
+		BillingService:      billingService,
+		SCIMService:         scimService,
+		LDAPSyncService:     ldapSyncService,
+		IPBanService:        ipBanService,
+		ChallengeService:    challengeService,
+		AttachmentService:   attachmentService,
+		WebSocketHub:        webSocketHub,
+		Logger:              logger,
 	}
 
 	// Setup router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(chimiddleware.Logger)
+	trustedProxies := make([]netip.Prefix, len(config.TrustedProxies))
+	for i, proxy := range config.TrustedProxies {
+		trustedProxies[i], _ = services.ParseIPPrefix(proxy) // checked with the config
+	}
+	ipLimiter := middleware.NewRateLimiter(clk, config.RateLimitIdleTTL, config.RateLimitMaxKeys)
+	go ipLimiter.RunCleanup(workerCtx, config.RateLimitIdleTTL/2)
+	r.Use(middleware.RequestID)
+	r.Use(middleware.ClientIP(trustedProxies))
+	r.Use(middleware.AccessLog(logger))
+	r.Use(middleware.IPFilter(ipBanService, ipLimiter, config.IPRateLimit, logger))
 	r.Use(chimiddleware.Recoverer)
-	r.Use(chimiddleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(config.RequestTimeout))
 
 	// CORS
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{config.AllowedOrigins},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins:   config.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "X-Request-ID", "X-GitHub-Token"},
+		ExposedHeaders:   []string{"Link", "X-Cache", "X-Snapshot-Generated-At", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -78,26 +390,243 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	if config.HealthToken != "" {
+		r.Method(http.MethodGet, "/healthz/details", requireBearerToken(config.HealthToken, "Operator token required",
+			healthDetailsHandler(db, nc, webSocketHub, build, startedAt)))
+	}
+
+	// Requests with X-API-Key authenticate as the key's principal; everything else needs a JWT
+	apiKeyLimiter := middleware.NewRateLimiter(clk, config.RateLimitIdleTTL, config.RateLimitMaxKeys)
+	go apiKeyLimiter.RunCleanup(workerCtx, config.RateLimitIdleTTL/2)
+	authMiddleware := middleware.APIKeyAuthMiddleware(apiKeyService, apiKeyLimiter, middleware.JWTAuthMiddleware(jwtVerifier))
+	if db.Isolated() {
+		// Each request is then served from its caller's workspace
+		authenticate, withTenant := authMiddleware, middleware.TenantMiddleware(workspaceService)
+		authMiddleware = func(next http.Handler) http.Handler { return authenticate(withTenant(next)) }
+	}
+	// Users deprovisioned over SCIM keep valid tokens until they expire, so each request checks
+	authenticated, requireActive := authMiddleware, middleware.RequireActiveUser(userService)
+	authMiddleware = func(next http.Handler) http.Handler { return authenticated(requireActive(next)) }
+
+	// OpenAPI document for the routes below, served unauthenticated so clients can generate from it
+	apiDoc, err := api.Load()
+	if err != nil {
+		fatal("Failed to load OpenAPI document", err)
+	}
+	validateRequests, err := middleware.ValidateRequests(apiDoc)
+	if err != nil {
+		fatal("Failed to build request validator", err)
+	}
+	r.Get("/openapi.yaml", api.YAMLHandler)
+	r.Get("/openapi.json", api.JSONHandler(apiDoc))
+
+	// Message imports take NDJSON batches far larger than other request bodies
+	r.With(middleware.MaxBodySize(config.ImportMaxBodyBytes), middleware.RequireDatabase(db), authMiddleware, validateRequests, middleware.RequireUserToken).
+		Post("/v1/workspace/import", handlers.ImportMessages)
+
+	// Uploaded files are sent as the raw request body, up to the attachment limit
+	r.With(middleware.MaxBodySize(config.AttachmentMaxBytes), middleware.RequireDatabase(db), authMiddleware, validateRequests, middleware.RequireScope(models.ScopeMessagesWrite)).
+		Post("/v1/conversations/{id}/attachments", handlers.UploadAttachment)
+
+	// Twilio and the billing provider sign their webhooks, and Telegram sends a shared secret,
+	// rather than authenticating
+	if smsService.Enabled() {
+		r.With(middleware.MaxBodySize(config.MaxBodyBytes), middleware.RequireDatabase(db)).
+			Post("/webhooks/twilio/sms", handlers.ReceiveSMS)
+	}
+	if telegramService.Enabled() {
+		r.With(middleware.MaxBodySize(config.MaxBodyBytes), middleware.RequireDatabase(db)).
+			Post("/webhooks/telegram", handlers.ReceiveTelegramUpdate)
+	}
+	if billingService.Enabled() {
+		r.With(middleware.MaxBodySize(config.MaxBodyBytes), middleware.RequireDatabase(db)).
+			Post("/webhooks/billing", handlers.ReceiveBillingEvent)
+	}
+
+	// Identity providers provision a workspace's users and groups with its SCIM token
+	r.Route("/scim/v2", func(r chi.Router) {
+		r.Use(middleware.MaxBodySize(config.MaxBodyBytes))
+		r.Use(middleware.RequireDatabase(db))
+		r.Use(handlers.SCIMAuth)
+
+		r.Get("/ServiceProviderConfig", handlers.GetSCIMServiceProviderConfig)
+		r.Get("/Users", handlers.ListSCIMUsers)
+		r.Post("/Users", handlers.CreateSCIMUser)
+		r.Get("/Users/{id}", handlers.GetSCIMUser)
+		r.Put("/Users/{id}", handlers.ReplaceSCIMUser)
+		r.Patch("/Users/{id}", handlers.PatchSCIMUser)
+		r.Delete("/Users/{id}", handlers.DeleteSCIMUser)
+		r.Get("/Groups", handlers.ListSCIMGroups)
+		r.Post("/Groups", handlers.CreateSCIMGroup)
+		r.Get("/Groups/{id}", handlers.GetSCIMGroup)
+		r.Put("/Groups/{id}", handlers.ReplaceSCIMGroup)
+		r.Patch("/Groups/{id}", handlers.PatchSCIMGroup)
+		r.Delete("/Groups/{id}", handlers.DeleteSCIMGroup)
+	})
+
+	// The operator API manages the deployment rather than one workspace, so it authenticates
+	// with ADMIN_TOKEN instead of as a user
+	if config.AdminToken != "" {
+		r.Route("/admin/v1", func(r chi.Router) {
+			r.Use(func(next http.Handler) http.Handler {
+				return requireBearerToken(config.AdminToken, "Operator token required", next)
+			})
+			r.Use(middleware.MaxBodySize(config.MaxBodyBytes))
+			r.Use(middleware.RequireDatabase(db))
 
-	// API routes (no JWT middleware - using GitHub OAuth only)
+			r.Get("/workspaces", handlers.ListWorkspaces)
+			r.Post("/workspaces", handlers.CreateWorkspace)
+			r.Get("/usage", handlers.GetUsage)
+			r.Get("/ip-bans", handlers.ListIPBans)
+			r.Post("/ip-bans", handlers.CreateIPBan)
+			r.Delete("/ip-bans/{id}", handlers.DeleteIPBan)
+			if ldapSyncService.Enabled() {
+				r.Post("/ldap/sync", handlers.SyncLDAP)
+				r.Get("/ldap/sync", handlers.GetLDAPSyncReport)
+			}
+		})
+	}
+
+	// API routes
 	r.Route("/v1", func(r chi.Router) {
-		// User routes
-		r.Get("/me", handlers.GetCurrentUser)
-		r.Put("/users/me", handlers.UpsertUser)
+		r.Use(middleware.MaxBodySize(config.MaxBodyBytes))
+		r.Use(middleware.RequireDatabase(db))
+		r.Use(authMiddleware)
+		r.Use(validateRequests)
 
 		// Conversation routes
-		r.Get("/conversations", handlers.GetConversations)
-		r.Post("/conversations", handlers.CreateConversation)
-		r.Delete("/conversations/{id}", handlers.DeleteConversation)
-		r.Get("/conversations/{id}/messages", handlers.GetMessages)
+		r.With(middleware.RequireScope(models.ScopeConversationsRead)).Get("/conversations", handlers.GetConversations)
+		r.With(middleware.RequireScope(models.ScopeConversationsRead)).Get("/conversations/search", handlers.SearchConversations)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Post("/conversations", handlers.CreateConversation)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Delete("/conversations/{id}", handlers.DeleteConversation)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Post("/conversations/{id}/members", handlers.AddMembers)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Delete("/conversations/{id}/members/{userId}", handlers.RemoveMember)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Put("/conversations/{id}/members/{userId}/role", handlers.SetMemberRole)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Put("/conversations/{id}/members/{userId}/mute", handlers.MuteMember)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Delete("/conversations/{id}/members/{userId}/mute", handlers.UnmuteMember)
+		r.With(middleware.RequireScope(models.ScopeConversationsWrite)).Patch("/conversations/{id}", handlers.UpdateConversation)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/conversations/{id}/pins", handlers.ListPins)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Put("/conversations/{id}/pins/{messageId}", handlers.PinMessage)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Delete("/conversations/{id}/pins/{messageId}", handlers.UnpinMessage)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/conversations/{id}/messages", handlers.GetMessages)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/conversations/{id}/events", handlers.StreamConversationEvents)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/conversations/{id}/receipts", handlers.GetReceipts)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/conversations/{id}/attachments/{attachmentId}", handlers.GetAttachment)
 
 		// Message routes
-		r.Post("/messages", handlers.SendMessage)
-		r.Post("/messages/{id}/read", handlers.MarkMessageAsRead)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Post("/messages", handlers.SendMessage)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Post("/messages/{id}/read", handlers.MarkMessageAsRead)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Post("/messages/{id}/retract", handlers.RetractMessage)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Post("/messages/{id}/vote", handlers.VotePoll)
+		r.With(middleware.RequireScope(models.ScopeMessagesWrite)).Post("/messages/{id}/location", handlers.UpdateLiveLocation)
+		r.With(middleware.RequireScope(models.ScopeMessagesRead)).Get("/messages/{id}/history", handlers.GetMessageHistory)
+
+		// Routes below are not available to API keys
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireUserToken)
+
+			// User routes
+			r.Get("/me", handlers.GetCurrentUser)
+			r.Get("/me/feed", handlers.GetFeed)
+			r.Get("/me/settings", handlers.GetMySettings)
+			r.Put("/me/settings", handlers.UpdateMySettings)
+			r.Get("/me/notification-settings", handlers.GetNotificationSettings)
+			r.Put("/me/notification-settings", handlers.UpdateNotificationSettings)
+			r.Get("/me/away-summary", handlers.GetAwaySummary)
+			r.Get("/me/badge", handlers.GetBadge)
+			r.Put("/me/status", handlers.UpdateStatus)
+			r.Put("/me/username", handlers.UpdateUsername)
+			r.Get("/me/username-history", handlers.GetUsernameHistory)
+			r.Get("/me/sessions", handlers.ListSessions)
+			r.Delete("/me/sessions/{id}", handlers.RevokeSession)
+			r.Get("/settings/effective", handlers.GetEffectiveSettings)
+
+			// Conversation settings routes
+			r.Get("/conversations/{id}/settings", handlers.GetConversationSettings)
+			r.Put("/conversations/{id}/settings", handlers.UpdateConversationSettings)
+			r.Put("/conversations/{id}/posting-policy", handlers.SetPostingPolicy)
+			r.Put("/conversations/{id}/policies", handlers.SetConversationPolicies)
+			r.Post("/conversations/{id}/lock", handlers.LockConversation)
+			r.Delete("/conversations/{id}/lock", handlers.UnlockConversation)
+			r.Put("/users/me", handlers.UpsertUser)
+			r.Get("/users", handlers.GetUsers)
+			r.Get("/usernames/{username}", handlers.CheckUsername)
+
+			// Retention routes
+			r.Get("/conversations/{id}/retention", handlers.GetRetention)
+			r.Put("/conversations/{id}/retention", handlers.UpdateRetention)
+			r.Post("/conversations/{id}/retention/approve", handlers.ApproveRetention)
+			r.Post("/conversations/{id}/retention/reject", handlers.RejectRetention)
+
+			// Bot allow-list routes
+			r.Get("/conversations/{id}/bots", handlers.ListConversationBots)
+			r.Put("/conversations/{id}/bots/{keyId}", handlers.SetConversationBot)
+			r.Delete("/conversations/{id}/bots/{keyId}", handlers.RemoveConversationBot)
+
+			// Telegram relay routes
+			r.Get("/conversations/{id}/telegram", handlers.GetTelegramLink)
+			r.Put("/conversations/{id}/telegram", handlers.LinkTelegram)
+			r.Delete("/conversations/{id}/telegram", handlers.UnlinkTelegram)
+			r.Get("/conversations/{id}/telegram/files/{fileId}", handlers.GetTelegramFile)
+
+			// Custom emoji routes
+			r.Get("/workspace/emoji", handlers.ListWorkspaceEmoji)
+			r.Post("/workspace/emoji", handlers.CreateWorkspaceEmoji)
+			r.Delete("/workspace/emoji/{name}", handlers.DeleteWorkspaceEmoji)
+			r.Get("/conversations/{id}/emoji", handlers.ListConversationEmoji)
+			r.Post("/conversations/{id}/emoji", handlers.CreateConversationEmoji)
+			r.Delete("/conversations/{id}/emoji/{name}", handlers.DeleteConversationEmoji)
+			r.Get("/emoji/{id}/image", handlers.GetEmojiImage)
+
+			// Message search across the caller's conversations
+			r.Get("/messages/search", handlers.SearchMessages)
+
+			// CAPTCHA challenges for senders flagged as possible spammers
+			r.Get("/challenge", handlers.GetChallenge)
+			r.Post("/challenge", handlers.SolveChallenge)
+
+			// GIF and sticker search, proxied so the provider's key stays here
+			r.Get("/gifs/search", handlers.SearchGIFs)
+
+			// Call history; the calls themselves are carried by clients
+			r.Get("/conversations/{id}/calls", handlers.ListCalls)
+			r.Post("/conversations/{id}/calls", handlers.StartCall)
+			r.Post("/calls/{id}/answer", handlers.AnswerCall)
+			r.Post("/calls/{id}/end", handlers.EndCall)
+
+			// Workspace administration
+			r.Get("/workspace", handlers.GetWorkspace)
+			r.Put("/workspace/default-conversations", handlers.SetDefaultConversations)
+			r.Put("/workspace/email-domains", handlers.SetEmailDomains)
+			r.Put("/workspace/attachment-policy", handlers.SetAttachmentPolicy)
+			r.Get("/workspace/attachment-reviews", handlers.ListAttachmentReviews)
+			r.Get("/workspace/attachment-reviews/{id}/file", handlers.GetAttachmentReviewFile)
+			r.Post("/workspace/attachment-reviews/{id}/resolve", handlers.ResolveAttachmentReview)
+			r.Post("/workspace/scim-token", handlers.CreateSCIMToken)
+			r.Delete("/workspace/scim-token", handlers.RevokeSCIMToken)
+			r.Put("/workspace/retention", handlers.SetWorkspaceRetention)
+			r.Get("/workspace/members", handlers.ListWorkspaceMembers)
+			r.Put("/workspace/members/{id}/roles", handlers.SetMemberRoles)
+			r.Post("/workspace/purge/dry-run", handlers.PurgeDryRun)
+			r.Post("/workspace/purge", handlers.ConfirmPurge)
+			r.Get("/workspace/purge/{id}", handlers.GetPurgeJob)
+			r.Post("/workspace/repair-orphans", handlers.RepairOrphans)
+			r.Get("/workspace/settings", handlers.GetWorkspaceSettings)
+			r.Put("/workspace/settings", handlers.UpdateWorkspaceSettings)
+			r.Post("/workspace/stream/reconfigure", handlers.ScheduleStreamReconfig)
+			r.Get("/workspace/stream/reconfigure/{id}", handlers.GetStreamReconfig)
+			r.Post("/api-keys", handlers.CreateAPIKey)
+			r.Get("/api-keys", handlers.ListAPIKeys)
+			r.Delete("/api-keys/{id}", handlers.RevokeAPIKey)
+			r.Get("/journal/status", handlers.GetJournalStatus)
+			r.Post("/conversations/{id}/watch-grants", handlers.CreateWatchGrant)
+			r.Get("/conversations/{id}/watch-grants", handlers.ListWatchGrants)
+			r.Delete("/watch-grants/{id}", handlers.RevokeWatchGrant)
+		})
 	})
 
 	// WebSocket endpoint
-	r.Get("/ws", handlers.HandleWebSocket)
+	r.With(middleware.RequireDatabase(db), authMiddleware, middleware.RequireScope(models.ScopeMessagesRead)).Get("/ws", handlers.HandleWebSocket)
 
 	// Start server
 	srv := &http.Server{
@@ -105,42 +634,114 @@ func main() {
 		Handler: r,
 	}
 
+	challengeSrv := configureTLS(config, srv)
+	if challengeSrv != nil {
+		go func() {
+			logger.Info("ACME challenge server starting", "addr", challengeSrv.Addr)
+			if err := challengeSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("ACME challenge server failed to start", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	go func() {
-		log.Printf("Server starting on port %s", config.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+		logger.Info("Server starting", "port", config.Port, "tls", srv.TLSConfig != nil)
+		if err := serve(config, srv); err != nil && err != http.ErrServerClosed {
+			fatal("Server failed to start", err)
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if config.GRPCAddr != "" {
+		grpcAPI := &grpcapi.Server{
+			UserService:         userService,
+			ConversationService: conversationService,
+			MessageService:      messageService,
+			WatchService:        watchService,
+			BotService:          botService,
+			WorkspaceService:    workspaceService,
+			WebSocketHub:        webSocketHub,
+			Database:            db,
+			Logger:              logger,
+			Verifier:            jwtVerifier,
+			APIKeys:             apiKeyService,
+			APIKeyLimiter:       apiKeyLimiter,
+			MaxMessageBytes:     int(config.MaxBodyBytes),
+		}
+		tlsConfig, err := grpcTLS(config, srv)
+		if err != nil {
+			fatal("Failed to load gRPC TLS certificate", err)
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcSrv = grpcAPI.GRPCServer(opts...)
+
+		lis, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			fatal("gRPC server failed to listen", err)
+		}
+		go func() {
+			logger.Info("gRPC server starting", "addr", config.GRPCAddr, "tls", tlsConfig != nil)
+			if err := grpcSrv.Serve(lis); err != nil {
+				fatal("gRPC server failed", err)
+			}
+		}()
+	}
+
+	var debugSrv *http.Server
+	if config.DebugAddr != "" {
+		debugSrv = newDebugServer(config.DebugAddr, config.DebugToken, webSocketHub)
+		go func() {
+			logger.Info("Debug server starting", "addr", config.DebugAddr)
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Debug server failed", logging.Err(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	logger.Info("Shutting down server")
+	stopWorkers()
+	webSocketHub.Shutdown()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if grpcSrv != nil {
+		// Chat streams were ended by the hub's shutdown; unary calls get to finish
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	if debugSrv != nil {
+		debugSrv.Close()
+	}
+	if challengeSrv != nil {
+		challengeSrv.Close()
+	}
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		fatal("Server forced to shutdown", err)
 	}
 
-	log.Println("Server exited")
+	logger.Info("Server exited")
 }
 
-type Config struct {
-	Port           string
-	MongoURI       string
-	DatabaseName   string
-	NATSUrl        string
-	AllowedOrigins string
+// fatal logs err and exits; like log.Fatal, deferred cleanup does not run
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
 }
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
\ No newline at end of file
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS prepares srv to terminate TLS itself, from certificate files or from
+// certificates obtained automatically through ACME (Let's Encrypt). HTTP/2 is negotiated
+// over TLS automatically; WebSocket upgrades use HTTP/1.1, so wss:// works unchanged.
+//
+// In ACME mode the returned server answers http-01 challenges on TLSHTTPAddr and
+// redirects everything else to https; it is nil otherwise.
+func configureTLS(config *Config, srv *http.Server) *http.Server {
+	switch {
+	case config.TLSCert != "":
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return nil
+
+	case len(config.TLSAutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(config.TLSAutocertCache),
+			Email:      config.TLSAutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		return &http.Server{
+			Addr:              config.TLSHTTPAddr,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return nil
+}
+
+// grpcTLS returns TLS settings for the gRPC listener matching those configureTLS gave srv, or
+// nil when srv serves plain HTTP
+func grpcTLS(config *Config, srv *http.Server) (*tls.Config, error) {
+	if srv.TLSConfig == nil {
+		return nil, nil
+	}
+	tlsConfig := srv.TLSConfig.Clone()
+	if config.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// serve starts srv with or without TLS, as configureTLS left it
+func serve(config *Config, srv *http.Server) error {
+	switch {
+	case config.TLSCert != "":
+		return srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+	case len(config.TLSAutocertDomains) > 0:
+		// Certificates come from srv.TLSConfig.GetCertificate
+		return srv.ListenAndServeTLS("", "")
+	default:
+		return srv.ListenAndServe()
+	}
+}
@@ -0,0 +1,143 @@
+package grpcapi
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadata carries the request ID on calls and responses, as X-Request-ID does over HTTP
+const requestIDMetadata = "x-request-id"
+
+// methodScopes is the API key scope each method needs, as on the matching REST route. Methods
+// not listed act on a person's own account and are refused to API keys.
+var methodScopes = map[string]string{
+	chatpb.ConversationService_ListConversations_FullMethodName:  models.ScopeConversationsRead,
+	chatpb.ConversationService_CreateConversation_FullMethodName: models.ScopeConversationsWrite,
+	chatpb.ConversationService_DeleteConversation_FullMethodName: models.ScopeConversationsWrite,
+	chatpb.ConversationService_ListMessages_FullMethodName:       models.ScopeMessagesRead,
+	chatpb.MessageService_SendMessage_FullMethodName:             models.ScopeMessagesWrite,
+	chatpb.MessageService_MarkMessageRead_FullMethodName:         models.ScopeMessagesWrite,
+	chatpb.MessageService_RetractMessage_FullMethodName:          models.ScopeMessagesWrite,
+	chatpb.ChatService_Chat_FullMethodName:                       models.ScopeMessagesRead,
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, id := withRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+
+	start := time.Now()
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	s.logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := withRequestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestIDMetadata, id))
+
+	start := time.Now()
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err == nil {
+		err = handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+	s.logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// withRequestID adopts the caller's request ID when it is well formed, or assigns one
+func withRequestID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := requestid.OrNew(firstValue(md, requestIDMetadata))
+	return requestid.NewContext(ctx, id), id
+}
+
+// authenticate checks the call's credentials and the method's scope, returning a context
+// carrying the principal as the REST middleware would
+func (s *Server) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if err := s.Database.Available(); err != nil {
+		return ctx, status.Error(codes.Unavailable, "Service temporarily unavailable; retry shortly")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if rawKey := firstValue(md, "x-api-key"); rawKey != "" {
+		key, err := s.APIKeys.AuthenticateAPIKey(ctx, rawKey)
+		if err != nil {
+			return ctx, status.Error(codes.Unauthenticated, "Invalid API key")
+		}
+		if !s.APIKeyLimiter.AllowPerMinute("apikey:"+key.ID, key.RateLimitPerMinute) {
+			return ctx, status.Error(codes.ResourceExhausted, "Rate limit exceeded")
+		}
+		ctx = middleware.WithAPIKey(ctx, key)
+	} else {
+		authorization := firstValue(md, "authorization")
+		tokenString := strings.TrimPrefix(authorization, "Bearer ")
+		if tokenString == authorization || tokenString == "" {
+			return ctx, status.Error(codes.Unauthenticated, "Missing or invalid authorization")
+		}
+		token, err := s.Verifier.Verify(tokenString)
+		if err != nil {
+			return ctx, status.Error(codes.Unauthenticated, "Invalid token")
+		}
+		ctx = middleware.WithUserToken(ctx, token)
+	}
+	if s.Database.Isolated() {
+		var err error
+		if ctx, err = middleware.WithTenant(ctx, s.WorkspaceService); err != nil {
+			return ctx, status.Error(codes.PermissionDenied, "Unknown workspace")
+		}
+	}
+	if active, err := middleware.CheckActive(ctx, s.UserService); err != nil {
+		return ctx, status.Error(codes.Internal, "Internal server error")
+	} else if !active {
+		return ctx, status.Error(codes.PermissionDenied, "Account deactivated")
+	}
+
+	scope, ok := methodScopes[fullMethod]
+	if !ok {
+		if _, isKey := middleware.GetAPIKeyIDFromContext(ctx); isKey {
+			return ctx, status.Error(codes.PermissionDenied, "Not available to API keys")
+		}
+	} else if !middleware.HasScope(ctx, scope) {
+		return ctx, status.Error(codes.PermissionDenied, "API key lacks required scope")
+	}
+	return ctx, nil
+}
+
+func (s *Server) logCall(ctx context.Context, fullMethod string, start time.Time, err error) {
+	s.Logger.InfoContext(ctx, "gRPC call",
+		"method", fullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// contextStream is a server stream whose handler sees the authenticated context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
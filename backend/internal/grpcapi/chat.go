@@ -0,0 +1,159 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"nhooyr.io/websocket"
+)
+
+// closeStatusCodes maps WebSocket application close codes to the status a Chat stream ends with
+var closeStatusCodes = map[websocket.StatusCode]codes.Code{
+	services.CloseAuthFailed.Status:     codes.Unauthenticated,
+	services.CloseSuperseded.Status:     codes.Aborted,
+	services.CloseRateLimited.Status:    codes.ResourceExhausted,
+	services.CloseServerDrain.Status:    codes.Unavailable,
+	services.CloseProtocolError.Status:  codes.InvalidArgument,
+	services.CloseSessionRevoked.Status: codes.Aborted,
+	services.CloseSlowConsumer.Status:   codes.ResourceExhausted,
+}
+
+var errStreamClosed = errors.New("stream closed")
+
+// Chat serves the WebSocket protocol over a stream. The hub treats the stream as a connection
+// that negotiated protobuf frames, so every frame type, limit and close code applies.
+func (s *chatServer) Chat(stream chatpb.ChatService_ChatServer) error {
+	ctx := stream.Context()
+	userID := callerID(ctx)
+	if err := s.WebSocketHub.CheckConnectionLimit(ctx, userID); err != nil {
+		return s.serviceError(ctx, err, "Failed to open connection")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	device := firstValue(md, "x-device")
+	if device == "" {
+		device = firstValue(md, "user-agent")
+	}
+	var peerAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		peerAddr = p.Addr.String()
+	}
+	apiKeyID, _ := middleware.GetAPIKeyIDFromContext(ctx)
+
+	conn := newStreamConn(stream)
+	s.WebSocketHub.ServeStream(ctx, conn, userID, apiKeyID, middleware.GetTokenExpiryFromContext(ctx), device, peerAddr)
+	return conn.finish()
+}
+
+// streamConn adapts a Chat stream to services.ClientConn. The hub writes frames already
+// encoded as protobuf and reads them the same way, so they are converted to and from
+// chatpb.Frame here.
+type streamConn struct {
+	stream   chatpb.ChatService_ChatServer
+	received chan []byte
+	recvErr  error // why received was closed
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	err       error // the status the stream ends with, set by Close
+}
+
+func newStreamConn(stream chatpb.ChatService_ChatServer) *streamConn {
+	c := &streamConn{
+		stream:   stream,
+		received: make(chan []byte),
+		closing:  make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+// receive reads client frames until the stream ends. Recv cannot be interrupted, so Read
+// waits on this goroutine instead and can give up when the connection is closed.
+func (c *streamConn) receive() {
+	defer close(c.received)
+	for {
+		frame, err := c.stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// The client closed its side, as a WebSocket client sends a close frame
+				c.Close(websocket.StatusNormalClosure, "")
+			}
+			c.recvErr = err
+			return
+		}
+		data, err := proto.Marshal(frame)
+		if err != nil {
+			c.recvErr = err
+			return
+		}
+		select {
+		case c.received <- data:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+func (c *streamConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case data, ok := <-c.received:
+		if !ok {
+			return 0, nil, c.recvErr
+		}
+		return websocket.MessageBinary, data, nil
+	case <-c.closing:
+		return 0, nil, errStreamClosed
+	}
+}
+
+func (c *streamConn) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	select {
+	case <-c.closing:
+		return errStreamClosed
+	default:
+	}
+
+	var frame chatpb.Frame
+	if err := proto.Unmarshal(p, &frame); err != nil {
+		return err
+	}
+	return c.stream.Send(&frame)
+}
+
+// Ping has nothing to do; keepalive pings cover the transport
+func (c *streamConn) Ping(ctx context.Context) error {
+	return c.stream.Context().Err()
+}
+
+// Close records the status the stream ends with. The first call decides it; the handler
+// returns it once the hub is done with the connection.
+func (c *streamConn) Close(code websocket.StatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		switch grpcCode, ok := closeStatusCodes[code]; {
+		case code == websocket.StatusNormalClosure:
+		case ok:
+			c.err = status.Error(grpcCode, reason)
+		default:
+			c.err = status.Error(codes.Internal, "Connection failed")
+		}
+		close(c.closing)
+	})
+	return nil
+}
+
+// finish closes the connection if the hub has not, and returns the status to end the stream with
+func (c *streamConn) finish() error {
+	c.Close(websocket.StatusNormalClosure, "")
+	return c.err
+}
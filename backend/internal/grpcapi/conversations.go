@@ -0,0 +1,137 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *conversationServer) ListConversations(ctx context.Context, req *chatpb.ListConversationsRequest) (*chatpb.ListConversationsResponse, error) {
+	snapshot, err := s.ConversationService.GetUserConversationsSnapshot(ctx, callerID(ctx))
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to get conversations")
+	}
+
+	readable, err := s.readableByBot(ctx, snapshot.Conversations)
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to get conversations")
+	}
+
+	resp := &chatpb.ListConversationsResponse{
+		Conversations: make([]*chatpb.Conversation, 0, len(snapshot.Conversations)),
+		GeneratedAt:   timestampProto(snapshot.GeneratedAt),
+		Cached:        snapshot.Cached,
+	}
+	for i := range snapshot.Conversations {
+		if readable == nil || readable[snapshot.Conversations[i].ID] {
+			resp.Conversations = append(resp.Conversations, conversationProto(&snapshot.Conversations[i]))
+		}
+	}
+	return resp, nil
+}
+
+func (s *conversationServer) CreateConversation(ctx context.Context, req *chatpb.CreateConversationRequest) (*chatpb.Conversation, error) {
+	createReq := models.CreateConversationRequest{
+		Kind:    req.GetKind(),
+		Title:   req.GetTitle(),
+		Members: req.GetMembers(),
+	}
+	if err := validateRequest(&createReq); err != nil {
+		return nil, err
+	}
+
+	conv, err := s.ConversationService.CreateConversation(ctx, &createReq, callerID(ctx))
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to create conversation")
+	}
+	return conversationProto(&models.ConversationWithParticipants{
+		ID:            conv.ID,
+		Kind:          conv.Kind,
+		Title:         conv.Title,
+		CreatedAt:     conv.CreatedAt,
+		LastMessageAt: conv.LastMessageAt,
+	}), nil
+}
+
+func (s *conversationServer) DeleteConversation(ctx context.Context, req *chatpb.DeleteConversationRequest) (*emptypb.Empty, error) {
+	if req.GetConversationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Conversation ID is required")
+	}
+	if err := s.authorizeBot(ctx, req.GetConversationId(), models.BotAccessPost); err != nil {
+		return nil, err
+	}
+
+	if err := s.ConversationService.DeleteConversation(ctx, req.GetConversationId(), callerID(ctx)); err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to delete conversation")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *conversationServer) ListMessages(ctx context.Context, req *chatpb.ListMessagesRequest) (*chatpb.ListMessagesResponse, error) {
+	conversationID := req.GetConversationId()
+	if conversationID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Conversation ID is required")
+	}
+	if err := s.authorizeBot(ctx, conversationID, models.BotAccessRead); err != nil {
+		return nil, err
+	}
+
+	// Participants, or compliance users with an active watch grant
+	if _, err := s.WatchService.AuthorizeRead(ctx, conversationID, callerID(ctx), "grpc"); err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to check participation")
+	}
+
+	limit := 50 // default
+	if req.GetLimit() > 0 && req.GetLimit() <= 100 {
+		limit = int(req.GetLimit())
+	}
+
+	page, err := s.MessageService.GetMessages(ctx, conversationID, req.GetBefore(), req.GetAfter(), limit)
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to get messages")
+	}
+
+	resp := &chatpb.ListMessagesResponse{
+		Messages:   make([]*chatpb.Message, len(page.Messages)),
+		HasMore:    page.HasMore,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+	for i := range page.Messages {
+		resp.Messages[i] = messageProto(&page.Messages[i])
+	}
+	return resp, nil
+}
+
+// readableByBot returns which of the conversations an API key may read, or nil for user tokens
+func (s *Server) readableByBot(ctx context.Context, conversations []models.ConversationWithParticipants) (map[string]bool, error) {
+	apiKeyID, ok := middleware.GetAPIKeyIDFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	ids := make([]string, len(conversations))
+	for i, conv := range conversations {
+		ids[i] = conv.ID
+	}
+	return s.BotService.ReadableConversations(ctx, apiKeyID, ids)
+}
+
+// authorizeBot applies the conversation's bot allow-list to API key calls. User-token calls
+// always pass.
+func (s *Server) authorizeBot(ctx context.Context, conversationID, access string) error {
+	apiKeyID, ok := middleware.GetAPIKeyIDFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if err := s.BotService.Authorize(ctx, conversationID, apiKeyID, access); err != nil {
+		return s.serviceError(ctx, err, "Failed to check bot access")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package grpcapi
+
+import (
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func userProto(user *models.User) *chatpb.User {
+	if user == nil {
+		return nil
+	}
+	return &chatpb.User{
+		Id:        user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		AvatarUrl: user.AvatarURL,
+		Roles:     user.Roles,
+		CreatedAt: timestampProto(user.CreatedAt),
+	}
+}
+
+func conversationProto(conv *models.ConversationWithParticipants) *chatpb.Conversation {
+	participants := make([]*chatpb.User, len(conv.Participants))
+	for i := range conv.Participants {
+		participants[i] = userProto(&conv.Participants[i])
+	}
+	return &chatpb.Conversation{
+		Id:            conv.ID,
+		Kind:          conv.Kind,
+		Title:         conv.Title,
+		CreatedAt:     timestampProto(conv.CreatedAt),
+		LastMessageAt: timestampProto(conv.LastMessageAt),
+		Participants:  participants,
+	}
+}
+
+func messageProto(msg *models.MessageWithSender) *chatpb.Message {
+	m := &chatpb.Message{
+		Id:             msg.ID,
+		Seq:            msg.Seq,
+		ConversationId: msg.ConversationID,
+		SenderId:       msg.SenderID,
+		ClientMsgId:    msg.ClientMsgID,
+		Body:           msg.Body,
+		CreatedAt:      timestampProto(msg.CreatedAt),
+		Sender:         userProto(msg.Sender),
+	}
+	if msg.RetractableUntil != nil {
+		m.RetractableUntil = timestampProto(*msg.RetractableUntil)
+	}
+	return m
+}
+
+// timestampProto leaves zero times unset, as omitempty would in JSON
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,54 @@
+package grpcapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// statusCodes maps the HTTP statuses of service error kinds to gRPC codes
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:         codes.InvalidArgument,
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusNotFound:           codes.NotFound,
+	http.StatusConflict:           codes.Aborted,
+	http.StatusTooManyRequests:    codes.ResourceExhausted,
+	http.StatusPaymentRequired:    codes.ResourceExhausted,
+	http.StatusLocked:             codes.FailedPrecondition,
+	http.StatusServiceUnavailable: codes.Unavailable,
+	http.StatusBadGateway:         codes.Unavailable,
+}
+
+// serviceError is writeServiceError for gRPC: the status comes from err's kind, and internal
+// errors get fallback as their message while the details are logged. A known wait before
+// retrying is attached as RetryInfo.
+func (s *Server) serviceError(ctx context.Context, err error, fallback string) error {
+	code, ok := statusCodes[services.HTTPStatus(err)]
+	if !ok {
+		s.Logger.ErrorContext(ctx, fallback, logging.Err(err))
+		code = codes.Internal
+	}
+	st := status.New(code, services.PublicMessage(err, fallback))
+	if wait := services.RetryAfter(err); wait > 0 {
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// validateRequest checks a request model against its validate tags, as decodeJSON does
+func validateRequest(v interface{}) error {
+	if err := validate.Struct(v); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *messageServer) SendMessage(ctx context.Context, req *chatpb.SendMessageRequest) (*chatpb.Message, error) {
+	sendReq := models.SendMessageRequest{
+		ConversationID: req.GetConversationId(),
+		ClientMsgID:    req.GetClientMsgId(),
+		Body:           req.GetBody(),
+	}
+	if err := validateRequest(&sendReq); err != nil {
+		return nil, err
+	}
+	if err := s.authorizeBot(ctx, sendReq.ConversationID, models.BotAccessPost); err != nil {
+		return nil, err
+	}
+	if err := s.requireParticipant(ctx, sendReq.ConversationID); err != nil {
+		return nil, err
+	}
+
+	message, err := s.MessageService.SendMessage(ctx, &sendReq, callerID(ctx))
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to send message")
+	}
+
+	// Update conversation last message timestamp
+	go s.ConversationService.UpdateLastMessageAt(context.WithoutCancel(ctx), sendReq.ConversationID)
+
+	return messageProto(message), nil
+}
+
+func (s *messageServer) MarkMessageRead(ctx context.Context, req *chatpb.MarkMessageReadRequest) (*emptypb.Empty, error) {
+	if req.GetConversationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Conversation ID is required")
+	}
+	if err := s.authorizeBot(ctx, req.GetConversationId(), models.BotAccessRead); err != nil {
+		return nil, err
+	}
+	if err := s.requireParticipant(ctx, req.GetConversationId()); err != nil {
+		return nil, err
+	}
+
+	if err := s.MessageService.MarkMessageAsRead(ctx, req.GetConversationId(), callerID(ctx), "", req.GetMessageId()); err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to mark message as read")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *messageServer) RetractMessage(ctx context.Context, req *chatpb.RetractMessageRequest) (*emptypb.Empty, error) {
+	if req.GetConversationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Conversation ID is required")
+	}
+	if err := s.authorizeBot(ctx, req.GetConversationId(), models.BotAccessPost); err != nil {
+		return nil, err
+	}
+
+	if err := s.MessageService.RetractMessage(ctx, req.GetConversationId(), req.GetMessageId(), callerID(ctx)); err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to retract message")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) requireParticipant(ctx context.Context, conversationID string) error {
+	isParticipant, err := s.ConversationService.IsUserParticipant(ctx, conversationID, callerID(ctx))
+	if err != nil {
+		return s.serviceError(ctx, err, "Failed to check participation")
+	}
+	if !isParticipant {
+		return status.Error(codes.PermissionDenied, "Access denied")
+	}
+	return nil
+}
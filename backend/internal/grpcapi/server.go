@@ -0,0 +1,75 @@
+// Package grpcapi serves the gRPC API described by proto/chat.proto on its own port. It is a
+// second front end over the same services as the REST handlers and the WebSocket hub, with
+// the same authentication, API key scopes and bot allow-lists.
+package grpcapi
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+type Server struct {
+	UserService         *services.UserService
+	ConversationService *services.ConversationService
+	MessageService      *services.MessageService
+	WatchService        *services.WatchService
+	BotService          *services.BotService
+	WorkspaceService    *services.WorkspaceService
+	WebSocketHub        *services.WebSocketHub
+	Database            *database.MongoDB
+	Logger              *slog.Logger
+
+	// Credentials are checked as by the REST API's JWT and API key middleware
+	Verifier      *middleware.JWTVerifier
+	APIKeys       middleware.APIKeyAuthenticator
+	APIKeyLimiter *middleware.RateLimiter
+
+	// Larger request messages are rejected, as REST bodies over MAX_BODY_BYTES are
+	MaxMessageBytes int
+}
+
+type userServer struct {
+	chatpb.UnimplementedUserServiceServer
+	*Server
+}
+
+type conversationServer struct {
+	chatpb.UnimplementedConversationServiceServer
+	*Server
+}
+
+type messageServer struct {
+	chatpb.UnimplementedMessageServiceServer
+	*Server
+}
+
+type chatServer struct {
+	chatpb.UnimplementedChatServiceServer
+	*Server
+}
+
+// GRPCServer returns a gRPC server with every service registered behind authentication
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	g := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+		grpc.MaxRecvMsgSize(s.MaxMessageBytes),
+		// Finds dead peers on idle Chat streams, as WebSocket pings do
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+	}, opts...)...)
+	chatpb.RegisterUserServiceServer(g, &userServer{Server: s})
+	chatpb.RegisterConversationServiceServer(g, &conversationServer{Server: s})
+	chatpb.RegisterMessageServiceServer(g, &messageServer{Server: s})
+	chatpb.RegisterChatServiceServer(g, &chatServer{Server: s})
+	return g
+}
@@ -0,0 +1,43 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"google.golang.org/grpc/metadata"
+)
+
+func (s *userServer) GetCurrentUser(ctx context.Context, req *chatpb.GetCurrentUserRequest) (*chatpb.User, error) {
+	user, err := s.UserService.GetUserByID(ctx, callerID(ctx))
+	if err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to get user")
+	}
+	return userProto(user), nil
+}
+
+func (s *userServer) UpsertUser(ctx context.Context, req *chatpb.UpsertUserRequest) (*chatpb.User, error) {
+	// The token subject is authoritative
+	user := models.User{
+		ID:        callerID(ctx),
+		Email:     req.GetEmail(),
+		Name:      req.GetName(),
+		AvatarURL: req.GetAvatarUrl(),
+	}
+	if err := validateRequest(&user); err != nil {
+		return nil, err
+	}
+	claim := middleware.GetTokenClaimFromContext(ctx, s.WorkspaceService.Claim())
+	md, _ := metadata.FromIncomingContext(ctx)
+	if err := s.WorkspaceService.UpsertUser(ctx, &user, claim, firstValue(md, "x-github-token")); err != nil {
+		return nil, s.serviceError(ctx, err, "Failed to upsert user")
+	}
+	return userProto(&user), nil
+}
+
+// callerID is the authenticated user; the interceptor has always set it
+func callerID(ctx context.Context) string {
+	userID, _ := middleware.GetUserIDFromContext(ctx)
+	return userID
+}
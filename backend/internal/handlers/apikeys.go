@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	key, err := h.APIKeyService.CreateAPIKey(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to process API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (h *Handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := h.APIKeyService.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to process API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.APIKeyService.RevokeAPIKey(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		h.writeServiceError(w, r, err, "Failed to process API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
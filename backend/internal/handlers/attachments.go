@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// UploadAttachment stores the request body as a file in the conversation, named by ?name=. The
+// Content-Type header is the client's claim of what it is; the bytes decide.
+func (h *Handlers) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Error(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		problem.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.AttachmentService.Upload(r.Context(), conversationID, userID,
+		r.URL.Query().Get("name"), r.Header.Get("Content-Type"), data)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to upload attachment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// GetAttachment serves an attachment's bytes. Browsers show images, audio and video in place
+// and download anything else, sandboxed either way. IDs are never reused, so it may be cached
+// for good.
+func (h *Handlers) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessRead) {
+		return
+	}
+
+	attachment, err := h.AttachmentService.Get(r.Context(), conversationID, chi.URLParam(r, "attachmentId"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get attachment")
+		return
+	}
+	serveAttachment(w, attachment)
+}
+
+// ListAttachmentReviews lists the workspace's open attachment reviews, or with ?status=resolved
+// the resolved ones
+func (h *Handlers) ListAttachmentReviews(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var resolved bool
+	switch r.URL.Query().Get("status") {
+	case "", "open":
+	case "resolved":
+		resolved = true
+	default:
+		problem.Error(w, r, "status must be open or resolved", http.StatusBadRequest)
+		return
+	}
+	limit := 50 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 200 {
+			limit = parsedLimit
+		}
+	}
+
+	page, err := h.AttachmentService.ListReviews(r.Context(), userID, resolved, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list attachment reviews")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetAttachmentReviewFile serves the image under review, blocked or not, as GetAttachment does
+func (h *Handlers) GetAttachmentReviewFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	attachment, err := h.AttachmentService.ReviewFile(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get attachment")
+		return
+	}
+	serveAttachment(w, attachment)
+}
+
+func (h *Handlers) ResolveAttachmentReview(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ResolveAttachmentReviewRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	review, err := h.AttachmentService.ResolveReview(r.Context(), userID, chi.URLParam(r, "id"), req.Resolution)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to resolve attachment review")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// serveAttachment writes an attachment's bytes, sandboxed
+func serveAttachment(w http.ResponseWriter, attachment *models.Attachment) {
+	disposition := "attachment"
+	family, _, _ := strings.Cut(attachment.ContentType, "/")
+	switch family {
+	case "image", "audio", "video":
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(attachment.Data)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(attachment.Data)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+// ReceiveBillingEvent is the billing provider's webhook for subscription changes. It is not
+// authenticated; the provider signs the body with BILLING_WEBHOOK_SECRET instead.
+func (h *Handlers) ReceiveBillingEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Error(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		problem.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.BillingService.VerifySignature(body, r.Header.Get("X-Billing-Signature")) {
+		problem.Error(w, r, "Invalid billing signature", http.StatusForbidden)
+		return
+	}
+
+	var event models.BillingEvent
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if !decodeJSON(w, r, &event) {
+		return
+	}
+
+	if err := h.BillingService.ApplyEvent(r.Context(), &event); err != nil {
+		h.writeServiceError(w, r, err, "Failed to apply billing event")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) ListConversationBots(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	bots, err := h.BotService.ListBots(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list bots")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
+
+func (h *Handlers) SetConversationBot(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SetConversationBotRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	bot, err := h.BotService.SetBot(r.Context(), chi.URLParam(r, "id"), userID, chi.URLParam(r, "keyId"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update bot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bot)
+}
+
+func (h *Handlers) RemoveConversationBot(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.BotService.RemoveBot(r.Context(), chi.URLParam(r, "id"), userID, chi.URLParam(r, "keyId"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to remove bot")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeBot applies the conversation's bot allow-list to API key requests, writing the
+// error response itself. User-token requests always pass.
+func (h *Handlers) authorizeBot(w http.ResponseWriter, r *http.Request, conversationID, access string) bool {
+	apiKeyID, ok := middleware.GetAPIKeyIDFromContext(r.Context())
+	if !ok {
+		return true
+	}
+
+	if err := h.BotService.Authorize(r.Context(), conversationID, apiKeyID, access); err != nil {
+		h.writeServiceError(w, r, err, "Failed to check bot access")
+		return false
+	}
+	return true
+}
+
+// filterBotConversations drops conversations an API key may not read from a conversation list
+func (h *Handlers) filterBotConversations(r *http.Request, conversations []models.ConversationWithParticipants) ([]models.ConversationWithParticipants, error) {
+	apiKeyID, ok := middleware.GetAPIKeyIDFromContext(r.Context())
+	if !ok || len(conversations) == 0 {
+		return conversations, nil
+	}
+
+	ids := make([]string, len(conversations))
+	for i, conv := range conversations {
+		ids[i] = conv.ID
+	}
+	readable, err := h.BotService.ReadableConversations(r.Context(), apiKeyID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]models.ConversationWithParticipants, 0, len(readable))
+	for _, conv := range conversations {
+		if readable[conv.ID] {
+			filtered = append(filtered, conv)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// StartCall records a call the caller is placing in a conversation
+func (h *Handlers) StartCall(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.StartCallRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	call, err := h.CallService.StartCall(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to start call")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(call)
+}
+
+func (h *Handlers) AnswerCall(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	call, err := h.CallService.AnswerCall(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to answer call")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(call)
+}
+
+func (h *Handlers) EndCall(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.EndCallRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	call, err := h.CallService.EndCall(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to end call")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(call)
+}
+
+// ListCalls returns a conversation's call history, newest first
+func (h *Handlers) ListCalls(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	calls, err := h.CallService.ListCalls(r.Context(), chi.URLParam(r, "id"), userID, r.URL.Query().Get("before"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list calls")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calls)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
+)
+
+// GetChallenge tells the caller whether they must solve a CAPTCHA before sending, and gives
+// the provider and site key to show it with
+func (h *Handlers) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.ChallengeService.Status(r.Context(), userID, clientip.FromContext(r.Context()))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get challenge")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SolveChallenge verifies the token of a solved CAPTCHA, lifting the caller's challenge
+func (h *Handlers) SolveChallenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SolveChallengeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.ChallengeService.Solve(r.Context(), userID, clientip.FromContext(r.Context()), req.Token); err != nil {
+		h.writeServiceError(w, r, err, "Failed to verify challenge")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
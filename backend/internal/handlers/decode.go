@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
+)
+
+// decodeJSON reads a JSON request body into v and checks it against v's validate tags. On
+// failure it writes the response itself (413 past the body limit, 400 otherwise) and returns
+// false. Bodies that are not valid UTF-8 are rejected rather than decoded, which would
+// silently replace the bad bytes.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Error(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		problem.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	if !utf8.Valid(body) {
+		problem.Error(w, r, "Request body is not valid UTF-8", http.StatusBadRequest)
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		problem.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	if err := validate.Struct(v); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) ListWorkspaceEmoji(w http.ResponseWriter, r *http.Request) {
+	h.listEmoji(w, r, "")
+}
+
+func (h *Handlers) ListConversationEmoji(w http.ResponseWriter, r *http.Request) {
+	h.listEmoji(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *Handlers) CreateWorkspaceEmoji(w http.ResponseWriter, r *http.Request) {
+	h.createEmoji(w, r, "")
+}
+
+func (h *Handlers) CreateConversationEmoji(w http.ResponseWriter, r *http.Request) {
+	h.createEmoji(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *Handlers) DeleteWorkspaceEmoji(w http.ResponseWriter, r *http.Request) {
+	h.deleteEmoji(w, r, "")
+}
+
+func (h *Handlers) DeleteConversationEmoji(w http.ResponseWriter, r *http.Request) {
+	h.deleteEmoji(w, r, chi.URLParam(r, "id"))
+}
+
+func (h *Handlers) listEmoji(w http.ResponseWriter, r *http.Request, conversationID string) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	emoji, err := h.EmojiService.ListEmoji(r.Context(), conversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list emoji")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(emoji)
+}
+
+func (h *Handlers) createEmoji(w http.ResponseWriter, r *http.Request, conversationID string) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateEmojiRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	emoji, err := h.EmojiService.CreateEmoji(r.Context(), conversationID, userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to create emoji")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(emoji)
+}
+
+func (h *Handlers) deleteEmoji(w http.ResponseWriter, r *http.Request, conversationID string) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.EmojiService.DeleteEmoji(r.Context(), conversationID, userID, chi.URLParam(r, "name"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to delete emoji")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetEmojiImage serves a custom emoji's image. IDs are never reused, so it may be cached for good.
+func (h *Handlers) GetEmojiImage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	emoji, err := h.EmojiService.GetEmojiImage(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get emoji")
+		return
+	}
+
+	w.Header().Set("Content-Type", emoji.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(emoji.Image)))
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(emoji.Image)
+}
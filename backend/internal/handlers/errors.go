@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+)
+
+// writeServiceError responds with the status and code mapped from err's kind. Internal errors
+// get fallback so driver and network details never reach the client; the details are logged
+// under the request ID the response carries.
+func (h *Handlers) writeServiceError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	status := services.HTTPStatus(err)
+	if status == http.StatusInternalServerError {
+		h.Logger.ErrorContext(r.Context(), fallback, "path", r.URL.Path, logging.Err(err))
+	}
+	problem.WriteRetryAfter(w, r, status, services.ErrorCode(err, "INTERNAL"), services.PublicMessage(err, fallback), services.RetryAfter(err))
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/go-chi/chi/v5"
+)
+
+// eventKeepalive is how often an idle event stream sends a comment, so proxies keep it open
+const eventKeepalive = 30 * time.Second
+
+// StreamConversationEvents serves a conversation's live frames as server-sent events, for
+// clients that cannot hold a WebSocket. Each event is named after the frame type and carries
+// the frame as JSON; message.new events have the message ID as their ID, so a reconnecting
+// EventSource's Last-Event-ID replays what it missed. A stream the server ends for a reason
+// the client should act on, such as a revoked session, ends with a close event carrying the
+// WebSocket close code. Sending still goes through REST.
+func (h *Handlers) StreamConversationEvents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessRead) {
+		return
+	}
+	grant, err := h.WatchService.AuthorizeRead(r.Context(), conversationID, userID, "sse")
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
+		return
+	}
+
+	var lastMessageID int64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastMessageID = id
+	}
+
+	// Open before replaying so nothing falls between the two; clients dedupe on message ID
+	device, ip := services.ConnectionOrigin(r)
+	stream := h.WebSocketHub.OpenEventStream(r.Context(), conversationID, userID, grant != nil, device, ip)
+	defer stream.Close()
+
+	var replay []*models.WSFrame
+	if lastMessageID != 0 {
+		frames, _, err := h.WebSocketHub.ReplayEvents(r.Context(), conversationID, lastMessageID)
+		if err != nil {
+			h.writeServiceError(w, r, err, "Failed to replay messages")
+			return
+		}
+		replay = frames
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	for _, frame := range replay {
+		if !writeEvent(w, frame) {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	// The stream ends with the token or watch grant, as a WebSocket would be closed
+	expiresAt := middleware.GetTokenExpiryFromContext(r.Context())
+	if grant != nil && (expiresAt.IsZero() || grant.ExpiresAt.Before(expiresAt)) {
+		expiresAt = grant.ExpiresAt
+	}
+	var expired <-chan time.Time
+	if !expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case frame, ok := <-stream.Frames():
+			if !ok {
+				// Fell behind, and the client reconnects and replays from its last event, or
+				// was closed by the server
+				if code := stream.CloseCode(); code != nil {
+					writeEvent(w, &models.WSFrame{Type: "close", Data: models.WSCloseData{Code: int(code.Status), Reason: code.Reason}})
+					rc.Flush()
+				}
+				return
+			}
+			if !writeEvent(w, frame) {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			h.Logger.DebugContext(r.Context(), "Event stream ended", logging.ConversationID, conversationID, logging.Err(err))
+			return
+		}
+	}
+}
+
+// writeEvent writes one frame as a server-sent event
+func writeEvent(w http.ResponseWriter, frame *models.WSFrame) bool {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return true
+	}
+	switch message := frame.Data.(type) {
+	case models.WSMessageNewData:
+		fmt.Fprintf(w, "id: %d\n", message.ID)
+	case *models.WSMessageNewData:
+		fmt.Fprintf(w, "id: %d\n", message.ID)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", frame.Type, data)
+	return err == nil
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+// SearchGIFs proxies a GIF or sticker search to the configured provider
+func (h *Handlers) SearchGIFs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0 // the service's default
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			problem.Error(w, r, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsedLimit
+	}
+
+	result, err := h.GIFService.Search(r.Context(), query.Get("kind"), query.Get("q"), query.Get("cursor"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to search GIFs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
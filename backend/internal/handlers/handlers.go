@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
 	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
 	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -15,21 +20,47 @@ type Handlers struct {
 	UserService         *services.UserService
 	ConversationService *services.ConversationService
 	MessageService      *services.MessageService
+	RetentionService    *services.RetentionService
+	PurgeService        *services.PurgeService
+	ImportService       *services.ImportService
+	APIKeyService       *services.APIKeyService
+	JournalService      *services.JournalService
+	WatchService        *services.WatchService
+	StreamConfigService *services.StreamConfigService
+	BotService          *services.BotService
+	EmojiService        *services.EmojiService
+	GIFService          *services.GIFService
+	CallService         *services.CallService
+	LockService         *services.LockService
+	MuteService         *services.MuteService
+	FeedService         *services.FeedService
+	SettingsService     *services.SettingsService
+	NotificationService *services.NotificationService
+	BadgeService        *services.BadgeService
+	SMSService          *services.SMSService
+	TelegramService     *services.TelegramService
+	WorkspaceService    *services.WorkspaceService
+	UsageService        *services.UsageService
+	BillingService      *services.BillingService
+	SCIMService         *services.SCIMService
+	LDAPSyncService     *services.LDAPSyncService
+	IPBanService        *services.IPBanService
+	ChallengeService    *services.ChallengeService
+	AttachmentService   *services.AttachmentService
 	WebSocketHub        *services.WebSocketHub
+	Logger              *slog.Logger
 }
 
 func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
-	// TODO: Integrate with NextAuth.js session
-	// For now, expect userID as query parameter for testing
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	user, err := h.UserService.GetUserByID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		h.writeServiceError(w, r, err, "Failed to get user")
 		return
 	}
 
@@ -37,26 +68,181 @@ func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func (h *Handlers) UpsertUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+// UpdateStatus sets the caller's status and announces it in their conversations
+func (h *Handlers) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateStatusRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := h.WebSocketHub.SetStatus(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update status")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// maxBatchUsers caps the IDs one GetUsers call may ask for
+const maxBatchUsers = 100
+
+// GetUsers looks up several users at once, e.g. a group's participants; unknown IDs and users
+// in other workspaces are left out
+func (h *Handlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userIDs []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", "ids is required")
+		return
+	}
+	if len(userIDs) > maxBatchUsers {
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", "ids: at most "+strconv.Itoa(maxBatchUsers)+" users per request")
+		return
+	}
+
+	caller, err := h.UserService.GetUserProfile(r.Context(), callerID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get users")
+		return
+	}
+	byID, err := h.UserService.GetUsersByIDs(r.Context(), userIDs)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get users")
+		return
+	}
+
+	response := models.UsersResponse{Users: make([]models.User, 0, len(byID))}
+	for _, id := range userIDs {
+		if user, ok := byID[id]; ok && user.WorkspaceID == caller.WorkspaceID {
+			response.Users = append(response.Users, *user)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CheckUsername reports whether the caller could take a username
+func (h *Handlers) CheckUsername(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// User ID should be in the request body
-	if user.ID == "" {
-		http.Error(w, "User ID required in request body", http.StatusBadRequest)
+	availability, err := h.UserService.CheckUsername(r.Context(), userID, chi.URLParam(r, "username"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to check username")
 		return
 	}
 
-	// Set created time if not provided
-	if user.CreatedAt.IsZero() {
-		user.CreatedAt = time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(availability)
+}
+
+// UpdateUsername sets the caller's username
+func (h *Handlers) UpdateUsername(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	if err := h.UserService.UpsertUser(r.Context(), &user); err != nil {
-		http.Error(w, "Failed to upsert user", http.StatusInternalServerError)
+	var req models.UpdateUsernameRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := h.UserService.SetUsername(r.Context(), userID, req.Username)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update username")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetUsernameHistory lists the caller's past username changes
+func (h *Handlers) GetUsernameHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	changes, err := h.UserService.UsernameHistory(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get username history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// GetFeed returns a page of the caller's activity feed
+func (h *Handlers) GetFeed(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 50 {
+			limit = parsedLimit
+		}
+	}
+
+	feed, err := h.FeedService.GetFeed(r.Context(), userID, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get feed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+func (h *Handlers) UpsertUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if !decodeJSON(w, r, &user) {
+		return
+	}
+
+	// The token subject is authoritative; ignore any ID in the body
+	user.ID = userID
+	claim := middleware.GetTokenClaimFromContext(r.Context(), h.WorkspaceService.Claim())
+	if err := h.WorkspaceService.UpsertUser(r.Context(), &user, claim, r.Header.Get("X-GitHub-Token")); err != nil {
+		h.writeServiceError(w, r, err, "Failed to upsert user")
 		return
 	}
 
@@ -65,49 +251,81 @@ func (h *Handlers) UpsertUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) GetConversations(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	snapshot, err := h.ConversationService.GetUserConversationsSnapshot(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get conversations")
 		return
 	}
 
-	conversations, err := h.ConversationService.GetUserConversations(r.Context(), userID)
+	conversations, err := h.filterBotConversations(r, snapshot.Conversations)
 	if err != nil {
-		http.Error(w, "Failed to get conversations", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to get conversations")
 		return
 	}
 
+	// Freshness marker: clients can tell how old a cached snapshot is
+	if snapshot.Cached {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.Header().Set("X-Snapshot-Generated-At", snapshot.GeneratedAt.UTC().Format(time.RFC3339Nano))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(conversations)
 }
 
-func (h *Handlers) CreateConversation(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+// SearchConversations finds the caller's conversations by title or participant name
+func (h *Handlers) SearchConversations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var req models.CreateConversationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 50 {
+			limit = parsedLimit
+		}
+	}
+
+	found, err := h.ConversationService.SearchConversations(r.Context(), userID, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to search conversations")
+		return
+	}
+
+	conversations, err := h.filterBotConversations(r, found)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to search conversations")
 		return
 	}
 
-	// Validate request
-	if req.Kind != "dm" && req.Kind != "group" {
-		http.Error(w, "Invalid conversation kind", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+func (h *Handlers) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if len(req.Members) == 0 {
-		http.Error(w, "At least one member is required", http.StatusBadRequest)
+	var req models.CreateConversationRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	conversation, err := h.ConversationService.CreateConversation(r.Context(), &req, userID)
 	if err != nil {
-		http.Error(w, "Failed to create conversation", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to create conversation")
 		return
 	}
 
@@ -117,33 +335,25 @@ func (h *Handlers) CreateConversation(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) DeleteConversation(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	conversationID := chi.URLParam(r, "id")
 	if conversationID == "" {
-		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		problem.Error(w, r, "Conversation ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
 		return
 	}
 
 	err := h.ConversationService.DeleteConversation(r.Context(), conversationID, userID)
 	if err != nil {
-		if err.Error() == "user is not a participant in this conversation" {
-			http.Error(w, "Access denied", http.StatusForbidden)
-			return
-		}
-		if err.Error() == "only admins can delete conversations" {
-			http.Error(w, "Only admins can delete conversations", http.StatusForbidden)
-			return
-		}
-		if err.Error() == "conversation not found" {
-			http.Error(w, "Conversation not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to delete conversation", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to delete conversation")
 		return
 	}
 
@@ -151,31 +361,31 @@ func (h *Handlers) DeleteConversation(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	conversationID := chi.URLParam(r, "id")
 	if conversationID == "" {
-		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
+		problem.Error(w, r, "Conversation ID is required", http.StatusBadRequest)
 		return
 	}
 
-	// Check if user is participant
-	isParticipant, err := h.ConversationService.IsUserParticipant(r.Context(), conversationID, userID)
-	if err != nil {
-		http.Error(w, "Failed to check participation", http.StatusInternalServerError)
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessRead) {
 		return
 	}
-	if !isParticipant {
-		http.Error(w, "Access denied", http.StatusForbidden)
+
+	// Participants, or compliance users with an active watch grant
+	if _, err := h.WatchService.AuthorizeRead(r.Context(), conversationID, userID, "rest"); err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
 		return
 	}
 
 	// Parse query parameters
 	before := r.URL.Query().Get("before")
+	after := r.URL.Query().Get("after")
 	limitStr := r.URL.Query().Get("limit")
 
 	limit := 50 // default
@@ -185,9 +395,9 @@ func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response, err := h.MessageService.GetMessages(r.Context(), conversationID, before, limit)
+	response, err := h.MessageService.GetMessages(r.Context(), conversationID, before, after, limit)
 	if err != nil {
-		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to get messages")
 		return
 	}
 
@@ -195,44 +405,69 @@ func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func (h *Handlers) SendMessage(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+// SearchMessages finds messages in the caller's conversations; see services/search.go for the
+// query syntax
+func (h *Handlers) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var req models.SendMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 50 {
+			limit = parsedLimit
+		}
+	}
+
+	response, err := h.MessageService.SearchMessages(r.Context(), userID, r.URL.Query().Get("q"), r.URL.Query().Get("before"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to search messages")
 		return
 	}
 
-	// Validate request
-	if req.ConversationID == "" || req.ClientMsgID == "" || req.Body == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handlers) SendMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SendMessageRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	if len(req.Body) > 4000 {
-		http.Error(w, "Message body too long", http.StatusBadRequest)
+	if !h.authorizeBot(w, r, req.ConversationID, models.BotAccessPost) {
 		return
 	}
 
 	// Check if user is participant
 	isParticipant, err := h.ConversationService.IsUserParticipant(r.Context(), req.ConversationID, userID)
 	if err != nil {
-		http.Error(w, "Failed to check participation", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to check participation")
 		return
 	}
 	if !isParticipant {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		problem.Error(w, r, "Access denied", http.StatusForbidden)
 		return
 	}
 
+	if _, isKey := middleware.GetAPIKeyIDFromContext(r.Context()); !isKey {
+		if err := h.ChallengeService.Screen(r.Context(), userID, clientip.FromContext(r.Context()), req.Body); err != nil {
+			h.writeServiceError(w, r, err, "Failed to send message")
+			return
+		}
+	}
+
 	message, err := h.MessageService.SendMessage(r.Context(), &req, userID)
 	if err != nil {
-		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to send message")
 		return
 	}
 
@@ -245,58 +480,244 @@ func (h *Handlers) SendMessage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "User ID required as query parameter", http.StatusBadRequest)
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	messageIDStr := chi.URLParam(r, "id")
 	if messageIDStr == "" {
-		http.Error(w, "Message ID is required", http.StatusBadRequest)
+		problem.Error(w, r, "Message ID is required", http.StatusBadRequest)
 		return
 	}
 
 	messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
 		return
 	}
 
 	var req models.MarkMessageAsReadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !h.authorizeBot(w, r, req.ConversationID, models.BotAccessRead) {
 		return
 	}
 
 	// Check if user is participant
 	isParticipant, err := h.ConversationService.IsUserParticipant(r.Context(), req.ConversationID, userID)
 	if err != nil {
-		http.Error(w, "Failed to check participation", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to check participation")
 		return
 	}
 	if !isParticipant {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		problem.Error(w, r, "Access denied", http.StatusForbidden)
 		return
 	}
 
-	err = h.MessageService.MarkMessageAsRead(r.Context(), req.ConversationID, userID, messageID)
+	err = h.MessageService.MarkMessageAsRead(r.Context(), req.ConversationID, userID, "", messageID)
 	if err != nil {
-		http.Error(w, "Failed to mark message as read", http.StatusInternalServerError)
+		h.writeServiceError(w, r, err, "Failed to mark message as read")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// RetractMessage withdraws one of the caller's messages within the undo-send window
+func (h *Handlers) RetractMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.RetractMessageRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !h.authorizeBot(w, r, req.ConversationID, models.BotAccessPost) {
+		return
+	}
+
+	if err := h.MessageService.RetractMessage(r.Context(), req.ConversationID, messageID, userID); err != nil {
+		h.writeServiceError(w, r, err, "Failed to retract message")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VotePoll replaces the caller's vote in a poll message and returns the new tally
+func (h *Handlers) VotePoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.VoteRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !h.authorizeBot(w, r, req.ConversationID, models.BotAccessPost) {
+		return
+	}
+
+	isParticipant, err := h.ConversationService.IsUserParticipant(r.Context(), req.ConversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
+		return
+	}
+	if !isParticipant {
+		problem.Error(w, r, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	poll, err := h.MessageService.Vote(r.Context(), messageID, userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to vote")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// UpdateLiveLocation moves the caller's live location in one of their location messages, for
+// clients without a WebSocket
+func (h *Handlers) UpdateLiveLocation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.LocationUpdateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !h.authorizeBot(w, r, req.ConversationID, models.BotAccessPost) {
+		return
+	}
+
+	isParticipant, err := h.ConversationService.IsUserParticipant(r.Context(), req.ConversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
+		return
+	}
+	if !isParticipant {
+		problem.Error(w, r, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	update, err := h.MessageService.UpdateLiveLocation(r.Context(), messageID, userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update location")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(update)
+}
+
+// GetReceipts lists each participant's read position, for "seen by" markers
+func (h *Handlers) GetReceipts(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessRead) {
+		return
+	}
+	// Participants, or compliance users with an active watch grant
+	if _, err := h.WatchService.AuthorizeRead(r.Context(), conversationID, userID, "rest"); err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
+		return
+	}
+
+	receipts, err := h.MessageService.GetReceipts(r.Context(), conversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get read receipts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipts)
+}
+
+// GetMessageHistory lists a message's earlier versions, for anyone who may read its conversation
+func (h *Handlers) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.MessageService.GetHistory(r.Context(), messageID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get message history")
+		return
+	}
+
+	if !h.authorizeBot(w, r, history.ConversationID, models.BotAccessRead) {
+		return
+	}
+	// Participants, or compliance users with an active watch grant
+	if _, err := h.WatchService.AuthorizeRead(r.Context(), history.ConversationID, userID, "rest"); err != nil {
+		h.writeServiceError(w, r, err, "Failed to check participation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
 func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// TODO: Integrate with NextAuth.js session validation
-	// For now, expect userID as query parameter for testing
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		// Use a default user ID for testing
-		userID = "test-user-123"
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	h.WebSocketHub.HandleWebSocket(w, r, userID)
-}
\ No newline at end of file
+	if err := h.WebSocketHub.CheckConnectionLimit(r.Context(), userID); err != nil {
+		h.writeServiceError(w, r, err, "Failed to open connection")
+		return
+	}
+
+	apiKeyID, _ := middleware.GetAPIKeyIDFromContext(r.Context())
+	h.WebSocketHub.HandleWebSocket(w, r, userID, apiKeyID, middleware.GetTokenExpiryFromContext(r.Context()))
+}
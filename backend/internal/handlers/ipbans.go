@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListIPBans serves the operator API; expired bans are listed until deleted
+func (h *Handlers) ListIPBans(w http.ResponseWriter, r *http.Request) {
+	bans, err := h.IPBanService.ListBans(r.Context())
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list IP bans")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+// CreateIPBan bans an address or CIDR network for the operator
+func (h *Handlers) CreateIPBan(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateIPBanRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	ban, err := h.IPBanService.CreateBan(r.Context(), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to create IP ban")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ban)
+}
+
+// DeleteIPBan lifts a ban for the operator
+func (h *Handlers) DeleteIPBan(w http.ResponseWriter, r *http.Request) {
+	if err := h.IPBanService.DeleteBan(r.Context(), chi.URLParam(r, "id")); err != nil {
+		h.writeServiceError(w, r, err, "Failed to delete IP ban")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+)
+
+// SyncLDAP runs an LDAP sync now for the operator and returns its report
+func (h *Handlers) SyncLDAP(w http.ResponseWriter, r *http.Request) {
+	var req models.LDAPSyncRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	report, err := h.LDAPSyncService.Sync(r.Context(), req.DryRun)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to sync from LDAP")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetLDAPSyncReport returns the last LDAP sync's report that was not a dry run
+func (h *Handlers) GetLDAPSyncReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.LDAPSyncService.LastReport(r.Context())
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get LDAP sync report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
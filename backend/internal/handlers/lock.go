@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// LockConversation freezes posting in a conversation until it is unlocked
+func (h *Handlers) LockConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.LockConversationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	conversation, err := h.LockService.Lock(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to lock conversation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// UnlockConversation lets members post in a locked conversation again
+func (h *Handlers) UnlockConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversation, err := h.LockService.Unlock(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to unlock conversation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
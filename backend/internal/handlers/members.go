@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// AddMembers adds users to a group conversation and returns the IDs of those newly added
+func (h *Handlers) AddMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	var req models.AddMembersRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	added, err := h.ConversationService.AddMembers(r.Context(), conversationID, userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to add members")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.AddMembersResponse{Added: added})
+}
+
+// RemoveMember takes a user out of a group conversation; a member may remove themselves
+func (h *Handlers) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	err := h.ConversationService.RemoveMember(r.Context(), conversationID, userID, chi.URLParam(r, "userId"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPostingPolicy makes a group conversation admins-only, or open to every member again
+func (h *Handlers) SetPostingPolicy(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.PostingPolicyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	conversation, err := h.ConversationService.SetPostingPolicy(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update posting policy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// SetConversationPolicies changes who may post, add members, pin and change the title in a
+// group conversation
+func (h *Handlers) SetConversationPolicies(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ConversationPoliciesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	conversation, err := h.ConversationService.SetPolicies(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update policies")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// UpdateConversation retitles a group conversation
+func (h *Handlers) UpdateConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	var req models.UpdateConversationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	conversation, err := h.ConversationService.UpdateConversation(r.Context(), conversationID, userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update conversation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// SetMemberRole makes a member of a group conversation an admin, a moderator or a plain member
+func (h *Handlers) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	var req models.MemberRoleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	err := h.ConversationService.SetMemberRole(r.Context(), conversationID, userID, chi.URLParam(r, "userId"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update role")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListPins returns a conversation's pinned messages, oldest pin first
+func (h *Handlers) ListPins(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessRead) {
+		return
+	}
+
+	pins, err := h.ConversationService.ListPins(r.Context(), conversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list pins")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pins)
+}
+
+// PinMessage pins a message in its conversation
+func (h *Handlers) PinMessage(w http.ResponseWriter, r *http.Request) {
+	h.changePin(w, r, h.ConversationService.PinMessage, "Failed to pin message")
+}
+
+// UnpinMessage removes a message's pin
+func (h *Handlers) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	h.changePin(w, r, h.ConversationService.UnpinMessage, "Failed to unpin message")
+}
+
+func (h *Handlers) changePin(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, conversationID, actorID string, messageID int64) (*models.Conversation, error), failure string) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		problem.Error(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	conversation, err := change(r.Context(), conversationID, userID, messageID)
+	if err != nil {
+		h.writeServiceError(w, r, err, failure)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// MuteMember keeps a member of a conversation from sending for a while
+func (h *Handlers) MuteMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	var req models.MuteMemberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	mute, err := h.MuteService.Mute(r.Context(), conversationID, userID, chi.URLParam(r, "userId"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to mute member")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mute)
+}
+
+// UnmuteMember lifts a member's mute before it expires
+func (h *Handlers) UnmuteMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+	if !h.authorizeBot(w, r, conversationID, models.BotAccessPost) {
+		return
+	}
+
+	err := h.MuteService.Unmute(r.Context(), conversationID, userID, chi.URLParam(r, "userId"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to unmute member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+func (h *Handlers) GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.NotificationService.GetSettings(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get notification settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// GetAwaySummary returns what the caller missed during their last do-not-disturb window
+func (h *Handlers) GetAwaySummary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	summary, err := h.NotificationService.GetAwaySummary(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get away summary")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// GetBadge returns the caller's unread totals for app-icon badges
+func (h *Handlers) GetBadge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	badge, err := h.BadgeService.GetBadge(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to count unread messages")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(badge)
+}
+
+func (h *Handlers) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.NotificationSettings
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.NotificationService.UpdateSettings(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update notification settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
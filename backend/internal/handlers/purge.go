@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) PurgeDryRun(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dryRun, err := h.PurgeService.DryRun(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to process purge")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dryRun)
+}
+
+func (h *Handlers) ConfirmPurge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ConfirmPurgeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	job, err := h.PurgeService.Confirm(r.Context(), userID, req.ConfirmationToken)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to process purge")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handlers) GetPurgeJob(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := h.PurgeService.GetJob(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to process purge")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handlers) RepairOrphans(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := h.PurgeService.RepairOrphans(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to repair orphaned data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) GetRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+
+	retention, err := h.RetentionService.GetRetention(r.Context(), conversationID, userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retention)
+}
+
+func (h *Handlers) UpdateRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID := chi.URLParam(r, "id")
+
+	var req models.UpdateRetentionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	retention, pending, err := h.RetentionService.UpdateRetention(r.Context(), conversationID, userID, req.RetentionDays)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if pending {
+		// Shortening retention waits for a compliance officer
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(retention)
+}
+
+func (h *Handlers) ApproveRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	retention, err := h.RetentionService.ApproveRetention(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retention)
+}
+
+func (h *Handlers) RejectRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	retention, err := h.RetentionService.RejectRetention(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retention)
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// SCIM clients expect SCIM's own error bodies and media type rather than problem details, so
+// everything under /scim/v2 writes those
+const scimContentType = "application/scim+json"
+
+// CreateSCIMToken issues the caller's workspace a SCIM token, replacing any earlier one
+func (h *Handlers) CreateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.SCIMService.CreateToken(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to create SCIM token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+func (h *Handlers) RevokeSCIMToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.SCIMService.RevokeToken(r.Context(), userID); err != nil {
+		h.writeServiceError(w, r, err, "Failed to revoke SCIM token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SCIMAuth authenticates an identity provider by its workspace's SCIM token and serves the
+// request from that workspace
+func (h *Handlers) SCIMAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || token == "" {
+			writeSCIMError(w, http.StatusUnauthorized, "", "Missing or invalid authorization")
+			return
+		}
+		workspaceID, err := h.SCIMService.Authenticate(r.Context(), token)
+		if errors.Is(err, services.ErrNotFound) {
+			writeSCIMError(w, http.StatusUnauthorized, "", "Invalid SCIM token")
+			return
+		}
+		if err != nil {
+			h.writeSCIMServiceError(w, r, err, "Failed to authenticate")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), workspaceID)))
+	})
+}
+
+// GetSCIMServiceProviderConfig describes what of SCIM this service supports
+func (h *Handlers) GetSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": 200},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The workspace's SCIM token, from POST /v1/workspace/scim-token",
+		}},
+	})
+}
+
+func (h *Handlers) ListSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	startIndex, count := services.SCIMPage(r.URL.Query().Get("startIndex"), r.URL.Query().Get("count"))
+	list, err := h.SCIMService.ListUsers(r.Context(), tenant.FromContext(r.Context()), r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to list users")
+		return
+	}
+	writeSCIM(w, http.StatusOK, list)
+}
+
+func (h *Handlers) GetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.SCIMService.GetUser(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to get user")
+		return
+	}
+	writeSCIM(w, http.StatusOK, user)
+}
+
+func (h *Handlers) CreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMUser
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	user, err := h.SCIMService.CreateUser(r.Context(), tenant.FromContext(r.Context()), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to create user")
+		return
+	}
+	w.Header().Set("Location", user.Meta.Location)
+	writeSCIM(w, http.StatusCreated, user)
+}
+
+func (h *Handlers) ReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMUser
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	user, err := h.SCIMService.ReplaceUser(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to update user")
+		return
+	}
+	writeSCIM(w, http.StatusOK, user)
+}
+
+func (h *Handlers) PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMPatchRequest
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	user, err := h.SCIMService.PatchUser(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to update user")
+		return
+	}
+	writeSCIM(w, http.StatusOK, user)
+}
+
+// DeleteSCIMUser deprovisions a user, which deactivates them rather than deleting anything
+func (h *Handlers) DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.SCIMService.DeleteUser(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id")); err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to deactivate user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) ListSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	startIndex, count := services.SCIMPage(r.URL.Query().Get("startIndex"), r.URL.Query().Get("count"))
+	list, err := h.SCIMService.ListGroups(r.Context(), tenant.FromContext(r.Context()), r.URL.Query().Get("filter"), startIndex, count)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to list groups")
+		return
+	}
+	writeSCIM(w, http.StatusOK, list)
+}
+
+func (h *Handlers) GetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := h.SCIMService.GetGroup(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to get group")
+		return
+	}
+	writeSCIM(w, http.StatusOK, group)
+}
+
+func (h *Handlers) CreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMGroup
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	group, err := h.SCIMService.CreateGroup(r.Context(), tenant.FromContext(r.Context()), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to create group")
+		return
+	}
+	w.Header().Set("Location", group.Meta.Location)
+	writeSCIM(w, http.StatusCreated, group)
+}
+
+func (h *Handlers) ReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMGroup
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	group, err := h.SCIMService.ReplaceGroup(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to update group")
+		return
+	}
+	writeSCIM(w, http.StatusOK, group)
+}
+
+func (h *Handlers) PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var req models.SCIMPatchRequest
+	if !decodeSCIM(w, r, &req) {
+		return
+	}
+
+	group, err := h.SCIMService.PatchGroup(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to update group")
+		return
+	}
+	writeSCIM(w, http.StatusOK, group)
+}
+
+// DeleteSCIMGroup unlinks a group; its conversation is kept
+func (h *Handlers) DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if err := h.SCIMService.DeleteGroup(r.Context(), tenant.FromContext(r.Context()), chi.URLParam(r, "id")); err != nil {
+		h.writeSCIMServiceError(w, r, err, "Failed to delete group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeSCIM is decodeJSON for SCIM requests, reporting problems as SCIM errors
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeSCIMError(w, http.StatusRequestEntityTooLarge, "", "Request body too large")
+			return false
+		}
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return false
+	}
+	if !utf8.Valid(body) || json.Unmarshal(body, v) != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return false
+	}
+	if err := validate.Struct(v); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) writeSCIMServiceError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	status := services.HTTPStatus(err)
+	if status == http.StatusInternalServerError {
+		h.Logger.ErrorContext(r.Context(), fallback, "path", r.URL.Path, logging.Err(err))
+	}
+	var scimType string
+	switch status {
+	case http.StatusConflict:
+		scimType = "uniqueness"
+	case http.StatusBadRequest:
+		scimType = "invalidValue"
+		if strings.Contains(r.URL.RawQuery, "filter=") {
+			scimType = "invalidFilter"
+		}
+	}
+	writeSCIMError(w, status, scimType, services.PublicMessage(err, fallback))
+}
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIM(w, status, &models.SCIMError{
+		Schemas:  []string{models.SCIMErrorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// GetEffectiveSettings shows the resolved settings for ?conversationId=&userId=, with where each
+// value came from; userId defaults to the caller
+func (h *Handlers) GetEffectiveSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	settings, err := h.SettingsService.GetEffective(r.Context(), userID, query.Get("conversationId"), query.Get("userId"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to resolve settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) GetWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.SettingsService.GetWorkspaceSettings(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) UpdateWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.Settings
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.SettingsService.UpdateWorkspaceSettings(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) GetConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.SettingsService.GetConversationSettings(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) UpdateConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.Settings
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.SettingsService.UpdateConversationSettings(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) GetMySettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.SettingsService.GetUserSettings(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handlers) UpdateMySettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.Settings
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.SettingsService.UpdateUserSettings(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to update settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
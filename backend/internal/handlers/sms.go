@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+// emptyTwiML tells Twilio not to reply to the text
+const emptyTwiML = `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`
+
+// ReceiveSMS is Twilio's webhook for texts to the workspace's number. It is not
+// authenticated; Twilio signs the request with the account's auth token instead.
+func (h *Handlers) ReceiveSMS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		problem.Error(w, r, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !h.SMSService.VerifySignature(r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		problem.Error(w, r, "Invalid Twilio signature", http.StatusForbidden)
+		return
+	}
+
+	err := h.SMSService.ReceiveSMS(r.Context(), r.PostForm.Get("From"), r.PostForm.Get("Body"), r.PostForm.Get("MessageSid"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to receive text")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(emptyTwiML))
+}
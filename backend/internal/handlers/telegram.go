@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ReceiveTelegramUpdate is the Telegram bot's webhook. It is not authenticated; Telegram sends
+// the secret registered with the webhook instead.
+func (h *Handlers) ReceiveTelegramUpdate(w http.ResponseWriter, r *http.Request) {
+	if !h.TelegramService.VerifySecret(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")) {
+		problem.Error(w, r, "Invalid Telegram secret", http.StatusForbidden)
+		return
+	}
+
+	var update services.TelegramUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		problem.Error(w, r, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	// Telegram retries failed updates, so only failures worth retrying are reported
+	if err := h.TelegramService.ReceiveUpdate(r.Context(), &update); err != nil && services.HTTPStatus(err) >= 500 {
+		h.writeServiceError(w, r, err, "Failed to receive Telegram update")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handlers) GetTelegramLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	link, err := h.TelegramService.GetLink(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get Telegram link")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+func (h *Handlers) LinkTelegram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.LinkTelegramRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	link, err := h.TelegramService.Link(r.Context(), chi.URLParam(r, "id"), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to link Telegram chat")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+func (h *Handlers) UnlinkTelegram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.TelegramService.Unlink(r.Context(), chi.URLParam(r, "id"), userID); err != nil {
+		h.writeServiceError(w, r, err, "Failed to unlink Telegram chat")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTelegramFile serves a photo, video or other file posted in the linked Telegram chat.
+// Files are downloaded as attachments, except images, so none is rendered as a page.
+func (h *Handlers) GetTelegramFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, contentType, err := h.TelegramService.File(r.Context(), chi.URLParam(r, "id"), userID, chi.URLParam(r, "fileId"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get Telegram file")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	io.Copy(w, body)
+}
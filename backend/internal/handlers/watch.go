@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handlers) CreateWatchGrant(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateWatchGrantRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	grant, err := h.WatchService.Grant(r.Context(), userID, chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to create watch grant")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+func (h *Handlers) ListWatchGrants(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	grants, err := h.WatchService.ListGrants(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list watch grants")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
+
+func (h *Handlers) RevokeWatchGrant(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.WatchService.Revoke(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		h.writeServiceError(w, r, err, "Failed to revoke watch grant")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateWorkspace serves the operator API; the caller is authenticated by the admin token
+func (h *Handlers) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWorkspaceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workspace, err := h.WorkspaceService.CreateWorkspace(r.Context(), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to create workspace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handlers) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := h.WorkspaceService.ListWorkspaces(r.Context())
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list workspaces")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// GetUsage reports workspaces' metered usage for a month to the operator
+func (h *Handlers) GetUsage(w http.ResponseWriter, r *http.Request) {
+	report, err := h.UsageService.Report(r.Context(), r.URL.Query().Get("workspaceId"), r.URL.Query().Get("period"))
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetWorkspace returns the caller's workspace to its admins
+func (h *Handlers) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspace, err := h.WorkspaceService.GetWorkspace(r.Context(), userID)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to get workspace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handlers) SetDefaultConversations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.DefaultConversationsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workspace, err := h.WorkspaceService.SetDefaultConversations(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to set default conversations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handlers) SetEmailDomains(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.EmailDomainsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workspace, err := h.WorkspaceService.SetEmailDomains(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to set email domains")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handlers) SetAttachmentPolicy(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.AttachmentPolicy
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	workspace, err := h.WorkspaceService.SetAttachmentPolicy(r.Context(), userID, &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to set attachment policy")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+func (h *Handlers) SetWorkspaceRetention(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.WorkspaceRetentionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	doc, err := h.SettingsService.SetWorkspaceRetention(r.Context(), userID, req.RetentionDays)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to set workspace retention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func (h *Handlers) ListWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 50 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 200 {
+			limit = parsedLimit
+		}
+	}
+
+	page, err := h.WorkspaceService.ListMembers(r.Context(), userID, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to list workspace members")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *Handlers) SetMemberRoles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MemberRolesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := h.WorkspaceService.SetMemberRoles(r.Context(), userID, chi.URLParam(r, "id"), &req)
+	if err != nil {
+		h.writeServiceError(w, r, err, "Failed to set member roles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
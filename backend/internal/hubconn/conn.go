@@ -0,0 +1,127 @@
+// Package hubconn connects in-process clients, such as the XMPP gateway and the IRC bridge, to
+// the WebSocket hub. The hub serves a Conn as it serves a gRPC Chat stream, so these clients get
+// the same permissions, limits and frames as any other connection.
+package hubconn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/chatpb"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"nhooyr.io/websocket"
+)
+
+// frameQueue bounds frames sent to the hub and not read yet
+const frameQueue = 64
+
+var ErrClosed = errors.New("connection closed")
+
+// Conn implements services.ClientConn. Frames from the hub are passed to onFrame with their
+// data as JSON; message IDs beyond 2^53 are strings there, as in protobuf frames.
+type Conn struct {
+	clock   clock.Clock
+	onFrame func(ctx context.Context, frameType string, data json.RawMessage)
+	onClose func()
+
+	frames    chan []byte
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a connection calling onFrame, from the hub's write pump, for each frame the hub
+// writes, and onClose once when it is closed. Either may be nil.
+func New(clk clock.Clock, onFrame func(ctx context.Context, frameType string, data json.RawMessage), onClose func()) *Conn {
+	return &Conn{
+		clock:   clk,
+		onFrame: onFrame,
+		onClose: onClose,
+		frames:  make(chan []byte, frameQueue),
+		closing: make(chan struct{}),
+	}
+}
+
+// Send passes a client frame to the hub, waiting while the queue is full
+func (c *Conn) Send(frameType string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return err
+	}
+	value, err := structpb.NewValue(generic)
+	if err != nil {
+		return err
+	}
+	frame, err := proto.Marshal(&chatpb.Frame{Type: frameType, Ts: c.clock.Now().UnixMilli(), Data: value})
+	if err != nil {
+		return err
+	}
+	select {
+	case c.frames <- frame:
+		return nil
+	case <-c.closing:
+		return ErrClosed
+	}
+}
+
+// Done is closed when the connection is
+func (c *Conn) Done() <-chan struct{} {
+	return c.closing
+}
+
+func (c *Conn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case frame := <-c.frames:
+		return websocket.MessageBinary, frame, nil
+	case <-c.closing:
+		return 0, nil, ErrClosed
+	}
+}
+
+func (c *Conn) Write(ctx context.Context, typ websocket.MessageType, p []byte) error {
+	select {
+	case <-c.closing:
+		return ErrClosed
+	default:
+	}
+
+	var frame chatpb.Frame
+	if err := proto.Unmarshal(p, &frame); err != nil {
+		return err
+	}
+	data, err := json.Marshal(frame.GetData().AsInterface())
+	if err != nil {
+		return err
+	}
+	if c.onFrame != nil {
+		c.onFrame(ctx, frame.Type, data)
+	}
+	return nil
+}
+
+// Ping has nothing to do; there is no transport to keep alive
+func (c *Conn) Ping(ctx context.Context) error {
+	select {
+	case <-c.closing:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+func (c *Conn) Close(code websocket.StatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
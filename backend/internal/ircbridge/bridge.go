@@ -0,0 +1,239 @@
+// Package ircbridge relays group conversations to IRC channels both ways. It keeps one IRC
+// connection under a configured nick and one hub connection as a bridge user, who must be a
+// member of every bridged conversation. Lines said in a channel are posted to its
+// conversation by that user as "<nick> text", and messages in the conversation are said in the
+// channel as "<name> text".
+package ircbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/hubconn"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+)
+
+const (
+	maxRetryDelay = 30 * time.Second
+	// outboundQueue bounds lines waiting for the IRC connection; more are dropped
+	outboundQueue = 256
+)
+
+type Config struct {
+	Server   string // IRC server host:port; empty disables the bridge
+	TLS      bool
+	Nick     string
+	Password string // server password, if the server wants one
+	// UserID posts channel lines in conversations; it must be a member of each
+	UserID string
+	// Channels maps conversation IDs to IRC channels
+	Channels map[string]string
+}
+
+// ParseChannels reads "<conversation id>=<#channel>" entries
+func ParseChannels(entries []string) (map[string]string, error) {
+	channels := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		conversationID, channel, ok := strings.Cut(entry, "=")
+		if !ok || conversationID == "" || !isChannel(channel) || strings.ContainsAny(channel, " ,\x07") {
+			return nil, fmt.Errorf("%q is not <conversation id>=<#channel>", entry)
+		}
+		channels[conversationID] = channel
+	}
+	return channels, nil
+}
+
+// Bridge is the IRC bridge. Run it on one node, or channels see every message once per node.
+type Bridge struct {
+	config        Config
+	hub           *services.WebSocketHub
+	users         *services.UserService
+	conversations *services.ConversationService
+	clock         clock.Clock
+	logger        *slog.Logger
+
+	channels map[string]string // lowercased IRC channel -> conversation ID, of the bridged ones
+	lines    chan string       // PRIVMSG lines waiting for the IRC connection
+
+	hubMu   sync.Mutex
+	hubConn *hubconn.Conn // set while the hub serves the bridge user
+	sent    atomic.Int64  // numbers client message IDs
+}
+
+func New(config Config, hub *services.WebSocketHub, users *services.UserService, conversations *services.ConversationService, clk clock.Clock, logger *slog.Logger) *Bridge {
+	return &Bridge{
+		config:        config,
+		hub:           hub,
+		users:         users,
+		conversations: conversations,
+		clock:         clk,
+		logger:        logger,
+		channels:      make(map[string]string),
+		lines:         make(chan string, outboundQueue),
+	}
+}
+
+// Run bridges the configured channels until ctx is cancelled
+func (b *Bridge) Run(ctx context.Context) {
+	if b.config.Server == "" {
+		b.logger.Info("IRC bridge disabled")
+		return
+	}
+	b.checkChannels(ctx)
+	if len(b.channels) == 0 {
+		b.logger.Warn("IRC bridge has no conversations to bridge")
+		return
+	}
+
+	go b.retry(ctx, "hub", b.serveHub)
+	b.retry(ctx, "IRC", b.serveIRC)
+}
+
+// checkChannels keeps the configured channels whose conversation is a group the bridge user
+// belongs to
+func (b *Bridge) checkChannels(ctx context.Context) {
+	for conversationID, channel := range b.config.Channels {
+		conversation, err := b.conversations.GetConversationByID(ctx, conversationID)
+		if err == nil && conversation.Kind != "group" {
+			err = fmt.Errorf("only group conversations can be bridged")
+		}
+		if err == nil {
+			var member bool
+			member, err = b.conversations.IsUserParticipant(ctx, conversationID, b.config.UserID)
+			if err == nil && !member {
+				err = fmt.Errorf("the bridge user is not a member")
+			}
+		}
+		if err != nil {
+			b.logger.Warn("Not bridging conversation", logging.ConversationID, conversationID, "channel", channel, logging.Err(err))
+			continue
+		}
+		b.channels[strings.ToLower(channel)] = conversationID
+	}
+}
+
+// retry runs serve until ctx is cancelled, waiting longer after each quick failure
+func (b *Bridge) retry(ctx context.Context, name string, serve func(context.Context) error) {
+	delay := time.Second
+	for {
+		started := b.clock.Now()
+		err := serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if b.clock.Now().Sub(started) > maxRetryDelay {
+			delay = time.Second
+		}
+		b.logger.Warn("IRC bridge lost its "+name+" connection", "retry_in", delay, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// serveHub connects the bridge user to the hub and subscribes to the bridged conversations
+func (b *Bridge) serveHub(ctx context.Context) error {
+	if err := b.hub.CheckConnectionLimit(ctx, b.config.UserID); err != nil {
+		return err
+	}
+	conn := hubconn.New(b.clock, b.handleFrame, nil)
+	b.hubMu.Lock()
+	b.hubConn = conn
+	b.hubMu.Unlock()
+	defer func() {
+		b.hubMu.Lock()
+		b.hubConn = nil
+		b.hubMu.Unlock()
+	}()
+
+	go func() {
+		for _, conversationID := range b.channels {
+			if conn.Send("subscribe", &models.WSSubscribeData{ConversationID: conversationID}) != nil {
+				return
+			}
+		}
+	}()
+	b.hub.ServeStream(ctx, conn, b.config.UserID, "", time.Time{}, "irc", "")
+	return fmt.Errorf("hub closed the connection")
+}
+
+// post sends a channel line to its conversation; lines said while the hub connection is down
+// are lost
+func (b *Bridge) post(conversationID, body string) {
+	b.hubMu.Lock()
+	conn := b.hubConn
+	b.hubMu.Unlock()
+	if conn == nil {
+		return
+	}
+	conn.Send("message.send", &models.WSMessageSendData{
+		ConversationID: conversationID,
+		ClientMsgID:    "irc:" + strconv.FormatInt(b.clock.Now().UnixNano(), 36) + "." + strconv.FormatInt(b.sent.Add(1), 36),
+		Body:           body,
+	})
+}
+
+// messageNew is the part of a message.new frame the bridge relays
+type messageNew struct {
+	ConversationID string       `json:"conversationId"`
+	SenderID       string       `json:"senderId"`
+	Body           string       `json:"body"`
+	Sender         *models.User `json:"sender"`
+}
+
+// handleFrame queues messages from bridged conversations for their channels
+func (b *Bridge) handleFrame(ctx context.Context, frameType string, data json.RawMessage) {
+	switch frameType {
+	case "message.new":
+		var m messageNew
+		if err := json.Unmarshal(data, &m); err != nil || m.SenderID == b.config.UserID {
+			return
+		}
+		channel, ok := b.config.Channels[m.ConversationID]
+		if !ok {
+			return
+		}
+		for _, line := range formatMessage(b.senderName(ctx, m.SenderID, m.Sender), m.Body) {
+			select {
+			case b.lines <- "PRIVMSG " + channel + " :" + line:
+			default:
+				b.logger.Warn("IRC bridge queue full; dropping a line", "channel", channel)
+			}
+		}
+	case "error":
+		var failure models.WSErrorData
+		if json.Unmarshal(data, &failure) == nil {
+			b.logger.Warn("IRC bridge could not post a line", "code", failure.Code, "detail", failure.Detail)
+		}
+	}
+}
+
+// senderName is how a conversation member is named in channels
+func (b *Bridge) senderName(ctx context.Context, userID string, sender *models.User) string {
+	if sender == nil {
+		var err error
+		if sender, err = b.users.GetUserProfile(ctx, userID); err != nil {
+			return userID
+		}
+	}
+	if sender.Username != "" {
+		return sender.Username
+	}
+	if sender.Name != "" {
+		return sender.Name
+	}
+	return userID
+}
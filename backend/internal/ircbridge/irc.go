@@ -0,0 +1,250 @@
+package ircbridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	// floodDelay spaces relayed lines so servers do not disconnect the bridge for flooding
+	floodDelay = 500 * time.Millisecond
+	// maxLineText is the most text sent in one PRIVMSG, well inside IRC's 512-byte lines
+	maxLineText = 400
+	// maxRelayedLines caps the lines one message becomes
+	maxRelayedLines = 10
+	maxInboundLine  = 8192 + 512 // IRCv3 tags plus the message
+)
+
+// ircConn writes lines to an IRC server
+type ircConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *ircConn) writeLine(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// serveIRC connects to the IRC server, joins the channels and relays until the connection ends
+func (b *Bridge) serveIRC(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if b.config.TLS {
+		host, _, _ := net.SplitHostPort(b.config.Server)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", b.config.Server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.config.Server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	irc := &ircConn{conn: conn}
+	nick := b.config.Nick
+	if b.config.Password != "" {
+		irc.writeLine("PASS " + b.config.Password)
+	}
+	irc.writeLine("NICK " + nick)
+	if err := irc.writeLine("USER " + b.config.Nick + " 0 * :Chat bridge"); err != nil {
+		return err
+	}
+
+	relayCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxInboundLine)
+	for scanner.Scan() {
+		msg := parseLine(scanner.Text())
+		switch msg.command {
+		case "PING":
+			irc.writeLine("PONG :" + msg.param(0))
+		case "001": // welcome: registered under the nick it names
+			nick = msg.param(0)
+			b.logger.Info("IRC bridge connected", "server", b.config.Server, "nick", nick, "channels", len(b.channels))
+			for _, channel := range b.config.Channels {
+				if _, ok := b.channels[strings.ToLower(channel)]; ok {
+					irc.writeLine("JOIN " + channel)
+				}
+			}
+			go b.relayLines(relayCtx, irc)
+		case "433": // nick in use
+			nick += "_"
+			irc.writeLine("NICK " + nick)
+		case "KICK":
+			if strings.EqualFold(msg.param(1), nick) {
+				irc.writeLine("JOIN " + msg.param(0))
+			}
+		case "PRIVMSG":
+			conversationID, ok := b.channels[strings.ToLower(msg.param(0))]
+			if ok && !strings.EqualFold(msg.nick(), nick) {
+				if body := formatLine(msg.nick(), msg.param(1)); body != "" {
+					b.post(conversationID, body)
+				}
+			}
+		case "ERROR":
+			return fmt.Errorf("server closed the link: %s", msg.param(0))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("server closed the connection")
+}
+
+// relayLines sends queued lines to the channels, spaced by floodDelay
+func (b *Bridge) relayLines(ctx context.Context, irc *ircConn) {
+	ticker := time.NewTicker(floodDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-b.lines:
+			if err := irc.writeLine(line); err != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ircMessage is a parsed IRC line: [@tags] [:prefix] command params... [:trailing]
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+func parseLine(line string) ircMessage {
+	var msg ircMessage
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		msg.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			msg.params = append(msg.params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if msg.command == "" {
+			msg.command = strings.ToUpper(param)
+		} else if param != "" {
+			msg.params = append(msg.params, param)
+		}
+	}
+	return msg
+}
+
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// nick is the sender's nick from a nick!user@host prefix
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+func isChannel(name string) bool {
+	return len(name) > 1 && (name[0] == '#' || name[0] == '&')
+}
+
+// formatLine is the message body for a channel line: "<nick> text", or "* nick text" for an
+// action, without IRC formatting codes. Other CTCP requests give "".
+func formatLine(nick, text string) string {
+	if strings.HasPrefix(text, "\x01") {
+		action, ok := strings.CutPrefix(strings.Trim(text, "\x01"), "ACTION ")
+		if !ok {
+			return ""
+		}
+		return "* " + nick + " " + stripFormatting(action)
+	}
+	text = strings.TrimSpace(stripFormatting(text))
+	if text == "" {
+		return ""
+	}
+	return "<" + nick + "> " + text
+}
+
+// stripFormatting removes mIRC bold, colour, italic, underline, strikethrough, monospace,
+// reverse and reset codes
+func stripFormatting(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case 0x02, 0x0F, 0x11, 0x16, 0x1D, 0x1E, 0x1F:
+		case 0x03: // colour: up to two digits, optionally a comma and two more
+			i += digits(text[i+1:])
+			if i+1 < len(text) && text[i+1] == ',' && digits(text[i+2:]) > 0 {
+				i += 1 + digits(text[i+2:])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func digits(s string) int {
+	n := 0
+	for n < 2 && n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// formatMessage splits a message into channel lines prefixed "<name> ", cut to fit IRC lines
+func formatMessage(name, body string) []string {
+	prefix := "<" + name + "> "
+	var lines []string
+	for _, text := range strings.Split(body, "\n") {
+		text = strings.TrimSpace(text)
+		for text != "" {
+			if len(lines) == maxRelayedLines {
+				lines[len(lines)-1] += " …"
+				return lines
+			}
+			chunk := text
+			if len(chunk) > maxLineText {
+				cut := maxLineText
+				for cut > 0 && !utf8.RuneStart(chunk[cut]) {
+					cut--
+				}
+				chunk = chunk[:cut]
+			}
+			lines = append(lines, prefix+chunk)
+			text = strings.TrimSpace(text[len(chunk):])
+		}
+	}
+	return lines
+}
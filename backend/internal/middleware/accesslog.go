@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// AccessLog logs one line per request with its status, size and duration. It runs inside
+// RequestID, so each line carries the request ID, and inside ClientIP, so it names the client
+// behind any trusted proxies.
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			next.ServeHTTP(ww, r)
+
+			logger.InfoContext(r.Context(), "Request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", ww.Status(),
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+				"remote", r.RemoteAddr,
+				"client", clientip.FromContext(r.Context()).String(),
+			)
+		})
+	}
+}
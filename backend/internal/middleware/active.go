@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+// DeactivationChecker reports whether a user has been deprovisioned
+type DeactivationChecker interface {
+	IsDeactivated(ctx context.Context, userID string) (bool, error)
+}
+
+// CheckActive refuses a deactivated principal, whose token or API key is otherwise still valid
+func CheckActive(ctx context.Context, checker DeactivationChecker) (bool, error) {
+	userID, ok := GetUserIDFromContext(ctx)
+	if !ok {
+		return true, nil
+	}
+	deactivated, err := checker.IsDeactivated(ctx, userID)
+	return !deactivated, err
+}
+
+// RequireActiveUser refuses requests from deactivated users. It goes after the auth middleware
+// and, when tenants are isolated, the tenant middleware.
+func RequireActiveUser(checker DeactivationChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			active, err := CheckActive(r.Context(), checker)
+			if err != nil {
+				problem.Error(w, r, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				problem.Error(w, r, "Account deactivated", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,60 +2,142 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
 	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey      contextKey = "userID"
+	TokenExpiryKey contextKey = "tokenExpiry"
+	TokenKey       contextKey = "token"
+)
+
+// JWTVerifier validates RS256 bearer tokens issued by the frontend, either against a
+// static PEM public key or against a JWKS endpoint (see NewJWKSVerifier)
+type JWTVerifier struct {
+	key      jwk.Key
+	jwks     *jwksSource
+	issuer   string
+	audience string
+}
+
+func NewJWTVerifier(publicKeyPEM, issuer, audience string) (*JWTVerifier, error) {
+	key, err := jwk.ParseKey([]byte(publicKeyPEM), jwk.WithPEM(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+	}
+
+	return &JWTVerifier{
+		key:      key,
+		issuer:   issuer,
+		audience: audience,
+	}, nil
+}
+
+// Verify checks the token's signature, expiry, issuer and audience
+func (v *JWTVerifier) Verify(tokenString string) (jwt.Token, error) {
+	var opts []jwt.ParseOption
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
 
-func JWTAuthMiddleware(publicKeyPEM, issuer, audience string) func(http.Handler) http.Handler {
+	var token jwt.Token
+	var err error
+	if v.jwks != nil {
+		token, err = v.jwks.parse([]byte(tokenString), opts...)
+	} else {
+		token, err = jwt.Parse([]byte(tokenString), append(opts, jwt.WithKey(jwa.RS256, v.key))...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Subject() == "" {
+		return nil, fmt.Errorf("missing user ID in token")
+	}
+
+	return token, nil
+}
+
+func JWTAuthMiddleware(verifier *JWTVerifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract token from Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "Missing authorization header", http.StatusUnauthorized)
-				return
-			}
-
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			if tokenString == authHeader {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			tokenString, ok := bearerToken(r)
+			if !ok {
+				problem.Error(w, r, "Missing or invalid authorization", http.StatusUnauthorized)
 				return
 			}
 
-			// Parse and validate JWT
-			token, err := jwt.Parse(
-				[]byte(tokenString),
-				jwt.WithKey(jwa.RS256, []byte(publicKeyPEM)),
-				jwt.WithIssuer(issuer),
-				jwt.WithAudience(audience),
-			)
+			token, err := verifier.Verify(tokenString)
 			if err != nil {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
-				return
-			}
-
-			// Extract user ID from token
-			userID, ok := token.Get("sub")
-			if !ok {
-				http.Error(w, "Missing user ID in token", http.StatusUnauthorized)
+				problem.Error(w, r, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			// Add user ID to request context
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(WithUserToken(r.Context(), token)))
 		})
 	}
 }
 
+// WithUserToken adds a verified token, its user ID and its expiry to ctx, for the gRPC API to
+// authenticate calls the same way
+func WithUserToken(ctx context.Context, token jwt.Token) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, token.Subject())
+	ctx = context.WithValue(ctx, TokenKey, token)
+	return context.WithValue(ctx, TokenExpiryKey, token.Expiration())
+}
+
+// bearerToken extracts the JWT from the Authorization header, or for WebSocket upgrades
+// (where browsers cannot set headers) from "Sec-WebSocket-Protocol: bearer, <jwt>", which may
+// also offer other subprotocols: "chat.v1.proto, bearer, <jwt>"
+func bearerToken(r *http.Request) (string, bool) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		return tokenString, tokenString != authHeader && tokenString != ""
+	}
+
+	protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == "bearer" {
+			tokenString := strings.TrimSpace(protocols[i+1])
+			return tokenString, tokenString != ""
+		}
+	}
+
+	return "", false
+}
+
 func GetUserIDFromContext(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(UserIDKey).(string)
 	return userID, ok
-}
\ No newline at end of file
+}
+
+// GetTokenClaimFromContext returns a string claim of the request's token; empty if the claim is
+// unset, not a string, or name is empty
+func GetTokenClaimFromContext(ctx context.Context, name string) string {
+	token, ok := ctx.Value(TokenKey).(jwt.Token)
+	if !ok || name == "" {
+		return ""
+	}
+	claim, _ := token.Get(name)
+	value, _ := claim.(string)
+	return value
+}
+
+// GetTokenExpiryFromContext returns when the request's token expires; zero means no expiry
+func GetTokenExpiryFromContext(ctx context.Context) time.Time {
+	expiry, _ := ctx.Value(TokenExpiryKey).(time.Time)
+	return expiry
+}
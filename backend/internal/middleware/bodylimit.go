@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+)
+
+// MaxBodySize caps request bodies at maxBytes. Requests declaring a larger Content-Length are
+// rejected with 413 before reaching the handler; bodies without one are cut off by
+// http.MaxBytesReader, whose error handlers report as 413 too.
+func MaxBodySize(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				problem.Error(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
+)
+
+// IPBanList answers whether an address is banned, and why
+type IPBanList interface {
+	Banned(addr netip.Addr) (string, bool)
+}
+
+// ClientIP works out the address of the client behind a request and stores it in the context
+// (see package clientip).
+// X-Forwarded-For is believed only as far as it was written by trusted proxies: walking it from
+// the right, past the connecting peer and every trusted hop, the first untrusted address is the
+// client. A client cannot spoof its way past this, as whatever it writes lies to the left of
+// what the proxies append. With no trusted proxies the peer is the client.
+func ClientIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := clientAddr(r, trusted)
+			next.ServeHTTP(w, r.WithContext(clientip.NewContext(r.Context(), addr)))
+		})
+	}
+}
+
+func clientAddr(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+	if !isTrusted(addr, trusted) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A garbled entry was not written by a trusted proxy; stop at the last good hop
+			return addr
+		}
+		addr = hop.Unmap()
+		if !isTrusted(addr, trusted) {
+			return addr
+		}
+	}
+	return addr
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter refuses requests from banned addresses with 403 and, when perMinute is positive,
+// limits each address to perMinute requests with 429. Both are logged. It runs inside
+// ClientIP; requests whose address is unknown pass.
+func IPFilter(bans IPBanList, limiter *RateLimiter, perMinute int, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := clientip.FromContext(r.Context())
+			if !addr.IsValid() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if reason, banned := bans.Banned(addr); banned {
+				logger.WarnContext(r.Context(), "Request from banned IP refused",
+					"ip", addr.String(), "path", r.URL.Path, "reason", reason)
+				problem.Error(w, r, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if perMinute > 0 && !limiter.AllowPerMinute("ip:"+addr.String(), perMinute) {
+				logger.WarnContext(r.Context(), "IP rate limit exceeded", "ip", addr.String(), "path", r.URL.Path)
+				problem.Error(w, r, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+)
+
+// RequireDatabase answers 503 straight away while the MongoDB circuit breaker is open, rather
+// than letting each request wait out its own timeout against a database that is down
+func RequireDatabase(db *database.MongoDB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := db.Available(); err != nil {
+				retryAfter := int(math.Ceil(db.RetryAfter().Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				problem.Error(w, r, "Service temporarily unavailable; retry shortly", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// jwksSource resolves signing keys from a JWKS URL. Keys are cached and refreshed in
+// the background; a token signed by an unknown key triggers one early refresh
+// (at most once per minRefresh) so issuer key rotations need no redeploy.
+type jwksSource struct {
+	url        string
+	cache      *jwk.Cache
+	minRefresh time.Duration
+	clock      clock.Clock
+
+	mu            sync.Mutex
+	lastRefreshed time.Time
+}
+
+// NewJWKSVerifier returns a JWTVerifier that fetches keys from jwksURL (NextAuth, Auth0, ...)
+func NewJWKSVerifier(ctx context.Context, jwksURL, issuer, audience string, refreshInterval time.Duration, clk clock.Clock) (*JWTVerifier, error) {
+	cache := jwk.NewCache(ctx)
+	if err := cache.Register(jwksURL, jwk.WithRefreshInterval(refreshInterval)); err != nil {
+		return nil, fmt.Errorf("failed to register JWKS URL: %w", err)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := cache.Refresh(fetchCtx, jwksURL); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	return &JWTVerifier{
+		jwks: &jwksSource{
+			url:           jwksURL,
+			cache:         cache,
+			minRefresh:    time.Minute,
+			clock:         clk,
+			lastRefreshed: clk.Now(),
+		},
+		issuer:   issuer,
+		audience: audience,
+	}, nil
+}
+
+func (s *jwksSource) parse(tokenBytes []byte, opts ...jwt.ParseOption) (jwt.Token, error) {
+	token, err := s.parseWithCachedSet(tokenBytes, opts...)
+	if err == nil || jwt.IsValidationError(err) || !s.claimRefresh() {
+		return token, err
+	}
+
+	// The signature didn't verify against any cached key; the issuer may have rotated keys
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, refreshErr := s.cache.Refresh(ctx, s.url); refreshErr != nil {
+		return nil, fmt.Errorf("failed to refresh JWKS: %w", refreshErr)
+	}
+
+	return s.parseWithCachedSet(tokenBytes, opts...)
+}
+
+func (s *jwksSource) parseWithCachedSet(tokenBytes []byte, opts ...jwt.ParseOption) (jwt.Token, error) {
+	set := jwk.NewCachedSet(s.cache, s.url)
+	return jwt.Parse(tokenBytes, append(opts, jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true)))...)
+}
+
+// claimRefresh reports whether an on-demand refresh is allowed now, and records it if so
+func (s *jwksSource) claimRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastRefreshed) < s.minRefresh {
+		return false
+	}
+	s.lastRefreshed = now
+	return true
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+// ValidateRequests checks path and query parameters, headers and JSON bodies against the
+// operation doc describes, so malformed requests get the same 400 VALIDATION problem whichever
+// handler they were meant for. Bodies past MaxBodySize are still 413; bodies that are not JSON
+// are 415, and a body without a Content-Type is taken to be JSON. An operation declaring only
+// other media types, such as NDJSON or any (*/*) for uploads, takes those instead and parses
+// its body itself, so only its parameters are checked here. Requests doc has no operation
+// for pass through, to be answered by the router. Authentication is left to the auth middleware.
+func ValidateRequests(doc *openapi3.T) (func(http.Handler) http.Handler, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		// Handlers apply their own defaults; the body they decode is the one the client sent
+		SkipSettingDefaults: true,
+	}
+	rawBodyOptions := *options
+	rawBodyOptions.ExcludeRequestBody = true
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			validation := options
+			if body := route.Operation.RequestBody; body != nil && body.Value.Content["application/json"] == nil {
+				mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if body.Value.Content.Get(mediaType) == nil {
+					problem.Error(w, r, "Request body must be "+strings.Join(mediaTypes(body.Value.Content), " or "), http.StatusUnsupportedMediaType)
+					return
+				}
+				validation = &rawBodyOptions
+			} else if body != nil && r.ContentLength != 0 {
+				contentType := r.Header.Get("Content-Type")
+				if contentType == "" {
+					r.Header.Set("Content-Type", "application/json")
+				} else if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" {
+					problem.Error(w, r, "Request body must be application/json", http.StatusUnsupportedMediaType)
+					return
+				}
+			}
+
+			err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    validation,
+			})
+			if err != nil {
+				writeValidationError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func mediaTypes(content openapi3.Content) []string {
+	types := make([]string, 0, len(content))
+	for mediaType := range content {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// writeValidationError answers a request that failed validation in the terms decodeJSON uses
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Error(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", err.Error())
+		return
+	}
+
+	var schemaErr *openapi3.SchemaError
+	hasSchemaErr := errors.As(reqErr.Err, &schemaErr)
+	switch {
+	case reqErr.Parameter != nil:
+		reason := reqErr.Err.Error()
+		if hasSchemaErr {
+			reason = schemaErr.Reason
+		}
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", reqErr.Parameter.Name+" "+reqErr.Parameter.In+" parameter: "+reason)
+	case reqErr.RequestBody != nil && hasSchemaErr:
+		detail := schemaErr.Reason
+		if field := strings.Join(schemaErr.JSONPointer(), "."); field != "" {
+			if schemaErr.SchemaField == "required" {
+				detail = field + " is required"
+			} else {
+				detail = field + ": " + detail
+			}
+		}
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", detail)
+	case reqErr.RequestBody != nil:
+		problem.Error(w, r, "Invalid request body", http.StatusBadRequest)
+	default:
+		problem.Write(w, r, http.StatusBadRequest, "VALIDATION", reqErr.Error())
+	}
+}
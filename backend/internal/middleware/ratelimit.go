@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"container/list"
+	"context"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 )
 
 // TokenBucket implements a simple token bucket rate limiter
@@ -12,15 +17,20 @@ type TokenBucket struct {
 	tokens     int
 	refillRate time.Duration
 	lastRefill time.Time
+	clock      clock.Clock
 	mu         sync.Mutex
 }
 
-func NewTokenBucket(capacity int, refillRate time.Duration) *TokenBucket {
+func NewTokenBucket(capacity int, refillRate time.Duration, clk clock.Clock) *TokenBucket {
+	if refillRate <= 0 {
+		refillRate = time.Nanosecond // Allow divides by it
+	}
 	return &TokenBucket{
 		capacity:   capacity,
 		tokens:     capacity,
 		refillRate: refillRate,
-		lastRefill: time.Now(),
+		lastRefill: clk.Now(),
+		clock:      clk,
 	}
 }
 
@@ -28,7 +38,7 @@ func (tb *TokenBucket) Allow() bool {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	now := time.Now()
+	now := tb.clock.Now()
 	elapsed := now.Sub(tb.lastRefill)
 
 	// Refill tokens based on elapsed time
@@ -53,35 +63,109 @@ func min(a, b int) int {
 	return b
 }
 
-// RateLimiter manages rate limits per user
+// RateLimiter manages rate limits per user (or per API key). Buckets idle for longer than
+// idleTTL are dropped by RunCleanup; a dropped bucket would have refilled by then anyway, so
+// limits are unaffected as long as idleTTL covers a full refill. At most maxEntries buckets
+// are kept: past that the least recently used is evicted, so a flood of unseen keys costs
+// bounded memory and at worst hands an evicted key a fresh burst.
 type RateLimiter struct {
-	buckets map[string]*TokenBucket
-	mu      sync.RWMutex
+	clock      clock.Clock
+	idleTTL    time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	order   *list.List // front is most recently used
 }
 
-func NewRateLimiter() *RateLimiter {
+type limiterEntry struct {
+	key      string
+	bucket   *TokenBucket
+	lastUsed time.Time
+}
+
+func NewRateLimiter(clk clock.Clock, idleTTL time.Duration, maxEntries int) *RateLimiter {
 	return &RateLimiter{
-		buckets: make(map[string]*TokenBucket),
+		clock:      clk,
+		idleTTL:    idleTTL,
+		maxEntries: maxEntries,
+		buckets:    make(map[string]*list.Element),
+		order:      list.New(),
 	}
 }
 
 func (rl *RateLimiter) Allow(userID string) bool {
-	rl.mu.RLock()
-	bucket, exists := rl.buckets[userID]
-	rl.mu.RUnlock()
-
-	if !exists {
-		rl.mu.Lock()
-		// Double-check in case another goroutine created it
-		if bucket, exists = rl.buckets[userID]; !exists {
-			// 10 messages per 5 seconds
-			bucket = NewTokenBucket(10, 500*time.Millisecond)
-			rl.buckets[userID] = bucket
+	// 10 messages per 5 seconds
+	return rl.bucket(userID, 10, 500*time.Millisecond).Allow()
+}
+
+// AllowPerMinute is Allow with a caller-chosen budget, e.g. an API key's own limit; a budget
+// of 0 or less means no limit. The bucket is sized on first use for a key.
+func (rl *RateLimiter) AllowPerMinute(key string, perMinute int) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	return rl.bucket(key, perMinute, time.Minute/time.Duration(perMinute)).Allow()
+}
+
+// bucket returns key's bucket, creating it with the given size if needed, and marks it used
+func (rl *RateLimiter) bucket(key string, capacity int, refillRate time.Duration) *TokenBucket {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock.Now()
+	if elem, ok := rl.buckets[key]; ok {
+		entry := elem.Value.(*limiterEntry)
+		entry.lastUsed = now
+		rl.order.MoveToFront(elem)
+		return entry.bucket
+	}
+
+	entry := &limiterEntry{key: key, bucket: NewTokenBucket(capacity, refillRate, rl.clock), lastUsed: now}
+	rl.buckets[key] = rl.order.PushFront(entry)
+	for rl.maxEntries > 0 && rl.order.Len() > rl.maxEntries {
+		rl.remove(rl.order.Back())
+	}
+	return entry.bucket
+}
+
+// RunCleanup drops idle buckets every interval until ctx is cancelled
+func (rl *RateLimiter) RunCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || rl.idleTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.expire()
 		}
-		rl.mu.Unlock()
 	}
+}
 
-	return bucket.Allow()
+// expire removes buckets unused for idleTTL, oldest first
+func (rl *RateLimiter) expire() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := rl.clock.Now().Add(-rl.idleTTL)
+	for elem := rl.order.Back(); elem != nil; elem = rl.order.Back() {
+		if elem.Value.(*limiterEntry).lastUsed.After(cutoff) {
+			return
+		}
+		rl.remove(elem)
+	}
+}
+
+// remove drops a bucket. Callers must hold rl.mu.
+func (rl *RateLimiter) remove(elem *list.Element) {
+	rl.order.Remove(elem)
+	delete(rl.buckets, elem.Value.(*limiterEntry).key)
 }
 
 // MessageRateLimitMiddleware creates a rate limiting middleware for message endpoints
@@ -90,16 +174,16 @@ func MessageRateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Ha
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			userID, ok := GetUserIDFromContext(r.Context())
 			if !ok {
-				http.Error(w, "User ID not found", http.StatusUnauthorized)
+				problem.Error(w, r, "User ID not found", http.StatusUnauthorized)
 				return
 			}
 
 			if !limiter.Allow(userID) {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				problem.Error(w, r, "Rate limit exceeded", http.StatusTooManyRequests)
 				return
 			}
 
 			next.ServeHTTP(w, r)
 		})
 	}
-}
\ No newline at end of file
+}
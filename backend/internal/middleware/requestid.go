@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestID adopts the caller's X-Request-ID when it is well formed, or assigns one, stores it
+// in the request context and echoes it on the response. It is also set under chi's key so the
+// access log shows it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.OrNew(r.Header.Get(requestid.Header))
+
+		ctx := requestid.NewContext(r.Context(), id)
+		ctx = context.WithValue(ctx, chimiddleware.RequestIDKey, id)
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// WorkspaceResolver maps the value of a user token's workspace claim to the workspace it names
+type WorkspaceResolver interface {
+	Claim() string
+	ForClaim(ctx context.Context, claim string) (string, error)
+}
+
+// WithTenant adds the workspace a user token names to ctx, so storage is routed to it when
+// tenants are isolated. API key contexts already carry their key's workspace.
+func WithTenant(ctx context.Context, resolver WorkspaceResolver) (context.Context, error) {
+	if _, isKey := GetAPIKeyIDFromContext(ctx); isKey {
+		return ctx, nil
+	}
+	workspaceID, err := resolver.ForClaim(ctx, GetTokenClaimFromContext(ctx, resolver.Claim()))
+	if err != nil {
+		return ctx, err
+	}
+	return tenant.NewContext(ctx, workspaceID), nil
+}
+
+// TenantMiddleware serves each authenticated request from its caller's workspace. It goes after
+// the auth middleware.
+func TenantMiddleware(resolver WorkspaceResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := WithTenant(r.Context(), resolver)
+			if err != nil {
+				problem.Error(w, r, "Unknown workspace", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -1,25 +1,680 @@
 package models
 
 import (
+	"encoding/json"
+	"strconv"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // User represents a user in the system
 type User struct {
+	ID          string   `bson:"_id" json:"id"`
+	WorkspaceID string   `bson:"workspaceId" json:"workspaceId"` // fixed when the user is first seen
+	Email       string   `bson:"email" json:"email" validate:"max=320"`
+	Name        string   `bson:"name" json:"name" validate:"max=200"`
+	Username    string   `bson:"username,omitempty" json:"username,omitempty"` // unique ignoring case; set with PUT /v1/me/username
+	AvatarURL   string   `bson:"avatarUrl,omitempty" json:"avatarUrl,omitempty" validate:"max=2048"`
+	Roles       []string `bson:"roles,omitempty" json:"roles,omitempty"` // workspace-level roles, e.g. "compliance"
+	DND         *DND     `bson:"dnd,omitempty" json:"dnd,omitempty"`
+	// Status is one of the Status* values, set with PUT /v1/me/status; empty is active
+	Status        string `bson:"status,omitempty" json:"status,omitempty"`
+	StatusMessage string `bson:"statusMessage,omitempty" json:"statusMessage,omitempty"`
+	// LastSeenAt is when one of the user's connections was last active, written every LAST_SEEN_INTERVAL
+	LastSeenAt *time.Time `bson:"lastSeenAt,omitempty" json:"lastSeenAt,omitempty"`
+	// DeactivatedAt is when the identity provider deprovisioned the user. Their tokens and API
+	// keys are refused from then on; their messages and memberships are kept.
+	DeactivatedAt *time.Time    `bson:"deactivatedAt,omitempty" json:"deactivatedAt,omitempty"`
+	Provisioning  *Provisioning `bson:"provisioning,omitempty" json:"-"` // set for users provisioned over SCIM
+	CreatedAt     time.Time     `bson:"createdAt" json:"createdAt"`
+}
+
+// Provisioning is how a directory knows a user or group conversation it manages, over SCIM or
+// through the LDAP sync
+type Provisioning struct {
+	Source     string `bson:"source,omitempty"` // ProvisionedByLDAP, or empty for SCIM
+	ExternalID string `bson:"externalId,omitempty"`
+	UserName   string `bson:"userName,omitempty"` // users only
+}
+
+// ProvisionedByLDAP marks users and groups the LDAP sync manages; SCIM leaves them alone
+const ProvisionedByLDAP = "ldap"
+
+// Workspace is an organization hosted by the deployment; it owns its users, conversations and
+// settings, and nothing in it is visible from another workspace
+type Workspace struct {
+	ID   string `bson:"_id" json:"id"`
+	Name string `bson:"name" json:"name"`
+	// DefaultConversationIDs are group conversations every new member is added to
+	DefaultConversationIDs []string `bson:"defaultConversationIds,omitempty" json:"defaultConversationIds,omitempty"`
+	// AllowedEmailDomains limits who may join; empty lets anyone the identity provider admits in
+	AllowedEmailDomains []string `bson:"allowedEmailDomains,omitempty" json:"allowedEmailDomains,omitempty"`
+	// Plan and Entitlements are set by the billing provider; a workspace without a plan has no limits
+	Plan             string       `bson:"plan,omitempty" json:"plan,omitempty"`
+	Entitlements     Entitlements `bson:"entitlements" json:"entitlements"`
+	BillingUpdatedAt *time.Time   `bson:"billingUpdatedAt,omitempty" json:"billingUpdatedAt,omitempty"` // when the applied billing event occurred
+	// SCIMTokenHash authenticates the workspace's identity provider on /scim/v2; absent disables SCIM
+	SCIMTokenHash      string     `bson:"scimTokenHash,omitempty" json:"-"`
+	SCIMTokenCreatedAt *time.Time `bson:"scimTokenCreatedAt,omitempty" json:"scimTokenCreatedAt,omitempty"`
+	// AttachmentPolicy narrows the files members may upload; absent allows every safe type
+	AttachmentPolicy *AttachmentPolicy `bson:"attachmentPolicy,omitempty" json:"attachmentPolicy,omitempty"`
+	CreatedAt        time.Time         `bson:"createdAt" json:"createdAt"`
+}
+
+// AttachmentPolicy is a workspace's rules for uploaded files. Types are media types
+// ("application/pdf") or whole families ("image/*"). Executables, scripts, HTML and SVG are
+// refused whatever it says.
+type AttachmentPolicy struct {
+	Allow []string `bson:"allow,omitempty" json:"allow,omitempty"` // empty allows every type not denied
+	Deny  []string `bson:"deny,omitempty" json:"deny,omitempty"`
+	// MaxBytes limits sizes by type or family, the most specific applying; no entry leaves the
+	// deployment's and plan's limits
+	MaxBytes map[string]int64 `bson:"maxBytes,omitempty" json:"maxBytes,omitempty"`
+	// ExplicitImages is what becomes of images the deployment's classifier scores at or above
+	// ExplicitThreshold (0.8 when absent): ExplicitImagesFlag, the default, shares them and
+	// queues them for review, ExplicitImagesBlock withholds them until reviewed, and
+	// ExplicitImagesAllow leaves them unclassified
+	ExplicitImages    string  `bson:"explicitImages,omitempty" json:"explicitImages,omitempty" validate:"oneof=allow|flag|block"`
+	ExplicitThreshold float64 `bson:"explicitThreshold,omitempty" json:"explicitThreshold,omitempty"`
+}
+
+// Explicit image actions
+const (
+	ExplicitImagesAllow = "allow"
+	ExplicitImagesFlag  = "flag"
+	ExplicitImagesBlock = "block"
+)
+
+// SCIMTokenResponse carries a new SCIM bearer token; it is shown only once
+type SCIMTokenResponse struct {
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Entitlements are what a workspace's plan allows; zero means no limit
+type Entitlements struct {
+	MaxGroupMembers int   `bson:"maxGroupMembers" json:"maxGroupMembers"`
+	HistoryDays     int   `bson:"historyDays" json:"historyDays"` // older messages are left out of history and search
+	MaxFileBytes    int64 `bson:"maxFileBytes" json:"maxFileBytes"`
+}
+
+// BillingEvent is what the billing provider posts to /webhooks/billing when a workspace's
+// subscription changes. Entitlements override those of a known plan, and are required for others.
+type BillingEvent struct {
+	ID           string        `json:"id" validate:"required,max=128"`
+	WorkspaceID  string        `json:"workspaceId" validate:"required,max=64"`
+	Plan         string        `json:"plan" validate:"required,max=64"`
+	Entitlements *Entitlements `json:"entitlements"`
+	OccurredAt   time.Time     `json:"occurredAt" validate:"required"`
+}
+
+// DefaultConversationsRequest replaces a workspace's default conversations
+type DefaultConversationsRequest struct {
+	ConversationIDs []string `json:"conversationIds" validate:"max=20"`
+}
+
+// EmailDomainsRequest replaces a workspace's allowed email domains; empty allows any
+type EmailDomainsRequest struct {
+	Domains []string `json:"domains" validate:"max=50"`
+}
+
+// WorkspaceRetentionRequest sets the workspace's default retention; 0 clears it
+type WorkspaceRetentionRequest struct {
+	RetentionDays int `json:"retentionDays" validate:"min=0"`
+}
+
+// MemberRolesRequest replaces a workspace member's roles
+type MemberRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// WorkspaceMembersPage is one page of a workspace's users ordered by ID
+type WorkspaceMembersPage struct {
+	Members    []User `json:"members"`
+	NextCursor string `json:"nextCursor,omitempty"` // pass as cursor for the next page
+}
+
+// CreateWorkspaceRequest creates a workspace; ID is what tokens name in the workspace claim
+type CreateWorkspaceRequest struct {
+	ID   string `json:"id" validate:"required,max=64"`
+	Name string `json:"name" validate:"required,max=200"`
+}
+
+// IPBan refuses every request from an address or network, until it expires if it has an expiry.
+// Operators manage the list; CIDR is stored in canonical form, a single address as /32 or /128.
+type IPBan struct {
+	ID        string     `bson:"_id" json:"id"`
+	CIDR      string     `bson:"cidr" json:"cidr"`
+	Reason    string     `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+// CreateIPBanRequest bans an address ("203.0.113.7") or network ("203.0.113.0/24"), for
+// DurationMinutes if set and otherwise until the ban is deleted
+type CreateIPBanRequest struct {
+	CIDR            string `json:"cidr" validate:"required,max=64"`
+	Reason          string `json:"reason,omitempty" validate:"max=200"`
+	DurationMinutes int    `json:"durationMinutes,omitempty" validate:"min=0,max=525600"`
+}
+
+// ChallengeStatus tells a client whether the caller must solve a CAPTCHA before sending, and
+// how to show one. Provider is "off" when challenges are disabled.
+type ChallengeStatus struct {
+	Provider string `json:"provider"`
+	SiteKey  string `json:"siteKey,omitempty"`
+	Required bool   `json:"required"`
+}
+
+// SolveChallengeRequest carries the token the provider's widget issued for a solved CAPTCHA
+type SolveChallengeRequest struct {
+	Token string `json:"token" validate:"required,max=4096"`
+}
+
+// WorkspaceUsage is a workspace's metered use in one calendar month (UTC). Messages are counted
+// as they are sent; attachment storage and active users are measured every USAGE_REFRESH_INTERVAL.
+type WorkspaceUsage struct {
+	ID              string     `bson:"_id" json:"-"` // "<workspace>:<period>"
+	WorkspaceID     string     `bson:"workspaceId" json:"workspaceId"`
+	Period          string     `bson:"period" json:"period"` // YYYY-MM
+	Messages        int64      `bson:"messages" json:"messages"`
+	AttachmentBytes int64      `bson:"attachmentBytes" json:"attachmentBytes"`
+	ActiveUsers     int64      `bson:"activeUsers" json:"activeUsers"` // users last seen in the period
+	MeasuredAt      *time.Time `bson:"measuredAt,omitempty" json:"measuredAt,omitempty"`
+}
+
+// Quotas are the deployment's per-workspace limits; zero means no limit
+type Quotas struct {
+	MessagesPerMonth int64 `json:"messagesPerMonth"`
+	AttachmentBytes  int64 `json:"attachmentBytes"`
+	ActiveUsers      int64 `json:"activeUsers"`
+}
+
+// UsageReport answers GET /admin/v1/usage
+type UsageReport struct {
+	Period     string           `json:"period"`
+	Quotas     Quotas           `json:"quotas"`
+	Workspaces []WorkspaceUsage `json:"workspaces"`
+}
+
+// User statuses
+const (
+	StatusActive    = "active"
+	StatusAway      = "away"
+	StatusBusy      = "busy"
+	StatusInvisible = "invisible" // shown to others as offline, with no status
+)
+
+// Public returns the user as other users see them: an invisible user's status is hidden
+func (u *User) Public() *User {
+	if u.Status != StatusInvisible {
+		return u
+	}
+	public := *u
+	public.Status = ""
+	public.StatusMessage = ""
+	public.LastSeenAt = nil
+	return &public
+}
+
+// UpdateUsernameRequest is the body of PUT /v1/me/username
+type UpdateUsernameRequest struct {
+	Username string `json:"username" validate:"required"`
+}
+
+// UsernameAvailability answers GET /v1/usernames/{username}
+type UsernameAvailability struct {
+	Username  string `json:"username"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"` // why not: invalid, reserved or taken
+}
+
+// UsernameChange records one change of a user's handle
+type UsernameChange struct {
 	ID        string    `bson:"_id" json:"id"`
-	Email     string    `bson:"email" json:"email"`
-	Name      string    `bson:"name" json:"name"`
-	AvatarURL string    `bson:"avatarUrl,omitempty" json:"avatarUrl,omitempty"`
-	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UserID    string    `bson:"userId" json:"userId"`
+	From      string    `bson:"from,omitempty" json:"from,omitempty"` // empty for the first handle
+	To        string    `bson:"to" json:"to"`
+	ChangedAt time.Time `bson:"changedAt" json:"changedAt"`
+}
+
+// UpdateStatusRequest is the body of PUT /v1/me/status
+type UpdateStatusRequest struct {
+	Status  string `json:"status" validate:"required,oneof=active|away|busy|invisible"`
+	Message string `json:"message,omitempty" validate:"max=140"`
+}
+
+// DND is a user's do-not-disturb schedule. Notifications due inside a window are held back
+// and summarised when it ends.
+type DND struct {
+	TimeZone string      `bson:"timeZone" json:"timeZone"` // IANA name; UTC if empty
+	Windows  []DNDWindow `bson:"windows" json:"windows"`
+}
+
+// DNDWindow is a daily window, which may wrap past midnight. A wrapping window belongs to the
+// day it starts on.
+type DNDWindow struct {
+	Days  []string `bson:"days,omitempty" json:"days,omitempty"` // "mon" to "sun"; every day if empty
+	Start string   `bson:"start" json:"start"`                   // "HH:MM"
+	End   string   `bson:"end" json:"end"`                       // "HH:MM"
+}
+
+// UsersResponse lists the users a batch lookup found, in the order asked for
+type UsersResponse struct {
+	Users []User `json:"users"`
+}
+
+// Workspace-level user roles
+const (
+	RoleCompliance     = "compliance"
+	RoleWorkspaceAdmin = "workspace_admin"
+)
+
+// Conversation-level participant roles
+const (
+	ConversationRoleAdmin     = "admin"
+	ConversationRoleModerator = "moderator"
+	ConversationRoleMember    = "member"
+)
+
+// HasRole reports whether the user holds the given workspace-level role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
 // Conversation represents a chat conversation
 type Conversation struct {
 	ID            string    `bson:"_id" json:"id"`
-	Kind          string    `bson:"kind" json:"kind"` // "dm" or "group"
+	WorkspaceID   string    `bson:"workspaceId" json:"workspaceId"` // the creator's; every participant belongs to it
+	Kind          string    `bson:"kind" json:"kind"`               // "dm" or "group"
 	Title         string    `bson:"title,omitempty" json:"title,omitempty"`
 	CreatedAt     time.Time `bson:"createdAt" json:"createdAt"`
 	LastMessageAt time.Time `bson:"lastMessageAt" json:"lastMessageAt"`
+	MessageSeq    int64     `bson:"messageSeq,omitempty" json:"messageSeq,omitempty"` // seq of the latest message
+
+	// RetentionDays overrides the workspace default; 0 means no override
+	RetentionDays    int                     `bson:"retentionDays,omitempty" json:"retentionDays,omitempty"`
+	PendingRetention *PendingRetentionChange `bson:"pendingRetention,omitempty" json:"pendingRetention,omitempty"`
+
+	// Bots lists the API keys allowed to act in this conversation; keys not listed are refused
+	Bots []ConversationBot `bson:"bots,omitempty" json:"bots,omitempty"`
+
+	// Settings overrides the workspace defaults for this conversation (retention excepted, see RetentionDays)
+	Settings *Settings `bson:"settings,omitempty" json:"settings,omitempty"`
+
+	// Policies say who may send messages, add members, pin messages and change the title:
+	// PolicyEveryone, PolicyModerators or PolicyAdmins. Stored empty means the default, which
+	// FillPolicyDefaults sets before a conversation is used or sent to clients.
+	PostingPolicy  string `bson:"postingPolicy,omitempty" json:"postingPolicy,omitempty"`
+	InvitePolicy   string `bson:"invitePolicy,omitempty" json:"invitePolicy,omitempty"`
+	PinPolicy      string `bson:"pinPolicy,omitempty" json:"pinPolicy,omitempty"`
+	MetadataPolicy string `bson:"metadataPolicy,omitempty" json:"metadataPolicy,omitempty"`
+
+	// Pins are the conversation's pinned messages, oldest first
+	Pins []Pin `bson:"pins,omitempty" json:"pins,omitempty"`
+
+	// Lock is set while an admin has frozen posting
+	Lock *ConversationLock `bson:"lock,omitempty" json:"lock,omitempty"`
+
+	// SlowModeSeconds is the effective slow mode interval, from Settings or the workspace's
+	// defaults; it is filled in for conversation.updated and not stored
+	SlowModeSeconds int `bson:"-" json:"slowModeSeconds,omitempty"`
+
+	// Provisioning is set on groups the identity provider manages over SCIM; their membership
+	// follows the provider's group, so it has no admins
+	Provisioning *Provisioning `bson:"provisioning,omitempty" json:"-"`
+}
+
+// Conversation policy levels: who a policy lets act. Moderators includes admins. An
+// admins-only posting policy makes a broadcast conversation: members read, admins post.
+const (
+	PolicyEveryone   = "everyone"
+	PolicyModerators = "moderators"
+	PolicyAdmins     = "admins"
+)
+
+// FillPolicyDefaults sets the policies left empty to their defaults: everyone posts and pins,
+// and admins add members and change the title
+func (c *Conversation) FillPolicyDefaults() {
+	if c.PostingPolicy == "" {
+		c.PostingPolicy = PolicyEveryone
+	}
+	if c.InvitePolicy == "" {
+		c.InvitePolicy = PolicyAdmins
+	}
+	if c.PinPolicy == "" {
+		c.PinPolicy = PolicyEveryone
+	}
+	if c.MetadataPolicy == "" {
+		c.MetadataPolicy = PolicyAdmins
+	}
+}
+
+// Pin is a message pinned in a conversation
+type Pin struct {
+	MessageID int64     `bson:"messageId" json:"messageId"`
+	PinnedBy  string    `bson:"pinnedBy" json:"pinnedBy"`
+	PinnedAt  time.Time `bson:"pinnedAt" json:"pinnedAt"`
+}
+
+// ConversationLock records who locked a conversation, when and why. Until it is lifted only
+// participants allowed to post in locked conversations can send messages.
+type ConversationLock struct {
+	LockedBy string    `bson:"lockedBy" json:"lockedBy"`
+	LockedAt time.Time `bson:"lockedAt" json:"lockedAt"`
+	Reason   string    `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// PinnedMessage is a pin with its message, for GET /v1/conversations/{id}/pins. Message is
+// absent once the message is gone, such as after retention removed it.
+type PinnedMessage struct {
+	Pin
+	Message *Message `json:"message,omitempty"`
+}
+
+// PinsResponse lists a conversation's pinned messages, oldest pin first
+type PinsResponse struct {
+	Pins []PinnedMessage `json:"pins"`
+}
+
+// Notification levels
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNone     = "none"
+)
+
+// Settings is one level of the settings cascade: workspace defaults, then conversation
+// overrides, then user preferences. A nil field is not set at that level and is inherited.
+type Settings struct {
+	RetentionDays   *int    `bson:"retentionDays,omitempty" json:"retentionDays,omitempty"`     // workspace only; conversations use the retention endpoints
+	SlowModeSeconds *int    `bson:"slowModeSeconds,omitempty" json:"slowModeSeconds,omitempty"` // workspace and conversation
+	ReadReceipts    *bool   `bson:"readReceipts,omitempty" json:"readReceipts,omitempty"`
+	Notifications   *string `bson:"notifications,omitempty" json:"notifications,omitempty"` // see Notify* levels
+}
+
+// SettingsDocument stores the workspace defaults or one user's preferences
+type SettingsDocument struct {
+	ID        string    `bson:"_id" json:"-"` // "workspace" or "user:<userId>"
+	Settings  Settings  `bson:"settings" json:"settings"`
+	UpdatedBy string    `bson:"updatedBy" json:"updatedBy"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// EffectiveSettings is the outcome of the settings cascade for a conversation and user
+type EffectiveSettings struct {
+	ConversationID  string `json:"conversationId,omitempty"`
+	UserID          string `json:"userId,omitempty"`
+	RetentionDays   int    `json:"retentionDays"` // 0 keeps messages indefinitely
+	SlowModeSeconds int    `json:"slowModeSeconds"`
+	ReadReceipts    bool   `json:"readReceipts"`
+	Notifications   string `json:"notifications"`
+	// Sources names the level each value came from: "default", "workspace", "conversation" or "user"
+	Sources map[string]string `json:"sources"`
+}
+
+// NotificationSettings are a user's notification preferences. They narrow, never widen, the
+// notifications level the settings cascade resolves for each conversation.
+type NotificationSettings struct {
+	UserID       string      `bson:"_id" json:"userId"`
+	Push         bool        `bson:"push" json:"push"`
+	Email        bool        `bson:"email" json:"email"`
+	MentionsOnly bool        `bson:"mentionsOnly" json:"mentionsOnly"`
+	QuietHours   *QuietHours `bson:"quietHours,omitempty" json:"quietHours,omitempty"`
+	UpdatedAt    time.Time   `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// QuietHours is a daily window, which may wrap past midnight, with no notifications
+type QuietHours struct {
+	Start    string `bson:"start" json:"start"`       // "HH:MM"
+	End      string `bson:"end" json:"end"`           // "HH:MM"
+	TimeZone string `bson:"timeZone" json:"timeZone"` // IANA name; UTC if empty
+}
+
+// AwaySummary counts the notifications held back during a do-not-disturb window
+type AwaySummary struct {
+	UserID        string             `bson:"_id" json:"userId"`
+	From          time.Time          `bson:"from" json:"from"` // first held-back notification
+	To            time.Time          `bson:"to" json:"to"`     // when the summary was made
+	Conversations []AwayConversation `bson:"conversations" json:"conversations"`
+}
+
+// Badge totals what a user has not read across their conversations, for app-icon badges
+type Badge struct {
+	UnreadMessages      int       `json:"unreadMessages"`
+	UnreadConversations int       `json:"unreadConversations"`
+	UnreadMentions      int       `json:"unreadMentions"`
+	GeneratedAt         time.Time `json:"generatedAt"`
+}
+
+// AwayConversation is one conversation's share of an AwaySummary
+type AwayConversation struct {
+	ConversationID string `bson:"conversationId" json:"conversationId"`
+	Count          int    `bson:"count" json:"count"`
+	Mentions       int    `bson:"mentions" json:"mentions"`
+}
+
+// ConversationBot is an API key (bot or integration) on a conversation's allow-list
+type ConversationBot struct {
+	APIKeyID string    `bson:"apiKeyId" json:"apiKeyId"`
+	Name     string    `bson:"name" json:"name"`
+	CanRead  bool      `bson:"canRead" json:"canRead"`
+	CanPost  bool      `bson:"canPost" json:"canPost"`
+	AddedBy  string    `bson:"addedBy" json:"addedBy"`
+	AddedAt  time.Time `bson:"addedAt" json:"addedAt"`
+}
+
+// Bot access levels checked against a conversation's allow-list
+const (
+	BotAccessRead = "read"
+	BotAccessPost = "post"
+)
+
+// PendingRetentionChange is a retention shortening awaiting compliance approval
+type PendingRetentionChange struct {
+	RetentionDays int       `bson:"retentionDays" json:"retentionDays"`
+	RequestedBy   string    `bson:"requestedBy" json:"requestedBy"`
+	RequestedAt   time.Time `bson:"requestedAt" json:"requestedAt"`
+}
+
+// ConversationRetention describes a conversation's retention settings for API responses
+type ConversationRetention struct {
+	ConversationID string                  `json:"conversationId"`
+	RetentionDays  int                     `json:"retentionDays"` // 0 means the workspace default applies
+	EffectiveDays  int                     `json:"effectiveDays"` // 0 means messages are kept indefinitely
+	MinDays        int                     `json:"minDays"`       // workspace policy lower bound
+	MaxDays        int                     `json:"maxDays"`       // workspace policy upper bound
+	Pending        *PendingRetentionChange `json:"pending,omitempty"`
+}
+
+// AuditEntry records a privileged or compliance-relevant change
+type AuditEntry struct {
+	ID             string                 `bson:"_id" json:"id"`
+	Action         string                 `bson:"action" json:"action"`
+	ActorID        string                 `bson:"actorId" json:"actorId"`
+	ConversationID string                 `bson:"conversationId,omitempty" json:"conversationId,omitempty"`
+	Details        map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt      time.Time              `bson:"createdAt" json:"createdAt"`
+}
+
+// WatchGrant gives a compliance user temporary read-only access to a conversation
+// for an investigation. Watchers are not participants and never appear in member lists.
+type WatchGrant struct {
+	ID             string     `bson:"_id" json:"id"`
+	ConversationID string     `bson:"conversationId" json:"conversationId"`
+	UserID         string     `bson:"userId" json:"userId"`
+	GrantedBy      string     `bson:"grantedBy" json:"grantedBy"`
+	Reason         string     `bson:"reason" json:"reason"`
+	ExpiresAt      time.Time  `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	RevokedAt      *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// APIKey lets a service or bot call the REST API as UserID without a user JWT.
+// Only the SHA-256 hash of the key is stored; the key itself is shown once at creation.
+type APIKey struct {
+	ID                 string     `bson:"_id" json:"id"`
+	Name               string     `bson:"name" json:"name"`
+	Prefix             string     `bson:"prefix" json:"prefix"` // first characters of the key, for identification
+	KeyHash            string     `bson:"keyHash" json:"-"`
+	UserID             string     `bson:"userId" json:"userId"` // principal the key acts as
+	WorkspaceID        string     `bson:"workspaceId" json:"workspaceId"`
+	Scopes             []string   `bson:"scopes" json:"scopes"`
+	RateLimitPerMinute int        `bson:"rateLimitPerMinute" json:"rateLimitPerMinute"`
+	CreatedBy          string     `bson:"createdBy" json:"createdBy"`
+	CreatedAt          time.Time  `bson:"createdAt" json:"createdAt"`
+	RevokedAt          *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Session is one of a user's open WebSocket connections, on any node
+type Session struct {
+	ID          string    `json:"id"`
+	Device      string    `json:"device"` // the ?device= (x-device for gRPC) given at connect, else the User-Agent
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// API key scopes
+const (
+	ScopeConversationsRead  = "conversations:read"
+	ScopeConversationsWrite = "conversations:write"
+	ScopeMessagesRead       = "messages:read"
+	ScopeMessagesWrite      = "messages:write"
+)
+
+// MaxAPIKeyRateLimit caps an API key's requests per minute, a thousand a second
+const MaxAPIKeyRateLimit = 60000
+
+// CreateAPIKeyResponse returns a new key; Key is never retrievable again
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// JournalEntry is the payload delivered to the journaling webhook for each stream event
+type JournalEntry struct {
+	StreamSequence uint64          `json:"streamSequence"`
+	Event          string          `json:"event"` // see nats.EventMessage*
+	ConversationID string          `json:"conversationId"`
+	Subject        string          `json:"subject"`
+	StoredAt       time.Time       `json:"storedAt"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// Notification is the payload delivered to the notification webhook, once per message and
+// recipient that should be notified
+type Notification struct {
+	UserID         string    `json:"userId"`
+	Channels       []string  `json:"channels"` // "push" and/or "email"
+	ConversationID string    `json:"conversationId"`
+	MessageID      int64     `json:"messageId"`
+	SenderID       string    `json:"senderId"`
+	Sender         *User     `json:"sender,omitempty"`
+	Preview        string    `json:"preview"` // the start of the body
+	Mentioned      bool      `json:"mentioned"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// JournalGap records stream sequences that left the stream before they were journaled
+type JournalGap struct {
+	ID            string    `bson:"_id" json:"id"`
+	FromSequence  uint64    `bson:"fromSequence" json:"fromSequence"`
+	ToSequence    uint64    `bson:"toSequence" json:"toSequence"`
+	MissingEvents uint64    `bson:"missingEvents" json:"missingEvents"`
+	DetectedAt    time.Time `bson:"detectedAt" json:"detectedAt"`
+}
+
+// JournalStatus reports the journaling consumer's progress for admins
+type JournalStatus struct {
+	Enabled             bool         `json:"enabled"`
+	LastDeliveredSeq    uint64       `json:"lastDeliveredSequence"`
+	LastDeliveredAt     *time.Time   `json:"lastDeliveredAt,omitempty"`
+	Pending             uint64       `json:"pending"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	LastError           string       `json:"lastError,omitempty"`
+	Gaps                []JournalGap `json:"gaps"`
+}
+
+// PurgeCounts holds per-collection document counts for a workspace purge
+type PurgeCounts struct {
+	Conversations     int64 `bson:"conversations" json:"conversations"`
+	Messages          int64 `bson:"messages" json:"messages"`
+	MessageRevisions  int64 `bson:"message_revisions" json:"messageRevisions"`
+	PollVotes         int64 `bson:"poll_votes" json:"pollVotes"`
+	Calls             int64 `bson:"calls" json:"calls"`
+	Participants      int64 `bson:"participants" json:"participants"`
+	Attachments       int64 `bson:"attachments" json:"attachments"`
+	AttachmentReviews int64 `bson:"attachment_reviews" json:"attachmentReviews"`
+	Users             int64 `bson:"users" json:"users"`
+}
+
+// PurgeJob tracks a workspace purge from dry run through confirmation to completion
+type PurgeJob struct {
+	ID               string      `bson:"_id" json:"id"`
+	Status           string      `bson:"status" json:"status"` // see services.Purge* statuses
+	RequestedBy      string      `bson:"requestedBy" json:"requestedBy"`
+	WorkspaceID      string      `bson:"workspaceId" json:"workspaceId"` // the only workspace the purge touches
+	TokenHash        string      `bson:"tokenHash" json:"-"`
+	TokenExpiresAt   time.Time   `bson:"tokenExpiresAt" json:"tokenExpiresAt"`
+	Estimated        PurgeCounts `bson:"estimated" json:"estimated"`
+	ReclaimableBytes int64       `bson:"reclaimableBytes" json:"reclaimableBytes"`
+	Deleted          PurgeCounts `bson:"deleted" json:"deleted"`
+	Stage            string      `bson:"stage,omitempty" json:"stage,omitempty"`
+	Error            string      `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt        time.Time   `bson:"createdAt" json:"createdAt"`
+	StartedAt        *time.Time  `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	CompletedAt      *time.Time  `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// StreamSettings are the CHAT stream settings a reconfiguration may change
+type StreamSettings struct {
+	Replicas      int   `bson:"replicas" json:"replicas"`
+	MaxBytes      int64 `bson:"maxBytes" json:"maxBytes"`           // -1 for unlimited
+	MaxAgeSeconds int64 `bson:"maxAgeSeconds" json:"maxAgeSeconds"` // 0 keeps messages indefinitely
+}
+
+// StreamPreChecks records what a reconfiguration found before touching the stream
+type StreamPreChecks struct {
+	LaggingConsumer string `bson:"laggingConsumer,omitempty" json:"laggingConsumer,omitempty"`
+	MaxConsumerLag  uint64 `bson:"maxConsumerLag" json:"maxConsumerLag"`
+	StreamBytes     uint64 `bson:"streamBytes" json:"streamBytes"`
+	StoreUsed       uint64 `bson:"storeUsed" json:"storeUsed"`
+	StoreLimit      int64  `bson:"storeLimit" json:"storeLimit"` // -1 for unlimited
+}
+
+// StreamReconfigJob is an admin-requested change to the CHAT stream configuration
+type StreamReconfigJob struct {
+	ID              string           `bson:"_id" json:"id"`
+	Status          string           `bson:"status" json:"status"` // see services.StreamReconfig* statuses
+	RequestedBy     string           `bson:"requestedBy" json:"requestedBy"`
+	Target          StreamSettings   `bson:"target" json:"target"`
+	Previous        *StreamSettings  `bson:"previous,omitempty" json:"previous,omitempty"`
+	Checks          *StreamPreChecks `bson:"checks,omitempty" json:"checks,omitempty"`
+	AppliedReplicas int              `bson:"appliedReplicas,omitempty" json:"appliedReplicas,omitempty"`
+	Error           string           `bson:"error,omitempty" json:"error,omitempty"`
+	ScheduledFor    time.Time        `bson:"scheduledFor" json:"scheduledFor"`
+	CreatedAt       time.Time        `bson:"createdAt" json:"createdAt"`
+	StartedAt       *time.Time       `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	CompletedAt     *time.Time       `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// OrphanRepairReport counts what an orphan repair deleted
+type OrphanRepairReport struct {
+	Participants  int64 `json:"participants"`
+	Conversations int64 `json:"conversations"`
+	Messages      int64 `json:"messages"`
+}
+
+// PurgeDryRunResponse reports what a purge would delete, with the token needed to confirm it
+type PurgeDryRunResponse struct {
+	*PurgeJob
+	ConfirmationToken string `json:"confirmationToken"`
 }
 
 // ConversationWithParticipants represents a conversation with populated participant info for API responses
@@ -29,19 +684,167 @@ type ConversationWithParticipants struct {
 	Title         string    `json:"title,omitempty"`
 	CreatedAt     time.Time `json:"createdAt"`
 	LastMessageAt time.Time `json:"lastMessageAt"`
-	Participants  []User    `json:"participants"`
+
+	PostingPolicy  string `json:"postingPolicy,omitempty"`
+	InvitePolicy   string `json:"invitePolicy,omitempty"`
+	PinPolicy      string `json:"pinPolicy,omitempty"`
+	MetadataPolicy string `json:"metadataPolicy,omitempty"`
+	// Role is the caller's role here, which with the policies tells clients what to offer them
+	Role string `json:"role,omitempty"`
+
+	Lock *ConversationLock `json:"lock,omitempty"`
+	// SlowModeSeconds is the effective minimum interval between one member's messages; admins
+	// and moderators are exempt
+	SlowModeSeconds int `json:"slowModeSeconds,omitempty"`
+
+	Participants []User `json:"participants"`
+}
+
+// ConversationListSnapshot is a user's assembled conversation list plus its freshness
+type ConversationListSnapshot struct {
+	Conversations []ConversationWithParticipants
+	GeneratedAt   time.Time
+	Cached        bool
+}
+
+// MembershipEvent is published on chat.conv.<id>.members when the set of participants changes
+type MembershipEvent struct {
+	ConversationID string   `json:"conversationId"`
+	UserIDs        []string `json:"userIds"`
+}
+
+// Conversation lifecycle events, each sent to the users concerned as a frame of the same name
+const (
+	ConversationCreated = "conversation.created"
+	ConversationUpdated = "conversation.updated"
+	ConversationDeleted = "conversation.deleted"
+	MemberAdded         = "member.added"
+	MemberRemoved       = "member.removed"
+	MemberRoleChanged   = "member.role_changed"
+	MemberMuted         = "member.muted"
+	MemberUnmuted       = "member.unmuted"
+)
+
+// WSConversationEventData tells users that a conversation entered, left or changed in their
+// conversation list, sent as the frame named by Event. It goes out on chat.users.conversations
+// with the users to tell in Recipients, which clients never see.
+type WSConversationEventData struct {
+	Event          string        `json:"event"`
+	ConversationID string        `json:"conversationId"`
+	Conversation   *Conversation `json:"conversation,omitempty"` // on conversation.created, conversation.updated and member.added
+	UserIDs        []string      `json:"userIds,omitempty"`      // every member when created; otherwise those added, removed, given Role, muted or unmuted
+	Role           string        `json:"role,omitempty"`         // on member.role_changed
+	MutedUntil     *time.Time    `json:"mutedUntil,omitempty"`   // on member.muted
+	ActorID        string        `json:"actorId,omitempty"`
+	Recipients     []string      `json:"recipients,omitempty"`
+}
+
+// ConversationMember is a participant with their user profile, for member lists
+type ConversationMember struct {
+	UserID   string    `json:"userId"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+	User     *User     `json:"user,omitempty"`
+}
+
+// FeedItem is one notable event in a user's activity feed
+type FeedItem struct {
+	ID                string    `json:"id"`   // "<kind>:<conversationId>"
+	Kind              string    `json:"kind"` // see services.Feed* kinds
+	ConversationID    string    `json:"conversationId"`
+	ConversationTitle string    `json:"conversationTitle,omitempty"`
+	OccurredAt        time.Time `json:"occurredAt"`
+	Score             float64   `json:"score"`
+
+	MemberCount  int64  `json:"memberCount,omitempty"`
+	MessageCount int64  `json:"messageCount,omitempty"`
+	SenderCount  int64  `json:"senderCount,omitempty"`
+	Preview      string `json:"preview,omitempty"` // the latest message, shortened
+}
+
+// FeedPage is one page of the activity feed, ranked as of AsOf
+type FeedPage struct {
+	Items      []FeedItem `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	AsOf       time.Time  `json:"asOf"`
+}
+
+// OutboxEntry is a message event waiting to be published to JetStream. It is written in the
+// same transaction as the change it announces, so a crash before publishing only delays delivery.
+type OutboxEntry struct {
+	ID             string          `bson:"_id" json:"id"` // "<messageId>:<event>", plus ":<id>" for message.updated
+	MessageID      int64           `bson:"messageId" json:"messageId"`
+	ConversationID string          `bson:"conversationId" json:"conversationId"`
+	Event          string          `bson:"event" json:"event"` // see nats.EventMessage*
+	MsgID          string          `bson:"msgId" json:"msgId"` // Nats-Msg-Id for JetStream dedup
+	Payload        json.RawMessage `bson:"payload" json:"payload"`
+	RequestID      string          `bson:"requestId,omitempty" json:"requestId,omitempty"` // request that caused the event
+	Sent           bool            `bson:"sent" json:"sent"`
+	Attempts       int             `bson:"attempts" json:"attempts"`
+	LastError      string          `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt      time.Time       `bson:"createdAt" json:"createdAt"`
+	SentAt         *time.Time      `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
 }
 
 // Participant represents a user's participation in a conversation
 type Participant struct {
-	ID                 string    `bson:"_id" json:"id"` // Format: "conversationId:userId"
-	ConversationID     string    `bson:"conversationId" json:"conversationId"`
-	UserID             string    `bson:"userId" json:"userId"`
-	Role               string    `bson:"role" json:"role"` // "member" or "admin"
-	LastReadMessageID  int64     `bson:"lastReadMessageId,omitempty" json:"lastReadMessageId,omitempty"`
-	JoinedAt           time.Time `bson:"joinedAt" json:"joinedAt"`
+	ID                string     `bson:"_id" json:"id"` // Format: "conversationId:userId"
+	ConversationID    string     `bson:"conversationId" json:"conversationId"`
+	UserID            string     `bson:"userId" json:"userId"`
+	Role              string     `bson:"role" json:"role"` // ConversationRoleMember, ConversationRoleModerator or ConversationRoleAdmin
+	LastReadMessageID int64      `bson:"lastReadMessageId,omitempty" json:"lastReadMessageId,omitempty"`
+	LastReadAt        *time.Time `bson:"lastReadAt,omitempty" json:"lastReadAt,omitempty"` // when LastReadMessageID was last moved
+	JoinedAt          time.Time  `bson:"joinedAt" json:"joinedAt"`
+	// Mute keeps the participant from sending until it expires or is lifted
+	Mute *ParticipantMute `bson:"mute,omitempty" json:"mute,omitempty"`
 }
 
+// ParticipantMute is a moderator's temporary mute of one participant. An expired mute may
+// stay on the document; only Until decides whether it is in force.
+type ParticipantMute struct {
+	Until   time.Time `bson:"until" json:"until"`
+	Reason  string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	MutedBy string    `bson:"mutedBy" json:"mutedBy"`
+	MutedAt time.Time `bson:"mutedAt" json:"mutedAt"`
+}
+
+// Muted reports whether the participant's mute is in force at now
+func (p *Participant) Muted(now time.Time) bool {
+	return p.Mute != nil && now.Before(p.Mute.Until)
+}
+
+// ReadReceipt is one participant's read position
+type ReadReceipt struct {
+	UserID            string     `json:"userId"`
+	LastReadMessageID int64      `json:"lastReadMessageId"` // 0 if they have read nothing yet
+	ReadAt            *time.Time `json:"readAt,omitempty"`
+}
+
+// ConversationReceipts lists the read positions of a conversation's participants who share
+// read receipts, plus the caller's own
+type ConversationReceipts struct {
+	ConversationID string        `json:"conversationId"`
+	Receipts       []ReadReceipt `json:"receipts"`
+}
+
+// Message body formats
+const (
+	MessageFormatPlain    = "plain"
+	MessageFormatMarkdown = "markdown" // the subset pkg/markdown renders
+)
+
+// Message types, and the payload each carries. Clients send the payload's request form, and
+// the body is a caption or, for polls, the question.
+const (
+	MessageTypeText     = "text"     // no payload
+	MessageTypeGIF      = "gif"      // Media; sent as MediaRequest
+	MessageTypeSticker  = "sticker"  // Media; sent as MediaRequest
+	MessageTypePoll     = "poll"     // Poll; sent as PollRequest
+	MessageTypeLocation = "location" // Location; sent as LocationRequest
+	MessageTypeFile     = "file"     // File; sent as FileRequest
+	MessageTypeSystem   = "system"   // SystemEvent; posted by the server only
+)
+
 // Message represents a chat message
 type Message struct {
 	ID             int64     `bson:"_id" json:"id"` // Snowflake ID
@@ -50,36 +853,450 @@ type Message struct {
 	ClientMsgID    string    `bson:"clientMsgId" json:"clientMsgId"`
 	Body           string    `bson:"body" json:"body"`
 	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	Seq            int64     `bson:"seq,omitempty" json:"seq,omitempty"` // per-conversation, gapless from 1
+	StreamSeq      uint64    `bson:"streamSeq,omitempty" json:"-"`       // CHAT stream sequence, once published
+
+	// Format is how Body is written (a MessageFormat*; empty means plain). A markdown body is
+	// also stored rendered as sanitized HTML, so every client shows it the same way.
+	Format string `bson:"format,omitempty" json:"format,omitempty"`
+	HTML   string `bson:"html,omitempty" json:"html,omitempty"`
+
+	// RetractableUntil ends the undo-send window; until then the sender may retract the message
+	// and push notifications must hold it back
+	RetractableUntil *time.Time `bson:"retractableUntil,omitempty" json:"retractableUntil,omitempty"`
+	RetractedAt      *time.Time `bson:"retractedAt,omitempty" json:"-"`
+
+	// Preview is attached by the unfurler once the first link in the body has been fetched.
+	// UnfurlURL is that link while it waits, and UnfurlLeaseUntil keeps other nodes off it
+	// while one fetches it.
+	Preview          *LinkPreview `bson:"preview,omitempty" json:"preview,omitempty"`
+	UnfurlURL        string       `bson:"unfurlUrl,omitempty" json:"-"`
+	UnfurlLeaseUntil *time.Time   `bson:"unfurlLeaseUntil,omitempty" json:"-"`
+
+	// Emoji maps the custom :shortcodes: in Body to the IDs of their images, as they resolved
+	// when the message was sent
+	Emoji map[string]string `bson:"emoji,omitempty" json:"emoji,omitempty"`
+
+	// Mentions lists the participants @mentioned in Body, by user ID, as resolved when sent
+	Mentions []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+
+	// Type is a MessageType*; empty means text. Payload is the type's content, stored as the
+	// type's payload struct; only the code for that type reads it; everything else passes it on.
+	Type    string   `bson:"type,omitempty" json:"type,omitempty"`
+	Payload bson.Raw `bson:"payload,omitempty" json:"-"`
+}
+
+// SystemEvent is the payload of a system message, which the server posts to record an event
+// in the conversation. The body describes the event for clients that do not know it.
+type SystemEvent struct {
+	Event           string `bson:"event" json:"event"` // a SystemEvent* kind
+	CallID          string `bson:"callId,omitempty" json:"callId,omitempty"`
+	CallMedia       string `bson:"callMedia,omitempty" json:"callMedia,omitempty"`
+	CallOutcome     string `bson:"callOutcome,omitempty" json:"callOutcome,omitempty"`
+	DurationSeconds int    `bson:"durationSeconds,omitempty" json:"durationSeconds,omitempty"`
+	Reason          string `bson:"reason,omitempty" json:"reason,omitempty"` // why a conversation was locked
+}
+
+// System message events
+const (
+	SystemEventCallEnded            = "call.ended"
+	SystemEventConversationLocked   = "conversation.locked"
+	SystemEventConversationUnlocked = "conversation.unlocked"
+)
+
+// Call is a record of an audio or video call in a conversation. Calls are placed and carried
+// by clients; the service keeps their history.
+type Call struct {
+	ID              string     `bson:"_id" json:"id"` // ULID, so IDs sort by start
+	ConversationID  string     `bson:"conversationId" json:"conversationId"`
+	CallerID        string     `bson:"callerId" json:"callerId"`
+	CalleeIDs       []string   `bson:"calleeIds" json:"calleeIds"` // the other participants when it started
+	Media           string     `bson:"media" json:"media"`         // audio or video
+	StartedAt       time.Time  `bson:"startedAt" json:"startedAt"`
+	AnsweredAt      *time.Time `bson:"answeredAt,omitempty" json:"answeredAt,omitempty"`
+	AnsweredBy      string     `bson:"answeredBy,omitempty" json:"answeredBy,omitempty"`
+	EndedAt         *time.Time `bson:"endedAt,omitempty" json:"endedAt,omitempty"`
+	Outcome         string     `bson:"outcome,omitempty" json:"outcome,omitempty"` // a CallOutcome*, once ended
+	DurationSeconds int        `bson:"durationSeconds,omitempty" json:"durationSeconds,omitempty"`
+	MessageID       int64      `bson:"messageId,omitempty" json:"messageId,omitempty"` // the system message
+}
+
+// Call outcomes
+const (
+	CallOutcomeCompleted = "completed" // answered, then hung up
+	CallOutcomeMissed    = "missed"    // rang out unanswered
+	CallOutcomeDeclined  = "declined"  // a callee refused it
+	CallOutcomeCancelled = "cancelled" // the caller hung up before an answer
+	CallOutcomeFailed    = "failed"    // could not connect or dropped
+)
+
+// StartCallRequest records a call being placed
+type StartCallRequest struct {
+	Media string `json:"media" validate:"required,oneof=audio|video"`
+}
+
+// EndCallRequest records the end of a call. Without an outcome it is inferred: completed if
+// answered, otherwise cancelled by the caller or declined by a callee.
+type EndCallRequest struct {
+	Outcome string `json:"outcome,omitempty" validate:"oneof=completed|missed|declined|cancelled|failed"`
+}
+
+// Location is a location message's point. A live location starts there; the sender's updates
+// until LiveUntil are only delivered as location.update frames and never stored.
+type Location struct {
+	Latitude  float64    `bson:"latitude" json:"latitude"`
+	Longitude float64    `bson:"longitude" json:"longitude"`
+	Label     string     `bson:"label,omitempty" json:"label,omitempty"`
+	LiveUntil *time.Time `bson:"liveUntil,omitempty" json:"liveUntil,omitempty"`
+}
+
+// LocationRequest is the point a location message sends. LiveSeconds above zero shares the
+// sender's live location for that long.
+type LocationRequest struct {
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Label       string  `json:"label,omitempty"`
+	LiveSeconds int     `json:"liveSeconds,omitempty"`
+}
+
+// LocationUpdateRequest moves a live location, or with Stop ends it early
+type LocationUpdateRequest struct {
+	ConversationID string  `json:"conversationId" validate:"required"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Stop           bool    `json:"stop,omitempty"`
+}
+
+// Poll is a poll message's options and running tally. Votes are kept in poll_votes, one
+// document per voter, and counted here in the same transaction.
+type Poll struct {
+	Options     []PollOption `bson:"options" json:"options"`
+	MultiSelect bool         `bson:"multiSelect" json:"multiSelect"`
+	Voters      int          `bson:"voters" json:"voters"`
+	ClosesAt    *time.Time   `bson:"closesAt,omitempty" json:"closesAt,omitempty"`
+	ClosedAt    *time.Time   `bson:"closedAt,omitempty" json:"closedAt,omitempty"` // once closed the tally is final
+}
+
+// PollOption is one answer and the number of voters who chose it
+type PollOption struct {
+	Text  string `bson:"text" json:"text"`
+	Votes int    `bson:"votes" json:"votes"`
+}
+
+// PollRequest creates a poll; Options are 2 to 10 answers of up to 100 characters
+type PollRequest struct {
+	Options     []string   `json:"options"`
+	MultiSelect bool       `json:"multiSelect,omitempty"`
+	ClosesAt    *time.Time `json:"closesAt,omitempty"`
+}
+
+// PollVote is a user's current choice in a poll, as option indexes
+type PollVote struct {
+	ID               string    `bson:"_id" json:"-"` // "<messageId>:<userId>"
+	MessageID        int64     `bson:"messageId" json:"-"`
+	ConversationID   string    `bson:"conversationId" json:"-"`
+	UserID           string    `bson:"userId" json:"-"`
+	Options          []int     `bson:"options" json:"options"`
+	VotedAt          time.Time `bson:"votedAt" json:"votedAt"`
+	MessageCreatedAt time.Time `bson:"messageCreatedAt" json:"-"` // lets retention delete votes with their poll
+}
+
+// VoteRequest replaces the caller's vote; no options withdraws it
+type VoteRequest struct {
+	ConversationID string `json:"conversationId" validate:"required"`
+	Options        []int  `json:"options" validate:"max=10"`
+}
+
+// MediaRequest sends a GIF or sticker by the ID /v1/gifs/search gave it
+type MediaRequest struct {
+	MediaID string `json:"mediaId" validate:"required,max=128"`
+}
+
+// Media is a GIF or sticker from the configured provider, as the provider described it when
+// it was sent or found
+type Media struct {
+	Provider   string `bson:"provider" json:"provider"`
+	ID         string `bson:"id" json:"id"`
+	Title      string `bson:"title,omitempty" json:"title,omitempty"`
+	URL        string `bson:"url" json:"url"`
+	PreviewURL string `bson:"previewUrl,omitempty" json:"previewUrl,omitempty"` // smaller, for pickers and slow links
+	Width      int    `bson:"width,omitempty" json:"width,omitempty"`
+	Height     int    `bson:"height,omitempty" json:"height,omitempty"`
+}
+
+// Attachment is a file uploaded to a conversation, kept whole with its bytes. ContentType is
+// what the bytes turned out to be, not what the client said.
+type Attachment struct {
+	ID             string    `bson:"_id" json:"id"`
+	ConversationID string    `bson:"conversationId" json:"conversationId"`
+	UploaderID     string    `bson:"uploaderId" json:"uploaderId"`
+	Name           string    `bson:"name" json:"name"`
+	ContentType    string    `bson:"contentType" json:"contentType"`
+	Size           int64     `bson:"size" json:"size"`
+	Data           []byte    `bson:"data,omitempty" json:"-"` // dropped once blocked
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+
+	// ScanStatus is an AttachmentScan*, absent for files uploaded while scanning was off.
+	// ScanResult names what the scanner found in a blocked file, and ScanLeaseUntil keeps
+	// other nodes off a file while one scans it.
+	ScanStatus     string     `bson:"scanStatus,omitempty" json:"scanStatus,omitempty"`
+	ScanResult     string     `bson:"scanResult,omitempty" json:"scanResult,omitempty"`
+	ScannedAt      *time.Time `bson:"scannedAt,omitempty" json:"scannedAt,omitempty"`
+	ScanLeaseUntil *time.Time `bson:"scanLeaseUntil,omitempty" json:"-"`
+}
+
+// Attachment scan states; a pending file is quarantined, served to nobody, until it passes
+const (
+	AttachmentScanPending = "pending"
+	AttachmentScanClean   = "clean"
+	AttachmentScanBlocked = "blocked"
+)
+
+// AttachmentReview records the classifier flagging or blocking an uploaded image, for the
+// workspace's admins to uphold or overturn. Reviews stay, resolved, as the record of both
+// decisions.
+type AttachmentReview struct {
+	ID             string    `bson:"_id" json:"id"`
+	WorkspaceID    string    `bson:"workspaceId" json:"-"`
+	AttachmentID   string    `bson:"attachmentId" json:"attachmentId"`
+	ConversationID string    `bson:"conversationId" json:"conversationId"`
+	UploaderID     string    `bson:"uploaderId" json:"uploaderId"`
+	Name           string    `bson:"name" json:"name"`
+	ContentType    string    `bson:"contentType" json:"contentType"`
+	Score          float64   `bson:"score" json:"score"`   // the classifier's, 0 to 1
+	Action         string    `bson:"action" json:"action"` // ExplicitImagesFlag or ExplicitImagesBlock
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+
+	// Resolution is an AttachmentReview*, absent while the review is open
+	Resolution string     `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	ResolvedBy string     `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+}
+
+// Attachment review resolutions: an approved image is shared, a removed one's bytes are dropped
+const (
+	AttachmentReviewApproved = "approved"
+	AttachmentReviewRemoved  = "removed"
+)
+
+// ResolveAttachmentReviewRequest resolves an open attachment review
+type ResolveAttachmentReviewRequest struct {
+	Resolution string `json:"resolution" validate:"required,oneof=approved|removed"`
+}
+
+// AttachmentReviewsPage is one page of a workspace's attachment reviews, newest first
+type AttachmentReviewsPage struct {
+	Reviews    []AttachmentReview `json:"reviews"`
+	NextCursor string             `json:"nextCursor,omitempty"` // pass as cursor for the next page
+}
+
+// File is the payload of a file message: the attachment it shares, as it was when sent. Its
+// ScanStatus follows the attachment's, announced in message.updated frames.
+type File struct {
+	AttachmentID string `bson:"attachmentId" json:"attachmentId"`
+	Name         string `bson:"name" json:"name"`
+	ContentType  string `bson:"contentType" json:"contentType"`
+	Size         int64  `bson:"size" json:"size"`
+	ScanStatus   string `bson:"scanStatus,omitempty" json:"scanStatus,omitempty"`
+}
+
+// FileRequest is what a client sends for a file message: one of its own uploads to the
+// conversation
+type FileRequest struct {
+	AttachmentID string `json:"attachmentId" validate:"required,max=64"`
+}
+
+// MediaSearchResult is a page of GIF or sticker search results; Next continues the search
+type MediaSearchResult struct {
+	Results []Media `json:"results"`
+	Next    string  `json:"next,omitempty"`
+}
+
+// LinkPreview summarises a linked page from its OpenGraph or Twitter card metadata
+type LinkPreview struct {
+	URL         string `bson:"url" json:"url"` // as it appeared in the message
+	SiteName    string `bson:"siteName,omitempty" json:"siteName,omitempty"`
+	Title       string `bson:"title" json:"title"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	ImageURL    string `bson:"imageUrl,omitempty" json:"imageUrl,omitempty"`
+}
+
+// CustomEmoji is an image people can use as :name: alongside the built-in emoji, throughout
+// the workspace or, when ConversationID is set, in that conversation only
+type CustomEmoji struct {
+	ID             string    `bson:"_id" json:"id"`
+	Name           string    `bson:"name" json:"name"`
+	WorkspaceID    string    `bson:"workspaceId" json:"workspaceId"`
+	ConversationID string    `bson:"conversationId,omitempty" json:"conversationId,omitempty"`
+	ContentType    string    `bson:"contentType" json:"contentType"`
+	Image          []byte    `bson:"image" json:"-"`
+	CreatedBy      string    `bson:"createdBy" json:"createdBy"`
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// CreateEmojiRequest uploads a custom emoji; Image is base64 in JSON
+type CreateEmojiRequest struct {
+	Name  string `json:"name" validate:"required"`
+	Image []byte `json:"image" validate:"required"`
 }
 
 // MessageWithSender represents a message with populated sender info for API responses
 type MessageWithSender struct {
 	ID             int64     `json:"id"`
+	Seq            int64     `json:"seq,omitempty"`
 	ConversationID string    `json:"conversationId"`
 	SenderID       string    `json:"senderId"`
 	ClientMsgID    string    `json:"clientMsgId"`
 	Body           string    `json:"body"`
+	Format         string    `json:"format,omitempty"`
+	HTML           string    `json:"html,omitempty"`
 	CreatedAt      time.Time `json:"createdAt"`
 	Sender         *User     `json:"sender,omitempty"`
+
+	RetractableUntil *time.Time        `json:"retractableUntil,omitempty"`
+	Preview          *LinkPreview      `json:"preview,omitempty"`
+	Emoji            map[string]string `json:"emoji,omitempty"`
+	Mentions         []string          `json:"mentions,omitempty"`
+	Type             string            `json:"type,omitempty"`
+	Payload          json.RawMessage   `json:"payload,omitempty"`
+}
+
+// MessageRevision is a prior version of a message's body, archived when an edit replaces it.
+// ID is "<messageId>:<revision>"; revision 1 is the body as first sent.
+type MessageRevision struct {
+	ID               string     `bson:"_id" json:"-"`
+	MessageID        int64      `bson:"messageId" json:"-"`
+	ConversationID   string     `bson:"conversationId" json:"-"`
+	MessageCreatedAt time.Time  `bson:"messageCreatedAt" json:"-"` // lets retention delete revisions with their message
+	Revision         int        `bson:"revision" json:"revision"`
+	Body             string     `bson:"body" json:"body"`
+	WrittenAt        time.Time  `bson:"writtenAt" json:"writtenAt"`
+	ReplacedAt       *time.Time `bson:"replacedAt" json:"replacedAt,omitempty"` // unset on the current version
+	ReplacedBy       string     `bson:"replacedBy" json:"replacedBy,omitempty"`
+}
+
+// MessageHistory is every version of a message: Revisions are the replaced ones, oldest first,
+// and Current is the body as it stands
+type MessageHistory struct {
+	MessageID      int64             `json:"messageId"`
+	ConversationID string            `json:"conversationId"`
+	SenderID       string            `json:"senderId"`
+	Revisions      []MessageRevision `json:"revisions"`
+	Current        MessageRevision   `json:"current"`
 }
 
 // CreateConversationRequest represents the request to create a new conversation
 type CreateConversationRequest struct {
-	Kind    string   `json:"kind"`    // "dm" or "group"
-	Title   string   `json:"title,omitempty"`
-	Members []string `json:"members"` // List of user emails or IDs
+	Kind    string   `json:"kind" validate:"required,oneof=dm|group"`
+	Title   string   `json:"title,omitempty" validate:"max=200"`
+	Members []string `json:"members" validate:"required"` // user IDs, or "@username" handles
+	// PostingPolicy restricts who posts in a group from the start; direct messages are always open
+	PostingPolicy string `json:"postingPolicy,omitempty" validate:"oneof=everyone|moderators|admins"`
+}
+
+// PostingPolicyRequest changes who may send messages in a group conversation
+type PostingPolicyRequest struct {
+	PostingPolicy string `json:"postingPolicy" validate:"required,oneof=everyone|moderators|admins"`
+}
+
+// ConversationPoliciesRequest changes a group conversation's policies; those left out keep
+// their current value
+type ConversationPoliciesRequest struct {
+	PostingPolicy  string `json:"postingPolicy,omitempty" validate:"oneof=everyone|moderators|admins"`
+	InvitePolicy   string `json:"invitePolicy,omitempty" validate:"oneof=everyone|moderators|admins"`
+	PinPolicy      string `json:"pinPolicy,omitempty" validate:"oneof=everyone|moderators|admins"`
+	MetadataPolicy string `json:"metadataPolicy,omitempty" validate:"oneof=everyone|moderators|admins"`
+}
+
+// LockConversationRequest freezes posting in a conversation
+type LockConversationRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=200"`
+}
+
+// UpdateConversationRequest changes a group conversation's title
+type UpdateConversationRequest struct {
+	Title string `json:"title" validate:"required,max=200"`
+}
+
+// MemberRoleRequest changes a participant's role in a group conversation
+type MemberRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=admin|moderator|member"`
+}
+
+// MuteMemberRequest mutes a participant for up to 30 days
+type MuteMemberRequest struct {
+	DurationSeconds int    `json:"durationSeconds" validate:"min=1,max=2592000"`
+	Reason          string `json:"reason,omitempty" validate:"max=200"`
+}
+
+// AddMembersRequest adds users to a group conversation
+type AddMembersRequest struct {
+	Members []string `json:"members" validate:"required"` // user IDs, or "@username" handles
+}
+
+// AddMembersResponse lists the users an AddMembersRequest added, leaving out existing members
+type AddMembersResponse struct {
+	Added []string `json:"added"`
 }
 
 // SendMessageRequest represents the request to send a message
 type SendMessageRequest struct {
-	ConversationID string `json:"conversationId"`
-	ClientMsgID    string `json:"clientMsgId"`
-	Body           string `json:"body"`
+	ConversationID string `json:"conversationId" validate:"required"`
+	ClientMsgID    string `json:"clientMsgId" validate:"required,max=128"`
+	Body           string `json:"body" validate:"required,max=4000"`
+	Format         string `json:"format,omitempty" validate:"oneof=plain|markdown"`
+	// Type is a MessageType* clients may send, and Payload its request form; the type's
+	// registered builder checks both
+	Type    string          `json:"type,omitempty" validate:"max=32"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// UpdateRetentionRequest represents the request to change a conversation's retention override
+type UpdateRetentionRequest struct {
+	RetentionDays int `json:"retentionDays"` // 0 clears the override
+}
+
+// CreateWatchGrantRequest represents the request to let a compliance user watch a conversation
+type CreateWatchGrantRequest struct {
+	UserID          string `json:"userId" validate:"required"`
+	Reason          string `json:"reason" validate:"required,max=500"`
+	DurationMinutes int    `json:"durationMinutes" validate:"required"`
+}
+
+// CreateAPIKeyRequest represents the request to issue an API key
+type CreateAPIKeyRequest struct {
+	Name               string   `json:"name" validate:"required,max=100"`
+	UserID             string   `json:"userId" validate:"required"`
+	Scopes             []string `json:"scopes" validate:"required"`
+	RateLimitPerMinute int      `json:"rateLimitPerMinute,omitempty" validate:"min=0,max=60000"` // at most MaxAPIKeyRateLimit
+}
+
+// StreamReconfigRequest schedules a CHAT stream change; omitted settings keep their current value
+type StreamReconfigRequest struct {
+	Replicas      *int       `json:"replicas,omitempty"`
+	MaxBytes      *int64     `json:"maxBytes,omitempty"`
+	MaxAgeSeconds *int64     `json:"maxAgeSeconds,omitempty"`
+	ScheduledFor  *time.Time `json:"scheduledFor,omitempty"` // defaults to now
+}
+
+// SetConversationBotRequest adds an API key to a conversation's bot allow-list or changes its access
+type SetConversationBotRequest struct {
+	CanRead bool `json:"canRead"`
+	CanPost bool `json:"canPost"`
+}
+
+// ConfirmPurgeRequest represents the request to start a workspace purge after a dry run
+type ConfirmPurgeRequest struct {
+	ConfirmationToken string `json:"confirmationToken" validate:"required"`
 }
 
 // MarkMessageAsReadRequest represents the request to mark a message as read
 type MarkMessageAsReadRequest struct {
-	ConversationID string `json:"conversationId"`
+	ConversationID string `json:"conversationId" validate:"required"`
+}
+
+type RetractMessageRequest struct {
+	ConversationID string `json:"conversationId" validate:"required"`
 }
 
 // WebSocket frame types
@@ -87,25 +1304,96 @@ type WSFrame struct {
 	Type string      `json:"type"`
 	TS   int64       `json:"ts"`
 	Data interface{} `json:"data"`
+	// RequestID optionally correlates a client frame; the server assigns one when absent
+	RequestID string `json:"requestId,omitempty"`
 }
 
 // WebSocket message types
 type WSAuthData struct {
 	JWT string `json:"jwt"`
+	// Encoding switches the connection to "json", "msgpack" or "protobuf" (auth frame only)
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// WSEncodingChangedData confirms an encoding switch; every later server frame uses it
+type WSEncodingChangedData struct {
+	Encoding string `json:"encoding"`
+}
+
+// WSAuthExpiringData warns that the connection's token is about to lapse
+type WSAuthExpiringData struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// WSAuthRefreshedData confirms an auth.refresh and reports the new expiry
+type WSAuthRefreshedData struct {
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 type WSSubscribeData struct {
 	ConversationID string `json:"conversationId"`
 }
 
+// WSMembersPageRequestData requests one page of a conversation's members
+type WSMembersPageRequestData struct {
+	RequestID      string `json:"requestId,omitempty"` // echoed in the response
+	ConversationID string `json:"conversationId"`
+	Cursor         string `json:"cursor,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+}
+
+// WSResumeData asks the hub to replay what was missed since the last message seen per conversation
+type WSResumeData struct {
+	Conversations []WSResumePosition `json:"conversations"`
+}
+
+type WSResumePosition struct {
+	ConversationID string        `json:"conversationId"`
+	LastMessageID  FlexibleInt64 `json:"lastMessageId"`
+}
+
+// FlexibleInt64 is an int64 that also decodes from a decimal string. Binary-protocol clients
+// receive message IDs as strings and may send them back that way.
+type FlexibleInt64 int64
+
+func (n *FlexibleInt64) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var i int64
+		if err := json.Unmarshal(data, &i); err != nil {
+			return err
+		}
+		*n = FlexibleInt64(i)
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = FlexibleInt64(i)
+	return nil
+}
+
 type WSUnsubscribeData struct {
 	ConversationID string `json:"conversationId"`
 }
 
 type WSMessageSendData struct {
-	ConversationID string `json:"conversationId"`
-	ClientMsgID    string `json:"clientMsgId"`
-	Body           string `json:"body"`
+	ConversationID string          `json:"conversationId" validate:"required"`
+	ClientMsgID    string          `json:"clientMsgId" validate:"required,max=128"`
+	Body           string          `json:"body" validate:"required,max=4000"`
+	Format         string          `json:"format,omitempty" validate:"oneof=plain|markdown"`
+	Type           string          `json:"type,omitempty" validate:"max=32"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+}
+
+// WSLocationUpdateData moves the sender's live location in a location message
+type WSLocationUpdateData struct {
+	ConversationID string  `json:"conversationId"`
+	MessageID      int64   `json:"messageId"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Stop           bool    `json:"stop,omitempty"`
 }
 
 type WSTypingUpdateData struct {
@@ -123,15 +1411,117 @@ type WSMessageAckData struct {
 	ClientMsgID string    `json:"clientMsgId"`
 	ID          int64     `json:"id"`
 	CreatedAt   time.Time `json:"createdAt"`
+	RequestID   string    `json:"requestId"`
 }
 
 type WSMessageNewData struct {
 	ID             int64     `json:"id"`
+	Seq            int64     `json:"seq"` // per-conversation; a jump means frames were missed
 	ConversationID string    `json:"conversationId"`
 	SenderID       string    `json:"senderId"`
 	Body           string    `json:"body"`
+	Format         string    `json:"format,omitempty"`
+	HTML           string    `json:"html,omitempty"`
 	CreatedAt      time.Time `json:"createdAt"`
 	Sender         *User     `json:"sender,omitempty"`
+
+	// Retractable marks a message its sender may still retract, until RetractableUntil
+	Retractable      bool       `json:"retractable,omitempty"`
+	RetractableUntil *time.Time `json:"retractableUntil,omitempty"`
+
+	// Preview is only set on replays, when the preview was attached before the replay
+	Preview *LinkPreview `json:"preview,omitempty"`
+
+	// Emoji maps custom :shortcodes: in Body to image IDs, served at /v1/emoji/{id}/image
+	Emoji map[string]string `json:"emoji,omitempty"`
+
+	// Mentions lists the user IDs of the participants @mentioned in Body
+	Mentions []string `json:"mentions,omitempty"`
+
+	// Payload is as sent: a poll's tally moves on in poll.update frames, a live location in
+	// location.update frames and a file's scan status in message.updated frames
+	Type    string          `json:"type,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WSMessageRetractData asks to retract one of the sender's messages within the undo window;
+// the server answers with message.retracted to everyone subscribed, including the sender
+type WSMessageRetractData struct {
+	ConversationID string `json:"conversationId"`
+	ID             int64  `json:"id"`
+}
+
+// WSMessageRetractedData announces that a message was retracted and should be removed
+type WSMessageRetractedData struct {
+	ConversationID string `json:"conversationId"`
+	ID             int64  `json:"id"`
+}
+
+// WSMessageUpdatedData carries the fields of a message that changed after it was sent; fields
+// left out are unchanged
+type WSMessageUpdatedData struct {
+	ConversationID string       `json:"conversationId"`
+	ID             int64        `json:"id"`
+	Preview        *LinkPreview `json:"preview,omitempty"`
+	File           *File        `json:"file,omitempty"` // a file message's payload, once its scan is done
+}
+
+// WSResumeDoneData ends the replay for a conversation; live delivery follows. When Truncated is
+// set the replay hit its limit and the client should backfill the rest over REST.
+type WSResumeDoneData struct {
+	ConversationID string `json:"conversationId"`
+	Replayed       int    `json:"replayed"`
+	Truncated      bool   `json:"truncated"`
+}
+
+// WSMembersPageData answers members.page; an empty NextCursor means the last page
+type WSMembersPageData struct {
+	RequestID      string               `json:"requestId,omitempty"`
+	ConversationID string               `json:"conversationId"`
+	Members        []ConversationMember `json:"members"`
+	NextCursor     string               `json:"nextCursor,omitempty"`
+}
+
+// WSPollUpdateData carries a poll's tally after a vote, sent as poll.update. Final is set once
+// the poll has closed and the tally will not change again.
+type WSPollUpdateData struct {
+	ConversationID string `json:"conversationId"`
+	MessageID      int64  `json:"messageId"`
+	Poll           *Poll  `json:"poll"`
+	Final          bool   `json:"final,omitempty"`
+}
+
+// WSLocationEventData is where a live location has moved, sent as location.update. Clients
+// drop the live marker at ExpiresAt, or as soon as Stopped is set, which comes without a position.
+type WSLocationEventData struct {
+	ConversationID string    `json:"conversationId"`
+	MessageID      int64     `json:"messageId"`
+	UserID         string    `json:"userId"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	Stopped        bool      `json:"stopped,omitempty"`
+}
+
+// WSBotEventData announces a change to a conversation's bot allow-list, sent as bot.<action>
+type WSBotEventData struct {
+	ConversationID string          `json:"conversationId"`
+	Action         string          `json:"action"` // "added", "updated" or "removed"
+	Bot            ConversationBot `json:"bot"`
+}
+
+// WSOfflineDoneData follows the messages collected while the user had no connection.
+// When Truncated is set the client should backfill the rest over REST.
+type WSOfflineDoneData struct {
+	Delivered int  `json:"delivered"`
+	Truncated bool `json:"truncated"`
+}
+
+// WSCloseData ends a server-sent event stream the server closed, with the WebSocket close code
+// a socket would have got
+type WSCloseData struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
 }
 
 type WSTypingUpdateEventData struct {
@@ -146,14 +1536,259 @@ type WSReceiptUpdateData struct {
 	MessageID      int64  `json:"messageId"`
 }
 
+// WSReceiptSelfData tells a user's other connections that they read a conversation up to
+// MessageID, so unread badges clear on every device
+type WSReceiptSelfData struct {
+	ConversationID string    `json:"conversationId"`
+	UserID         string    `json:"userId"`
+	MessageID      int64     `json:"messageId"`
+	ReadAt         time.Time `json:"readAt"`
+	// The connection the read came from, which is not told; empty for REST and gRPC reads
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+// WSStatusUpdateData announces a user's status in a conversation they belong to, on
+// chat.conv.<id>.presence. Clients get it as presence.status, with an invisible user's status
+// and message blanked.
+type WSStatusUpdateData struct {
+	ConversationID string `json:"conversationId"`
+	UserID         string `json:"userId"`
+	Status         string `json:"status"`
+	Message        string `json:"message,omitempty"`
+}
+
+type WSPresenceUpdateData struct {
+	ConversationID string `json:"conversationId"`
+	UserID         string `json:"userId"`
+	Status         string `json:"status"` // "online" or "offline"
+}
+
+// WSPresenceSnapshotData lists every user known to be online in a large conversation
+type WSPresenceSnapshotData struct {
+	ConversationID string   `json:"conversationId"`
+	Online         []string `json:"online"`
+}
+
+// WSPresenceDeltaData batches presence changes since the previous roll-up
+type WSPresenceDeltaData struct {
+	ConversationID string   `json:"conversationId"`
+	Online         []string `json:"online,omitempty"`
+	Offline        []string `json:"offline,omitempty"`
+}
+
+// WSErrorData mirrors the REST problem details (see internal/problem)
 type WSErrorData struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Type              string `json:"type"`
+	Code              string `json:"code"`
+	Detail            string `json:"detail"`
+	Message           string `json:"message"` // same as Detail, for clients predating it
+	RetryAfterSeconds int    `json:"retryAfterSeconds,omitempty"`
 }
 
 // Pagination types
+// ImportHeader may open a POST /v1/workspace/import batch, mapping the source system's author
+// IDs to user IDs; authors it does not list are taken as user IDs
+type ImportHeader struct {
+	Authors map[string]string `json:"authors"`
+}
+
+// ImportMessage is one message line of an import batch
+type ImportMessage struct {
+	ConversationID string    `json:"conversationId" validate:"required"`
+	ExternalID     string    `json:"externalId" validate:"required,max=120"` // the message's ID in the source, for re-runs
+	Author         string    `json:"author" validate:"required"`
+	Body           string    `json:"body" validate:"required,max=4000"`
+	CreatedAt      time.Time `json:"createdAt" validate:"required"`
+}
+
+// ImportResult reports what an import batch wrote
+type ImportResult struct {
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"` // imported by an earlier batch
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// ImportFailure is a batch line that was not imported
+type ImportFailure struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// MessageSearchResponse answers GET /v1/messages/search, newest first
+type MessageSearchResponse struct {
+	Messages   []MessageSearchHit `json:"messages"`
+	NextCursor string             `json:"nextCursor,omitempty"` // pass as before for older matches
+}
+
+// MessageSearchHit is a matching message with the part of its body around the first match
+type MessageSearchHit struct {
+	MessageWithSender
+	Snippet []SnippetPart `json:"snippet"`
+}
+
+// SnippetPart is a run of snippet text; Hit marks text that matched the query
+type SnippetPart struct {
+	Text string `json:"text"`
+	Hit  bool   `json:"hit,omitempty"`
+}
+
 type PaginatedMessagesResponse struct {
 	Messages   []MessageWithSender `json:"messages"`
-	HasMore    bool                `json:"hasMore"`
-	NextCursor string              `json:"nextCursor,omitempty"`
-}
\ No newline at end of file
+	HasMore    bool                `json:"hasMore"`              // more messages in the direction of travel
+	NextCursor string              `json:"nextCursor,omitempty"` // continue with the same parameter (before or after)
+	PrevCursor string              `json:"prevCursor,omitempty"` // turn back with the other parameter
+}
+
+// SMSContact is a phone number that has texted the workspace's Twilio number
+type SMSContact struct {
+	Number     string     `bson:"_id" json:"number"`      // E.164
+	UserID     string     `bson:"userId" json:"userId"`   // its virtual user, "sms:<number>"
+	OptedIn    bool       `bson:"optedIn" json:"optedIn"` // false after STOP, until START
+	OptedOutAt *time.Time `bson:"optedOutAt,omitempty" json:"optedOutAt,omitempty"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// TelegramLink relays a conversation to a Telegram chat, set up by one of its admins
+type TelegramLink struct {
+	ConversationID string    `bson:"_id" json:"conversationId"`
+	ChatID         int64     `bson:"chatId" json:"chatId"`
+	ChatTitle      string    `bson:"chatTitle,omitempty" json:"chatTitle,omitempty"` // when linked
+	LinkedBy       string    `bson:"linkedBy" json:"linkedBy"`
+	LinkedAt       time.Time `bson:"linkedAt" json:"linkedAt"`
+}
+
+// LinkTelegramRequest links a conversation to a Telegram chat the relay's bot is in
+type LinkTelegramRequest struct {
+	ChatID int64 `json:"chatId" validate:"required"`
+}
+
+// SCIM 2.0 message schemas (RFC 7644)
+const (
+	SCIMUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMUser is a user as /scim/v2/Users represents it. Its ID is the user's ID, which is the
+// externalId the provider created it with, or its userName.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty" validate:"max=200"`
+	UserName    string      `json:"userName" validate:"required,max=320"`
+	DisplayName string      `json:"displayName,omitempty" validate:"max=200"`
+	Name        *SCIMName   `json:"name,omitempty"`
+	Emails      []SCIMEmail `json:"emails,omitempty" validate:"max=10"`
+	Active      *bool       `json:"active,omitempty"` // absent means true
+	Meta        *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+// SCIMGroup is a group conversation as /scim/v2/Groups represents it; members are user IDs
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty" validate:"max=200"`
+	DisplayName string       `json:"displayName" validate:"required,max=200"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// SCIMListResponse is one page of a SCIM query; StartIndex counts from 1
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is a SCIM PATCH body; each operation's value is decoded by its path
+type SCIMPatchRequest struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []SCIMPatchOp `json:"Operations" validate:"required,max=100"`
+}
+
+type SCIMPatchOp struct {
+	Op    string          `json:"op"` // add, replace or remove, in any case
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// SCIMError is the body of every SCIM error response
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// LDAPSyncRequest is the body of POST /admin/v1/ldap/sync
+type LDAPSyncRequest struct {
+	DryRun bool `json:"dryRun"` // report what would change without changing it
+}
+
+// LDAPSyncReport is what one LDAP sync changed, or would change in a dry run. Directory
+// entries it could not apply are listed in Conflicts and otherwise skipped.
+type LDAPSyncReport struct {
+	DryRun           bool               `bson:"dryRun" json:"dryRun"`
+	StartedAt        time.Time          `bson:"startedAt" json:"startedAt"`
+	FinishedAt       time.Time          `bson:"finishedAt" json:"finishedAt"`
+	Error            string             `bson:"error,omitempty" json:"error,omitempty"` // why a scheduled sync failed
+	UsersCreated     []string           `bson:"usersCreated" json:"usersCreated"`
+	UsersUpdated     []string           `bson:"usersUpdated" json:"usersUpdated"`
+	UsersDeactivated []string           `bson:"usersDeactivated" json:"usersDeactivated"`
+	UsersReactivated []string           `bson:"usersReactivated" json:"usersReactivated"`
+	Groups           []LDAPGroupChange  `bson:"groups" json:"groups"` // groups that changed
+	Conflicts        []LDAPSyncConflict `bson:"conflicts" json:"conflicts"`
+}
+
+// LDAPGroupChange is a directory group's effect on its conversation
+type LDAPGroupChange struct {
+	DN             string   `bson:"dn" json:"dn"`
+	ConversationID string   `bson:"conversationId,omitempty" json:"conversationId,omitempty"` // absent for groups a dry run would create
+	Title          string   `bson:"title" json:"title"`
+	Created        bool     `bson:"created,omitempty" json:"created,omitempty"`
+	Unlinked       bool     `bson:"unlinked,omitempty" json:"unlinked,omitempty"` // gone from the directory; the conversation is kept
+	Added          []string `bson:"added,omitempty" json:"added,omitempty"`
+	Removed        []string `bson:"removed,omitempty" json:"removed,omitempty"`
+}
+
+// LDAPSyncConflict is a directory entry the sync skipped, and why
+type LDAPSyncConflict struct {
+	DN     string `bson:"dn" json:"dn"`
+	UserID string `bson:"userId,omitempty" json:"userId,omitempty"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+// LDAPSyncState is the ldap_syncs document for the synced workspace: when the next scheduled
+// sync is due, and the last sync's report
+type LDAPSyncState struct {
+	ID         string          `bson:"_id"`
+	NextRunAt  time.Time       `bson:"nextRunAt"`
+	LastReport *LDAPSyncReport `bson:"lastReport,omitempty"`
+}
@@ -0,0 +1,99 @@
+// Package permissions maps roles to what they allow, so services ask whether someone may do a
+// thing rather than comparing role names. There are two kinds of role: a participant's role in
+// one conversation, and a user's workspace roles. Neither implies the other; a workspace admin
+// is not an admin of every conversation. A new role is a name in models and an entry below.
+package permissions
+
+import "github.com/JohnBPerkins/chat-service/backend/internal/models"
+
+type Permission string
+
+// Conversation permissions, granted by the participant's role in the conversation
+const (
+	DeleteConversation Permission = "conversation.delete"
+	ManageMembers      Permission = "conversation.members" // remove others; who adds members is the invite policy
+	ManageRoles        Permission = "conversation.roles"
+	ManageConversation Permission = "conversation.manage" // settings, retention, policies and locks
+	ManageBots         Permission = "conversation.bots"
+	ManageIntegrations Permission = "conversation.integrations" // Telegram links
+	ManageEmoji        Permission = "conversation.emoji"
+	BypassSlowMode     Permission = "conversation.bypass_slow_mode"
+	PostWhenLocked     Permission = "conversation.post_when_locked"
+	MuteMembers        Permission = "conversation.mute" // members holding it cannot be muted
+)
+
+// Workspace permissions, granted by the user's workspace roles
+const (
+	// AdministerWorkspace covers the workspace's members, roles, defaults, API keys, emoji,
+	// provisioning, imports, purges and watch grants
+	AdministerWorkspace Permission = "workspace.administer"
+	ApproveRetention    Permission = "workspace.approve_retention" // shorten retention, or approve others shortening it
+	WatchConversations  Permission = "workspace.watch"             // read conversations under a watch grant
+)
+
+var conversationRoles = map[string][]Permission{
+	models.ConversationRoleAdmin: {
+		DeleteConversation, ManageMembers, ManageRoles, ManageConversation, ManageBots,
+		ManageIntegrations, ManageEmoji, BypassSlowMode, PostWhenLocked, MuteMembers,
+	},
+	models.ConversationRoleModerator: {BypassSlowMode, MuteMembers},
+	models.ConversationRoleMember:    {},
+}
+
+// policyRoles are the conversation roles each policy level lets act
+var policyRoles = map[string][]string{
+	models.PolicyEveryone:   {models.ConversationRoleAdmin, models.ConversationRoleModerator, models.ConversationRoleMember},
+	models.PolicyModerators: {models.ConversationRoleAdmin, models.ConversationRoleModerator},
+	models.PolicyAdmins:     {models.ConversationRoleAdmin},
+}
+
+var workspaceRoles = map[string][]Permission{
+	models.RoleWorkspaceAdmin: {AdministerWorkspace},
+	models.RoleCompliance:     {ApproveRetention, WatchConversations},
+}
+
+// CheckPermission reports whether someone holds perm. Conversation permissions come from
+// participant, their participation in the conversation acted on, and workspace permissions
+// from user; either may be nil when perm does not need it, and nil holds nothing.
+func CheckPermission(participant *models.Participant, user *models.User, perm Permission) bool {
+	if participant != nil && granted(conversationRoles[participant.Role], perm) {
+		return true
+	}
+	if user != nil {
+		for _, role := range user.Roles {
+			if granted(workspaceRoles[role], perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckPolicy reports whether a participant may act under one of their conversation's
+// policies, such as its posting policy. Empty policies must be filled with their defaults first.
+func CheckPolicy(participant *models.Participant, policy string) bool {
+	if participant == nil {
+		return false
+	}
+	for _, role := range policyRoles[policy] {
+		if participant.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWorkspaceRole reports whether role is a workspace role, one admins may give members
+func IsWorkspaceRole(role string) bool {
+	_, ok := workspaceRoles[role]
+	return ok
+}
+
+func granted(permissions []Permission, perm Permission) bool {
+	for _, p := range permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
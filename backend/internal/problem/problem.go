@@ -0,0 +1,93 @@
+// Package problem writes error responses as RFC 7807 problem details, the one error envelope
+// used by every REST endpoint and mirrored by the WebSocket error frame:
+//
+//	{"type": "urn:chat-service:problem:not-found", "title": "Not Found", "status": 404,
+//	 "code": "NOT_FOUND", "detail": "conversation not found", "requestId": "..."}
+//
+// code is the same machine-readable code the WebSocket error frame carries; type is derived
+// from it. detail is always safe to show to the client.
+package problem
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// Details is the response body
+type Details struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"requestId,omitempty"`
+	// RetryAfterSeconds is set, as is the Retry-After header, when the wait before retrying is known
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+}
+
+// statusCodes are the codes used when a response has no more specific one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "INVALID_REQUEST",
+	http.StatusUnauthorized:          "UNAUTHORIZED",
+	http.StatusForbidden:             "FORBIDDEN",
+	http.StatusNotFound:              "NOT_FOUND",
+	http.StatusConflict:              "CONFLICT",
+	http.StatusRequestEntityTooLarge: "PAYLOAD_TOO_LARGE",
+	http.StatusTooManyRequests:       "RATE_LIMITED",
+	http.StatusInternalServerError:   "INTERNAL",
+	http.StatusServiceUnavailable:    "UNAVAILABLE",
+}
+
+// Type returns the problem type URI for code, e.g. urn:chat-service:problem:not-found
+func Type(code string) string {
+	return "urn:chat-service:problem:" + strings.ReplaceAll(strings.ToLower(code), "_", "-")
+}
+
+// Error is the drop-in replacement for http.Error: it writes detail with the code for status
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	Write(w, r, status, code, detail)
+}
+
+// Write writes a problem response with an explicit code
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	WriteRetryAfter(w, r, status, code, detail, 0)
+}
+
+// WriteRetryAfter is Write for failures that pass after a known wait, which is rounded up to
+// whole seconds; zero leaves it out
+func WriteRetryAfter(w http.ResponseWriter, r *http.Request, status int, code, detail string, retryAfter time.Duration) {
+	seconds := RetryAfterSeconds(retryAfter)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if seconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Details{
+		Type:              Type(code),
+		Title:             http.StatusText(status),
+		Status:            status,
+		Code:              code,
+		Detail:            detail,
+		RequestID:         requestid.FromContext(r.Context()),
+		RetryAfterSeconds: seconds,
+	})
+}
+
+// RetryAfterSeconds rounds a wait up to whole seconds, as Retry-After counts them
+func RetryAfterSeconds(retryAfter time.Duration) int {
+	return int(math.Ceil(retryAfter.Seconds()))
+}
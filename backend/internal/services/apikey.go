@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeyPrefix             = "csk_"
+	apiKeyDisplayPrefixChars = 12
+)
+
+var validAPIKeyScopes = map[string]bool{
+	models.ScopeConversationsRead:  true,
+	models.ScopeConversationsWrite: true,
+	models.ScopeMessagesRead:       true,
+	models.ScopeMessagesWrite:      true,
+}
+
+type APIKeyService struct {
+	db           *database.MongoDB
+	userService  *UserService
+	auditService *AuditService
+	clock        clock.Clock
+	logger       *slog.Logger
+	ids          IDGenerator
+
+	// defaultRateLimit applies to keys created without a per-minute limit
+	defaultRateLimit int
+}
+
+func NewAPIKeyService(db *database.MongoDB, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, defaultRateLimit int) *APIKeyService {
+	return &APIKeyService{
+		db:               db,
+		userService:      userService,
+		auditService:     auditService,
+		clock:            clk,
+		logger:           logger,
+		ids:              ids,
+		defaultRateLimit: defaultRateLimit,
+	}
+}
+
+// CreateAPIKey issues a key acting as req.UserID with the requested scopes
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, actorID string, req *models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" || len(req.Scopes) == 0 || req.RateLimitPerMinute < 0 || req.RateLimitPerMinute > models.MaxAPIKeyRateLimit {
+		return nil, validationError("invalid api key request")
+	}
+	for _, scope := range req.Scopes {
+		if !validAPIKeyScopes[scope] {
+			return nil, validationError("invalid api key request")
+		}
+	}
+
+	// The principal must exist so participant checks resolve, and be in the admin's workspace
+	principal, err := s.userService.GetUserByID(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if principal.WorkspaceID != actor.WorkspaceID {
+		return nil, notFoundError("user not found")
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	rawKey := apiKeyPrefix + secret
+
+	rateLimit := req.RateLimitPerMinute
+	if rateLimit == 0 {
+		rateLimit = s.defaultRateLimit
+	}
+
+	key := &models.APIKey{
+		ID:                 s.ids.NewID(),
+		Name:               req.Name,
+		Prefix:             rawKey[:apiKeyDisplayPrefixChars],
+		KeyHash:            hashSecret(rawKey),
+		UserID:             req.UserID,
+		WorkspaceID:        actor.WorkspaceID,
+		Scopes:             req.Scopes,
+		RateLimitPerMinute: rateLimit,
+		CreatedBy:          actorID,
+		CreatedAt:          s.clock.Now(),
+	}
+
+	if _, err := s.db.Collection(ctx, "api_keys").InsertOne(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	s.audit(ctx, AuditAPIKeyCreated, actorID, key)
+	return &models.CreateAPIKeyResponse{APIKey: key, Key: rawKey}, nil
+}
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, actorID string) ([]models.APIKey, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.db.Collection(ctx, "api_keys").Find(ctx, bson.M{"workspaceId": actor.WorkspaceID}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey disables a key immediately; revoked keys are kept for the audit trail
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, actorID, keyID string) error {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return err
+	}
+
+	var key models.APIKey
+	err = s.db.Collection(ctx, "api_keys").FindOneAndUpdate(ctx,
+		bson.M{"_id": keyID, "workspaceId": actor.WorkspaceID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": s.clock.Now()}},
+	).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("api key not found")
+		}
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	s.audit(ctx, AuditAPIKeyRevoked, actorID, &key)
+	return nil
+}
+
+// AuthenticateAPIKey resolves a raw key presented in X-API-Key to its active record
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, rawKey string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := s.db.Collection(ctx, "api_keys").FindOne(ctx, bson.M{
+		"keyHash":   hashSecret(rawKey),
+		"revokedAt": bson.M{"$exists": false},
+	}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("invalid api key")
+		}
+		return nil, fmt.Errorf("failed to look up api key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func (s *APIKeyService) audit(ctx context.Context, action, actorID string, key *models.APIKey) {
+	err := s.auditService.Record(ctx, action, actorID, "", map[string]interface{}{
+		"keyId":  key.ID,
+		"name":   key.Name,
+		"userId": key.UserID,
+		"scopes": key.Scopes,
+	})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", action, logging.UserID, actorID, logging.Err(err))
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// With a classifier configured, uploaded images are quarantined like files awaiting the virus
+// scanner, and once any scan passes, the classifier scores how likely each is to be explicit.
+// Images scoring at or above the workspace's threshold are flagged, shared as usual, or
+// blocked, withheld from everyone, as its attachment policy says; either way the decision is
+// recorded as an open review. The workspace's admins see the image there and approve it,
+// sharing a blocked one, or remove it, dropping its bytes. The classifier is a service of the
+// operator's choosing, reached over HTTP, as no model ships with the server.
+
+// Image classifier providers
+const (
+	ClassifierOff  = "off"
+	ClassifierHTTP = "http"
+)
+
+const (
+	attachmentReviewsCollection = "attachment_reviews"
+	defaultExplicitThreshold    = 0.8
+	classifierResponseMaxBytes  = 64 << 10
+
+	// Scan results of files withheld for moderators rather than by the virus scanner
+	explicitImageResult      = "explicit image"
+	removedByModeratorResult = "removed by a moderator"
+)
+
+// ImageClassifier scores how likely an image is to be explicit, from 0 to 1
+type ImageClassifier interface {
+	Classify(ctx context.Context, contentType string, data []byte) (score float64, err error)
+}
+
+// NewImageClassifier returns the classifier for provider, or nil when classifying is off.
+// endpoint is the HTTP classifier's URL.
+func NewImageClassifier(provider, endpoint string) (ImageClassifier, error) {
+	switch provider {
+	case ClassifierOff, "":
+		return nil, nil
+	case ClassifierHTTP:
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("image classifier %q must be an http(s) URL", endpoint)
+		}
+		return &httpClassifier{client: &http.Client{}, endpoint: endpoint}, nil
+	}
+	return nil, fmt.Errorf("unknown image classifier %q", provider)
+}
+
+// httpClassifier posts each image, typed by Content-Type, to an endpoint answering
+// {"score": 0.97}. Wrappers around the common open models are a few lines.
+type httpClassifier struct {
+	client   *http.Client
+	endpoint string
+}
+
+func (c *httpClassifier) Classify(ctx context.Context, contentType string, data []byte) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The URL may carry credentials, so it is dropped from the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return 0, fmt.Errorf("failed to reach image classifier: %w", urlErr.Err)
+		}
+		return 0, fmt.Errorf("failed to reach image classifier: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("image classifier returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, classifierResponseMaxBytes)).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode image classifier response: %w", err)
+	}
+	if result.Score == nil || *result.Score < 0 || *result.Score > 1 {
+		return 0, errors.New("image classifier returned no score between 0 and 1")
+	}
+	return *result.Score, nil
+}
+
+// explicitImagePolicy returns what a workspace's policy does with explicit images, and the
+// score from which images count as explicit
+func explicitImagePolicy(policy *models.AttachmentPolicy) (action string, threshold float64) {
+	action, threshold = models.ExplicitImagesFlag, defaultExplicitThreshold
+	if policy != nil {
+		if policy.ExplicitImages != "" {
+			action = policy.ExplicitImages
+		}
+		if policy.ExplicitThreshold > 0 {
+			threshold = policy.ExplicitThreshold
+		}
+	}
+	return action, threshold
+}
+
+// classifies reports whether an upload of contentType waits for the classifier
+func (s *AttachmentService) classifies(contentType string, policy *models.AttachmentPolicy) bool {
+	action, _ := explicitImagePolicy(policy)
+	return s.config.Classifier != nil && strings.HasPrefix(contentType, "image/") && action != models.ExplicitImagesAllow
+}
+
+// classify scores a claimed image against its workspace's current policy, returning the
+// review to open for it, or nil if it passes
+func (s *AttachmentService) classify(ctx context.Context, attachment *models.Attachment) (*models.AttachmentReview, error) {
+	conversation, err := s.conversationService.GetConversationByID(ctx, attachment.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := attachmentPolicy(ctx, s.db, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.classifies(attachment.ContentType, policy) {
+		return nil, nil
+	}
+	action, threshold := explicitImagePolicy(policy)
+
+	score, err := s.config.Classifier.Classify(ctx, attachment.ContentType, attachment.Data)
+	if err != nil {
+		return nil, err
+	}
+	if score < threshold {
+		return nil, nil
+	}
+	return &models.AttachmentReview{
+		ID:             s.ids.NewID(),
+		WorkspaceID:    conversation.WorkspaceID,
+		AttachmentID:   attachment.ID,
+		ConversationID: attachment.ConversationID,
+		UploaderID:     attachment.UploaderID,
+		Name:           attachment.Name,
+		ContentType:    attachment.ContentType,
+		Score:          score,
+		Action:         action,
+		CreatedAt:      s.clock.Now(),
+	}, nil
+}
+
+// ListReviews returns up to limit of the admin's workspace attachment reviews, open or resolved,
+// newest first, starting after cursor (a review ID; empty for the first page)
+func (s *AttachmentService) ListReviews(ctx context.Context, actorID string, resolved bool, cursor string, limit int) (*models.AttachmentReviewsPage, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"workspaceId": actor.WorkspaceID, "resolution": bson.M{"$exists": resolved}}
+	if cursor != "" {
+		filter["_id"] = bson.M{"$lt": cursor}
+	}
+	results, err := s.db.Collection(ctx, attachmentReviewsCollection).Find(ctx, filter, options.Find().
+		SetSort(bson.M{"_id": -1}).
+		SetLimit(int64(limit+1))) // one extra to tell whether there are more
+	if err != nil {
+		return nil, fmt.Errorf("failed to find attachment reviews: %w", err)
+	}
+	page := &models.AttachmentReviewsPage{Reviews: []models.AttachmentReview{}}
+	if err := results.All(ctx, &page.Reviews); err != nil {
+		return nil, fmt.Errorf("failed to decode attachment reviews: %w", err)
+	}
+	if len(page.Reviews) > limit {
+		page.Reviews = page.Reviews[:limit]
+		page.NextCursor = page.Reviews[limit-1].ID
+	}
+	return page, nil
+}
+
+// ReviewFile returns the image under review, with its bytes, to an admin of its workspace,
+// whether or not it is blocked
+func (s *AttachmentService) ReviewFile(ctx context.Context, actorID, reviewID string) (*models.Attachment, error) {
+	review, err := s.findReview(ctx, actorID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := findAttachment(ctx, s.db, bson.M{"_id": review.AttachmentID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachment.Data) == 0 {
+		return nil, notFoundError("the file has been removed")
+	}
+	return attachment, nil
+}
+
+// ResolveReview closes an open review. Approving shares a blocked image; removing withholds the
+// image from everyone and drops its bytes. Messages sharing it follow, as they do a scan.
+func (s *AttachmentService) ResolveReview(ctx context.Context, actorID, reviewID, resolution string) (*models.AttachmentReview, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	var review models.AttachmentReview
+	err = s.db.Collection(ctx, attachmentReviewsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": reviewID, "workspaceId": actor.WorkspaceID, "resolution": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"resolution": resolution, "resolvedBy": actorID, "resolvedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		if _, err := s.findReview(ctx, actorID, reviewID); err != nil {
+			return nil, err
+		}
+		return nil, conflictError("the review is already resolved")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve attachment review: %w", err)
+	}
+
+	filter := bson.M{"_id": review.AttachmentID, "scanStatus": models.AttachmentScanBlocked, "scanResult": explicitImageResult}
+	update := bson.M{"$set": bson.M{"scanStatus": models.AttachmentScanClean}, "$unset": bson.M{"scanResult": ""}}
+	if resolution == models.AttachmentReviewRemoved {
+		filter = bson.M{"_id": review.AttachmentID}
+		update = bson.M{
+			"$set":   bson.M{"scanStatus": models.AttachmentScanBlocked, "scanResult": removedByModeratorResult},
+			"$unset": bson.M{"data": ""},
+		}
+	}
+	var attachment models.Attachment
+	err = s.db.Collection(ctx, attachmentsCollection).FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetProjection(bson.M{"data": 0}).SetReturnDocument(options.After)).Decode(&attachment)
+	switch {
+	case err == nil:
+		if err := s.messageService.updateFileScans(ctx, &attachment); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to update messages sharing a reviewed attachment", "id", attachment.ID,
+				logging.ConversationID, attachment.ConversationID, logging.Err(err))
+		}
+	case err != mongo.ErrNoDocuments: // a flagged image already shared, or one since deleted
+		return nil, fmt.Errorf("failed to update attachment: %w", err)
+	}
+
+	if err := s.auditService.Record(ctx, AuditAttachmentReviewed, actorID, review.ConversationID, map[string]interface{}{
+		"reviewId":     review.ID,
+		"attachmentId": review.AttachmentID,
+		"uploaderId":   review.UploaderID,
+		"score":        review.Score,
+		"action":       review.Action,
+		"resolution":   resolution,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", AuditAttachmentReviewed, logging.UserID, actorID, logging.Err(err))
+	}
+	return &review, nil
+}
+
+// findReview finds a review in the admin's workspace
+func (s *AttachmentService) findReview(ctx context.Context, actorID, reviewID string) (*models.AttachmentReview, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	var review models.AttachmentReview
+	err = s.db.Collection(ctx, attachmentReviewsCollection).FindOne(ctx,
+		bson.M{"_id": reviewID, "workspaceId": actor.WorkspaceID}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("attachment review not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find attachment review: %w", err)
+	}
+	return &review, nil
+}
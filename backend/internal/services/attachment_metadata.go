@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Photos carry where and when they were taken, and on what, in metadata their senders rarely
+// know is there. Uploaded JPEG, PNG and WebP images lose it before they are stored, so no
+// download ever has it: EXIF (GPS included), XMP, IPTC, comments and text chunks go, while
+// the pixels, colour profiles and a JPEG's orientation stay. The files are rewritten segment
+// by segment rather than decoded, so nothing is recompressed. GIF, BMP and icons carry no
+// such metadata.
+
+var errMalformedImage = errors.New("malformed image")
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+)
+
+// pngMetadataChunks are the PNG chunks dropped: EXIF, text (where XMP also lives) and the
+// modification time
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripImageMetadata returns an image of contentType without its metadata; other files are
+// returned as they are
+func stripImageMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	}
+	return data, nil
+}
+
+// stripJPEGMetadata drops the application segments other than JFIF, Adobe and ICC profiles,
+// comments, and anything after the image. An EXIF orientation is kept in a minimal EXIF
+// segment of its own, so photos taken sideways still show upright.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	insertAt := len(out) // where the orientation goes: after SOI and any JFIF header
+	orientation := 0
+
+	for i := 2; ; {
+		if i+2 > len(data) || data[i] != 0xFF {
+			return nil, errMalformedImage
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			i++
+			continue
+		case marker == 0xD9: // EOI
+			out = append(out, data[i:i+2]...)
+			return withOrientation(out, insertAt, orientation), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // no length
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errMalformedImage
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, errMalformedImage
+		}
+		if marker == 0xDA {
+			// SOS: the scans follow. 0xFF is escaped inside them, so the first EOI is the end
+			// of the image, and anything a camera appended after it goes too.
+			eoi := bytes.Index(data[end:], []byte{0xFF, 0xD9})
+			if eoi < 0 {
+				return nil, errMalformedImage
+			}
+			out = append(out, data[i:end+eoi+2]...)
+			return withOrientation(out, insertAt, orientation), nil
+		}
+
+		payload := data[i+4 : end]
+		keep := true
+		switch {
+		case marker == 0xE1:
+			if bytes.HasPrefix(payload, exifHeader) && orientation == 0 {
+				orientation = exifOrientation(payload[len(exifHeader):])
+			}
+			keep = false
+		case marker == 0xE2:
+			keep = bytes.HasPrefix(payload, iccHeader)
+		case marker == 0xE0 || marker == 0xEE: // JFIF and Adobe, which decoders need
+		case marker >= 0xE3 && marker <= 0xEF, marker == 0xFE:
+			keep = false
+		}
+		if keep {
+			jfifFirst := marker == 0xE0 && len(out) == 2
+			out = append(out, data[i:end]...)
+			if jfifFirst {
+				insertAt = len(out)
+			}
+		}
+		i = end
+	}
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF-structured EXIF block; 0 if
+// absent
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd+2 > int64(len(tiff)) {
+		return 0
+	}
+	entries := int64(order.Uint16(tiff[ifd:]))
+	for k := int64(0); k < entries; k++ {
+		entry := ifd + 2 + 12*k
+		if entry+12 > int64(len(tiff)) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if value := order.Uint16(tiff[entry+8:]); value >= 1 && value <= 8 {
+				return int(value)
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// withOrientation inserts an EXIF segment holding only the orientation at insertAt; images
+// already upright (1) or without one get none
+func withOrientation(jpeg []byte, insertAt, orientation int) []byte {
+	if orientation <= 1 {
+		return jpeg
+	}
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big-endian header, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // Orientation, SHORT, 1 value
+		0, 0, 0, 0, // no next IFD
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+
+	out := make([]byte, 0, len(jpeg)+len(segment))
+	out = append(out, jpeg[:insertAt]...)
+	out = append(out, segment...)
+	return append(out, jpeg[insertAt:]...)
+}
+
+// stripPNGMetadata drops the metadata chunks, and anything after IEND
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	for i := len(pngSignature); i+12 <= len(data); {
+		end := int64(i) + 12 + int64(binary.BigEndian.Uint32(data[i:]))
+		if end > int64(len(data)) {
+			return nil, errMalformedImage
+		}
+		chunkType := string(data[i+4 : i+8])
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		if chunkType == "IEND" {
+			return out, nil
+		}
+		i = int(end)
+	}
+	return nil, errMalformedImage
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks, and anything after the RIFF container, and
+// clears their flags in the VP8X header
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedImage
+	}
+	if riffEnd := 8 + int64(binary.LittleEndian.Uint32(data[4:])); riffEnd < int64(len(data)) {
+		data = data[:max(riffEnd, 12)]
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformedImage
+		}
+		size := int64(binary.LittleEndian.Uint32(data[i+4:]))
+		end := int64(i) + 8 + size + size%2 // chunks are padded to an even size
+		if end > int64(len(data)) {
+			if end-1 != int64(len(data)) || size%2 == 0 {
+				return nil, errMalformedImage
+			}
+			end-- // some encoders leave the last chunk's padding out
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04 // the EXIF and XMP flags
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = int(end)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
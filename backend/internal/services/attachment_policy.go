@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+)
+
+// What an uploaded file is goes by its bytes. The client makes two claims, its Content-Type
+// and the file name's extension, and each must agree with the bytes: a claim the sniffer
+// could have recognized must be what it found, and otherwise the bytes must be of a generic
+// kind the claim is a case of, such as a .docx that sniffs as ZIP. The file is stored as the
+// most specific agreeing type. Types that run code in a browser or on a desktop are refused
+// whatever is claimed and whatever the workspace's policy allows.
+
+const maxAttachmentPolicyEntries = 100
+
+// dangerousAttachmentTypes are refused whether sniffed or claimed
+var dangerousAttachmentTypes = map[string]bool{
+	"application/x-msdownload":                true,
+	"application/x-executable":                true,
+	"application/x-mach-binary":               true,
+	"application/java-vm":                     true,
+	"application/java-archive":                true,
+	"application/vnd.android.package-archive": true,
+	"application/x-msi":                       true,
+	"application/wasm":                        true,
+	"application/javascript":                  true,
+	"application/xhtml+xml":                   true,
+	"application/x-sh":                        true,
+	"text/javascript":                         true,
+	"text/html":                               true,
+	"text/x-shellscript":                      true,
+	"image/svg+xml":                           true,
+}
+
+// dangerousAttachmentExtensions are refused in file names, as desktops run them
+var dangerousAttachmentExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".com": true, ".scr": true, ".msi": true, ".bat": true,
+	".cmd": true, ".ps1": true, ".vbs": true, ".vbe": true, ".js": true, ".jse": true,
+	".mjs": true, ".wsf": true, ".wsh": true, ".hta": true, ".lnk": true, ".reg": true,
+	".cpl": true, ".sh": true, ".jar": true, ".apk": true, ".app": true, ".html": true,
+	".htm": true, ".xhtml": true, ".svg": true, ".wasm": true,
+}
+
+// attachmentExtensionTypes are the types claimed by common extensions; a fixed table, as the
+// mime package's depends on the host
+var attachmentExtensionTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".ico":  "image/x-icon",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain",
+	".log":  "text/plain",
+	".csv":  "text/csv",
+	".md":   "text/markdown",
+	".json": "application/json",
+	".xml":  "application/xml",
+	".zip":  "application/zip",
+	".gz":   "application/x-gzip",
+	".rar":  "application/x-rar-compressed",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".epub": "application/epub+zip",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wave",
+	".ogg":  "audio/ogg",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".avi":  "video/avi",
+}
+
+// attachmentTypeAliases map other names clients use to the ones the sniffer reports
+var attachmentTypeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/vnd.microsoft.icon":     "image/x-icon",
+	"audio/wav":                    "audio/wave",
+	"audio/x-wav":                  "audio/wave",
+	"audio/vnd.wave":               "audio/wave",
+	"audio/mp3":                    "audio/mpeg",
+	"video/x-msvideo":              "video/avi",
+	"application/gzip":             "application/x-gzip",
+	"application/x-zip-compressed": "application/zip",
+	"application/vnd.rar":          "application/x-rar-compressed",
+	"text/xml":                     "application/xml",
+	"application/x-javascript":     "application/javascript",
+}
+
+// sniffedAttachmentTypes are the types the sniffer recognizes by signature; a claim of one
+// must be what the bytes are
+var sniffedAttachmentTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+	"image/x-icon": true, "application/pdf": true, "application/postscript": true,
+	"application/zip": true, "application/x-gzip": true, "application/x-rar-compressed": true,
+	"application/ogg": true, "audio/ogg": true, "video/ogg": true, "audio/mpeg": true, "audio/wave": true,
+	"audio/aiff": true, "audio/basic": true, "audio/midi": true, "video/mp4": true, "video/webm": true, "video/avi": true,
+	"font/ttf": true, "font/otf": true, "font/woff": true, "font/woff2": true,
+}
+
+// attachmentContainers are generic sniffed types and the more specific types they may hold
+var attachmentContainers = map[string]func(claimed string) bool{
+	"text/plain": func(claimed string) bool {
+		return strings.HasPrefix(claimed, "text/") || claimed == "application/json" || claimed == "application/xml"
+	},
+	"application/xml": func(claimed string) bool {
+		return claimed == "text/plain" || strings.HasSuffix(claimed, "+xml")
+	},
+	"application/zip": func(claimed string) bool {
+		return strings.HasPrefix(claimed, "application/vnd.openxmlformats-officedocument.") ||
+			strings.HasPrefix(claimed, "application/vnd.oasis.opendocument.") ||
+			claimed == "application/epub+zip"
+	},
+	"application/ogg": func(claimed string) bool {
+		return claimed == "audio/ogg" || claimed == "video/ogg"
+	},
+	// Bytes the sniffer does not know may be anything it would have recognized otherwise
+	"application/octet-stream": func(claimed string) bool {
+		family, _, _ := strings.Cut(claimed, "/")
+		return !sniffedAttachmentTypes[claimed] && family != "text"
+	},
+}
+
+var attachmentTypePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9!#$&^_.+-]*/(\*|[a-z0-9][a-z0-9!#$&^_.+-]*)$`)
+
+// sniffAttachment returns the media type of data going by its bytes: http.DetectContentType's
+// answer, after looking for executables and scripts, which it reports as octet-stream or text,
+// and for SVG, which it reports as XML or text
+func sniffAttachment(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("MZ")):
+		return "application/x-msdownload"
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return "application/x-executable"
+	case bytes.HasPrefix(data, []byte{0xfe, 0xed, 0xfa, 0xce}), bytes.HasPrefix(data, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.HasPrefix(data, []byte{0xce, 0xfa, 0xed, 0xfe}), bytes.HasPrefix(data, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return "application/x-mach-binary"
+	case bytes.HasPrefix(data, []byte{0xca, 0xfe, 0xba, 0xbe}):
+		return "application/java-vm" // or a universal Mach-O binary
+	case bytes.HasPrefix(data, []byte("#!")):
+		return "text/x-shellscript"
+	}
+
+	mediaType := normalizeAttachmentType(http.DetectContentType(data))
+	if mediaType == "application/xml" || mediaType == "text/plain" {
+		head := data
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+		if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	return mediaType
+}
+
+// normalizeAttachmentType strips parameters from a media type, lowercases it and resolves
+// aliases; "" for an empty or malformed one
+func normalizeAttachmentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if alias, ok := attachmentTypeAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// attachmentTypeAgrees reports whether a claimed type is consistent with the sniffed one
+func attachmentTypeAgrees(sniffed, claimed string) bool {
+	if claimed == sniffed {
+		return true
+	}
+	holds, ok := attachmentContainers[sniffed]
+	return ok && holds(claimed)
+}
+
+// checkAttachment works out the type of an uploaded file and checks it against what the
+// client claimed and against the workspace's policy, returning the type to store it as.
+// maxBytes is the deployment's limit, planBytes the plan's (zero for none).
+func checkAttachment(data []byte, name, claimedType string, policy *models.AttachmentPolicy, maxBytes, planBytes int64) (string, error) {
+	sniffed := sniffAttachment(data)
+	ext := strings.ToLower(path.Ext(name))
+	if dangerousAttachmentExtensions[ext] {
+		return "", validationError(ext + " files are not allowed")
+	}
+
+	contentType := sniffed
+	for _, claim := range []string{normalizeAttachmentType(claimedType), attachmentExtensionTypes[ext]} {
+		if claim == "" || claim == "application/octet-stream" {
+			continue
+		}
+		if dangerousAttachmentTypes[claim] {
+			return "", validationError(claim + " files are not allowed")
+		}
+		if !attachmentTypeAgrees(sniffed, claim) {
+			return "", validationError(fmt.Sprintf("the file's contents (%s) do not match its type or name (%s)", sniffed, claim))
+		}
+		if contentType == sniffed {
+			contentType = claim
+		}
+	}
+	if dangerousAttachmentTypes[sniffed] {
+		return "", validationError(sniffed + " files are not allowed")
+	}
+
+	if policy != nil {
+		if matchAttachmentType(policy.Deny, contentType) != "" ||
+			(len(policy.Allow) > 0 && matchAttachmentType(policy.Allow, contentType) == "") {
+			return "", forbiddenError("the workspace does not allow " + contentType + " files")
+		}
+	}
+
+	size := int64(len(data))
+	if size > maxBytes {
+		return "", validationError(fmt.Sprintf("files are limited to %d KB", maxBytes>>10))
+	}
+	if planBytes > 0 && size > planBytes {
+		return "", quotaError(fmt.Sprintf("the workspace's plan allows files of up to %d KB", planBytes>>10))
+	}
+	if policy != nil {
+		patterns := make([]string, 0, len(policy.MaxBytes))
+		for pattern := range policy.MaxBytes {
+			patterns = append(patterns, pattern)
+		}
+		if pattern := matchAttachmentType(patterns, contentType); pattern != "" && size > policy.MaxBytes[pattern] {
+			return "", validationError(fmt.Sprintf("%s files are limited to %d KB in this workspace", contentType, policy.MaxBytes[pattern]>>10))
+		}
+	}
+	return contentType, nil
+}
+
+// matchAttachmentType returns the most specific of patterns that contentType matches: itself,
+// then its family ("image/*"); "" if none does
+func matchAttachmentType(patterns []string, contentType string) string {
+	family, _, _ := strings.Cut(contentType, "/")
+	match := ""
+	for _, pattern := range patterns {
+		if pattern == contentType {
+			return pattern
+		}
+		if pattern == family+"/*" {
+			match = pattern
+		}
+	}
+	return match
+}
+
+// normalizeAttachmentPolicy checks a workspace's new policy and returns it cleaned up; nil
+// when it has no rules
+func normalizeAttachmentPolicy(req *models.AttachmentPolicy) (*models.AttachmentPolicy, error) {
+	if len(req.Allow)+len(req.Deny)+len(req.MaxBytes) > maxAttachmentPolicyEntries {
+		return nil, validationError(fmt.Sprintf("an attachment policy has at most %d entries", maxAttachmentPolicyEntries))
+	}
+	policy := &models.AttachmentPolicy{}
+	for _, list := range []struct {
+		from []string
+		to   *[]string
+	}{{req.Allow, &policy.Allow}, {req.Deny, &policy.Deny}} {
+		for _, pattern := range list.from {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if !attachmentTypePattern.MatchString(pattern) {
+				return nil, validationError("invalid media type " + pattern)
+			}
+			*list.to = append(*list.to, pattern)
+		}
+	}
+	for pattern, limit := range req.MaxBytes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if !attachmentTypePattern.MatchString(pattern) {
+			return nil, validationError("invalid media type " + pattern)
+		}
+		if limit <= 0 {
+			return nil, validationError("maxBytes for " + pattern + " must be positive")
+		}
+		if policy.MaxBytes == nil {
+			policy.MaxBytes = make(map[string]int64)
+		}
+		policy.MaxBytes[pattern] = limit
+	}
+	if req.ExplicitThreshold < 0 || req.ExplicitThreshold > 1 {
+		return nil, validationError("explicitThreshold must be between 0 and 1")
+	}
+	policy.ExplicitImages = req.ExplicitImages
+	policy.ExplicitThreshold = req.ExplicitThreshold
+	if len(policy.Allow)+len(policy.Deny)+len(policy.MaxBytes) == 0 && policy.ExplicitImages == "" && policy.ExplicitThreshold == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
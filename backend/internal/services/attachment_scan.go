@@ -0,0 +1,403 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// With a scanner configured, uploads are stored as pending and served to nobody until a scan
+// clears them. AttachmentService leases pending files to one node at a time, as the unfurler
+// does messages, and hands each to the scanner; a scanner that fails or times out leaves the
+// lease to run out and the file to be tried again. A file the scanner blocks loses its bytes.
+// Images then go to the classifier, if one is configured (see attachment_classify.go).
+// Scanners speak clamd's INSTREAM protocol or ICAP (RFC 3507) RESPMOD, which most commercial
+// engines offer.
+
+// Attachment scanner providers
+const (
+	ScannerOff    = "off"
+	ScannerClamAV = "clamav"
+	ScannerICAP   = "icap"
+)
+
+const scanChunkSize = 64 << 10
+
+// AttachmentScanner looks for malware in a file. found names what it found, and is empty for
+// a clean file; an error means the file could not be scanned.
+type AttachmentScanner interface {
+	Scan(ctx context.Context, data []byte) (found string, err error)
+}
+
+// NewAttachmentScanner returns the scanner for provider, or nil when scanning is off. addr is
+// clamd's host:port (or socket path) or the ICAP service URL.
+func NewAttachmentScanner(provider, addr string) (AttachmentScanner, error) {
+	if provider != ScannerOff && provider != "" && addr == "" {
+		return nil, fmt.Errorf("the %s scanner needs an address", provider)
+	}
+	switch provider {
+	case ScannerOff, "":
+		return nil, nil
+	case ScannerClamAV:
+		network := "tcp"
+		if strings.HasPrefix(addr, "/") {
+			network = "unix"
+		}
+		return &clamdScanner{network: network, addr: addr}, nil
+	case ScannerICAP:
+		service, err := url.Parse(addr)
+		if err != nil || service.Scheme != "icap" || service.Hostname() == "" {
+			return nil, fmt.Errorf("ICAP service %q must be an icap://host[:port]/service URL", addr)
+		}
+		if service.Port() == "" {
+			service.Host = net.JoinHostPort(service.Hostname(), "1344")
+		}
+		return &icapScanner{service: service}, nil
+	}
+	return nil, fmt.Errorf("unknown attachment scanner %q", provider)
+}
+
+// dialScanner connects to a scanner, bounding the whole exchange by ctx's deadline
+func dialScanner(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// clamdScanner streams files to clamd with INSTREAM
+type clamdScanner struct {
+	network string
+	addr    string
+}
+
+func (c *clamdScanner) Scan(ctx context.Context, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, c.network, c.addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach clamd: %w", err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	for len(data) > 0 {
+		chunk := data[:min(len(data), scanChunkSize)]
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		w.Write(size[:])
+		w.Write(chunk)
+		data = data[len(chunk):]
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send file to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	reply = strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), "\x00")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// icapScanner sends files to an ICAP service as the body of an HTTP response to modify. The
+// service answers 204 for a clean file and anything else it changes or blocks.
+type icapScanner struct {
+	service *url.URL
+}
+
+func (c *icapScanner) Scan(ctx context.Context, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, "tcp", c.service.Host)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach ICAP service: %w", err)
+	}
+	defer conn.Close()
+
+	httpHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: " + strconv.Itoa(len(data)) + "\r\n\r\n"
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n",
+		c.service, c.service.Host, len(httpHeader))
+	w.WriteString(httpHeader)
+	fmt.Fprintf(w, "%x\r\n", len(data))
+	w.Write(data)
+	w.WriteString("\r\n0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send file to ICAP service: %w", err)
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+	proto, code, _ := strings.Cut(status, " ")
+	code, _, _ = strings.Cut(code, " ")
+	if !strings.HasPrefix(proto, "ICAP/") {
+		return "", fmt.Errorf("ICAP service answered %q", status)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+
+	switch code {
+	case "204":
+		return "", nil
+	case "200":
+		return icapFinding(header), nil
+	}
+	return "", fmt.Errorf("ICAP service answered %q", status)
+}
+
+// icapFinding names the threat an ICAP service reports, from the headers engines use for it
+func icapFinding(header textproto.MIMEHeader) string {
+	if infection := header.Get("X-Infection-Found"); infection != "" {
+		for _, field := range strings.Split(infection, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok && strings.EqualFold(name, "Threat") {
+				return value
+			}
+		}
+	}
+	for _, name := range []string{"X-Virus-ID", "X-Violations-Found"} {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return "blocked by the ICAP service"
+}
+
+const scanBatchSize = 20
+
+// RunScanner scans pending uploads every ScanInterval until ctx is cancelled
+func (s *AttachmentService) RunScanner(ctx context.Context) {
+	if (s.config.Scanner == nil && s.config.Classifier == nil) || s.config.ScanInterval <= 0 {
+		s.logger.Info("Attachment scanning disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.config.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepEachTenant(ctx, s.db, s.logger, s.scanPending)
+		}
+	}
+}
+
+// scanPending works through up to scanBatchSize pending uploads
+func (s *AttachmentService) scanPending(ctx context.Context) {
+	for i := 0; i < scanBatchSize && ctx.Err() == nil; i++ {
+		attachment, err := s.claimScan(ctx)
+		if err != nil {
+			s.logger.Error("Failed to claim an attachment to scan", logging.Err(err))
+			return
+		}
+		if attachment == nil {
+			return
+		}
+
+		scanCtx, cancel := context.WithTimeout(ctx, s.config.ScanTimeout)
+		found, review, err := s.inspect(scanCtx, attachment)
+		cancel()
+		if err == nil {
+			err = s.finishScan(ctx, attachment, found, review)
+		}
+		if err != nil {
+			// The lease runs out and the file is scanned again
+			s.logger.Error("Failed to scan attachment", "id", attachment.ID, logging.ConversationID, attachment.ConversationID, logging.Err(err))
+		}
+	}
+}
+
+// inspect runs a claimed upload past the scanner and then, if it is clean, the classifier.
+// found names any malware; review is the classifier's decision on an explicit image.
+func (s *AttachmentService) inspect(ctx context.Context, attachment *models.Attachment) (found string, review *models.AttachmentReview, err error) {
+	if s.config.Scanner != nil {
+		if found, err = s.config.Scanner.Scan(ctx, attachment.Data); err != nil || found != "" {
+			return found, nil, err
+		}
+	}
+	if s.config.Classifier != nil {
+		review, err = s.classify(ctx, attachment)
+	}
+	return "", review, err
+}
+
+// claimScan leases the oldest pending upload to this node
+func (s *AttachmentService) claimScan(ctx context.Context) (*models.Attachment, error) {
+	now := s.clock.Now()
+	// Long enough to scan the file and store the verdict
+	leaseUntil := now.Add(2 * s.config.ScanTimeout)
+
+	var attachment models.Attachment
+	err := s.db.Collection(ctx, attachmentsCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"scanStatus": models.AttachmentScanPending,
+			"$or": bson.A{
+				bson.M{"scanLeaseUntil": bson.M{"$exists": false}},
+				bson.M{"scanLeaseUntil": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"scanLeaseUntil": leaseUntil}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// finishScan records the verdict, dropping the bytes of a file the scanner blocked and opening
+// any review, and brings the messages sharing the file up to date. Images blocked by the
+// classifier keep their bytes for the review.
+func (s *AttachmentService) finishScan(ctx context.Context, attachment *models.Attachment, found string, review *models.AttachmentReview) error {
+	now := s.clock.Now()
+	set := bson.M{"scanStatus": models.AttachmentScanClean, "scannedAt": now}
+	unset := bson.M{"scanLeaseUntil": ""}
+	switch {
+	case found != "":
+		set["scanStatus"] = models.AttachmentScanBlocked
+		set["scanResult"] = found
+		unset["data"] = ""
+	case review != nil && review.Action == models.ExplicitImagesBlock:
+		set["scanStatus"] = models.AttachmentScanBlocked
+		set["scanResult"] = explicitImageResult
+	}
+	matched := false
+	err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		result, err := s.db.Collection(txCtx, attachmentsCollection).UpdateOne(txCtx,
+			bson.M{"_id": attachment.ID, "scanStatus": models.AttachmentScanPending},
+			bson.M{"$set": set, "$unset": unset})
+		if err != nil {
+			return fmt.Errorf("failed to record scan: %w", err)
+		}
+		if matched = result.MatchedCount > 0; !matched || review == nil {
+			return nil
+		}
+		if _, err := s.db.Collection(txCtx, attachmentReviewsCollection).InsertOne(txCtx, review); err != nil {
+			return fmt.Errorf("failed to open attachment review: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return nil // deleted meanwhile
+	}
+
+	attachment.ScanStatus = set["scanStatus"].(string)
+	switch {
+	case found != "":
+		s.logger.WarnContext(ctx, "Attachment blocked by scanner", "id", attachment.ID, logging.ConversationID, attachment.ConversationID,
+			"uploader_id", attachment.UploaderID, "found", found)
+	case review != nil:
+		s.logger.WarnContext(ctx, "Explicit image held for review", "id", attachment.ID, logging.ConversationID, attachment.ConversationID,
+			"uploader_id", attachment.UploaderID, "score", review.Score, "action", review.Action, "review_id", review.ID)
+	}
+	if err := s.messageService.updateFileScans(ctx, attachment); err != nil {
+		// The verdict stands; the messages keep showing the file as pending
+		s.logger.ErrorContext(ctx, "Failed to update messages sharing a scanned attachment", "id", attachment.ID,
+			logging.ConversationID, attachment.ConversationID, logging.Err(err))
+	}
+	return nil
+}
+
+// updateFileScans sets a scanned or reviewed attachment's status on the file messages sharing
+// it and announces each with a message.updated event. An event for a message whose own message.created
+// is not yet published is left to the outbox relay, so it cannot overtake it.
+func (s *MessageService) updateFileScans(ctx context.Context, attachment *models.Attachment) error {
+	collection := s.db.Collection(ctx, "messages")
+	cursor, err := collection.Find(ctx, bson.M{
+		"conversationId":       attachment.ConversationID,
+		"type":                 models.MessageTypeFile,
+		"payload.attachmentId": attachment.ID,
+		"payload.scanStatus":   bson.M{"$exists": true, "$ne": attachment.ScanStatus},
+		"retractedAt":          bson.M{"$exists": false},
+	}, options.Find().SetProjection(bson.M{"payload": 1, "streamSeq": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find file messages: %w", err)
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return fmt.Errorf("failed to decode file messages: %w", err)
+	}
+
+	now := s.clock.Now()
+	for _, message := range messages {
+		var file models.File
+		if err := bson.Unmarshal(message.Payload, &file); err != nil {
+			return fmt.Errorf("failed to decode file payload: %w", err)
+		}
+		previous := file.ScanStatus
+		file.ScanStatus = attachment.ScanStatus
+
+		var entry *models.OutboxEntry
+		err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+			entry = nil
+			result, err := collection.UpdateOne(txCtx,
+				bson.M{"_id": message.ID, "payload.scanStatus": previous},
+				bson.M{"$set": bson.M{"payload.scanStatus": file.ScanStatus}})
+			if err != nil {
+				return fmt.Errorf("failed to update file message: %w", err)
+			}
+			if result.MatchedCount == 0 {
+				return nil
+			}
+
+			entry, err = s.updateEntry(&models.WSMessageUpdatedData{
+				ConversationID: attachment.ConversationID,
+				ID:             message.ID,
+				File:           &file,
+			}, now)
+			if err != nil {
+				return err
+			}
+			if _, err := s.db.Collection(txCtx, outboxCollection).InsertOne(txCtx, entry); err != nil {
+				return fmt.Errorf("failed to write outbox entry: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if entry != nil && message.StreamSeq != 0 {
+			s.publishEntry(ctx, entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Participants upload a file to a conversation first and share it with a file message
+// afterwards. Uploads are kept whole in the attachments collection, so a file is limited to
+// what fits in one document; usage metering and purges already count and remove them by
+// conversation. An upload no message shares stays until its conversation is deleted or purged.
+
+const (
+	attachmentsCollection = "attachments"
+	maxAttachmentName     = 255
+)
+
+// AttachmentConfig configures uploads and their scanning
+type AttachmentConfig struct {
+	MaxBytes      int64             // the largest file the deployment accepts, whatever the plan allows
+	StripMetadata bool              // removes EXIF and similar metadata from images; see attachment_metadata.go
+	Scanner       AttachmentScanner // nil stores uploads unscanned
+	Classifier    ImageClassifier   // nil leaves images unclassified; see attachment_classify.go
+	ScanInterval  time.Duration     // how often pending uploads are looked for
+	ScanTimeout   time.Duration     // per file, scan and classification together
+}
+
+// AttachmentService stores the files uploaded to conversations
+type AttachmentService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	messageService      *MessageService
+	usageService        *UsageService
+	userService         *UserService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+	ids                 IDGenerator
+	config              AttachmentConfig
+}
+
+func NewAttachmentService(db *database.MongoDB, conversationService *ConversationService, messageService *MessageService, usageService *UsageService, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, config AttachmentConfig) *AttachmentService {
+	return &AttachmentService{
+		db:                  db,
+		conversationService: conversationService,
+		messageService:      messageService,
+		usageService:        usageService,
+		userService:         userService,
+		auditService:        auditService,
+		clock:               clk,
+		logger:              logger,
+		ids:                 ids,
+		config:              config,
+	}
+}
+
+// Upload stores a participant's file in a conversation once its bytes agree with the type
+// and name claimed for it and the workspace's policy, plan and quotas allow it. Images are
+// stored without their metadata, and with a scanner or classifier configured the file stays
+// pending until they have seen it.
+func (s *AttachmentService) Upload(ctx context.Context, conversationID, uploaderID, name, claimedType string, data []byte) (*models.Attachment, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, uploaderID); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, validationError("the file is empty")
+	}
+	name = attachmentName(name)
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.usageService.CheckSend(ctx, conversation.WorkspaceID); err != nil {
+		return nil, err
+	}
+	policy, err := attachmentPolicy(ctx, s.db, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	entitlements, err := workspaceEntitlements(ctx, s.db, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, err := checkAttachment(data, name, claimedType, policy, s.config.MaxBytes, entitlements.MaxFileBytes)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.StripMetadata {
+		if data, err = stripImageMetadata(contentType, data); err != nil {
+			return nil, validationError("the image is damaged and cannot be stored")
+		}
+	}
+
+	attachment := &models.Attachment{
+		ID:             s.ids.NewID(),
+		ConversationID: conversationID,
+		UploaderID:     uploaderID,
+		Name:           name,
+		ContentType:    contentType,
+		Size:           int64(len(data)),
+		Data:           data,
+		CreatedAt:      s.clock.Now(),
+	}
+	if s.config.Scanner != nil || s.classifies(contentType, policy) {
+		attachment.ScanStatus = models.AttachmentScanPending
+	}
+	if _, err := s.db.Collection(ctx, attachmentsCollection).InsertOne(ctx, attachment); err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "Attachment uploaded", "id", attachment.ID, "conversation_id", conversationID,
+		"content_type", contentType, "size", attachment.Size)
+	return attachment, nil
+}
+
+// Get returns an attachment, with its bytes, to a participant of its conversation. Files still
+// being scanned and blocked files are served to nobody.
+func (s *AttachmentService) Get(ctx context.Context, conversationID, attachmentID, userID string) (*models.Attachment, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+	attachment, err := findAttachment(ctx, s.db, bson.M{"_id": attachmentID, "conversationId": conversationID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch attachment.ScanStatus {
+	case models.AttachmentScanPending:
+		return nil, conflictError("the file is still being scanned")
+	case models.AttachmentScanBlocked:
+		return nil, blockedAttachmentError(attachment)
+	}
+	return attachment, nil
+}
+
+// attachedFile is the payload of a file message sharing one of the sender's uploads. A file
+// still being scanned may be shared, and the message follows its scan.
+func (s *MessageService) attachedFile(ctx context.Context, conversationID, attachmentID, senderID string) (*models.File, error) {
+	attachment, err := findAttachment(ctx, s.db,
+		bson.M{"_id": attachmentID, "conversationId": conversationID, "uploaderId": senderID},
+		bson.M{"data": 0})
+	if err != nil {
+		return nil, err
+	}
+	if attachment.ScanStatus == models.AttachmentScanBlocked {
+		return nil, blockedAttachmentError(attachment)
+	}
+	return &models.File{
+		AttachmentID: attachment.ID,
+		Name:         attachment.Name,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+		ScanStatus:   attachment.ScanStatus,
+	}, nil
+}
+
+// blockedAttachmentError says who blocked a file
+func blockedAttachmentError(attachment *models.Attachment) error {
+	switch attachment.ScanResult {
+	case explicitImageResult:
+		return forbiddenError("the image is withheld until a moderator reviews it")
+	case removedByModeratorResult:
+		return forbiddenError("the file was removed by a moderator")
+	}
+	return forbiddenError("the file was blocked by the virus scanner")
+}
+
+// attachmentPolicy returns a workspace's attachment policy, nil if it has none
+func attachmentPolicy(ctx context.Context, db *database.MongoDB, workspaceID string) (*models.AttachmentPolicy, error) {
+	var workspace models.Workspace
+	err := db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": workspaceID},
+		options.FindOne().SetProjection(bson.M{"attachmentPolicy": 1})).Decode(&workspace)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find workspace: %w", err)
+	}
+	return workspace.AttachmentPolicy, nil
+}
+
+// findAttachment finds one attachment; projection may leave out its bytes
+func findAttachment(ctx context.Context, db *database.MongoDB, filter, projection bson.M) (*models.Attachment, error) {
+	opts := options.FindOne()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	var attachment models.Attachment
+	err := db.Collection(ctx, attachmentsCollection).FindOne(ctx, filter, opts).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("attachment not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// attachmentName keeps the last element of a client's file name, without control characters,
+// so it is safe to show and to offer as a download name
+func attachmentName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, path.Base(strings.ReplaceAll(name, `\`, "/")))
+	name = strings.TrimSpace(name)
+	if len(name) > maxAttachmentName {
+		name = strings.ToValidUTF8(name[:maxAttachmentName], "")
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+)
+
+// Audit actions
+const (
+	AuditRetentionUpdated   = "retention.updated"
+	AuditRetentionRequested = "retention.change_requested"
+	AuditRetentionApproved  = "retention.change_approved"
+	AuditRetentionRejected  = "retention.change_rejected"
+
+	AuditWorkspacePurgeStarted    = "workspace.purge_started"
+	AuditWorkspacePurgeCompleted  = "workspace.purge_completed"
+	AuditWorkspaceOrphansRepaired = "workspace.orphans_repaired"
+	AuditWorkspaceUpdated         = "workspace.updated"
+	AuditWorkspaceRolesUpdated    = "workspace.roles_updated"
+	AuditWorkspacePlanUpdated     = "workspace.plan_updated"
+
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+
+	AuditSCIMTokenCreated = "scim.token_created"
+	AuditSCIMTokenRevoked = "scim.token_revoked"
+	AuditUserDeactivated  = "user.deactivated"
+	AuditUserReactivated  = "user.reactivated"
+
+	AuditWatchGranted  = "watch.granted"
+	AuditWatchRevoked  = "watch.revoked"
+	AuditWatchAccessed = "watch.accessed"
+
+	AuditBotAdded   = "bot.added"
+	AuditBotUpdated = "bot.updated"
+	AuditBotRemoved = "bot.removed"
+
+	AuditTelegramLinked   = "telegram.linked"
+	AuditTelegramUnlinked = "telegram.unlinked"
+
+	AuditStreamReconfigScheduled  = "stream.reconfig_scheduled"
+	AuditStreamReconfigRejected   = "stream.reconfig_rejected"
+	AuditStreamReconfigCompleted  = "stream.reconfig_completed"
+	AuditStreamReconfigRolledBack = "stream.reconfig_rolled_back"
+
+	AuditSettingsUpdated = "settings.updated"
+
+	AuditMessagesImported = "messages.imported"
+
+	AuditAttachmentReviewed = "attachment.reviewed"
+)
+
+type AuditService struct {
+	db    *database.MongoDB
+	clock clock.Clock
+	ids   IDGenerator
+}
+
+func NewAuditService(db *database.MongoDB, clk clock.Clock, ids IDGenerator) *AuditService {
+	return &AuditService{db: db, clock: clk, ids: ids}
+}
+
+// Record appends an entry to the audit log
+func (s *AuditService) Record(ctx context.Context, action, actorID, conversationID string, details map[string]interface{}) error {
+	collection := s.db.Collection(ctx, "audit_log")
+
+	entry := &models.AuditEntry{
+		ID:             s.ids.NewID(),
+		Action:         action,
+		ActorID:        actorID,
+		ConversationID: conversationID,
+		Details:        details,
+		CreatedAt:      s.clock.Now(),
+	}
+
+	_, err := collection.InsertOne(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A client that reads slower than frames arrive is handled by what is falling behind.
+// Typing and presence frames only matter while fresh, so they go to a small queue of their
+// own that drops its oldest frame when full; the client never notices beyond missing a stale
+// indicator. Every other frame carries state the client cannot recover without resyncing, so
+// when the main queue is full the client is disconnected with SLOW_CONSUMER and catches up
+// through resume on reconnect.
+
+// droppableFrames are the frame types that may be discarded under backpressure
+var droppableFrames = map[string]bool{
+	"typing.update":   true,
+	"presence.update": true,
+	"presence.delta":  true,
+}
+
+// ephemeralQueue holds a client's droppable frames
+type ephemeralQueue struct {
+	mu     sync.Mutex
+	frames []*outboundFrame
+	size   int
+	ready  chan struct{} // signalled when frames are waiting
+}
+
+func newEphemeralQueue(size int) *ephemeralQueue {
+	return &ephemeralQueue{size: size, ready: make(chan struct{}, 1)}
+}
+
+// push adds a frame, dropping the oldest one if the queue is full. It returns the dropped
+// frame, if any.
+func (q *ephemeralQueue) push(frame *outboundFrame) *outboundFrame {
+	q.mu.Lock()
+	var dropped *outboundFrame
+	if len(q.frames) >= q.size {
+		dropped = q.frames[0]
+		q.frames = q.frames[1:]
+	}
+	q.frames = append(q.frames, frame)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// take removes and returns every waiting frame
+func (q *ephemeralQueue) take() []*outboundFrame {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	frames := q.frames
+	q.frames = nil
+	return frames
+}
+
+func (q *ephemeralQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// backpressureStats counts what slow clients cost, for the debug endpoint
+type backpressureStats struct {
+	mu      sync.Mutex
+	dropped map[string]int64 // frame type -> frames dropped
+
+	slowDisconnects atomic.Int64
+}
+
+func (s *backpressureStats) recordDrop(frameType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dropped == nil {
+		s.dropped = make(map[string]int64)
+	}
+	s.dropped[frameType]++
+}
+
+func (s *backpressureStats) droppedByType() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := make(map[string]int64, len(s.dropped))
+	for frameType, count := range s.dropped {
+		dropped[frameType] = count
+	}
+	return dropped
+}
+
+// queueDroppable hands a typing or presence frame to the write pump without ever blocking
+func (c *Client) queueDroppable(frame *outboundFrame) {
+	c.sendMu.RLock()
+	closed := c.sendClosed
+	c.sendMu.RUnlock()
+	if closed {
+		return
+	}
+
+	if dropped := c.ephemeral.push(frame); dropped != nil {
+		c.Hub.backpressure.recordDrop(dropped.Type)
+	}
+}
+
+// disconnectSlow drops a client whose main queue overflowed
+func (c *Client) disconnectSlow() {
+	if !c.closeSendWith(&CloseSlowConsumer) {
+		return
+	}
+	c.Hub.backpressure.slowDisconnects.Add(1)
+	c.logger.Warn("Disconnecting slow consumer", "queued", len(c.Send))
+
+	// The write pump may be blocked on the socket itself, so close it from here as well
+	go c.closeWith(CloseSlowConsumer)
+}
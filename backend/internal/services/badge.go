@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	natsgo "github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// badgeConversationCap bounds the unread messages counted in one conversation, so a
+	// long-abandoned room does not make every badge request scan its whole history
+	badgeConversationCap = 1000
+	// badgeCacheSweepSize is the cache size past which expired entries are swept on write
+	badgeCacheSweepSize = 10000
+)
+
+// BadgeService counts what a user has not read, for app-icon badges. One aggregation walks the
+// user's participants and looks up the messages after each read position, not counting their
+// own or retracted ones. Results are cached per user for the TTL and dropped as soon as the
+// user reads something, on any node, through chat.users.receipt. A zero TTL disables caching.
+type BadgeService struct {
+	db       *database.MongoDB
+	natsConn *nats.NATSConnection
+	clock    clock.Clock
+	logger   *slog.Logger
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*models.Badge
+	sub     *natsgo.Subscription
+}
+
+func NewBadgeService(db *database.MongoDB, natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger, ttl time.Duration) *BadgeService {
+	return &BadgeService{
+		db:       db,
+		natsConn: natsConn,
+		clock:    clk,
+		logger:   logger,
+		ttl:      ttl,
+		entries:  make(map[string]*models.Badge),
+	}
+}
+
+// Start subscribes to the read positions that invalidate cached badges
+func (s *BadgeService) Start() error {
+	if s.ttl <= 0 {
+		return nil
+	}
+
+	sub, err := s.natsConn.Conn.Subscribe(nats.SelfReceiptSubject, func(msg *natsgo.Msg) {
+		var receipt models.WSReceiptSelfData
+		if err := json.Unmarshal(msg.Data, &receipt); err != nil {
+			s.logger.Error("Failed to unmarshal self receipt data", logging.Err(err))
+			return
+		}
+		s.mu.Lock()
+		delete(s.entries, receipt.UserID)
+		s.mu.Unlock()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to self receipts: %w", err)
+	}
+	s.sub = sub
+	return nil
+}
+
+func (s *BadgeService) Stop() {
+	if s.sub != nil {
+		s.sub.Unsubscribe()
+	}
+}
+
+// GetBadge returns the user's unread totals, from cache when fresh
+func (s *BadgeService) GetBadge(ctx context.Context, userID string) (*models.Badge, error) {
+	now := s.clock.Now()
+	if s.ttl > 0 {
+		s.mu.Lock()
+		badge, ok := s.entries[userID]
+		s.mu.Unlock()
+		if ok && now.Sub(badge.GeneratedAt) <= s.ttl {
+			return badge, nil
+		}
+	}
+
+	badge, err := s.countUnread(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	badge.GeneratedAt = now
+
+	if s.ttl > 0 {
+		s.mu.Lock()
+		if len(s.entries) >= badgeCacheSweepSize {
+			for id, entry := range s.entries {
+				if now.Sub(entry.GeneratedAt) > s.ttl {
+					delete(s.entries, id)
+				}
+			}
+		}
+		s.entries[userID] = badge
+		s.mu.Unlock()
+	}
+	return badge, nil
+}
+
+func (s *BadgeService) countUnread(ctx context.Context, userID string) (*models.Badge, error) {
+	cursor, err := s.db.Collection(ctx, "participants").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": s.db.Collection(ctx, "messages").Name(),
+			"let": bson.M{
+				"conversationId": "$conversationId",
+				"lastRead":       bson.M{"$ifNull": bson.A{"$lastReadMessageId", 0}},
+			},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"$expr": bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$conversationId", "$$conversationId"}},
+						bson.M{"$gt": bson.A{"$_id", "$$lastRead"}},
+					}},
+					"senderId":    bson.M{"$ne": userID},
+					"retractedAt": bson.M{"$exists": false},
+				}},
+				bson.M{"$limit": badgeConversationCap},
+				bson.M{"$project": bson.M{"mentioned": bson.M{"$in": bson.A{userID, bson.M{"$ifNull": bson.A{"$mentions", bson.A{}}}}}}},
+			},
+			"as": "unread",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"messages": bson.M{"$size": "$unread"},
+			"mentions": bson.M{"$size": bson.M{"$filter": bson.M{"input": "$unread", "cond": "$$this.mentioned"}}},
+		}}},
+		{{Key: "$match", Value: bson.M{"messages": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"messages":      bson.M{"$sum": "$messages"},
+			"conversations": bson.M{"$sum": 1},
+			"mentions":      bson.M{"$sum": "$mentions"},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Messages      int `bson:"messages"`
+		Conversations int `bson:"conversations"`
+		Mentions      int `bson:"mentions"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode unread counts: %w", err)
+	}
+
+	badge := &models.Badge{}
+	if len(totals) == 1 {
+		badge.UnreadMessages = totals[0].Messages
+		badge.UnreadConversations = totals[0].Conversations
+		badge.UnreadMentions = totals[0].Mentions
+	}
+	return badge, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A workspace's plan decides its entitlements, kept on the workspace document. The billing
+// provider is the source of truth: it posts an event to /webhooks/billing whenever a
+// subscription changes, and a workspace it has never mentioned has no limits.
+
+// billingActor is the audit actor for changes made by the billing provider
+const billingActor = "billing"
+
+// plans are the entitlements of the plans the billing provider names; an event may override them
+var plans = map[string]models.Entitlements{
+	"free":       {MaxGroupMembers: 50, HistoryDays: 90, MaxFileBytes: 5 << 20},
+	"team":       {MaxGroupMembers: 1000, MaxFileBytes: 100 << 20},
+	"enterprise": {},
+}
+
+// BillingService applies the billing provider's subscription changes to workspaces
+type BillingService struct {
+	db           *database.MongoDB
+	auditService *AuditService
+	clock        clock.Clock
+	logger       *slog.Logger
+	secret       string
+}
+
+func NewBillingService(db *database.MongoDB, auditService *AuditService, clk clock.Clock, logger *slog.Logger, secret string) *BillingService {
+	return &BillingService{
+		db:           db,
+		auditService: auditService,
+		clock:        clk,
+		logger:       logger,
+		secret:       secret,
+	}
+}
+
+// Enabled reports whether a webhook secret is configured; without one the webhook is not served
+func (s *BillingService) Enabled() bool {
+	return s.secret != ""
+}
+
+// VerifySignature checks X-Billing-Signature, "sha256=" and the hex HMAC-SHA256 of the body
+func (s *BillingService) VerifySignature(body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// ApplyEvent sets a workspace's plan and entitlements. Providers retry and may deliver out of
+// order, so an event no newer than the last one applied is ignored.
+func (s *BillingService) ApplyEvent(ctx context.Context, event *models.BillingEvent) error {
+	entitlements, ok := plans[event.Plan]
+	if event.Entitlements != nil {
+		entitlements = *event.Entitlements
+	} else if !ok {
+		return validationError("unknown plan " + event.Plan + "; send its entitlements")
+	}
+
+	collection := s.db.Collection(ctx, "workspaces")
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": event.WorkspaceID, "$or": bson.A{
+			bson.M{"billingUpdatedAt": bson.M{"$exists": false}},
+			bson.M{"billingUpdatedAt": bson.M{"$lt": event.OccurredAt}},
+		}},
+		bson.M{"$set": bson.M{
+			"plan":             event.Plan,
+			"entitlements":     entitlements,
+			"billingUpdatedAt": event.OccurredAt,
+		}})
+	if err != nil {
+		return fmt.Errorf("failed to update workspace plan: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		err := collection.FindOne(ctx, bson.M{"_id": event.WorkspaceID}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("workspace not found")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find workspace: %w", err)
+		}
+		s.logger.InfoContext(ctx, "Ignored stale billing event", "event_id", event.ID, "workspace_id", event.WorkspaceID)
+		return nil
+	}
+
+	ctx = tenant.NewContext(ctx, event.WorkspaceID)
+	details := map[string]interface{}{"eventId": event.ID, "plan": event.Plan, "entitlements": entitlements}
+	if err := s.auditService.Record(ctx, AuditWorkspacePlanUpdated, billingActor, "", details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", AuditWorkspacePlanUpdated, logging.Err(err))
+	}
+	return nil
+}
+
+// workspaceEntitlements returns what a workspace's plan allows; it is what every enforcement
+// point consults
+func workspaceEntitlements(ctx context.Context, db *database.MongoDB, workspaceID string) (models.Entitlements, error) {
+	var workspace models.Workspace
+	err := db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": workspaceID},
+		options.FindOne().SetProjection(bson.M{"entitlements": 1})).Decode(&workspace)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return models.Entitlements{}, fmt.Errorf("failed to find workspace: %w", err)
+	}
+	return workspace.Entitlements, nil
+}
+
+// historyStart is the oldest a workspace's members may read back to; nil when unlimited
+func historyStart(ctx context.Context, db *database.MongoDB, workspaceID string, now time.Time) (*time.Time, error) {
+	entitlements, err := workspaceEntitlements(ctx, db, workspaceID)
+	if err != nil || entitlements.HistoryDays <= 0 {
+		return nil, err
+	}
+	start := now.AddDate(0, 0, -entitlements.HistoryDays)
+	return &start, nil
+}
+
+// checkGroupSize refuses a group conversation growing past its workspace's plan
+func checkGroupSize(ctx context.Context, db *database.MongoDB, workspaceID string, members int) error {
+	entitlements, err := workspaceEntitlements(ctx, db, workspaceID)
+	if err != nil {
+		return err
+	}
+	if entitlements.MaxGroupMembers > 0 && members > entitlements.MaxGroupMembers {
+		return quotaError(fmt.Sprintf("the workspace's plan allows groups of up to %d members", entitlements.MaxGroupMembers))
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BotService manages each conversation's bot allow-list. Requests authenticated with an API key
+// may only read or post in conversations that list the key with that access; user tokens are
+// unaffected. Conversation admins maintain the list.
+type BotService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	auditService        *AuditService
+	natsConn            *nats.NATSConnection
+	clock               clock.Clock
+	logger              *slog.Logger
+}
+
+func NewBotService(db *database.MongoDB, conversationService *ConversationService, auditService *AuditService, natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger) *BotService {
+	return &BotService{
+		db:                  db,
+		conversationService: conversationService,
+		auditService:        auditService,
+		natsConn:            natsConn,
+		clock:               clk,
+		logger:              logger,
+	}
+}
+
+// ListBots returns a conversation's bot allow-list to any participant
+func (s *BotService) ListBots(ctx context.Context, conversationID, userID string) ([]models.ConversationBot, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if conversation.Bots == nil {
+		return []models.ConversationBot{}, nil
+	}
+	return conversation.Bots, nil
+}
+
+// SetBot adds an API key to the allow-list, or changes the access of one already on it
+func (s *BotService) SetBot(ctx context.Context, conversationID, actorID, apiKeyID string, req *models.SetConversationBotRequest) (*models.ConversationBot, error) {
+	if err := s.requireAdmin(ctx, conversationID, actorID); err != nil {
+		return nil, err
+	}
+	if !req.CanRead && !req.CanPost {
+		return nil, validationError("a bot needs read or post access; remove it instead")
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys from other workspaces are not found, as if they did not exist
+	var key models.APIKey
+	err = s.db.Collection(ctx, "api_keys").FindOne(ctx, bson.M{
+		"_id":         apiKeyID,
+		"workspaceId": conversation.WorkspaceID,
+		"revokedAt":   bson.M{"$exists": false},
+	}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	bot := models.ConversationBot{
+		APIKeyID: key.ID,
+		Name:     key.Name,
+		CanRead:  req.CanRead,
+		CanPost:  req.CanPost,
+		AddedBy:  actorID,
+		AddedAt:  s.clock.Now(),
+	}
+
+	collection := s.db.Collection(ctx, "conversations")
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": conversationID, "bots.apiKeyId": key.ID},
+		bson.M{"$set": bson.M{"bots.$.canRead": bot.CanRead, "bots.$.canPost": bot.CanPost}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update bot: %w", err)
+	}
+
+	action, auditAction := "updated", AuditBotUpdated
+	if result.MatchedCount == 0 {
+		action, auditAction = "added", AuditBotAdded
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": conversationID, "bots.apiKeyId": bson.M{"$ne": key.ID}},
+			bson.M{"$push": bson.M{"bots": bot}},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add bot: %w", err)
+		}
+	}
+
+	s.announce(ctx, conversationID, actorID, action, auditAction, bot)
+	return &bot, nil
+}
+
+// RemoveBot takes an API key off the allow-list
+func (s *BotService) RemoveBot(ctx context.Context, conversationID, actorID, apiKeyID string) error {
+	if err := s.requireAdmin(ctx, conversationID, actorID); err != nil {
+		return err
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+
+	var removed *models.ConversationBot
+	for i := range conversation.Bots {
+		if conversation.Bots[i].APIKeyID == apiKeyID {
+			removed = &conversation.Bots[i]
+		}
+	}
+	if removed == nil {
+		return notFoundError("bot is not on this conversation's allow-list")
+	}
+
+	_, err = s.db.Collection(ctx, "conversations").UpdateOne(ctx,
+		bson.M{"_id": conversationID},
+		bson.M{"$pull": bson.M{"bots": bson.M{"apiKeyId": apiKeyID}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove bot: %w", err)
+	}
+
+	s.announce(ctx, conversationID, actorID, "removed", AuditBotRemoved, *removed)
+	return nil
+}
+
+// Authorize checks that the API key may read or post (see models.BotAccess*) in the conversation
+func (s *BotService) Authorize(ctx context.Context, conversationID, apiKeyID, access string) error {
+	field := "canRead"
+	if access == models.BotAccessPost {
+		field = "canPost"
+	}
+
+	count, err := s.db.Collection(ctx, "conversations").CountDocuments(ctx, bson.M{
+		"_id":  conversationID,
+		"bots": bson.M{"$elemMatch": bson.M{"apiKeyId": apiKeyID, field: true}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check bot allow-list: %w", err)
+	}
+	if count == 0 {
+		return forbiddenError(fmt.Sprintf("bot is not allowed to %s in this conversation", access))
+	}
+
+	return nil
+}
+
+// ReadableConversations returns which of the given conversations the API key may read
+func (s *BotService) ReadableConversations(ctx context.Context, apiKeyID string, conversationIDs []string) (map[string]bool, error) {
+	cursor, err := s.db.Collection(ctx, "conversations").Find(ctx, bson.M{
+		"_id":  bson.M{"$in": conversationIDs},
+		"bots": bson.M{"$elemMatch": bson.M{"apiKeyId": apiKeyID, "canRead": true}},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bot allow-lists: %w", err)
+	}
+
+	var conversations []models.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode conversations: %w", err)
+	}
+
+	readable := make(map[string]bool, len(conversations))
+	for _, conv := range conversations {
+		readable[conv.ID] = true
+	}
+	return readable, nil
+}
+
+func (s *BotService) requireAdmin(ctx context.Context, conversationID, actorID string) error {
+	_, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.ManageBots, "only admins can manage bots")
+	return err
+}
+
+// announce audits an allow-list change and tells subscribed clients about it
+func (s *BotService) announce(ctx context.Context, conversationID, actorID, action, auditAction string, bot models.ConversationBot) {
+	if err := s.auditService.Record(ctx, auditAction, actorID, conversationID, map[string]interface{}{
+		"apiKeyId": bot.APIKeyID,
+		"canRead":  bot.CanRead,
+		"canPost":  bot.CanPost,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit bot change", logging.ConversationID, conversationID, logging.Err(err))
+	}
+
+	event := &models.WSBotEventData{
+		ConversationID: conversationID,
+		Action:         action,
+		Bot:            bot,
+	}
+	if err := s.natsConn.PublishBotEvent(conversationID, event); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish bot event", logging.ConversationID, conversationID, logging.Err(err))
+	}
+}
+
+// authorizeBot applies the conversation's bot allow-list to connections made with an API key
+func (c *Client) authorizeBot(ctx context.Context, conversationID, access string) error {
+	if c.APIKeyID == "" {
+		return nil
+	}
+	return c.Hub.botService.Authorize(ctx, conversationID, c.APIKeyID, access)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Calls are carried by clients; the service only keeps their history. A client records a call
+// when it starts ringing, when someone answers and when it ends. Ending a call posts a system
+// message to the conversation in the same transaction, so the timeline shows every call.
+// Calls nobody answers or ends are marked missed by RunMissedCallSweeper.
+
+const (
+	callsCollection   = "calls"
+	defaultCallsLimit = 20
+	callSweepBatch    = 100
+)
+
+// CallService keeps call history
+type CallService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	messageService      *MessageService
+	userService         *UserService
+	clock               clock.Clock
+	logger              *slog.Logger
+	ids                 IDGenerator
+	// ringTimeout is how long a call may ring unanswered before it is marked missed
+	ringTimeout time.Duration
+}
+
+func NewCallService(db *database.MongoDB, conversationService *ConversationService, messageService *MessageService, userService *UserService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, ringTimeout time.Duration) *CallService {
+	return &CallService{
+		db:                  db,
+		conversationService: conversationService,
+		messageService:      messageService,
+		userService:         userService,
+		clock:               clk,
+		logger:              logger,
+		ids:                 ids,
+		ringTimeout:         ringTimeout,
+	}
+}
+
+// StartCall records a call placed by a participant to the rest of the conversation
+func (s *CallService) StartCall(ctx context.Context, conversationID, callerID string, req *models.StartCallRequest) (*models.Call, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, callerID); err != nil {
+		return nil, err
+	}
+	participants, err := s.conversationService.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	callees := make([]string, 0, len(participants))
+	for _, userID := range participants {
+		if userID != callerID {
+			callees = append(callees, userID)
+		}
+	}
+	if len(callees) == 0 {
+		return nil, validationError("there is nobody else in the conversation to call")
+	}
+
+	call := &models.Call{
+		ID:             s.ids.NewID(),
+		ConversationID: conversationID,
+		CallerID:       callerID,
+		CalleeIDs:      callees,
+		Media:          req.Media,
+		StartedAt:      s.clock.Now(),
+	}
+	if _, err := s.db.Collection(ctx, callsCollection).InsertOne(ctx, call); err != nil {
+		return nil, fmt.Errorf("failed to record call: %w", err)
+	}
+	return call, nil
+}
+
+// AnswerCall records a callee picking up a ringing call. The first answer wins.
+func (s *CallService) AnswerCall(ctx context.Context, callID, userID string) (*models.Call, error) {
+	call, err := s.getCall(ctx, callID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userID == call.CallerID {
+		return nil, forbiddenError("only a callee can answer a call")
+	}
+
+	now := s.clock.Now()
+	var answered models.Call
+	err = s.db.Collection(ctx, callsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": callID, "answeredAt": bson.M{"$exists": false}, "endedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"answeredAt": now, "answeredBy": userID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&answered)
+	if err == mongo.ErrNoDocuments {
+		return nil, conflictError("the call is no longer ringing")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to answer call: %w", err)
+	}
+	return &answered, nil
+}
+
+// EndCall records the end of a call by the caller or a callee. Without an outcome it is
+// inferred from who hangs up and whether the call was answered.
+func (s *CallService) EndCall(ctx context.Context, callID, userID string, req *models.EndCallRequest) (*models.Call, error) {
+	call, err := s.getCall(ctx, callID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if call.EndedAt != nil {
+		return nil, conflictError("the call has already ended")
+	}
+
+	outcome := req.Outcome
+	answered := call.AnsweredAt != nil
+	switch {
+	case outcome == "" && answered:
+		outcome = models.CallOutcomeCompleted
+	case outcome == "" && userID == call.CallerID:
+		outcome = models.CallOutcomeCancelled
+	case outcome == "":
+		outcome = models.CallOutcomeDeclined
+	case outcome == models.CallOutcomeCompleted && !answered:
+		return nil, validationError("a call nobody answered cannot be completed")
+	case outcome != models.CallOutcomeCompleted && outcome != models.CallOutcomeFailed && answered:
+		return nil, validationError("an answered call ends completed or failed")
+	case outcome == models.CallOutcomeDeclined && userID == call.CallerID:
+		return nil, validationError("only a callee can decline a call")
+	case outcome == models.CallOutcomeCancelled && userID != call.CallerID:
+		return nil, validationError("only the caller can cancel a call")
+	}
+
+	return s.endCall(ctx, call, outcome)
+}
+
+// ListCalls returns a conversation's calls, newest first, to its participants. before is the ID
+// of the oldest call on the previous page.
+func (s *CallService) ListCalls(ctx context.Context, conversationID, userID, before string, limit int) ([]models.Call, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultCallsLimit
+	}
+
+	filter := bson.M{"conversationId": conversationID}
+	if before != "" {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+	cursor, err := s.db.Collection(ctx, callsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find calls: %w", err)
+	}
+	calls := []models.Call{}
+	if err := cursor.All(ctx, &calls); err != nil {
+		return nil, fmt.Errorf("failed to decode calls: %w", err)
+	}
+	return calls, nil
+}
+
+// getCall returns a call to one of the people in it
+func (s *CallService) getCall(ctx context.Context, callID, userID string) (*models.Call, error) {
+	var call models.Call
+	err := s.db.Collection(ctx, callsCollection).FindOne(ctx, bson.M{"_id": callID}).Decode(&call)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("call not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get call: %w", err)
+	}
+	if userID == call.CallerID {
+		return &call, nil
+	}
+	for _, calleeID := range call.CalleeIDs {
+		if userID == calleeID {
+			return &call, nil
+		}
+	}
+	return nil, notFoundError("call not found")
+}
+
+// endCall closes a call and posts its system message in one transaction. The update only
+// matches the call as read, still open and answered or not, so when a hang-up races an answer
+// or the sweeper exactly one wins.
+func (s *CallService) endCall(ctx context.Context, call *models.Call, outcome string) (*models.Call, error) {
+	now := s.clock.Now()
+	call.EndedAt = &now
+	call.Outcome = outcome
+	if call.AnsweredAt != nil {
+		call.DurationSeconds = int(now.Sub(*call.AnsweredAt) / time.Second)
+	}
+
+	message := &models.Message{
+		ID:             s.ids.NewMessageID(),
+		ConversationID: call.ConversationID,
+		SenderID:       call.CallerID,
+		ClientMsgID:    "call:" + call.ID,
+		Body:           callSummary(call),
+		CreatedAt:      now,
+		Type:           models.MessageTypeSystem,
+	}
+	err := setPayload(message, &models.SystemEvent{
+		Event:           models.SystemEventCallEnded,
+		CallID:          call.ID,
+		CallMedia:       call.Media,
+		CallOutcome:     outcome,
+		DurationSeconds: call.DurationSeconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	call.MessageID = message.ID
+
+	var sender *models.User
+	if user, err := s.userService.GetUserProfile(ctx, call.CallerID); err == nil {
+		sender = user
+	}
+
+	var entry *models.OutboxEntry
+	err = withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		result, err := s.db.Collection(txCtx, callsCollection).UpdateOne(txCtx,
+			bson.M{"_id": call.ID, "endedAt": bson.M{"$exists": false}, "answeredAt": bson.M{"$exists": call.AnsweredAt != nil}},
+			bson.M{"$set": bson.M{
+				"endedAt":         now,
+				"outcome":         outcome,
+				"durationSeconds": call.DurationSeconds,
+				"messageId":       message.ID,
+			}})
+		if err != nil {
+			return fmt.Errorf("failed to end call: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return conflictError("the call has already ended or been answered")
+		}
+		entry, err = s.messageService.insertMessage(txCtx, message, sender)
+		if err != nil {
+			return fmt.Errorf("failed to post call message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.messageService.publishEntry(ctx, entry)
+	go s.conversationService.UpdateLastMessageAt(context.WithoutCancel(ctx), call.ConversationID)
+	return call, nil
+}
+
+// callSummary is the body of a call's system message, for clients that do not render calls
+func callSummary(call *models.Call) string {
+	switch call.Outcome {
+	case models.CallOutcomeCompleted:
+		d := time.Duration(call.DurationSeconds) * time.Second
+		return fmt.Sprintf("%s call, %dm %02ds", capitalize(call.Media), int(d.Minutes()), call.DurationSeconds%60)
+	case models.CallOutcomeMissed:
+		return "Missed " + call.Media + " call"
+	case models.CallOutcomeDeclined:
+		return "Declined " + call.Media + " call"
+	case models.CallOutcomeCancelled:
+		return "Cancelled " + call.Media + " call"
+	default:
+		return capitalize(call.Media) + " call failed"
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
+
+// RunMissedCallSweeper marks calls that rang out as missed every interval until ctx is cancelled
+func (s *CallService) RunMissedCallSweeper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		s.logger.Info("Missed call sweeper disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepEachTenant(ctx, s.db, s.logger, s.sweepMissedCalls)
+		}
+	}
+}
+
+func (s *CallService) sweepMissedCalls(ctx context.Context) {
+	cursor, err := s.db.Collection(ctx, callsCollection).Find(ctx,
+		bson.M{
+			"startedAt":  bson.M{"$lte": s.clock.Now().Add(-s.ringTimeout)},
+			"answeredAt": bson.M{"$exists": false},
+			"endedAt":    bson.M{"$exists": false},
+		},
+		options.Find().SetSort(bson.M{"startedAt": 1}).SetLimit(callSweepBatch))
+	if err != nil {
+		s.logger.Error("Failed to find unanswered calls", logging.Err(err))
+		return
+	}
+	var due []models.Call
+	if err := cursor.All(ctx, &due); err != nil {
+		s.logger.Error("Failed to decode unanswered calls", logging.Err(err))
+		return
+	}
+
+	for i := range due {
+		_, err := s.endCall(ctx, &due[i], models.CallOutcomeMissed)
+		if err != nil && !errors.Is(err, ErrConflict) { // answered or hung up meanwhile
+			s.logger.Error("Failed to mark call missed", logging.ConversationID, due[i].ConversationID, logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// When a sender or a client address looks like it is spamming, it must solve a CAPTCHA before
+// more of its messages are accepted. Each node watches the sends it serves for two signs: the
+// same message sent again and again by one user, and a burst of messages from one address,
+// whoever sends them. Tripping either stores a challenge in the shared challenges collection,
+// so every node then refuses the user or address with CHALLENGE_REQUIRED until it is solved or
+// lapses. Solving one clears the user's and the address's challenges and spares both for a
+// while. Only senders who could show a CAPTCHA are screened: users, not API keys, on REST and
+// WebSocket connections.
+
+// CAPTCHA providers, selected with CAPTCHA_PROVIDER
+const (
+	CaptchaProviderTurnstile = "turnstile"
+	CaptchaProviderHCaptcha  = "hcaptcha"
+	CaptchaProviderOff       = "off"
+)
+
+const (
+	challengesCollection     = "challenges"
+	captchaResponseMaxBytes  = 64 << 10
+	challengeReasonDuplicate = "repeated message"
+	challengeReasonBurst     = "message burst from address"
+)
+
+// captchaVerifyURLs are the providers' token verification endpoints
+var captchaVerifyURLs = map[string]string{
+	CaptchaProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	CaptchaProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+}
+
+// ChallengeConfig configures CAPTCHA challenges and the heuristics that call for them
+type ChallengeConfig struct {
+	Provider  string
+	SiteKey   string
+	Secret    string
+	VerifyURL string // overrides the provider's endpoint
+	Timeout   time.Duration
+
+	// Identical messages in a row from one user, and messages from one address, within Window
+	// that call for a challenge; zero turns a heuristic off
+	RepeatedMessages int
+	AddressMessages  int
+	Window           time.Duration
+
+	ChallengeTTL time.Duration // how long a challenge stands unsolved
+	PassTTL      time.Duration // how long a user and address that solved one are left alone
+}
+
+// ChallengeService screens sends for spam and verifies solved CAPTCHAs
+type ChallengeService struct {
+	db     *database.MongoDB
+	clock  clock.Clock
+	logger *slog.Logger
+	config ChallengeConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	senders   map[string]*repeatCount
+	addresses map[netip.Addr]*burstCount
+}
+
+// repeatCount tracks a user's run of identical messages
+type repeatCount struct {
+	hash  uint64
+	count int
+	since time.Time
+}
+
+// burstCount counts an address's messages in the current window
+type burstCount struct {
+	count int
+	since time.Time
+}
+
+// captchaVerification is the part of the providers' verification response used here
+type captchaVerification struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func NewChallengeService(db *database.MongoDB, clk clock.Clock, logger *slog.Logger, config ChallengeConfig) *ChallengeService {
+	if config.VerifyURL == "" {
+		config.VerifyURL = captchaVerifyURLs[config.Provider]
+	}
+	return &ChallengeService{
+		db:        db,
+		clock:     clk,
+		logger:    logger,
+		config:    config,
+		client:    &http.Client{Timeout: config.Timeout},
+		senders:   make(map[string]*repeatCount),
+		addresses: make(map[netip.Addr]*burstCount),
+	}
+}
+
+// Enabled reports whether a CAPTCHA provider is configured
+func (s *ChallengeService) Enabled() bool {
+	return s.config.Provider != CaptchaProviderOff && s.config.Provider != ""
+}
+
+// Screen refuses a message with CHALLENGE_REQUIRED while the user or address has a challenge
+// standing, and counts it towards the heuristics, which may raise one there and then. Sends
+// without a known address, such as from bridges and gRPC streams, are not screened.
+func (s *ChallengeService) Screen(ctx context.Context, userID string, addr netip.Addr, body string) error {
+	if !s.Enabled() || !addr.IsValid() {
+		return nil
+	}
+	addr = addr.Unmap()
+	userKey, addrKey := challengeUserKey(ctx, userID), challengeAddrKey(addr)
+
+	required, err := s.required(ctx, userKey, addrKey)
+	if err != nil {
+		return err
+	}
+	if required {
+		return challengeError()
+	}
+
+	flagUser, flagAddr := s.observe(userKey, addr, body)
+	raised := false
+	if flagUser {
+		// A user repeating themselves may be one of several accounts at the address
+		raised = s.require(ctx, userKey, challengeReasonDuplicate) || raised
+		raised = s.require(ctx, addrKey, challengeReasonDuplicate) || raised
+	}
+	if flagAddr {
+		raised = s.require(ctx, addrKey, challengeReasonBurst) || raised
+	}
+	if raised {
+		return challengeError()
+	}
+	return nil
+}
+
+// Status tells the caller whether they must solve a CAPTCHA, and with which provider
+func (s *ChallengeService) Status(ctx context.Context, userID string, addr netip.Addr) (*models.ChallengeStatus, error) {
+	if !s.Enabled() {
+		return &models.ChallengeStatus{Provider: CaptchaProviderOff}, nil
+	}
+	status := &models.ChallengeStatus{Provider: s.config.Provider, SiteKey: s.config.SiteKey}
+	keys := []string{challengeUserKey(ctx, userID)}
+	if addr.IsValid() {
+		keys = append(keys, challengeAddrKey(addr.Unmap()))
+	}
+	required, err := s.required(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	status.Required = required
+	return status, nil
+}
+
+// Solve verifies a solved CAPTCHA's token with the provider and clears the user's and the
+// address's challenges, sparing both for the pass TTL
+func (s *ChallengeService) Solve(ctx context.Context, userID string, addr netip.Addr, token string) error {
+	if !s.Enabled() {
+		return notFoundError("CAPTCHA challenges are not enabled")
+	}
+	if addr.IsValid() {
+		addr = addr.Unmap()
+	}
+	if err := s.verify(ctx, addr, token); err != nil {
+		return err
+	}
+
+	passedUntil := s.clock.Now().Add(s.config.PassTTL)
+	keys := []string{challengeUserKey(ctx, userID)}
+	if addr.IsValid() {
+		keys = append(keys, challengeAddrKey(addr))
+	}
+	for _, key := range keys {
+		_, err := s.db.Collection(ctx, challengesCollection).UpdateOne(ctx, bson.M{"_id": key},
+			bson.M{
+				"$set":   bson.M{"passedUntil": passedUntil},
+				"$unset": bson.M{"reason": "", "requiredAt": "", "requiredUntil": ""},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to clear challenge: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.senders, keys[0])
+	if addr.IsValid() {
+		delete(s.addresses, addr)
+	}
+	s.mu.Unlock()
+
+	s.logger.InfoContext(ctx, "Challenge solved", logging.UserID, userID, "ip", addr.String())
+	return nil
+}
+
+// RunCleanup drops heuristic counts idle for a window, every window, until ctx is cancelled
+func (s *ChallengeService) RunCleanup(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(s.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := s.clock.Now().Add(-s.config.Window)
+			s.mu.Lock()
+			for key, c := range s.senders {
+				if c.since.Before(cutoff) {
+					delete(s.senders, key)
+				}
+			}
+			for addr, c := range s.addresses {
+				if c.since.Before(cutoff) {
+					delete(s.addresses, addr)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// required reports whether any of keys has a challenge standing
+func (s *ChallengeService) required(ctx context.Context, keys ...string) (bool, error) {
+	count, err := s.db.Collection(ctx, challengesCollection).CountDocuments(ctx, bson.M{
+		"_id":           bson.M{"$in": keys},
+		"requiredUntil": bson.M{"$gt": s.clock.Now()},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check challenges: %w", err)
+	}
+	return count > 0, nil
+}
+
+// observe counts a message towards the heuristics, reporting whether the user's repeats or the
+// address's burst has reached its threshold. A count that trips starts over.
+func (s *ChallengeService) observe(userKey string, addr netip.Addr, body string) (flagUser, flagAddr bool) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.RepeatedMessages > 0 {
+		if normalized := strings.ToLower(strings.Join(strings.Fields(body), " ")); normalized != "" {
+			h := fnv.New64a()
+			h.Write([]byte(normalized))
+			hash := h.Sum64()
+
+			c := s.senders[userKey]
+			if c == nil || c.hash != hash || now.Sub(c.since) > s.config.Window {
+				c = &repeatCount{hash: hash, since: now}
+				s.senders[userKey] = c
+			}
+			c.count++
+			if c.count >= s.config.RepeatedMessages {
+				delete(s.senders, userKey)
+				flagUser = true
+			}
+		}
+	}
+
+	if s.config.AddressMessages > 0 {
+		c := s.addresses[addr]
+		if c == nil || now.Sub(c.since) > s.config.Window {
+			c = &burstCount{since: now}
+			s.addresses[addr] = c
+		}
+		c.count++
+		if c.count > s.config.AddressMessages {
+			delete(s.addresses, addr)
+			flagAddr = true
+		}
+	}
+	return flagUser, flagAddr
+}
+
+// require stores a challenge for key unless it solved one within the pass TTL, reporting
+// whether one now stands. A failure to store it is logged and lets the message through, as
+// the heuristics will trip again.
+func (s *ChallengeService) require(ctx context.Context, key, reason string) bool {
+	now := s.clock.Now()
+	until := now.Add(s.config.ChallengeTTL)
+	_, err := s.db.Collection(ctx, challengesCollection).UpdateOne(ctx,
+		bson.M{"_id": key, "passedUntil": bson.M{"$not": bson.M{"$gt": now}}},
+		bson.M{"$set": bson.M{"reason": reason, "requiredAt": now, "requiredUntil": until}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The filter missed an existing document: key passed a challenge recently
+		return false
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to require challenge", "key", key, logging.Err(err))
+		return false
+	}
+	s.logger.WarnContext(ctx, "Challenge required", "key", key, "reason", reason)
+	return true
+}
+
+// verify asks the provider whether token is a solved CAPTCHA for this site
+func (s *ChallengeService) verify(ctx context.Context, addr netip.Addr, token string) error {
+	form := url.Values{"secret": {s.config.Secret}, "response": {token}}
+	if addr.IsValid() {
+		form.Set("remoteip", addr.String())
+	}
+	if s.config.SiteKey != "" {
+		form.Set("sitekey", s.config.SiteKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		s.logger.ErrorContext(ctx, "CAPTCHA verification failed", "provider", s.config.Provider, logging.Err(err))
+		return upstreamError("could not verify the CAPTCHA")
+	}
+	defer resp.Body.Close()
+
+	var result captchaVerification
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s returned %d", s.config.Provider, resp.StatusCode)
+	} else {
+		err = json.NewDecoder(io.LimitReader(resp.Body, captchaResponseMaxBytes)).Decode(&result)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "CAPTCHA verification failed", "provider", s.config.Provider, logging.Err(err))
+		return upstreamError("could not verify the CAPTCHA")
+	}
+	if !result.Success {
+		s.logger.InfoContext(ctx, "CAPTCHA token refused", "provider", s.config.Provider, "errors", result.ErrorCodes)
+		return forbiddenError("the CAPTCHA was not solved; try again")
+	}
+	return nil
+}
+
+func challengeUserKey(ctx context.Context, userID string) string {
+	return "user:" + tenant.FromContext(ctx) + ":" + userID
+}
+
+func challengeAddrKey(addr netip.Addr) string {
+	return "ip:" + addr.String()
+}
+
+// challengeError refuses a message until the sender solves a CAPTCHA
+func challengeError() error {
+	return &Error{Kind: ErrChallenge, Message: "solve a CAPTCHA to keep sending messages"}
+}
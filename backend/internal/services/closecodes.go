@@ -0,0 +1,33 @@
+package services
+
+import "nhooyr.io/websocket"
+
+// CloseCode is an application-defined WebSocket close status (4000-4999) with a
+// machine-readable reason, so clients can decide whether to retry, re-auth, or give up.
+// The table in DESIGN.md ("WebSocket close codes") must be kept in sync.
+type CloseCode struct {
+	Status websocket.StatusCode
+	Reason string
+}
+
+var (
+	// CloseAuthFailed: the token is missing, invalid or expired. Re-authenticate before reconnecting.
+	CloseAuthFailed = CloseCode{Status: 4001, Reason: "AUTH_FAILED"}
+	// CloseSuperseded: a newer connection replaced this one. Do not reconnect automatically.
+	CloseSuperseded = CloseCode{Status: 4002, Reason: "SUPERSEDED"}
+	// CloseRateLimited: the client exceeded its frame budget. Reconnect with backoff.
+	CloseRateLimited = CloseCode{Status: 4003, Reason: "RATE_LIMITED"}
+	// CloseServerDrain: the node is shutting down. Reconnect immediately; another node will serve.
+	CloseServerDrain = CloseCode{Status: 4004, Reason: "SERVER_DRAIN"}
+	// CloseProtocolError: the client sent a malformed frame. Surface an error; do not retry blindly.
+	CloseProtocolError = CloseCode{Status: 4005, Reason: "PROTOCOL_ERROR"}
+	// CloseSessionRevoked: the user ended this connection from another session. Do not reconnect automatically.
+	CloseSessionRevoked = CloseCode{Status: 4006, Reason: "SESSION_REVOKED"}
+	// CloseSlowConsumer: the client fell too far behind on messages. Reconnect and resume.
+	CloseSlowConsumer = CloseCode{Status: 4007, Reason: "SLOW_CONSUMER"}
+)
+
+// closeWith closes the client's socket with an application close code
+func (c *Client) closeWith(code CloseCode) {
+	c.Conn.Close(code.Status, code.Reason)
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nhooyr.io/websocket"
+)
+
+// WebSocket compression modes, selected with WS_COMPRESSION. Compression is permessage-deflate
+// and only applies to clients that offer it.
+const (
+	CompressionOff               = "off"
+	CompressionNoContextTakeover = "no-context-takeover" // each frame compressed alone; no per-connection window
+	CompressionContextTakeover   = "context-takeover"    // keeps a 32 KB window per connection for better ratios
+)
+
+func compressionMode(name string) websocket.CompressionMode {
+	switch name {
+	case CompressionNoContextTakeover:
+		return websocket.CompressionNoContextTakeover
+	case CompressionContextTakeover:
+		return websocket.CompressionContextTakeover
+	default:
+		return websocket.CompressionDisabled
+	}
+}
+
+// negotiatedDeflate reports whether the upgrade response agreed to permessage-deflate
+func negotiatedDeflate(w http.ResponseWriter) bool {
+	return strings.Contains(w.Header().Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
+
+// frameSizeBuckets are the upper bounds, in bytes, of the frame size histogram
+var frameSizeBuckets = [...]int{128, 512, 1024, 4096, 16384}
+
+// frameStats counts frames written to clients by encoded size, before compression
+type frameStats struct {
+	mu         sync.Mutex
+	frames     int64
+	bytes      int64
+	maxBytes   int
+	compressed int64                            // frames over the threshold on connections that negotiated compression
+	buckets    [len(frameSizeBuckets) + 1]int64 // one per bound, then one for larger frames
+}
+
+func (s *frameStats) record(size int, compressed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frames++
+	s.bytes += int64(size)
+	if size > s.maxBytes {
+		s.maxBytes = size
+	}
+	if compressed {
+		s.compressed++
+	}
+
+	i := 0
+	for i < len(frameSizeBuckets) && size > frameSizeBuckets[i] {
+		i++
+	}
+	s.buckets[i]++
+}
+
+// FrameSizeStats summarizes outbound frame sizes for the debug endpoint
+type FrameSizeStats struct {
+	Frames     int64 `json:"frames"`
+	Bytes      int64 `json:"bytes"`
+	MaxBytes   int   `json:"maxBytes"`
+	Compressed int64 `json:"compressed"`
+
+	// Frame counts keyed by size bound ("le_512") with "gt_16384" for the rest
+	Histogram map[string]int64 `json:"histogram"`
+}
+
+func (s *frameStats) snapshot() FrameSizeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := FrameSizeStats{
+		Frames:     s.frames,
+		Bytes:      s.bytes,
+		MaxBytes:   s.maxBytes,
+		Compressed: s.compressed,
+		Histogram:  make(map[string]int64, len(frameSizeBuckets)+1),
+	}
+	for i, bound := range frameSizeBuckets {
+		stats.Histogram["le_"+strconv.Itoa(bound)] = s.buckets[i]
+	}
+	stats.Histogram["gt_"+strconv.Itoa(frameSizeBuckets[len(frameSizeBuckets)-1])] = s.buckets[len(frameSizeBuckets)]
+	return stats
+}
@@ -2,154 +2,289 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"time"
+	"log/slog"
+	"regexp"
+	"strings"
 
 	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const maxConversationSearchLength = 100
+
 type ConversationService struct {
 	db          *database.MongoDB
 	userService *UserService
+	listCache   *ConversationListCache
+	natsConn    *nats.NATSConnection
+	clock       clock.Clock
+	logger      *slog.Logger
+	ids         IDGenerator
 }
 
-func NewConversationService(db *database.MongoDB, userService *UserService) *ConversationService {
+func NewConversationService(db *database.MongoDB, userService *UserService, listCache *ConversationListCache, natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *ConversationService {
 	return &ConversationService{
 		db:          db,
 		userService: userService,
+		listCache:   listCache,
+		natsConn:    natsConn,
+		clock:       clk,
+		logger:      logger,
+		ids:         ids,
 	}
 }
 
 func (s *ConversationService) CreateConversation(ctx context.Context, req *models.CreateConversationRequest, creatorID string) (*models.Conversation, error) {
-	conversationsCollection := s.db.DB.Collection("conversations")
-	participantsCollection := s.db.DB.Collection("participants")
+	conversationsCollection := s.db.Collection(ctx, "conversations")
+	participantsCollection := s.db.Collection(ctx, "participants")
+
+	// The conversation belongs to its creator's workspace, and so must everyone in it
+	creator, err := s.userService.GetUserByID(ctx, creatorID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.userService.resolveMembers(ctx, creator.WorkspaceID, req.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.PostingPolicy != "" && req.PostingPolicy != models.PolicyEveryone && req.Kind != "group" {
+		return nil, validationError("only group conversations can restrict who posts")
+	}
+
+	now := s.clock.Now()
 
 	// Create conversation
 	conversation := &models.Conversation{
-		ID:            generateUUID(),
+		ID:            s.ids.NewID(),
+		WorkspaceID:   creator.WorkspaceID,
 		Kind:          req.Kind,
 		Title:         req.Title,
-		CreatedAt:     time.Now(),
-		LastMessageAt: time.Now(),
+		CreatedAt:     now,
+		LastMessageAt: now,
 	}
-
-	_, err := conversationsCollection.InsertOne(ctx, conversation)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	if req.PostingPolicy != models.PolicyEveryone {
+		conversation.PostingPolicy = req.PostingPolicy
 	}
 
 	// Add creator as admin participant
-	creatorParticipant := &models.Participant{
-		ID:             fmt.Sprintf("%s:%s", conversation.ID, creatorID),
+	participants := []interface{}{&models.Participant{
+		ID:             id.Participant(conversation.ID, creatorID),
 		ConversationID: conversation.ID,
 		UserID:         creatorID,
-		Role:           "admin",
-		JoinedAt:       time.Now(),
-	}
-
-	_, err = participantsCollection.InsertOne(ctx, creatorParticipant)
-	if err != nil {
-		return nil, fmt.Errorf("failed to add creator as participant: %w", err)
-	}
+		Role:           models.ConversationRoleAdmin,
+		JoinedAt:       now,
+	}}
 
 	// Add other members
-	for _, memberID := range req.Members {
-		if memberID == creatorID {
-			continue // Skip creator
+	memberIDs := []string{creatorID}
+	seen := map[string]bool{creatorID: true}
+	for _, memberID := range members {
+		if seen[memberID] {
+			continue // Skip creator and repeated members
 		}
+		seen[memberID] = true
+		memberIDs = append(memberIDs, memberID)
 
-		participant := &models.Participant{
-			ID:             fmt.Sprintf("%s:%s", conversation.ID, memberID),
+		participants = append(participants, &models.Participant{
+			ID:             id.Participant(conversation.ID, memberID),
 			ConversationID: conversation.ID,
 			UserID:         memberID,
-			Role:           "member",
-			JoinedAt:       time.Now(),
+			Role:           models.ConversationRoleMember,
+			JoinedAt:       now,
+		})
+	}
+	if req.Kind == "group" {
+		if err := checkGroupSize(ctx, s.db, creator.WorkspaceID, len(memberIDs)); err != nil {
+			return nil, err
 		}
+	}
 
-		_, err = participantsCollection.InsertOne(ctx, participant)
-		if err != nil {
-			return nil, fmt.Errorf("failed to add participant %s: %w", memberID, err)
+	// The conversation and its participants are written together, so a failure part-way
+	// can no longer leave a conversation without members or members without a conversation
+	err = withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		if _, err := conversationsCollection.InsertOne(txCtx, conversation); err != nil {
+			return fmt.Errorf("failed to create conversation: %w", err)
 		}
+		if _, err := participantsCollection.InsertMany(txCtx, participants); err != nil {
+			return fmt.Errorf("failed to add participants: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	conversation.FillPolicyDefaults()
+	s.listCache.InvalidateMembers(conversation.ID, memberIDs)
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.ConversationCreated,
+		ConversationID: conversation.ID,
+		Conversation:   conversation,
+		UserIDs:        memberIDs,
+		ActorID:        creatorID,
+		Recipients:     memberIDs,
+	})
+
 	return conversation, nil
 }
 
-func (s *ConversationService) GetUserConversations(ctx context.Context, userID string) ([]models.ConversationWithParticipants, error) {
-	participantsCollection := s.db.DB.Collection("participants")
-	conversationsCollection := s.db.DB.Collection("conversations")
+// GetUserConversationsSnapshot serves the user's conversation list from cache when fresh,
+// falling back to GetUserConversations on a miss
+func (s *ConversationService) GetUserConversationsSnapshot(ctx context.Context, userID string) (*models.ConversationListSnapshot, error) {
+	if conversations, generatedAt, ok := s.listCache.Get(userID); ok {
+		return &models.ConversationListSnapshot{
+			Conversations: conversations,
+			GeneratedAt:   generatedAt,
+			Cached:        true,
+		}, nil
+	}
 
-	// Find all conversations where user is a participant
-	cursor, err := participantsCollection.Find(ctx, bson.M{"userId": userID})
+	generation := s.listCache.Generation()
+	generatedAt := s.clock.Now()
+	conversations, err := s.GetUserConversations(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find user participations: %w", err)
+		return nil, err
 	}
-	defer cursor.Close(ctx)
+	s.listCache.Put(userID, generation, conversations, generatedAt)
 
-	var participants []models.Participant
-	if err = cursor.All(ctx, &participants); err != nil {
-		return nil, fmt.Errorf("failed to decode participants: %w", err)
-	}
+	return &models.ConversationListSnapshot{
+		Conversations: conversations,
+		GeneratedAt:   generatedAt,
+	}, nil
+}
 
-	if len(participants) == 0 {
-		return []models.ConversationWithParticipants{}, nil
-	}
+// conversationListRow is one conversation as assembled by GetUserConversations' pipeline
+type conversationListRow struct {
+	models.Conversation `bson:",inline"`
+	Members             []models.Participant `bson:"members"`
+	Users               []models.User        `bson:"users"`
+}
 
-	// Extract conversation IDs
-	conversationIDs := make([]string, len(participants))
-	for i, p := range participants {
-		conversationIDs[i] = p.ConversationID
+// GetUserConversations returns the user's conversations, most recently active first, with their
+// participants' profiles. One aggregation joins participants → conversations → participants →
+// users, so the cost no longer grows with one query per conversation and member.
+func (s *ConversationService) GetUserConversations(ctx context.Context, userID string) ([]models.ConversationWithParticipants, error) {
+	return s.listConversations(ctx, userID, s.conversationListPipeline(ctx, userID))
+}
+
+// SearchConversations finds the user's conversations whose title, or another participant's name
+// or username, contains the query, ignoring case; most recently active first. The search runs
+// over the same join as the conversation list, so it is bounded by the user's own conversations
+// and uses the participants and users indexes rather than scanning every user.
+func (s *ConversationService) SearchConversations(ctx context.Context, userID, query string, limit int) ([]models.ConversationWithParticipants, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, validationError("q is required")
+	}
+	if len([]rune(query)) > maxConversationSearchLength {
+		return nil, validationError(fmt.Sprintf("q is limited to %d characters", maxConversationSearchLength))
 	}
 
-	// Get conversations sorted by lastMessageAt
-	conversationCursor, err := conversationsCollection.Find(
-		ctx,
-		bson.M{"_id": bson.M{"$in": conversationIDs}},
-		options.Find().SetSort(bson.D{{Key: "lastMessageAt", Value: -1}}),
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	pipeline := append(s.conversationListPipeline(ctx, userID),
+		bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"title": pattern},
+			bson.M{"users": bson.M{"$elemMatch": bson.M{
+				"_id": bson.M{"$ne": userID},
+				"$or": bson.A{bson.M{"name": pattern}, bson.M{"username": pattern}},
+			}}},
+		}}}},
+		bson.D{{Key: "$limit", Value: limit}},
 	)
+	return s.listConversations(ctx, userID, pipeline)
+}
+
+// conversationListPipeline joins participants → conversations → participants → users for the
+// user's conversations, most recently active first
+func (s *ConversationService) conversationListPipeline(ctx context.Context, userID string) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.db.Collection(ctx, "conversations").Name(),
+			"localField":   "conversationId",
+			"foreignField": "_id",
+			"as":           "conversation",
+		}}},
+		// Participants whose conversation is gone are skipped, as before
+		{{Key: "$unwind", Value: "$conversation"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$conversation"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "lastMessageAt", Value: -1}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.db.Collection(ctx, "participants").Name(),
+			"localField":   "_id",
+			"foreignField": "conversationId",
+			"as":           "members",
+			"pipeline":     bson.A{bson.M{"$project": bson.M{"userId": 1, "role": 1}}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.db.Collection(ctx, "users").Name(),
+			"localField":   "members.userId",
+			"foreignField": "_id",
+			"as":           "users",
+		}}},
+	}
+}
+
+func (s *ConversationService) listConversations(ctx context.Context, userID string, pipeline mongo.Pipeline) ([]models.ConversationWithParticipants, error) {
+	cursor, err := s.db.Collection(ctx, "participants").Aggregate(ctx, pipeline)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find conversations: %w", err)
+		return nil, fmt.Errorf("failed to load conversations: %w", err)
 	}
-	defer conversationCursor.Close(ctx)
+	defer cursor.Close(ctx)
 
-	var conversations []models.Conversation
-	if err = conversationCursor.All(ctx, &conversations); err != nil {
+	var rows []conversationListRow
+	if err = cursor.All(ctx, &rows); err != nil {
 		return nil, fmt.Errorf("failed to decode conversations: %w", err)
 	}
 
-	// Convert to ConversationWithParticipants and populate participants
-	result := make([]models.ConversationWithParticipants, len(conversations))
-	for i, conv := range conversations {
-		result[i] = models.ConversationWithParticipants{
-			ID:            conv.ID,
-			Kind:          conv.Kind,
-			Title:         conv.Title,
-			CreatedAt:     conv.CreatedAt,
-			LastMessageAt: conv.LastMessageAt,
+	slowModes := make(map[string]int)
+	result := make([]models.ConversationWithParticipants, len(rows))
+	for i, row := range rows {
+		row.FillPolicyDefaults()
+		if _, ok := slowModes[row.WorkspaceID]; !ok {
+			if slowModes[row.WorkspaceID], err = s.workspaceSlowMode(ctx, row.WorkspaceID); err != nil {
+				return nil, err
+			}
 		}
-
-		// Get all participants for this conversation
-		participantCursor, err := participantsCollection.Find(ctx, bson.M{"conversationId": conv.ID})
-		if err != nil {
-			return nil, fmt.Errorf("failed to find conversation participants: %w", err)
+		result[i] = models.ConversationWithParticipants{
+			ID:              row.ID,
+			Kind:            row.Kind,
+			Title:           row.Title,
+			CreatedAt:       row.CreatedAt,
+			LastMessageAt:   row.LastMessageAt,
+			PostingPolicy:   row.PostingPolicy,
+			InvitePolicy:    row.InvitePolicy,
+			PinPolicy:       row.PinPolicy,
+			MetadataPolicy:  row.MetadataPolicy,
+			Lock:            row.Lock,
+			SlowModeSeconds: slowModeSeconds(&row.Conversation, slowModes[row.WorkspaceID]),
 		}
 
-		var convParticipants []models.Participant
-		if err = participantCursor.All(ctx, &convParticipants); err != nil {
-			participantCursor.Close(ctx)
-			return nil, fmt.Errorf("failed to decode conversation participants: %w", err)
+		// $lookup does not keep the members' order; restore it. Members without a user
+		// document are left out.
+		users := make(map[string]models.User, len(row.Users))
+		for _, user := range row.Users {
+			users[user.ID] = user
 		}
-		participantCursor.Close(ctx)
-
-		// Populate user info for each participant
-		participantUsers := make([]models.User, 0, len(convParticipants))
-		for _, p := range convParticipants {
-			if user, err := s.userService.GetUserByID(ctx, p.UserID); err == nil {
-				participantUsers = append(participantUsers, *user)
+		participantUsers := make([]models.User, 0, len(row.Members))
+		for _, member := range row.Members {
+			if member.UserID == userID {
+				result[i].Role = member.Role
+			}
+			if user, ok := users[member.UserID]; ok {
+				participantUsers = append(participantUsers, *user.Public())
 			}
 		}
 		result[i].Participants = participantUsers
@@ -159,24 +294,25 @@ func (s *ConversationService) GetUserConversations(ctx context.Context, userID s
 }
 
 func (s *ConversationService) GetConversationByID(ctx context.Context, conversationID string) (*models.Conversation, error) {
-	collection := s.db.DB.Collection("conversations")
+	collection := s.db.Collection(ctx, "conversations")
 
 	var conversation models.Conversation
 	err := collection.FindOne(ctx, bson.M{"_id": conversationID}).Decode(&conversation)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("conversation not found")
+			return nil, notFoundError("conversation not found")
 		}
 		return nil, fmt.Errorf("failed to get conversation: %w", err)
 	}
 
+	conversation.FillPolicyDefaults()
 	return &conversation, nil
 }
 
 func (s *ConversationService) IsUserParticipant(ctx context.Context, conversationID, userID string) (bool, error) {
-	collection := s.db.DB.Collection("participants")
+	collection := s.db.Collection(ctx, "participants")
 
-	participantID := fmt.Sprintf("%s:%s", conversationID, userID)
+	participantID := id.Participant(conversationID, userID)
 	count, err := collection.CountDocuments(ctx, bson.M{"_id": participantID})
 	if err != nil {
 		return false, fmt.Errorf("failed to check participation: %w", err)
@@ -185,13 +321,40 @@ func (s *ConversationService) IsUserParticipant(ctx context.Context, conversatio
 	return count > 0, nil
 }
 
+func (s *ConversationService) GetParticipant(ctx context.Context, conversationID, userID string) (*models.Participant, error) {
+	collection := s.db.Collection(ctx, "participants")
+
+	participantID := id.Participant(conversationID, userID)
+	var participant models.Participant
+	err := collection.FindOne(ctx, bson.M{"_id": participantID}).Decode(&participant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, forbiddenError("user is not a participant in this conversation")
+		}
+		return nil, fmt.Errorf("failed to find participant: %w", err)
+	}
+
+	return &participant, nil
+}
+
+func (s *ConversationService) CountParticipants(ctx context.Context, conversationID string) (int64, error) {
+	collection := s.db.Collection(ctx, "participants")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count participants: %w", err)
+	}
+
+	return count, nil
+}
+
 func (s *ConversationService) UpdateLastMessageAt(ctx context.Context, conversationID string) error {
-	collection := s.db.DB.Collection("conversations")
+	collection := s.db.Collection(ctx, "conversations")
 
 	_, err := collection.UpdateOne(
 		ctx,
 		bson.M{"_id": conversationID},
-		bson.D{{Key: "$set", Value: bson.D{{Key: "lastMessageAt", Value: time.Now()}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lastMessageAt", Value: s.clock.Now()}}}},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update lastMessageAt: %w", err)
@@ -207,12 +370,12 @@ func (s *ConversationService) DeleteConversation(ctx context.Context, conversati
 		return fmt.Errorf("failed to check participation: %w", err)
 	}
 	if !isParticipant {
-		return fmt.Errorf("user is not a participant in this conversation")
+		return forbiddenError("user is not a participant in this conversation")
 	}
 
 	// Check if user is admin (only admins can delete conversations)
-	participantsCollection := s.db.DB.Collection("participants")
-	participantID := fmt.Sprintf("%s:%s", conversationID, userID)
+	participantsCollection := s.db.Collection(ctx, "participants")
+	participantID := id.Participant(conversationID, userID)
 
 	var participant models.Participant
 	err = participantsCollection.FindOne(ctx, bson.M{"_id": participantID}).Decode(&participant)
@@ -220,38 +383,388 @@ func (s *ConversationService) DeleteConversation(ctx context.Context, conversati
 		return fmt.Errorf("failed to find participant: %w", err)
 	}
 
-	if participant.Role != "admin" {
-		return fmt.Errorf("only admins can delete conversations")
+	if !permissions.CheckPermission(&participant, nil, permissions.DeleteConversation) {
+		return forbiddenError("only admins can delete conversations")
 	}
 
 	// Delete all messages in the conversation
-	messagesCollection := s.db.DB.Collection("messages")
+	messagesCollection := s.db.Collection(ctx, "messages")
 	_, err = messagesCollection.DeleteMany(ctx, bson.M{"conversationId": conversationID})
 	if err != nil {
 		return fmt.Errorf("failed to delete messages: %w", err)
 	}
+	_, err = s.db.Collection(ctx, messageRevisionsCollection).DeleteMany(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return fmt.Errorf("failed to delete message revisions: %w", err)
+	}
+	_, err = s.db.Collection(ctx, pollVotesCollection).DeleteMany(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return fmt.Errorf("failed to delete poll votes: %w", err)
+	}
+	_, err = s.db.Collection(ctx, callsCollection).DeleteMany(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return fmt.Errorf("failed to delete calls: %w", err)
+	}
+	_, err = s.db.Collection(ctx, attachmentsCollection).DeleteMany(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	_, err = s.db.Collection(ctx, attachmentReviewsCollection).DeleteMany(ctx, bson.M{"conversationId": conversationID})
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment reviews: %w", err)
+	}
+
+	// Remember who was in the conversation so their cached lists can be dropped
+	memberIDs, err := s.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return err
+	}
 
 	// Delete all participants
 	_, err = participantsCollection.DeleteMany(ctx, bson.M{"conversationId": conversationID})
 	if err != nil {
 		return fmt.Errorf("failed to delete participants: %w", err)
 	}
+	s.listCache.InvalidateMembers(conversationID, memberIDs)
 
 	// Delete the conversation itself
-	conversationsCollection := s.db.DB.Collection("conversations")
+	conversationsCollection := s.db.Collection(ctx, "conversations")
 	result, err := conversationsCollection.DeleteOne(ctx, bson.M{"_id": conversationID})
 	if err != nil {
 		return fmt.Errorf("failed to delete conversation: %w", err)
 	}
 
 	if result.DeletedCount == 0 {
-		return fmt.Errorf("conversation not found")
+		return notFoundError("conversation not found")
+	}
+
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.ConversationDeleted,
+		ConversationID: conversationID,
+		ActorID:        userID,
+		Recipients:     memberIDs,
+	})
+
+	return nil
+}
+
+// AddMembers adds users to a group conversation on behalf of a participant its invite policy
+// allows, and returns the IDs of those who were not already in it
+func (s *ConversationService) AddMembers(ctx context.Context, conversationID, actorID string, req *models.AddMembersRequest) ([]string, error) {
+	conversation, participant, err := s.groupParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPolicy(participant, conversation.InvitePolicy) {
+		return nil, forbiddenError("the conversation's invite policy does not let you add members")
+	}
+	members, err := s.userService.resolveMembers(ctx, conversation.WorkspaceID, req.Members)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(existing))
+	for _, userID := range existing {
+		seen[userID] = true
+	}
+	now := s.clock.Now()
+	added := []string{}
+	var participants []interface{}
+	for _, memberID := range members {
+		if seen[memberID] {
+			continue
+		}
+		seen[memberID] = true
+		added = append(added, memberID)
+		participants = append(participants, &models.Participant{
+			ID:             id.Participant(conversationID, memberID),
+			ConversationID: conversationID,
+			UserID:         memberID,
+			Role:           models.ConversationRoleMember,
+			JoinedAt:       now,
+		})
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+	if err := checkGroupSize(ctx, s.db, conversation.WorkspaceID, len(existing)+len(added)); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Collection(ctx, "participants").InsertMany(ctx, participants); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, conflictError("a member was added at the same time; try again")
+		}
+		return nil, fmt.Errorf("failed to add participants: %w", err)
+	}
+
+	s.listCache.InvalidateMembers(conversationID, added)
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.MemberAdded,
+		ConversationID: conversationID,
+		Conversation:   conversation,
+		UserIDs:        added,
+		ActorID:        actorID,
+		Recipients:     append(existing, added...),
+	})
+
+	return added, nil
+}
+
+// JoinDefaults adds a user who has just joined their workspace to its default conversations.
+// Conversations deleted, or no longer groups in the workspace, since they were chosen are
+// skipped, as are those already as large as the workspace's plan allows.
+func (s *ConversationService) JoinDefaults(ctx context.Context, user *models.User, conversationIDs []string) error {
+	for _, conversationID := range conversationIDs {
+		conversation, err := s.GetConversationByID(ctx, conversationID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if conversation.Kind != "group" || conversation.WorkspaceID != user.WorkspaceID {
+			continue
+		}
+
+		existing, err := s.participantUserIDs(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+		err = checkGroupSize(ctx, s.db, user.WorkspaceID, len(existing)+1)
+		if errors.Is(err, ErrQuota) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = s.db.Collection(ctx, "participants").InsertOne(ctx, &models.Participant{
+			ID:             id.Participant(conversationID, user.ID),
+			ConversationID: conversationID,
+			UserID:         user.ID,
+			Role:           models.ConversationRoleMember,
+			JoinedAt:       s.clock.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add participant: %w", err)
+		}
+
+		s.listCache.InvalidateMembers(conversationID, []string{user.ID})
+		s.announce(ctx, &models.WSConversationEventData{
+			Event:          models.MemberAdded,
+			ConversationID: conversationID,
+			Conversation:   conversation,
+			UserIDs:        []string{user.ID},
+			ActorID:        user.ID,
+			Recipients:     append(existing, user.ID),
+		})
+	}
+	return nil
+}
+
+// RemoveMember takes a user out of a group conversation. Members may remove themselves; only
+// admins may remove others. The last admin cannot leave while others remain, nor the last
+// member at all: deleting the conversation is how it ends.
+func (s *ConversationService) RemoveMember(ctx context.Context, conversationID, actorID, userID string) error {
+	if userID != actorID {
+		if _, err := s.requireGroupPermission(ctx, conversationID, actorID, permissions.ManageMembers, "only admins can remove other members"); err != nil {
+			return err
+		}
+	} else {
+		conversation, err := s.GetConversationByID(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+		if conversation.Kind != "group" {
+			return validationError("members can only leave group conversations")
+		}
+	}
+
+	participant, err := s.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return notFoundError("user is not a member of this conversation")
+		}
+		return err
+	}
+	remaining, err := s.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	if len(remaining) == 1 {
+		return conflictError("the last member cannot leave; delete the conversation instead")
+	}
+	if participant.Role == models.ConversationRoleAdmin {
+		admins, err := s.db.Collection(ctx, "participants").CountDocuments(ctx, bson.M{"conversationId": conversationID, "role": models.ConversationRoleAdmin})
+		if err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+		if admins == 1 {
+			return conflictError("the last admin cannot leave while others remain")
+		}
+	}
+
+	result, err := s.db.Collection(ctx, "participants").DeleteOne(ctx, bson.M{"_id": participant.ID})
+	if err != nil {
+		return fmt.Errorf("failed to remove participant: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return notFoundError("user is not a member of this conversation")
 	}
 
+	s.listCache.InvalidateMembers(conversationID, []string{userID})
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.MemberRemoved,
+		ConversationID: conversationID,
+		UserIDs:        []string{userID},
+		ActorID:        actorID,
+		Recipients:     remaining, // still includes the removed user
+	})
+
 	return nil
 }
 
-// generateUUID is a placeholder - in production use a proper UUID library
-func generateUUID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
-}
\ No newline at end of file
+// SetPostingPolicy changes who may send messages in a group conversation on an admin's behalf.
+// Members are told with conversation.updated, so their clients show or hide the composer.
+func (s *ConversationService) SetPostingPolicy(ctx context.Context, conversationID, actorID string, req *models.PostingPolicyRequest) (*models.Conversation, error) {
+	return s.SetPolicies(ctx, conversationID, actorID, &models.ConversationPoliciesRequest{PostingPolicy: req.PostingPolicy})
+}
+
+// requireGroupPermission returns a group conversation if the actor's role in it grants perm
+func (s *ConversationService) requireGroupPermission(ctx context.Context, conversationID, actorID string, perm permissions.Permission, denied string) (*models.Conversation, error) {
+	conversation, participant, err := s.groupParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPermission(participant, nil, perm) {
+		return nil, forbiddenError(denied)
+	}
+	return conversation, nil
+}
+
+// groupParticipant returns a group conversation and the actor's participation in it
+func (s *ConversationService) groupParticipant(ctx context.Context, conversationID, actorID string) (*models.Conversation, *models.Participant, error) {
+	participant, err := s.GetParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation, err := s.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if conversation.Kind != "group" {
+		return nil, nil, validationError("only group conversations change members")
+	}
+	return conversation, participant, nil
+}
+
+// announce tells the event's recipients, on every node, that their conversation list changed.
+// It is best effort: a client that misses one finds the change on its next list fetch.
+func (s *ConversationService) announce(ctx context.Context, event *models.WSConversationEventData) {
+	if err := s.natsConn.PublishConversationEvent(event); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish conversation event", logging.ConversationID, event.ConversationID, logging.Err(err))
+	}
+}
+
+func (s *ConversationService) participantUserIDs(ctx context.Context, conversationID string) ([]string, error) {
+	collection := s.db.Collection(ctx, "participants")
+
+	cursor, err := collection.Find(ctx, bson.M{"conversationId": conversationID}, options.Find().SetProjection(bson.M{"userId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find participants: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var participants []models.Participant
+	if err = cursor.All(ctx, &participants); err != nil {
+		return nil, fmt.Errorf("failed to decode participants: %w", err)
+	}
+
+	userIDs := make([]string, len(participants))
+	for i, p := range participants {
+		userIDs[i] = p.UserID
+	}
+	return userIDs, nil
+}
+
+// GetUserConversationIDs returns the IDs of every conversation the user participates in
+func (s *ConversationService) GetUserConversationIDs(ctx context.Context, userID string) ([]string, error) {
+	collection := s.db.Collection(ctx, "participants")
+
+	cursor, err := collection.Find(ctx, bson.M{"userId": userID}, options.Find().SetProjection(bson.M{"conversationId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find participants: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var participants []models.Participant
+	if err = cursor.All(ctx, &participants); err != nil {
+		return nil, fmt.Errorf("failed to decode participants: %w", err)
+	}
+
+	conversationIDs := make([]string, len(participants))
+	for i, p := range participants {
+		conversationIDs[i] = p.ConversationID
+	}
+	return conversationIDs, nil
+}
+
+// GetMembersPage returns up to limit participants ordered by user ID, starting after cursor
+// (a user ID; empty for the first page), with the cursor for the next page
+func (s *ConversationService) GetMembersPage(ctx context.Context, conversationID, cursor string, limit int) ([]models.ConversationMember, string, error) {
+	collection := s.db.Collection(ctx, "participants")
+
+	filter := bson.M{"conversationId": conversationID}
+	if cursor != "" {
+		filter["userId"] = bson.M{"$gt": cursor}
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"userId": 1}).
+		SetLimit(int64(limit + 1)) // Fetch one extra to check if there are more
+
+	results, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find participants: %w", err)
+	}
+	defer results.Close(ctx)
+
+	var participants []models.Participant
+	if err = results.All(ctx, &participants); err != nil {
+		return nil, "", fmt.Errorf("failed to decode participants: %w", err)
+	}
+
+	var nextCursor string
+	if len(participants) > limit {
+		participants = participants[:limit]
+		nextCursor = participants[limit-1].UserID
+	}
+
+	userIDs := make([]string, len(participants))
+	for i, p := range participants {
+		userIDs[i] = p.UserID
+	}
+	users, err := s.userService.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	members := make([]models.ConversationMember, len(participants))
+	for i, p := range participants {
+		members[i] = models.ConversationMember{
+			UserID:   p.UserID,
+			Role:     p.Role,
+			JoinedAt: p.JoinedAt,
+			User:     users[p.UserID],
+		}
+	}
+
+	return members, nextCursor, nil
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// ConversationListCache keeps each user's assembled conversation list in memory.
+// Entries are dropped when a message, membership or status event for one of their conversations
+// arrives over NATS, so every node invalidates consistently; the TTL is a safety net
+// for missed events. Expired entries are swept by RunExpiry, and past maxEntries the oldest
+// is evicted, so users who never return cost nothing for long. A zero TTL disables caching.
+//
+// A list is assembled outside the lock, so an event can land while it is being built. Every
+// invalidation, of a user or of a conversation, is stamped with the next value of a counter;
+// callers take the counter with Generation before querying, and Put stores nothing if the user
+// or any conversation in the list has been stamped since.
+type ConversationListCache struct {
+	natsConn   *nats.NATSConnection
+	clock      clock.Clock
+	logger     *slog.Logger
+	ttl        time.Duration
+	maxEntries int
+
+	mu             sync.Mutex
+	entries        map[string]*list.Element
+	order          *list.List                 // front is the most recently stored
+	byConversation map[string]map[string]bool // conversationID -> users with a cached list containing it
+	userStamps     map[string]generationStamp // userID -> last invalidation
+	convStamps     map[string]generationStamp // conversationID -> last invalidation
+	generation     uint64
+	subs           []*natsgo.Subscription
+}
+
+type conversationListEntry struct {
+	userID        string
+	conversations []models.ConversationWithParticipants
+	generatedAt   time.Time
+}
+
+// generationStamp is the generation of a user's or conversation's last invalidation, and
+// when. A list built for longer than the TTL is not expected, so stamps older than that are
+// swept; a user or conversation without one has not been invalidated lately.
+type generationStamp struct {
+	generation    uint64
+	invalidatedAt time.Time
+}
+
+func NewConversationListCache(natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger, ttl time.Duration, maxEntries int) *ConversationListCache {
+	return &ConversationListCache{
+		natsConn:       natsConn,
+		clock:          clk,
+		logger:         logger,
+		ttl:            ttl,
+		maxEntries:     maxEntries,
+		entries:        make(map[string]*list.Element),
+		order:          list.New(),
+		byConversation: make(map[string]map[string]bool),
+		userStamps:     make(map[string]generationStamp),
+		convStamps:     make(map[string]generationStamp),
+	}
+}
+
+// Start subscribes to the events that invalidate cached lists
+func (c *ConversationListCache) Start() error {
+	if c.ttl <= 0 {
+		return nil
+	}
+
+	msgSub, err := c.natsConn.Conn.Subscribe("chat.conv.*.msg", func(msg *natsgo.Msg) {
+		c.invalidateConversation(conversationIDFromSubject(msg.Subject))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to message events: %w", err)
+	}
+
+	membersSub, err := c.natsConn.Conn.Subscribe("chat.conv.*.members", func(msg *natsgo.Msg) {
+		var event models.MembershipEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			c.logger.Error("Failed to unmarshal membership event", logging.Err(err))
+			return
+		}
+		c.invalidateUsers(event.UserIDs)
+	})
+	if err != nil {
+		msgSub.Unsubscribe()
+		return fmt.Errorf("failed to subscribe to membership events: %w", err)
+	}
+
+	// Participants' statuses are part of the lists
+	statusSub, err := c.natsConn.Conn.Subscribe("chat.conv.*.presence", func(msg *natsgo.Msg) {
+		c.invalidateConversation(conversationIDFromSubject(msg.Subject))
+	})
+	if err != nil {
+		msgSub.Unsubscribe()
+		membersSub.Unsubscribe()
+		return fmt.Errorf("failed to subscribe to status events: %w", err)
+	}
+
+	c.subs = []*natsgo.Subscription{msgSub, membersSub, statusSub}
+	return nil
+}
+
+func (c *ConversationListCache) Stop() {
+	for _, sub := range c.subs {
+		sub.Unsubscribe()
+	}
+}
+
+// RunExpiry drops expired entries, and stamps too old to matter, every TTL until ctx is
+// cancelled
+func (c *ConversationListCache) RunExpiry(ctx context.Context) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.expire()
+		}
+	}
+}
+
+// expire removes entries and stamps older than the TTL
+func (c *ConversationListCache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := c.clock.Now().Add(-c.ttl)
+	for elem := c.order.Back(); elem != nil; elem = c.order.Back() {
+		if elem.Value.(*conversationListEntry).generatedAt.After(cutoff) {
+			break
+		}
+		c.removeLocked(elem.Value.(*conversationListEntry).userID)
+	}
+	for _, stamps := range []map[string]generationStamp{c.userStamps, c.convStamps} {
+		for id, stamp := range stamps {
+			if !stamp.invalidatedAt.After(cutoff) {
+				delete(stamps, id)
+			}
+		}
+	}
+}
+
+// Generation returns the current generation, to pass to Put with the list built after
+func (c *ConversationListCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// stampLocked records an invalidation of id. Callers must hold c.mu.
+func (c *ConversationListCache) stampLocked(stamps map[string]generationStamp, id string, now time.Time) {
+	c.generation++
+	stamps[id] = generationStamp{generation: c.generation, invalidatedAt: now}
+}
+
+// staleLocked reports whether the user or any of the conversations has been invalidated since
+// generation. Callers must hold c.mu.
+func (c *ConversationListCache) staleLocked(userID string, conversations []models.ConversationWithParticipants, generation uint64) bool {
+	if c.userStamps[userID].generation > generation {
+		return true
+	}
+	for _, conv := range conversations {
+		if c.convStamps[conv.ID].generation > generation {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConversationListCache) Get(userID string) ([]models.ConversationWithParticipants, time.Time, bool) {
+	if c.ttl <= 0 {
+		return nil, time.Time{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[userID]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	entry := elem.Value.(*conversationListEntry)
+	if c.clock.Now().Sub(entry.generatedAt) > c.ttl {
+		c.removeLocked(userID)
+		return nil, time.Time{}, false
+	}
+
+	return entry.conversations, entry.generatedAt, true
+}
+
+// Put caches a list built from the state at generation; a list invalidated since is dropped
+func (c *ConversationListCache) Put(userID string, generation uint64, conversations []models.ConversationWithParticipants, generatedAt time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.staleLocked(userID, conversations, generation) {
+		return
+	}
+	c.removeLocked(userID)
+	c.entries[userID] = c.order.PushFront(&conversationListEntry{
+		userID:        userID,
+		conversations: conversations,
+		generatedAt:   generatedAt,
+	})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back().Value.(*conversationListEntry).userID)
+	}
+	for _, conv := range conversations {
+		users, ok := c.byConversation[conv.ID]
+		if !ok {
+			users = make(map[string]bool)
+			c.byConversation[conv.ID] = users
+		}
+		users[userID] = true
+	}
+}
+
+// InvalidateMembers announces a membership change so every node drops the affected users' lists
+func (c *ConversationListCache) InvalidateMembers(conversationID string, userIDs []string) {
+	event := &models.MembershipEvent{
+		ConversationID: conversationID,
+		UserIDs:        userIDs,
+	}
+
+	if err := c.natsConn.PublishMembership(conversationID, event); err != nil {
+		c.logger.Error("Failed to publish membership event", logging.ConversationID, conversationID, logging.Err(err))
+		// At least keep this node consistent
+		c.invalidateUsers(userIDs)
+	}
+}
+
+func (c *ConversationListCache) invalidateUsers(userIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for _, userID := range userIDs {
+		c.removeLocked(userID)
+		c.stampLocked(c.userStamps, userID, now)
+	}
+}
+
+func (c *ConversationListCache) invalidateConversation(conversationID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stampLocked(c.convStamps, conversationID, c.clock.Now())
+	for userID := range c.byConversation[conversationID] {
+		c.removeLocked(userID)
+	}
+}
+
+// removeLocked drops a user's entry and its reverse-index references. Callers must hold c.mu.
+func (c *ConversationListCache) removeLocked(userID string) {
+	elem, ok := c.entries[userID]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*conversationListEntry)
+
+	for _, conv := range entry.conversations {
+		if users, ok := c.byConversation[conv.ID]; ok {
+			delete(users, userID)
+			if len(users) == 0 {
+				delete(c.byConversation, conv.ID)
+			}
+		}
+	}
+	c.order.Remove(elem)
+	delete(c.entries, userID)
+}
+
+// conversationIDFromSubject extracts <id> from chat.conv.<id>.<kind>
+func conversationIDFromSubject(subject string) string {
+	parts := strings.Split(subject, ".")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[2]
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	dispatchConsumerName   = "notifications"
+	dispatchBatchSize      = 20
+	dispatchDeliverTimeout = 10 * time.Second
+	notificationPreview    = 140
+)
+
+// DispatchConfig configures delivery to the push gateway
+type DispatchConfig struct {
+	WebhookURL string // empty disables notifications
+	Secret     string // signs each delivery with HMAC-SHA256 in X-Notification-Signature
+}
+
+// NotificationDispatcher turns new messages into notifications. A durable consumer on the CHAT
+// stream reads each message.created event once the undo window has passed, skips messages
+// retracted meanwhile, and asks NotificationService.Channels for every other participant,
+// passing whether they were mentioned. Recipients with channels are POSTed to the webhook, a
+// push gateway that owns device tokens and providers; the rest get nothing, but their unread
+// counts still grow, since those come from read positions, not notifications.
+type NotificationDispatcher struct {
+	natsConn            *nats.NATSConnection
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	notificationService *NotificationService
+	clock               clock.Clock
+	logger              *slog.Logger
+	config              DispatchConfig
+	httpClient          *http.Client
+}
+
+func NewNotificationDispatcher(natsConn *nats.NATSConnection, db *database.MongoDB, conversationService *ConversationService, notificationService *NotificationService, clk clock.Clock, logger *slog.Logger, config DispatchConfig) *NotificationDispatcher {
+	return &NotificationDispatcher{
+		natsConn:            natsConn,
+		db:                  db,
+		conversationService: conversationService,
+		notificationService: notificationService,
+		clock:               clk,
+		logger:              logger,
+		config:              config,
+		httpClient:          &http.Client{Timeout: dispatchDeliverTimeout},
+	}
+}
+
+// Run dispatches notifications for new messages until ctx is cancelled
+func (d *NotificationDispatcher) Run(ctx context.Context) {
+	if d.config.WebhookURL == "" {
+		d.logger.Info("Notification dispatcher disabled")
+		return
+	}
+
+	consumer, err := d.natsConn.JS.CreateOrUpdateConsumer(ctx, nats.ChatStream, jetstream.ConsumerConfig{
+		Durable:       dispatchConsumerName,
+		Description:   "Push and email notifications",
+		FilterSubject: "chat.conv.*.msg",
+		// A new consumer starts from now rather than notifying the whole history
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+	})
+	if err != nil {
+		d.logger.Error("Failed to create notification consumer", logging.Err(err))
+		return
+	}
+
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(dispatchBatchSize, jetstream.FetchMaxWait(5*time.Second))
+		if err != nil {
+			d.logger.Warn("Failed to fetch messages to notify", logging.Err(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for msg := range batch.Messages() {
+			d.handle(tenant.NewContext(ctx, msg.Headers().Get(tenant.Header)), msg)
+		}
+	}
+}
+
+func (d *NotificationDispatcher) handle(ctx context.Context, msg jetstream.Msg) {
+	if nats.MessageEvent(msg.Headers()) != nats.EventMessageCreated {
+		msg.Ack()
+		return
+	}
+	var message models.WSMessageNewData
+	if err := json.Unmarshal(msg.Data(), &message); err != nil {
+		d.logger.Error("Failed to unmarshal message to notify", logging.Err(err))
+		msg.Term()
+		return
+	}
+
+	wait, retracted, err := awaitRetraction(ctx, d.db, d.clock, &message)
+	switch {
+	case err != nil:
+		d.logger.Error("Failed to check message retraction", logging.MessageID, message.ID, logging.Err(err))
+		msg.Nak()
+		return
+	case wait > 0:
+		msg.NakWithDelay(wait)
+		return
+	case retracted:
+		msg.Ack()
+		return
+	}
+
+	recipients, err := d.conversationService.participantUserIDs(ctx, message.ConversationID)
+	if err != nil {
+		d.logger.Error("Failed to find recipients", logging.ConversationID, message.ConversationID, logging.Err(err))
+		msg.Nak()
+		return
+	}
+
+	for _, userID := range recipients {
+		if userID == message.SenderID {
+			continue
+		}
+		mentioned := slices.Contains(message.Mentions, userID)
+		channels, err := d.notificationService.Channels(ctx, userID, message.ConversationID, mentioned)
+		if err != nil {
+			d.logger.Error("Failed to resolve notification channels", logging.UserID, userID, logging.Err(err))
+			continue
+		}
+		if len(channels) == 0 {
+			continue
+		}
+
+		notification := &models.Notification{
+			UserID:         userID,
+			Channels:       channels,
+			ConversationID: message.ConversationID,
+			MessageID:      message.ID,
+			SenderID:       message.SenderID,
+			Sender:         message.Sender,
+			Preview:        preview(message.Body),
+			Mentioned:      mentioned,
+			CreatedAt:      message.CreatedAt,
+		}
+		// Best effort: retrying the whole message would notify the others twice
+		if err := d.deliver(ctx, notification); err != nil {
+			d.logger.Warn("Notification delivery failed", logging.UserID, userID, logging.MessageID, message.ID, logging.Err(err))
+		}
+	}
+
+	if err := msg.Ack(); err != nil {
+		d.logger.Error("Failed to ack notified message", logging.MessageID, message.ID, logging.Err(err))
+	}
+}
+
+// awaitRetraction holds a new message, for consumers acting on it outside its conversation,
+// until its sender can no longer take it back. It returns how long there is left to wait, or
+// else whether the message was retracted.
+func awaitRetraction(ctx context.Context, db *database.MongoDB, clk clock.Clock, message *models.WSMessageNewData) (time.Duration, bool, error) {
+	until := message.RetractableUntil
+	if until == nil {
+		return 0, false, nil
+	}
+	if wait := until.Sub(clk.Now()); wait > 0 {
+		return wait, false, nil
+	}
+	count, err := db.Collection(ctx, "messages").CountDocuments(ctx,
+		bson.M{"_id": message.ID, "retractedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, false, err
+	}
+	return 0, count == 0, nil
+}
+
+func (d *NotificationDispatcher) deliver(ctx context.Context, notification *models.Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.config.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Notification-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// preview shortens a body for a notification, on a rune boundary
+func preview(body string) string {
+	runes := []rune(body)
+	if len(runes) <= notificationPreview {
+		return body
+	}
+	return string(runes[:notificationPreview-1]) + "…"
+}
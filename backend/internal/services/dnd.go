@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Do-not-disturb: notifications a user would have received inside one of their DND windows
+// are counted per conversation in away_notifications, one document per user. Once no window
+// covers the present, RunAwaySummaries turns the counts into an AwaySummary, stores it for
+// GET /v1/me/away-summary and sends it to the user's connections as an away.summary frame.
+// Every node runs the sweep; taking the counts with FindOneAndDelete lets only one of them
+// deliver each summary.
+
+const (
+	awayNotificationsCollection = "away_notifications"
+	awaySummariesCollection     = "away_summaries"
+	maxDNDWindows               = 28
+)
+
+// dndDays maps the day names DND windows use to weekdays
+var dndDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// awayCounts is the away_notifications document
+type awayCounts struct {
+	UserID        string                             `bson:"_id"`
+	From          time.Time                          `bson:"from"`
+	Conversations map[string]models.AwayConversation `bson:"conversations"`
+}
+
+// validateDND checks a schedule from a profile update and fills in its defaults
+func validateDND(dnd *models.DND) error {
+	if len(dnd.Windows) > maxDNDWindows {
+		return validationError(fmt.Sprintf("dnd.windows allows at most %d windows", maxDNDWindows))
+	}
+	for i, window := range dnd.Windows {
+		field := fmt.Sprintf("dnd.windows[%d]", i)
+		if err := validateWindow(field, window.Start, window.End); err != nil {
+			return err
+		}
+		for j, day := range window.Days {
+			day = strings.ToLower(day)
+			if _, ok := dndDays[day]; !ok {
+				return validationError(field + ".days must name days as mon, tue, wed, thu, fri, sat or sun")
+			}
+			dnd.Windows[i].Days[j] = day
+		}
+	}
+	if dnd.TimeZone == "" {
+		dnd.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(dnd.TimeZone); err != nil {
+		return validationError("dnd.timeZone must be an IANA time zone, e.g. Europe/Berlin")
+	}
+	return nil
+}
+
+// inDND reports whether any of the schedule's windows covers now, read in its time zone
+func inDND(dnd *models.DND, now time.Time) bool {
+	location, err := time.LoadLocation(dnd.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+
+	for _, window := range dnd.Windows {
+		inside, startedYesterday := inWindow(window.Start, window.End, local)
+		if !inside {
+			continue
+		}
+		if len(window.Days) == 0 {
+			return true
+		}
+		day := local.Weekday()
+		if startedYesterday {
+			day = (day + 6) % 7
+		}
+		for _, name := range window.Days {
+			if dndDays[name] == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holdForSummary counts a notification held back by DND
+func (s *NotificationService) holdForSummary(ctx context.Context, userID, conversationID string, mentioned bool) error {
+	counts := bson.M{"conversations." + conversationID + ".count": 1}
+	if mentioned {
+		counts["conversations."+conversationID+".mentions"] = 1
+	}
+	update := bson.M{
+		"$inc":         counts,
+		"$set":         bson.M{"conversations." + conversationID + ".conversationId": conversationID},
+		"$setOnInsert": bson.M{"from": s.clock.Now()},
+	}
+	_, err := s.db.Collection(ctx, awayNotificationsCollection).UpdateOne(ctx,
+		bson.M{"_id": userID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to hold notification: %w", err)
+	}
+	return nil
+}
+
+// GetAwaySummary returns the user's latest away summary
+func (s *NotificationService) GetAwaySummary(ctx context.Context, userID string) (*models.AwaySummary, error) {
+	var summary models.AwaySummary
+	err := s.db.Collection(ctx, awaySummariesCollection).FindOne(ctx, bson.M{"_id": userID}).Decode(&summary)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("no away summary")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load away summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// RunAwaySummaries delivers away summaries to users whose DND has ended until ctx is cancelled
+func (s *NotificationService) RunAwaySummaries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.db.EachTenant(ctx, s.SendAwaySummaries); err != nil {
+				s.logger.Error("Away summary sweep failed", logging.Err(err))
+			}
+		}
+	}
+}
+
+// SendAwaySummaries summarises the held notifications of every user no longer in DND
+func (s *NotificationService) SendAwaySummaries(ctx context.Context) error {
+	held := s.db.Collection(ctx, awayNotificationsCollection)
+	cursor, err := held.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find held notifications: %w", err)
+	}
+	var users []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return fmt.Errorf("failed to decode held notifications: %w", err)
+	}
+
+	now := s.clock.Now()
+	for _, u := range users {
+		user, err := s.userService.GetUserByID(ctx, u.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// A deleted user's counts are dropped below without a summary
+		if user != nil && user.DND != nil && inDND(user.DND, now) {
+			continue
+		}
+
+		var counts awayCounts
+		err = held.FindOneAndDelete(ctx, bson.M{"_id": u.ID}).Decode(&counts)
+		if err == mongo.ErrNoDocuments {
+			continue // another node took it
+		}
+		if err != nil {
+			return fmt.Errorf("failed to take held notifications: %w", err)
+		}
+		if user == nil {
+			continue
+		}
+		if err := s.deliverAwaySummary(ctx, &counts, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NotificationService) deliverAwaySummary(ctx context.Context, counts *awayCounts, now time.Time) error {
+	summary := &models.AwaySummary{
+		UserID:        counts.UserID,
+		From:          counts.From,
+		To:            now,
+		Conversations: make([]models.AwayConversation, 0, len(counts.Conversations)),
+	}
+	for _, conversation := range counts.Conversations {
+		summary.Conversations = append(summary.Conversations, conversation)
+	}
+	// Mentions first, then the busiest conversations
+	sort.Slice(summary.Conversations, func(i, j int) bool {
+		a, b := summary.Conversations[i], summary.Conversations[j]
+		if a.Mentions != b.Mentions {
+			return a.Mentions > b.Mentions
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.ConversationID < b.ConversationID
+	})
+
+	_, err := s.db.Collection(ctx, awaySummariesCollection).ReplaceOne(ctx,
+		bson.M{"_id": summary.UserID}, summary, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store away summary: %w", err)
+	}
+
+	// Connected devices hear of it at once; the rest read it through the API
+	if err := s.nats.PublishAwaySummary(summary); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish away summary", logging.UserID, summary.UserID, logging.Err(err))
+	}
+	return nil
+}
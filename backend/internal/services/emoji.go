@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // registers the decoders DecodeConfig needs
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"regexp"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Custom emoji extend the built-in shortcodes. Workspace admins add emoji everyone in the workspace
+// can use, and conversation admins add emoji for their conversation, which win over a workspace
+// emoji of the same name there. Images are small enough to keep in the registry document itself.
+
+const (
+	customEmojiCollection = "custom_emoji"
+	maxEmojiBytes         = 32 << 10
+	maxEmojiSide          = 128 // pixels
+)
+
+var (
+	emojiNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+	shortcodePattern = regexp.MustCompile(`:([a-z0-9_+-]{1,32}):`)
+)
+
+// emojiContentTypes are the image formats emoji may be uploaded in
+var emojiContentTypes = map[string]bool{"image/png": true, "image/gif": true, "image/jpeg": true}
+
+// EmojiService keeps the custom emoji registry and checks shortcodes against it
+type EmojiService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	userService         *UserService
+	clock               clock.Clock
+	ids                 IDGenerator
+}
+
+func NewEmojiService(db *database.MongoDB, conversationService *ConversationService, userService *UserService, clk clock.Clock, ids IDGenerator) *EmojiService {
+	return &EmojiService{
+		db:                  db,
+		conversationService: conversationService,
+		userService:         userService,
+		clock:               clk,
+		ids:                 ids,
+	}
+}
+
+// ListEmoji returns the workspace's custom emoji, and with a conversation ID that
+// conversation's as well, to any of its participants
+func (s *EmojiService) ListEmoji(ctx context.Context, conversationID, userID string) ([]models.CustomEmoji, error) {
+	var filter bson.M
+	if conversationID != "" {
+		if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+			return nil, err
+		}
+		var err error
+		if filter, err = s.inScope(ctx, conversationID); err != nil {
+			return nil, err
+		}
+	} else {
+		user, err := s.userService.GetUserProfile(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"workspaceId": user.WorkspaceID, "conversationId": bson.M{"$exists": false}}
+	}
+
+	cursor, err := s.db.Collection(ctx, customEmojiCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "conversationId", Value: 1}}).
+			SetProjection(bson.M{"image": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find custom emoji: %w", err)
+	}
+	emoji := []models.CustomEmoji{}
+	if err := cursor.All(ctx, &emoji); err != nil {
+		return nil, fmt.Errorf("failed to decode custom emoji: %w", err)
+	}
+	return emoji, nil
+}
+
+// CreateEmoji adds a custom emoji: to the workspace for workspace admins, or to a conversation
+// for its admins
+func (s *EmojiService) CreateEmoji(ctx context.Context, conversationID, actorID string, req *models.CreateEmojiRequest) (*models.CustomEmoji, error) {
+	workspaceID, err := s.requireAdmin(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !emojiNamePattern.MatchString(req.Name) {
+		return nil, validationError("emoji name must be 2 to 32 lowercase letters, digits, underscores or hyphens, starting with a letter")
+	}
+	if builtinEmoji[req.Name] {
+		return nil, conflictError("a built-in emoji is called :" + req.Name + ":")
+	}
+	contentType, err := checkEmojiImage(req.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	emoji := &models.CustomEmoji{
+		ID:             s.ids.NewID(),
+		Name:           req.Name,
+		WorkspaceID:    workspaceID,
+		ConversationID: conversationID,
+		ContentType:    contentType,
+		Image:          req.Image,
+		CreatedBy:      actorID,
+		CreatedAt:      s.clock.Now(),
+	}
+	// The unique (workspaceId, conversationId, name) index settles concurrent uploads of one name
+	_, err = s.db.Collection(ctx, customEmojiCollection).InsertOne(ctx, emoji)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, conflictError("there is already an emoji called :" + req.Name + ":")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create custom emoji: %w", err)
+	}
+	return emoji, nil
+}
+
+// DeleteEmoji removes a custom emoji by name. Messages that used it keep the ID, and clients
+// show the shortcode as text once the image is gone.
+func (s *EmojiService) DeleteEmoji(ctx context.Context, conversationID, actorID, name string) error {
+	workspaceID, err := s.requireAdmin(ctx, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"name": name, "workspaceId": workspaceID, "conversationId": bson.M{"$exists": false}}
+	if conversationID != "" {
+		filter["conversationId"] = conversationID
+	}
+	result, err := s.db.Collection(ctx, customEmojiCollection).DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete custom emoji: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return notFoundError("emoji not found")
+	}
+	return nil
+}
+
+// GetEmojiImage returns a custom emoji with its image. Workspace emoji are visible to everyone
+// in the workspace, conversation emoji to the conversation's participants.
+func (s *EmojiService) GetEmojiImage(ctx context.Context, id, userID string) (*models.CustomEmoji, error) {
+	var emoji models.CustomEmoji
+	err := s.db.Collection(ctx, customEmojiCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&emoji)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("emoji not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get custom emoji: %w", err)
+	}
+	if emoji.ConversationID != "" {
+		if _, err := s.conversationService.GetParticipant(ctx, emoji.ConversationID, userID); err != nil {
+			return nil, err
+		}
+		return &emoji, nil
+	}
+	user, err := s.userService.GetUserProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.WorkspaceID != emoji.WorkspaceID {
+		return nil, notFoundError("emoji not found")
+	}
+	return &emoji, nil
+}
+
+// ValidateReaction checks that a reaction is a built-in shortcode or a custom emoji usable in
+// the conversation, and returns the custom emoji's ID ("" for a built-in one)
+func (s *EmojiService) ValidateReaction(ctx context.Context, conversationID, shortcode string) (string, error) {
+	if builtinEmoji[shortcode] {
+		return "", nil
+	}
+	resolved, err := s.resolve(ctx, conversationID, []string{shortcode})
+	if err != nil {
+		return "", err
+	}
+	id, ok := resolved[shortcode]
+	if !ok {
+		return "", validationError("unknown emoji :" + shortcode + ":")
+	}
+	return id, nil
+}
+
+// ResolveShortcodes maps the custom emoji shortcodes in a message body to their IDs. Built-in
+// shortcodes need no mapping, and anything else between colons is left as text, as "10:30:00"
+// would be.
+func (s *EmojiService) ResolveShortcodes(ctx context.Context, conversationID, body string) (map[string]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range shortcodePattern.FindAllStringSubmatch(body, -1) {
+		name := match[1]
+		if emojiNamePattern.MatchString(name) && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return s.resolve(ctx, conversationID, names)
+}
+
+// resolve looks up custom emoji by name, preferring the conversation's own over the workspace's
+func (s *EmojiService) resolve(ctx context.Context, conversationID string, names []string) (map[string]string, error) {
+	filter, err := s.inScope(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	filter["name"] = bson.M{"$in": names}
+	cursor, err := s.db.Collection(ctx, customEmojiCollection).Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"name": 1, "conversationId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find custom emoji: %w", err)
+	}
+	var found []models.CustomEmoji
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode custom emoji: %w", err)
+	}
+
+	resolved := make(map[string]string)
+	for _, emoji := range found {
+		if _, taken := resolved[emoji.Name]; !taken || emoji.ConversationID != "" {
+			resolved[emoji.Name] = emoji.ID
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, nil
+	}
+	return resolved, nil
+}
+
+// inScope matches the custom emoji of the conversation's workspace and the conversation's own
+func (s *EmojiService) inScope(ctx context.Context, conversationID string) (bson.M, error) {
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"workspaceId": conversation.WorkspaceID, "$or": bson.A{
+		bson.M{"conversationId": bson.M{"$exists": false}},
+		bson.M{"conversationId": conversationID},
+	}}, nil
+}
+
+// requireAdmin allows workspace admins to manage workspace emoji, and conversation admins to
+// manage their conversation's, and returns the workspace the emoji belong to
+func (s *EmojiService) requireAdmin(ctx context.Context, conversationID, actorID string) (string, error) {
+	if conversationID == "" {
+		actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+		if err != nil {
+			return "", err
+		}
+		return actor.WorkspaceID, nil
+	}
+	if _, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.ManageEmoji, "only admins can manage emoji"); err != nil {
+		return "", err
+	}
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return "", err
+	}
+	return conversation.WorkspaceID, nil
+}
+
+// checkEmojiImage returns the content type of an uploaded emoji image, going by its contents
+// rather than anything the client claims
+func checkEmojiImage(data []byte) (string, error) {
+	if len(data) > maxEmojiBytes {
+		return "", validationError(fmt.Sprintf("emoji images are limited to %d KB", maxEmojiBytes>>10))
+	}
+	contentType := http.DetectContentType(data)
+	if !emojiContentTypes[contentType] {
+		return "", validationError("emoji images must be PNG, GIF or JPEG")
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", validationError("emoji image could not be read")
+	}
+	if config.Width > maxEmojiSide || config.Height > maxEmojiSide {
+		return "", validationError(fmt.Sprintf("emoji images are limited to %dx%d pixels", maxEmojiSide, maxEmojiSide))
+	}
+	return contentType, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import "strings"
+
+// builtinEmoji are the standard shortcodes clients draw with their own emoji font. Custom emoji
+// cannot take these names, and reactions may use any of them.
+var builtinEmoji = func() map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range strings.Fields(builtinEmojiNames) {
+		set[name] = true
+	}
+	return set
+}()
+
+const builtinEmojiNames = `
++1 -1 100 1234 8ball a ab abc abcd accept admissions_tickets aerial_tramway airplane alarm_clock
+alembic alien ambulance amphora anchor angel anger angry anguished ant apple aquarius aries
+arrow_backward arrow_down arrow_forward arrow_left arrow_right arrow_up art astonished athletic_shoe
+atm avocado b baby baby_bottle back bacon badminton_racquet_and_shuttlecock balloon ballot_box_with_check
+bamboo banana bangbang bank bar_chart barber baseball basketball bat bath bathtub battery beach_with_umbrella
+bear bed bee beer beers beetle beginner bell bento bicyclist bike bikini bird birthday black_heart
+blossom blowfish blue_book blue_heart blush boar boat bomb book bookmark books boom boot bouquet bow
+bowling boy bread bride_with_veil bridge_at_night briefcase broken_heart bug bulb bullettrain_front
+burrito bus busstop bust_in_silhouette busts_in_silhouette butterfly cactus cake calendar calling camel
+camera camping cancer candle candy capital_abcd capricorn car card_index carrot cat cat2 cd chart
+chart_with_downwards_trend chart_with_upwards_trend checkered_flag cheese cherries cherry_blossom chestnut
+chicken children_crossing chipmunk chocolate_bar christmas_tree church cinema clap clapper clipboard clock1
+clock10 clock11 clock12 clock2 clock3 clock4 clock5 clock6 clock7 clock8 clock9 closed_book
+closed_lock_with_key closed_umbrella cloud clown_face clubs cocktail coffee cold_sweat collision comet
+computer confetti_ball confounded confused congratulations construction construction_worker control_knobs
+convenience_store cookie cool cop copyright corn couch_and_lamp couple cow cow2 crab crayon credit_card
+crescent_moon cricket crocodile croissant crossed_fingers crossed_flags crown cry crying_cat_face
+crystal_ball cucumber cupid curly_loop currency_exchange curry custard customs cyclone dagger_knife dancer
+dancers dango dark_sunglasses dart dash date deciduous_tree deer department_store desert desktop_computer
+diamonds disappointed disappointed_relieved dizzy dizzy_face do_not_litter dog dog2 dollar dolls dolphin
+door doughnut dove dragon dragon_face dress dromedary_camel drooling_face droplet duck dvd e-mail eagle
+ear ear_of_rice earth_africa earth_americas earth_asia egg eggplant eight eject electric_plug elephant
+email end envelope envelope_with_arrow euro european_castle evergreen_tree exclamation expressionless
+eye eyeglasses eyes face_palm face_with_monocle face_with_rolling_eyes face_with_thermometer facepunch
+factory fallen_leaf family fast_forward fax fearful feet female_sign ferris_wheel ferry field_hockey_stick_and_ball
+file_cabinet file_folder film_frames fire fire_engine fireworks first_place_medal fish fish_cake
+fishing_pole_and_fish fist five flag-us flags flashlight flexed_biceps floppy_disk flower_playing_cards flushed
+fog foggy football footprints fork_and_knife fountain four four_leaf_clover fox_face free fried_egg
+fried_shrimp fries frog frowning fuelpump full_moon full_moon_with_face game_die gear gem gemini ghost
+gift gift_heart girl globe_with_meridians goal_net goat golf gorilla grapes green_apple green_book
+green_heart grey_exclamation grey_question grimacing grin grinning guardsman guitar gun haircut
+hamburger hammer hammer_and_wrench hamster hand handbag handshake hankey hash hatched_chick hatching_chick
+headphones hear_no_evil heart heart_decoration heart_eyes heart_eyes_cat heartbeat heartpulse hearts
+heavy_check_mark heavy_division_sign heavy_dollar_sign heavy_minus_sign heavy_multiplication_x heavy_plus_sign
+helicopter herb hibiscus high_brightness high_heel hocho hole honey_pot honeybee horse horse_racing
+hospital hot_pepper hotdog hotel hotsprings hourglass hourglass_flowing_sand house house_with_garden
+hugging_face hushed ice_cream ice_hockey_stick_and_puck icecream id imp inbox_tray incoming_envelope
+information_desk_person information_source innocent interrobang iphone izakaya_lantern jack_o_lantern
+japan japanese_castle japanese_goblin japanese_ogre jeans joy joy_cat joystick kaaba key keyboard
+keycap_ten kimono kiss kissing kissing_cat kissing_closed_eyes kissing_heart kissing_smiling_eyes
+kiwifruit knife koala koko label ladybug lantern large_blue_circle large_blue_diamond large_orange_diamond
+last_quarter_moon laughing leaves ledger left_right_arrow lemon leo leopard level_slider libra light_rail
+link lion_face lips lipstick lizard lock lock_with_ink_pen lollipop loop loud_sound loudspeaker
+love_hotel love_letter low_brightness lying_face m mag mag_right mahjong mailbox mailbox_closed male_sign
+man man_dancing mans_shoe mantelpiece_clock maple_leaf mask massage meat_on_bone medal memo menorah_with_nine_branches
+mens metro microphone microscope middle_finger milky_way minibus minidisc mobile_phone money_mouth_face
+money_with_wings moneybag monkey monkey_face monorail moon mortar_board mosque motor_scooter motorboat
+motorway mount_fuji mountain mountain_bicyclist mountain_cableway mountain_railway mouse mouse2 movie_camera
+moyai muscle mushroom musical_keyboard musical_note musical_score mute nail_care name_badge nauseated_face
+necktie negative_squared_cross_mark nerd_face neutral_face new new_moon new_moon_with_face newspaper ng
+night_with_stars nine no_bell no_bicycles no_entry no_entry_sign no_good no_mobile_phones no_mouth
+no_pedestrians no_smoking non-potable_water nose notebook notebook_with_decorative_cover notes nut_and_bolt
+o o2 ocean octopus oden office ok ok_hand ok_woman old_key older_man older_woman om_symbol on oncoming_automobile
+oncoming_bus oncoming_police_car oncoming_taxi one open_book open_file_folder open_hands open_mouth
+ophiuchus orange_book orthodox_cross outbox_tray owl ox package page_facing_up page_with_curl pager
+palm_tree pancakes panda_face paperclip parking part_alternation_mark partly_sunny partying_face passport_control
+peach peanuts pear pencil pencil2 penguin pensive performing_arts persevere person_frowning
+person_with_blond_hair person_with_pouting_face phone pick pig pig2 pig_nose pill pineapple pisces
+pizza place_of_worship point_down point_left point_right point_up point_up_2 police_car poodle poop
+popcorn post_office postal_horn postbox potable_water potato pouch poultry_leg pound pouting_cat pray
+prayer_beads pretzel princess printer punch purple_heart purse pushpin put_litter_in_its_place question
+rabbit rabbit2 racehorse racing_car radio radio_button rage rage1 railway_car rainbow raised_hand
+raised_hands raising_hand ram ramen rat recycle red_car red_circle registered relaxed relieved
+reminder_ribbon repeat restroom revolving_hearts rewind rhinoceros ribbon rice rice_ball rice_cracker
+rice_scene ring robot_face rocket rofl roller_coaster rolling_on_the_floor_laughing rooster rose rosette
+rotating_light round_pushpin rowboat rugby_football runner running running_shirt_with_sash sa sagittarius
+sailboat sake salad sandal santa satellite satisfied saxophone scales school school_satchel scissors
+scorpion scorpius scream scream_cat scroll seat second_place_medal secret see_no_evil seedling selfie
+seven shallow_pan_of_food shamrock shark shaved_ice sheep shell shield ship shirt shit shopping_bags
+shopping_trolley shower shrimp shrug sign_of_the_horn signal_strength six six_pointed_star ski skier
+skull skull_and_crossbones sleeping sleeping_accommodation sleepy sleuth_or_spy slightly_frowning_face
+slightly_smiling_face slot_machine small_blue_diamond small_orange_diamond small_red_triangle
+small_red_triangle_down smile smile_cat smiley smiley_cat smiling_imp smirk smirk_cat smoking snail snake
+sneezing_face snowboarder snowflake snowman soccer soon sos sound space_invader spades spaghetti sparkle
+sparkler sparkles sparkling_heart speak_no_evil speaker speech_balloon speedboat spider spider_web
+spiral_calendar_pad spiral_note_pad spoon squid stadium star star2 star_struck stars station statue_of_liberty
+steam_locomotive stew stopwatch straight_ruler strawberry stuck_out_tongue stuck_out_tongue_closed_eyes
+stuck_out_tongue_winking_eye stuffed_flatbread sun_with_face sunflower sunglasses sunny sunrise
+sunrise_over_mountains surfer sushi suspension_railway sweat sweat_drops sweat_smile sweet_potato swimmer
+symbols synagogue syringe table_tennis_paddle_and_ball taco tada tanabata_tree tangerine taurus taxi tea
+telephone telephone_receiver telescope tennis tent thermometer thinking_face third_place_medal thought_balloon
+three three_button_mouse thumbsdown thumbsup ticket tiger tiger2 timer_clock tired_face tm toilet
+tokyo_tower tomato tongue top tophat tornado trackball tractor traffic_light train train2 tram
+triangular_flag_on_post triangular_ruler trident triumph trolleybus trophy tropical_drink tropical_fish
+truck trumpet tshirt tulip tumbler_glass turkey turtle tv twisted_rightwards_arrows two two_hearts
+two_men_holding_hands two_women_holding_hands u5272 u5408 u55b6 u6307 u6708 u6709 u6e80 u7121 u7533
+u7981 u7a7a umbrella umbrella_with_rain_drops unamused underage unicorn_face unlock up upside_down_face
+v vertical_traffic_light vhs vibration_mode video_camera video_game violin virgo volcano volleyball vs
+walking waning_crescent_moon waning_gibbous_moon warning wastebasket watch water_buffalo watermelon wave
+wavy_dash waxing_crescent_moon waxing_gibbous_moon wc weary wedding whale whale2 wheel_of_dharma
+wheelchair white_check_mark white_circle white_flower white_frowning_face white_square_button wilted_flower
+wind_blowing_face wind_chime wine_glass wink wolf woman womans_clothes womans_hat womens worried wrench
+writing_hand x yellow_heart yen yin_yang yum zany_face zap zebra_face zero zipper_mouth_face zzz
+`
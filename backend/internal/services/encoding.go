@@ -0,0 +1,263 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"nhooyr.io/websocket"
+)
+
+// Subprotocols selecting a binary frame encoding instead of JSON. ProtobufSubprotocol frames are
+// proto/ws.proto's Frame; MsgpackSubprotocol frames are the JSON envelope in MessagePack.
+const (
+	ProtobufSubprotocol = "chat.v1.proto"
+	MsgpackSubprotocol  = "chat.v1.msgpack"
+)
+
+// frameEncoding is how a connection's frames are written
+type frameEncoding int
+
+const (
+	encodingJSON frameEncoding = iota
+	encodingProtobuf
+	encodingMsgpack
+	encodingCount
+)
+
+// encodingNames are the names clients use for encodings in the auth frame
+var encodingNames = [encodingCount]string{"json", "protobuf", "msgpack"}
+
+func (e frameEncoding) String() string {
+	return encodingNames[e]
+}
+
+// parseEncoding looks up an encoding by the name a client gave
+func parseEncoding(name string) (frameEncoding, bool) {
+	for e, n := range encodingNames {
+		if n == name {
+			return frameEncoding(e), true
+		}
+	}
+	return encodingJSON, false
+}
+
+// connectionEncoding picks the encoding for the subprotocol agreed at the upgrade
+func connectionEncoding(subprotocol string) frameEncoding {
+	switch subprotocol {
+	case ProtobufSubprotocol:
+		return encodingProtobuf
+	case MsgpackSubprotocol:
+		return encodingMsgpack
+	default:
+		return encodingJSON
+	}
+}
+
+func (e frameEncoding) messageType() websocket.MessageType {
+	if e == encodingJSON {
+		return websocket.MessageText
+	}
+	return websocket.MessageBinary
+}
+
+// decodeFrame reads a client frame. Text messages are always JSON; binary ones are in the
+// connection's binary encoding, protobuf unless msgpack was chosen.
+func decodeFrame(messageType websocket.MessageType, data []byte, e frameEncoding, frame *models.WSFrame) error {
+	switch {
+	case messageType == websocket.MessageText:
+		return json.Unmarshal(data, frame)
+	case e == encodingMsgpack:
+		return unmarshalMsgpackFrame(data, frame)
+	default:
+		return unmarshalProtoFrame(data, frame)
+	}
+}
+
+// outboundFrame is a frame queued for one or more clients. Each encoding is produced at most
+// once, by whichever write pump needs it first, so a broadcast costs one marshal per encoding
+// in use rather than one per client.
+type outboundFrame struct {
+	*models.WSFrame
+
+	// switchTo, when set, is the encoding for frames written after this one
+	switchTo *frameEncoding
+
+	encoded [encodingCount]struct {
+		once sync.Once
+		data []byte
+		err  error
+	}
+}
+
+func newOutboundFrame(frame *models.WSFrame) *outboundFrame {
+	return &outboundFrame{WSFrame: frame}
+}
+
+func (f *outboundFrame) encode(e frameEncoding) ([]byte, error) {
+	enc := &f.encoded[e]
+	enc.once.Do(func() {
+		switch e {
+		case encodingProtobuf:
+			enc.data, enc.err = marshalProtoFrame(f.WSFrame)
+		case encodingMsgpack:
+			enc.data, enc.err = marshalMsgpackFrame(f.WSFrame)
+		default:
+			enc.data, enc.err = json.Marshal(f.WSFrame)
+		}
+	})
+	return enc.data, enc.err
+}
+
+// Field numbers of proto/ws.proto's Frame
+const (
+	protoFieldType      protowire.Number = 1
+	protoFieldTS        protowire.Number = 2
+	protoFieldData      protowire.Number = 3
+	protoFieldRequestID protowire.Number = 4
+)
+
+// marshalProtoFrame encodes a frame as a protobuf Frame. The payload goes through its JSON
+// form, so both encodings carry the same fields.
+func marshalProtoFrame(frame *models.WSFrame) ([]byte, error) {
+	jsonData, err := json.Marshal(frame.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal frame data: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to convert frame data: %w", err)
+	}
+	value, err := structpb.NewValue(protoNumbers(generic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert frame data: %w", err)
+	}
+	valueBytes, err := proto.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal frame data: %w", err)
+	}
+
+	b := make([]byte, 0, len(valueBytes)+len(frame.Type)+len(frame.RequestID)+16)
+	b = protowire.AppendTag(b, protoFieldType, protowire.BytesType)
+	b = protowire.AppendString(b, frame.Type)
+	if frame.TS != 0 {
+		b = protowire.AppendTag(b, protoFieldTS, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(frame.TS))
+	}
+	b = protowire.AppendTag(b, protoFieldData, protowire.BytesType)
+	b = protowire.AppendBytes(b, valueBytes)
+	if frame.RequestID != "" {
+		b = protowire.AppendTag(b, protoFieldRequestID, protowire.BytesType)
+		b = protowire.AppendString(b, frame.RequestID)
+	}
+	return b, nil
+}
+
+// maxExactDouble is the largest integer every smaller integer of which a double holds exactly
+const maxExactDouble = 1 << 53
+
+// protoNumbers replaces the json.Numbers in decoded JSON with doubles, except integers a double
+// cannot hold exactly (Snowflake message IDs), which become decimal strings as in the proto3
+// JSON mapping of int64
+func protoNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil && (i > maxExactDouble || i < -maxExactDouble) {
+			return v.String()
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = protoNumbers(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = protoNumbers(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// unmarshalProtoFrame decodes a protobuf Frame. Data is left in the generic form JSON decoding
+// produces, so frame handlers need not care which encoding a client uses.
+func unmarshalProtoFrame(b []byte, frame *models.WSFrame) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == protoFieldType && typ == protowire.BytesType:
+			frame.Type, n = protowire.ConsumeString(b)
+		case num == protoFieldTS && typ == protowire.VarintType:
+			var ts uint64
+			ts, n = protowire.ConsumeVarint(b)
+			frame.TS = int64(ts)
+		case num == protoFieldData && typ == protowire.BytesType:
+			var valueBytes []byte
+			valueBytes, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				var value structpb.Value
+				if err := proto.Unmarshal(valueBytes, &value); err != nil {
+					return fmt.Errorf("invalid frame data: %w", err)
+				}
+				frame.Data = value.AsInterface()
+			}
+		case num == protoFieldRequestID && typ == protowire.BytesType:
+			frame.RequestID, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	if frame.Type == "" {
+		return errors.New("frame has no type")
+	}
+	return nil
+}
+
+// marshalMsgpackFrame encodes a frame as MessagePack with the JSON field names. Integers keep
+// full precision and times use the MessagePack timestamp extension.
+func marshalMsgpackFrame(frame *models.WSFrame) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(frame); err != nil {
+		return nil, fmt.Errorf("failed to marshal frame: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalMsgpackFrame decodes a MessagePack frame, leaving data in generic form as
+// unmarshalProtoFrame does
+func unmarshalMsgpackFrame(data []byte, frame *models.WSFrame) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(frame); err != nil {
+		return err
+	}
+	if frame.Type == "" {
+		return errors.New("frame has no type")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+)
+
+// Error kinds. Services report failures a caller can act on as an *Error of one of these
+// kinds; any other error is an internal failure whose details stay in the logs.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrForbidden   = errors.New("forbidden")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrRateLimited = errors.New("rate limited")
+	ErrUpstream    = errors.New("upstream failed")    // a third-party service the request needed
+	ErrQuota       = errors.New("quota exceeded")     // the workspace has used up a quota
+	ErrLocked      = errors.New("locked")             // an admin has locked the conversation
+	ErrChallenge   = errors.New("challenge required") // the caller must solve a CAPTCHA first
+)
+
+// Error is a service failure with a kind (matched with errors.Is) and a client-safe message
+type Error struct {
+	Kind    error
+	Message string
+	// RetryAfter is how long until the request would succeed, when a rate limit knows it
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func notFoundError(message string) error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+func forbiddenError(message string) error {
+	return &Error{Kind: ErrForbidden, Message: message}
+}
+
+func conflictError(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+func validationError(message string) error {
+	return &Error{Kind: ErrValidation, Message: message}
+}
+
+func rateLimitedError(message string) error {
+	return &Error{Kind: ErrRateLimited, Message: message}
+}
+
+// retryLaterError is a rateLimitedError that tells the caller when to try again
+func retryLaterError(message string, retryAfter time.Duration) error {
+	return &Error{Kind: ErrRateLimited, Message: message, RetryAfter: retryAfter}
+}
+
+func upstreamError(message string) error {
+	return &Error{Kind: ErrUpstream, Message: message}
+}
+
+func quotaError(message string) error {
+	return &Error{Kind: ErrQuota, Message: message}
+}
+
+func lockedError(message string) error {
+	return &Error{Kind: ErrLocked, Message: message}
+}
+
+// errorMappings is the single translation from error kinds to HTTP statuses and WS error codes
+var errorMappings = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrNotFound, http.StatusNotFound, "NOT_FOUND"},
+	{ErrForbidden, http.StatusForbidden, "FORBIDDEN"},
+	{ErrConflict, http.StatusConflict, "CONFLICT"},
+	{ErrValidation, http.StatusBadRequest, "VALIDATION"},
+	{ErrRateLimited, http.StatusTooManyRequests, "RATE_LIMITED"},
+	{ErrUpstream, http.StatusBadGateway, "UPSTREAM"},
+	{ErrQuota, http.StatusPaymentRequired, "QUOTA_EXCEEDED"},
+	{ErrLocked, http.StatusLocked, "CONVERSATION_LOCKED"},
+	{ErrChallenge, http.StatusForbidden, "CHALLENGE_REQUIRED"},
+	{database.ErrUnavailable, http.StatusServiceUnavailable, "UNAVAILABLE"},
+}
+
+// HTTPStatus returns the HTTP status for err; internal errors map to 500
+func HTTPStatus(err error) int {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.kind) {
+			return m.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorCode returns the WS error code for err, or fallback for internal errors
+func ErrorCode(err error, fallback string) string {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.kind) {
+			return m.code
+		}
+	}
+	return fallback
+}
+
+// RetryAfter returns how long the caller should wait before retrying after err, or zero when
+// it is not known
+func RetryAfter(err error) time.Duration {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.RetryAfter
+	}
+	return 0
+}
+
+// PublicMessage returns err's client-safe message, or fallback for internal errors
+func PublicMessage(err error, fallback string) string {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Message
+	}
+	if errors.Is(err, database.ErrUnavailable) {
+		return "Service temporarily unavailable; retry shortly"
+	}
+	return fallback
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+)
+
+func TestErrorMappings(t *testing.T) {
+	tests := []struct {
+		kind   error
+		status int
+		code   string
+	}{
+		{ErrNotFound, http.StatusNotFound, "NOT_FOUND"},
+		{ErrForbidden, http.StatusForbidden, "FORBIDDEN"},
+		{ErrConflict, http.StatusConflict, "CONFLICT"},
+		{ErrValidation, http.StatusBadRequest, "VALIDATION"},
+		{ErrRateLimited, http.StatusTooManyRequests, "RATE_LIMITED"},
+		{ErrUpstream, http.StatusBadGateway, "UPSTREAM"},
+		{ErrQuota, http.StatusPaymentRequired, "QUOTA_EXCEEDED"},
+		{ErrLocked, http.StatusLocked, "CONVERSATION_LOCKED"},
+		{ErrChallenge, http.StatusForbidden, "CHALLENGE_REQUIRED"},
+		{database.ErrUnavailable, http.StatusServiceUnavailable, "UNAVAILABLE"},
+	}
+	if len(tests) != len(errorMappings) {
+		t.Fatalf("%d kinds tested, %d mapped", len(tests), len(errorMappings))
+	}
+
+	for _, tt := range tests {
+		serviceErr := &Error{Kind: tt.kind, Message: "public"}
+		errs := map[string]error{
+			"kind":            tt.kind,
+			"wrapped kind":    fmt.Errorf("failed to do it: %w", tt.kind),
+			"service error":   serviceErr,
+			"wrapped service": fmt.Errorf("failed to do it: %w", serviceErr),
+		}
+		for name, err := range errs {
+			t.Run(tt.code+"/"+name, func(t *testing.T) {
+				if got := HTTPStatus(err); got != tt.status {
+					t.Errorf("HTTPStatus = %d, want %d", got, tt.status)
+				}
+				if got := ErrorCode(err, "FALLBACK"); got != tt.code {
+					t.Errorf("ErrorCode = %q, want %q", got, tt.code)
+				}
+			})
+		}
+	}
+}
+
+func TestErrorMappingsInternal(t *testing.T) {
+	for name, err := range map[string]error{
+		"plain":   errors.New("connection reset"),
+		"wrapped": fmt.Errorf("failed to find user: %w", errors.New("connection reset")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := HTTPStatus(err); got != http.StatusInternalServerError {
+				t.Errorf("HTTPStatus = %d, want 500", got)
+			}
+			if got := ErrorCode(err, "SEND_FAILED"); got != "SEND_FAILED" {
+				t.Errorf("ErrorCode = %q, want the fallback", got)
+			}
+			if got := PublicMessage(err, "Failed to send"); got != "Failed to send" {
+				t.Errorf("PublicMessage = %q, want the fallback", got)
+			}
+			if got := RetryAfter(err); got != 0 {
+				t.Errorf("RetryAfter = %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	err := retryLaterError("Slow mode is on", 7*time.Second)
+	for name, err := range map[string]error{
+		"unwrapped": err,
+		"wrapped":   fmt.Errorf("failed to send message: %w", err),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := RetryAfter(err); got != 7*time.Second {
+				t.Errorf("RetryAfter = %v, want 7s", got)
+			}
+			if got := HTTPStatus(err); got != http.StatusTooManyRequests {
+				t.Errorf("HTTPStatus = %d, want 429", got)
+			}
+		})
+	}
+	if got := RetryAfter(rateLimitedError("Too many requests")); got != 0 {
+		t.Errorf("RetryAfter without a known wait = %v, want 0", got)
+	}
+}
+
+func TestPublicMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"service error", notFoundError("Conversation not found"), "Conversation not found"},
+		{"wrapped service error", fmt.Errorf("failed to load: %w", forbiddenError("Not a participant")), "Not a participant"},
+		{"unavailable", fmt.Errorf("failed to find messages: %w", database.ErrUnavailable), "Service temporarily unavailable; retry shortly"},
+		{"internal", errors.New("decode failed"), "Something went wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PublicMessage(tt.err, "Something went wrong"); got != tt.want {
+				t.Errorf("PublicMessage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// EventStream is a read-only listener on one conversation's live frames, behind the
+// server-sent events fallback for clients whose proxies break WebSockets. It shares the
+// conversation's subscription with WebSocket clients, so it sees the same frames in the same
+// order, but never announces presence. A stream that falls behind is closed rather than
+// disconnecting anyone else; its client reconnects with Last-Event-ID and replays.
+//
+// Streams are listed among their user's sessions, so revoking one, or deactivating the user,
+// closes it like a socket. Removing the user from the conversation, or deleting it, closes
+// their streams too, except those opened through a watch grant, which end with the grant.
+type EventStream struct {
+	ID             string
+	UserID         string
+	ConversationID string
+	watching       bool // opened through a watch grant
+
+	session   models.Session
+	frames    chan *models.WSFrame
+	mu        sync.RWMutex
+	closed    bool
+	closeCode *CloseCode // why the stream was closed, if the client should be told
+	hub       *WebSocketHub
+}
+
+// OpenEventStream starts routing a conversation's frames to a new stream for the user, named
+// in the session list by device and ip. watching marks a stream authorized by a watch grant
+// rather than participation. Callers must have authorized the reader and must Close the
+// stream when done.
+func (h *WebSocketHub) OpenEventStream(ctx context.Context, conversationID, userID string, watching bool, device, ip string) *EventStream {
+	connectedAt := h.clock.Now()
+	streamID := fmt.Sprintf("%s-%d", userID, connectedAt.UnixNano())
+	stream := &EventStream{
+		ID:             streamID,
+		UserID:         userID,
+		ConversationID: conversationID,
+		watching:       watching,
+		session:        newSession(streamID, device, ip, connectedAt),
+		frames:         make(chan *models.WSFrame, h.config.SendBufferSize),
+		hub:            h,
+	}
+
+	h.subsMu.Lock()
+	sub := h.subscriptionLocked(conversationID, tenant.FromContext(ctx))
+	sub.ClientsMu.Lock()
+	sub.streams[stream] = true
+	sub.ClientsMu.Unlock()
+	h.subsMu.Unlock()
+
+	h.clientsMu.Lock()
+	h.streams[stream.ID] = stream
+	h.clientsMu.Unlock()
+	h.putSession(stream.UserID, stream.session)
+
+	return stream
+}
+
+// Frames delivers the stream's frames; it is closed when the stream is closed or overflows
+func (s *EventStream) Frames() <-chan *models.WSFrame {
+	return s.frames
+}
+
+// CloseCode returns why the stream was closed by the server, or nil if it was not
+func (s *EventStream) CloseCode() *CloseCode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closeCode
+}
+
+// closeWith closes the stream, telling its client why
+func (s *EventStream) closeWith(code CloseCode) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closeCode = &code
+	}
+	s.mu.Unlock()
+	s.Close()
+}
+
+// Close stops the stream
+func (s *EventStream) Close() {
+	h := s.hub
+	h.clientsMu.Lock()
+	registered := h.streams[s.ID] == s
+	delete(h.streams, s.ID)
+	h.clientsMu.Unlock()
+	if registered {
+		h.deleteSession(s.UserID, s.ID)
+	}
+
+	h.subsMu.Lock()
+	if sub, ok := h.subscriptions[s.ConversationID]; ok {
+		sub.ClientsMu.Lock()
+		delete(sub.streams, s)
+		remaining := len(sub.Clients) + len(sub.streams)
+		sub.ClientsMu.Unlock()
+		if remaining == 0 {
+			delete(h.subscriptions, s.ConversationID)
+		}
+	}
+	h.subsMu.Unlock()
+
+	s.closeFrames()
+}
+
+// send queues a frame without blocking, closing the stream if it is full
+func (s *EventStream) send(frame *models.WSFrame) {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return
+	}
+	var queued bool
+	select {
+	case s.frames <- frame:
+		queued = true
+	default:
+	}
+	s.mu.RUnlock()
+
+	if !queued {
+		s.hub.backpressure.slowDisconnects.Add(1)
+		s.closeFrames()
+	}
+}
+
+func (s *EventStream) closeFrames() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.frames)
+	}
+}
+
+// ReplayEvents returns the frames a stream missed after lastMessageID, retractions and updates
+// first, and whether there were more than one replay returns
+func (h *WebSocketHub) ReplayEvents(ctx context.Context, conversationID string, lastMessageID int64) ([]*models.WSFrame, bool, error) {
+	replay, err := h.messageService.ReplaySince(ctx, conversationID, lastMessageID, maxResumeReplay)
+	if err != nil {
+		return nil, false, err
+	}
+
+	frames := make([]*models.WSFrame, 0, len(replay.Retracted)+len(replay.Updated)+len(replay.Messages))
+	for i := range replay.Retracted {
+		frames = append(frames, h.newFrame("message.retracted", &replay.Retracted[i]))
+	}
+	for i := range replay.Updated {
+		frames = append(frames, h.newFrame("message.updated", &replay.Updated[i]))
+	}
+	for i := range replay.Messages {
+		frames = append(frames, h.newFrame("message.new", &replay.Messages[i]))
+	}
+	return frames, replay.More, nil
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Fan-out runs a fixed set of node-wide subscriptions rather than a set per conversation: one
+// JetStream ordered consumer over chat.conv.*.msg and a core wildcard subscription per ephemeral
+// subject. Each event is routed to the conversation's local subscription, or dropped if no
+// client on this node is subscribed. Subscribing a client is then only a map update, and a
+// node's NATS footprint stays the same however many conversations its clients follow.
+// Presence is the exception: it is kept in a KV bucket, see presence_cluster.go.
+//
+// After a NATS outage the ordered consumer resumes from the last message it delivered and
+// core subscriptions are restored by the client, so nothing needs re-creating.
+
+// Start begins node-wide fan-out
+func (h *WebSocketHub) Start(ctx context.Context) error {
+	consumer, err := h.natsConn.MessageFeed(ctx)
+	if err != nil {
+		return err
+	}
+	feed, err := consumer.Consume(func(msg jetstream.Msg) {
+		if sub := h.localSubscription(msg.Subject()); sub != nil {
+			h.handleMessageEvent(sub, msg.Headers(), msg.Data())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to consume message feed: %w", err)
+	}
+	h.feed = feed
+
+	if err := h.startPresence(ctx); err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to start presence: %w", err)
+	}
+	if err := h.startSessions(ctx); err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to start session registry: %w", err)
+	}
+	revokeSub, err := h.natsConn.Conn.Subscribe(nats.SessionRevokeSubject, func(msg *natsgo.Msg) {
+		h.closeSession(string(msg.Data), CloseSessionRevoked)
+	})
+	if err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to subscribe to session revocations: %w", err)
+	}
+	h.natsSubs = append(h.natsSubs, revokeSub)
+	supersedeSub, err := h.natsConn.Conn.Subscribe(nats.SessionSupersedeSubject, func(msg *natsgo.Msg) {
+		h.closeSession(string(msg.Data), CloseSuperseded)
+	})
+	if err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to subscribe to session supersedes: %w", err)
+	}
+	h.natsSubs = append(h.natsSubs, supersedeSub)
+	selfReceiptSub, err := h.natsConn.Conn.Subscribe(nats.SelfReceiptSubject, func(msg *natsgo.Msg) {
+		h.handleSelfReceipt(msg.Data)
+	})
+	if err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to subscribe to self receipts: %w", err)
+	}
+	h.natsSubs = append(h.natsSubs, selfReceiptSub)
+	awaySub, err := h.natsConn.Conn.Subscribe(nats.AwaySummarySubject, func(msg *natsgo.Msg) {
+		h.handleAwaySummary(msg.Data)
+	})
+	if err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to subscribe to away summaries: %w", err)
+	}
+	h.natsSubs = append(h.natsSubs, awaySub)
+	conversationSub, err := h.natsConn.Conn.Subscribe(nats.ConversationEventSubject, func(msg *natsgo.Msg) {
+		h.handleConversationEvent(msg.Data)
+	})
+	if err != nil {
+		h.Stop()
+		return fmt.Errorf("failed to subscribe to conversation events: %w", err)
+	}
+	h.natsSubs = append(h.natsSubs, conversationSub)
+
+	ephemeral := map[string]func(*ConversationSubscription, []byte){
+		"chat.conv.*.typing":   h.handleTypingEvent,
+		"chat.conv.*.receipt":  h.handleReceiptEvent,
+		"chat.conv.*.bots":     h.handleBotEvent,
+		"chat.conv.*.poll":     h.handlePollEvent,
+		"chat.conv.*.location": h.handleLocationEvent,
+		"chat.conv.*.presence": h.handleStatusEvent,
+	}
+	for subject, handle := range ephemeral {
+		natsSub, err := h.natsConn.Conn.Subscribe(subject, func(msg *natsgo.Msg) {
+			if sub := h.localSubscription(msg.Subject); sub != nil {
+				handle(sub, msg.Data)
+			}
+		})
+		if err != nil {
+			h.Stop()
+			return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+		h.natsSubs = append(h.natsSubs, natsSub)
+	}
+	return nil
+}
+
+// Stop ends node-wide fan-out
+func (h *WebSocketHub) Stop() {
+	if h.feed != nil {
+		h.feed.Stop()
+	}
+	h.stopPresence()
+	for _, natsSub := range h.natsSubs {
+		natsSub.Unsubscribe()
+	}
+	h.natsSubs = nil
+}
+
+// localSubscription returns this node's subscription to the conversation a subject belongs to
+func (h *WebSocketHub) localSubscription(subject string) *ConversationSubscription {
+	conversationID := conversationIDFromSubject(subject)
+
+	h.subsMu.RLock()
+	defer h.subsMu.RUnlock()
+	return h.subscriptions[conversationID]
+}
+
+func (h *WebSocketHub) handleMessageEvent(sub *ConversationSubscription, header natsgo.Header, data []byte) {
+	switch nats.MessageEvent(header) {
+	case nats.EventMessageRetracted:
+		var retraction models.WSMessageRetractedData
+		if err := json.Unmarshal(data, &retraction); err != nil {
+			h.logger.Error("Failed to unmarshal retraction data", logging.ConversationID, sub.ConversationID, logging.RequestID, header.Get(requestid.Header), logging.Err(err))
+			return
+		}
+		// Retractions carry no sequence; they only remove a message clients already hold
+		h.broadcastToSubscription(sub, h.newFrame("message.retracted", retraction))
+		return
+	case nats.EventMessageUpdated:
+		var update models.WSMessageUpdatedData
+		if err := json.Unmarshal(data, &update); err != nil {
+			h.logger.Error("Failed to unmarshal update data", logging.ConversationID, sub.ConversationID, logging.RequestID, header.Get(requestid.Header), logging.Err(err))
+			return
+		}
+		// Like retractions, updates change a message clients already hold
+		h.broadcastToSubscription(sub, h.newFrame("message.updated", update))
+		return
+	}
+
+	var messageData models.WSMessageNewData
+	if err := json.Unmarshal(data, &messageData); err != nil {
+		h.logger.Error("Failed to unmarshal message data", logging.ConversationID, sub.ConversationID, logging.RequestID, header.Get(requestid.Header), logging.Err(err))
+		return
+	}
+
+	h.deliverMessage(sub, messageData)
+}
+
+func (h *WebSocketHub) handleTypingEvent(sub *ConversationSubscription, data []byte) {
+	var typingData models.WSTypingUpdateEventData
+	if err := json.Unmarshal(data, &typingData); err != nil {
+		h.logger.Error("Failed to unmarshal typing data", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("typing.update", typingData))
+}
+
+func (h *WebSocketHub) handleReceiptEvent(sub *ConversationSubscription, data []byte) {
+	var receiptData models.WSReceiptUpdateData
+	if err := json.Unmarshal(data, &receiptData); err != nil {
+		h.logger.Error("Failed to unmarshal receipt data", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("receipt.update", receiptData))
+}
+
+// handleSelfReceipt passes a user's read position to their connections on this node, found
+// through the user index rather than a conversation subscription: a device that has not
+// opened the conversation still shows its unread badge
+func (h *WebSocketHub) handleSelfReceipt(data []byte) {
+	var receiptData models.WSReceiptSelfData
+	if err := json.Unmarshal(data, &receiptData); err != nil {
+		h.logger.Error("Failed to unmarshal self receipt data", logging.Err(err))
+		return
+	}
+
+	h.sendToUser(receiptData.UserID, receiptData.SessionID, h.newFrame("receipt.self", receiptData))
+}
+
+// handleAwaySummary passes a user's away summary to their connections on this node
+func (h *WebSocketHub) handleAwaySummary(data []byte) {
+	var summary models.AwaySummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		h.logger.Error("Failed to unmarshal away summary", logging.Err(err))
+		return
+	}
+
+	h.sendToUser(summary.UserID, "", h.newFrame("away.summary", summary))
+}
+
+// handleConversationEvent passes a conversation lifecycle event to its recipients' connections on
+// this node. Connections of users who left the conversation, or saw it deleted, stop getting
+// its events.
+func (h *WebSocketHub) handleConversationEvent(data []byte) {
+	var event models.WSConversationEventData
+	if err := json.Unmarshal(data, &event); err != nil {
+		h.logger.Error("Failed to unmarshal conversation event", logging.Err(err))
+		return
+	}
+
+	recipients := event.Recipients
+	event.Recipients = nil
+	frame := h.newFrame(event.Event, event)
+	for _, userID := range recipients {
+		h.sendToUser(userID, "", frame)
+	}
+
+	switch event.Event {
+	case models.ConversationDeleted:
+		h.dropSubscriptions(event.ConversationID, recipients)
+	case models.MemberRemoved:
+		h.dropSubscriptions(event.ConversationID, event.UserIDs)
+	}
+}
+
+// dropSubscriptions unsubscribes the users' connections on this node from a conversation and
+// closes their event streams on it, leaving watch-only subscriptions to their grants
+func (h *WebSocketHub) dropSubscriptions(conversationID string, userIDs []string) {
+	dropped := make(map[string]bool, len(userIDs))
+	var clients []*Client
+	var streams []*EventStream
+	h.clientsMu.RLock()
+	for _, userID := range userIDs {
+		dropped[userID] = true
+		for _, client := range h.userClients[userID] {
+			clients = append(clients, client)
+		}
+	}
+	for _, stream := range h.streams {
+		if dropped[stream.UserID] && stream.ConversationID == conversationID && !stream.watching {
+			streams = append(streams, stream)
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	for _, stream := range streams {
+		stream.Close()
+	}
+
+	for _, client := range clients {
+		client.subscriptionsMu.RLock()
+		_, watching := client.watching[conversationID]
+		subscribed := client.subscriptions[conversationID]
+		client.subscriptionsMu.RUnlock()
+		if subscribed && !watching {
+			h.unsubscribeClient(client, conversationID)
+		}
+	}
+}
+
+func (h *WebSocketHub) handleBotEvent(sub *ConversationSubscription, data []byte) {
+	var botData models.WSBotEventData
+	if err := json.Unmarshal(data, &botData); err != nil {
+		h.logger.Error("Failed to unmarshal bot event", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("bot."+botData.Action, botData))
+}
+
+func (h *WebSocketHub) handlePollEvent(sub *ConversationSubscription, data []byte) {
+	var pollData models.WSPollUpdateData
+	if err := json.Unmarshal(data, &pollData); err != nil {
+		h.logger.Error("Failed to unmarshal poll update", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("poll.update", pollData))
+}
+
+func (h *WebSocketHub) handleLocationEvent(sub *ConversationSubscription, data []byte) {
+	var locationData models.WSLocationEventData
+	if err := json.Unmarshal(data, &locationData); err != nil {
+		h.logger.Error("Failed to unmarshal location update", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("location.update", locationData))
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Feed item kinds
+const (
+	FeedConversationCreated = "conversation.created"
+	FeedConversationActive  = "conversation.active"
+)
+
+const (
+	// feedActiveMinMessages is how many messages within the window make a conversation notable
+	feedActiveMinMessages = 10
+	// feedCreatedWeight ranks a new conversation like that many messages of activity
+	feedCreatedWeight = 20
+	// feedSenderWeight counts each distinct sender as that many messages; broad discussions rank higher
+	feedSenderWeight = 5
+	// feedHalfLife is the age at which an item's score has halved
+	feedHalfLife   = 24 * time.Hour
+	feedPreviewLen = 140
+)
+
+// FeedService builds the activity feed: notable events across the group conversations a user
+// belongs to, ranked so a lead can skim them without opening every conversation. Items are
+// computed on request from the last window of conversations and messages.
+type FeedService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	clock               clock.Clock
+	window              time.Duration
+}
+
+func NewFeedService(db *database.MongoDB, conversationService *ConversationService, clk clock.Clock, window time.Duration) *FeedService {
+	return &FeedService{
+		db:                  db,
+		conversationService: conversationService,
+		clock:               clk,
+		window:              window,
+	}
+}
+
+// feedCursor marks the last item of a page. Later pages are ranked as of the same moment, so
+// scores do not drift while the user pages through.
+type feedCursor struct {
+	AsOf  time.Time `json:"asOf"`
+	Score float64   `json:"score"`
+	ID    string    `json:"id"`
+}
+
+// GetFeed returns up to limit feed items for the user, highest score first, starting after
+// cursor (empty for the first page)
+func (s *FeedService) GetFeed(ctx context.Context, userID, cursor string, limit int) (*models.FeedPage, error) {
+	var after *feedCursor
+	asOf := s.clock.Now()
+	if cursor != "" {
+		decoded, err := decodeFeedCursor(cursor)
+		if err != nil {
+			return nil, validationError("invalid feed cursor")
+		}
+		after = decoded
+		asOf = decoded.AsOf
+	}
+
+	items, err := s.rankedItems(ctx, userID, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			return items[i].Score < after.Score || (items[i].Score == after.Score && items[i].ID > after.ID)
+		})
+	}
+	items = items[start:]
+
+	page := &models.FeedPage{Items: items, AsOf: asOf}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor, err = encodeFeedCursor(&feedCursor{AsOf: asOf, Score: last.Score, ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// rankedItems collects every feed item in the window ending at asOf, sorted by score then ID
+func (s *FeedService) rankedItems(ctx context.Context, userID string, asOf time.Time) ([]models.FeedItem, error) {
+	conversationIDs, err := s.conversationService.GetUserConversationIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversationIDs) == 0 {
+		return []models.FeedItem{}, nil
+	}
+
+	since := asOf.Add(-s.window)
+
+	cursor, err := s.db.Collection(ctx, "conversations").Find(ctx,
+		bson.M{"_id": bson.M{"$in": conversationIDs}, "kind": "group"},
+		options.Find().SetProjection(bson.M{"title": 1, "createdAt": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conversations: %w", err)
+	}
+	var conversations []models.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode conversations: %w", err)
+	}
+
+	titles := make(map[string]string, len(conversations))
+	groupIDs := make([]string, 0, len(conversations))
+	var created []models.Conversation
+	for _, conv := range conversations {
+		titles[conv.ID] = conv.Title
+		groupIDs = append(groupIDs, conv.ID)
+		if !conv.CreatedAt.Before(since) && !conv.CreatedAt.After(asOf) {
+			created = append(created, conv)
+		}
+	}
+
+	items := []models.FeedItem{}
+
+	if len(created) > 0 {
+		createdIDs := make([]string, len(created))
+		for i, conv := range created {
+			createdIDs[i] = conv.ID
+		}
+		memberCounts, err := s.memberCounts(ctx, createdIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, conv := range created {
+			members := memberCounts[conv.ID]
+			items = append(items, models.FeedItem{
+				ID:                FeedConversationCreated + ":" + conv.ID,
+				Kind:              FeedConversationCreated,
+				ConversationID:    conv.ID,
+				ConversationTitle: conv.Title,
+				OccurredAt:        conv.CreatedAt,
+				Score:             feedScore(float64(feedCreatedWeight+members), asOf.Sub(conv.CreatedAt)),
+				MemberCount:       members,
+			})
+		}
+	}
+
+	if len(groupIDs) > 0 {
+		activity, err := s.activity(ctx, groupIDs, since, asOf)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range activity {
+			senders := int64(len(a.Senders))
+			items = append(items, models.FeedItem{
+				ID:                FeedConversationActive + ":" + a.ConversationID,
+				Kind:              FeedConversationActive,
+				ConversationID:    a.ConversationID,
+				ConversationTitle: titles[a.ConversationID],
+				OccurredAt:        a.LastAt,
+				Score:             feedScore(float64(a.Count+feedSenderWeight*senders), asOf.Sub(a.LastAt)),
+				MessageCount:      a.Count,
+				SenderCount:       senders,
+				Preview:           shorten(a.LastBody, feedPreviewLen),
+			})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
+
+func (s *FeedService) memberCounts(ctx context.Context, conversationIDs []string) (map[string]int64, error) {
+	cursor, err := s.db.Collection(ctx, "participants").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"conversationId": bson.M{"$in": conversationIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$conversationId", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+
+	var rows []struct {
+		ConversationID string `bson:"_id"`
+		Count          int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to decode member counts: %w", err)
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ConversationID] = row.Count
+	}
+	return counts, nil
+}
+
+type conversationActivity struct {
+	ConversationID string    `bson:"_id"`
+	Count          int64     `bson:"count"`
+	Senders        []string  `bson:"senders"`
+	LastAt         time.Time `bson:"lastAt"`
+	LastBody       string    `bson:"lastBody"`
+}
+
+// activity summarises the messages sent in each conversation between since and asOf, keeping
+// conversations with at least feedActiveMinMessages. Retracted messages do not count.
+func (s *FeedService) activity(ctx context.Context, conversationIDs []string, since, asOf time.Time) ([]conversationActivity, error) {
+	cursor, err := s.db.Collection(ctx, "messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"conversationId": bson.M{"$in": conversationIDs},
+			"createdAt":      bson.M{"$gte": since, "$lte": asOf},
+			"retractedAt":    bson.M{"$exists": false},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$conversationId",
+			"count":    bson.M{"$sum": 1},
+			"senders":  bson.M{"$addToSet": "$senderId"},
+			"lastAt":   bson.M{"$last": "$createdAt"},
+			"lastBody": bson.M{"$last": "$body"},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gte": feedActiveMinMessages}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise activity: %w", err)
+	}
+
+	var activity []conversationActivity
+	if err := cursor.All(ctx, &activity); err != nil {
+		return nil, fmt.Errorf("failed to decode activity: %w", err)
+	}
+	return activity, nil
+}
+
+// feedScore decays weight by age; at feedHalfLife an item scores half its weight
+func feedScore(weight float64, age time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+	return weight / (1 + age.Hours()/feedHalfLife.Hours())
+}
+
+// shorten cuts s to at most n runes, marking the cut with an ellipsis
+func shorten(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+func encodeFeedCursor(c *feedCursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode feed cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeFeedCursor(s string) (*feedCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c feedCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.AsOf.IsZero() {
+		return nil, fmt.Errorf("cursor has no timestamp")
+	}
+	return &c, nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+)
+
+// GIF providers, selected with GIF_PROVIDER
+const (
+	GIFProviderGiphy = "giphy"
+	GIFProviderTenor = "tenor"
+	GIFProviderOff   = "off"
+)
+
+const (
+	maxGIFQuery       = 100
+	defaultGIFResults = 24
+	maxGIFResults     = 50
+)
+
+// GIFProvider searches a GIF service. The kind is models.MessageTypeGIF or
+// models.MessageTypeSticker; cursor is the Next of the previous page.
+type GIFProvider interface {
+	Name() string
+	Search(ctx context.Context, kind, query, cursor string, limit int) (*models.MediaSearchResult, error)
+	Get(ctx context.Context, kind, id string) (*models.Media, error)
+}
+
+// errMediaNotFound is returned by providers for an ID they do not know
+var errMediaNotFound = errors.New("media not found")
+
+// GIFConfig configures the GIF provider
+type GIFConfig struct {
+	Provider string
+	APIKey   string
+	Rating   string // g, pg, pg-13 or r; the most a result may be rated
+	Timeout  time.Duration
+}
+
+// GIFService proxies GIF and sticker searches to the provider, so the provider's API key
+// stays on the server, and looks up what clients send
+type GIFService struct {
+	provider GIFProvider // nil when GIFs are off
+	logger   *slog.Logger
+}
+
+func NewGIFService(logger *slog.Logger, config GIFConfig) *GIFService {
+	client := &http.Client{Timeout: config.Timeout}
+	var provider GIFProvider
+	switch config.Provider {
+	case GIFProviderGiphy:
+		provider = &giphyProvider{client: client, apiKey: config.APIKey, rating: config.Rating}
+	case GIFProviderTenor:
+		provider = &tenorProvider{client: client, apiKey: config.APIKey, rating: config.Rating}
+	}
+	return &GIFService{provider: provider, logger: logger}
+}
+
+// Search finds GIFs or stickers for a query
+func (s *GIFService) Search(ctx context.Context, kind, query, cursor string, limit int) (*models.MediaSearchResult, error) {
+	if s.provider == nil {
+		return nil, notFoundError("GIFs are not enabled")
+	}
+	query = strings.TrimSpace(query)
+	if query == "" || len(query) > maxGIFQuery {
+		return nil, validationError(fmt.Sprintf("q must be 1 to %d characters", maxGIFQuery))
+	}
+	if kind == "" {
+		kind = models.MessageTypeGIF
+	}
+	if kind != models.MessageTypeGIF && kind != models.MessageTypeSticker {
+		return nil, validationError("kind must be gif or sticker")
+	}
+	if limit <= 0 {
+		limit = defaultGIFResults
+	}
+	limit = min(limit, maxGIFResults)
+
+	result, err := s.provider.Search(ctx, kind, query, cursor, limit)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "GIF search failed", "provider", s.provider.Name(), logging.Err(err))
+		return nil, upstreamError("GIF search is unavailable")
+	}
+	return result, nil
+}
+
+// media looks up what a GIF or sticker message sends. The URLs come from the provider, never
+// from the client, so messages only ever point at the provider's CDN.
+func (s *GIFService) media(ctx context.Context, kind, id string) (*models.Media, error) {
+	if s.provider == nil {
+		return nil, validationError("GIFs are not enabled")
+	}
+	if id == "" {
+		return nil, validationError("mediaId is required for " + kind + " messages")
+	}
+
+	media, err := s.provider.Get(ctx, kind, id)
+	if errors.Is(err, errMediaNotFound) {
+		return nil, validationError("unknown " + kind + " " + id)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "GIF lookup failed", "provider", s.provider.Name(), logging.Err(err))
+		return nil, upstreamError("GIFs are unavailable")
+	}
+	return media, nil
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+)
+
+const gifResponseMaxBytes = 4 << 20
+
+// getGIFJSON decodes the JSON response to a provider request. A 404 is errMediaNotFound.
+func getGIFJSON(ctx context.Context, client *http.Client, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Drop the URL, and the API key in it, from the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errMediaNotFound
+	case resp.StatusCode != http.StatusOK:
+		// The URL carries the API key, so only the status is reported
+		return fmt.Errorf("provider returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, gifResponseMaxBytes)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode provider response: %w", err)
+	}
+	return nil
+}
+
+// giphyProvider uses the Giphy API, whose stickers are GIFs with a transparent background
+type giphyProvider struct {
+	client *http.Client
+	apiKey string
+	rating string
+}
+
+type giphyImage struct {
+	URL    string `json:"url"`
+	Width  string `json:"width"`
+	Height string `json:"height"`
+}
+
+type giphyGIF struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Images struct {
+		Original   giphyImage `json:"original"`
+		FixedWidth giphyImage `json:"fixed_width"`
+	} `json:"images"`
+}
+
+func (p *giphyProvider) Name() string {
+	return GIFProviderGiphy
+}
+
+func (p *giphyProvider) Search(ctx context.Context, kind, query, cursor string, limit int) (*models.MediaSearchResult, error) {
+	offset, _ := strconv.Atoi(cursor)
+	params := url.Values{
+		"api_key": {p.apiKey},
+		"q":       {query},
+		"limit":   {strconv.Itoa(limit)},
+		"offset":  {strconv.Itoa(max(offset, 0))},
+		"rating":  {p.rating},
+	}
+	path := "gifs"
+	if kind == models.MessageTypeSticker {
+		path = "stickers"
+	}
+
+	var response struct {
+		Data       []giphyGIF `json:"data"`
+		Pagination struct {
+			TotalCount int `json:"total_count"`
+			Count      int `json:"count"`
+			Offset     int `json:"offset"`
+		} `json:"pagination"`
+	}
+	if err := getGIFJSON(ctx, p.client, "https://api.giphy.com/v1/"+path+"/search?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	result := &models.MediaSearchResult{Results: make([]models.Media, 0, len(response.Data))}
+	for _, gif := range response.Data {
+		result.Results = append(result.Results, p.media(gif))
+	}
+	if next := response.Pagination.Offset + response.Pagination.Count; response.Pagination.Count > 0 && next < response.Pagination.TotalCount {
+		result.Next = strconv.Itoa(next)
+	}
+	return result, nil
+}
+
+func (p *giphyProvider) Get(ctx context.Context, kind, id string) (*models.Media, error) {
+	params := url.Values{"api_key": {p.apiKey}}
+	var response struct {
+		Data giphyGIF `json:"data"`
+	}
+	if err := getGIFJSON(ctx, p.client, "https://api.giphy.com/v1/gifs/"+url.PathEscape(id)+"?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+	if response.Data.ID == "" {
+		return nil, errMediaNotFound
+	}
+	media := p.media(response.Data)
+	return &media, nil
+}
+
+func (p *giphyProvider) media(gif giphyGIF) models.Media {
+	width, _ := strconv.Atoi(gif.Images.Original.Width)
+	height, _ := strconv.Atoi(gif.Images.Original.Height)
+	return models.Media{
+		Provider:   GIFProviderGiphy,
+		ID:         gif.ID,
+		Title:      gif.Title,
+		URL:        gif.Images.Original.URL,
+		PreviewURL: gif.Images.FixedWidth.URL,
+		Width:      width,
+		Height:     height,
+	}
+}
+
+// tenorProvider uses the Tenor v2 API, which filters stickers out of the same search
+type tenorProvider struct {
+	client *http.Client
+	apiKey string
+	rating string
+}
+
+type tenorFormat struct {
+	URL  string `json:"url"`
+	Dims []int  `json:"dims"`
+}
+
+type tenorResult struct {
+	ID           string                 `json:"id"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"content_description"`
+	MediaFormats map[string]tenorFormat `json:"media_formats"`
+}
+
+// tenorContentFilters maps Giphy-style ratings to Tenor's content filters
+var tenorContentFilters = map[string]string{"g": "high", "pg": "medium", "pg-13": "low", "r": "off"}
+
+func (p *tenorProvider) Name() string {
+	return GIFProviderTenor
+}
+
+// formats returns the full-size and preview formats for kind; stickers have transparent ones
+func (p *tenorProvider) formats(kind string) (string, string) {
+	if kind == models.MessageTypeSticker {
+		return "gif_transparent", "tinygif_transparent"
+	}
+	return "gif", "tinygif"
+}
+
+func (p *tenorProvider) Search(ctx context.Context, kind, query, cursor string, limit int) (*models.MediaSearchResult, error) {
+	full, preview := p.formats(kind)
+	params := url.Values{
+		"key":           {p.apiKey},
+		"q":             {query},
+		"limit":         {strconv.Itoa(limit)},
+		"contentfilter": {tenorContentFilters[p.rating]},
+		"media_filter":  {full + "," + preview},
+	}
+	if cursor != "" {
+		params.Set("pos", cursor)
+	}
+	if kind == models.MessageTypeSticker {
+		params.Set("searchfilter", "sticker")
+	}
+
+	var response struct {
+		Results []tenorResult `json:"results"`
+		Next    string        `json:"next"`
+	}
+	if err := getGIFJSON(ctx, p.client, "https://tenor.googleapis.com/v2/search?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	result := &models.MediaSearchResult{Results: make([]models.Media, 0, len(response.Results)), Next: response.Next}
+	for _, r := range response.Results {
+		if media, ok := p.media(r, full, preview); ok {
+			result.Results = append(result.Results, media)
+		}
+	}
+	if len(response.Results) == 0 {
+		result.Next = ""
+	}
+	return result, nil
+}
+
+func (p *tenorProvider) Get(ctx context.Context, kind, id string) (*models.Media, error) {
+	full, preview := p.formats(kind)
+	params := url.Values{
+		"key":          {p.apiKey},
+		"ids":          {id},
+		"media_filter": {full + "," + preview},
+	}
+	var response struct {
+		Results []tenorResult `json:"results"`
+	}
+	if err := getGIFJSON(ctx, p.client, "https://tenor.googleapis.com/v2/posts?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+	// ids is a comma-separated list, so an ID with a comma could fetch several
+	if len(response.Results) != 1 || response.Results[0].ID != id {
+		return nil, errMediaNotFound
+	}
+	media, ok := p.media(response.Results[0], full, preview)
+	if !ok {
+		return nil, errMediaNotFound
+	}
+	return &media, nil
+}
+
+func (p *tenorProvider) media(r tenorResult, full, preview string) (models.Media, bool) {
+	format, ok := r.MediaFormats[full]
+	if !ok || format.URL == "" {
+		return models.Media{}, false
+	}
+	media := models.Media{
+		Provider:   GIFProviderTenor,
+		ID:         r.ID,
+		Title:      r.Title,
+		URL:        format.URL,
+		PreviewURL: r.MediaFormats[preview].URL,
+	}
+	if media.Title == "" {
+		media.Title = r.Description
+	}
+	if len(format.Dims) == 2 {
+		media.Width, media.Height = format.Dims[0], format.Dims[1]
+	}
+	return media, true
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+)
+
+const (
+	// DefaultGitHubAPIURL is github.com's REST API; GitHub Enterprise Server serves it under /api/v3
+	DefaultGitHubAPIURL    = "https://api.github.com"
+	githubResponseMaxBytes = 1 << 20
+)
+
+// errGitHubUnauthorized is GitHub refusing the access token
+var errGitHubUnauthorized = errors.New("github: token refused")
+
+type GitHubConfig struct {
+	APIURL  string
+	Timeout time.Duration
+}
+
+// GitHubVerifier checks a user's GitHub access token against GitHub, so the profile saved for
+// them is GitHub's rather than whatever the client sent
+type GitHubVerifier struct {
+	client *http.Client
+	apiURL string
+	logger *slog.Logger
+}
+
+func NewGitHubVerifier(logger *slog.Logger, config GitHubConfig) *GitHubVerifier {
+	return &GitHubVerifier{
+		client: &http.Client{Timeout: config.Timeout},
+		apiURL: strings.TrimSuffix(config.APIURL, "/"),
+		logger: logger,
+	}
+}
+
+type githubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// Verify replaces user's email, name and avatar with those of the GitHub account the token
+// belongs to. The account must be the user's: its numeric ID, its login or one of its
+// verified emails must be the user's ID, the subject of their token.
+func (v *GitHubVerifier) Verify(ctx context.Context, user *models.User, token string) error {
+	if token == "" {
+		return forbiddenError("a GitHub access token is required to save the profile")
+	}
+
+	var account githubUser
+	if err := v.get(ctx, "/user", token, &account); err != nil {
+		return v.verifyError(ctx, err)
+	}
+	// Listing emails needs the user:email scope; without it only the public email is known
+	var emails []githubEmail
+	if err := v.get(ctx, "/user/emails", token, &emails); err != nil && !errors.Is(err, errGitHubUnauthorized) {
+		return v.verifyError(ctx, err)
+	}
+
+	email := ""
+	matched := user.ID == strconv.FormatInt(account.ID, 10) || strings.EqualFold(user.ID, account.Login)
+	for _, e := range emails {
+		if !e.Verified {
+			continue
+		}
+		if strings.EqualFold(user.ID, e.Email) {
+			matched = true
+			email = e.Email
+		}
+		if e.Primary && email == "" {
+			email = e.Email
+		}
+	}
+	if email == "" {
+		// GitHub only shows a verified address as the public email
+		email = account.Email
+		matched = matched || (email != "" && strings.EqualFold(user.ID, email))
+	}
+	if !matched {
+		return forbiddenError("the GitHub account does not belong to the signed-in user")
+	}
+
+	user.Email = strings.ToLower(email)
+	user.Name = account.Name
+	if user.Name == "" {
+		user.Name = account.Login
+	}
+	user.AvatarURL = account.AvatarURL
+	return nil
+}
+
+// get decodes the JSON response to a GitHub API request made with the user's token. A 401,
+// 403 or 404 (GitHub's answer to a token without the scope a path needs) is
+// errGitHubUnauthorized.
+func (v *GitHubVerifier) get(ctx context.Context, path, token string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusNotFound:
+		return errGitHubUnauthorized
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("github: %s returned %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, githubResponseMaxBytes)).Decode(into); err != nil {
+		return fmt.Errorf("github: failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+func (v *GitHubVerifier) verifyError(ctx context.Context, err error) error {
+	if errors.Is(err, errGitHubUnauthorized) {
+		return forbiddenError("the GitHub access token is invalid or expired")
+	}
+	v.logger.ErrorContext(ctx, "GitHub profile lookup failed", logging.Err(err))
+	return upstreamError("could not verify the GitHub account")
+}
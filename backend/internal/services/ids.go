@@ -0,0 +1,39 @@
+package services
+
+import (
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+)
+
+// IDGenerator produces identifiers for new documents
+type IDGenerator interface {
+	// NewID returns a ULID for conversations, audit entries and similar documents
+	NewID() string
+	// NewMessageID returns a time-sortable message ID
+	NewMessageID() int64
+}
+
+type clockIDGenerator struct {
+	ulid      *id.ULID
+	snowflake *id.Snowflake
+}
+
+// NewIDGenerator returns the default IDGenerator, deriving IDs from the given clock. nodeID
+// must be unique per running instance (see id.NewSnowflake).
+func NewIDGenerator(clk clock.Clock, nodeID int64) (IDGenerator, error) {
+	snowflake, err := id.NewSnowflake(clk, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return &clockIDGenerator{ulid: id.NewULID(clk), snowflake: snowflake}, nil
+}
+
+// NewID returns a ULID, unique across nodes without coordination
+func (g *clockIDGenerator) NewID() string {
+	return g.ulid.Next()
+}
+
+// NewMessageID returns a snowflake ID, unique across nodes with distinct node IDs
+func (g *clockIDGenerator) NewMessageID() int64 {
+	return g.snowflake.Next()
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Imports bring history over from other chat systems. A batch is NDJSON: an optional header
+// mapping the source's author IDs to user IDs, then one message per line with its original
+// timestamp. Messages are written straight to the messages collection, bypassing the outbox, so
+// nothing is published on NATS: clients see imported history when they load it. Each message
+// is keyed by its source ID (clientMsgId "import:<externalId>"), so a batch sent again only
+// adds what is missing.
+//
+// Imported messages have no seq, since they sit before or among live ones, and their IDs are
+// minted at import time; history orders by createdAt first, so they appear where they were sent.
+
+const (
+	importMaxMessages  = 10000
+	importChunkSize    = 500
+	importMaxLineBytes = 64 << 10
+	importClientPrefix = "import:"
+)
+
+// ImportService writes imported message history
+type ImportService struct {
+	db           *database.MongoDB
+	userService  *UserService
+	auditService *AuditService
+	clock        clock.Clock
+	logger       *slog.Logger
+	ids          IDGenerator
+}
+
+func NewImportService(db *database.MongoDB, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *ImportService {
+	return &ImportService{
+		db:           db,
+		userService:  userService,
+		auditService: auditService,
+		clock:        clk,
+		logger:       logger,
+		ids:          ids,
+	}
+}
+
+// importLine is a parsed message line, remembered by line number for error reports
+type importLine struct {
+	line    int
+	message models.ImportMessage
+}
+
+// Import reads an NDJSON batch and writes its messages. Lines that cannot be imported are
+// reported in the result without stopping the rest.
+func (s *ImportService) Import(ctx context.Context, actorID string, batch io.Reader) (*models.ImportResult, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ImportResult{Failed: []models.ImportFailure{}}
+	fail := func(line int, reason string) {
+		result.Failed = append(result.Failed, models.ImportFailure{Line: line, Error: reason})
+	}
+
+	lines, authors, err := s.parseBatch(batch, fail)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return result, nil
+	}
+
+	users, conversations, err := s.resolve(ctx, actor.WorkspaceID, lines, authors)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	pending := make([]interface{}, 0, importChunkSize)
+	pendingLines := make([]int, 0, importChunkSize)
+	latest := make(map[string]time.Time)
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		imported, err := s.insertChunk(ctx, pending, pendingLines, result, fail)
+		result.Imported += imported
+		pending, pendingLines = pending[:0], pendingLines[:0]
+		return err
+	}
+
+	for _, l := range lines {
+		msg := l.message
+		senderID := msg.Author
+		if mapped, ok := authors[msg.Author]; ok {
+			senderID = mapped
+		}
+		switch {
+		case !users[senderID]:
+			fail(l.line, "unknown author "+msg.Author)
+			continue
+		case !conversations[msg.ConversationID]:
+			fail(l.line, "unknown conversation "+msg.ConversationID)
+			continue
+		case msg.CreatedAt.After(now):
+			fail(l.line, "createdAt is in the future")
+			continue
+		}
+
+		pending = append(pending, &models.Message{
+			ID:             s.ids.NewMessageID(),
+			ConversationID: msg.ConversationID,
+			SenderID:       senderID,
+			ClientMsgID:    importClientPrefix + msg.ExternalID,
+			Body:           msg.Body,
+			CreatedAt:      msg.CreatedAt.UTC(),
+		})
+		pendingLines = append(pendingLines, l.line)
+		if msg.CreatedAt.After(latest[msg.ConversationID]) {
+			latest[msg.ConversationID] = msg.CreatedAt.UTC()
+		}
+		if len(pending) == importChunkSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// Conversations sort by activity; imported history can only move that later
+	for conversationID, at := range latest {
+		_, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx,
+			bson.M{"_id": conversationID},
+			bson.M{"$max": bson.M{"lastMessageAt": at}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update lastMessageAt: %w", err)
+		}
+	}
+
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Line < result.Failed[j].Line })
+
+	if err := s.auditService.Record(ctx, AuditMessagesImported, actorID, "", map[string]interface{}{
+		"imported":      result.Imported,
+		"skipped":       result.Skipped,
+		"failed":        len(result.Failed),
+		"conversations": len(latest),
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit import", logging.Err(err))
+	}
+	return result, nil
+}
+
+// parseBatch reads the header and message lines, reporting lines that do not parse
+func (s *ImportService) parseBatch(batch io.Reader, fail func(int, string)) ([]importLine, map[string]string, error) {
+	scanner := bufio.NewScanner(batch)
+	scanner.Buffer(make([]byte, 0, 4096), importMaxLineBytes)
+
+	var lines []importLine
+	authors := map[string]string{}
+	number := 0
+	first := true
+	for scanner.Scan() {
+		number++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		if first {
+			first = false
+			var header models.ImportHeader
+			if err := json.Unmarshal(raw, &header); err == nil && header.Authors != nil {
+				authors = header.Authors
+				continue
+			}
+		}
+
+		var msg models.ImportMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			fail(number, "invalid JSON")
+			continue
+		}
+		if err := validate.Struct(&msg); err != nil {
+			fail(number, err.Error())
+			continue
+		}
+		if len(lines) == importMaxMessages {
+			return nil, nil, validationError(fmt.Sprintf("a batch holds at most %d messages", importMaxMessages))
+		}
+		lines = append(lines, importLine{line: number, message: msg})
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, validationError(fmt.Sprintf("line %d is longer than %d bytes", number+1, importMaxLineBytes))
+		}
+		return nil, nil, validationError("failed to read batch")
+	}
+	return lines, authors, nil
+}
+
+// resolve reports which of the batch's authors and conversations exist in the workspace
+func (s *ImportService) resolve(ctx context.Context, workspaceID string, lines []importLine, authors map[string]string) (map[string]bool, map[string]bool, error) {
+	userIDs := map[string]bool{}
+	conversationIDs := map[string]bool{}
+	for _, l := range lines {
+		author := l.message.Author
+		if mapped, ok := authors[author]; ok {
+			author = mapped
+		}
+		userIDs[author] = false
+		conversationIDs[l.message.ConversationID] = false
+	}
+
+	if err := s.markExisting(ctx, "users", workspaceID, userIDs); err != nil {
+		return nil, nil, err
+	}
+	if err := s.markExisting(ctx, "conversations", workspaceID, conversationIDs); err != nil {
+		return nil, nil, err
+	}
+	return userIDs, conversationIDs, nil
+}
+
+// markExisting sets ids[id] for each ID found in the collection within the workspace; IDs from
+// other workspaces are unknown, as if they did not exist
+func (s *ImportService) markExisting(ctx context.Context, collection, workspaceID string, ids map[string]bool) error {
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	cursor, err := s.db.Collection(ctx, collection).Find(ctx, bson.M{"_id": bson.M{"$in": list}, "workspaceId": workspaceID},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", collection, err)
+	}
+	var found []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", collection, err)
+	}
+	for _, doc := range found {
+		ids[doc.ID] = true
+	}
+	return nil
+}
+
+// insertChunk inserts messages unordered, counting those already imported as skipped
+func (s *ImportService) insertChunk(ctx context.Context, messages []interface{}, lines []int, result *models.ImportResult, fail func(int, string)) (int, error) {
+	_, err := s.db.Collection(ctx, "messages").InsertMany(ctx, messages, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return 0, fmt.Errorf("failed to import messages: %w", err)
+	}
+
+	imported := len(messages) - len(bulkErr.WriteErrors)
+	for _, writeErr := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(writeErr) {
+			result.Skipped++
+		} else {
+			fail(lines[writeErr.Index], "failed to write message")
+		}
+	}
+	return imported, nil
+}
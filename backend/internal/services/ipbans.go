@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operators ban addresses and networks through the admin API. The list lives in the ip_bans
+// collection, shared by every workspace, and each node keeps a parsed copy that the request
+// filter checks without a query. A node reloads its copy after its own changes and every
+// reload interval, so other nodes follow within that interval.
+
+const ipBansCollection = "ip_bans"
+
+// IPBanService manages the IP ban list and answers whether an address is banned
+type IPBanService struct {
+	db     *database.MongoDB
+	clock  clock.Clock
+	logger *slog.Logger
+	ids    IDGenerator
+
+	bans atomic.Pointer[[]ipBanEntry]
+}
+
+type ipBanEntry struct {
+	prefix    netip.Prefix
+	reason    string
+	expiresAt *time.Time
+}
+
+func NewIPBanService(db *database.MongoDB, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *IPBanService {
+	s := &IPBanService{db: db, clock: clk, logger: logger, ids: ids}
+	s.bans.Store(&[]ipBanEntry{})
+	return s
+}
+
+// Banned reports whether addr falls in a ban in force, and the ban's reason
+func (s *IPBanService) Banned(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	now := s.clock.Now()
+	for _, ban := range *s.bans.Load() {
+		if ban.prefix.Contains(addr) && (ban.expiresAt == nil || now.Before(*ban.expiresAt)) {
+			return ban.reason, true
+		}
+	}
+	return "", false
+}
+
+// ListBans returns every ban, expired ones included, newest first
+func (s *IPBanService) ListBans(ctx context.Context) ([]models.IPBan, error) {
+	cursor, err := s.db.Collection(ctx, ipBansCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list IP bans: %w", err)
+	}
+	bans := []models.IPBan{}
+	if err := cursor.All(ctx, &bans); err != nil {
+		return nil, fmt.Errorf("failed to decode IP bans: %w", err)
+	}
+	return bans, nil
+}
+
+// CreateBan bans an address or network and applies the ban on this node at once
+func (s *IPBanService) CreateBan(ctx context.Context, req *models.CreateIPBanRequest) (*models.IPBan, error) {
+	prefix, err := ParseIPPrefix(req.CIDR)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+
+	ban := &models.IPBan{
+		ID:        s.ids.NewID(),
+		CIDR:      prefix.String(),
+		Reason:    req.Reason,
+		CreatedAt: s.clock.Now(),
+	}
+	if req.DurationMinutes > 0 {
+		expiresAt := ban.CreatedAt.Add(time.Duration(req.DurationMinutes) * time.Minute)
+		ban.ExpiresAt = &expiresAt
+	}
+	if _, err := s.db.Collection(ctx, ipBansCollection).InsertOne(ctx, ban); err != nil {
+		return nil, fmt.Errorf("failed to create IP ban: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "IP ban created", "id", ban.ID, "cidr", ban.CIDR, "reason", ban.Reason)
+	s.reloadAfterChange(ctx)
+	return ban, nil
+}
+
+// DeleteBan lifts a ban and stops applying it on this node at once
+func (s *IPBanService) DeleteBan(ctx context.Context, banID string) error {
+	result, err := s.db.Collection(ctx, ipBansCollection).DeleteOne(ctx, bson.M{"_id": banID})
+	if err != nil {
+		return fmt.Errorf("failed to delete IP ban: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return notFoundError("IP ban not found")
+	}
+
+	s.logger.InfoContext(ctx, "IP ban deleted", "id", banID)
+	s.reloadAfterChange(ctx)
+	return nil
+}
+
+// Reload replaces this node's copy of the ban list with the stored one, leaving out expired bans
+func (s *IPBanService) Reload(ctx context.Context) error {
+	cursor, err := s.db.Collection(ctx, ipBansCollection).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": s.clock.Now()}},
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to load IP bans: %w", err)
+	}
+	var stored []models.IPBan
+	if err := cursor.All(ctx, &stored); err != nil {
+		return fmt.Errorf("failed to decode IP bans: %w", err)
+	}
+
+	bans := make([]ipBanEntry, 0, len(stored))
+	for _, ban := range stored {
+		prefix, err := netip.ParsePrefix(ban.CIDR)
+		if err != nil {
+			s.logger.WarnContext(ctx, "Skipping unparseable IP ban", "id", ban.ID, "cidr", ban.CIDR)
+			continue
+		}
+		bans = append(bans, ipBanEntry{prefix: prefix, reason: ban.Reason, expiresAt: ban.ExpiresAt})
+	}
+	s.bans.Store(&bans)
+	return nil
+}
+
+// RunReload reloads the ban list every interval until ctx is cancelled, starting at once
+func (s *IPBanService) RunReload(ctx context.Context, interval time.Duration) {
+	if err := s.Reload(ctx); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to load IP bans", logging.Err(err))
+	}
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				s.logger.ErrorContext(ctx, "Failed to reload IP bans", logging.Err(err))
+			}
+		}
+	}
+}
+
+// reloadAfterChange applies a change on this node; if the reload fails the change still
+// arrives with the next periodic reload
+func (s *IPBanService) reloadAfterChange(ctx context.Context) {
+	if err := s.Reload(ctx); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to reload IP bans", logging.Err(err))
+	}
+}
+
+// ParseIPPrefix parses an address, as a single-address prefix, or a CIDR network, canonicalized
+func ParseIPPrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not a valid CIDR network", value)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not a valid IP address", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
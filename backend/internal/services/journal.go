@@ -0,0 +1,267 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	journalConsumerName   = "journal"
+	journalInitialBackoff = time.Second
+	journalDeliverTimeout = 10 * time.Second
+	journalGapsReported   = 50
+)
+
+// JournalConfig configures delivery to the workspace's archiving endpoint
+type JournalConfig struct {
+	WebhookURL string        // empty disables journaling
+	Secret     string        // signs each delivery with HMAC-SHA256 in X-Journal-Signature
+	MaxBackoff time.Duration // cap for retry backoff
+}
+
+// JournalService forwards every event on the CHAT stream to an external webhook, in stream
+// order and at least once. A durable consumer with one message in flight provides the ordering;
+// a message is acked only after the endpoint returns 2xx and is retried with backoff until then.
+// Stream sequences skipped between deliveries (e.g. evicted by stream limits) are recorded as gaps.
+type JournalService struct {
+	natsConn    *nats.NATSConnection
+	db          *database.MongoDB
+	userService *UserService
+	clock       clock.Clock
+	logger      *slog.Logger
+	ids         IDGenerator
+	config      JournalConfig
+	httpClient  *http.Client
+
+	mu     sync.Mutex
+	status models.JournalStatus
+}
+
+func NewJournalService(natsConn *nats.NATSConnection, db *database.MongoDB, userService *UserService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, config JournalConfig) *JournalService {
+	return &JournalService{
+		natsConn:    natsConn,
+		db:          db,
+		userService: userService,
+		clock:       clk,
+		logger:      logger,
+		ids:         ids,
+		config:      config,
+		httpClient:  &http.Client{Timeout: journalDeliverTimeout},
+		status:      models.JournalStatus{Enabled: config.WebhookURL != ""},
+	}
+}
+
+// Run consumes and delivers stream events until ctx is cancelled
+func (s *JournalService) Run(ctx context.Context) {
+	if s.config.WebhookURL == "" {
+		return
+	}
+
+	consumer, err := s.natsConn.JS.CreateOrUpdateConsumer(ctx, nats.ChatStream, jetstream.ConsumerConfig{
+		Durable:       journalConsumerName,
+		Description:   "Compliance journaling webhook",
+		FilterSubject: "chat.conv.*.msg",
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+		MaxAckPending: 1,
+	})
+	if err != nil {
+		s.logger.Error("Failed to create journal consumer", logging.Err(err))
+		return
+	}
+
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		s.logger.Error("Failed to read journal consumer state", logging.Err(err))
+		return
+	}
+	s.mu.Lock()
+	s.status.LastDeliveredSeq = info.AckFloor.Stream
+	s.status.Pending = info.NumPending
+	s.mu.Unlock()
+
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(1, jetstream.FetchMaxWait(5*time.Second))
+		if err != nil {
+			s.logger.Warn("Failed to fetch journal events", logging.Err(err))
+			s.wait(ctx, journalInitialBackoff)
+			continue
+		}
+
+		for msg := range batch.Messages() {
+			s.handle(ctx, msg)
+		}
+	}
+}
+
+func (s *JournalService) handle(ctx context.Context, msg jetstream.Msg) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		s.logger.Error("Failed to read journal event metadata", logging.Err(err))
+		return
+	}
+
+	s.detectGap(ctx, meta.Sequence.Stream)
+
+	entry := &models.JournalEntry{
+		StreamSequence: meta.Sequence.Stream,
+		Event:          nats.MessageEvent(msg.Headers()),
+		ConversationID: conversationIDFromSubject(msg.Subject()),
+		Subject:        msg.Subject(),
+		StoredAt:       meta.Timestamp,
+		Data:           json.RawMessage(msg.Data()),
+	}
+
+	backoff := journalInitialBackoff
+	for {
+		err := s.deliver(ctx, entry)
+		if err == nil {
+			break
+		}
+
+		s.mu.Lock()
+		s.status.ConsecutiveFailures++
+		s.status.LastError = err.Error()
+		s.mu.Unlock()
+		s.logger.Warn("Journal delivery failed", "stream_seq", entry.StreamSequence, logging.Err(err))
+
+		// Keep the message in progress so it is not redelivered out from under us
+		msg.InProgress()
+		if !s.wait(ctx, backoff) {
+			// Unacked; the consumer redelivers it on the next start
+			return
+		}
+		backoff *= 2
+		if backoff > s.config.MaxBackoff {
+			backoff = s.config.MaxBackoff
+		}
+	}
+
+	if err := msg.Ack(); err != nil {
+		// The endpoint will see this sequence again; receivers dedupe on streamSequence
+		s.logger.Error("Failed to ack journal event", "stream_seq", entry.StreamSequence, logging.Err(err))
+	}
+
+	now := s.clock.Now()
+	s.mu.Lock()
+	s.status.LastDeliveredSeq = entry.StreamSequence
+	s.status.LastDeliveredAt = &now
+	s.status.Pending = meta.NumPending
+	s.status.ConsecutiveFailures = 0
+	s.status.LastError = ""
+	s.mu.Unlock()
+}
+
+func (s *JournalService) deliver(ctx context.Context, entry *models.JournalEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build journal request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Journal-Sequence", strconv.FormatUint(entry.StreamSequence, 10))
+	if s.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.config.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Journal-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver journal entry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("journal endpoint returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// detectGap records any stream sequences between the last delivered one and seq
+func (s *JournalService) detectGap(ctx context.Context, seq uint64) {
+	s.mu.Lock()
+	last := s.status.LastDeliveredSeq
+	s.mu.Unlock()
+
+	if seq <= last+1 {
+		return
+	}
+
+	gap := &models.JournalGap{
+		ID:            s.ids.NewID(),
+		FromSequence:  last + 1,
+		ToSequence:    seq - 1,
+		MissingEvents: seq - 1 - last,
+		DetectedAt:    s.clock.Now(),
+	}
+	s.logger.Warn("Journal gap: events left the stream before delivery", "from_seq", gap.FromSequence, "to_seq", gap.ToSequence)
+
+	if _, err := s.db.Collection(ctx, "journal_gaps").InsertOne(ctx, gap); err != nil {
+		s.logger.Error("Failed to record journal gap", logging.Err(err))
+	}
+}
+
+// Status reports journaling progress and the most recent gaps
+func (s *JournalService) Status(ctx context.Context, actorID string) (*models.JournalStatus, error) {
+	if err := requireDeploymentAdmin(ctx, s.userService, actorID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+
+	cursor, err := s.db.Collection(ctx, "journal_gaps").Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.M{"fromSequence": -1}).
+		SetLimit(journalGapsReported))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find journal gaps: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	status.Gaps = []models.JournalGap{}
+	if err := cursor.All(ctx, &status.Gaps); err != nil {
+		return nil, fmt.Errorf("failed to decode journal gaps: %w", err)
+	}
+
+	return &status, nil
+}
+
+// wait sleeps for d, returning false if ctx is cancelled first
+func (s *JournalService) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// A user is seen whenever one of their connections sends a frame (a heartbeat frame included),
+// answers a ping or closes. Sightings collect in memory and are written to users.lastSeenAt
+// every LastSeenInterval in one bulk write, so a busy node costs one write per interval rather
+// than one per frame. Bot connections are not counted. Cached profiles can lag by up to the
+// user cache TTL, which is fine for "last seen 5 minutes ago".
+
+// lastSeenState holds the sightings since the last flush, by workspace (see package tenant)
+type lastSeenState struct {
+	mu      sync.Mutex
+	pending map[string]map[string]time.Time
+}
+
+// markSeen records that the client's user is around
+func (h *WebSocketHub) markSeen(client *Client) {
+	if h.config.LastSeenInterval <= 0 || client.APIKeyID != "" {
+		return
+	}
+	now := h.clock.Now()
+
+	h.lastSeen.mu.Lock()
+	if h.lastSeen.pending == nil {
+		h.lastSeen.pending = make(map[string]map[string]time.Time)
+	}
+	seen := h.lastSeen.pending[client.workspaceID]
+	if seen == nil {
+		seen = make(map[string]time.Time)
+		h.lastSeen.pending[client.workspaceID] = seen
+	}
+	seen[client.UserID] = now
+	h.lastSeen.mu.Unlock()
+}
+
+// flushLastSeen writes the sightings since the last flush
+func (h *WebSocketHub) flushLastSeen(ctx context.Context) {
+	h.lastSeen.mu.Lock()
+	pending := h.lastSeen.pending
+	h.lastSeen.pending = nil
+	h.lastSeen.mu.Unlock()
+
+	for workspaceID, seen := range pending {
+		if err := h.userService.RecordLastSeen(tenant.NewContext(ctx, workspaceID), seen); err != nil {
+			h.logger.Error("Failed to record last seen times", "users", len(seen), logging.Err(err))
+		}
+	}
+}
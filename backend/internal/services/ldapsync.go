@@ -0,0 +1,636 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/ldap"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The LDAP sync makes one workspace's users and group conversations follow a directory such as
+// Active Directory. Users are matched by the attribute that holds their token subject; each
+// group matching the group filter becomes a group conversation whose members are the group's
+// members. Users who leave the directory, or are disabled in it, are deactivated as a SCIM
+// deprovisioning would; groups that leave it are unlinked and kept.
+
+const (
+	ldapSyncCollection = "ldap_syncs"
+	// ldapActor is the audit actor for changes the sync makes
+	ldapActor = "ldap"
+	// ldapTimeout bounds reading the directory
+	ldapTimeout = 2 * time.Minute
+
+	// adAccountDisabled is ACCOUNTDISABLE in Active Directory's userAccountControl
+	adAccountDisabled = 0x2
+)
+
+type LDAPConfig struct {
+	URL              string // ldap:// or ldaps://; empty disables the sync
+	BindDN           string
+	BindPassword     string
+	BaseDN           string
+	UserFilter       string
+	GroupFilter      string
+	IDAttribute      string // holds each user's ID, the subject of their tokens
+	GroupIDAttribute string // stays the same when a group is renamed or moved, like objectGUID
+	Workspace        string
+}
+
+// LDAPSyncService syncs a workspace's users and groups from an LDAP directory
+type LDAPSyncService struct {
+	provisioner
+	userService *UserService
+	config      LDAPConfig
+	running     sync.Mutex
+}
+
+func NewLDAPSyncService(db *database.MongoDB, userService *UserService, conversationService *ConversationService, auditService *AuditService, hub *WebSocketHub, clk clock.Clock, logger *slog.Logger, ids IDGenerator, config LDAPConfig) *LDAPSyncService {
+	return &LDAPSyncService{
+		provisioner: provisioner{
+			db:                  db,
+			conversationService: conversationService,
+			auditService:        auditService,
+			hub:                 hub,
+			clock:               clk,
+			logger:              logger,
+			ids:                 ids,
+		},
+		userService: userService,
+		config:      config,
+	}
+}
+
+// Enabled reports whether a directory is configured
+func (s *LDAPSyncService) Enabled() bool {
+	return s.config.URL != ""
+}
+
+// directoryUser is a user entry the sync will apply
+type directoryUser struct {
+	dn       string
+	id       string
+	email    string
+	name     string
+	disabled bool
+}
+
+// directoryGroup is a group entry the sync will apply, with its members resolved to user IDs
+type directoryGroup struct {
+	dn         string
+	externalID string
+	title      string
+	memberIDs  []string
+}
+
+// Sync brings the workspace in line with the directory now. A dry run reads everything and
+// reports what it would change, changing nothing.
+func (s *LDAPSyncService) Sync(ctx context.Context, dryRun bool) (*models.LDAPSyncReport, error) {
+	if !s.Enabled() {
+		return nil, notFoundError("LDAP sync is not configured")
+	}
+	if !s.running.TryLock() {
+		return nil, conflictError("an LDAP sync is already running")
+	}
+	defer s.running.Unlock()
+
+	ctx = tenant.NewContext(ctx, s.config.Workspace)
+	report := &models.LDAPSyncReport{
+		DryRun:           dryRun,
+		StartedAt:        s.clock.Now(),
+		UsersCreated:     []string{},
+		UsersUpdated:     []string{},
+		UsersDeactivated: []string{},
+		UsersReactivated: []string{},
+		Groups:           []models.LDAPGroupChange{},
+		Conflicts:        []models.LDAPSyncConflict{},
+	}
+
+	users, groups, err := s.read(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	synced, err := s.syncUsers(ctx, users, report)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncGroups(ctx, groups, synced, report); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = s.clock.Now()
+
+	if !dryRun {
+		s.saveReport(ctx, report)
+	}
+	return report, nil
+}
+
+// LastReport returns the last sync's report that made changes, dry runs excepted
+func (s *LDAPSyncService) LastReport(ctx context.Context) (*models.LDAPSyncReport, error) {
+	var state models.LDAPSyncState
+	err := s.db.Collection(ctx, ldapSyncCollection).FindOne(ctx, bson.M{"_id": s.config.Workspace}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find LDAP sync state: %w", err)
+	}
+	if state.LastReport == nil {
+		return nil, notFoundError("no LDAP sync has run")
+	}
+	return state.LastReport, nil
+}
+
+// RunSync syncs on the given interval until ctx is cancelled. Every node runs it; each
+// interval the node that claims the ldap_syncs document syncs, and the others skip.
+func (s *LDAPSyncService) RunSync(ctx context.Context, interval time.Duration, dryRun bool) {
+	if !s.Enabled() || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			claimed, err := s.claim(ctx, interval)
+			if err != nil {
+				s.logger.Error("Failed to claim LDAP sync", logging.Err(err))
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			report, err := s.Sync(ctx, dryRun)
+			if err != nil {
+				s.logger.Error("LDAP sync failed", logging.Err(err))
+				s.saveReport(ctx, &models.LDAPSyncReport{DryRun: dryRun, StartedAt: s.clock.Now(), FinishedAt: s.clock.Now(), Error: PublicMessage(err, err.Error())})
+				continue
+			}
+			s.logger.Info("LDAP sync finished",
+				"dry_run", dryRun,
+				"users_created", len(report.UsersCreated),
+				"users_updated", len(report.UsersUpdated),
+				"users_deactivated", len(report.UsersDeactivated),
+				"users_reactivated", len(report.UsersReactivated),
+				"groups_changed", len(report.Groups),
+				"conflicts", len(report.Conflicts),
+			)
+			for _, conflict := range report.Conflicts {
+				s.logger.Warn("LDAP sync conflict", "dn", conflict.DN, logging.UserID, conflict.UserID, "reason", conflict.Reason)
+			}
+		}
+	}
+}
+
+// claim takes this interval's sync for this node; false when another node already has
+func (s *LDAPSyncService) claim(ctx context.Context, interval time.Duration) (bool, error) {
+	now := s.clock.Now()
+	_, err := s.db.Collection(ctx, ldapSyncCollection).UpdateOne(ctx,
+		bson.M{"_id": s.config.Workspace, "$or": bson.A{
+			bson.M{"nextRunAt": bson.M{"$exists": false}},
+			bson.M{"nextRunAt": bson.M{"$lte": now}},
+		}},
+		// Half an interval's slack keeps nodes whose tickers drift from both syncing
+		bson.M{"$set": bson.M{"nextRunAt": now.Add(interval / 2)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LDAPSyncService) saveReport(ctx context.Context, report *models.LDAPSyncReport) {
+	_, err := s.db.Collection(ctx, ldapSyncCollection).UpdateOne(ctx, bson.M{"_id": s.config.Workspace},
+		bson.M{"$set": bson.M{"lastReport": report}}, options.Update().SetUpsert(true))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to save LDAP sync report", logging.Err(err))
+	}
+}
+
+// read fetches the directory's users and groups. Entries that cannot be applied are reported
+// as conflicts and left out.
+func (s *LDAPSyncService) read(ctx context.Context, report *models.LDAPSyncReport) ([]directoryUser, []directoryGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+	defer cancel()
+
+	conn, err := ldap.Dial(ctx, s.config.URL)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to connect to LDAP", logging.Err(err))
+		return nil, nil, upstreamError("could not connect to the directory")
+	}
+	defer conn.Close()
+	if s.config.BindDN != "" {
+		if err := conn.Bind(ctx, s.config.BindDN, s.config.BindPassword); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to bind to LDAP", logging.Err(err))
+			return nil, nil, upstreamError("could not sign in to the directory")
+		}
+	}
+
+	userEntries, err := conn.Search(ctx, s.config.BaseDN, s.config.UserFilter,
+		[]string{s.config.IDAttribute, "mail", "displayName", "cn", "userAccountControl"})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to search LDAP users", logging.Err(err))
+		return nil, nil, upstreamError("could not read users from the directory")
+	}
+	groupEntries, err := conn.Search(ctx, s.config.BaseDN, s.config.GroupFilter,
+		[]string{s.config.GroupIDAttribute, "cn", "member"})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to search LDAP groups", logging.Err(err))
+		return nil, nil, upstreamError("could not read groups from the directory")
+	}
+
+	users := make([]directoryUser, 0, len(userEntries))
+	byDN := make(map[string]string, len(userEntries))
+	seen := make(map[string]string, len(userEntries))
+	for _, entry := range userEntries {
+		user := directoryUser{
+			dn:    entry.DN,
+			id:    entry.Get(s.config.IDAttribute),
+			email: strings.ToLower(entry.Get("mail")),
+			name:  entry.Get("displayName"),
+		}
+		if user.name == "" {
+			user.name = entry.Get("cn")
+		}
+		if flags, err := strconv.ParseInt(entry.Get("userAccountControl"), 10, 64); err == nil {
+			user.disabled = flags&adAccountDisabled != 0
+		}
+
+		switch {
+		case user.id == "":
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.DN, Reason: "no " + s.config.IDAttribute})
+			continue
+		case user.email == "":
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.DN, UserID: user.id, Reason: "no mail"})
+			continue
+		case seen[user.id] != "":
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.DN, UserID: user.id, Reason: "same " + s.config.IDAttribute + " as " + seen[user.id]})
+			continue
+		}
+		seen[user.id] = entry.DN
+		byDN[strings.ToLower(entry.DN)] = user.id
+		users = append(users, user)
+	}
+
+	groups := make([]directoryGroup, 0, len(groupEntries))
+	for _, entry := range groupEntries {
+		group := directoryGroup{
+			dn:         entry.DN,
+			externalID: directoryID(entry.Get(s.config.GroupIDAttribute)),
+			title:      entry.Get("cn"),
+		}
+		if group.externalID == "" {
+			group.externalID = entry.DN
+		}
+		if group.title == "" {
+			group.title = entry.DN
+		}
+		for _, memberDN := range entry.Values("member") {
+			userID, ok := byDN[strings.ToLower(memberDN)]
+			if !ok {
+				report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.DN,
+					Reason: "member " + memberDN + " is not a synced user; nested groups are not expanded"})
+				continue
+			}
+			group.memberIDs = append(group.memberIDs, userID)
+		}
+		groups = append(groups, group)
+	}
+	return users, groups, nil
+}
+
+// syncUsers creates, updates, deactivates and reactivates users to match the directory. It
+// returns the users it manages, leaving out those it reported as conflicts.
+func (s *LDAPSyncService) syncUsers(ctx context.Context, users []directoryUser, report *models.LDAPSyncReport) (map[string]bool, error) {
+	workspaceID := s.config.Workspace
+	ids := make([]string, len(users))
+	emails := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.id
+		emails[i] = user.email
+	}
+
+	existing := map[string]*models.User{}
+	if err := s.findUsers(ctx, bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"email": bson.M{"$in": emails}},
+		bson.M{"workspaceId": workspaceID, "provisioning.source": models.ProvisionedByLDAP},
+	}}, existing); err != nil {
+		return nil, err
+	}
+	emailOwners := make(map[string]string, len(existing))
+	for _, user := range existing {
+		emailOwners[strings.ToLower(user.Email)] = user.ID
+	}
+
+	var workspace models.Workspace
+	err := s.db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find workspace: %w", err)
+	}
+
+	inDirectory := make(map[string]bool, len(users))
+	for _, entry := range users {
+		user := existing[entry.id]
+		if owner := emailOwners[entry.email]; owner != "" && owner != entry.id {
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.dn, UserID: entry.id, Reason: "mail is already used by user " + owner})
+			continue
+		}
+		if user != nil && user.WorkspaceID != workspaceID {
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.dn, UserID: entry.id, Reason: "the user belongs to another workspace"})
+			continue
+		}
+		if user != nil && user.Provisioning != nil && user.Provisioning.Source != models.ProvisionedByLDAP {
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.dn, UserID: entry.id, Reason: "the user is managed over SCIM"})
+			continue
+		}
+		inDirectory[entry.id] = true
+
+		if user == nil {
+			report.UsersCreated = append(report.UsersCreated, entry.id)
+			if entry.disabled {
+				report.UsersDeactivated = append(report.UsersDeactivated, entry.id)
+			}
+			if report.DryRun {
+				continue
+			}
+			if err := s.createUser(ctx, &entry, workspace.DefaultConversationIDs); err != nil {
+				if !mongo.IsDuplicateKeyError(err) {
+					return nil, err
+				}
+				delete(inDirectory, entry.id)
+				report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: entry.dn, UserID: entry.id, Reason: "the user or their mail was created at the same time; retried next sync"})
+			}
+			continue
+		}
+
+		provisioning := &models.Provisioning{Source: models.ProvisionedByLDAP, ExternalID: entry.dn}
+		changed := user.Email != entry.email || user.Name != entry.name || user.Provisioning == nil || *user.Provisioning != *provisioning
+		deactivate := entry.disabled && user.DeactivatedAt == nil
+		reactivate := !entry.disabled && user.DeactivatedAt != nil
+		if changed {
+			report.UsersUpdated = append(report.UsersUpdated, entry.id)
+		}
+		if !report.DryRun && changed {
+			_, err := s.db.Collection(ctx, "users").UpdateOne(ctx, bson.M{"_id": entry.id},
+				bson.M{"$set": bson.M{"email": entry.email, "name": entry.name, "provisioning": provisioning}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to update user: %w", err)
+			}
+			s.userService.invalidate(ctx, entry.id)
+		}
+		if deactivate || reactivate {
+			if err := s.setActive(ctx, entry.id, reactivate, report); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Users the sync created who are gone from the directory, or were filtered out of it
+	for _, user := range existing {
+		managed := user.WorkspaceID == workspaceID && user.Provisioning != nil && user.Provisioning.Source == models.ProvisionedByLDAP
+		if managed && !inDirectory[user.ID] && user.DeactivatedAt == nil {
+			if err := s.setActive(ctx, user.ID, false, report); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return inDirectory, nil
+}
+
+func (s *LDAPSyncService) findUsers(ctx context.Context, filter bson.M, into map[string]*models.User) error {
+	cursor, err := s.db.Collection(ctx, "users").Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to find users: %w", err)
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return fmt.Errorf("failed to decode users: %w", err)
+	}
+	for i := range users {
+		into[users[i].ID] = &users[i]
+	}
+	return nil
+}
+
+func (s *LDAPSyncService) createUser(ctx context.Context, entry *directoryUser, defaultConversationIDs []string) error {
+	now := s.clock.Now()
+	user := &models.User{
+		ID:           entry.id,
+		WorkspaceID:  s.config.Workspace,
+		Email:        entry.email,
+		Name:         entry.name,
+		Provisioning: &models.Provisioning{Source: models.ProvisionedByLDAP, ExternalID: entry.dn},
+		CreatedAt:    now,
+	}
+	if entry.disabled {
+		user.DeactivatedAt = &now
+	}
+	if _, err := s.db.Collection(ctx, "users").InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if entry.disabled {
+		s.activationChanged(ctx, user.ID, true, ldapActor)
+		return nil
+	}
+	return s.conversationService.JoinDefaults(ctx, user, defaultConversationIDs)
+}
+
+// setActive deactivates or reactivates a user, or in a dry run only reports it
+func (s *LDAPSyncService) setActive(ctx context.Context, userID string, active bool, report *models.LDAPSyncReport) error {
+	if active {
+		report.UsersReactivated = append(report.UsersReactivated, userID)
+	} else {
+		report.UsersDeactivated = append(report.UsersDeactivated, userID)
+	}
+	if report.DryRun {
+		return nil
+	}
+
+	update := bson.M{"$set": bson.M{"deactivatedAt": s.clock.Now()}}
+	if active {
+		update = bson.M{"$unset": bson.M{"deactivatedAt": ""}}
+	}
+	if _, err := s.db.Collection(ctx, "users").UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	s.userService.invalidate(ctx, userID)
+	s.activationChanged(ctx, userID, !active, ldapActor)
+	return nil
+}
+
+// syncGroups creates, renames and re-members group conversations to match the directory's
+// groups, and unlinks those whose group is gone. Only synced users become members.
+func (s *LDAPSyncService) syncGroups(ctx context.Context, groups []directoryGroup, synced map[string]bool, report *models.LDAPSyncReport) error {
+	workspaceID := s.config.Workspace
+	cursor, err := s.db.Collection(ctx, "conversations").Find(ctx, bson.M{
+		"workspaceId":         workspaceID,
+		"provisioning.source": models.ProvisionedByLDAP,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find synced groups: %w", err)
+	}
+	var conversations []models.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return fmt.Errorf("failed to decode synced groups: %w", err)
+	}
+	linked := make(map[string]*models.Conversation, len(conversations))
+	for i := range conversations {
+		linked[conversations[i].Provisioning.ExternalID] = &conversations[i]
+	}
+
+	inDirectory := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		if inDirectory[group.externalID] {
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: group.dn, Reason: "same " + s.config.GroupIDAttribute + " as another group"})
+			continue
+		}
+		inDirectory[group.externalID] = true
+
+		memberIDs := make([]string, 0, len(group.memberIDs))
+		for _, userID := range group.memberIDs {
+			if synced[userID] {
+				memberIDs = append(memberIDs, userID)
+			}
+		}
+		memberIDs = uniqueStrings(memberIDs)
+		if err := checkGroupSize(ctx, s.db, workspaceID, len(memberIDs)); err != nil {
+			if !errors.Is(err, ErrQuota) {
+				return err
+			}
+			report.Conflicts = append(report.Conflicts, models.LDAPSyncConflict{DN: group.dn, Reason: PublicMessage(err, "")})
+			continue
+		}
+
+		conversation := linked[group.externalID]
+		if conversation == nil {
+			change := models.LDAPGroupChange{DN: group.dn, Title: group.title, Created: true, Added: memberIDs}
+			if !report.DryRun {
+				provisioning := &models.Provisioning{Source: models.ProvisionedByLDAP, ExternalID: group.externalID}
+				created, err := s.createGroup(ctx, workspaceID, group.title, provisioning, memberIDs, ldapActor)
+				if err != nil {
+					return err
+				}
+				change.ConversationID = created.ID
+			}
+			report.Groups = append(report.Groups, change)
+			continue
+		}
+
+		current, err := s.conversationService.participantUserIDs(ctx, conversation.ID)
+		if err != nil {
+			return err
+		}
+		added, removed := diffMembers(current, memberIDs)
+		if conversation.Title == group.title && len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		report.Groups = append(report.Groups, models.LDAPGroupChange{
+			DN:             group.dn,
+			ConversationID: conversation.ID,
+			Title:          group.title,
+			Added:          added,
+			Removed:        removed,
+		})
+		if report.DryRun {
+			continue
+		}
+		if err := s.rename(ctx, conversation, group.title, group.externalID, ldapActor); err != nil {
+			return err
+		}
+		if err := s.setMembers(ctx, conversation, memberIDs, ldapActor); err != nil {
+			return err
+		}
+	}
+
+	for externalID, conversation := range linked {
+		if inDirectory[externalID] {
+			continue
+		}
+		report.Groups = append(report.Groups, models.LDAPGroupChange{
+			DN:             externalID,
+			ConversationID: conversation.ID,
+			Title:          conversation.Title,
+			Unlinked:       true,
+		})
+		if report.DryRun {
+			continue
+		}
+		_, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversation.ID},
+			bson.M{"$unset": bson.M{"provisioning": ""}})
+		if err != nil {
+			return fmt.Errorf("failed to unlink group: %w", err)
+		}
+	}
+	return nil
+}
+
+// diffMembers returns who is in wanted but not current, and who is in current but not wanted
+func diffMembers(current, wanted []string) ([]string, []string) {
+	inCurrent := make(map[string]bool, len(current))
+	for _, userID := range current {
+		inCurrent[userID] = true
+	}
+	inWanted := make(map[string]bool, len(wanted))
+	var added []string
+	for _, userID := range wanted {
+		inWanted[userID] = true
+		if !inCurrent[userID] {
+			added = append(added, userID)
+		}
+	}
+	var removed []string
+	for _, userID := range current {
+		if !inWanted[userID] {
+			removed = append(removed, userID)
+		}
+	}
+	return added, removed
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// directoryID renders an ID attribute as text; binary ones such as objectGUID become hex
+func directoryID(value string) string {
+	if !utf8.ValidString(value) {
+		return hex.EncodeToString([]byte(value))
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return hex.EncodeToString([]byte(value))
+		}
+	}
+	return value
+}
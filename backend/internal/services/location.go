@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Location messages carry a point. A live location also starts there, and until its LiveUntil
+// the sender's client moves it with location.update frames. Those go out on
+// chat.conv.<id>.location like typing indicators: never stored, and dropped by clients once
+// they expire, so the message always shows where the sharing started.
+
+const (
+	maxLocationLabel = 200
+	minLiveLocation  = time.Minute
+	maxLiveLocation  = 8 * time.Hour
+)
+
+// newLocation checks a location request and returns the location to store
+func newLocation(req *models.LocationRequest, now time.Time) (*models.Location, error) {
+	if req == nil {
+		return nil, validationError("location is required for location messages")
+	}
+	if err := checkCoordinates(req.Latitude, req.Longitude); err != nil {
+		return nil, err
+	}
+	label := strings.TrimSpace(req.Label)
+	if len([]rune(label)) > maxLocationLabel {
+		return nil, validationError(fmt.Sprintf("location labels are limited to %d characters", maxLocationLabel))
+	}
+
+	location := &models.Location{
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Label:     label,
+	}
+	if req.LiveSeconds != 0 {
+		live := time.Duration(req.LiveSeconds) * time.Second
+		if live < minLiveLocation || live > maxLiveLocation {
+			return nil, validationError(fmt.Sprintf("liveSeconds must be %d to %d", int(minLiveLocation.Seconds()), int(maxLiveLocation.Seconds())))
+		}
+		until := now.Add(live)
+		location.LiveUntil = &until
+	}
+	return location, nil
+}
+
+func checkCoordinates(latitude, longitude float64) error {
+	if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return validationError("latitude must be between -90 and 90")
+	}
+	if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return validationError("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// UpdateLiveLocation moves the sender's live location, or with Stop ends it, and announces it
+// to the conversation
+func (s *MessageService) UpdateLiveLocation(ctx context.Context, messageID int64, userID string, req *models.LocationUpdateRequest) (*models.WSLocationEventData, error) {
+	if !req.Stop {
+		if err := checkCoordinates(req.Latitude, req.Longitude); err != nil {
+			return nil, err
+		}
+	}
+	messages := s.db.Collection(ctx, "messages")
+	now := s.clock.Now()
+
+	var message models.Message
+	err := messages.FindOne(ctx,
+		bson.M{"_id": messageID, "conversationId": req.ConversationID, "retractedAt": bson.M{"$exists": false}},
+		options.FindOne().SetProjection(bson.M{"senderId": 1, "type": 1, "payload": 1}),
+	).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("message not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message: %w", err)
+	}
+	var location models.Location
+	if err := decodePayload(&message, models.MessageTypeLocation, &location); err != nil {
+		return nil, err
+	}
+	if location.LiveUntil == nil {
+		return nil, validationError("message is not a live location")
+	}
+	if message.SenderID != userID {
+		return nil, forbiddenError("only the sender can move a live location")
+	}
+	if !location.LiveUntil.After(now) {
+		return nil, conflictError("the live location has ended")
+	}
+
+	event := &models.WSLocationEventData{
+		ConversationID: req.ConversationID,
+		MessageID:      messageID,
+		UserID:         userID,
+		ExpiresAt:      *location.LiveUntil,
+	}
+	if req.Stop {
+		// Ending early is stored, so clients loading the message later do not show it live
+		result, err := messages.UpdateOne(ctx,
+			bson.M{"_id": messageID, "payload.liveUntil": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"payload.liveUntil": now}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to stop live location: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return nil, conflictError("the live location has ended")
+		}
+		event.ExpiresAt = now
+		event.Stopped = true
+	} else {
+		event.Latitude, event.Longitude = &req.Latitude, &req.Longitude
+	}
+
+	if err := s.nats.PublishLocationUpdate(req.ConversationID, event); err != nil {
+		return nil, fmt.Errorf("failed to publish location update: %w", err)
+	}
+	return event, nil
+}
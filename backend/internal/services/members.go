@@ -0,0 +1,54 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+)
+
+const (
+	defaultMembersPageSize = 50
+	maxMembersPageSize     = 200
+)
+
+// handleMembersPage serves one page of a conversation's member list over the socket
+func (c *Client) handleMembersPage(frame *models.WSFrame) {
+	var data models.WSMembersPageRequestData
+	dataBytes, err := json.Marshal(frame.Data)
+	if err != nil {
+		c.sendError("INVALID_DATA", "Invalid members page data format")
+		return
+	}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		c.sendError("INVALID_DATA", "Invalid members page data")
+		return
+	}
+
+	limit := data.Limit
+	if limit <= 0 || limit > maxMembersPageSize {
+		limit = defaultMembersPageSize
+	}
+
+	ctx := c.context()
+	if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessRead); err != nil {
+		c.sendError(ErrorCode(err, "MEMBERS_FAILED"), PublicMessage(err, "Failed to list members"))
+		return
+	}
+	if _, err := c.Hub.watchService.AuthorizeRead(ctx, data.ConversationID, c.UserID, "members"); err != nil {
+		c.sendError(ErrorCode(err, "MEMBERS_FAILED"), PublicMessage(err, "Failed to list members"))
+		return
+	}
+
+	members, nextCursor, err := c.Hub.conversationService.GetMembersPage(ctx, data.ConversationID, data.Cursor, limit)
+	if err != nil {
+		c.sendError(ErrorCode(err, "MEMBERS_FAILED"), PublicMessage(err, "Failed to list members"))
+		return
+	}
+
+	c.sendFrame("members.page", &models.WSMembersPageData{
+		RequestID:      data.RequestID,
+		ConversationID: data.ConversationID,
+		Members:        members,
+		NextCursor:     nextCursor,
+	})
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxMentions bounds the handles looked up for one message; later ones are not mentions
+const maxMentions = 20
+
+// mentionPattern finds @handles not preceded by a handle character, so e-mail addresses are
+// not mentions. The handle follows usernamePattern.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@])@([A-Za-z0-9_][A-Za-z0-9_.]{1,28}[A-Za-z0-9_])`)
+
+// parseMentions returns the distinct handles mentioned in body, lowercased, in order
+func parseMentions(body string) []string {
+	var handles []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(match[1])
+		if seen[handle] || reservedUsernames[handle] {
+			continue
+		}
+		seen[handle] = true
+		handles = append(handles, handle)
+		if len(handles) == maxMentions {
+			break
+		}
+	}
+	return handles
+}
+
+// resolveMentions returns the IDs of the conversation's participants mentioned in body.
+// Handles of unknown users or of people outside the conversation are plain text.
+func (s *MessageService) resolveMentions(ctx context.Context, conversationID, body string) ([]string, error) {
+	handles := parseMentions(body)
+	if len(handles) == 0 {
+		return nil, nil
+	}
+
+	userIDs := make([]string, 0, len(handles))
+	participantIDs := make([]string, 0, len(handles))
+	for _, handle := range handles {
+		user, err := s.userService.GetUserByUsername(ctx, handle)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, user.ID)
+		participantIDs = append(participantIDs, id.Participant(conversationID, user.ID))
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := s.db.Collection(ctx, "participants").Find(ctx, bson.M{"_id": bson.M{"$in": participantIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mentioned participants: %w", err)
+	}
+	var participants []models.Participant
+	if err := cursor.All(ctx, &participants); err != nil {
+		return nil, fmt.Errorf("failed to decode mentioned participants: %w", err)
+	}
+	inConversation := make(map[string]bool, len(participants))
+	for _, p := range participants {
+		inConversation[p.UserID] = true
+	}
+
+	var mentions []string
+	for _, userID := range userIDs {
+		if inConversation[userID] {
+			mentions = append(mentions, userID)
+		}
+	}
+	return mentions, nil
+}
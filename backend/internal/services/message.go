@@ -2,36 +2,72 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/markdown"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MessageService struct {
-	db          *database.MongoDB
-	nats        *nats.NATSConnection
-	userService *UserService
+	db              *database.MongoDB
+	nats            *nats.NATSConnection
+	userService     *UserService
+	settingsService *SettingsService
+	emojiService    *EmojiService
+	gifService      *GIFService
+	usageService    *UsageService
+	clock           clock.Clock
+	logger          *slog.Logger
+	ids             IDGenerator
+	// undoWindow is how long after sending a message its sender may retract it; zero disables undo
+	undoWindow time.Duration
 }
 
-func NewMessageService(db *database.MongoDB, natsConn *nats.NATSConnection, userService *UserService) *MessageService {
+func NewMessageService(db *database.MongoDB, natsConn *nats.NATSConnection, userService *UserService, settingsService *SettingsService, emojiService *EmojiService, gifService *GIFService, usageService *UsageService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, undoWindow time.Duration) *MessageService {
 	return &MessageService{
-		db:          db,
-		nats:        natsConn,
-		userService: userService,
+		db:              db,
+		nats:            natsConn,
+		userService:     userService,
+		settingsService: settingsService,
+		emojiService:    emojiService,
+		gifService:      gifService,
+		usageService:    usageService,
+		clock:           clk,
+		logger:          logger,
+		ids:             ids,
+		undoWindow:      undoWindow,
 	}
 }
 
 func (s *MessageService) SendMessage(ctx context.Context, req *models.SendMessageRequest, senderID string) (*models.MessageWithSender, error) {
-	collection := s.db.DB.Collection("messages")
+	collection := s.db.Collection(ctx, "messages")
 
-	// Generate snowflake ID (simplified version)
-	messageID := generateSnowflakeID()
+	workspaceID, participant, err := s.checkSender(ctx, req.ConversationID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSlowMode(ctx, participant, req.ConversationID, senderID, req.ClientMsgID); err != nil {
+		return nil, err
+	}
+	if err := s.usageService.CheckSend(ctx, workspaceID); err != nil {
+		return nil, err
+	}
+
+	messageID := s.ids.NewMessageID()
 
 	message := &models.Message{
 		ID:             messageID,
@@ -39,11 +75,43 @@ func (s *MessageService) SendMessage(ctx context.Context, req *models.SendMessag
 		SenderID:       senderID,
 		ClientMsgID:    req.ClientMsgID,
 		Body:           req.Body,
-		CreatedAt:      time.Now(),
+		CreatedAt:      s.clock.Now(),
+		UnfurlURL:      firstLink(req.Body),
+	}
+	if req.Format == models.MessageFormatMarkdown {
+		message.Format = models.MessageFormatMarkdown
+		message.HTML = markdown.Render(req.Body)
+	}
+	if err := s.buildPayload(ctx, req, message); err != nil {
+		return nil, err
+	}
+	emoji, err := s.emojiService.ResolveShortcodes(ctx, req.ConversationID, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	message.Emoji = emoji
+	mentions, err := s.resolveMentions(ctx, req.ConversationID, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	message.Mentions = mentions
+	if s.undoWindow > 0 {
+		until := message.CreatedAt.Add(s.undoWindow)
+		message.RetractableUntil = &until
+	}
+
+	// Fetch sender information up front; it is part of the published event
+	var sender *models.User
+	if user, err := s.userService.GetUserProfile(ctx, senderID); err == nil {
+		sender = user
 	}
 
-	// Insert message with idempotency check
-	_, err := collection.InsertOne(ctx, message)
+	var entry *models.OutboxEntry
+	err = withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		var err error
+		entry, err = s.insertMessage(txCtx, message, sender)
+		return err
+	})
 	if err != nil {
 		// Check if it's a duplicate key error (idempotency)
 		if mongo.IsDuplicateKeyError(err) {
@@ -58,20 +126,30 @@ func (s *MessageService) SendMessage(ctx context.Context, req *models.SendMessag
 			if err != nil {
 				return nil, fmt.Errorf("failed to find existing message: %w", err)
 			}
+			payload, err := payloadJSON(&existingMessage)
+			if err != nil {
+				return nil, err
+			}
 
 			// Convert to MessageWithSender and populate sender info
 			messageWithSender := &models.MessageWithSender{
 				ID:             existingMessage.ID,
+				Seq:            existingMessage.Seq,
 				ConversationID: existingMessage.ConversationID,
 				SenderID:       existingMessage.SenderID,
 				ClientMsgID:    existingMessage.ClientMsgID,
 				Body:           existingMessage.Body,
+				Format:         existingMessage.Format,
+				HTML:           existingMessage.HTML,
 				CreatedAt:      existingMessage.CreatedAt,
-			}
-
-			// Fetch sender information
-			if sender, err := s.userService.GetUserByID(ctx, existingMessage.SenderID); err == nil {
-				messageWithSender.Sender = sender
+				Sender:         sender,
+
+				RetractableUntil: existingMessage.RetractableUntil,
+				Preview:          existingMessage.Preview,
+				Emoji:            existingMessage.Emoji,
+				Mentions:         existingMessage.Mentions,
+				Type:             existingMessage.Type,
+				Payload:          payload,
 			}
 
 			return messageWithSender, nil
@@ -79,57 +157,376 @@ func (s *MessageService) SendMessage(ctx context.Context, req *models.SendMessag
 		return nil, fmt.Errorf("failed to insert message: %w", err)
 	}
 
+	// Publish right away; if this fails (or the process dies first) the outbox relay retries
+	s.publishEntry(ctx, entry)
+	s.usageService.RecordMessage(ctx, workspaceID)
+
+	payload, err := payloadJSON(message)
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert to MessageWithSender and populate sender info
 	messageWithSender := &models.MessageWithSender{
 		ID:             message.ID,
+		Seq:            message.Seq,
 		ConversationID: message.ConversationID,
 		SenderID:       message.SenderID,
 		ClientMsgID:    message.ClientMsgID,
 		Body:           message.Body,
+		Format:         message.Format,
+		HTML:           message.HTML,
 		CreatedAt:      message.CreatedAt,
+		Sender:         sender,
+
+		RetractableUntil: message.RetractableUntil,
+		Emoji:            message.Emoji,
+		Mentions:         message.Mentions,
+		Type:             message.Type,
+		Payload:          payload,
 	}
 
-	// Fetch sender information
-	if sender, err := s.userService.GetUserByID(ctx, message.SenderID); err == nil {
-		messageWithSender.Sender = sender
+	return messageWithSender, nil
+}
+
+// insertMessage numbers and stores a new message with its message.created outbox entry, in the
+// caller's transaction. Writing all three together means a message is never stored without a
+// pending publish, and idempotent retries leave no hole in the sequence. The caller publishes
+// the entry once the transaction commits.
+func (s *MessageService) insertMessage(txCtx mongo.SessionContext, message *models.Message, sender *models.User) (*models.OutboxEntry, error) {
+	seq, err := s.nextSeq(txCtx, message.ConversationID)
+	if err != nil {
+		return nil, err
 	}
+	message.Seq = seq
 
-	// Publish to NATS JetStream
-	wsMessageData := &models.WSMessageNewData{
-		ID:             message.ID,
-		ConversationID: message.ConversationID,
-		SenderID:       message.SenderID,
-		Body:           message.Body,
-		CreatedAt:      message.CreatedAt,
-		Sender:         messageWithSender.Sender,
+	if _, err := s.db.Collection(txCtx, "messages").InsertOne(txCtx, message); err != nil {
+		return nil, err
 	}
 
-	err = s.nats.PublishMessage(req.ConversationID, wsMessageData)
+	entry, err := s.outboxEntry(message, sender)
 	if err != nil {
-		// Log error but don't fail the request - message is already persisted
-		fmt.Printf("Failed to publish message to NATS: %v\n", err)
+		return nil, err
 	}
+	entry.RequestID = requestid.FromContext(txCtx)
+	if _, err := s.db.Collection(txCtx, outboxCollection).InsertOne(txCtx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return entry, nil
+}
 
-	return messageWithSender, nil
+// RetractMessage withdraws one of the sender's own messages while its undo window is open.
+// The message is kept but hidden from history, and a message.retracted event tells live
+// clients to drop it. If the message.created event has not gone out yet it never will.
+func (s *MessageService) RetractMessage(ctx context.Context, conversationID string, messageID int64, userID string) error {
+	collection := s.db.Collection(ctx, "messages")
+	now := s.clock.Now()
+
+	var entry *models.OutboxEntry
+	err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		var message models.Message
+		err := collection.FindOneAndUpdate(txCtx,
+			bson.M{
+				"_id":              messageID,
+				"conversationId":   conversationID,
+				"senderId":         userID,
+				"retractedAt":      bson.M{"$exists": false},
+				"retractableUntil": bson.M{"$gt": now},
+			},
+			bson.M{"$set": bson.M{"retractedAt": now}, "$unset": bson.M{"unfurlUrl": "", "unfurlLeaseUntil": ""}},
+		).Decode(&message)
+		if err == mongo.ErrNoDocuments {
+			return s.retractRefusal(txCtx, conversationID, messageID, userID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retract message: %w", err)
+		}
+
+		// An unpublished message.created is simply dropped
+		_, err = s.db.Collection(txCtx, outboxCollection).UpdateOne(txCtx,
+			bson.M{"_id": outboxEntryID(messageID, nats.EventMessageCreated), "sent": false},
+			bson.M{"$set": bson.M{"sent": true, "sentAt": now, "lastError": "retracted before publish"}})
+		if err != nil {
+			return fmt.Errorf("failed to cancel outbox entry: %w", err)
+		}
+
+		entry, err = s.retractionEntry(&message, now)
+		if err != nil {
+			return err
+		}
+		entry.RequestID = requestid.FromContext(ctx)
+		if _, err := s.db.Collection(txCtx, outboxCollection).InsertOne(txCtx, entry); err != nil {
+			return fmt.Errorf("failed to write outbox entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publishEntry(ctx, entry)
+	return nil
+}
+
+// retractRefusal explains why a retraction matched nothing
+func (s *MessageService) retractRefusal(ctx context.Context, conversationID string, messageID int64, userID string) error {
+	var message models.Message
+	err := s.db.Collection(ctx, "messages").FindOne(ctx, bson.M{
+		"_id":            messageID,
+		"conversationId": conversationID,
+	}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("message not found")
+		}
+		return fmt.Errorf("failed to find message: %w", err)
+	}
+
+	switch {
+	case message.RetractedAt != nil:
+		return notFoundError("message not found")
+	case message.SenderID != userID:
+		return forbiddenError("only the sender can retract a message")
+	default:
+		return conflictError("the undo window for this message has closed")
+	}
+}
+
+// checkSender refuses a message from a participant the conversation's posting policy leaves
+// out, who is muted, or while the conversation is locked. It returns the conversation's
+// workspace and the sender's participation, empty if they have none.
+func (s *MessageService) checkSender(ctx context.Context, conversationID, senderID string) (string, *models.Participant, error) {
+	var conversation models.Conversation
+	err := s.db.Collection(ctx, "conversations").FindOne(ctx, bson.M{"_id": conversationID},
+		options.FindOne().SetProjection(bson.M{"postingPolicy": 1, "workspaceId": 1, "lock": 1})).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return "", nil, notFoundError("conversation not found")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find conversation: %w", err)
+	}
+	conversation.FillPolicyDefaults()
+
+	var participant models.Participant
+	err = s.db.Collection(ctx, "participants").FindOne(ctx, bson.M{"_id": id.Participant(conversationID, senderID)}).Decode(&participant)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", nil, fmt.Errorf("failed to find participant: %w", err)
+	}
+	if conversation.PostingPolicy != models.PolicyEveryone && !permissions.CheckPolicy(&participant, conversation.PostingPolicy) {
+		if conversation.PostingPolicy == models.PolicyModerators {
+			return "", nil, forbiddenError("only moderators and admins can post in this conversation")
+		}
+		return "", nil, forbiddenError("only admins can post in this conversation")
+	}
+	if conversation.Lock != nil && !permissions.CheckPermission(&participant, nil, permissions.PostWhenLocked) {
+		return "", nil, lockedError("the conversation is locked")
+	}
+	if now := s.clock.Now(); participant.Muted(now) {
+		return "", nil, mutedError(participant.Mute.Until.Sub(now))
+	}
+	return conversation.WorkspaceID, &participant, nil
+}
+
+// checkSlowMode refuses a message sent sooner after the sender's previous one than the
+// conversation's slow mode allows, with the wait until the sender may post again.
+// Participants who may bypass slow mode are exempt. A retry of an already stored message
+// passes, so it can be answered idempotently.
+func (s *MessageService) checkSlowMode(ctx context.Context, participant *models.Participant, conversationID, senderID, clientMsgID string) error {
+	if permissions.CheckPermission(participant, nil, permissions.BypassSlowMode) {
+		return nil
+	}
+	settings, err := s.settingsService.Resolve(ctx, conversationID, "")
+	if err != nil {
+		return err
+	}
+	if settings.SlowModeSeconds <= 0 {
+		return nil
+	}
+
+	interval := time.Duration(settings.SlowModeSeconds) * time.Second
+	var previous models.Message
+	err = s.db.Collection(ctx, "messages").FindOne(ctx,
+		bson.M{
+			"conversationId": conversationID,
+			"senderId":       senderID,
+			"createdAt":      bson.M{"$gt": s.clock.Now().Add(-interval)},
+		},
+		options.FindOne().SetSort(bson.M{"createdAt": -1}),
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments || (err == nil && previous.ClientMsgID == clientMsgID) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check slow mode: %w", err)
+	}
+
+	wait := previous.CreatedAt.Add(interval).Sub(s.clock.Now()).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return retryLaterError(fmt.Sprintf("slow mode is on; wait %s before sending again", wait), wait)
+}
+
+// nextSeq atomically allocates the next per-conversation message sequence. JetStream has no
+// per-subject sequence and its stream sequences interleave all conversations, so the counter
+// lives on the conversation document.
+func (s *MessageService) nextSeq(ctx context.Context, conversationID string) (int64, error) {
+	var conversation models.Conversation
+	err := s.db.Collection(ctx, "conversations").FindOneAndUpdate(ctx,
+		bson.M{"_id": conversationID},
+		bson.M{"$inc": bson.M{"messageSeq": 1}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"messageSeq": 1}),
+	).Decode(&conversation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, notFoundError("conversation not found")
+		}
+		return 0, fmt.Errorf("failed to allocate message sequence: %w", err)
+	}
+
+	return conversation.MessageSeq, nil
+}
+
+// Replay is what ReplaySince read from the CHAT stream
+type Replay struct {
+	Messages []models.WSMessageNewData
+	// Retractions and updates of messages the caller may already hold
+	Retracted []models.WSMessageRetractedData
+	Updated   []models.WSMessageUpdatedData
+	More      bool // the replay stopped at its limit
 }
 
-func (s *MessageService) GetMessages(ctx context.Context, conversationID string, before string, limit int) (*models.PaginatedMessagesResponse, error) {
-	collection := s.db.DB.Collection("messages")
+// ReplaySince returns up to limit message events published after lastMessageID, read from the
+// CHAT stream: new messages, and retractions and updates of messages the caller may already
+// hold. A message both sent and retracted within the replay is left out, and updates to
+// messages sent within it are folded into them.
+func (s *MessageService) ReplaySince(ctx context.Context, conversationID string, lastMessageID int64, limit int) (*Replay, error) {
+	var last models.Message
+	err := s.db.Collection(ctx, "messages").FindOne(ctx, bson.M{
+		"_id":            lastMessageID,
+		"conversationId": conversationID,
+	}).Decode(&last)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("message not found")
+		}
+		return nil, fmt.Errorf("failed to find last message: %w", err)
+	}
+
+	// Prefer the exact stream position; messages published before it was recorded fall back to time
+	var startSeq uint64
+	if last.StreamSeq > 0 {
+		startSeq = last.StreamSeq + 1
+	}
+
+	replayed, more, err := s.nats.ReplayMessages(ctx, conversationID, startSeq, last.CreatedAt, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	replay := &Replay{Messages: make([]models.WSMessageNewData, 0, len(replayed)), More: more}
+	// Messages sent within the replay, by ID, as indexes into replay.Messages
+	replayedIDs := make(map[int64]int, len(replayed))
+	dropped := make(map[int64]bool)
+	for _, entry := range replayed {
+		switch entry.Event {
+		case nats.EventMessageRetracted:
+			var retraction models.WSMessageRetractedData
+			if err := json.Unmarshal(entry.Data, &retraction); err != nil {
+				return nil, fmt.Errorf("failed to decode replayed retraction: %w", err)
+			}
+			// Retractions of messages in this replay cancel out
+			if _, ok := replayedIDs[retraction.ID]; ok {
+				dropped[retraction.ID] = true
+				continue
+			}
+			replay.Retracted = append(replay.Retracted, retraction)
 
-	var filter bson.D
-	if before != "" {
-		// Parse before cursor (could be timestamp or message ID)
-		// For simplicity, assume it's a timestamp for now
-		if beforeTime, err := time.Parse(time.RFC3339, before); err == nil {
-			filter = bson.D{
-				{Key: "conversationId", Value: conversationID},
-				{Key: "createdAt", Value: bson.D{{Key: "$lt", Value: beforeTime}}},
+		case nats.EventMessageUpdated:
+			var update models.WSMessageUpdatedData
+			if err := json.Unmarshal(entry.Data, &update); err != nil {
+				return nil, fmt.Errorf("failed to decode replayed update: %w", err)
 			}
-		} else {
-			filter = bson.D{{Key: "conversationId", Value: conversationID}}
+			if i, ok := replayedIDs[update.ID]; ok {
+				if update.Preview != nil {
+					replay.Messages[i].Preview = update.Preview
+				}
+				if update.File != nil {
+					payload, err := json.Marshal(update.File)
+					if err != nil {
+						return nil, fmt.Errorf("failed to encode replayed file: %w", err)
+					}
+					replay.Messages[i].Payload = payload
+				}
+				continue
+			}
+			replay.Updated = append(replay.Updated, update)
+
+		default:
+			var message models.WSMessageNewData
+			if err := json.Unmarshal(entry.Data, &message); err != nil {
+				return nil, fmt.Errorf("failed to decode replayed message: %w", err)
+			}
+			if message.ID <= lastMessageID {
+				continue
+			}
+			replayedIDs[message.ID] = len(replay.Messages)
+			replay.Messages = append(replay.Messages, message)
+		}
+	}
+
+	if len(dropped) > 0 {
+		live := replay.Messages[:0]
+		for _, message := range replay.Messages {
+			if !dropped[message.ID] {
+				live = append(live, message)
+			}
+		}
+		replay.Messages = live
+	}
+
+	return replay, nil
+}
+
+// GetMessages returns a page of history, newest first. With before (or neither cursor) the page
+// holds the messages just older than the cursor; with after, those just newer, for scrolling
+// forward from a point in history. Cursors are opaque (see messageCursor); the page's
+// NextCursor continues in the same direction and PrevCursor turns back.
+func (s *MessageService) GetMessages(ctx context.Context, conversationID, before, after string, limit int) (*models.PaginatedMessagesResponse, error) {
+	collection := s.db.Collection(ctx, "messages")
+
+	if before != "" && after != "" {
+		return nil, validationError("use either before or after, not both")
+	}
+	forward := after != ""
+
+	// Retracted messages are kept but never shown
+	filter := bson.D{
+		{Key: "conversationId", Value: conversationID},
+		{Key: "retractedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	// and neither are those older than the workspace's plan lets its members read back to
+	var conversation models.Conversation
+	err := s.db.Collection(ctx, "conversations").FindOne(ctx, bson.M{"_id": conversationID},
+		options.FindOne().SetProjection(bson.M{"workspaceId": 1})).Decode(&conversation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find conversation: %w", err)
+	}
+	start, err := historyStart(ctx, s.db, conversation.WorkspaceID, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		filter = append(filter, bson.E{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: *start}}})
+	}
+	if cursorValue := before + after; cursorValue != "" {
+		position, err := decodeMessageCursor(cursorValue)
+		if err != nil {
+			return nil, validationError("invalid cursor")
 		}
-	} else {
-		filter = bson.D{{Key: "conversationId", Value: conversationID}}
+		filter = append(filter, position.filter(forward))
 	}
 
 	// Set default limit
@@ -137,8 +534,12 @@ func (s *MessageService) GetMessages(ctx context.Context, conversationID string,
 		limit = 50
 	}
 
+	order := -1
+	if forward {
+		order = 1
+	}
 	opts := options.Find().
-		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSort(bson.D{{Key: "createdAt", Value: order}, {Key: "_id", Value: order}}).
 		SetLimit(int64(limit + 1)) // Fetch one extra to check if there are more
 
 	cursor, err := collection.Find(ctx, filter, opts)
@@ -157,48 +558,151 @@ func (s *MessageService) GetMessages(ctx context.Context, conversationID string,
 		messages = messages[:limit]
 	}
 
-	// Convert to MessageWithSender and populate sender info
-	messagesWithSender := make([]models.MessageWithSender, len(messages))
-	for i, msg := range messages {
-		messagesWithSender[i] = models.MessageWithSender{
-			ID:             msg.ID,
-			ConversationID: msg.ConversationID,
-			SenderID:       msg.SenderID,
-			ClientMsgID:    msg.ClientMsgID,
-			Body:           msg.Body,
-			CreatedAt:      msg.CreatedAt,
+	// The next cursor sits at the far end of the page in the direction of travel, the previous
+	// one at the near end
+	var nextCursor, prevCursor string
+	if len(messages) > 0 {
+		if hasMore {
+			nextCursor = encodeMessageCursor(messages[len(messages)-1])
 		}
-
-		// Fetch sender information
-		if sender, err := s.userService.GetUserByID(ctx, msg.SenderID); err == nil {
-			messagesWithSender[i].Sender = sender
+		prevCursor = encodeMessageCursor(messages[0])
+	}
+	if forward {
+		// Pages are always returned newest first
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
 		}
-		// If user fetch fails, sender will be nil and frontend should handle it gracefully
 	}
 
-	var nextCursor string
-	if hasMore && len(messages) > 0 {
-		nextCursor = messages[len(messages)-1].CreatedAt.Format(time.RFC3339)
+	messagesWithSender := make([]models.MessageWithSender, len(messages))
+	for i := range messages {
+		if messagesWithSender[i], err = s.withSender(ctx, &messages[i]); err != nil {
+			return nil, err
+		}
 	}
 
 	return &models.PaginatedMessagesResponse{
 		Messages:   messagesWithSender,
 		HasMore:    hasMore,
 		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
 	}, nil
 }
 
-func (s *MessageService) MarkMessageAsRead(ctx context.Context, conversationID, userID string, messageID int64) error {
-	collection := s.db.DB.Collection("participants")
+// withSender converts a stored message for clients, with its sender's profile
+func (s *MessageService) withSender(ctx context.Context, msg *models.Message) (models.MessageWithSender, error) {
+	payload, err := payloadJSON(msg)
+	if err != nil {
+		return models.MessageWithSender{}, err
+	}
+	result := models.MessageWithSender{
+		ID:             msg.ID,
+		Seq:            msg.Seq,
+		ConversationID: msg.ConversationID,
+		SenderID:       msg.SenderID,
+		ClientMsgID:    msg.ClientMsgID,
+		Body:           msg.Body,
+		Format:         msg.Format,
+		HTML:           msg.HTML,
+		CreatedAt:      msg.CreatedAt,
+		Preview:        msg.Preview,
+		Emoji:          msg.Emoji,
+		Mentions:       msg.Mentions,
+		Type:           msg.Type,
+		Payload:        payload,
+	}
+	if msg.RetractableUntil != nil && msg.RetractableUntil.After(s.clock.Now()) {
+		result.RetractableUntil = msg.RetractableUntil
+	}
+
+	// If the user fetch fails, sender will be nil and frontend should handle it gracefully
+	if sender, err := s.userService.GetUserProfile(ctx, msg.SenderID); err == nil {
+		result.Sender = sender
+	}
+	return result, nil
+}
+
+// messageCursor is a position in a conversation's history. Messages are ordered by createdAt
+// and then ID, so several messages created in the same millisecond page without gaps or repeats.
+type messageCursor struct {
+	createdAt time.Time
+	id        int64
+}
+
+// encodeMessageCursor returns the opaque cursor for the position of message
+func encodeMessageCursor(message models.Message) string {
+	raw := fmt.Sprintf("%d:%d", message.CreatedAt.UnixMilli(), message.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMessageCursor(cursor string) (*messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
 
-	participantID := fmt.Sprintf("%s:%s", conversationID, userID)
+	var millis, id int64
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &millis, &id); err != nil {
+		return nil, err
+	}
+	return &messageCursor{createdAt: time.UnixMilli(millis).UTC(), id: id}, nil
+}
+
+// filter matches messages strictly after the cursor in the direction of travel: newer when
+// forward, older otherwise
+func (c *messageCursor) filter(forward bool) bson.E {
+	op := "$lt"
+	if forward {
+		op = "$gt"
+	}
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.M{"createdAt": bson.M{op: c.createdAt}},
+		bson.M{"createdAt": c.createdAt, "_id": bson.M{op: c.id}},
+	}}
+}
+
+// MarkMessageAsRead records how far the user has read and tells their other connections.
+// sessionID names the WebSocket connection the read came from, if any, which is not told.
+func (s *MessageService) MarkMessageAsRead(ctx context.Context, conversationID, userID, sessionID string, messageID int64) error {
+	collection := s.db.Collection(ctx, "participants")
+
+	readAt := s.clock.Now()
+	participantID := id.Participant(conversationID, userID)
 	filter := bson.M{"_id": participantID}
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastReadMessageId", Value: messageID}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "lastReadMessageId", Value: messageID},
+		{Key: "lastReadAt", Value: readAt},
+	}}}
 
-	_, err := collection.UpdateOne(ctx, filter, update)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return fmt.Errorf("failed to update read receipt: %w", err)
 	}
+	if result.MatchedCount == 0 {
+		// Not a participant (e.g. a watch-only auditor); receipts would reveal them
+		return forbiddenError("user is not a participant in this conversation")
+	}
+
+	// The user's own devices hear of it whatever their receipt settings
+	selfData := &models.WSReceiptSelfData{
+		ConversationID: conversationID,
+		UserID:         userID,
+		MessageID:      messageID,
+		ReadAt:         readAt,
+		SessionID:      sessionID,
+	}
+	if err := s.nats.PublishSelfReceipt(selfData); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish self receipt", logging.ConversationID, conversationID, logging.UserID, userID, logging.MessageID, messageID, logging.Err(err))
+	}
+
+	// The read position is always kept; sharing it is up to the settings cascade
+	settings, err := s.settingsService.Resolve(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !settings.ReadReceipts {
+		return nil
+	}
 
 	// Publish read receipt update
 	receiptData := &models.WSReceiptUpdateData{
@@ -208,14 +712,52 @@ func (s *MessageService) MarkMessageAsRead(ctx context.Context, conversationID,
 	}
 
 	// Publish to ephemeral subject (not JetStream)
-	err = s.nats.PublishPresence(conversationID, receiptData)
+	err = s.nats.PublishReceipt(conversationID, receiptData)
 	if err != nil {
-		fmt.Printf("Failed to publish read receipt: %v\n", err)
+		s.logger.ErrorContext(ctx, "Failed to publish read receipt", logging.ConversationID, conversationID, logging.UserID, userID, logging.MessageID, messageID, logging.Err(err))
 	}
 
 	return nil
 }
 
+// GetReceipts returns the read positions of a conversation's participants, for "seen by"
+// markers. Participants whose settings turn read receipts off are left out, as their
+// receipt.update frames are; the viewer always sees their own position.
+func (s *MessageService) GetReceipts(ctx context.Context, conversationID, viewerID string) (*models.ConversationReceipts, error) {
+	cursor, err := s.db.Collection(ctx, "participants").Find(ctx,
+		bson.M{"conversationId": conversationID},
+		options.Find().SetSort(bson.M{"userId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find participants: %w", err)
+	}
+	var participants []models.Participant
+	if err := cursor.All(ctx, &participants); err != nil {
+		return nil, fmt.Errorf("failed to decode participants: %w", err)
+	}
+
+	userIDs := make([]string, len(participants))
+	for i, p := range participants {
+		userIDs[i] = p.UserID
+	}
+	settings, err := s.settingsService.ResolveMany(ctx, conversationID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := &models.ConversationReceipts{ConversationID: conversationID, Receipts: []models.ReadReceipt{}}
+	for _, p := range participants {
+		if p.UserID != viewerID && !settings[p.UserID].ReadReceipts {
+			continue
+		}
+		receipts.Receipts = append(receipts.Receipts, models.ReadReceipt{
+			UserID:            p.UserID,
+			LastReadMessageID: p.LastReadMessageID,
+			ReadAt:            p.LastReadAt,
+		})
+	}
+	return receipts, nil
+}
+
 func (s *MessageService) PublishTypingIndicator(conversationID, userID string, isTyping bool) error {
 	typingData := &models.WSTypingUpdateEventData{
 		ConversationID: conversationID,
@@ -225,9 +767,3 @@ func (s *MessageService) PublishTypingIndicator(conversationID, userID string, i
 
 	return s.nats.PublishTyping(conversationID, typingData)
 }
-
-// generateSnowflakeID is a simplified snowflake ID generator
-// In production, use a proper snowflake library
-func generateSnowflakeID() int64 {
-	return time.Now().UnixMilli()
-}
\ No newline at end of file
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A message's type decides what its payload holds. Each type is registered here with the struct
+// its payload is stored as and a builder that checks what a client sent. Everything between
+// (storage, the outbox, history and replays) carries the payload as opaque BSON or JSON, so a
+// new type is one entry here plus its own code, not a field in every model and handler.
+
+// messageType describes one kind of message
+type messageType struct {
+	// payload returns a new value of the stored payload to decode into; nil for types without one
+	payload func() interface{}
+	// build checks a sent message's payload and returns the one to store on message, which has
+	// its sender and time set; nil for types only the server posts
+	build func(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error)
+}
+
+var messageTypes = map[string]messageType{
+	models.MessageTypeText: {
+		build: buildText,
+	},
+	models.MessageTypeGIF: {
+		payload: func() interface{} { return &models.Media{} },
+		build:   buildMedia,
+	},
+	models.MessageTypeSticker: {
+		payload: func() interface{} { return &models.Media{} },
+		build:   buildMedia,
+	},
+	models.MessageTypePoll: {
+		payload: func() interface{} { return &models.Poll{} },
+		build:   buildPoll,
+	},
+	models.MessageTypeLocation: {
+		payload: func() interface{} { return &models.Location{} },
+		build:   buildLocation,
+	},
+	models.MessageTypeFile: {
+		payload: func() interface{} { return &models.File{} },
+		build:   buildFile,
+	},
+	models.MessageTypeSystem: {
+		payload: func() interface{} { return &models.SystemEvent{} },
+	},
+}
+
+// buildPayload checks a sent message's type and payload and sets them on the message
+func (s *MessageService) buildPayload(ctx context.Context, req *models.SendMessageRequest, message *models.Message) error {
+	kind := req.Type
+	if kind == "" {
+		kind = models.MessageTypeText
+	}
+	t, ok := messageTypes[kind]
+	if !ok {
+		return validationError("unknown message type " + kind)
+	}
+	if t.build == nil {
+		return validationError(kind + " messages are only posted by the server")
+	}
+
+	payload, err := t.build(ctx, s, req, message)
+	if err != nil {
+		return err
+	}
+	if kind != models.MessageTypeText {
+		message.Type = kind
+	}
+	return setPayload(message, payload)
+}
+
+// setPayload stores a payload on a message
+func setPayload(message *models.Message, payload interface{}) error {
+	if payload == nil {
+		message.Payload = nil
+		return nil
+	}
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s payload: %w", message.Type, err)
+	}
+	message.Payload = raw
+	return nil
+}
+
+// decodePayload reads a message's payload, which must be of the given type, into v
+func decodePayload(message *models.Message, kind string, v interface{}) error {
+	if message.Type != kind || message.Payload == nil {
+		return validationError("message is not a " + kind)
+	}
+	if err := bson.Unmarshal(message.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", kind, err)
+	}
+	return nil
+}
+
+// payloadJSON renders a message's payload for clients, through the type's payload struct
+func payloadJSON(message *models.Message) (json.RawMessage, error) {
+	t := messageTypes[message.Type]
+	if message.Payload == nil || t.payload == nil {
+		return nil, nil
+	}
+	payload := t.payload()
+	if err := bson.Unmarshal(message.Payload, payload); err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", message.Type, err)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", message.Type, err)
+	}
+	return data, nil
+}
+
+// decodeRequest reads and checks the payload a client sent for a type
+func decodeRequest(req *models.SendMessageRequest, v interface{}) error {
+	if len(req.Payload) == 0 {
+		return validationError("payload is required for " + req.Type + " messages")
+	}
+	if err := json.Unmarshal(req.Payload, v); err != nil {
+		return validationError("invalid payload for " + req.Type + " messages")
+	}
+	if err := validate.Struct(v); err != nil {
+		return validationError("payload." + err.Error())
+	}
+	return nil
+}
+
+func buildText(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error) {
+	if len(req.Payload) > 0 && string(req.Payload) != "null" {
+		return nil, validationError("text messages take no payload")
+	}
+	return nil, nil
+}
+
+func buildMedia(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error) {
+	var media models.MediaRequest
+	if err := decodeRequest(req, &media); err != nil {
+		return nil, err
+	}
+	return s.gifService.media(ctx, req.Type, media.MediaID)
+}
+
+func buildPoll(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error) {
+	var poll models.PollRequest
+	if err := decodeRequest(req, &poll); err != nil {
+		return nil, err
+	}
+	return newPoll(&poll, message.CreatedAt)
+}
+
+func buildLocation(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error) {
+	var location models.LocationRequest
+	if err := decodeRequest(req, &location); err != nil {
+		return nil, err
+	}
+	return newLocation(&location, message.CreatedAt)
+}
+
+func buildFile(ctx context.Context, s *MessageService, req *models.SendMessageRequest, message *models.Message) (interface{}, error) {
+	var file models.FileRequest
+	if err := decodeRequest(req, &file); err != nil {
+		return nil, err
+	}
+	return s.attachedFile(ctx, message.ConversationID, file.AttachmentID, message.SenderID)
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A mute keeps one participant from sending in one conversation for a while. It is stored on
+// their participant document and checked by SendMessage, so it expires by itself when its
+// time is up; nothing sweeps it. Moderators and admins mute, and cannot be muted.
+
+// Audit actions for mutes
+const (
+	AuditMemberMuted   = "member.muted"
+	AuditMemberUnmuted = "member.unmuted"
+)
+
+// MuteService mutes and unmutes participants
+type MuteService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+}
+
+func NewMuteService(db *database.MongoDB, conversationService *ConversationService, auditService *AuditService, clk clock.Clock, logger *slog.Logger) *MuteService {
+	return &MuteService{
+		db:                  db,
+		conversationService: conversationService,
+		auditService:        auditService,
+		clock:               clk,
+		logger:              logger,
+	}
+}
+
+// Mute keeps a participant from sending for req.DurationSeconds, on a moderator's behalf. A
+// new mute replaces one in force. Members are told with member.muted.
+func (s *MuteService) Mute(ctx context.Context, conversationID, actorID, userID string, req *models.MuteMemberRequest) (*models.ParticipantMute, error) {
+	if err := s.requireMutable(ctx, conversationID, actorID, userID); err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	mute := &models.ParticipantMute{
+		Until:   now.Add(time.Duration(req.DurationSeconds) * time.Second),
+		Reason:  req.Reason,
+		MutedBy: actorID,
+		MutedAt: now,
+	}
+	result, err := s.db.Collection(ctx, "participants").UpdateOne(ctx, bson.M{"_id": id.Participant(conversationID, userID)},
+		bson.M{"$set": bson.M{"mute": mute}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to mute member: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, notFoundError("user is not a participant in this conversation")
+	}
+
+	s.audit(ctx, AuditMemberMuted, actorID, conversationID, map[string]interface{}{
+		"userId": userID,
+		"until":  mute.Until,
+		"reason": mute.Reason,
+	})
+	if err := s.announce(ctx, models.MemberMuted, conversationID, actorID, userID, &mute.Until); err != nil {
+		return nil, err
+	}
+	return mute, nil
+}
+
+// Unmute lifts a participant's mute early, on a moderator's behalf; unmuting someone not
+// muted changes nothing
+func (s *MuteService) Unmute(ctx context.Context, conversationID, actorID, userID string) error {
+	if err := s.requireMutable(ctx, conversationID, actorID, userID); err != nil {
+		return err
+	}
+
+	result, err := s.db.Collection(ctx, "participants").UpdateOne(ctx,
+		bson.M{"_id": id.Participant(conversationID, userID), "mute.until": bson.M{"$gt": s.clock.Now()}},
+		bson.M{"$unset": bson.M{"mute": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to unmute member: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	s.audit(ctx, AuditMemberUnmuted, actorID, conversationID, map[string]interface{}{"userId": userID})
+	return s.announce(ctx, models.MemberUnmuted, conversationID, actorID, userID, nil)
+}
+
+// requireMutable checks that the actor may mute and the user is a participant who may be muted
+func (s *MuteService) requireMutable(ctx context.Context, conversationID, actorID, userID string) error {
+	if _, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.MuteMembers, "only moderators and admins can mute members"); err != nil {
+		return err
+	}
+	participant, err := s.conversationService.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if permissions.CheckPermission(participant, nil, permissions.MuteMembers) {
+		return forbiddenError("moderators and admins cannot be muted")
+	}
+	return nil
+}
+
+func (s *MuteService) announce(ctx context.Context, event, conversationID, actorID, userID string, until *time.Time) error {
+	memberIDs, err := s.conversationService.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	s.conversationService.listCache.InvalidateMembers(conversationID, memberIDs)
+	s.conversationService.announce(ctx, &models.WSConversationEventData{
+		Event:          event,
+		ConversationID: conversationID,
+		UserIDs:        []string{userID},
+		MutedUntil:     until,
+		ActorID:        actorID,
+		Recipients:     memberIDs,
+	})
+	return nil
+}
+
+func (s *MuteService) audit(ctx context.Context, action, actorID, conversationID string, details map[string]interface{}) {
+	if err := s.auditService.Record(ctx, action, actorID, conversationID, details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit mute change", logging.ConversationID, conversationID, logging.Err(err))
+	}
+}
+
+// mutedError refuses a muted participant's message, with how long the mute has left
+func mutedError(remaining time.Duration) error {
+	remaining = remaining.Round(time.Second)
+	if remaining < time.Second {
+		remaining = time.Second
+	}
+	return &Error{
+		Kind:       ErrForbidden,
+		Message:    fmt.Sprintf("you are muted in this conversation for another %s", remaining),
+		RetryAfter: remaining,
+	}
+}
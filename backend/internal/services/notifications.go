@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification channels
+const (
+	NotifyChannelPush  = "push"
+	NotifyChannelEmail = "email"
+)
+
+const (
+	notificationSettingsCollection = "notification_settings"
+	quietHoursLayout               = "15:04"
+)
+
+// NotificationService keeps each user's notification preferences: which channels they want,
+// whether only mentions count, and quiet hours. It also holds back notifications during the
+// user's do-not-disturb windows and summarises them afterwards (see dnd.go). Every dispatch
+// path asks Channels before sending; NotificationDispatcher is the first.
+type NotificationService struct {
+	db              *database.MongoDB
+	settingsService *SettingsService
+	userService     *UserService
+	nats            *nats.NATSConnection
+	clock           clock.Clock
+	logger          *slog.Logger
+}
+
+func NewNotificationService(db *database.MongoDB, settingsService *SettingsService, userService *UserService, natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger) *NotificationService {
+	return &NotificationService{
+		db:              db,
+		settingsService: settingsService,
+		userService:     userService,
+		nats:            natsConn,
+		clock:           clk,
+		logger:          logger,
+	}
+}
+
+// defaultNotificationSettings applies to users who never saved preferences
+func defaultNotificationSettings(userID string) *models.NotificationSettings {
+	return &models.NotificationSettings{UserID: userID, Push: true, Email: false}
+}
+
+// GetSettings returns the user's preferences, or the defaults if none are saved
+func (s *NotificationService) GetSettings(ctx context.Context, userID string) (*models.NotificationSettings, error) {
+	var settings models.NotificationSettings
+	err := s.db.Collection(ctx, notificationSettingsCollection).FindOne(ctx, bson.M{"_id": userID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return defaultNotificationSettings(userID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// UpdateSettings replaces the user's preferences
+func (s *NotificationService) UpdateSettings(ctx context.Context, userID string, settings *models.NotificationSettings) (*models.NotificationSettings, error) {
+	if quiet := settings.QuietHours; quiet != nil {
+		if err := validateQuietHours(quiet); err != nil {
+			return nil, err
+		}
+	}
+
+	settings.UserID = userID
+	settings.UpdatedAt = s.clock.Now()
+	_, err := s.db.Collection(ctx, notificationSettingsCollection).ReplaceOne(ctx,
+		bson.M{"_id": userID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store notification settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Channels returns the channels (NotifyChannel*) a notification about a new message in a
+// conversation goes out on now; none means it is not sent. Call it once per message and
+// recipient. The conversation's resolved notifications level (see SettingsService) is checked
+// first, then the user's channel switches, mention-only preference and quiet hours, which
+// drop the notification. Last comes do-not-disturb, which holds it for the away summary.
+func (s *NotificationService) Channels(ctx context.Context, userID, conversationID string, mentioned bool) ([]string, error) {
+	effective, err := s.settingsService.Resolve(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch effective.Notifications {
+	case models.NotifyNone:
+		return nil, nil
+	case models.NotifyMentions:
+		if !mentioned {
+			return nil, nil
+		}
+	}
+
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var channels []string
+	if settings.Push {
+		channels = append(channels, NotifyChannelPush)
+	}
+	if settings.Email {
+		channels = append(channels, NotifyChannelEmail)
+	}
+	if len(channels) == 0 || (settings.MentionsOnly && !mentioned) {
+		return nil, nil
+	}
+	now := s.clock.Now()
+	if settings.QuietHours != nil && inQuietHours(settings.QuietHours, now) {
+		return nil, nil
+	}
+
+	user, err := s.userService.GetUserProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DND != nil && inDND(user.DND, now) {
+		return nil, s.holdForSummary(ctx, userID, conversationID, mentioned)
+	}
+	return channels, nil
+}
+
+func validateQuietHours(quiet *models.QuietHours) error {
+	if err := validateWindow("quietHours", quiet.Start, quiet.End); err != nil {
+		return err
+	}
+	if quiet.TimeZone == "" {
+		quiet.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(quiet.TimeZone); err != nil {
+		return validationError("quietHours.timeZone must be an IANA time zone, e.g. Europe/Berlin")
+	}
+	return nil
+}
+
+// inQuietHours reports whether now falls in the window, read in its time zone
+func inQuietHours(quiet *models.QuietHours, now time.Time) bool {
+	location, err := time.LoadLocation(quiet.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	inside, _ := inWindow(quiet.Start, quiet.End, local)
+	return inside
+}
+
+// validateWindow checks a daily window's HH:MM bounds; field names it in errors
+func validateWindow(field, start, end string) error {
+	from, err := time.Parse(quietHoursLayout, start)
+	if err != nil {
+		return validationError(field + ".start must be HH:MM")
+	}
+	to, err := time.Parse(quietHoursLayout, end)
+	if err != nil {
+		return validationError(field + ".end must be HH:MM")
+	}
+	if from.Equal(to) {
+		return validationError(field + ".start and " + field + ".end must differ")
+	}
+	return nil
+}
+
+// inWindow reports whether local time falls in the daily window from start to end, which
+// wraps past midnight when end is earlier, e.g. 22:00 to 07:00. startedYesterday is set
+// when local is in the part of a wrapping window after midnight.
+func inWindow(start, end string, local time.Time) (inside, startedYesterday bool) {
+	from, err1 := time.Parse(quietHoursLayout, start)
+	to, err2 := time.Parse(quietHoursLayout, end)
+	if err1 != nil || err2 != nil {
+		return false, false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	fromMinute := from.Hour()*60 + from.Minute()
+	toMinute := to.Hour()*60 + to.Minute()
+	if fromMinute < toMinute {
+		return minute >= fromMinute && minute < toMinute, false
+	}
+	if minute >= fromMinute {
+		return true, false
+	}
+	return minute < toMinute, minute < toMinute
+}
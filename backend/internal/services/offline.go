@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+)
+
+// Offline delivery: when a user's last connection on this node closes, the hub parks a durable
+// consumer on the CHAT stream filtered to their conversations. On the next connect, everything it
+// collected is sent (in stream order) as message.new and message.retracted frames followed by offline.done, before any
+// live traffic. Users connected to several nodes may see a message both live and as offline
+// delivery; clients dedupe on message ID.
+
+const offlineConsumerPrefix = "offline-"
+
+func offlineConsumerName(userID string) string {
+	// Durable names cannot contain subject tokens, so derive a safe name from the user ID
+	return offlineConsumerPrefix + hashSecret(userID)[:24]
+}
+
+func (h *WebSocketHub) conversationSubjects(ctx context.Context, userID string) ([]string, error) {
+	conversationIDs, err := h.conversationService.GetUserConversationIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]string, len(conversationIDs))
+	for i, id := range conversationIDs {
+		subjects[i] = "chat.conv." + id + ".msg"
+	}
+	return subjects, nil
+}
+
+// parkUser starts collecting the user's messages until they reconnect
+func (h *WebSocketHub) parkUser(ctx context.Context, userID string) {
+	if h.config.OfflineDeliveryLimit <= 0 {
+		return
+	}
+
+	subjects, err := h.conversationSubjects(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to park offline consumer", logging.UserID, userID, logging.Err(err))
+		return
+	}
+
+	if err := h.natsConn.ParkConsumer(ctx, offlineConsumerName(userID), subjects, h.config.OfflineRetention); err != nil {
+		h.logger.Error("Failed to park offline consumer", logging.UserID, userID, logging.Err(err))
+	}
+}
+
+// deliverOffline sends the messages collected while the user was away
+func (h *WebSocketHub) deliverOffline(client *Client) {
+	if h.config.OfflineDeliveryLimit <= 0 || client.APIKeyID != "" {
+		return
+	}
+
+	ctx := client.context()
+	// Include conversations joined while offline
+	subjects, err := h.conversationSubjects(ctx, client.UserID)
+	if err != nil {
+		client.logger.Error("Failed to load conversations for offline delivery", logging.Err(err))
+		return
+	}
+
+	collected, truncated, err := h.natsConn.DrainConsumer(ctx, offlineConsumerName(client.UserID), subjects, h.config.OfflineDeliveryLimit)
+	if err != nil {
+		client.logger.Error("Failed to drain offline consumer", logging.Err(err))
+		return
+	}
+
+	for _, entry := range collected {
+		switch entry.Event {
+		case nats.EventMessageRetracted:
+			var retraction models.WSMessageRetractedData
+			if err := json.Unmarshal(entry.Data, &retraction); err != nil {
+				client.logger.Error("Failed to decode offline retraction", logging.Err(err))
+				continue
+			}
+			if !client.sendFrameWait("message.retracted", &retraction, resumeSendTimeout) {
+				return
+			}
+			continue
+		case nats.EventMessageUpdated:
+			var update models.WSMessageUpdatedData
+			if err := json.Unmarshal(entry.Data, &update); err != nil {
+				client.logger.Error("Failed to decode offline update", logging.Err(err))
+				continue
+			}
+			if !client.sendFrameWait("message.updated", &update, resumeSendTimeout) {
+				return
+			}
+			continue
+		}
+
+		var message models.WSMessageNewData
+		if err := json.Unmarshal(entry.Data, &message); err != nil {
+			client.logger.Error("Failed to decode offline message", logging.Err(err))
+			continue
+		}
+		if !client.sendFrameWait("message.new", &message, resumeSendTimeout) {
+			return
+		}
+	}
+
+	client.sendFrame("offline.done", &models.WSOfflineDoneData{
+		Delivered: len(collected),
+		Truncated: truncated,
+	})
+}
+
+// userConnected reports whether the user still has a connection on this node, not counting bots
+func (h *WebSocketHub) userConnected(userID string) bool {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	for _, client := range h.userClients[userID] {
+		if client.APIKeyID == "" {
+			return true
+		}
+	}
+	return false
+}
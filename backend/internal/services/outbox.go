@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection = "outbox"
+	// outboxGrace keeps the relay from racing SendMessage's own publish of a fresh entry
+	outboxGrace     = 5 * time.Second
+	outboxBatchSize = 100
+	// outboxSentRetention is how long published entries are kept for inspection
+	outboxSentRetention = 24 * time.Hour
+)
+
+func outboxEntryID(messageID int64, event string) string {
+	return fmt.Sprintf("%d:%s", messageID, event)
+}
+
+// outboxEntry builds the message.created entry for a new message
+func (s *MessageService) outboxEntry(message *models.Message, sender *models.User) (*models.OutboxEntry, error) {
+	messagePayload, err := payloadJSON(message)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(&models.WSMessageNewData{
+		ID:               message.ID,
+		Seq:              message.Seq,
+		ConversationID:   message.ConversationID,
+		SenderID:         message.SenderID,
+		Body:             message.Body,
+		Format:           message.Format,
+		HTML:             message.HTML,
+		CreatedAt:        message.CreatedAt,
+		Sender:           sender,
+		Retractable:      message.RetractableUntil != nil,
+		RetractableUntil: message.RetractableUntil,
+		Emoji:            message.Emoji,
+		Mentions:         message.Mentions,
+		Type:             message.Type,
+		Payload:          messagePayload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message event: %w", err)
+	}
+
+	return &models.OutboxEntry{
+		ID:             outboxEntryID(message.ID, nats.EventMessageCreated),
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		Event:          nats.EventMessageCreated,
+		MsgID:          outboxEntryID(message.ID, nats.EventMessageCreated), // per stored message; retries return the same one
+		Payload:        payload,
+		CreatedAt:      message.CreatedAt,
+	}, nil
+}
+
+// retractionEntry builds the message.retracted entry for a message retracted at the given time
+func (s *MessageService) retractionEntry(message *models.Message, retractedAt time.Time) (*models.OutboxEntry, error) {
+	payload, err := json.Marshal(&models.WSMessageRetractedData{
+		ConversationID: message.ConversationID,
+		ID:             message.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal retraction event: %w", err)
+	}
+
+	return &models.OutboxEntry{
+		ID:             outboxEntryID(message.ID, nats.EventMessageRetracted),
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		Event:          nats.EventMessageRetracted,
+		MsgID:          outboxEntryID(message.ID, nats.EventMessageRetracted),
+		Payload:        payload,
+		CreatedAt:      retractedAt,
+	}, nil
+}
+
+// updateEntry builds a message.updated entry announcing a change to a sent message. A message
+// can be updated several times, so each entry gets its own ID.
+func (s *MessageService) updateEntry(update *models.WSMessageUpdatedData, updatedAt time.Time) (*models.OutboxEntry, error) {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal update event: %w", err)
+	}
+
+	entryID := outboxEntryID(update.ID, nats.EventMessageUpdated) + ":" + s.ids.NewID()
+	return &models.OutboxEntry{
+		ID:             entryID,
+		MessageID:      update.ID,
+		ConversationID: update.ConversationID,
+		Event:          nats.EventMessageUpdated,
+		MsgID:          entryID,
+		Payload:        payload,
+		CreatedAt:      updatedAt,
+	}, nil
+}
+
+// publishEntry publishes an outbox entry to JetStream, then records the stream sequence on the
+// message and marks the entry sent. Failures stay pending for the relay. The Nats-Msg-Id makes
+// a repeated publish of the same entry harmless.
+func (s *MessageService) publishEntry(ctx context.Context, entry *models.OutboxEntry) {
+	outbox := s.db.Collection(ctx, outboxCollection)
+
+	streamSeq, duplicate, err := s.nats.PublishMessage(entry.ConversationID, entry.Event, entry.MsgID, entry.RequestID, tenant.FromContext(ctx), entry.Payload)
+	if err != nil {
+		s.logger.Error("Failed to publish to NATS", "event", entry.Event, logging.MessageID, entry.MessageID, logging.ConversationID, entry.ConversationID, logging.RequestID, entry.RequestID, logging.Err(err))
+		outbox.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{
+			"$inc": bson.M{"attempts": 1},
+			"$set": bson.M{"lastError": err.Error()},
+		})
+		return
+	}
+	if duplicate {
+		s.logger.Info("Publish deduplicated by JetStream", "event", entry.Event, logging.MessageID, entry.MessageID, "stream_seq", streamSeq)
+	}
+
+	if entry.Event == nats.EventMessageCreated {
+		// Remember where the message sits in the stream so resumes can start right after it
+		_, err = s.db.Collection(ctx, "messages").UpdateOne(ctx, bson.M{"_id": entry.MessageID}, bson.M{"$set": bson.M{"streamSeq": streamSeq}})
+		if err != nil {
+			s.logger.Error("Failed to record stream sequence", logging.MessageID, entry.MessageID, logging.Err(err))
+		}
+	}
+
+	_, err = outbox.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{
+		"$set":   bson.M{"sent": true, "sentAt": s.clock.Now()},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"lastError": ""},
+	})
+	if err != nil {
+		s.logger.Error("Failed to mark outbox entry sent", "outbox_id", entry.ID, logging.MessageID, entry.MessageID, logging.Err(err))
+	}
+}
+
+// RunOutboxRelay publishes outbox entries that were not published inline, in write order, every
+// interval until ctx is cancelled. Sent entries are removed after outboxSentRetention.
+func (s *MessageService) RunOutboxRelay(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		s.logger.Info("Outbox relay disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepEachTenant(ctx, s.db, s.logger, s.relayPending)
+		}
+	}
+}
+
+func (s *MessageService) relayPending(ctx context.Context) {
+	outbox := s.db.Collection(ctx, outboxCollection)
+	now := s.clock.Now()
+
+	cursor, err := outbox.Find(ctx,
+		bson.M{"sent": false, "createdAt": bson.M{"$lte": now.Add(-outboxGrace)}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "messageId", Value: 1}}).SetLimit(outboxBatchSize))
+	if err != nil {
+		s.logger.Error("Failed to load pending outbox entries", logging.Err(err))
+		return
+	}
+
+	var pending []models.OutboxEntry
+	if err := cursor.All(ctx, &pending); err != nil {
+		s.logger.Error("Failed to decode pending outbox entries", logging.Err(err))
+		return
+	}
+
+	for i := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		s.publishEntry(ctx, &pending[i])
+	}
+
+	_, err = outbox.DeleteMany(ctx, bson.M{"sent": true, "sentAt": bson.M{"$lt": now.Add(-outboxSentRetention)}})
+	if err != nil {
+		s.logger.Error("Failed to remove sent outbox entries", logging.Err(err))
+	}
+}
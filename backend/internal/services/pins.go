@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxPins bounds a conversation's pins, which travel with it in every conversation.updated
+const maxPins = 50
+
+// PinMessage pins a message in its conversation on behalf of a participant the pin policy
+// allows; pinning a pinned message changes nothing
+func (s *ConversationService) PinMessage(ctx context.Context, conversationID, actorID string, messageID int64) (*models.Conversation, error) {
+	conversation, err := s.requirePinPolicy(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pin := range conversation.Pins {
+		if pin.MessageID == messageID {
+			return conversation, nil
+		}
+	}
+
+	err = s.db.Collection(ctx, "messages").FindOne(ctx, bson.M{
+		"_id":            messageID,
+		"conversationId": conversationID,
+		"retractedAt":    bson.M{"$exists": false},
+	}).Err()
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("message not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message: %w", err)
+	}
+
+	pin := models.Pin{MessageID: messageID, PinnedBy: actorID, PinnedAt: s.clock.Now()}
+	result, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx,
+		bson.M{
+			"_id":                             conversationID,
+			"pins.messageId":                  bson.M{"$ne": messageID},
+			fmt.Sprintf("pins.%d", maxPins-1): bson.M{"$exists": false},
+		},
+		bson.M{"$push": bson.M{"pins": pin}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to pin message: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		// Pinned at the same time, or the conversation is full
+		if conversation, err = s.GetConversationByID(ctx, conversationID); err != nil {
+			return nil, err
+		}
+		for _, pin := range conversation.Pins {
+			if pin.MessageID == messageID {
+				return conversation, nil
+			}
+		}
+		return nil, conflictError(fmt.Sprintf("a conversation can have at most %d pinned messages", maxPins))
+	}
+
+	conversation.Pins = append(conversation.Pins, pin)
+	if err := s.announceUpdate(ctx, conversation, actorID); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// UnpinMessage removes a pin on behalf of a participant the pin policy allows
+func (s *ConversationService) UnpinMessage(ctx context.Context, conversationID, actorID string, messageID int64) (*models.Conversation, error) {
+	conversation, err := s.requirePinPolicy(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx,
+		bson.M{"_id": conversationID, "pins.messageId": messageID},
+		bson.M{"$pull": bson.M{"pins": bson.M{"messageId": messageID}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpin message: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, notFoundError("message is not pinned")
+	}
+
+	pins := make([]models.Pin, 0, len(conversation.Pins))
+	for _, pin := range conversation.Pins {
+		if pin.MessageID != messageID {
+			pins = append(pins, pin)
+		}
+	}
+	conversation.Pins = pins
+	if err := s.announceUpdate(ctx, conversation, actorID); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// ListPins returns a conversation's pins with their messages to a participant. Messages that
+// are gone, retracted or older than the workspace's plan lets members read are left off their
+// pins.
+func (s *ConversationService) ListPins(ctx context.Context, conversationID, userID string) (*models.PinsResponse, error) {
+	if _, err := s.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+	conversation, err := s.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.PinsResponse{Pins: make([]models.PinnedMessage, len(conversation.Pins))}
+	if len(conversation.Pins) == 0 {
+		return response, nil
+	}
+	ids := make([]int64, len(conversation.Pins))
+	for i, pin := range conversation.Pins {
+		ids[i] = pin.MessageID
+	}
+	filter := bson.M{
+		"_id":            bson.M{"$in": ids},
+		"conversationId": conversationID,
+		"retractedAt":    bson.M{"$exists": false},
+	}
+	start, err := historyStart(ctx, s.db, conversation.WorkspaceID, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		filter["createdAt"] = bson.M{"$gte": *start}
+	}
+	cursor, err := s.db.Collection(ctx, "messages").Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pinned messages: %w", err)
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode pinned messages: %w", err)
+	}
+	byID := make(map[int64]*models.Message, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+	}
+
+	for i, pin := range conversation.Pins {
+		response.Pins[i] = models.PinnedMessage{Pin: pin, Message: byID[pin.MessageID]}
+	}
+	return response, nil
+}
+
+// requirePinPolicy returns a conversation if its pin policy lets the actor pin
+func (s *ConversationService) requirePinPolicy(ctx context.Context, conversationID, actorID string) (*models.Conversation, error) {
+	participant, err := s.GetParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	conversation, err := s.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPolicy(participant, conversation.PinPolicy) {
+		return nil, forbiddenError("the conversation's pin policy does not let you pin messages")
+	}
+	return conversation, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SetPolicies changes who may post, add members, pin and change the title in a group
+// conversation, on an admin's behalf. A policy set back to its default is removed from the
+// document. Members are told with conversation.updated, so their clients can match the rules.
+func (s *ConversationService) SetPolicies(ctx context.Context, conversationID, actorID string, req *models.ConversationPoliciesRequest) (*models.Conversation, error) {
+	conversation, err := s.requireGroupPermission(ctx, conversationID, actorID, permissions.ManageConversation, "only admins can change the conversation's policies")
+	if err != nil {
+		return nil, err
+	}
+
+	var defaults models.Conversation
+	defaults.FillPolicyDefaults()
+	set, unset := bson.M{}, bson.M{}
+	for _, policy := range []struct {
+		field    string
+		value    string
+		fallback string
+		current  *string
+	}{
+		{"postingPolicy", req.PostingPolicy, defaults.PostingPolicy, &conversation.PostingPolicy},
+		{"invitePolicy", req.InvitePolicy, defaults.InvitePolicy, &conversation.InvitePolicy},
+		{"pinPolicy", req.PinPolicy, defaults.PinPolicy, &conversation.PinPolicy},
+		{"metadataPolicy", req.MetadataPolicy, defaults.MetadataPolicy, &conversation.MetadataPolicy},
+	} {
+		switch policy.value {
+		case "":
+			continue
+		case policy.fallback:
+			unset[policy.field] = ""
+		default:
+			set[policy.field] = policy.value
+		}
+		*policy.current = policy.value
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return conversation, nil
+	}
+	if _, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversationID}, update); err != nil {
+		return nil, fmt.Errorf("failed to update policies: %w", err)
+	}
+	if err := s.announceUpdate(ctx, conversation, actorID); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// UpdateConversation retitles a group conversation on behalf of a participant its metadata
+// policy allows
+func (s *ConversationService) UpdateConversation(ctx context.Context, conversationID, actorID string, req *models.UpdateConversationRequest) (*models.Conversation, error) {
+	conversation, participant, err := s.groupParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPolicy(participant, conversation.MetadataPolicy) {
+		return nil, forbiddenError("the conversation's policy does not let you change its title")
+	}
+	if conversation.Provisioning != nil {
+		return nil, conflictError("the conversation's title follows its directory group")
+	}
+	if req.Title == conversation.Title {
+		return conversation, nil
+	}
+
+	if _, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversationID},
+		bson.M{"$set": bson.M{"title": req.Title}}); err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	conversation.Title = req.Title
+	if err := s.announceUpdate(ctx, conversation, actorID); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// SetMemberRole makes a participant of a group conversation an admin, a moderator or a plain
+// member, on an admin's behalf. The last admin cannot be demoted while others remain, as they
+// cannot leave. Members are told with member.role_changed.
+func (s *ConversationService) SetMemberRole(ctx context.Context, conversationID, actorID, userID string, req *models.MemberRoleRequest) error {
+	if _, err := s.requireGroupPermission(ctx, conversationID, actorID, permissions.ManageRoles, "only admins can change members' roles"); err != nil {
+		return err
+	}
+	participant, err := s.GetParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if participant.Role == req.Role {
+		return nil
+	}
+
+	collection := s.db.Collection(ctx, "participants")
+	if participant.Role == models.ConversationRoleAdmin {
+		admins, err := collection.CountDocuments(ctx, bson.M{"conversationId": conversationID, "role": models.ConversationRoleAdmin})
+		if err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+		if admins == 1 {
+			return conflictError("the last admin cannot be demoted; make another member admin first")
+		}
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": id.Participant(conversationID, userID)},
+		bson.M{"$set": bson.M{"role": req.Role}}); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	memberIDs, err := s.participantUserIDs(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	s.listCache.InvalidateMembers(conversationID, memberIDs)
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.MemberRoleChanged,
+		ConversationID: conversationID,
+		UserIDs:        []string{userID},
+		Role:           req.Role,
+		ActorID:        actorID,
+		Recipients:     memberIDs,
+	})
+	return nil
+}
+
+// announceUpdate tells a conversation's members it changed, with conversation.updated
+func (s *ConversationService) announceUpdate(ctx context.Context, conversation *models.Conversation, actorID string) error {
+	memberIDs, err := s.participantUserIDs(ctx, conversation.ID)
+	if err != nil {
+		return err
+	}
+	workspaceSlowMode, err := s.workspaceSlowMode(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	conversation.SlowModeSeconds = slowModeSeconds(conversation, workspaceSlowMode)
+	s.listCache.InvalidateMembers(conversation.ID, memberIDs)
+	s.announce(ctx, &models.WSConversationEventData{
+		Event:          models.ConversationUpdated,
+		ConversationID: conversation.ID,
+		Conversation:   conversation,
+		ActorID:        actorID,
+		Recipients:     memberIDs,
+	})
+	return nil
+}
+
+// workspaceSlowMode returns a workspace's default slow mode interval, which its conversations
+// without their own follow
+func (s *ConversationService) workspaceSlowMode(ctx context.Context, workspaceID string) (int, error) {
+	var doc models.SettingsDocument
+	err := s.db.Collection(ctx, settingsCollection).FindOne(ctx, bson.M{"_id": workspaceSettingsID(workspaceID)},
+		options.FindOne().SetProjection(bson.M{"settings.slowModeSeconds": 1})).Decode(&doc)
+	if err == mongo.ErrNoDocuments || (err == nil && doc.Settings.SlowModeSeconds == nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load settings: %w", err)
+	}
+	return *doc.Settings.SlowModeSeconds, nil
+}
+
+// slowModeSeconds is a conversation's effective slow mode interval, as SettingsService
+// resolves it: the conversation's override, else the workspace's
+func slowModeSeconds(conversation *models.Conversation, workspaceSlowMode int) int {
+	if conversation.Settings != nil && conversation.Settings.SlowModeSeconds != nil {
+		return *conversation.Settings.SlowModeSeconds
+	}
+	return workspaceSlowMode
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Polls are messages of type poll. Each vote replaces the voter's previous one in poll_votes
+// and adjusts the tally on the message in the same transaction, then the new tally goes out as
+// a poll.update frame. Frames are ephemeral: a client that missed some reads the tally from the
+// message. Polls with a close time are closed by RunPollCloser, which sends the final tally.
+
+const (
+	pollVotesCollection = "poll_votes"
+	minPollOptions      = 2
+	maxPollOptions      = 10
+	maxPollOptionLength = 100
+	pollCloseBatchSize  = 100
+)
+
+// newPoll checks a poll request and returns the poll to store
+func newPoll(req *models.PollRequest, now time.Time) (*models.Poll, error) {
+	if req == nil {
+		return nil, validationError("poll is required for poll messages")
+	}
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		return nil, validationError(fmt.Sprintf("a poll needs %d to %d options", minPollOptions, maxPollOptions))
+	}
+
+	poll := &models.Poll{
+		Options:     make([]models.PollOption, len(req.Options)),
+		MultiSelect: req.MultiSelect,
+	}
+	seen := make(map[string]bool)
+	for i, option := range req.Options {
+		text := strings.TrimSpace(option)
+		if text == "" || len([]rune(text)) > maxPollOptionLength {
+			return nil, validationError(fmt.Sprintf("poll options must be 1 to %d characters", maxPollOptionLength))
+		}
+		if seen[strings.ToLower(text)] {
+			return nil, validationError("poll options must differ")
+		}
+		seen[strings.ToLower(text)] = true
+		poll.Options[i] = models.PollOption{Text: text}
+	}
+	if req.ClosesAt != nil {
+		if !req.ClosesAt.After(now) {
+			return nil, validationError("closesAt must be in the future")
+		}
+		closesAt := req.ClosesAt.UTC()
+		poll.ClosesAt = &closesAt
+	}
+	return poll, nil
+}
+
+// Vote replaces the user's vote in a poll and announces the new tally. The caller has checked
+// that the user is a participant.
+func (s *MessageService) Vote(ctx context.Context, messageID int64, userID string, req *models.VoteRequest) (*models.Poll, error) {
+	messages := s.db.Collection(ctx, "messages")
+	votes := s.db.Collection(ctx, pollVotesCollection)
+	voteID := fmt.Sprintf("%d:%s", messageID, userID)
+	now := s.clock.Now()
+
+	var poll *models.Poll
+	err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		var message models.Message
+		err := messages.FindOne(txCtx, bson.M{
+			"_id":            messageID,
+			"conversationId": req.ConversationID,
+			"retractedAt":    bson.M{"$exists": false},
+		}).Decode(&message)
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("message not found")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find message: %w", err)
+		}
+		var current models.Poll
+		if err := decodePayload(&message, models.MessageTypePoll, &current); err != nil {
+			return err
+		}
+		if pollClosed(&current, now) {
+			return conflictError("the poll is closed")
+		}
+
+		chosen, err := checkVote(&current, req.Options)
+		if err != nil {
+			return err
+		}
+
+		var previous models.PollVote
+		err = votes.FindOne(txCtx, bson.M{"_id": voteID}).Decode(&previous)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to find vote: %w", err)
+		}
+
+		inc := bson.M{}
+		for _, option := range previous.Options {
+			inc[fmt.Sprintf("payload.options.%d.votes", option)] = -1
+		}
+		for _, option := range chosen {
+			key := fmt.Sprintf("payload.options.%d.votes", option)
+			if delta, _ := inc[key].(int); delta == -1 {
+				delete(inc, key) // chosen again
+			} else {
+				inc[key] = 1
+			}
+		}
+		if voters := boolInt(len(chosen) > 0) - boolInt(len(previous.Options) > 0); voters != 0 {
+			inc["payload.voters"] = voters
+		}
+
+		if len(chosen) == 0 {
+			_, err = votes.DeleteOne(txCtx, bson.M{"_id": voteID})
+		} else {
+			_, err = votes.ReplaceOne(txCtx, bson.M{"_id": voteID}, &models.PollVote{
+				ID:               voteID,
+				MessageID:        messageID,
+				ConversationID:   req.ConversationID,
+				UserID:           userID,
+				Options:          chosen,
+				VotedAt:          now,
+				MessageCreatedAt: message.CreatedAt,
+			}, options.Replace().SetUpsert(true))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record vote: %w", err)
+		}
+
+		if len(inc) == 0 {
+			poll = &current
+			return nil
+		}
+		var updated models.Message
+		err = messages.FindOneAndUpdate(txCtx,
+			bson.M{"_id": messageID, "payload.closedAt": bson.M{"$exists": false}},
+			bson.M{"$inc": inc},
+			options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"type": 1, "payload": 1}),
+		).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			return conflictError("the poll is closed")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to count vote: %w", err)
+		}
+		poll = &models.Poll{}
+		return decodePayload(&updated, models.MessageTypePoll, poll)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishPollUpdate(ctx, req.ConversationID, messageID, poll, false)
+	return poll, nil
+}
+
+// checkVote returns the chosen option indexes, in order, after checking them against the poll
+func checkVote(poll *models.Poll, options []int) ([]int, error) {
+	if len(options) > 1 && !poll.MultiSelect {
+		return nil, validationError("this poll takes a single choice")
+	}
+	chosen := make([]int, 0, len(options))
+	seen := make(map[int]bool)
+	for _, option := range options {
+		if option < 0 || option >= len(poll.Options) {
+			return nil, validationError(fmt.Sprintf("option %d does not exist", option))
+		}
+		if seen[option] {
+			return nil, validationError(fmt.Sprintf("option %d is chosen twice", option))
+		}
+		seen[option] = true
+		chosen = append(chosen, option)
+	}
+	return chosen, nil
+}
+
+// pollClosed reports whether a poll takes no more votes, including one whose close time has
+// passed but that RunPollCloser has not reached yet
+func pollClosed(poll *models.Poll, now time.Time) bool {
+	return poll.ClosedAt != nil || (poll.ClosesAt != nil && !poll.ClosesAt.After(now))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RunPollCloser closes polls whose close time has passed every interval until ctx is cancelled
+func (s *MessageService) RunPollCloser(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		s.logger.Info("Poll closer disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepEachTenant(ctx, s.db, s.logger, s.closeDuePolls)
+		}
+	}
+}
+
+// closeDuePolls marks due polls closed and sends their final tally. The update only matches an
+// open poll, so when several nodes race exactly one announces it.
+func (s *MessageService) closeDuePolls(ctx context.Context) {
+	messages := s.db.Collection(ctx, "messages")
+	now := s.clock.Now()
+
+	cursor, err := messages.Find(ctx,
+		bson.M{
+			"type":             models.MessageTypePoll,
+			"payload.closesAt": bson.M{"$lte": now},
+			"payload.closedAt": bson.M{"$exists": false},
+		},
+		options.Find().SetSort(bson.M{"payload.closesAt": 1}).SetLimit(pollCloseBatchSize))
+	if err != nil {
+		s.logger.Error("Failed to find polls to close", logging.Err(err))
+		return
+	}
+	var due []models.Message
+	if err := cursor.All(ctx, &due); err != nil {
+		s.logger.Error("Failed to decode polls to close", logging.Err(err))
+		return
+	}
+
+	for _, message := range due {
+		var poll models.Poll
+		if err := decodePayload(&message, models.MessageTypePoll, &poll); err != nil {
+			s.logger.Error("Failed to read poll", logging.MessageID, message.ID, logging.Err(err))
+			continue
+		}
+		var closed models.Message
+		err := messages.FindOneAndUpdate(ctx,
+			bson.M{"_id": message.ID, "payload.closedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"payload.closedAt": poll.ClosesAt}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"type": 1, "payload": 1}),
+		).Decode(&closed)
+		if err == mongo.ErrNoDocuments {
+			continue // another node closed it
+		}
+		if err == nil {
+			err = decodePayload(&closed, models.MessageTypePoll, &poll)
+		}
+		if err != nil {
+			s.logger.Error("Failed to close poll", logging.MessageID, message.ID, logging.Err(err))
+			continue
+		}
+		if message.RetractedAt == nil {
+			s.publishPollUpdate(ctx, message.ConversationID, message.ID, &poll, true)
+		}
+	}
+}
+
+func (s *MessageService) publishPollUpdate(ctx context.Context, conversationID string, messageID int64, poll *models.Poll, final bool) {
+	event := &models.WSPollUpdateData{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Poll:           poll,
+		Final:          final,
+	}
+	if err := s.nats.PublishPollUpdate(conversationID, event); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to publish poll update", logging.ConversationID, conversationID, logging.Err(err))
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// Presence statuses, as in presence.update frames. Users' own statuses (models.Status*) travel
+// on chat.conv.<id>.presence.
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// presenceState tracks who is online in a conversation from this node's point of view.
+// Once the conversation's membership exceeds the roll-up threshold, individual updates
+// are accumulated in pending and flushed as a single presence.delta frame per interval.
+type presenceState struct {
+	mu      sync.Mutex
+	rollup  bool
+	online  map[string]bool
+	pending map[string]string // userID -> latest status since the last flush
+}
+
+// refreshMemberCount decides whether a subscription should use presence roll-ups
+func (h *WebSocketHub) refreshMemberCount(sub *ConversationSubscription) {
+	ctx, cancel := context.WithTimeout(tenant.NewContext(context.Background(), sub.workspaceID), 5*time.Second)
+	defer cancel()
+
+	count, err := h.conversationService.CountParticipants(ctx, sub.ConversationID)
+	if err != nil {
+		h.logger.Error("Failed to count participants for presence roll-up", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	sub.presence.mu.Lock()
+	sub.presence.rollup = count > int64(h.config.PresenceRollupThreshold)
+	sub.presence.mu.Unlock()
+}
+
+func (h *WebSocketHub) handlePresenceEvent(sub *ConversationSubscription, data *models.WSPresenceUpdateData) {
+	sub.presence.mu.Lock()
+	if data.Status == PresenceOnline {
+		sub.presence.online[data.UserID] = true
+	} else {
+		delete(sub.presence.online, data.UserID)
+	}
+
+	if sub.presence.rollup {
+		sub.presence.pending[data.UserID] = data.Status
+		sub.presence.mu.Unlock()
+		return
+	}
+	sub.presence.mu.Unlock()
+
+	h.broadcastToSubscription(sub, h.newFrame("presence.update", data))
+}
+
+// sendPresenceSnapshot gives a newly subscribed client the full online set of a rolled-up conversation
+func (h *WebSocketHub) sendPresenceSnapshot(client *Client, sub *ConversationSubscription) {
+	sub.presence.mu.Lock()
+	if !sub.presence.rollup {
+		sub.presence.mu.Unlock()
+		return
+	}
+	online := sortedKeys(sub.presence.online)
+	sub.presence.mu.Unlock()
+
+	client.sendFrame("presence.snapshot", &models.WSPresenceSnapshotData{
+		ConversationID: sub.ConversationID,
+		Online:         online,
+	})
+}
+
+func (h *WebSocketHub) flushPresenceRollups() {
+	h.subsMu.RLock()
+	subs := make([]*ConversationSubscription, 0, len(h.subscriptions))
+	for _, sub := range h.subscriptions {
+		subs = append(subs, sub)
+	}
+	h.subsMu.RUnlock()
+
+	for _, sub := range subs {
+		sub.presence.mu.Lock()
+		if !sub.presence.rollup || len(sub.presence.pending) == 0 {
+			sub.presence.mu.Unlock()
+			continue
+		}
+
+		delta := &models.WSPresenceDeltaData{ConversationID: sub.ConversationID}
+		for userID, status := range sub.presence.pending {
+			if status == PresenceOnline {
+				delta.Online = append(delta.Online, userID)
+			} else {
+				delta.Offline = append(delta.Offline, userID)
+			}
+		}
+		sub.presence.pending = make(map[string]string)
+		sub.presence.mu.Unlock()
+
+		sort.Strings(delta.Online)
+		sort.Strings(delta.Offline)
+
+		h.broadcastToSubscription(sub, h.newFrame("presence.delta", delta))
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetStatus stores the user's status and announces it in every conversation they belong to.
+// Invisible users stop publishing presence, so they show as offline, and their status is
+// hidden from others.
+func (h *WebSocketHub) SetStatus(ctx context.Context, userID string, req *models.UpdateStatusRequest) (*models.User, error) {
+	user, err := h.userService.SetStatus(ctx, userID, req.Status, req.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	conversationIDs, err := h.conversationService.GetUserConversationIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, conversationID := range conversationIDs {
+		data := &models.WSStatusUpdateData{
+			ConversationID: conversationID,
+			UserID:         userID,
+			Status:         req.Status,
+			Message:        req.Message,
+		}
+		if err := h.natsConn.PublishStatus(conversationID, data); err != nil {
+			h.logger.ErrorContext(ctx, "Failed to publish status", logging.ConversationID, conversationID, logging.UserID, userID, logging.Err(err))
+		}
+	}
+	return user, nil
+}
+
+// handleStatusEvent applies a status change to this node's presence for the user, if they
+// are connected here, and passes it on to subscribers
+func (h *WebSocketHub) handleStatusEvent(sub *ConversationSubscription, data []byte) {
+	var status models.WSStatusUpdateData
+	if err := json.Unmarshal(data, &status); err != nil {
+		h.logger.Error("Failed to unmarshal status data", logging.ConversationID, sub.ConversationID, logging.Err(err))
+		return
+	}
+
+	invisible := status.Status == models.StatusInvisible
+	h.clientsMu.Lock()
+	_, connected := h.userClients[status.UserID]
+	if connected {
+		if invisible {
+			h.invisible[status.UserID] = true
+		} else {
+			delete(h.invisible, status.UserID)
+		}
+	}
+	h.clientsMu.Unlock()
+
+	// Each conversation gets its own event, so only this one's presence key changes here
+	if connected && hasMemberClient(sub, status.UserID) {
+		if invisible {
+			h.publishPresence(sub.ConversationID, status.UserID, PresenceOffline)
+		} else {
+			h.publishPresence(sub.ConversationID, status.UserID, PresenceOnline)
+		}
+	}
+
+	if invisible {
+		status.Status = ""
+		status.Message = ""
+	}
+	h.broadcastToSubscription(sub, h.newFrame("presence.status", status))
+}
+
+// loadInvisible reports whether the user's stored status is invisible
+func (h *WebSocketHub) loadInvisible(ctx context.Context, userID string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	user, err := h.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		// Bots and users without a profile yet have no status
+		return false
+	}
+	return user.Status == models.StatusInvisible
+}
+
+func (h *WebSocketHub) isInvisible(userID string) bool {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	return h.invisible[userID]
+}
+
+// hasMemberClient reports whether any of the user's clients follows the conversation as a
+// member rather than through a watch grant
+func hasMemberClient(sub *ConversationSubscription, userID string) bool {
+	sub.ClientsMu.RLock()
+	defer sub.ClientsMu.RUnlock()
+	for _, c := range sub.Clients {
+		if c.UserID == userID && !c.isWatching(sub.ConversationID) {
+			return true
+		}
+	}
+	return false
+}
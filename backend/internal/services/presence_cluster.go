@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Presence is shared between nodes through the presence bucket. A node with a member
+// connection for a user in a conversation keeps a heartbeat key for the pair, refreshed every
+// PresenceTTL/3 and deleted when the user's last local connection leaves. Every node watches
+// the whole bucket, so each knows who is online on any node: a user is online in a
+// conversation while any node's key for them is live, and goes offline when the last one is
+// deleted or stops being refreshed, as when its node dies.
+
+// presenceWriteQueue bounds presence writes waiting for the bucket; overflowing puts are
+// repaired by the next heartbeat and overflowing deletes by expiry
+const presenceWriteQueue = 1024
+
+// presenceRegistry is the cluster-wide online set as seen through the bucket
+type presenceRegistry struct {
+	mu   sync.Mutex
+	seen map[string]map[string]map[string]time.Time // conversation -> user -> node -> last heartbeat
+}
+
+type presenceWrite struct {
+	conversationID string
+	userID         string
+	online         bool
+}
+
+// startPresence opens the presence bucket and begins watching it
+func (h *WebSocketHub) startPresence(ctx context.Context) error {
+	kv, err := h.natsConn.PresenceStore(ctx, h.config.PresenceTTL)
+	if err != nil {
+		return err
+	}
+	watcher, err := kv.WatchAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.presenceKV = kv
+	h.presenceWatcher = watcher
+	h.presenceWrites = make(chan presenceWrite, presenceWriteQueue)
+	h.presenceDone = make(chan struct{})
+	h.presenceStopped = make(chan struct{})
+	go h.watchPresence(watcher)
+	go h.writePresence()
+	return nil
+}
+
+func (h *WebSocketHub) stopPresence() {
+	if h.presenceWatcher == nil {
+		return
+	}
+	h.presenceWatcher.Stop()
+	h.presenceWatcher = nil
+	close(h.presenceDone)
+	<-h.presenceStopped
+}
+
+// publishPresence records that userID came online or went offline in a conversation on this
+// node. Writes are applied in order by writePresence.
+func (h *WebSocketHub) publishPresence(conversationID, userID, status string) {
+	if status == PresenceOnline && h.isInvisible(userID) {
+		return
+	}
+	write := presenceWrite{conversationID: conversationID, userID: userID, online: status == PresenceOnline}
+	select {
+	case h.presenceWrites <- write:
+	default:
+		h.logger.Warn("Presence write queue full, dropping update", logging.ConversationID, conversationID, logging.UserID, userID, "status", status)
+	}
+}
+
+func (h *WebSocketHub) writePresence() {
+	defer close(h.presenceStopped)
+	for {
+		select {
+		case write := <-h.presenceWrites:
+			h.applyPresenceWrite(write)
+		case <-h.presenceDone:
+			// Deletes queued by the shutdown drain let other nodes show users offline at once
+			for {
+				select {
+				case write := <-h.presenceWrites:
+					h.applyPresenceWrite(write)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (h *WebSocketHub) applyPresenceWrite(write presenceWrite) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := presenceKey(write.conversationID, write.userID, h.config.NodeName)
+	var err error
+	if write.online {
+		_, err = h.presenceKV.Put(ctx, key, nil)
+	} else {
+		err = h.presenceKV.Delete(ctx, key)
+	}
+	if err != nil {
+		h.logger.Error("Failed to write presence", logging.ConversationID, write.conversationID, logging.UserID, write.userID, "online", write.online, logging.Err(err))
+	}
+}
+
+// heartbeatPresence refreshes the keys of every user with a member connection on this node
+func (h *WebSocketHub) heartbeatPresence() {
+	type pair struct{ conversationID, userID string }
+	online := make(map[pair]bool)
+
+	h.subsMu.RLock()
+	for conversationID, sub := range h.subscriptions {
+		sub.ClientsMu.RLock()
+		for _, client := range sub.Clients {
+			if !client.isWatching(conversationID) {
+				online[pair{conversationID, client.UserID}] = true
+			}
+		}
+		sub.ClientsMu.RUnlock()
+	}
+	h.subsMu.RUnlock()
+
+	for p := range online {
+		h.publishPresence(p.conversationID, p.userID, PresenceOnline)
+	}
+}
+
+func (h *WebSocketHub) watchPresence(watcher jetstream.KeyWatcher) {
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			// Marks the end of the keys that existed when the watch began
+			continue
+		}
+		conversationID, userID, node, ok := parsePresenceKey(entry.Key())
+		if !ok {
+			continue
+		}
+		if entry.Operation() == jetstream.KeyValuePut {
+			h.presenceSeen(conversationID, userID, node, entry.Created())
+		} else {
+			h.presenceGone(conversationID, userID, node)
+		}
+	}
+}
+
+func (h *WebSocketHub) presenceSeen(conversationID, userID, node string, at time.Time) {
+	r := &h.presenceRegistry
+	r.mu.Lock()
+	if r.seen == nil {
+		r.seen = make(map[string]map[string]map[string]time.Time)
+	}
+	users := r.seen[conversationID]
+	if users == nil {
+		users = make(map[string]map[string]time.Time)
+		r.seen[conversationID] = users
+	}
+	nodes := users[userID]
+	cameOnline := len(nodes) == 0
+	if nodes == nil {
+		nodes = make(map[string]time.Time)
+		users[userID] = nodes
+	}
+	nodes[node] = at
+	r.mu.Unlock()
+
+	if cameOnline {
+		h.notifyPresence(conversationID, userID, PresenceOnline)
+	}
+}
+
+func (h *WebSocketHub) presenceGone(conversationID, userID, node string) {
+	r := &h.presenceRegistry
+	r.mu.Lock()
+	nodes := r.seen[conversationID][userID]
+	if _, ok := nodes[node]; !ok {
+		r.mu.Unlock()
+		return
+	}
+	delete(nodes, node)
+	wentOffline := len(nodes) == 0
+	if wentOffline {
+		r.forgetLocked(conversationID, userID)
+	}
+	r.mu.Unlock()
+
+	if wentOffline {
+		h.notifyPresence(conversationID, userID, PresenceOffline)
+	}
+}
+
+// expirePresence treats heartbeats older than the TTL as gone. The bucket drops them too, but
+// without telling watchers.
+func (h *WebSocketHub) expirePresence() {
+	type pair struct{ conversationID, userID string }
+	var offline []pair
+
+	cutoff := h.clock.Now().Add(-h.config.PresenceTTL)
+	r := &h.presenceRegistry
+	r.mu.Lock()
+	for conversationID, users := range r.seen {
+		for userID, nodes := range users {
+			for node, at := range nodes {
+				if at.Before(cutoff) {
+					delete(nodes, node)
+				}
+			}
+			if len(nodes) == 0 {
+				r.forgetLocked(conversationID, userID)
+				offline = append(offline, pair{conversationID, userID})
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, p := range offline {
+		h.notifyPresence(p.conversationID, p.userID, PresenceOffline)
+	}
+}
+
+func (r *presenceRegistry) forgetLocked(conversationID, userID string) {
+	delete(r.seen[conversationID], userID)
+	if len(r.seen[conversationID]) == 0 {
+		delete(r.seen, conversationID)
+	}
+}
+
+// onlineUsers returns who is online in a conversation on any node
+func (h *WebSocketHub) onlineUsers(conversationID string) map[string]bool {
+	r := &h.presenceRegistry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	online := make(map[string]bool, len(r.seen[conversationID]))
+	for userID := range r.seen[conversationID] {
+		online[userID] = true
+	}
+	return online
+}
+
+// OnlineUsers returns who is online in a conversation on any node, sorted
+func (h *WebSocketHub) OnlineUsers(conversationID string) []string {
+	return sortedKeys(h.onlineUsers(conversationID))
+}
+
+// notifyPresence passes a cluster-wide transition to this node's subscribers, if any
+func (h *WebSocketHub) notifyPresence(conversationID, userID, status string) {
+	h.subsMu.RLock()
+	sub := h.subscriptions[conversationID]
+	h.subsMu.RUnlock()
+	if sub == nil {
+		return
+	}
+
+	h.handlePresenceEvent(sub, &models.WSPresenceUpdateData{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Status:         status,
+	})
+}
+
+// isWatching reports whether the client follows a conversation through a watch grant
+func (c *Client) isWatching(conversationID string) bool {
+	c.subscriptionsMu.RLock()
+	defer c.subscriptionsMu.RUnlock()
+	_, ok := c.watching[conversationID]
+	return ok
+}
+
+func presenceKey(conversationID, userID, node string) string {
+	return bucketKey(conversationID, userID, node)
+}
+
+func parsePresenceKey(key string) (conversationID, userID, node string, ok bool) {
+	parts, ok := parseBucketKey(key, 3)
+	if !ok {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// bucketKey builds a KV key from IDs that may hold characters keys cannot, one token per ID
+func bucketKey(parts ...string) string {
+	tokens := make([]string, len(parts))
+	for i, part := range parts {
+		tokens[i] = base64.RawURLEncoding.EncodeToString([]byte(part))
+	}
+	return strings.Join(tokens, ".")
+}
+
+// parseBucketKey reverses bucketKey for a key of n parts
+func parseBucketKey(key string, n int) ([]string, bool) {
+	tokens := strings.Split(key, ".")
+	if len(tokens) != n {
+		return nil, false
+	}
+	parts := make([]string, n)
+	for i, token := range tokens {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = string(b)
+	}
+	return parts, true
+}
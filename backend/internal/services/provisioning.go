@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/id"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// provisioner is what SCIM and the LDAP sync share: both make group conversations follow a
+// directory's groups and deactivate the users it removes
+type provisioner struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	auditService        *AuditService
+	hub                 *WebSocketHub
+	clock               clock.Clock
+	logger              *slog.Logger
+	ids                 IDGenerator
+}
+
+// activationChanged audits a user's deactivation or reactivation, and on deactivation closes
+// their connections
+func (p *provisioner) activationChanged(ctx context.Context, userID string, deactivated bool, actorID string) {
+	if !deactivated {
+		p.audit(ctx, AuditUserReactivated, actorID, map[string]interface{}{"userId": userID})
+		return
+	}
+	p.audit(ctx, AuditUserDeactivated, actorID, map[string]interface{}{"userId": userID})
+	p.closeSessions(ctx, userID)
+}
+
+// createGroup starts a group conversation managed by a directory; its members are all plain
+// members, as membership follows the directory
+func (p *provisioner) createGroup(ctx context.Context, workspaceID, title string, provisioning *models.Provisioning, memberIDs []string, actorID string) (*models.Conversation, error) {
+	if err := checkGroupSize(ctx, p.db, workspaceID, len(memberIDs)); err != nil {
+		return nil, err
+	}
+
+	now := p.clock.Now()
+	conversation := &models.Conversation{
+		ID:            p.ids.NewID(),
+		WorkspaceID:   workspaceID,
+		Kind:          "group",
+		Title:         title,
+		CreatedAt:     now,
+		LastMessageAt: now,
+		Provisioning:  provisioning,
+	}
+	err := withTransaction(ctx, p.db, func(txCtx mongo.SessionContext) error {
+		if _, err := p.db.Collection(txCtx, "conversations").InsertOne(txCtx, conversation); err != nil {
+			return fmt.Errorf("failed to create conversation: %w", err)
+		}
+		if len(memberIDs) == 0 {
+			return nil
+		}
+		if _, err := p.db.Collection(txCtx, "participants").InsertMany(txCtx, p.participants(conversation.ID, memberIDs)); err != nil {
+			return fmt.Errorf("failed to add participants: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.conversationService.listCache.InvalidateMembers(conversation.ID, memberIDs)
+	p.conversationService.announce(ctx, &models.WSConversationEventData{
+		Event:          models.ConversationCreated,
+		ConversationID: conversation.ID,
+		Conversation:   conversation,
+		UserIDs:        memberIDs,
+		ActorID:        actorID,
+		Recipients:     memberIDs,
+	})
+	return conversation, nil
+}
+
+// closeSessions ends a deactivated user's live connections; new ones are refused at sign-in
+func (p *provisioner) closeSessions(ctx context.Context, userID string) {
+	sessions, err := p.hub.Sessions(ctx, userID)
+	if err != nil {
+		p.logger.ErrorContext(ctx, "Failed to list sessions of deactivated user", logging.UserID, userID, logging.Err(err))
+		return
+	}
+	for _, session := range sessions {
+		if err := p.hub.RevokeSession(ctx, userID, session.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			p.logger.ErrorContext(ctx, "Failed to close session of deactivated user", logging.UserID, userID, logging.Err(err))
+		}
+	}
+}
+
+// rename sets a provisioned group's title and external ID, announcing a new title
+func (p *provisioner) rename(ctx context.Context, conversation *models.Conversation, displayName, externalID, actorID string) error {
+	if displayName == conversation.Title && externalID == conversation.Provisioning.ExternalID {
+		return nil
+	}
+	_, err := p.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversation.ID},
+		bson.M{"$set": bson.M{"title": displayName, "provisioning.externalId": externalID}})
+	if err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+	renamed := displayName != conversation.Title
+	conversation.Title = displayName
+	conversation.Provisioning.ExternalID = externalID
+	if !renamed {
+		return nil
+	}
+
+	recipients, err := p.conversationService.participantUserIDs(ctx, conversation.ID)
+	if err != nil {
+		return err
+	}
+	p.conversationService.listCache.InvalidateMembers(conversation.ID, recipients)
+	p.conversationService.announce(ctx, &models.WSConversationEventData{
+		Event:          models.ConversationUpdated,
+		ConversationID: conversation.ID,
+		Conversation:   conversation,
+		ActorID:        actorID,
+		Recipients:     recipients,
+	})
+	return nil
+}
+
+// setMembers makes a group's participants exactly memberIDs, announcing who joined and left
+func (p *provisioner) setMembers(ctx context.Context, conversation *models.Conversation, memberIDs []string, actorID string) error {
+	current, err := p.conversationService.participantUserIDs(ctx, conversation.ID)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(memberIDs))
+	for _, userID := range memberIDs {
+		wanted[userID] = true
+	}
+	existing := make(map[string]bool, len(current))
+	var removed []string
+	for _, userID := range current {
+		existing[userID] = true
+		if !wanted[userID] {
+			removed = append(removed, userID)
+		}
+	}
+	var added []string
+	for _, userID := range memberIDs {
+		if !existing[userID] {
+			added = append(added, userID)
+		}
+	}
+
+	if len(added) > 0 {
+		if err := checkGroupSize(ctx, p.db, conversation.WorkspaceID, len(memberIDs)); err != nil {
+			return err
+		}
+		_, err := p.db.Collection(ctx, "participants").InsertMany(ctx, p.participants(conversation.ID, added),
+			options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to add participants: %w", err)
+		}
+		p.conversationService.listCache.InvalidateMembers(conversation.ID, added)
+		p.conversationService.announce(ctx, &models.WSConversationEventData{
+			Event:          models.MemberAdded,
+			ConversationID: conversation.ID,
+			Conversation:   conversation,
+			UserIDs:        added,
+			ActorID:        actorID,
+			Recipients:     append(current, added...),
+		})
+	}
+	if len(removed) > 0 {
+		participantIDs := make([]string, len(removed))
+		for i, userID := range removed {
+			participantIDs[i] = id.Participant(conversation.ID, userID)
+		}
+		if _, err := p.db.Collection(ctx, "participants").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": participantIDs}}); err != nil {
+			return fmt.Errorf("failed to remove participants: %w", err)
+		}
+		p.conversationService.listCache.InvalidateMembers(conversation.ID, removed)
+		p.conversationService.announce(ctx, &models.WSConversationEventData{
+			Event:          models.MemberRemoved,
+			ConversationID: conversation.ID,
+			UserIDs:        removed,
+			ActorID:        actorID,
+			Recipients:     current, // still includes the removed users
+		})
+	}
+	return nil
+}
+
+func (p *provisioner) participants(conversationID string, userIDs []string) []interface{} {
+	now := p.clock.Now()
+	participants := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		participants[i] = &models.Participant{
+			ID:             id.Participant(conversationID, userID),
+			ConversationID: conversationID,
+			UserID:         userID,
+			Role:           models.ConversationRoleMember,
+			JoinedAt:       now,
+		}
+	}
+	return participants
+}
+
+func (p *provisioner) audit(ctx context.Context, action, actorID string, details map[string]interface{}) {
+	if err := p.auditService.Record(ctx, action, actorID, "", details); err != nil {
+		p.logger.ErrorContext(ctx, "Failed to audit", "action", action, logging.UserID, actorID, logging.Err(err))
+	}
+}
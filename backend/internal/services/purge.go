@@ -0,0 +1,375 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Purge job statuses
+const (
+	PurgeAwaitingConfirmation = "awaiting_confirmation"
+	PurgeRunning              = "running"
+	PurgeCompleted            = "completed"
+	PurgeFailed               = "failed"
+)
+
+const (
+	purgeTokenTTL       = 15 * time.Minute
+	purgeBatchSize      = 1000
+	purgeJobsCollection = "purge_jobs"
+)
+
+// purgeStages lists the collections a purge empties, children before parents, so an
+// interrupted purge never leaves messages or memberships pointing at deleted conversations
+var purgeStages = []string{messageRevisionsCollection, pollVotesCollection, callsCollection, "messages", attachmentReviewsCollection, "attachments", "participants", "conversations", "users"}
+
+// PurgeService deletes all of a workspace's data. A dry run records a job with the counts and a
+// single-use confirmation token; confirming queues the job, which deletes in batches and
+// persists progress after each one. Jobs left running by a restart are resumed by Run.
+type PurgeService struct {
+	db           *database.MongoDB
+	userService  *UserService
+	auditService *AuditService
+	clock        clock.Clock
+	logger       *slog.Logger
+	ids          IDGenerator
+	jobs         chan *models.PurgeJob
+}
+
+func NewPurgeService(db *database.MongoDB, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *PurgeService {
+	return &PurgeService{
+		db:           db,
+		userService:  userService,
+		auditService: auditService,
+		clock:        clk,
+		logger:       logger,
+		ids:          ids,
+		jobs:         make(chan *models.PurgeJob, 8),
+	}
+}
+
+// DryRun reports what a purge would delete and issues a confirmation token for it
+func (s *PurgeService) DryRun(ctx context.Context, actorID string) (*models.PurgeDryRunResponse, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	var estimated models.PurgeCounts
+	var reclaimable int64
+	for _, name := range purgeStages {
+		filter, err := s.purgeFilter(ctx, name, actor.WorkspaceID, actorID)
+		if err != nil {
+			return nil, err
+		}
+		count, err := s.db.Collection(ctx, name).CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", name, err)
+		}
+		*purgeCounter(&estimated, name) = count
+
+		// Collections are shared by every workspace, so the workspace's share of the storage is
+		// estimated from its share of the documents
+		size, err := s.storageSize(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		total, err := s.db.Collection(ctx, name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", name, err)
+		}
+		if total > 0 {
+			reclaimable += int64(float64(size) * min(float64(count)/float64(total), 1))
+		}
+	}
+
+	token, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	job := &models.PurgeJob{
+		ID:               s.ids.NewID(),
+		Status:           PurgeAwaitingConfirmation,
+		RequestedBy:      actorID,
+		WorkspaceID:      actor.WorkspaceID,
+		TokenHash:        hashSecret(token),
+		TokenExpiresAt:   now.Add(purgeTokenTTL),
+		Estimated:        estimated,
+		ReclaimableBytes: reclaimable,
+		CreatedAt:        now,
+	}
+
+	if _, err := s.db.Collection(ctx, purgeJobsCollection).InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create purge job: %w", err)
+	}
+
+	return &models.PurgeDryRunResponse{PurgeJob: job, ConfirmationToken: token}, nil
+}
+
+// Confirm starts the purge issued to actorID under the given confirmation token
+func (s *PurgeService) Confirm(ctx context.Context, actorID, token string) (*models.PurgeJob, error) {
+	if _, err := requireWorkspaceAdmin(ctx, s.userService, actorID); err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	filter := bson.M{
+		"tokenHash":      hashSecret(token),
+		"requestedBy":    actorID,
+		"status":         PurgeAwaitingConfirmation,
+		"tokenExpiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"status": PurgeRunning, "startedAt": now}}
+
+	var job models.PurgeJob
+	err := s.db.Collection(ctx, purgeJobsCollection).FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, conflictError("invalid or expired confirmation token")
+		}
+		return nil, fmt.Errorf("failed to confirm purge: %w", err)
+	}
+
+	if err := s.auditService.Record(ctx, AuditWorkspacePurgeStarted, actorID, "", map[string]interface{}{
+		"jobId":     job.ID,
+		"estimated": job.Estimated,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit purge start", logging.JobID, job.ID, logging.Err(err))
+	}
+
+	select {
+	case s.jobs <- &job:
+	case <-ctx.Done():
+		// The job stays running and is picked up on the next start
+	}
+	return &job, nil
+}
+
+// GetJob returns a purge job's progress
+func (s *PurgeService) GetJob(ctx context.Context, jobID, actorID string) (*models.PurgeJob, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.PurgeJob
+	err = s.db.Collection(ctx, purgeJobsCollection).FindOne(ctx,
+		bson.M{"_id": jobID, "workspaceId": actor.WorkspaceID}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("purge job not found")
+		}
+		return nil, fmt.Errorf("failed to get purge job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// Run executes confirmed purge jobs until ctx is cancelled, first resuming any interrupted ones
+func (s *PurgeService) Run(ctx context.Context) {
+	err := s.db.EachTenant(ctx, func(ctx context.Context) error {
+		cursor, err := s.db.Collection(ctx, purgeJobsCollection).Find(ctx, bson.M{"status": PurgeRunning})
+		if err != nil {
+			return fmt.Errorf("failed to look up interrupted purge jobs: %w", err)
+		}
+		var interrupted []models.PurgeJob
+		if err := cursor.All(ctx, &interrupted); err != nil {
+			return fmt.Errorf("failed to decode interrupted purge jobs: %w", err)
+		}
+		for _, job := range interrupted {
+			s.logger.Info("Resuming purge job", logging.JobID, job.ID)
+			s.execute(ctx, job.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Failed to resume purge jobs", logging.Err(err))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.jobs:
+			s.execute(tenant.NewContext(ctx, job.WorkspaceID), job.ID)
+		}
+	}
+}
+
+func (s *PurgeService) execute(ctx context.Context, jobID string) {
+	jobs := s.db.Collection(ctx, purgeJobsCollection)
+
+	var job models.PurgeJob
+	if err := jobs.FindOne(ctx, bson.M{"_id": jobID}).Decode(&job); err != nil {
+		s.logger.Error("Failed to load purge job", logging.JobID, jobID, logging.Err(err))
+		return
+	}
+
+	for _, name := range purgeStages {
+		if err := s.purgeCollection(ctx, &job, name); err != nil {
+			if ctx.Err() != nil {
+				// Shutting down; the job stays running and is resumed on the next start
+				return
+			}
+			s.logger.Error("Purge job failed", logging.JobID, jobID, "stage", name, logging.Err(err))
+			jobs.UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": jobID}, bson.M{"$set": bson.M{
+				"status": PurgeFailed,
+				"error":  err.Error(),
+			}})
+			return
+		}
+	}
+
+	completedAt := s.clock.Now()
+	_, err := jobs.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{
+		"$set":   bson.M{"status": PurgeCompleted, "completedAt": completedAt},
+		"$unset": bson.M{"stage": ""},
+	})
+	if err != nil {
+		s.logger.Error("Failed to mark purge job completed", logging.JobID, jobID, logging.Err(err))
+	}
+
+	if err := s.auditService.Record(ctx, AuditWorkspacePurgeCompleted, job.RequestedBy, "", map[string]interface{}{
+		"jobId":   job.ID,
+		"deleted": job.Deleted,
+	}); err != nil {
+		s.logger.Error("Failed to audit purge completion", logging.JobID, jobID, logging.Err(err))
+	}
+}
+
+// purgeCollection deletes one collection in batches, recording progress after each batch.
+// Deleting by looked-up IDs keeps each batch idempotent, so a resumed job simply continues.
+func (s *PurgeService) purgeCollection(ctx context.Context, job *models.PurgeJob, name string) error {
+	collection := s.db.Collection(ctx, name)
+	jobs := s.db.Collection(ctx, purgeJobsCollection)
+
+	filter, err := s.purgeFilter(ctx, name, job.WorkspaceID, job.RequestedBy)
+	if err != nil {
+		return err
+	}
+
+	if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"stage": name}}); err != nil {
+		return fmt.Errorf("failed to update purge progress: %w", err)
+	}
+
+	for {
+		cursor, err := collection.Find(ctx, filter, options.Find().
+			SetProjection(bson.M{"_id": 1}).
+			SetLimit(purgeBatchSize))
+		if err != nil {
+			return fmt.Errorf("failed to find %s to purge: %w", name, err)
+		}
+
+		var batch []bson.M
+		if err := cursor.All(ctx, &batch); err != nil {
+			return fmt.Errorf("failed to decode %s to purge: %w", name, err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]interface{}, len(batch))
+		for i, doc := range batch {
+			ids[i] = doc["_id"]
+		}
+
+		result, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", name, err)
+		}
+
+		*purgeCounter(&job.Deleted, name) += result.DeletedCount
+		_, err = jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{
+			"$inc": bson.M{"deleted." + name: result.DeletedCount},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update purge progress: %w", err)
+		}
+	}
+}
+
+// storageSize returns the bytes of data and indexes held by a collection; missing collections count as zero
+func (s *PurgeService) storageSize(ctx context.Context, name string) (int64, error) {
+	var stats struct {
+		Size           int64 `bson:"size"`
+		TotalIndexSize int64 `bson:"totalIndexSize"`
+	}
+
+	collection := s.db.Collection(ctx, name)
+	err := collection.Database().RunCommand(ctx, bson.D{{Key: "collStats", Value: collection.Name()}}).Decode(&stats)
+	if err != nil {
+		if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 26 { // NamespaceNotFound
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read storage stats for %s: %w", name, err)
+	}
+
+	return stats.Size + stats.TotalIndexSize, nil
+}
+
+// purgeFilter selects what a purge deletes from a collection: the workspace's users and
+// conversations, and everything belonging to those conversations. The requesting admin's user
+// record is kept so they can still follow the job. Conversations go after their children, so
+// the list of them is still complete when a resumed job reaches any child stage.
+func (s *PurgeService) purgeFilter(ctx context.Context, name, workspaceID, requestedBy string) (bson.M, error) {
+	switch name {
+	case "users":
+		return bson.M{"workspaceId": workspaceID, "_id": bson.M{"$ne": requestedBy}}, nil
+	case "conversations":
+		return bson.M{"workspaceId": workspaceID}, nil
+	}
+
+	cursor, err := s.db.Collection(ctx, "conversations").Find(ctx, bson.M{"workspaceId": workspaceID},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find workspace conversations: %w", err)
+	}
+	var conversations []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode workspace conversations: %w", err)
+	}
+	ids := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+	}
+	return bson.M{"conversationId": bson.M{"$in": ids}}, nil
+}
+
+func purgeCounter(counts *models.PurgeCounts, name string) *int64 {
+	switch name {
+	case messageRevisionsCollection:
+		return &counts.MessageRevisions
+	case pollVotesCollection:
+		return &counts.PollVotes
+	case callsCollection:
+		return &counts.Calls
+	case "messages":
+		return &counts.Messages
+	case attachmentReviewsCollection:
+		return &counts.AttachmentReviews
+	case "attachments":
+		return &counts.Attachments
+	case "participants":
+		return &counts.Participants
+	case "conversations":
+		return &counts.Conversations
+	default:
+		return &counts.Users
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const repairBatchSize = 1000
+
+// RepairOrphans removes data left behind by conversation creations that failed part-way before
+// they were transactional: participants whose conversation does not exist, and conversations
+// (with their messages) that have no participants. Every workspace is repaired. Groups
+// provisioned over SCIM are left alone, as their provider may empty them.
+func (s *PurgeService) RepairOrphans(ctx context.Context, actorID string) (*models.OrphanRepairReport, error) {
+	if err := requireDeploymentAdmin(ctx, s.userService, actorID); err != nil {
+		return nil, err
+	}
+
+	report := &models.OrphanRepairReport{}
+	err := s.db.EachTenant(ctx, func(ctx context.Context) error {
+		return s.repairTenant(ctx, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.auditService.Record(ctx, AuditWorkspaceOrphansRepaired, actorID, "", map[string]interface{}{
+		"participants":  report.Participants,
+		"conversations": report.Conversations,
+		"messages":      report.Messages,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit orphan repair", logging.Err(err))
+	}
+
+	return report, nil
+}
+
+// repairTenant repairs one workspace's collections, adding what it removed to report
+func (s *PurgeService) repairTenant(ctx context.Context, report *models.OrphanRepairReport) error {
+	participants := s.db.Collection(ctx, "participants")
+	conversations := s.db.Collection(ctx, "conversations")
+
+	for {
+		ids, err := orphanIDs(ctx, participants, bson.M{}, conversations.Name(), "conversationId", "_id")
+		if err != nil {
+			return fmt.Errorf("failed to find orphaned participants: %w", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		result, err := participants.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned participants: %w", err)
+		}
+		report.Participants += result.DeletedCount
+	}
+
+	for {
+		ids, err := orphanIDs(ctx, conversations, bson.M{"provisioning": bson.M{"$exists": false}}, participants.Name(), "_id", "conversationId")
+		if err != nil {
+			return fmt.Errorf("failed to find orphaned conversations: %w", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		result, err := s.db.Collection(ctx, "messages").DeleteMany(ctx, bson.M{"conversationId": bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned messages: %w", err)
+		}
+		report.Messages += result.DeletedCount
+		if _, err := s.db.Collection(ctx, messageRevisionsCollection).DeleteMany(ctx, bson.M{"conversationId": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to delete orphaned message revisions: %w", err)
+		}
+		if _, err := s.db.Collection(ctx, pollVotesCollection).DeleteMany(ctx, bson.M{"conversationId": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to delete orphaned poll votes: %w", err)
+		}
+
+		result, err = conversations.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned conversations: %w", err)
+		}
+		report.Conversations += result.DeletedCount
+	}
+	return nil
+}
+
+// orphanIDs returns up to repairBatchSize IDs of documents in collection matching filter with
+// no match in foreign (localField = foreignField)
+func orphanIDs(ctx context.Context, collection *mongo.Collection, filter bson.M, foreign, localField, foreignField string) ([]interface{}, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         foreign,
+			"localField":   localField,
+			"foreignField": foreignField,
+			"as":           "owner",
+			"pipeline":     bson.A{bson.M{"$limit": 1}, bson.M{"$project": bson.M{"_id": 1}}},
+		}}},
+		{{Key: "$match", Value: bson.M{"owner": bson.M{"$size": 0}}}},
+		{{Key: "$limit", Value: repairBatchSize}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc["_id"]
+	}
+	return ids, nil
+}
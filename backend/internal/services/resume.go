@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+)
+
+const (
+	// maxResumeReplay bounds how many messages a resume replays per conversation
+	maxResumeReplay = 500
+	// resumeSendTimeout is how long a replay waits on a slow client before dropping it
+	resumeSendTimeout = 5 * time.Second
+)
+
+// errClientDropped means the client was disconnected for not keeping up with a replay
+var errClientDropped = errors.New("client dropped during replay")
+
+// handleResume replays messages missed while the client was disconnected, then subscribes it
+// for live delivery. A second pass after subscribing closes the window between the two, so a
+// message may arrive twice; clients dedupe on message ID.
+func (c *Client) handleResume(frame *models.WSFrame) {
+	var data models.WSResumeData
+	dataBytes, err := json.Marshal(frame.Data)
+	if err != nil {
+		c.sendError("INVALID_DATA", "Invalid resume data format")
+		return
+	}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		c.sendError("INVALID_DATA", "Invalid resume data")
+		return
+	}
+
+	ctx := c.context()
+	for _, position := range data.Conversations {
+		if err := c.authorizeBot(ctx, position.ConversationID, models.BotAccessRead); err != nil {
+			c.sendError(ErrorCode(err, "RESUME_FAILED"), PublicMessage(err, "Failed to resume"))
+			continue
+		}
+		grant, err := c.Hub.watchService.AuthorizeRead(ctx, position.ConversationID, c.UserID, "websocket")
+		if err != nil {
+			c.sendError(ErrorCode(err, "RESUME_FAILED"), PublicMessage(err, "Failed to resume"))
+			continue
+		}
+
+		replayed, truncated, err := c.replay(ctx, position.ConversationID, int64(position.LastMessageID))
+		if err == errClientDropped {
+			return
+		}
+		if err != nil {
+			c.sendError(ErrorCode(err, "RESUME_FAILED"), PublicMessage(err, "Failed to replay messages"))
+			continue
+		}
+
+		c.Hub.subscribeClient(c, position.ConversationID, grant)
+
+		if !truncated {
+			lastID := int64(position.LastMessageID)
+			if len(replayed) > 0 {
+				lastID = replayed[len(replayed)-1].ID
+			}
+			caughtUp, _, err := c.replay(ctx, position.ConversationID, lastID)
+			if err == errClientDropped {
+				return
+			}
+			replayed = append(replayed, caughtUp...)
+		}
+
+		c.sendFrame("resume.done", &models.WSResumeDoneData{
+			ConversationID: position.ConversationID,
+			Replayed:       len(replayed),
+			Truncated:      truncated,
+		})
+	}
+}
+
+// replay sends the messages after lastMessageID as message.new frames, and retractions and
+// updates of earlier ones as message.retracted and message.updated, reporting whether it was
+// truncated
+func (c *Client) replay(ctx context.Context, conversationID string, lastMessageID int64) ([]models.WSMessageNewData, bool, error) {
+	replay, err := c.Hub.messageService.ReplaySince(ctx, conversationID, lastMessageID, maxResumeReplay)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := range replay.Retracted {
+		if !c.sendFrameWait("message.retracted", &replay.Retracted[i], resumeSendTimeout) {
+			return nil, false, errClientDropped
+		}
+	}
+	for i := range replay.Updated {
+		if !c.sendFrameWait("message.updated", &replay.Updated[i], resumeSendTimeout) {
+			return nil, false, errClientDropped
+		}
+	}
+	for i := range replay.Messages {
+		if !c.sendFrameWait("message.new", &replay.Messages[i], resumeSendTimeout) {
+			return nil, false, errClientDropped
+		}
+	}
+
+	return replay.Messages, replay.More, nil
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetentionPolicy holds the workspace-wide retention rules, in days
+type RetentionPolicy struct {
+	DefaultDays int // built-in default, below the workspace settings; 0 keeps messages indefinitely
+	MinDays     int // lower bound for conversation overrides
+	MaxDays     int // upper bound for conversation overrides; 0 means unbounded
+}
+
+type RetentionService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	userService         *UserService
+	auditService        *AuditService
+	settingsService     *SettingsService
+	clock               clock.Clock
+	logger              *slog.Logger
+	policy              RetentionPolicy
+}
+
+func NewRetentionService(db *database.MongoDB, conversationService *ConversationService, userService *UserService, auditService *AuditService, settingsService *SettingsService, clk clock.Clock, logger *slog.Logger, policy RetentionPolicy) *RetentionService {
+	return &RetentionService{
+		db:                  db,
+		conversationService: conversationService,
+		userService:         userService,
+		auditService:        auditService,
+		settingsService:     settingsService,
+		clock:               clk,
+		logger:              logger,
+		policy:              policy,
+	}
+}
+
+func (s *RetentionService) GetRetention(ctx context.Context, conversationID, userID string) (*models.ConversationRetention, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := s.settingsService.workspace(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.describe(workspace, conversation), nil
+}
+
+// UpdateRetention changes a conversation's retention override. Lengthening (or clearing) applies
+// immediately; shortening requires compliance approval unless the actor holds the compliance role.
+// The returned bool reports whether the change is pending approval.
+func (s *RetentionService) UpdateRetention(ctx context.Context, conversationID, actorID string, days int) (*models.ConversationRetention, bool, error) {
+	if _, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.ManageConversation, "only admins can change retention"); err != nil {
+		return nil, false, err
+	}
+
+	if days < 0 || (days > 0 && (days < s.policy.MinDays || (s.policy.MaxDays > 0 && days > s.policy.MaxDays))) {
+		return nil, false, validationError("retention outside workspace policy bounds")
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	actor, err := s.userService.GetUserByID(ctx, actorID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	workspace, err := s.settingsService.workspace(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	previous := s.effectiveDays(workspace, conversation)
+	next := days
+	if days == 0 {
+		// Clearing the override falls back to the workspace default
+		next = s.settingsService.resolveWith(workspace, nil, nil).RetentionDays
+	}
+
+	if isShorterRetention(next, previous) && !permissions.CheckPermission(nil, actor, permissions.ApproveRetention) {
+		pending := &models.PendingRetentionChange{
+			RetentionDays: days,
+			RequestedBy:   actorID,
+			RequestedAt:   s.clock.Now(),
+		}
+		if err := s.setPending(ctx, conversationID, pending); err != nil {
+			return nil, false, err
+		}
+		s.audit(ctx, AuditRetentionRequested, actorID, conversationID, map[string]interface{}{
+			"fromDays": previous,
+			"toDays":   next,
+		})
+
+		conversation.PendingRetention = pending
+		return s.describe(workspace, conversation), true, nil
+	}
+
+	if err := s.apply(ctx, conversationID, days); err != nil {
+		return nil, false, err
+	}
+	s.audit(ctx, AuditRetentionUpdated, actorID, conversationID, map[string]interface{}{
+		"fromDays": previous,
+		"toDays":   next,
+	})
+
+	conversation.RetentionDays = days
+	conversation.PendingRetention = nil
+	return s.describe(workspace, conversation), false, nil
+}
+
+// ApproveRetention applies a pending retention shortening on behalf of a compliance officer
+func (s *RetentionService) ApproveRetention(ctx context.Context, conversationID, approverID string) (*models.ConversationRetention, error) {
+	conversation, err := s.pendingForReview(ctx, conversationID, approverID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := s.settingsService.workspace(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := conversation.PendingRetention
+	if err := s.apply(ctx, conversationID, pending.RetentionDays); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, AuditRetentionApproved, approverID, conversationID, map[string]interface{}{
+		"fromDays":    s.effectiveDays(workspace, conversation),
+		"toDays":      pending.RetentionDays,
+		"requestedBy": pending.RequestedBy,
+	})
+
+	conversation.RetentionDays = pending.RetentionDays
+	conversation.PendingRetention = nil
+	return s.describe(workspace, conversation), nil
+}
+
+// RejectRetention discards a pending retention shortening
+func (s *RetentionService) RejectRetention(ctx context.Context, conversationID, approverID string) (*models.ConversationRetention, error) {
+	conversation, err := s.pendingForReview(ctx, conversationID, approverID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := s.settingsService.workspace(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := conversation.PendingRetention
+	if err := s.setPending(ctx, conversationID, nil); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, AuditRetentionRejected, approverID, conversationID, map[string]interface{}{
+		"toDays":      pending.RetentionDays,
+		"requestedBy": pending.RequestedBy,
+	})
+
+	conversation.PendingRetention = nil
+	return s.describe(workspace, conversation), nil
+}
+
+// Sweep deletes messages older than their conversation's effective retention, with their
+// revisions and poll votes
+func (s *RetentionService) Sweep(ctx context.Context) error {
+	conversationsCollection := s.db.Collection(ctx, "conversations")
+	messagesCollection := s.db.Collection(ctx, "messages")
+
+	workspaces, err := s.settingsService.workspaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Conversations without an override only need visiting where their workspace's default expires messages
+	filter := bson.M{"retentionDays": bson.M{"$gt": 0}}
+	if s.settingsService.resolveWith(nil, nil, nil).RetentionDays > 0 {
+		filter = bson.M{}
+	} else {
+		var expiring []string
+		for workspaceID, workspace := range workspaces {
+			if s.settingsService.resolveWith(workspace, nil, nil).RetentionDays > 0 {
+				expiring = append(expiring, workspaceID)
+			}
+		}
+		if len(expiring) > 0 {
+			filter = bson.M{"$or": bson.A{filter, bson.M{"workspaceId": bson.M{"$in": expiring}}}}
+		}
+	}
+
+	cursor, err := conversationsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"retentionDays": 1, "workspaceId": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find conversations for retention sweep: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	now := s.clock.Now()
+	for cursor.Next(ctx) {
+		var conversation models.Conversation
+		if err := cursor.Decode(&conversation); err != nil {
+			return fmt.Errorf("failed to decode conversation: %w", err)
+		}
+
+		days := s.effectiveDays(workspaces[conversation.WorkspaceID], &conversation)
+		if days == 0 {
+			continue
+		}
+
+		cutoff := now.AddDate(0, 0, -days)
+		_, err := messagesCollection.DeleteMany(ctx, bson.M{
+			"conversationId": conversation.ID,
+			"createdAt":      bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete expired messages: %w", err)
+		}
+		_, err = s.db.Collection(ctx, messageRevisionsCollection).DeleteMany(ctx, bson.M{
+			"conversationId":   conversation.ID,
+			"messageCreatedAt": bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete expired message revisions: %w", err)
+		}
+		_, err = s.db.Collection(ctx, pollVotesCollection).DeleteMany(ctx, bson.M{
+			"conversationId":   conversation.ID,
+			"messageCreatedAt": bson.M{"$lt": cutoff},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete expired poll votes: %w", err)
+		}
+	}
+
+	return cursor.Err()
+}
+
+// RunSweeper runs Sweep on the given interval until ctx is cancelled
+func (s *RetentionService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.db.EachTenant(ctx, s.Sweep); err != nil {
+				s.logger.Error("Retention sweep failed", logging.Err(err))
+			}
+		}
+	}
+}
+
+func (s *RetentionService) pendingForReview(ctx context.Context, conversationID, approverID string) (*models.Conversation, error) {
+	approver, err := s.userService.GetUserByID(ctx, approverID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPermission(nil, approver, permissions.ApproveRetention) {
+		return nil, forbiddenError("compliance role required")
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.WorkspaceID != approver.WorkspaceID {
+		return nil, notFoundError("conversation not found")
+	}
+	if conversation.PendingRetention == nil {
+		return nil, conflictError("no pending retention change")
+	}
+
+	return conversation, nil
+}
+
+func (s *RetentionService) apply(ctx context.Context, conversationID string, days int) error {
+	collection := s.db.Collection(ctx, "conversations")
+
+	update := bson.M{"$unset": bson.M{"pendingRetention": ""}}
+	if days > 0 {
+		update["$set"] = bson.M{"retentionDays": days}
+	} else {
+		update["$unset"] = bson.M{"pendingRetention": "", "retentionDays": ""}
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": conversationID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update retention: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RetentionService) setPending(ctx context.Context, conversationID string, pending *models.PendingRetentionChange) error {
+	collection := s.db.Collection(ctx, "conversations")
+
+	update := bson.M{"$unset": bson.M{"pendingRetention": ""}}
+	if pending != nil {
+		update = bson.M{"$set": bson.M{"pendingRetention": pending}}
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": conversationID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update pending retention: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RetentionService) audit(ctx context.Context, action, actorID, conversationID string, details map[string]interface{}) {
+	if err := s.auditService.Record(ctx, action, actorID, conversationID, details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", action, logging.UserID, actorID, logging.ConversationID, conversationID, logging.Err(err))
+	}
+}
+
+// effectiveDays resolves a conversation's retention through the settings cascade, given the
+// workspace level
+func (s *RetentionService) effectiveDays(workspace *models.Settings, conversation *models.Conversation) int {
+	return s.settingsService.resolveWith(workspace, conversation, nil).RetentionDays
+}
+
+func (s *RetentionService) describe(workspace *models.Settings, conversation *models.Conversation) *models.ConversationRetention {
+	return &models.ConversationRetention{
+		ConversationID: conversation.ID,
+		RetentionDays:  conversation.RetentionDays,
+		EffectiveDays:  s.effectiveDays(workspace, conversation),
+		MinDays:        s.policy.MinDays,
+		MaxDays:        s.policy.MaxDays,
+		Pending:        conversation.PendingRetention,
+	}
+}
+
+// isShorterRetention reports whether next keeps messages for less time than previous (0 = forever)
+func isShorterRetention(next, previous int) bool {
+	return next > 0 && (previous == 0 || next < previous)
+}
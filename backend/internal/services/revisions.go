@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const messageRevisionsCollection = "message_revisions"
+
+// archiveRevision stores message's current body as its next revision before an edit replaces
+// it. Call it in the edit's transaction, with the message as read there, so concurrent edits
+// conflict on the revision ID instead of losing a version.
+func (s *MessageService) archiveRevision(ctx context.Context, message *models.Message, replacedBy string) error {
+	revisions := s.db.Collection(ctx, messageRevisionsCollection)
+
+	revision := 1
+	writtenAt := message.CreatedAt
+	var latest models.MessageRevision
+	err := revisions.FindOne(ctx, bson.M{"messageId": message.ID},
+		options.FindOne().SetSort(bson.M{"revision": -1})).Decode(&latest)
+	switch {
+	case err == nil:
+		revision = latest.Revision + 1
+		writtenAt = *latest.ReplacedAt
+	case err != mongo.ErrNoDocuments:
+		return fmt.Errorf("failed to find message revisions: %w", err)
+	}
+
+	now := s.clock.Now()
+	_, err = revisions.InsertOne(ctx, &models.MessageRevision{
+		ID:               fmt.Sprintf("%d:%d", message.ID, revision),
+		MessageID:        message.ID,
+		ConversationID:   message.ConversationID,
+		MessageCreatedAt: message.CreatedAt,
+		Revision:         revision,
+		Body:             message.Body,
+		WrittenAt:        writtenAt,
+		ReplacedAt:       &now,
+		ReplacedBy:       replacedBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive message revision: %w", err)
+	}
+	return nil
+}
+
+// GetHistory returns every version of a message. Callers check that the user may read the
+// returned conversation before showing it.
+func (s *MessageService) GetHistory(ctx context.Context, messageID int64) (*models.MessageHistory, error) {
+	var message models.Message
+	err := s.db.Collection(ctx, "messages").FindOne(ctx, bson.M{
+		"_id":         messageID,
+		"retractedAt": bson.M{"$exists": false},
+	}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("message not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message: %w", err)
+	}
+
+	cursor, err := s.db.Collection(ctx, messageRevisionsCollection).Find(ctx,
+		bson.M{"messageId": messageID},
+		options.Find().SetSort(bson.M{"revision": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message revisions: %w", err)
+	}
+	revisions := []models.MessageRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, fmt.Errorf("failed to decode message revisions: %w", err)
+	}
+
+	current := models.MessageRevision{
+		Revision:  len(revisions) + 1,
+		Body:      message.Body,
+		WrittenAt: message.CreatedAt,
+	}
+	if n := len(revisions); n > 0 {
+		current.WrittenAt = *revisions[n-1].ReplacedAt
+	}
+
+	return &models.MessageHistory{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Revisions:      revisions,
+		Current:        current,
+	}, nil
+}
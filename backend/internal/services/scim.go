@@ -0,0 +1,788 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SCIM 2.0 lets a workspace's identity provider create, update and deprovision its users and
+// keep group conversations in step with its groups. The provider authenticates with a bearer
+// token a workspace admin issues, which also names the workspace.
+//
+// A SCIM user is a user of this service, with the same ID: tokens identify users by subject, so
+// the provider must send that subject as externalId (or as userName, when it has no externalId).
+// Deprovisioning only deactivates: the user's messages and memberships stay, their tokens and
+// API keys are refused, and their connections are closed.
+
+const (
+	scimTokenPrefix = "scim_"
+	// scimActor is the audit actor for changes the identity provider makes
+	scimActor = "scim"
+
+	scimDefaultCount = 100
+	scimMaxCount     = 200
+)
+
+// scimFilterPattern is the one filter form supported, `attribute eq "value"`, as providers use
+// to look a resource up before creating it
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+type SCIMService struct {
+	provisioner
+	userService *UserService
+}
+
+func NewSCIMService(db *database.MongoDB, userService *UserService, conversationService *ConversationService, auditService *AuditService, hub *WebSocketHub, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *SCIMService {
+	return &SCIMService{
+		provisioner: provisioner{
+			db:                  db,
+			conversationService: conversationService,
+			auditService:        auditService,
+			hub:                 hub,
+			clock:               clk,
+			logger:              logger,
+			ids:                 ids,
+		},
+		userService: userService,
+	}
+}
+
+// CreateToken issues the workspace's SCIM token, replacing any earlier one
+func (s *SCIMService) CreateToken(ctx context.Context, actorID string) (*models.SCIMTokenResponse, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	token := scimTokenPrefix + secret
+	now := s.clock.Now()
+
+	_, err = s.db.Collection(ctx, "workspaces").UpdateOne(ctx, bson.M{"_id": actor.WorkspaceID},
+		bson.M{"$set": bson.M{"scimTokenHash": hashSecret(token), "scimTokenCreatedAt": now}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store SCIM token: %w", err)
+	}
+	s.audit(ctx, AuditSCIMTokenCreated, actorID, nil)
+	return &models.SCIMTokenResponse{Token: token, CreatedAt: now}, nil
+}
+
+// RevokeToken disables SCIM for the admin's workspace
+func (s *SCIMService) RevokeToken(ctx context.Context, actorID string) error {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Collection(ctx, "workspaces").UpdateOne(ctx,
+		bson.M{"_id": actor.WorkspaceID, "scimTokenHash": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"scimTokenHash": "", "scimTokenCreatedAt": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to revoke SCIM token: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return notFoundError("the workspace has no SCIM token")
+	}
+	s.audit(ctx, AuditSCIMTokenRevoked, actorID, nil)
+	return nil
+}
+
+// Authenticate returns the workspace a SCIM token belongs to
+func (s *SCIMService) Authenticate(ctx context.Context, token string) (string, error) {
+	if !strings.HasPrefix(token, scimTokenPrefix) {
+		return "", notFoundError("unknown SCIM token")
+	}
+	var workspace models.Workspace
+	err := s.db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"scimTokenHash": hashSecret(token)},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&workspace)
+	if err == mongo.ErrNoDocuments {
+		return "", notFoundError("unknown SCIM token")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find SCIM token: %w", err)
+	}
+	return workspace.ID, nil
+}
+
+// ListUsers returns one page of the workspace's users. Users who joined by signing in have no
+// provisioned userName, so their email stands in for it.
+func (s *SCIMService) ListUsers(ctx context.Context, workspaceID, filter string, startIndex, count int) (*models.SCIMListResponse, error) {
+	query := bson.M{"workspaceId": workspaceID}
+	if filter != "" {
+		attribute, value, err := parseSCIMFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		switch attribute {
+		case "id":
+			query["_id"] = value
+		case "externalid":
+			query["provisioning.externalId"] = value
+		case "username":
+			pattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+			query["$or"] = bson.A{
+				bson.M{"provisioning.userName": pattern},
+				bson.M{"provisioning.userName": bson.M{"$exists": false}, "email": pattern},
+			}
+		case "emails", "emails.value":
+			query["email"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+		default:
+			return nil, validationError("users can be filtered by id, externalId, userName or emails")
+		}
+	}
+
+	var users []models.User
+	total, err := s.page(ctx, "users", query, startIndex, count, &users)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]models.SCIMUser, len(users))
+	for i := range users {
+		resources[i] = *scimUser(&users[i])
+	}
+	return scimList(total, startIndex, len(resources), resources), nil
+}
+
+func (s *SCIMService) GetUser(ctx context.Context, workspaceID, userID string) (*models.SCIMUser, error) {
+	user, err := s.user(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scimUser(user), nil
+}
+
+// CreateUser provisions a user. A user who already signed in under the same ID is adopted
+// rather than refused, so a provider can take over an existing workspace.
+func (s *SCIMService) CreateUser(ctx context.Context, workspaceID string, req *models.SCIMUser) (*models.SCIMUser, error) {
+	userID := req.ExternalID
+	if userID == "" {
+		userID = req.UserName
+	}
+
+	taken, err := s.db.Collection(ctx, "users").CountDocuments(ctx, bson.M{
+		"workspaceId":           workspaceID,
+		"_id":                   bson.M{"$ne": userID},
+		"provisioning.userName": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(req.UserName) + "$", Options: "i"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check userName: %w", err)
+	}
+	if taken > 0 {
+		return nil, conflictError("a user with this userName already exists")
+	}
+
+	existing, err := s.userService.GetUserByID(ctx, userID)
+	switch {
+	case err == nil && existing.WorkspaceID != workspaceID:
+		return nil, conflictError("a user with this ID already exists")
+	case err == nil && existing.Provisioning != nil:
+		return nil, conflictError("a user with this ID already exists")
+	case err == nil:
+		return s.saveUser(ctx, existing, req)
+	case !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:          userID,
+		WorkspaceID: workspaceID,
+		CreatedAt:   s.clock.Now(),
+	}
+	if _, err := s.db.Collection(ctx, "users").InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, conflictError("a user with this ID already exists")
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	created, err := s.saveUser(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var workspace models.Workspace
+	err = s.db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find workspace: %w", err)
+	}
+	if err := s.conversationService.JoinDefaults(ctx, user, workspace.DefaultConversationIDs); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ReplaceUser sets a user to the provider's full representation of it
+func (s *SCIMService) ReplaceUser(ctx context.Context, workspaceID, userID string, req *models.SCIMUser) (*models.SCIMUser, error) {
+	user, err := s.user(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveUser(ctx, user, req)
+}
+
+// PatchUser applies a provider's changes to a user. Attributes this service does not keep
+// (titles, phone numbers, ...) are accepted and ignored, as providers send whatever they map.
+func (s *SCIMService) PatchUser(ctx context.Context, workspaceID, userID string, req *models.SCIMPatchRequest) (*models.SCIMUser, error) {
+	user, err := s.user(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	patched := scimUser(user)
+	for _, op := range req.Operations {
+		if err := patchSCIMUser(patched, op); err != nil {
+			return nil, err
+		}
+	}
+	return s.saveUser(ctx, user, patched)
+}
+
+// DeleteUser deprovisions a user by deactivating them
+func (s *SCIMService) DeleteUser(ctx context.Context, workspaceID, userID string) error {
+	user, err := s.user(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	req := scimUser(user)
+	inactive := false
+	req.Active = &inactive
+	_, err = s.saveUser(ctx, user, req)
+	return err
+}
+
+// saveUser stores the provider's view of a user and carries out any change of activation
+func (s *SCIMService) saveUser(ctx context.Context, user *models.User, req *models.SCIMUser) (*models.SCIMUser, error) {
+	set := bson.M{"provisioning": &models.Provisioning{ExternalID: req.ExternalID, UserName: req.UserName}}
+	if name := scimDisplayName(req); name != "" {
+		set["name"] = name
+	}
+	if email := scimPrimaryEmail(req); email != "" {
+		set["email"] = email
+	}
+	update := bson.M{"$set": set}
+
+	active := req.Active == nil || *req.Active
+	deactivated := !active && user.DeactivatedAt == nil
+	reactivated := active && user.DeactivatedAt != nil
+	now := s.clock.Now()
+	switch {
+	case deactivated:
+		set["deactivatedAt"] = now
+	case reactivated:
+		update["$unset"] = bson.M{"deactivatedAt": ""}
+	}
+
+	var saved models.User
+	err := s.db.Collection(ctx, "users").FindOneAndUpdate(ctx,
+		bson.M{"_id": user.ID, "workspaceId": user.WorkspaceID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	s.userService.invalidate(ctx, user.ID)
+
+	if deactivated || reactivated {
+		s.activationChanged(ctx, user.ID, deactivated, scimActor)
+	}
+	return scimUser(&saved), nil
+}
+
+func (s *SCIMService) user(ctx context.Context, workspaceID, userID string) (*models.User, error) {
+	user, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.WorkspaceID != workspaceID {
+		return nil, notFoundError("user not found")
+	}
+	if user.Provisioning != nil && user.Provisioning.Source == models.ProvisionedByLDAP {
+		return nil, conflictError("the user is managed by the LDAP sync")
+	}
+	return user, nil
+}
+
+// ListGroups returns one page of the workspace's provisioned group conversations
+func (s *SCIMService) ListGroups(ctx context.Context, workspaceID, filter string, startIndex, count int) (*models.SCIMListResponse, error) {
+	query := bson.M{"workspaceId": workspaceID, "provisioning": bson.M{"$exists": true}, "provisioning.source": bson.M{"$exists": false}}
+	if filter != "" {
+		attribute, value, err := parseSCIMFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		switch attribute {
+		case "id":
+			query["_id"] = value
+		case "externalid":
+			query["provisioning.externalId"] = value
+		case "displayname":
+			query["title"] = value
+		default:
+			return nil, validationError("groups can be filtered by id, externalId or displayName")
+		}
+	}
+
+	var conversations []models.Conversation
+	total, err := s.page(ctx, "conversations", query, startIndex, count, &conversations)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]models.SCIMGroup, len(conversations))
+	for i := range conversations {
+		group, err := s.scimGroup(ctx, &conversations[i])
+		if err != nil {
+			return nil, err
+		}
+		resources[i] = *group
+	}
+	return scimList(total, startIndex, len(resources), resources), nil
+}
+
+func (s *SCIMService) GetGroup(ctx context.Context, workspaceID, groupID string) (*models.SCIMGroup, error) {
+	conversation, err := s.group(ctx, workspaceID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(ctx, conversation)
+}
+
+// CreateGroup starts a group conversation whose membership the provider manages. It may start
+// empty, as providers often add members afterwards.
+func (s *SCIMService) CreateGroup(ctx context.Context, workspaceID string, req *models.SCIMGroup) (*models.SCIMGroup, error) {
+	memberIDs, err := s.groupMembers(ctx, workspaceID, req.Members)
+	if err != nil {
+		return nil, err
+	}
+	conversation, err := s.createGroup(ctx, workspaceID, req.DisplayName, &models.Provisioning{ExternalID: req.ExternalID}, memberIDs, scimActor)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(ctx, conversation)
+}
+
+// ReplaceGroup sets a group's title and members to the provider's
+func (s *SCIMService) ReplaceGroup(ctx context.Context, workspaceID, groupID string, req *models.SCIMGroup) (*models.SCIMGroup, error) {
+	conversation, err := s.group(ctx, workspaceID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	memberIDs, err := s.groupMembers(ctx, workspaceID, req.Members)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rename(ctx, conversation, req.DisplayName, req.ExternalID, scimActor); err != nil {
+		return nil, err
+	}
+	if err := s.setMembers(ctx, conversation, memberIDs, scimActor); err != nil {
+		return nil, err
+	}
+	return s.scimGroup(ctx, conversation)
+}
+
+// PatchGroup applies a provider's changes to a group: member additions and removals, and
+// replacing its displayName or whole membership
+func (s *SCIMService) PatchGroup(ctx context.Context, workspaceID, groupID string, req *models.SCIMPatchRequest) (*models.SCIMGroup, error) {
+	conversation, err := s.group(ctx, workspaceID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.conversationService.participantUserIDs(ctx, conversation.ID)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(current))
+	for _, userID := range current {
+		members[userID] = true
+	}
+	displayName, externalID := conversation.Title, conversation.Provisioning.ExternalID
+
+	for _, op := range req.Operations {
+		path := strings.ToLower(op.Path)
+		switch {
+		case path == "displayname" || (path == "" && strings.EqualFold(op.Op, "replace") && jsonHasKey(op.Value, "displayName")):
+			value := op.Value
+			if path == "" {
+				var attributes map[string]json.RawMessage
+				json.Unmarshal(op.Value, &attributes)
+				value = attributes["displayName"]
+			}
+			if err := json.Unmarshal(value, &displayName); err != nil || displayName == "" {
+				return nil, validationError("displayName must be a non-empty string")
+			}
+		case path == "externalid":
+			if err := json.Unmarshal(op.Value, &externalID); err != nil {
+				return nil, validationError("externalId must be a string")
+			}
+		case path == "members" || strings.HasPrefix(path, "members["):
+			ids, err := scimMemberValues(op)
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(op.Op) {
+			case "add":
+				for _, userID := range ids {
+					members[userID] = true
+				}
+			case "remove":
+				if len(ids) == 0 && path == "members" {
+					members = map[string]bool{}
+				}
+				for _, userID := range ids {
+					delete(members, userID)
+				}
+			case "replace":
+				members = make(map[string]bool, len(ids))
+				for _, userID := range ids {
+					members[userID] = true
+				}
+			default:
+				return nil, validationError("unknown op " + op.Op)
+			}
+		default:
+			return nil, validationError("groups can only be patched on displayName, externalId and members")
+		}
+	}
+
+	memberIDs := make([]string, 0, len(members))
+	for userID := range members {
+		memberIDs = append(memberIDs, userID)
+	}
+	if memberIDs, err = s.groupMembers(ctx, workspaceID, scimMembers(memberIDs)); err != nil {
+		return nil, err
+	}
+	if err := s.rename(ctx, conversation, displayName, externalID, scimActor); err != nil {
+		return nil, err
+	}
+	if err := s.setMembers(ctx, conversation, memberIDs, scimActor); err != nil {
+		return nil, err
+	}
+	return s.scimGroup(ctx, conversation)
+}
+
+// DeleteGroup stops the provider managing a group. The conversation and its history stay with
+// its members, who can still talk and leave but no longer be added to or removed from it.
+func (s *SCIMService) DeleteGroup(ctx context.Context, workspaceID, groupID string) error {
+	conversation, err := s.group(ctx, workspaceID, groupID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversation.ID},
+		bson.M{"$unset": bson.M{"provisioning": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to unlink group: %w", err)
+	}
+	return nil
+}
+
+func (s *SCIMService) group(ctx context.Context, workspaceID, groupID string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.Collection(ctx, "conversations").FindOne(ctx, bson.M{
+		"_id":                 groupID,
+		"workspaceId":         workspaceID,
+		"provisioning":        bson.M{"$exists": true},
+		"provisioning.source": bson.M{"$exists": false},
+	}).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("group not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find group: %w", err)
+	}
+	return &conversation, nil
+}
+
+// groupMembers checks that every member is a user of the workspace
+func (s *SCIMService) groupMembers(ctx context.Context, workspaceID string, members []models.SCIMMember) ([]string, error) {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Value == "" || strings.HasPrefix(member.Value, "@") {
+			return nil, validationError("members must be user IDs")
+		}
+		ids = append(ids, member.Value)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	return s.userService.resolveMembers(ctx, workspaceID, ids)
+}
+
+func (s *SCIMService) scimGroup(ctx context.Context, conversation *models.Conversation) (*models.SCIMGroup, error) {
+	memberIDs, err := s.conversationService.participantUserIDs(ctx, conversation.ID)
+	if err != nil {
+		return nil, err
+	}
+	group := &models.SCIMGroup{
+		Schemas:     []string{models.SCIMGroupSchema},
+		ID:          conversation.ID,
+		DisplayName: conversation.Title,
+		Members:     scimMembers(memberIDs),
+		Meta: &models.SCIMMeta{
+			ResourceType: "Group",
+			Created:      conversation.CreatedAt,
+			Location:     "/scim/v2/Groups/" + conversation.ID,
+		},
+	}
+	if conversation.Provisioning != nil {
+		group.ExternalID = conversation.Provisioning.ExternalID
+	}
+	return group, nil
+}
+
+// page finds one page of a SCIM listing into results and returns the total matching
+func (s *SCIMService) page(ctx context.Context, collection string, query bson.M, startIndex, count int, results interface{}) (int64, error) {
+	total, err := s.db.Collection(ctx, collection).CountDocuments(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", collection, err)
+	}
+	if count == 0 {
+		return total, nil // a count of 0 asks only for the total
+	}
+	cursor, err := s.db.Collection(ctx, collection).Find(ctx, query, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetSkip(int64(startIndex-1)).
+		SetLimit(int64(count)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find %s: %w", collection, err)
+	}
+	if err := cursor.All(ctx, results); err != nil {
+		return 0, fmt.Errorf("failed to decode %s: %w", collection, err)
+	}
+	return total, nil
+}
+
+// SCIMPage bounds a listing's startIndex and count as RFC 7644 §3.4.2.4 asks: a start below 1
+// is 1, and a count is capped rather than refused
+func SCIMPage(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 0 {
+		limit = scimDefaultCount
+	}
+	if limit > scimMaxCount {
+		limit = scimMaxCount
+	}
+	return start, limit
+}
+
+func scimList(total int64, startIndex, items int, resources interface{}) *models.SCIMListResponse {
+	return &models.SCIMListResponse{
+		Schemas:      []string{models.SCIMListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: items,
+		Resources:    resources,
+	}
+}
+
+func scimUser(user *models.User) *models.SCIMUser {
+	active := user.DeactivatedAt == nil
+	scim := &models.SCIMUser{
+		Schemas:     []string{models.SCIMUserSchema},
+		ID:          user.ID,
+		UserName:    user.Email,
+		DisplayName: user.Name,
+		Active:      &active,
+		Meta: &models.SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			Location:     "/scim/v2/Users/" + user.ID,
+		},
+	}
+	if user.Name != "" {
+		scim.Name = &models.SCIMName{Formatted: user.Name}
+	}
+	if user.Email != "" {
+		scim.Emails = []models.SCIMEmail{{Value: user.Email, Primary: true}}
+	}
+	if user.Provisioning != nil {
+		scim.ExternalID = user.Provisioning.ExternalID
+		scim.UserName = user.Provisioning.UserName
+	}
+	if scim.UserName == "" {
+		scim.UserName = user.ID
+	}
+	return scim
+}
+
+func scimDisplayName(req *models.SCIMUser) string {
+	if req.DisplayName != "" {
+		return req.DisplayName
+	}
+	if req.Name == nil {
+		return ""
+	}
+	if req.Name.Formatted != "" {
+		return req.Name.Formatted
+	}
+	return strings.TrimSpace(req.Name.GivenName + " " + req.Name.FamilyName)
+}
+
+func scimPrimaryEmail(req *models.SCIMUser) string {
+	for _, email := range req.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(req.Emails) > 0 {
+		return req.Emails[0].Value
+	}
+	return ""
+}
+
+func scimMembers(userIDs []string) []models.SCIMMember {
+	members := make([]models.SCIMMember, len(userIDs))
+	for i, userID := range userIDs {
+		members[i] = models.SCIMMember{Value: userID}
+	}
+	return members
+}
+
+// patchSCIMUser applies one PATCH operation to a user's SCIM representation
+func patchSCIMUser(user *models.SCIMUser, op models.SCIMPatchOp) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		return nil // nothing a provider removes is kept by this service
+	default:
+		return validationError("unknown op " + op.Op)
+	}
+
+	// Without a path the value holds the attributes to set
+	if op.Path == "" {
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attributes); err != nil {
+			return validationError("a patch without a path needs an object value")
+		}
+		for path, value := range attributes {
+			if err := patchSCIMUser(user, models.SCIMPatchOp{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	switch path := strings.ToLower(op.Path); {
+	case path == "active":
+		var active bool
+		if active, err = scimBool(op.Value); err == nil {
+			user.Active = &active
+		}
+	case path == "username":
+		err = json.Unmarshal(op.Value, &user.UserName)
+	case path == "externalid":
+		err = json.Unmarshal(op.Value, &user.ExternalID)
+	case path == "displayname":
+		err = json.Unmarshal(op.Value, &user.DisplayName)
+	case path == "name":
+		err = json.Unmarshal(op.Value, &user.Name)
+		user.DisplayName = ""
+	case strings.HasPrefix(path, "name."):
+		if user.Name == nil {
+			user.Name = &models.SCIMName{}
+		}
+		switch path {
+		case "name.formatted":
+			err = json.Unmarshal(op.Value, &user.Name.Formatted)
+		case "name.givenname":
+			err = json.Unmarshal(op.Value, &user.Name.GivenName)
+			user.Name.Formatted = ""
+		case "name.familyname":
+			err = json.Unmarshal(op.Value, &user.Name.FamilyName)
+			user.Name.Formatted = ""
+		}
+		user.DisplayName = ""
+	case path == "emails":
+		err = json.Unmarshal(op.Value, &user.Emails)
+	case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		var email string
+		if err = json.Unmarshal(op.Value, &email); err == nil {
+			user.Emails = []models.SCIMEmail{{Value: email, Primary: true}}
+		}
+	}
+	if err != nil {
+		return validationError("invalid value for " + op.Path)
+	}
+	if user.UserName == "" {
+		return validationError("userName cannot be empty")
+	}
+	return nil
+}
+
+// scimMemberValues returns the user IDs a group PATCH operation adds or removes, from its value
+// or from a path like members[value eq "id"]
+func scimMemberValues(op models.SCIMPatchOp) ([]string, error) {
+	if strings.HasPrefix(strings.ToLower(op.Path), "members[") {
+		inner := strings.TrimSuffix(op.Path[len("members["):], "]")
+		attribute, value, err := parseSCIMFilter(inner)
+		if err != nil || attribute != "value" {
+			return nil, validationError("members can only be selected by value")
+		}
+		return []string{value}, nil
+	}
+	if len(op.Value) == 0 {
+		return nil, nil
+	}
+	var members []models.SCIMMember
+	if err := json.Unmarshal(op.Value, &members); err != nil {
+		return nil, validationError("members must be a list of {\"value\": userId}")
+	}
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = member.Value
+	}
+	return ids, nil
+}
+
+// parseSCIMFilter splits `attribute eq "value"`, lowercasing the attribute
+func parseSCIMFilter(filter string) (string, string, error) {
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", validationError(`only filters of the form attribute eq "value" are supported`)
+	}
+	value, err := strconv.Unquote(match[2])
+	if err != nil {
+		return "", "", validationError("invalid filter value")
+	}
+	return strings.ToLower(match[1]), value, nil
+}
+
+// scimBool reads a boolean, including the "True" and "False" strings some providers send
+func scimBool(raw json.RawMessage) (bool, error) {
+	var value bool
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.ToLower(text))
+}
+
+func jsonHasKey(raw json.RawMessage, key string) bool {
+	var attributes map[string]json.RawMessage
+	if json.Unmarshal(raw, &attributes) != nil {
+		return false
+	}
+	_, ok := attributes[key]
+	return ok
+}
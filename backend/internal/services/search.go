@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Message search takes one query string, as users type it: words and "quoted phrases" the body
+// must all contain (ignoring case), plus filters. from:<username or user ID> and
+// in:<conversation ID> may repeat, any one matching; before:/after:<YYYY-MM-DD> are UTC days,
+// excluded; has: is link, media (GIFs and stickers), file (also as attachment), poll or location.
+// The query is parsed here into one find over the caller's conversations, which the messages
+// index narrows by conversation and time; body terms are matched by regex within that. A
+// full-text index (Atlas Search) is on the roadmap.
+
+const (
+	maxSearchQueryLength = 200
+	maxSearchTerms       = 10
+	defaultSearchLimit   = 20
+	// snippetLength and snippetLead are in bytes, trimmed to whole characters
+	snippetLength = 160
+	snippetLead   = 40
+	searchDate    = "2006-01-02"
+)
+
+// messageQuery is a parsed search query
+type messageQuery struct {
+	terms  []string
+	from   []string
+	in     []string
+	has    []string
+	before *time.Time
+	after  *time.Time
+}
+
+// parseMessageQuery splits a query into terms and filters. A word whose prefix is not a known
+// filter, such as a URL, is a term.
+func parseMessageQuery(q string) (*messageQuery, error) {
+	if len([]rune(q)) > maxSearchQueryLength {
+		return nil, validationError(fmt.Sprintf("q is limited to %d characters", maxSearchQueryLength))
+	}
+
+	query := &messageQuery{}
+	for _, token := range tokenizeQuery(q) {
+		key, value, found := strings.Cut(token.text, ":")
+		if token.quoted || !found || value == "" {
+			query.terms = append(query.terms, token.text)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from":
+			query.from = append(query.from, strings.TrimPrefix(value, "@"))
+		case "in":
+			query.in = append(query.in, value)
+		case "has":
+			has := strings.ToLower(value)
+			switch has {
+			case "attachment":
+				has = models.MessageTypeFile
+			case "link", "media", models.MessageTypeFile, "poll", "location":
+			default:
+				return nil, validationError("has: takes link, media, file, attachment, poll or location")
+			}
+			query.has = append(query.has, has)
+		case "before", "after":
+			day, err := time.Parse(searchDate, value)
+			if err != nil {
+				return nil, validationError(key + ": takes a date as YYYY-MM-DD")
+			}
+			if strings.EqualFold(key, "before") {
+				query.before = &day
+			} else {
+				next := day.AddDate(0, 0, 1)
+				query.after = &next
+			}
+		default:
+			query.terms = append(query.terms, token.text)
+		}
+	}
+
+	if len(query.terms) > maxSearchTerms {
+		return nil, validationError(fmt.Sprintf("at most %d search terms are allowed", maxSearchTerms))
+	}
+	if len(query.terms) == 0 && len(query.from) == 0 && len(query.in) == 0 && len(query.has) == 0 &&
+		query.before == nil && query.after == nil {
+		return nil, validationError("q is required")
+	}
+	return query, nil
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeQuery splits on spaces, keeping "quoted phrases" together. An unclosed quote runs to
+// the end.
+func tokenizeQuery(q string) []queryToken {
+	var tokens []queryToken
+	for {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			return tokens
+		}
+		if q[0] == '"' {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				tokens = append(tokens, queryToken{text: phrase, quoted: true})
+			}
+			q = rest
+			continue
+		}
+		end := strings.IndexAny(q, " \t\n")
+		if end < 0 {
+			end = len(q)
+		}
+		tokens = append(tokens, queryToken{text: q[:end]})
+		q = q[end:]
+	}
+}
+
+// SearchMessages finds messages in the user's conversations matching a query, newest first.
+// before is the previous page's NextCursor.
+func (s *MessageService) SearchMessages(ctx context.Context, userID, q, before string, limit int) (*models.MessageSearchResponse, error) {
+	query, err := parseMessageQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	conversationIDs, err := s.searchConversations(ctx, userID, query.in)
+	if err != nil {
+		return nil, err
+	}
+	senderIDs, err := s.searchSenders(ctx, query.from)
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := bson.A{
+		bson.M{"conversationId": bson.M{"$in": conversationIDs}},
+		bson.M{"retractedAt": bson.M{"$exists": false}},
+	}
+	if len(senderIDs) > 0 {
+		clauses = append(clauses, bson.M{"senderId": bson.M{"$in": senderIDs}})
+	}
+	if query.before != nil {
+		clauses = append(clauses, bson.M{"createdAt": bson.M{"$lt": *query.before}})
+	}
+	if query.after != nil {
+		clauses = append(clauses, bson.M{"createdAt": bson.M{"$gte": *query.after}})
+	}
+	user, err := s.userService.GetUserProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	start, err := historyStart(ctx, s.db, user.WorkspaceID, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		clauses = append(clauses, bson.M{"createdAt": bson.M{"$gte": *start}})
+	}
+	for _, term := range query.terms {
+		clauses = append(clauses, bson.M{"body": primitive.Regex{Pattern: regexp.QuoteMeta(term), Options: "i"}})
+	}
+	for _, has := range query.has {
+		clauses = append(clauses, hasFilter(has))
+	}
+	if before != "" {
+		position, err := decodeMessageCursor(before)
+		if err != nil {
+			return nil, validationError("invalid cursor")
+		}
+		clauses = append(clauses, bson.D{position.filter(false)})
+	}
+
+	cursor, err := s.db.Collection(ctx, "messages").Find(ctx, bson.M{"$and": clauses},
+		options.Find().
+			SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	response := &models.MessageSearchResponse{Messages: make([]models.MessageSearchHit, 0, limit)}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		response.NextCursor = encodeMessageCursor(messages[limit-1])
+	}
+	highlight := termPattern(query.terms)
+	for i := range messages {
+		message, err := s.withSender(ctx, &messages[i])
+		if err != nil {
+			return nil, err
+		}
+		response.Messages = append(response.Messages, models.MessageSearchHit{
+			MessageWithSender: message,
+			Snippet:           snippet(messages[i].Body, highlight),
+		})
+	}
+	return response, nil
+}
+
+// searchConversations returns the conversations to search: the user's, or those of them named
+// with in:
+func (s *MessageService) searchConversations(ctx context.Context, userID string, in []string) ([]string, error) {
+	filter := bson.M{"userId": userID}
+	if len(in) > 0 {
+		filter["conversationId"] = bson.M{"$in": in}
+	}
+	cursor, err := s.db.Collection(ctx, "participants").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"conversationId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find participants: %w", err)
+	}
+	var participants []models.Participant
+	if err := cursor.All(ctx, &participants); err != nil {
+		return nil, fmt.Errorf("failed to decode participants: %w", err)
+	}
+	if len(in) > 0 && len(participants) == 0 {
+		return nil, notFoundError("conversation not found")
+	}
+
+	conversationIDs := make([]string, len(participants))
+	for i, p := range participants {
+		conversationIDs[i] = p.ConversationID
+	}
+	return conversationIDs, nil
+}
+
+// searchSenders resolves from: values, usernames first and otherwise taken as user IDs
+func (s *MessageService) searchSenders(ctx context.Context, from []string) ([]string, error) {
+	senderIDs := make([]string, 0, len(from))
+	for _, name := range from {
+		user, err := s.userService.GetUserByUsername(ctx, name)
+		switch {
+		case err == nil:
+			senderIDs = append(senderIDs, user.ID)
+		case errors.Is(err, ErrNotFound):
+			senderIDs = append(senderIDs, name)
+		default:
+			return nil, err
+		}
+	}
+	return senderIDs, nil
+}
+
+func hasFilter(has string) bson.M {
+	switch has {
+	case "link":
+		return bson.M{"$or": bson.A{
+			bson.M{"preview": bson.M{"$exists": true}},
+			bson.M{"unfurlUrl": bson.M{"$exists": true}},
+		}}
+	case "media":
+		return bson.M{"type": bson.M{"$in": bson.A{models.MessageTypeGIF, models.MessageTypeSticker}}}
+	default:
+		return bson.M{"type": has}
+	}
+}
+
+// termPattern matches any of the terms, ignoring case; nil without terms
+func termPattern(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// snippet cuts the body down to a window starting a little before the first match, split into
+// runs of matched and unmatched text
+func snippet(body string, pattern *regexp.Regexp) []models.SnippetPart {
+	var hits [][]int
+	if pattern != nil {
+		hits = pattern.FindAllStringIndex(body, -1)
+	}
+
+	start := 0
+	if len(hits) > 0 {
+		start = max(hits[0][0]-snippetLead, 0)
+	}
+	end := min(start+snippetLength, len(body))
+	for start > 0 && !utf8.RuneStart(body[start]) {
+		start++
+	}
+	for end < len(body) && !utf8.RuneStart(body[end]) {
+		end--
+	}
+
+	parts := make([]models.SnippetPart, 0, 2*len(hits)+3)
+	if start > 0 {
+		parts = append(parts, models.SnippetPart{Text: "…"})
+	}
+	add := func(text string, hit bool) {
+		if text != "" {
+			parts = append(parts, models.SnippetPart{Text: text, Hit: hit})
+		}
+	}
+	pos := start
+	for _, hit := range hits {
+		if hit[1] <= pos {
+			continue
+		}
+		if hit[0] >= end {
+			break
+		}
+		add(body[pos:hit[0]], false)
+		pos = min(hit[1], end)
+		add(body[hit[0]:pos], true)
+	}
+	add(body[pos:end], false)
+	if end < len(body) {
+		parts = append(parts, models.SnippetPart{Text: "…"})
+	}
+	return parts
+}
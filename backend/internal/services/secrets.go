@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+)
+
+// newSecret returns a random hex token suitable for confirmation tokens and API keys
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashSecret returns the SHA-256 digest under which a secret is stored; secrets are never persisted
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireWorkspaceAdmin returns the actor if they administer their workspace; what they may
+// administer is that workspace alone
+func requireWorkspaceAdmin(ctx context.Context, userService *UserService, actorID string) (*models.User, error) {
+	actor, err := userService.GetUserByID(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPermission(nil, actor, permissions.AdministerWorkspace) {
+		return nil, forbiddenError("workspace admin role required")
+	}
+	return actor, nil
+}
+
+// requireConversationPermission returns the actor's participation in a conversation if their
+// role there grants perm, refusing them with denied otherwise
+func requireConversationPermission(ctx context.Context, conversationService *ConversationService, conversationID, actorID string, perm permissions.Permission, denied string) (*models.Participant, error) {
+	participant, err := conversationService.GetParticipant(ctx, conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPermission(participant, nil, perm) {
+		return nil, forbiddenError(denied)
+	}
+	return participant, nil
+}
+
+// requireDeploymentAdmin is for operations affecting every workspace, such as the JetStream
+// stream or the compliance journal: only admins of the default workspace may run them
+func requireDeploymentAdmin(ctx context.Context, userService *UserService, actorID string) error {
+	actor, err := requireWorkspaceAdmin(ctx, userService, actorID)
+	if err != nil {
+		return err
+	}
+	if actor.WorkspaceID != DefaultWorkspaceID {
+		return forbiddenError("deployment admin role required")
+	}
+	return nil
+}
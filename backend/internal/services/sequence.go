@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+)
+
+// seqWindow is how many recent sequences a subscription remembers for deduplication
+const seqWindow = 1000
+
+// sequenceState tracks the per-conversation message sequence a subscription has delivered
+type sequenceState struct {
+	mu            sync.Mutex
+	lastSeq       int64
+	lastMessageID int64
+	delivered     map[int64]bool
+}
+
+// deliverMessage fans a message out in sequence. If the feed skipped sequences (concurrent sends
+// can reach the stream out of order) the missing messages are backfilled from the CHAT stream
+// first; sequences already delivered by a backfill are not sent twice.
+func (h *WebSocketHub) deliverMessage(sub *ConversationSubscription, message models.WSMessageNewData) {
+	state := &sub.sequence
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if message.Seq == 0 {
+		// Published before sequences were assigned
+		h.broadcastToSubscription(sub, h.newFrame("message.new", message))
+		return
+	}
+	if state.delivered == nil {
+		state.delivered = make(map[int64]bool)
+	}
+	if state.delivered[message.Seq] {
+		return
+	}
+
+	if state.lastSeq > 0 && message.Seq > state.lastSeq+1 {
+		h.backfillLocked(sub, state, message.Seq)
+	}
+
+	h.broadcastToSubscription(sub, h.newFrame("message.new", message))
+	state.markLocked(message)
+}
+
+func (h *WebSocketHub) backfillLocked(sub *ConversationSubscription, state *sequenceState, beforeSeq int64) {
+	ctx := tenant.NewContext(context.Background(), sub.workspaceID)
+	replay, err := h.messageService.ReplaySince(ctx, sub.ConversationID, state.lastMessageID, int(beforeSeq-state.lastSeq))
+	if err != nil {
+		h.logger.Error("Failed to backfill conversation", logging.ConversationID, sub.ConversationID, "after_seq", state.lastSeq, logging.Err(err))
+		return
+	}
+
+	// Retractions and updates also arrive live; a repeat is harmless
+	for _, retraction := range replay.Retracted {
+		h.broadcastToSubscription(sub, h.newFrame("message.retracted", retraction))
+	}
+	for _, update := range replay.Updated {
+		h.broadcastToSubscription(sub, h.newFrame("message.updated", update))
+	}
+	for _, message := range replay.Messages {
+		// Concurrent sends can publish out of order; later ones are delivered when they arrive
+		if message.Seq >= beforeSeq || state.delivered[message.Seq] {
+			continue
+		}
+		h.broadcastToSubscription(sub, h.newFrame("message.new", message))
+		state.markLocked(message)
+	}
+}
+
+func (s *sequenceState) markLocked(message models.WSMessageNewData) {
+	s.delivered[message.Seq] = true
+	if message.Seq > s.lastSeq {
+		s.lastSeq = message.Seq
+		s.lastMessageID = message.ID
+	}
+
+	for seq := range s.delivered {
+		if seq <= s.lastSeq-seqWindow {
+			delete(s.delivered, seq)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Every WebSocket connection is listed in the session bucket under its user, so a user's
+// sessions can be counted and shown whichever node holds them. Like presence heartbeats,
+// entries are refreshed every PresenceTTL/3 and expire when their node stops. Revoking a
+// session broadcasts its ID; the node holding it closes the socket.
+//
+// The connection limit is checked before the upgrade against the bucket. A user at the limit
+// gets the new connection and loses their oldest, closed as superseded the same way a revoked
+// one is. Two connections racing on different nodes can both get in; it bounds abuse, not
+// exact counts.
+
+const maxDeviceLength = 200
+
+// startSessions opens the session bucket
+func (h *WebSocketHub) startSessions(ctx context.Context) error {
+	kv, err := h.natsConn.SessionStore(ctx, h.config.PresenceTTL)
+	if err != nil {
+		return err
+	}
+	h.sessionKV = kv
+	return nil
+}
+
+// CheckConnectionLimit makes room for a new connection for a user already at the per-user
+// limit by superseding their oldest ones
+func (h *WebSocketHub) CheckConnectionLimit(ctx context.Context, userID string) error {
+	if h.config.MaxConnectionsPerUser == 0 {
+		return nil
+	}
+
+	sessions, err := h.Sessions(ctx, userID)
+	if err != nil {
+		// The registry being unreachable should not lock users out; fall back to this node's connections
+		h.logger.WarnContext(ctx, "Failed to list sessions, using local connections", logging.UserID, userID, logging.Err(err))
+		sessions = h.localSessions(userID)
+	}
+
+	for _, session := range sessions[:max(0, len(sessions)-h.config.MaxConnectionsPerUser+1)] {
+		h.logger.InfoContext(ctx, "Superseding oldest connection", logging.UserID, userID, logging.ClientID, session.ID)
+		if err := h.natsConn.PublishSessionSupersede(session.ID); err != nil {
+			return err
+		}
+		if err := h.sessionKV.Delete(ctx, bucketKey(userID, session.ID)); err != nil {
+			h.logger.WarnContext(ctx, "Failed to delete superseded session", logging.UserID, userID, logging.Err(err))
+		}
+	}
+	return nil
+}
+
+// Sessions lists a user's open connections across the cluster, oldest first
+func (h *WebSocketHub) Sessions(ctx context.Context, userID string) ([]models.Session, error) {
+	watcher, err := h.sessionKV.WatchFiltered(ctx, []string{bucketKey(userID) + ".*"}, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer watcher.Stop()
+
+	sessions := []models.Session{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to list sessions: %w", ctx.Err())
+		case entry := <-watcher.Updates():
+			if entry == nil {
+				sort.Slice(sessions, func(i, j int) bool {
+					return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+				})
+				return sessions, nil
+			}
+			var session models.Session
+			if err := json.Unmarshal(entry.Value(), &session); err != nil {
+				h.logger.Warn("Skipping unreadable session entry", logging.UserID, userID, logging.Err(err))
+				continue
+			}
+			sessions = append(sessions, session)
+		}
+	}
+}
+
+// RevokeSession closes one of the user's connections, on whichever node holds it
+func (h *WebSocketHub) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	key := bucketKey(userID, sessionID)
+	if _, err := h.sessionKV.Get(ctx, key); err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return notFoundError("Session not found")
+		}
+		return fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	if err := h.natsConn.PublishSessionRevoke(sessionID); err != nil {
+		return err
+	}
+	// The holding node deletes it too once the socket closes; this makes the list current now
+	if err := h.sessionKV.Delete(ctx, key); err != nil {
+		h.logger.WarnContext(ctx, "Failed to delete revoked session", logging.UserID, userID, logging.Err(err))
+	}
+	return nil
+}
+
+// closeSession closes the connection or event stream named by a revocation or supersede if it
+// is on this node
+func (h *WebSocketHub) closeSession(sessionID string, code CloseCode) {
+	h.clientsMu.RLock()
+	client := h.clients[sessionID]
+	stream := h.streams[sessionID]
+	h.clientsMu.RUnlock()
+	if stream != nil {
+		h.logger.Info("Event stream closed", logging.UserID, stream.UserID, logging.ClientID, stream.ID, "reason", code.Reason)
+		stream.closeWith(code)
+	}
+	if client == nil {
+		return
+	}
+
+	client.logger.Info("Session closed", "reason", code.Reason)
+	client.closeWith(code)
+}
+
+// newSession describes a new connection
+func newSession(id, device, ip string, connectedAt time.Time) models.Session {
+	return models.Session{
+		ID:          id,
+		Device:      shorten(device, maxDeviceLength),
+		IP:          ip,
+		ConnectedAt: connectedAt,
+	}
+}
+
+// ConnectionOrigin returns the device and IP address a session opened by r is listed with
+func ConnectionOrigin(r *http.Request) (device, ip string) {
+	device = r.URL.Query().Get("device")
+	if device == "" {
+		device = r.UserAgent()
+	}
+	ip = remoteIP(r.RemoteAddr)
+	if addr := clientip.FromContext(r.Context()); addr.IsValid() {
+		ip = addr.String()
+	}
+	return device, ip
+}
+
+// remoteIP strips the port from a peer address
+func remoteIP(addr string) string {
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ip
+}
+
+func (h *WebSocketHub) putSession(userID string, session models.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		h.logger.Error("Failed to marshal session", logging.UserID, userID, logging.ClientID, session.ID, logging.Err(err))
+		return
+	}
+	if _, err := h.sessionKV.Put(ctx, bucketKey(userID, session.ID), data); err != nil {
+		h.logger.Error("Failed to record session", logging.UserID, userID, logging.ClientID, session.ID, logging.Err(err))
+	}
+}
+
+func (h *WebSocketHub) deleteSession(userID, sessionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.sessionKV.Delete(ctx, bucketKey(userID, sessionID)); err != nil {
+		h.logger.Error("Failed to remove session", logging.UserID, userID, logging.ClientID, sessionID, logging.Err(err))
+	}
+}
+
+// refreshSessions keeps this node's session entries, sockets' and event streams', from expiring
+func (h *WebSocketHub) refreshSessions() {
+	h.clientsMu.RLock()
+	userIDs := make([]string, 0, len(h.clients)+len(h.streams))
+	sessions := make([]models.Session, 0, len(h.clients)+len(h.streams))
+	for _, client := range h.clients {
+		userIDs = append(userIDs, client.UserID)
+		sessions = append(sessions, client.session)
+	}
+	for _, stream := range h.streams {
+		userIDs = append(userIDs, stream.UserID)
+		sessions = append(sessions, stream.session)
+	}
+	h.clientsMu.RUnlock()
+
+	for i, session := range sessions {
+		h.putSession(userIDs[i], session)
+	}
+}
+
+// localSessions lists the user's connections on this node, oldest first
+func (h *WebSocketHub) localSessions(userID string) []models.Session {
+	h.clientsMu.RLock()
+	sessions := make([]models.Session, 0, len(h.userClients[userID]))
+	for _, client := range h.userClients[userID] {
+		sessions = append(sessions, client.session)
+	}
+	for _, stream := range h.streams {
+		if stream.UserID == userID {
+			sessions = append(sessions, stream.session)
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions
+}
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Settings levels, lowest precedence first
+const (
+	SettingsDefault      = "default"
+	SettingsWorkspace    = "workspace"
+	SettingsConversation = "conversation"
+	SettingsUser         = "user"
+)
+
+const (
+	settingsCollection = "settings"
+	maxSlowModeSeconds = 6 * 60 * 60
+)
+
+// SettingsService resolves the settings cascade: built-in defaults, then workspace defaults,
+// then conversation overrides, then user preferences, each level replacing only the values it
+// sets. Retention, slow mode, read receipts and notifications all read their values here.
+// Notifications are resolved for a future push pipeline, which NotificationService gates.
+type SettingsService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	userService         *UserService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+	// retention supplies the built-in retention default and the bounds for the workspace value
+	retention RetentionPolicy
+}
+
+func NewSettingsService(db *database.MongoDB, conversationService *ConversationService, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, retention RetentionPolicy) *SettingsService {
+	return &SettingsService{
+		db:                  db,
+		conversationService: conversationService,
+		userService:         userService,
+		auditService:        auditService,
+		clock:               clk,
+		logger:              logger,
+		retention:           retention,
+	}
+}
+
+// Resolve returns the effective settings for a conversation and user; either may be empty to
+// stop the cascade above that level. The workspace level is the conversation's workspace, or
+// without a conversation the user's.
+func (s *SettingsService) Resolve(ctx context.Context, conversationID, userID string) (*models.EffectiveSettings, error) {
+	workspaceID := DefaultWorkspaceID
+	var conversation *models.Conversation
+	var err error
+	if conversationID != "" {
+		conversation, err = s.conversationService.GetConversationByID(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		workspaceID = conversation.WorkspaceID
+	} else if userID != "" {
+		profile, err := s.userService.GetUserProfile(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		workspaceID = profile.WorkspaceID
+	}
+
+	workspace, err := s.workspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.Settings
+	if userID != "" {
+		doc, err := s.load(ctx, userSettingsID(userID))
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			user = &doc.Settings
+		}
+	}
+
+	effective := s.resolveWith(workspace, conversation, user)
+	effective.ConversationID = conversationID
+	effective.UserID = userID
+	return effective, nil
+}
+
+// GetEffective resolves settings for debugging. Users may inspect their own settings in
+// conversations they belong to; anything else needs the workspace admin role.
+func (s *SettingsService) GetEffective(ctx context.Context, actorID, conversationID, userID string) (*models.EffectiveSettings, error) {
+	if userID == "" {
+		userID = actorID
+	}
+
+	needsAdmin := userID != actorID
+	if conversationID != "" && !needsAdmin {
+		if _, err := s.conversationService.GetParticipant(ctx, conversationID, actorID); err != nil {
+			if !errors.Is(err, ErrForbidden) {
+				return nil, err
+			}
+			needsAdmin = true
+		}
+	}
+	if needsAdmin {
+		actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+		if err != nil {
+			return nil, err
+		}
+		// Admins see into their own workspace only
+		if conversationID != "" {
+			conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+			if err != nil {
+				return nil, err
+			}
+			if conversation.WorkspaceID != actor.WorkspaceID {
+				return nil, notFoundError("conversation not found")
+			}
+		}
+		user, err := s.userService.GetUserProfile(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if user.WorkspaceID != actor.WorkspaceID {
+			return nil, notFoundError("user not found")
+		}
+	}
+
+	return s.Resolve(ctx, conversationID, userID)
+}
+
+// GetWorkspaceSettings returns the workspace defaults (workspace admins only)
+func (s *SettingsService) GetWorkspaceSettings(ctx context.Context, actorID string) (*models.SettingsDocument, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := workspaceSettingsID(actor.WorkspaceID)
+	doc, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = &models.SettingsDocument{ID: id}
+	}
+	return doc, nil
+}
+
+// UpdateWorkspaceSettings replaces the workspace defaults (workspace admins only)
+func (s *SettingsService) UpdateWorkspaceSettings(ctx context.Context, actorID string, settings *models.Settings) (*models.SettingsDocument, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validate(settings, SettingsWorkspace); err != nil {
+		return nil, err
+	}
+
+	doc, err := s.store(ctx, workspaceSettingsID(actor.WorkspaceID), actorID, settings)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, actorID, "", SettingsWorkspace, settings)
+	return doc, nil
+}
+
+// SetWorkspaceRetention sets only the workspace's default retention, leaving its other defaults
+// alone; 0 clears it so the deployment default applies (workspace admins only)
+func (s *SettingsService) SetWorkspaceRetention(ctx context.Context, actorID string, days int) (*models.SettingsDocument, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.workspace(ctx, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	updated := models.Settings{}
+	if settings != nil {
+		updated = *settings
+	}
+	updated.RetentionDays = nil
+	if days > 0 {
+		updated.RetentionDays = &days
+	}
+	if err := s.validate(&updated, SettingsWorkspace); err != nil {
+		return nil, err
+	}
+
+	doc, err := s.store(ctx, workspaceSettingsID(actor.WorkspaceID), actorID, &updated)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, actorID, "", SettingsWorkspace, &updated)
+	return doc, nil
+}
+
+// GetConversationSettings returns a conversation's overrides (participants only)
+func (s *SettingsService) GetConversationSettings(ctx context.Context, conversationID, actorID string) (*models.Settings, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, actorID); err != nil {
+		return nil, err
+	}
+
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Settings == nil {
+		return &models.Settings{}, nil
+	}
+	return conversation.Settings, nil
+}
+
+// UpdateConversationSettings replaces a conversation's overrides (conversation admins only)
+// and tells members with conversation.updated. Retention is excluded; its changes go through
+// RetentionService for approval.
+func (s *SettingsService) UpdateConversationSettings(ctx context.Context, conversationID, actorID string, settings *models.Settings) (*models.Settings, error) {
+	if _, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.ManageConversation, "only admins can change conversation settings"); err != nil {
+		return nil, err
+	}
+	if err := s.validate(settings, SettingsConversation); err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$set": bson.M{"settings": settings}}
+	if isEmptySettings(settings) {
+		update = bson.M{"$unset": bson.M{"settings": ""}}
+	}
+	result, err := s.db.Collection(ctx, "conversations").UpdateOne(ctx, bson.M{"_id": conversationID}, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation settings: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, notFoundError("conversation not found")
+	}
+
+	s.audit(ctx, actorID, conversationID, SettingsConversation, settings)
+
+	// Members' clients show the slow mode interval, so they are told of the change
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.conversationService.announceUpdate(ctx, conversation, actorID); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetUserSettings returns a user's preferences
+func (s *SettingsService) GetUserSettings(ctx context.Context, userID string) (*models.SettingsDocument, error) {
+	doc, err := s.load(ctx, userSettingsID(userID))
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = &models.SettingsDocument{ID: userSettingsID(userID)}
+	}
+	return doc, nil
+}
+
+// UpdateUserSettings replaces a user's preferences
+func (s *SettingsService) UpdateUserSettings(ctx context.Context, userID string, settings *models.Settings) (*models.SettingsDocument, error) {
+	if err := s.validate(settings, SettingsUser); err != nil {
+		return nil, err
+	}
+	return s.store(ctx, userSettingsID(userID), userID, settings)
+}
+
+// ResolveMany resolves the cascade for several users in one conversation, reading each level
+// once, keyed by user ID
+func (s *SettingsService) ResolveMany(ctx context.Context, conversationID string, userIDs []string) (map[string]*models.EffectiveSettings, error) {
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	workspace, err := s.workspace(ctx, conversation.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	docIDs := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		docIDs[i] = userSettingsID(userID)
+	}
+	cursor, err := s.db.Collection(ctx, settingsCollection).Find(ctx, bson.M{"_id": bson.M{"$in": docIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	var docs []models.SettingsDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode settings: %w", err)
+	}
+	userLevels := make(map[string]*models.Settings, len(docs))
+	for i := range docs {
+		userLevels[docs[i].ID] = &docs[i].Settings
+	}
+
+	resolved := make(map[string]*models.EffectiveSettings, len(userIDs))
+	for _, userID := range userIDs {
+		effective := s.resolveWith(workspace, conversation, userLevels[userSettingsID(userID)])
+		effective.ConversationID = conversationID
+		effective.UserID = userID
+		resolved[userID] = effective
+	}
+	return resolved, nil
+}
+
+// workspace loads a workspace's level; nil when no defaults have been set
+func (s *SettingsService) workspace(ctx context.Context, workspaceID string) (*models.Settings, error) {
+	doc, err := s.load(ctx, workspaceSettingsID(workspaceID))
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return &doc.Settings, nil
+}
+
+// workspaces loads every workspace level that has been set, keyed by workspace ID
+func (s *SettingsService) workspaces(ctx context.Context) (map[string]*models.Settings, error) {
+	cursor, err := s.db.Collection(ctx, settingsCollection).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"_id": workspaceSettingsID(DefaultWorkspaceID)},
+		bson.M{"_id": bson.M{"$regex": "^workspace:"}},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	var docs []models.SettingsDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode settings: %w", err)
+	}
+
+	levels := make(map[string]*models.Settings, len(docs))
+	for i := range docs {
+		workspaceID, ok := strings.CutPrefix(docs[i].ID, "workspace:")
+		if !ok {
+			workspaceID = DefaultWorkspaceID
+		}
+		levels[workspaceID] = &docs[i].Settings
+	}
+	return levels, nil
+}
+
+// resolveWith runs the cascade over levels already loaded; any of them may be nil
+func (s *SettingsService) resolveWith(workspace *models.Settings, conversation *models.Conversation, user *models.Settings) *models.EffectiveSettings {
+	effective := &models.EffectiveSettings{
+		RetentionDays:   s.retention.DefaultDays,
+		SlowModeSeconds: 0,
+		ReadReceipts:    true,
+		Notifications:   models.NotifyAll,
+		Sources: map[string]string{
+			"retentionDays":   SettingsDefault,
+			"slowModeSeconds": SettingsDefault,
+			"readReceipts":    SettingsDefault,
+			"notifications":   SettingsDefault,
+		},
+	}
+
+	apply := func(level string, settings *models.Settings) {
+		if settings == nil {
+			return
+		}
+		if settings.RetentionDays != nil {
+			effective.RetentionDays = *settings.RetentionDays
+			effective.Sources["retentionDays"] = level
+		}
+		if settings.SlowModeSeconds != nil {
+			effective.SlowModeSeconds = *settings.SlowModeSeconds
+			effective.Sources["slowModeSeconds"] = level
+		}
+		if settings.ReadReceipts != nil {
+			effective.ReadReceipts = *settings.ReadReceipts
+			effective.Sources["readReceipts"] = level
+		}
+		if settings.Notifications != nil {
+			effective.Notifications = *settings.Notifications
+			effective.Sources["notifications"] = level
+		}
+	}
+
+	apply(SettingsWorkspace, workspace)
+	if conversation != nil {
+		apply(SettingsConversation, conversationLevel(conversation))
+	}
+	if user != nil {
+		// Only preferences belong to users; stored values are validated, this is belt and braces
+		apply(SettingsUser, &models.Settings{ReadReceipts: user.ReadReceipts, Notifications: user.Notifications})
+	}
+	return effective
+}
+
+// conversationLevel combines a conversation's overrides with its retention override, which
+// lives on the conversation itself
+func conversationLevel(conversation *models.Conversation) *models.Settings {
+	level := models.Settings{}
+	if conversation.Settings != nil {
+		level = *conversation.Settings
+	}
+	level.RetentionDays = nil
+	if conversation.RetentionDays > 0 {
+		days := conversation.RetentionDays
+		level.RetentionDays = &days
+	}
+	return &level
+}
+
+// validate checks that settings only hold values the level may set, within bounds
+func (s *SettingsService) validate(settings *models.Settings, level string) error {
+	if days := settings.RetentionDays; days != nil {
+		if level != SettingsWorkspace {
+			return validationError("retentionDays can only be set for the workspace; use the retention endpoints for conversations")
+		}
+		if *days < 0 || (*days > 0 && (*days < s.retention.MinDays || (s.retention.MaxDays > 0 && *days > s.retention.MaxDays))) {
+			return validationError("retention outside workspace policy bounds")
+		}
+	}
+
+	if seconds := settings.SlowModeSeconds; seconds != nil {
+		if level == SettingsUser {
+			return validationError("slowModeSeconds cannot be set per user")
+		}
+		if *seconds < 0 || *seconds > maxSlowModeSeconds {
+			return validationError(fmt.Sprintf("slowModeSeconds must be between 0 and %d", maxSlowModeSeconds))
+		}
+	}
+
+	if notify := settings.Notifications; notify != nil {
+		switch *notify {
+		case models.NotifyAll, models.NotifyMentions, models.NotifyNone:
+		default:
+			return validationError("notifications must be all, mentions or none")
+		}
+	}
+
+	return nil
+}
+
+func (s *SettingsService) load(ctx context.Context, id string) (*models.SettingsDocument, error) {
+	var doc models.SettingsDocument
+	err := s.db.Collection(ctx, settingsCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	return &doc, nil
+}
+
+func (s *SettingsService) store(ctx context.Context, id, actorID string, settings *models.Settings) (*models.SettingsDocument, error) {
+	doc := &models.SettingsDocument{
+		ID:        id,
+		Settings:  *settings,
+		UpdatedBy: actorID,
+		UpdatedAt: s.clock.Now(),
+	}
+
+	_, err := s.db.Collection(ctx, settingsCollection).ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store settings: %w", err)
+	}
+	return doc, nil
+}
+
+func (s *SettingsService) audit(ctx context.Context, actorID, conversationID, level string, settings *models.Settings) {
+	details := map[string]interface{}{"level": level, "settings": settings}
+	if err := s.auditService.Record(ctx, AuditSettingsUpdated, actorID, conversationID, details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", AuditSettingsUpdated, logging.UserID, actorID, logging.ConversationID, conversationID, logging.Err(err))
+	}
+}
+
+func userSettingsID(userID string) string {
+	return "user:" + userID
+}
+
+// workspaceSettingsID keeps the default workspace's document where it was before workspaces
+func workspaceSettingsID(workspaceID string) string {
+	if workspaceID == DefaultWorkspaceID {
+		return "workspace"
+	}
+	return "workspace:" + workspaceID
+}
+
+func isEmptySettings(settings *models.Settings) bool {
+	return settings.RetentionDays == nil && settings.SlowModeSeconds == nil &&
+		settings.ReadReceipts == nil && settings.Notifications == nil
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// People without an account can take part by SMS through Twilio. Each phone number that texts
+// the workspace's Twilio number gets a virtual user, "sms:<number>", named after the number,
+// and its texts are posted by that user to its most recently active direct message, or to a new
+// one with the inbox user. Messages posted in any conversation with a virtual user are texted to
+// its number by a consumer on the CHAT stream, once the undo window has passed.
+//
+// Texting in opts a number in; STOP and the other carrier keywords opt it out until START.
+// Twilio answers those keywords itself and refuses to text opted-out numbers, so the service
+// only records the state, to skip numbers it would fail on.
+
+const (
+	smsContactsCollection = "sms_contacts"
+	smsConsumerName       = "sms"
+	smsUserPrefix         = "sms:"
+	smsSendTimeout        = 10 * time.Second
+	smsRetryDelay         = 30 * time.Second
+	// smsMaxBody is the longest text Twilio sends, as up to ten concatenated segments
+	smsMaxBody = 1600
+	// twilioUnsubscribed is Twilio's error for a number that replied STOP
+	twilioUnsubscribed = 21610
+)
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Carrier keywords, matched against a whole text ignoring case
+var (
+	smsOptOutKeywords = map[string]bool{"STOP": true, "STOPALL": true, "UNSUBSCRIBE": true, "CANCEL": true, "END": true, "QUIT": true, "OPTOUT": true, "REVOKE": true}
+	smsOptInKeywords  = map[string]bool{"START": true, "YES": true, "UNSTOP": true}
+	smsHelpKeywords   = map[string]bool{"HELP": true, "INFO": true}
+)
+
+// SMSConfig configures the Twilio bridge
+type SMSConfig struct {
+	AccountSID string // empty disables SMS
+	AuthToken  string // authenticates API calls and signs webhooks
+	FromNumber string // the Twilio number texts come from and go to
+	// WebhookURL is the public URL Twilio posts inbound texts to; signatures cover it, so it
+	// must be exactly as configured in Twilio, whatever proxies rewrite
+	WebhookURL string
+	// InboxUserID is the other side of the direct message a number's first text starts
+	InboxUserID string
+	APIBaseURL  string // Twilio's API; overridden in development
+}
+
+// SMSService bridges conversations and SMS
+type SMSService struct {
+	db                  *database.MongoDB
+	natsConn            *nats.NATSConnection
+	conversationService *ConversationService
+	messageService      *MessageService
+	userService         *UserService
+	clock               clock.Clock
+	logger              *slog.Logger
+	config              SMSConfig
+	httpClient          *http.Client
+}
+
+func NewSMSService(db *database.MongoDB, natsConn *nats.NATSConnection, conversationService *ConversationService, messageService *MessageService, userService *UserService, clk clock.Clock, logger *slog.Logger, config SMSConfig) *SMSService {
+	if config.APIBaseURL == "" {
+		config.APIBaseURL = "https://api.twilio.com"
+	}
+	return &SMSService{
+		db:                  db,
+		natsConn:            natsConn,
+		conversationService: conversationService,
+		messageService:      messageService,
+		userService:         userService,
+		clock:               clk,
+		logger:              logger,
+		config:              config,
+		httpClient:          &http.Client{Timeout: smsSendTimeout},
+	}
+}
+
+// Enabled reports whether Twilio is configured
+func (s *SMSService) Enabled() bool {
+	return s.config.AccountSID != ""
+}
+
+// VerifySignature checks X-Twilio-Signature: base64 HMAC-SHA1, keyed with the auth token, of the
+// webhook URL followed by each posted parameter's name and value in name order
+func (s *SMSService) VerifySignature(params url.Values, signature string) bool {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha1.New, []byte(s.config.AuthToken))
+	mac.Write([]byte(s.config.WebhookURL))
+	for _, name := range names {
+		for _, value := range params[name] {
+			mac.Write([]byte(name + value))
+		}
+	}
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// ReceiveSMS handles a text from a phone number. messageSID is Twilio's ID for it, so a webhook
+// Twilio delivers twice posts once.
+func (s *SMSService) ReceiveSMS(ctx context.Context, from, body, messageSID string) error {
+	if !e164Pattern.MatchString(from) {
+		return validationError("From must be an E.164 phone number")
+	}
+	keyword := strings.ToUpper(strings.TrimSpace(body))
+	switch {
+	case smsOptOutKeywords[keyword]:
+		return s.setOptedIn(ctx, from, false)
+	case smsOptInKeywords[keyword]:
+		return s.setOptedIn(ctx, from, true)
+	case smsHelpKeywords[keyword]:
+		return nil
+	}
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil // pictures and other media are not bridged
+	}
+	contact, err := s.contact(ctx, from)
+	if err != nil {
+		return err
+	}
+	conversationID, err := s.conversationFor(ctx, contact)
+	if err != nil {
+		return err
+	}
+	_, err = s.messageService.SendMessage(ctx, &models.SendMessageRequest{
+		ConversationID: conversationID,
+		ClientMsgID:    smsUserPrefix + messageSID,
+		Body:           body,
+	}, contact.UserID)
+	if err != nil {
+		return err
+	}
+	go s.conversationService.UpdateLastMessageAt(context.WithoutCancel(ctx), conversationID)
+	return nil
+}
+
+// contact returns a number's contact, creating it and its virtual user, opted in, on its
+// first text
+func (s *SMSService) contact(ctx context.Context, number string) (*models.SMSContact, error) {
+	collection := s.db.Collection(ctx, smsContactsCollection)
+	var contact models.SMSContact
+	err := collection.FindOne(ctx, bson.M{"_id": number}).Decode(&contact)
+	if err == nil {
+		return &contact, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find SMS contact: %w", err)
+	}
+
+	now := s.clock.Now()
+	contact = models.SMSContact{
+		Number:    number,
+		UserID:    smsUserPrefix + number,
+		OptedIn:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	// Texters join the inbox's workspace, so the inbox can start conversations with them
+	inbox, err := s.userService.GetUserByID(ctx, s.config.InboxUserID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.userService.UpsertUser(ctx, &models.User{
+		ID:          contact.UserID,
+		WorkspaceID: inbox.WorkspaceID,
+		Email:       number + "@sms.invalid", // emails are unique; .invalid never resolves
+		Name:        number,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := collection.InsertOne(ctx, &contact); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to save SMS contact: %w", err)
+	}
+	return &contact, nil
+}
+
+// setOptedIn records a keyword. Numbers not seen before are recorded too, so a STOP sent first
+// is honoured.
+func (s *SMSService) setOptedIn(ctx context.Context, number string, optedIn bool) error {
+	if _, err := s.contact(ctx, number); err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	set := bson.M{"optedIn": optedIn, "updatedAt": now}
+	update := bson.M{"$set": set}
+	if optedIn {
+		update["$unset"] = bson.M{"optedOutAt": ""}
+	} else {
+		set["optedOutAt"] = now
+	}
+	_, err := s.db.Collection(ctx, smsContactsCollection).UpdateOne(ctx, bson.M{"_id": number}, update)
+	if err != nil {
+		return fmt.Errorf("failed to record SMS opt-in: %w", err)
+	}
+	return nil
+}
+
+// conversationFor picks where a number's text goes: its most recently active direct message,
+// or a new one with the inbox user
+func (s *SMSService) conversationFor(ctx context.Context, contact *models.SMSContact) (string, error) {
+	conversations, err := s.conversationService.GetUserConversations(ctx, contact.UserID)
+	if err != nil {
+		return "", err
+	}
+	for _, conversation := range conversations {
+		if conversation.Kind == "dm" {
+			return conversation.ID, nil
+		}
+	}
+	if s.config.InboxUserID == "" {
+		return "", validationError("no conversation takes texts from this number")
+	}
+	conversation, err := s.conversationService.CreateConversation(ctx, &models.CreateConversationRequest{
+		Kind:    "dm",
+		Members: []string{contact.UserID},
+	}, s.config.InboxUserID)
+	if err != nil {
+		return "", err
+	}
+	return conversation.ID, nil
+}
+
+// Run texts new messages to the SMS participants of their conversations until ctx is cancelled
+func (s *SMSService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		s.logger.Info("SMS bridge disabled")
+		return
+	}
+
+	consumer, err := s.natsConn.JS.CreateOrUpdateConsumer(ctx, nats.ChatStream, jetstream.ConsumerConfig{
+		Durable:       smsConsumerName,
+		Description:   "SMS delivery through Twilio",
+		FilterSubject: "chat.conv.*.msg",
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+	})
+	if err != nil {
+		s.logger.Error("Failed to create SMS consumer", logging.Err(err))
+		return
+	}
+
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(dispatchBatchSize, jetstream.FetchMaxWait(5*time.Second))
+		if err != nil {
+			s.logger.Warn("Failed to fetch messages to text", logging.Err(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for msg := range batch.Messages() {
+			s.handle(tenant.NewContext(ctx, msg.Headers().Get(tenant.Header)), msg)
+		}
+	}
+}
+
+func (s *SMSService) handle(ctx context.Context, msg jetstream.Msg) {
+	if nats.MessageEvent(msg.Headers()) != nats.EventMessageCreated {
+		msg.Ack()
+		return
+	}
+	var message models.WSMessageNewData
+	if err := json.Unmarshal(msg.Data(), &message); err != nil {
+		s.logger.Error("Failed to unmarshal message to text", logging.Err(err))
+		msg.Term()
+		return
+	}
+
+	participants, err := s.conversationService.participantUserIDs(ctx, message.ConversationID)
+	if err != nil {
+		s.logger.Error("Failed to find SMS recipients", logging.ConversationID, message.ConversationID, logging.Err(err))
+		msg.Nak()
+		return
+	}
+	var numbers []string
+	for _, userID := range participants {
+		if number, ok := strings.CutPrefix(userID, smsUserPrefix); ok && userID != message.SenderID {
+			numbers = append(numbers, number)
+		}
+	}
+	if len(numbers) == 0 {
+		msg.Ack()
+		return
+	}
+
+	wait, retracted, err := awaitRetraction(ctx, s.db, s.clock, &message)
+	switch {
+	case err != nil:
+		s.logger.Error("Failed to check message retraction", logging.MessageID, message.ID, logging.Err(err))
+		msg.Nak()
+		return
+	case wait > 0:
+		msg.NakWithDelay(wait)
+		return
+	case retracted:
+		msg.Ack()
+		return
+	}
+
+	body := message.Body
+	if len(participants) > 2 {
+		// In groups, say who wrote it
+		name := message.SenderID
+		if message.Sender != nil && message.Sender.Name != "" {
+			name = message.Sender.Name
+		}
+		body = name + ": " + body
+	}
+	if runes := []rune(body); len(runes) > smsMaxBody {
+		body = string(runes[:smsMaxBody-1]) + "…"
+	}
+
+	temporary := 0
+	for _, number := range numbers {
+		if err := s.text(ctx, number, body); err != nil {
+			s.logger.Warn("Failed to text message", logging.MessageID, message.ID, logging.Err(err))
+			if isTemporary(err) {
+				temporary++
+			}
+		}
+	}
+	// A retry texts every number again, so it is only worth it when none got the text
+	if temporary == len(numbers) {
+		msg.NakWithDelay(smsRetryDelay)
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		s.logger.Error("Failed to ack texted message", logging.MessageID, message.ID, logging.Err(err))
+	}
+}
+
+// twilioError is the body of a failed Twilio API call
+type twilioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	status  int
+}
+
+func (e *twilioError) Error() string {
+	return fmt.Sprintf("twilio returned %d: %d %s", e.status, e.Code, e.Message)
+}
+
+// isTemporary reports whether a failed text is worth sending again
+func isTemporary(err error) bool {
+	twilioErr, ok := err.(*twilioError)
+	return !ok || twilioErr.status == http.StatusTooManyRequests || twilioErr.status >= 500
+}
+
+// text sends one SMS to an opted-in number
+func (s *SMSService) text(ctx context.Context, number, body string) error {
+	var contact models.SMSContact
+	err := s.db.Collection(ctx, smsContactsCollection).FindOne(ctx, bson.M{"_id": number}).Decode(&contact)
+	if err == mongo.ErrNoDocuments || (err == nil && !contact.OptedIn) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find SMS contact: %w", err)
+	}
+
+	form := url.Values{"To": {number}, "From": {s.config.FromNumber}, "Body": {body}}
+	endpoint := s.config.APIBaseURL + "/2010-04-01/Accounts/" + url.PathEscape(s.config.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build SMS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.config.AccountSID, s.config.AuthToken)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Twilio: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	twilioErr := &twilioError{status: resp.StatusCode}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(twilioErr)
+	if twilioErr.Code == twilioUnsubscribed {
+		return s.setOptedIn(ctx, number, false)
+	}
+	return twilioErr
+}
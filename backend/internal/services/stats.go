@@ -0,0 +1,56 @@
+package services
+
+// HubStats is a point-in-time view of the hub for the debug endpoint
+type HubStats struct {
+	Clients       int `json:"clients"`
+	Subscriptions int `json:"subscriptions"`
+
+	// Frames waiting in client send queues; a growing maximum points at a stuck writer
+	SendQueued   int `json:"sendQueued"`
+	SendQueueMax int `json:"sendQueueMax"`
+
+	// Per-client depths, keyed by client ID, for clients with anything queued
+	SendQueueDepths map[string]int `json:"sendQueueDepths"`
+
+	// Typing and presence frames discarded for slow clients since startup, by frame type, and
+	// clients and event streams disconnected for falling behind on everything else
+	DroppedFrames           map[string]int64 `json:"droppedFrames"`
+	SlowConsumerDisconnects int64            `json:"slowConsumerDisconnects"`
+
+	// Outbound frame sizes since startup, and clients that negotiated compression
+	FrameSizes        FrameSizeStats `json:"frameSizes"`
+	CompressedClients int            `json:"compressedClients"`
+}
+
+// Stats counts clients, conversation subscriptions and queued outbound frames
+func (h *WebSocketHub) Stats() HubStats {
+	stats := HubStats{SendQueueDepths: make(map[string]int)}
+
+	h.clientsMu.RLock()
+	stats.Clients = len(h.clients)
+	for id, client := range h.clients {
+		if client.compressed {
+			stats.CompressedClients++
+		}
+		depth := len(client.Send) + client.ephemeral.len()
+		if depth == 0 {
+			continue
+		}
+		stats.SendQueued += depth
+		stats.SendQueueDepths[id] = depth
+		if depth > stats.SendQueueMax {
+			stats.SendQueueMax = depth
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	h.subsMu.RLock()
+	stats.Subscriptions = len(h.subscriptions)
+	h.subsMu.RUnlock()
+
+	stats.DroppedFrames = h.backpressure.droppedByType()
+	stats.SlowConsumerDisconnects = h.backpressure.slowDisconnects.Load()
+	stats.FrameSizes = h.frameSizes.snapshot()
+
+	return stats
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stream reconfiguration statuses
+const (
+	StreamReconfigScheduled  = "scheduled"
+	StreamReconfigRunning    = "running"
+	StreamReconfigCompleted  = "completed"
+	StreamReconfigRejected   = "rejected"    // a pre-check failed; the stream was not touched
+	StreamReconfigRolledBack = "rolled_back" // a step failed and the previous config was restored
+	StreamReconfigFailed     = "failed"      // a step failed and so did the rollback
+)
+
+const (
+	streamReconfigCollection = "stream_reconfigs"
+	streamReconfigPoll       = 30 * time.Second
+	streamHealthPoll         = 2 * time.Second
+	maxStreamReplicas        = 5
+)
+
+// StreamConfig tunes how stream reconfigurations are rate-controlled and checked
+type StreamConfig struct {
+	Cooldown      time.Duration // minimum time between reconfigurations
+	MaxLag        uint64        // pre-check: no consumer may be further behind than this
+	StoreHeadroom float64       // pre-check: fraction of the account store limit to keep free
+	HealthTimeout time.Duration // how long each step may take to report healthy replicas
+}
+
+// StreamConfigService applies CHAT stream changes (replicas, size and age limits) at a
+// scheduled, quiet time instead of implicitly at startup. Each job runs pre-checks, changes
+// replicas one at a time waiting for the replicas to catch up in between, and restores the
+// previous configuration if any step fails.
+type StreamConfigService struct {
+	natsConn     *nats.NATSConnection
+	db           *database.MongoDB
+	userService  *UserService
+	auditService *AuditService
+	clock        clock.Clock
+	logger       *slog.Logger
+	ids          IDGenerator
+	config       StreamConfig
+}
+
+func NewStreamConfigService(natsConn *nats.NATSConnection, db *database.MongoDB, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator, config StreamConfig) *StreamConfigService {
+	return &StreamConfigService{
+		natsConn:     natsConn,
+		db:           db,
+		userService:  userService,
+		auditService: auditService,
+		clock:        clk,
+		logger:       logger,
+		ids:          ids,
+		config:       config,
+	}
+}
+
+// Schedule records a reconfiguration of the CHAT stream to run at req.ScheduledFor
+func (s *StreamConfigService) Schedule(ctx context.Context, actorID string, req *models.StreamReconfigRequest) (*models.StreamReconfigJob, error) {
+	if err := requireDeploymentAdmin(ctx, s.userService, actorID); err != nil {
+		return nil, err
+	}
+
+	info, err := s.natsConn.ChatStreamInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target := streamSettings(info.Config)
+	if req.Replicas != nil {
+		target.Replicas = *req.Replicas
+	}
+	if req.MaxBytes != nil {
+		target.MaxBytes = *req.MaxBytes
+	}
+	if req.MaxAgeSeconds != nil {
+		target.MaxAgeSeconds = *req.MaxAgeSeconds
+	}
+
+	if target.Replicas < 1 || target.Replicas > maxStreamReplicas {
+		return nil, validationError(fmt.Sprintf("replicas must be between 1 and %d", maxStreamReplicas))
+	}
+	if target.MaxBytes == 0 || target.MaxBytes < -1 {
+		return nil, validationError("maxBytes must be positive or -1 for unlimited")
+	}
+	if target.MaxAgeSeconds < 0 {
+		return nil, validationError("maxAgeSeconds cannot be negative")
+	}
+	if target == streamSettings(info.Config) {
+		return nil, validationError("no change to the stream configuration")
+	}
+
+	now := s.clock.Now()
+	scheduledFor := now
+	if req.ScheduledFor != nil && req.ScheduledFor.After(now) {
+		scheduledFor = *req.ScheduledFor
+	}
+
+	// Rate control: one pending job at a time, and none within the cooldown of the last one
+	collection := s.db.Collection(ctx, streamReconfigCollection)
+	pending, err := collection.CountDocuments(ctx, bson.M{
+		"status": bson.M{"$in": []string{StreamReconfigScheduled, StreamReconfigRunning}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pending reconfigurations: %w", err)
+	}
+	if pending > 0 {
+		return nil, conflictError("a stream reconfiguration is already scheduled")
+	}
+
+	var last models.StreamReconfigJob
+	err = collection.FindOne(ctx, bson.M{"startedAt": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.M{"startedAt": -1})).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to check last reconfiguration: %w", err)
+	}
+	if err == nil && scheduledFor.Sub(*last.StartedAt) < s.config.Cooldown {
+		return nil, conflictError(fmt.Sprintf("stream was reconfigured at %s; schedule after %s",
+			last.StartedAt.Format(time.RFC3339), last.StartedAt.Add(s.config.Cooldown).Format(time.RFC3339)))
+	}
+
+	job := &models.StreamReconfigJob{
+		ID:           s.ids.NewID(),
+		Status:       StreamReconfigScheduled,
+		RequestedBy:  actorID,
+		Target:       target,
+		ScheduledFor: scheduledFor,
+		CreatedAt:    now,
+	}
+	if _, err := collection.InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to schedule stream reconfiguration: %w", err)
+	}
+
+	if err := s.auditService.Record(ctx, AuditStreamReconfigScheduled, actorID, "", map[string]interface{}{
+		"jobId":        job.ID,
+		"target":       job.Target,
+		"scheduledFor": job.ScheduledFor,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit stream reconfiguration", logging.JobID, job.ID, logging.Err(err))
+	}
+
+	return job, nil
+}
+
+// GetJob returns a stream reconfiguration's progress
+func (s *StreamConfigService) GetJob(ctx context.Context, jobID, actorID string) (*models.StreamReconfigJob, error) {
+	if err := requireDeploymentAdmin(ctx, s.userService, actorID); err != nil {
+		return nil, err
+	}
+
+	var job models.StreamReconfigJob
+	err := s.db.Collection(ctx, streamReconfigCollection).FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("stream reconfiguration not found")
+		}
+		return nil, fmt.Errorf("failed to get stream reconfiguration: %w", err)
+	}
+
+	return &job, nil
+}
+
+// Run executes due reconfigurations until ctx is cancelled. A job interrupted by a restart is
+// rolled back to its recorded previous configuration rather than resumed mid-way.
+func (s *StreamConfigService) Run(ctx context.Context) {
+	collection := s.db.Collection(ctx, streamReconfigCollection)
+
+	var interrupted []models.StreamReconfigJob
+	cursor, err := collection.Find(ctx, bson.M{"status": StreamReconfigRunning})
+	if err == nil {
+		err = cursor.All(ctx, &interrupted)
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up interrupted stream reconfigurations", logging.Err(err))
+	}
+	for _, job := range interrupted {
+		s.logger.Warn("Rolling back interrupted stream reconfiguration", logging.JobID, job.ID)
+		s.rollback(ctx, &job, fmt.Errorf("interrupted by a restart"))
+	}
+
+	ticker := time.NewTicker(streamReconfigPoll)
+	defer ticker.Stop()
+
+	for {
+		var job models.StreamReconfigJob
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"status": StreamReconfigScheduled, "scheduledFor": bson.M{"$lte": s.clock.Now()}},
+			bson.M{"$set": bson.M{"status": StreamReconfigRunning, "startedAt": s.clock.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&job)
+		if err == nil {
+			s.execute(ctx, &job)
+		} else if err != mongo.ErrNoDocuments && ctx.Err() == nil {
+			s.logger.Error("Failed to claim stream reconfiguration", logging.Err(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *StreamConfigService) execute(ctx context.Context, job *models.StreamReconfigJob) {
+	info, err := s.natsConn.ChatStreamInfo(ctx)
+	if err != nil {
+		s.finish(job, StreamReconfigRejected, err)
+		return
+	}
+
+	previous := streamSettings(info.Config)
+	job.Previous = &previous
+	s.update(job.ID, bson.M{"previous": previous})
+
+	checks, err := s.preCheck(ctx, info, job.Target)
+	job.Checks = checks
+	if checks != nil {
+		s.update(job.ID, bson.M{"checks": checks})
+	}
+	if err != nil {
+		s.logger.Warn("Stream reconfiguration rejected", logging.JobID, job.ID, logging.Err(err))
+		s.finish(job, StreamReconfigRejected, err)
+		return
+	}
+
+	// Limits change in the first step; replicas move one at a time so a single
+	// catching-up replica is all the cluster has to absorb
+	config := info.Config
+	config.MaxBytes = job.Target.MaxBytes
+	config.MaxAge = time.Duration(job.Target.MaxAgeSeconds) * time.Second
+	for {
+		if config.Replicas < job.Target.Replicas {
+			config.Replicas++
+		} else if config.Replicas > job.Target.Replicas {
+			config.Replicas--
+		}
+
+		if _, err := s.natsConn.UpdateChatStream(ctx, config); err != nil {
+			s.rollback(ctx, job, err)
+			return
+		}
+		if err := s.waitHealthy(ctx, config.Replicas); err != nil {
+			s.rollback(ctx, job, err)
+			return
+		}
+
+		job.AppliedReplicas = config.Replicas
+		s.update(job.ID, bson.M{"appliedReplicas": config.Replicas})
+		if config.Replicas == job.Target.Replicas {
+			break
+		}
+	}
+
+	s.finish(job, StreamReconfigCompleted, nil)
+	s.logger.Info("Stream reconfiguration completed", logging.JobID, job.ID)
+}
+
+// preCheck refuses changes that would disrupt delivery: consumers already far behind, a size
+// limit below the data the stream holds, or new replicas that would not fit in the account
+func (s *StreamConfigService) preCheck(ctx context.Context, info *jetstream.StreamInfo, target models.StreamSettings) (*models.StreamPreChecks, error) {
+	lag, err := s.natsConn.ConsumerLag(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.natsConn.JS.AccountInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account info: %w", err)
+	}
+
+	checks := &models.StreamPreChecks{
+		StreamBytes: info.State.Bytes,
+		StoreUsed:   account.Store,
+		StoreLimit:  account.Limits.MaxStore,
+	}
+	for name, pending := range lag {
+		if strings.HasPrefix(name, offlineConsumerPrefix) {
+			// Parked offline consumers only accumulate until their user reconnects
+			continue
+		}
+		if pending > checks.MaxConsumerLag {
+			checks.LaggingConsumer, checks.MaxConsumerLag = name, pending
+		}
+	}
+
+	if checks.MaxConsumerLag > s.config.MaxLag {
+		return checks, fmt.Errorf("consumer %s is %d messages behind (limit %d)", checks.LaggingConsumer, checks.MaxConsumerLag, s.config.MaxLag)
+	}
+	if target.MaxBytes > 0 && uint64(target.MaxBytes) < info.State.Bytes {
+		return checks, fmt.Errorf("maxBytes %d is below the %d bytes the stream holds", target.MaxBytes, info.State.Bytes)
+	}
+	if added := target.Replicas - info.Config.Replicas; added > 0 && checks.StoreLimit > 0 {
+		needed := checks.StoreUsed + uint64(added)*info.State.Bytes
+		available := uint64(float64(checks.StoreLimit) * (1 - s.config.StoreHeadroom))
+		if needed > available {
+			return checks, fmt.Errorf("%d more replicas need %d bytes of storage; %d available", added, needed, available)
+		}
+	}
+
+	return checks, nil
+}
+
+// waitHealthy waits until the stream reports the given number of current, online replicas
+func (s *StreamConfigService) waitHealthy(ctx context.Context, replicas int) error {
+	deadline := s.clock.Now().Add(s.config.HealthTimeout)
+	for {
+		info, err := s.natsConn.ChatStreamInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if streamHealthy(info, replicas) {
+			return nil
+		}
+		if !s.clock.Now().Before(deadline) {
+			return fmt.Errorf("stream replicas not current after %s", s.config.HealthTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(streamHealthPoll):
+		}
+	}
+}
+
+func streamHealthy(info *jetstream.StreamInfo, replicas int) bool {
+	if info.Cluster == nil {
+		// Not clustered: a single server has nothing to catch up
+		return true
+	}
+	if info.Cluster.Leader == "" || len(info.Cluster.Replicas) != replicas-1 {
+		return false
+	}
+	for _, peer := range info.Cluster.Replicas {
+		if !peer.Current || peer.Offline {
+			return false
+		}
+	}
+	return true
+}
+
+// rollback restores the configuration recorded before the job started
+func (s *StreamConfigService) rollback(ctx context.Context, job *models.StreamReconfigJob, cause error) {
+	s.logger.Warn("Stream reconfiguration failed, rolling back", logging.JobID, job.ID, logging.Err(cause))
+	if job.Previous == nil {
+		s.finish(job, StreamReconfigRolledBack, cause)
+		return
+	}
+
+	// Roll back even while shutting down; a half-applied config is the worse outcome
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.config.HealthTimeout)
+	defer cancel()
+
+	info, err := s.natsConn.ChatStreamInfo(rollbackCtx)
+	if err == nil {
+		config := info.Config
+		config.Replicas = job.Previous.Replicas
+		config.MaxBytes = job.Previous.MaxBytes
+		config.MaxAge = time.Duration(job.Previous.MaxAgeSeconds) * time.Second
+		_, err = s.natsConn.UpdateChatStream(rollbackCtx, config)
+	}
+	if err != nil {
+		s.logger.Error("Failed to roll back stream reconfiguration", logging.JobID, job.ID, logging.Err(err))
+		s.finish(job, StreamReconfigFailed, fmt.Errorf("%v; rollback failed: %v", cause, err))
+		return
+	}
+
+	s.finish(job, StreamReconfigRolledBack, cause)
+}
+
+func (s *StreamConfigService) finish(job *models.StreamReconfigJob, status string, cause error) {
+	completedAt := s.clock.Now()
+	set := bson.M{"status": status, "completedAt": completedAt}
+	if cause != nil {
+		set["error"] = cause.Error()
+	}
+	s.update(job.ID, set)
+
+	action := AuditStreamReconfigCompleted
+	switch status {
+	case StreamReconfigRejected:
+		action = AuditStreamReconfigRejected
+	case StreamReconfigRolledBack, StreamReconfigFailed:
+		action = AuditStreamReconfigRolledBack
+	}
+	details := map[string]interface{}{"jobId": job.ID, "status": status, "target": job.Target, "previous": job.Previous}
+	if cause != nil {
+		details["error"] = cause.Error()
+	}
+	if err := s.auditService.Record(context.Background(), action, job.RequestedBy, "", details); err != nil {
+		s.logger.Error("Failed to audit stream reconfiguration", logging.JobID, job.ID, logging.Err(err))
+	}
+}
+
+// update records job progress; it outlives shutdown so the final state is not lost
+func (s *StreamConfigService) update(jobID string, set bson.M) {
+	ctx := context.Background()
+	_, err := s.db.Collection(ctx, streamReconfigCollection).UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": set})
+	if err != nil {
+		s.logger.Error("Failed to update stream reconfiguration", logging.JobID, jobID, logging.Err(err))
+	}
+}
+
+func streamSettings(config jetstream.StreamConfig) models.StreamSettings {
+	return models.StreamSettings{
+		Replicas:      config.Replicas,
+		MaxBytes:      config.MaxBytes,
+		MaxAgeSeconds: int64(config.MaxAge / time.Second),
+	}
+}
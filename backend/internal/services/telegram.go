@@ -0,0 +1,587 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Conversation admins can link a group to a Telegram chat the relay's bot has been added to.
+// Telegram delivers the chat's messages to a webhook, and the relay user posts them in the
+// conversation as "Name: text", with media linked through a proxy so the bot token stays
+// private. Messages posted in the conversation are sent to the chat, attributed in bold, by a
+// consumer on the CHAT stream once the undo window has passed; GIFs and stickers go as
+// animations and locations as venues.
+
+const (
+	telegramLinksCollection = "telegram_links"
+	telegramConsumerName    = "telegram"
+	telegramTimeout         = 10 * time.Second
+	telegramRetryDelay      = 30 * time.Second
+	// telegramMaxFile is the largest file the Bot API lets bots download
+	telegramMaxFile = 20 << 20
+)
+
+// TelegramConfig configures the Telegram relay
+type TelegramConfig struct {
+	BotToken string // empty disables the relay
+	// WebhookURL is the public URL of POST /webhooks/telegram, registered with Telegram at start
+	WebhookURL string
+	// WebhookSecret is registered with the webhook; Telegram sends it back on every update
+	WebhookSecret string
+	// UserID posts Telegram messages in linked conversations; it must be a member of each
+	UserID     string
+	APIBaseURL string // the Bot API; overridden in development
+}
+
+// TelegramService relays linked conversations to Telegram chats
+type TelegramService struct {
+	db                  *database.MongoDB
+	natsConn            *nats.NATSConnection
+	conversationService *ConversationService
+	messageService      *MessageService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+	config              TelegramConfig
+	httpClient          *http.Client
+}
+
+func NewTelegramService(db *database.MongoDB, natsConn *nats.NATSConnection, conversationService *ConversationService, messageService *MessageService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, config TelegramConfig) *TelegramService {
+	if config.APIBaseURL == "" {
+		config.APIBaseURL = "https://api.telegram.org"
+	}
+	return &TelegramService{
+		db:                  db,
+		natsConn:            natsConn,
+		conversationService: conversationService,
+		messageService:      messageService,
+		auditService:        auditService,
+		clock:               clk,
+		logger:              logger,
+		config:              config,
+		httpClient:          &http.Client{Timeout: telegramTimeout},
+	}
+}
+
+// Enabled reports whether a bot is configured
+func (s *TelegramService) Enabled() bool {
+	return s.config.BotToken != ""
+}
+
+// VerifySecret checks the X-Telegram-Bot-Api-Secret-Token of a webhook request
+func (s *TelegramService) VerifySecret(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.WebhookSecret)) == 1
+}
+
+// GetLink returns a conversation's Telegram link to any participant
+func (s *TelegramService) GetLink(ctx context.Context, conversationID, userID string) (*models.TelegramLink, error) {
+	if _, err := s.conversationService.GetParticipant(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+	return s.link(ctx, conversationID)
+}
+
+// Link relays a group conversation to a Telegram chat, replacing any chat it was linked to
+func (s *TelegramService) Link(ctx context.Context, conversationID, actorID string, req *models.LinkTelegramRequest) (*models.TelegramLink, error) {
+	if !s.Enabled() {
+		return nil, validationError("the Telegram relay is not configured")
+	}
+	if err := s.requireAdmin(ctx, conversationID, actorID); err != nil {
+		return nil, err
+	}
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Kind != "group" {
+		return nil, validationError("only group conversations can be linked to Telegram")
+	}
+	member, err := s.conversationService.IsUserParticipant(ctx, conversationID, s.config.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, validationError("add the Telegram relay user to the conversation first")
+	}
+
+	var chat struct {
+		Title string `json:"title"`
+	}
+	if err := s.call(ctx, "getChat", map[string]interface{}{"chat_id": req.ChatID}, &chat); err != nil {
+		if telegramErr, ok := err.(*telegramError); ok && telegramErr.Code < 500 {
+			return nil, validationError("the relay's bot is not in that Telegram chat")
+		}
+		s.logger.ErrorContext(ctx, "Telegram chat lookup failed", logging.Err(err))
+		return nil, upstreamError("Telegram is unavailable")
+	}
+
+	collection := s.db.Collection(ctx, telegramLinksCollection)
+	count, err := collection.CountDocuments(ctx, bson.M{"chatId": req.ChatID, "_id": bson.M{"$ne": conversationID}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check Telegram links: %w", err)
+	}
+	if count > 0 {
+		return nil, conflictError("that Telegram chat is linked to another conversation")
+	}
+
+	link := &models.TelegramLink{
+		ConversationID: conversationID,
+		ChatID:         req.ChatID,
+		ChatTitle:      chat.Title,
+		LinkedBy:       actorID,
+		LinkedAt:       s.clock.Now(),
+	}
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": conversationID}, link, options.Replace().SetUpsert(true)); err != nil {
+		return nil, fmt.Errorf("failed to link Telegram chat: %w", err)
+	}
+	s.audit(ctx, AuditTelegramLinked, actorID, link)
+	return link, nil
+}
+
+// Unlink stops relaying a conversation to Telegram
+func (s *TelegramService) Unlink(ctx context.Context, conversationID, actorID string) error {
+	if err := s.requireAdmin(ctx, conversationID, actorID); err != nil {
+		return err
+	}
+	link, err := s.link(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Collection(ctx, telegramLinksCollection).DeleteOne(ctx, bson.M{"_id": conversationID}); err != nil {
+		return fmt.Errorf("failed to unlink Telegram chat: %w", err)
+	}
+	s.audit(ctx, AuditTelegramUnlinked, actorID, link)
+	return nil
+}
+
+func (s *TelegramService) link(ctx context.Context, conversationID string) (*models.TelegramLink, error) {
+	var link models.TelegramLink
+	err := s.db.Collection(ctx, telegramLinksCollection).FindOne(ctx, bson.M{"_id": conversationID}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("conversation is not linked to Telegram")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Telegram link: %w", err)
+	}
+	return &link, nil
+}
+
+func (s *TelegramService) requireAdmin(ctx context.Context, conversationID, actorID string) error {
+	_, err := requireConversationPermission(ctx, s.conversationService, conversationID, actorID, permissions.ManageIntegrations, "only admins can link Telegram chats")
+	return err
+}
+
+func (s *TelegramService) audit(ctx context.Context, action, actorID string, link *models.TelegramLink) {
+	if err := s.auditService.Record(ctx, action, actorID, link.ConversationID, map[string]interface{}{
+		"chatId": link.ChatID,
+	}); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit Telegram link change", logging.ConversationID, link.ConversationID, logging.Err(err))
+	}
+}
+
+// TelegramUpdate is the part of a Bot API update the relay reads
+type TelegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID  int64         `json:"message_id"`
+	From       *telegramUser `json:"from"`
+	SenderChat *struct {
+		Title string `json:"title"`
+	} `json:"sender_chat"` // set for anonymous admins and channel posts
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text      string           `json:"text"`
+	Caption   string           `json:"caption"`
+	Photo     []telegramFile   `json:"photo"` // sizes, smallest first
+	Animation *telegramFile    `json:"animation"`
+	Video     *telegramFile    `json:"video"`
+	Document  *telegramFile    `json:"document"`
+	Audio     *telegramFile    `json:"audio"`
+	Voice     *telegramFile    `json:"voice"`
+	Sticker   *telegramFile    `json:"sticker"`
+	Location  *models.Location `json:"location"`
+}
+
+type telegramUser struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+type telegramFile struct {
+	FileID string `json:"file_id"`
+}
+
+// ReceiveUpdate posts a message from a linked Telegram chat in its conversation. Telegram
+// repeats updates until one is answered, so each posts once.
+func (s *TelegramService) ReceiveUpdate(ctx context.Context, update *TelegramUpdate) error {
+	m := update.Message
+	if m == nil {
+		return nil
+	}
+	var link models.TelegramLink
+	err := s.db.Collection(ctx, telegramLinksCollection).FindOne(ctx, bson.M{"chatId": m.Chat.ID}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return nil // the bot is in chats that are not linked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Telegram link: %w", err)
+	}
+
+	req := &models.SendMessageRequest{
+		ConversationID: link.ConversationID,
+		ClientMsgID:    "telegram:" + strconv.FormatInt(m.Chat.ID, 10) + ":" + strconv.FormatInt(m.MessageID, 10),
+		Body:           telegramBody(link.ConversationID, m),
+	}
+	if m.Location != nil {
+		req.Type = models.MessageTypeLocation
+		req.Payload, _ = json.Marshal(&models.LocationRequest{Latitude: m.Location.Latitude, Longitude: m.Location.Longitude})
+	}
+	if req.Body == "" {
+		return nil // joins, pins and other service messages
+	}
+	if _, err := s.messageService.SendMessage(ctx, req, s.config.UserID); err != nil {
+		return err
+	}
+	go s.conversationService.UpdateLastMessageAt(context.WithoutCancel(ctx), link.ConversationID)
+	return nil
+}
+
+// telegramBody renders a Telegram message as "Name: text", with a line linking to its media
+func telegramBody(conversationID string, m *telegramMessage) string {
+	name := "Telegram"
+	switch {
+	case m.SenderChat != nil && m.SenderChat.Title != "":
+		name = m.SenderChat.Title
+	case m.From != nil:
+		name = strings.TrimSpace(m.From.FirstName + " " + m.From.LastName)
+		if name == "" {
+			name = "@" + m.From.Username
+		}
+	}
+
+	var kind string
+	var file *telegramFile
+	switch {
+	case len(m.Photo) > 0:
+		kind, file = "photo", &m.Photo[len(m.Photo)-1]
+	case m.Animation != nil:
+		kind, file = "GIF", m.Animation
+	case m.Video != nil:
+		kind, file = "video", m.Video
+	case m.Voice != nil:
+		kind, file = "voice message", m.Voice
+	case m.Audio != nil:
+		kind, file = "audio", m.Audio
+	case m.Sticker != nil:
+		kind, file = "sticker", m.Sticker
+	case m.Document != nil:
+		kind, file = "file", m.Document
+	case m.Location != nil:
+		kind = "location"
+	}
+
+	text := strings.TrimSpace(m.Text + m.Caption)
+	var lines []string
+	switch {
+	case text != "":
+		lines = append(lines, name+": "+text)
+	case kind != "":
+		lines = append(lines, name+" sent a "+kind)
+	}
+	if file != nil {
+		lines = append(lines, kind+": /v1/conversations/"+url.PathEscape(conversationID)+"/telegram/files/"+url.PathEscape(file.FileID))
+	}
+	body := strings.Join(lines, "\n")
+	if runes := []rune(body); len(runes) > 4000 {
+		body = string(runes[:3999]) + "…"
+	}
+	return body
+}
+
+// File streams a file from a linked chat to a participant of its conversation. The caller
+// closes the body.
+func (s *TelegramService) File(ctx context.Context, conversationID, userID, fileID string) (io.ReadCloser, string, error) {
+	if _, err := s.GetLink(ctx, conversationID, userID); err != nil {
+		return nil, "", err
+	}
+	var file struct {
+		FilePath string `json:"file_path"`
+		FileSize int64  `json:"file_size"`
+	}
+	if err := s.call(ctx, "getFile", map[string]interface{}{"file_id": fileID}, &file); err != nil {
+		if telegramErr, ok := err.(*telegramError); ok && telegramErr.Code < 500 {
+			return nil, "", notFoundError("file not found")
+		}
+		s.logger.ErrorContext(ctx, "Telegram file lookup failed", logging.Err(err))
+		return nil, "", upstreamError("Telegram is unavailable")
+	}
+	if file.FilePath == "" || file.FileSize > telegramMaxFile {
+		return nil, "", validationError("the file is too large for bots to download")
+	}
+	entitlements, err := workspaceEntitlements(ctx, s.db, DefaultWorkspaceID)
+	if err != nil {
+		return nil, "", err
+	}
+	if entitlements.MaxFileBytes > 0 && file.FileSize > entitlements.MaxFileBytes {
+		return nil, "", quotaError(fmt.Sprintf("the workspace's plan allows files of up to %d KB", entitlements.MaxFileBytes>>10))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.APIBaseURL+"/file/bot"+s.config.BotToken+"/"+file.FilePath, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build Telegram file request: %w", err)
+	}
+	resp, err := (&http.Client{}).Do(req) // no client timeout: the body streams to the caller
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Telegram file download failed", logging.Err(errorsWithoutURL(err)))
+		return nil, "", upstreamError("Telegram is unavailable")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		s.logger.ErrorContext(ctx, "Telegram file download failed", "status", resp.StatusCode)
+		return nil, "", upstreamError("Telegram is unavailable")
+	}
+	contentType := mime.TypeByExtension(path.Ext(file.FilePath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return resp.Body, contentType, nil
+}
+
+// Run registers the webhook and sends new messages in linked conversations to their chats
+// until ctx is cancelled
+func (s *TelegramService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		s.logger.Info("Telegram relay disabled")
+		return
+	}
+
+	err := s.call(ctx, "setWebhook", map[string]interface{}{
+		"url":             s.config.WebhookURL,
+		"secret_token":    s.config.WebhookSecret,
+		"allowed_updates": []string{"message"},
+	}, nil)
+	if err != nil {
+		s.logger.Error("Failed to register Telegram webhook", logging.Err(err))
+	}
+
+	consumer, err := s.natsConn.JS.CreateOrUpdateConsumer(ctx, nats.ChatStream, jetstream.ConsumerConfig{
+		Durable:       telegramConsumerName,
+		Description:   "Telegram relay",
+		FilterSubject: "chat.conv.*.msg",
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+	})
+	if err != nil {
+		s.logger.Error("Failed to create Telegram consumer", logging.Err(err))
+		return
+	}
+
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(dispatchBatchSize, jetstream.FetchMaxWait(5*time.Second))
+		if err != nil {
+			s.logger.Warn("Failed to fetch messages to relay", logging.Err(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for msg := range batch.Messages() {
+			s.handle(tenant.NewContext(ctx, msg.Headers().Get(tenant.Header)), msg)
+		}
+	}
+}
+
+func (s *TelegramService) handle(ctx context.Context, msg jetstream.Msg) {
+	if nats.MessageEvent(msg.Headers()) != nats.EventMessageCreated {
+		msg.Ack()
+		return
+	}
+	var message models.WSMessageNewData
+	if err := json.Unmarshal(msg.Data(), &message); err != nil {
+		s.logger.Error("Failed to unmarshal message to relay", logging.Err(err))
+		msg.Term()
+		return
+	}
+	// The relay user's messages came from Telegram
+	if message.SenderID == s.config.UserID || message.Type == models.MessageTypeSystem {
+		msg.Ack()
+		return
+	}
+
+	var link models.TelegramLink
+	err := s.db.Collection(ctx, telegramLinksCollection).FindOne(ctx, bson.M{"_id": message.ConversationID}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		msg.Ack()
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to get Telegram link", logging.ConversationID, message.ConversationID, logging.Err(err))
+		msg.Nak()
+		return
+	}
+
+	wait, retracted, err := awaitRetraction(ctx, s.db, s.clock, &message)
+	switch {
+	case err != nil:
+		s.logger.Error("Failed to check message retraction", logging.MessageID, message.ID, logging.Err(err))
+		msg.Nak()
+		return
+	case wait > 0:
+		msg.NakWithDelay(wait)
+		return
+	case retracted:
+		msg.Ack()
+		return
+	}
+
+	method, params := telegramOutbound(link.ChatID, &message)
+	if err := s.call(ctx, method, params, nil); err != nil {
+		s.logger.Warn("Failed to relay message to Telegram", logging.MessageID, message.ID, "chat_id", link.ChatID, logging.Err(err))
+		if telegramErr, ok := err.(*telegramError); !ok || telegramErr.Code == http.StatusTooManyRequests || telegramErr.Code >= 500 {
+			delay := telegramRetryDelay
+			if ok && telegramErr.RetryAfter > 0 {
+				delay = time.Duration(telegramErr.RetryAfter) * time.Second
+			}
+			msg.NakWithDelay(delay)
+			return
+		}
+	}
+	if err := msg.Ack(); err != nil {
+		s.logger.Error("Failed to ack relayed message", logging.MessageID, message.ID, logging.Err(err))
+	}
+}
+
+// telegramOutbound is the Bot API call that says a message in a chat, attributed to its sender
+func telegramOutbound(chatID int64, message *models.WSMessageNewData) (string, map[string]interface{}) {
+	name := message.SenderID
+	if message.Sender != nil && message.Sender.Name != "" {
+		name = message.Sender.Name
+	}
+	attribution := "<b>" + html.EscapeString(name) + "</b>"
+
+	switch message.Type {
+	case models.MessageTypeGIF, models.MessageTypeSticker:
+		var media models.Media
+		if json.Unmarshal(message.Payload, &media) == nil && media.URL != "" {
+			return "sendAnimation", map[string]interface{}{
+				"chat_id": chatID, "animation": media.URL, "caption": attribution, "parse_mode": "HTML",
+			}
+		}
+	case models.MessageTypeLocation:
+		var location models.Location
+		if json.Unmarshal(message.Payload, &location) == nil {
+			address := location.Label
+			if address == "" {
+				address = "Shared location"
+			}
+			return "sendVenue", map[string]interface{}{
+				"chat_id": chatID, "latitude": location.Latitude, "longitude": location.Longitude, "title": name, "address": address,
+			}
+		}
+	case models.MessageTypePoll:
+		var poll models.Poll
+		if json.Unmarshal(message.Payload, &poll) == nil {
+			text := attribution + ": " + html.EscapeString(message.Body)
+			for _, option := range poll.Options {
+				text += "\n• " + html.EscapeString(option.Text)
+			}
+			return "sendMessage", map[string]interface{}{"chat_id": chatID, "text": text, "parse_mode": "HTML"}
+		}
+	}
+	return "sendMessage", map[string]interface{}{
+		"chat_id": chatID, "text": attribution + ": " + html.EscapeString(message.Body), "parse_mode": "HTML",
+	}
+}
+
+// telegramError is a failed Bot API call
+type telegramError struct {
+	Code        int    `json:"error_code"`
+	Description string `json:"description"`
+	RetryAfter  int    // seconds, for 429s
+}
+
+func (e *telegramError) Error() string {
+	return fmt.Sprintf("telegram returned %d: %s", e.Code, e.Description)
+}
+
+// call invokes a Bot API method, decoding its result into result when it is not nil
+func (s *TelegramService) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.APIBaseURL+"/bot"+s.config.BotToken+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build Telegram request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		// The URL holds the bot token; keep it out of logs
+		return fmt.Errorf("failed to reach Telegram: %w", errorsWithoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return &telegramError{Code: resp.StatusCode, Description: "unreadable response"}
+	}
+	if !answer.OK {
+		return &telegramError{Code: answer.ErrorCode, Description: answer.Description, RetryAfter: answer.Parameters.RetryAfter}
+	}
+	if result != nil {
+		if err := json.Unmarshal(answer.Result, result); err != nil {
+			return fmt.Errorf("failed to decode Telegram %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// errorsWithoutURL drops the request URL from a transport error
+func errorsWithoutURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
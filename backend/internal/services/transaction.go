@@ -0,0 +1,27 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// withTransaction runs fn in a multi-document transaction (requires a replica set). The driver
+// retries fn on transient errors such as write conflicts, and the whole transaction is retried
+// with backoff if MongoDB is briefly unreachable, so fn must be safe to re-run.
+func withTransaction(ctx context.Context, db *database.MongoDB, fn func(mongo.SessionContext) error) error {
+	return db.Do(ctx, func(ctx context.Context) error {
+		session, err := db.Client.StartSession()
+		if err != nil {
+			return fmt.Errorf("failed to start session: %w", err)
+		}
+		defer session.EndSession(ctx)
+
+		_, err = session.WithTransaction(ctx, func(txCtx mongo.SessionContext) (interface{}, error) {
+			return nil, fn(txCtx)
+		})
+		return err
+	})
+}
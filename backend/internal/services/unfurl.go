@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Link previews: SendMessage records the first http(s) link in a message body as its
+// unfurlUrl. UnfurlService claims such messages once their message.created event is out,
+// fetches the page's OpenGraph or Twitter card metadata, attaches it to the message as preview
+// and announces it with a message.updated event, written through the outbox like any other
+// message event. Pages are cached in link_previews, failures included, so a link pasted in
+// many conversations is fetched once per CacheTTL. Fetches never reach private or loopback
+// addresses; see unfurl_fetch.go.
+
+const (
+	linkPreviewsCollection = "link_previews"
+	unfurlBatchSize        = 20
+	maxLinkLength          = 2048
+)
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// firstLink returns the first http(s) URL in body, without trailing punctuation, or ""
+func firstLink(body string) string {
+	link := linkPattern.FindString(body)
+	for link != "" {
+		trimmed := strings.TrimRight(link, `.,;:!?'"`)
+		// A closing bracket belongs to the link only if the link opened it, as Wikipedia's do
+		for _, pair := range []string{"()", "[]"} {
+			if strings.HasSuffix(trimmed, pair[1:]) && strings.Count(trimmed, pair[:1]) < strings.Count(trimmed, pair[1:]) {
+				trimmed = trimmed[:len(trimmed)-1]
+			}
+		}
+		if trimmed == link {
+			break
+		}
+		link = trimmed
+	}
+	if len(link) > maxLinkLength {
+		return ""
+	}
+	return link
+}
+
+// UnfurlConfig configures link previews
+type UnfurlConfig struct {
+	Interval time.Duration // how often waiting links are looked for; 0 disables previews
+	CacheTTL time.Duration // how long a fetched page is reused
+	Timeout  time.Duration // per page, redirects included
+}
+
+// UnfurlService attaches link previews to messages in the background
+type UnfurlService struct {
+	db             *database.MongoDB
+	messageService *MessageService
+	clock          clock.Clock
+	logger         *slog.Logger
+	config         UnfurlConfig
+	httpClient     *http.Client
+}
+
+func NewUnfurlService(db *database.MongoDB, messageService *MessageService, clk clock.Clock, logger *slog.Logger, config UnfurlConfig) *UnfurlService {
+	return &UnfurlService{
+		db:             db,
+		messageService: messageService,
+		clock:          clk,
+		logger:         logger,
+		config:         config,
+		httpClient:     newUnfurlClient(config.Timeout),
+	}
+}
+
+// cachedPreview is the link_previews document
+type cachedPreview struct {
+	URL       string              `bson:"_id"`
+	Preview   *models.LinkPreview `bson:"preview,omitempty"` // nil when the page had none
+	FetchedAt time.Time           `bson:"fetchedAt"`
+}
+
+// Run unfurls waiting links every Interval until ctx is cancelled
+func (s *UnfurlService) Run(ctx context.Context) {
+	if s.config.Interval <= 0 {
+		s.logger.Info("Link previews disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepEachTenant(ctx, s.db, s.logger, s.unfurlPending)
+		}
+	}
+}
+
+// unfurlPending works through up to unfurlBatchSize waiting links
+func (s *UnfurlService) unfurlPending(ctx context.Context) {
+	for i := 0; i < unfurlBatchSize && ctx.Err() == nil; i++ {
+		message, err := s.claim(ctx)
+		if err != nil {
+			s.logger.Error("Failed to claim a link to unfurl", logging.Err(err))
+			return
+		}
+		if message == nil {
+			return
+		}
+
+		preview, err := s.lookup(ctx, message.UnfurlURL)
+		if err == nil {
+			err = s.messageService.attachPreview(ctx, message, preview)
+		}
+		if err != nil {
+			// The lease runs out and the link is tried again
+			s.logger.Error("Failed to unfurl link", logging.MessageID, message.ID, logging.ConversationID, message.ConversationID, logging.Err(err))
+		}
+	}
+}
+
+// claim leases the oldest message waiting for a preview to this node. Messages wait until their
+// message.created event is published (streamSeq is set), so the update never overtakes it.
+func (s *UnfurlService) claim(ctx context.Context) (*models.Message, error) {
+	now := s.clock.Now()
+	// Long enough to fetch the page and store the result
+	leaseUntil := now.Add(2 * s.config.Timeout)
+
+	var message models.Message
+	err := s.db.Collection(ctx, "messages").FindOneAndUpdate(ctx,
+		bson.M{
+			"unfurlUrl":   bson.M{"$exists": true},
+			"streamSeq":   bson.M{"$exists": true},
+			"retractedAt": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"unfurlLeaseUntil": bson.M{"$exists": false}},
+				bson.M{"unfurlLeaseUntil": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"unfurlLeaseUntil": leaseUntil}},
+		options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After),
+	).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim message: %w", err)
+	}
+	return &message, nil
+}
+
+// lookup returns the preview for link from the cache, fetching the page when the cache has
+// none or it is older than CacheTTL. A page that cannot be fetched or has no title is cached
+// as having no preview, which is not an error.
+func (s *UnfurlService) lookup(ctx context.Context, link string) (*models.LinkPreview, error) {
+	cache := s.db.Collection(ctx, linkPreviewsCollection)
+
+	var cached cachedPreview
+	err := cache.FindOne(ctx, bson.M{"_id": link}).Decode(&cached)
+	switch {
+	case err == nil && s.clock.Now().Sub(cached.FetchedAt) < s.config.CacheTTL:
+		return cached.Preview, nil
+	case err != nil && err != mongo.ErrNoDocuments:
+		return nil, fmt.Errorf("failed to read link preview cache: %w", err)
+	}
+
+	preview, err := s.fetch(ctx, link)
+	if err != nil {
+		s.logger.Debug("No link preview", "url", link, logging.Err(err))
+		preview = nil
+	}
+
+	cached = cachedPreview{URL: link, Preview: preview, FetchedAt: s.clock.Now()}
+	_, err = cache.ReplaceOne(ctx, bson.M{"_id": link}, &cached, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache link preview: %w", err)
+	}
+	return preview, nil
+}
+
+// attachPreview stores a message's link preview and announces it with a message.updated event.
+// With no preview it only marks the message as done.
+func (s *MessageService) attachPreview(ctx context.Context, message *models.Message, preview *models.LinkPreview) error {
+	collection := s.db.Collection(ctx, "messages")
+	done := bson.M{"unfurlUrl": "", "unfurlLeaseUntil": ""}
+
+	if preview == nil {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{"$unset": done})
+		if err != nil {
+			return fmt.Errorf("failed to finish unfurling: %w", err)
+		}
+		return nil
+	}
+
+	now := s.clock.Now()
+	var entry *models.OutboxEntry
+	err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		entry = nil
+		result, err := collection.UpdateOne(txCtx,
+			bson.M{"_id": message.ID, "retractedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"preview": preview}, "$unset": done})
+		if err != nil {
+			return fmt.Errorf("failed to attach link preview: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return nil // retracted or deleted meanwhile
+		}
+
+		entry, err = s.updateEntry(&models.WSMessageUpdatedData{
+			ConversationID: message.ConversationID,
+			ID:             message.ID,
+			Preview:        preview,
+		}, now)
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Collection(txCtx, outboxCollection).InsertOne(txCtx, entry); err != nil {
+			return fmt.Errorf("failed to write outbox entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if entry != nil {
+		s.publishEntry(ctx, entry)
+	}
+	return nil
+}
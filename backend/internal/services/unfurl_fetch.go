@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	unfurlMaxBytes        = 512 << 10 // read from a page looking for its metadata
+	unfurlMaxRedirects    = 3
+	unfurlUserAgent       = "chat-service-unfurl/1.0"
+	maxPreviewTitle       = 300
+	maxPreviewDescription = 1000
+)
+
+var errUnfurlBlocked = errors.New("address not allowed for link previews")
+
+// blockedPrefixes are non-public ranges that netip does not report as private, loopback or
+// link-local
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which can reach private IPv4
+	netip.MustParsePrefix("2002::/16"),    // 6to4, likewise
+}
+
+// publicAddress reports whether a link preview fetch may connect to addr
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnfurlURL allows plain http(s) URLs on the default ports
+func checkUnfurlURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errUnfurlBlocked
+	}
+	if u.User != nil || u.Hostname() == "" {
+		return errUnfurlBlocked
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		return errUnfurlBlocked
+	}
+	return nil
+}
+
+// newUnfurlClient returns an HTTP client for fetching pages users link to. The address of every
+// connection is checked after DNS resolution, so neither a name resolving to an internal
+// address nor a redirect to one gets through, and no proxy is used.
+func newUnfurlClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addrPort.Addr()) {
+				return errUnfurlBlocked
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          16,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > unfurlMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkUnfurlURL(req.URL)
+		},
+	}
+}
+
+// fetch reads the preview metadata of the page at link. It returns nil if the page has no title.
+func (s *UnfurlService) fetch(ctx context.Context, link string) (*models.LinkPreview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnfurlURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", unfurlUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page returned %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("not a web page: %q", mediaType)
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, unfurlMaxBytes), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parsePreview(body, resp.Request.URL)
+	if preview != nil {
+		preview.URL = link
+	}
+	return preview, nil
+}
+
+// parsePreview reads the OpenGraph and Twitter card tags in a page's head, falling back to its
+// <title> and description. base resolves relative image URLs.
+func parsePreview(r io.Reader, base *url.URL) *models.LinkPreview {
+	meta := make(map[string]string)
+	var title string
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// End of the page, or of as much as was read
+			return buildPreview(meta, title, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var attr, value []byte
+					attr, value, hasAttr = tokenizer.TagAttr()
+					switch string(attr) {
+					case "property", "name":
+						key = strings.ToLower(string(value))
+					case "content":
+						content = string(value)
+					}
+				}
+				if _, seen := meta[key]; key != "" && !seen {
+					meta[key] = content
+				}
+			case "title":
+				if title == "" && tokenizer.Next() == html.TextToken {
+					title = string(tokenizer.Text())
+				}
+			case "body":
+				return buildPreview(meta, title, base)
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return buildPreview(meta, title, base)
+			}
+		}
+	}
+}
+
+func buildPreview(meta map[string]string, title string, base *url.URL) *models.LinkPreview {
+	// pick returns the first of keys the page set, with whitespace collapsed
+	pick := func(keys ...string) string {
+		for _, key := range keys {
+			if value := strings.Join(strings.Fields(meta[key]), " "); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	preview := &models.LinkPreview{
+		SiteName:    shorten(pick("og:site_name"), maxPreviewTitle),
+		Title:       pick("og:title", "twitter:title"),
+		Description: shorten(pick("og:description", "twitter:description", "description"), maxPreviewDescription),
+	}
+	if preview.Title == "" {
+		preview.Title = strings.Join(strings.Fields(title), " ")
+	}
+	if preview.Title == "" {
+		return nil
+	}
+	preview.Title = shorten(preview.Title, maxPreviewTitle)
+
+	if image := pick("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if ref, err := base.Parse(image); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") && len(ref.String()) <= maxLinkLength {
+			preview.ImageURL = ref.String()
+		}
+	}
+	return preview
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Usage is metered per workspace and calendar month (UTC), one document each in the
+// deployment-wide usage collection. Sends are counted as they happen; attachment storage and
+// active users would cost a scan per send, so they are measured periodically instead.
+
+const (
+	usageCollection = "usage"
+	usagePeriod     = "2006-01"
+)
+
+// UsageService meters workspaces' use of the deployment and enforces the configured quotas
+type UsageService struct {
+	db     *database.MongoDB
+	clock  clock.Clock
+	logger *slog.Logger
+	quotas models.Quotas
+}
+
+func NewUsageService(db *database.MongoDB, clk clock.Clock, logger *slog.Logger, quotas models.Quotas) *UsageService {
+	return &UsageService{
+		db:     db,
+		clock:  clk,
+		logger: logger,
+		quotas: quotas,
+	}
+}
+
+// CheckSend refuses a message from a workspace that has used up one of its quotas. The check
+// reads the last recorded usage, so concurrent sends may pass the message quota by a few.
+func (s *UsageService) CheckSend(ctx context.Context, workspaceID string) error {
+	if s.quotas == (models.Quotas{}) {
+		return nil
+	}
+	period := s.clock.Now().UTC().Format(usagePeriod)
+	usage, err := s.usage(ctx, workspaceID, period)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.quotas.MessagesPerMonth > 0 && usage.Messages >= s.quotas.MessagesPerMonth:
+		return quotaError(fmt.Sprintf("the workspace has sent its %d messages for %s", s.quotas.MessagesPerMonth, period))
+	case s.quotas.AttachmentBytes > 0 && usage.AttachmentBytes > s.quotas.AttachmentBytes:
+		return quotaError("the workspace is over its attachment storage quota")
+	case s.quotas.ActiveUsers > 0 && usage.ActiveUsers > s.quotas.ActiveUsers:
+		return quotaError(fmt.Sprintf("the workspace is over its quota of %d active users for %s", s.quotas.ActiveUsers, period))
+	}
+	return nil
+}
+
+// RecordMessage counts a sent message. The message is already stored, so a failure is only logged.
+func (s *UsageService) RecordMessage(ctx context.Context, workspaceID string) {
+	period := s.clock.Now().UTC().Format(usagePeriod)
+	_, err := s.db.Collection(ctx, usageCollection).UpdateOne(ctx,
+		bson.M{"_id": workspaceID + ":" + period},
+		bson.M{
+			"$inc":         bson.M{"messages": 1},
+			"$setOnInsert": bson.M{"workspaceId": workspaceID, "period": period},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to meter message", "workspace_id", workspaceID, logging.Err(err))
+	}
+}
+
+// Report returns the recorded usage for a period (YYYY-MM, default the current month) of every
+// workspace with any, or of one workspace. Workspaces without usage in the period are left out.
+func (s *UsageService) Report(ctx context.Context, workspaceID, period string) (*models.UsageReport, error) {
+	if period == "" {
+		period = s.clock.Now().UTC().Format(usagePeriod)
+	} else if _, err := time.Parse(usagePeriod, period); err != nil {
+		return nil, validationError("period must be a month as YYYY-MM")
+	}
+
+	filter := bson.M{"period": period}
+	if workspaceID != "" {
+		filter["workspaceId"] = workspaceID
+	}
+	cursor, err := s.db.Collection(ctx, usageCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.M{"workspaceId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find usage: %w", err)
+	}
+	workspaces := []models.WorkspaceUsage{}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return nil, fmt.Errorf("failed to decode usage: %w", err)
+	}
+	return &models.UsageReport{Period: period, Quotas: s.quotas, Workspaces: workspaces}, nil
+}
+
+// RunMeter measures every workspace's attachment storage and active users on the given
+// interval until ctx is cancelled
+func (s *UsageService) RunMeter(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		s.logger.Info("Usage metering disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Measure(ctx); err != nil {
+				s.logger.Error("Usage metering failed", logging.Err(err))
+			}
+		}
+	}
+}
+
+// Measure records each workspace's attachment storage and the users seen this month
+func (s *UsageService) Measure(ctx context.Context) error {
+	cursor, err := s.db.Collection(ctx, "workspaces").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	var workspaces []models.Workspace
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return fmt.Errorf("failed to decode workspaces: %w", err)
+	}
+
+	now := s.clock.Now().UTC()
+	for _, workspace := range workspaces {
+		if err := s.measure(tenant.NewContext(ctx, workspace.ID), workspace.ID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UsageService) measure(ctx context.Context, workspaceID string, now time.Time) error {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	activeUsers, err := s.db.Collection(ctx, "users").CountDocuments(ctx, bson.M{
+		"workspaceId": workspaceID,
+		"lastSeenAt":  bson.M{"$gte": monthStart},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count active users: %w", err)
+	}
+
+	attachmentBytes, err := s.attachmentBytes(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	period := now.Format(usagePeriod)
+	_, err = s.db.Collection(ctx, usageCollection).UpdateOne(ctx,
+		bson.M{"_id": workspaceID + ":" + period},
+		bson.M{
+			"$set":         bson.M{"attachmentBytes": attachmentBytes, "activeUsers": activeUsers, "measuredAt": now},
+			"$setOnInsert": bson.M{"workspaceId": workspaceID, "period": period, "messages": 0},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// attachmentBytes totals the stored size of the attachments in a workspace's conversations
+func (s *UsageService) attachmentBytes(ctx context.Context, workspaceID string) (int64, error) {
+	cursor, err := s.db.Collection(ctx, "conversations").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"workspaceId": workspaceID}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.db.Collection(ctx, "attachments").Name(),
+			"localField":   "_id",
+			"foreignField": "conversationId",
+			"as":           "attachments",
+			"pipeline":     bson.A{bson.M{"$project": bson.M{"bytes": bson.M{"$bsonSize": "$$ROOT"}}}},
+		}}},
+		{{Key: "$unwind", Value: "$attachments"}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "bytes": bson.M{"$sum": "$attachments.bytes"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure attachments: %w", err)
+	}
+	var totals []struct {
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, fmt.Errorf("failed to decode attachment sizes: %w", err)
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Bytes, nil
+}
+
+func (s *UsageService) usage(ctx context.Context, workspaceID, period string) (*models.WorkspaceUsage, error) {
+	var usage models.WorkspaceUsage
+	err := s.db.Collection(ctx, usageCollection).FindOne(ctx, bson.M{"_id": workspaceID + ":" + period}).Decode(&usage)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find usage: %w", err)
+	}
+	return &usage, nil
+}
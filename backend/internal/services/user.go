@@ -2,9 +2,12 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,33 +16,81 @@ import (
 
 type UserService struct {
 	db *database.MongoDB
+	// cache serves profile reads (GetUserProfile, GetUsersByIDs); nil disables caching
+	cache UserCache
+	clock clock.Clock
+	ids   IDGenerator
 }
 
-func NewUserService(db *database.MongoDB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *database.MongoDB, cache UserCache, clk clock.Clock, ids IDGenerator) *UserService {
+	return &UserService{db: db, cache: cache, clock: clk, ids: ids}
 }
 
 func (s *UserService) UpsertUser(ctx context.Context, user *models.User) error {
-	collection := s.db.DB.Collection("users")
+	collection := s.db.Collection(ctx, "users")
 
-	// Simplified approach - just insert or replace
-	opts := options.Replace().SetUpsert(true)
-	_, err := collection.ReplaceOne(ctx, bson.M{"_id": user.ID}, user, opts)
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = s.clock.Now()
+	}
+	if user.WorkspaceID == "" {
+		user.WorkspaceID = DefaultWorkspaceID
+	}
+
+	// Only profile fields come from the client; roles are managed server-side
+	set := bson.D{
+		{Key: "email", Value: user.Email},
+		{Key: "name", Value: user.Name},
+		{Key: "avatarUrl", Value: user.AvatarURL},
+	}
+	update := bson.D{
+		// A user's workspace is fixed when they are first seen; IDs are never shared across workspaces
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "createdAt", Value: user.CreatedAt},
+			{Key: "workspaceId", Value: user.WorkspaceID},
+		}},
+	}
+	// A profile sent without a DND schedule keeps the stored one; no windows clears it
+	switch {
+	case user.DND == nil:
+	case len(user.DND.Windows) == 0:
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "dnd", Value: ""}}})
+		user.DND = nil
+	default:
+		if err := validateDND(user.DND); err != nil {
+			return err
+		}
+		set = append(set, bson.E{Key: "dnd", Value: user.DND})
+	}
+	update = append(update, bson.E{Key: "$set", Value: set})
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"workspaceId": 1})
+	var stored struct {
+		WorkspaceID string `bson:"workspaceId"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, update, opts).Decode(&stored)
 	if err != nil {
 		return fmt.Errorf("failed to upsert user: %w", err)
 	}
+	user.WorkspaceID = stored.WorkspaceID
 
+	if s.cache != nil {
+		s.cache.Invalidate(ctx, user.ID)
+	}
 	return nil
 }
 
+// GetUserByID always reads the database, so role checks and /me see changes immediately.
+// Profile lookups for display should use GetUserProfile.
 func (s *UserService) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
-	collection := s.db.DB.Collection("users")
+	collection := s.db.Collection(ctx, "users")
 
 	var user models.User
 	err := collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, notFoundError("user not found")
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -47,17 +98,151 @@ func (s *UserService) GetUserByID(ctx context.Context, userID string) (*models.U
 	return &user, nil
 }
 
+// GetUserProfile returns a user for display, such as a message sender, from the cache when
+// possible, as other users see them (see User.Public). A missed invalidation can leave a
+// profile stale for up to the cache TTL, so use GetUserByID for anything authorization
+// depends on.
+func (s *UserService) GetUserProfile(ctx context.Context, userID string) (*models.User, error) {
+	if s.cache != nil {
+		if user, ok := s.cache.Get(ctx, userID); ok {
+			return user.Public(), nil
+		}
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.cache != nil {
+		s.cache.Put(ctx, user)
+	}
+	return user.Public(), nil
+}
+
+// GetUsersByIDs loads several user profiles for display at once, keyed by ID; unknown IDs are
+// omitted. Cached profiles are used and only the misses are read from the database.
+func (s *UserService) GetUsersByIDs(ctx context.Context, userIDs []string) (map[string]*models.User, error) {
+	byID := make(map[string]*models.User, len(userIDs))
+	missing := userIDs
+	if s.cache != nil {
+		missing = make([]string, 0, len(userIDs))
+		for _, id := range userIDs {
+			if user, ok := s.cache.Get(ctx, id); ok {
+				byID[id] = user.Public()
+			} else {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			return byID, nil
+		}
+	}
+
+	collection := s.db.Collection(ctx, "users")
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": missing}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %w", err)
+	}
+
+	for i := range users {
+		byID[users[i].ID] = users[i].Public()
+		if s.cache != nil {
+			s.cache.Put(ctx, &users[i])
+		}
+	}
+	return byID, nil
+}
+
+// SetStatus replaces the user's status and status message
+func (s *UserService) SetStatus(ctx context.Context, userID, status, message string) (*models.User, error) {
+	update := bson.M{"$set": bson.M{"status": status, "statusMessage": message}}
+	result, err := s.db.Collection(ctx, "users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set status: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, notFoundError("user not found")
+	}
+
+	if s.cache != nil {
+		s.cache.Invalidate(ctx, userID)
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// SetRoles replaces the workspace roles of a user in the given workspace
+func (s *UserService) SetRoles(ctx context.Context, workspaceID, userID string, roles []string) (*models.User, error) {
+	update := bson.M{"$set": bson.M{"roles": roles}}
+	if len(roles) == 0 {
+		update = bson.M{"$unset": bson.M{"roles": ""}}
+	}
+	result, err := s.db.Collection(ctx, "users").UpdateOne(ctx, bson.M{"_id": userID, "workspaceId": workspaceID}, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set roles: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, notFoundError("user not found")
+	}
+
+	if s.cache != nil {
+		s.cache.Invalidate(ctx, userID)
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// IsDeactivated reports whether a user has been deprovisioned. Users not yet seen are not.
+func (s *UserService) IsDeactivated(ctx context.Context, userID string) (bool, error) {
+	user, err := s.GetUserProfile(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.DeactivatedAt != nil, nil
+}
+
+// invalidate drops a user's cached profile after a change
+func (s *UserService) invalidate(ctx context.Context, userID string) {
+	if s.cache != nil {
+		s.cache.Invalidate(ctx, userID)
+	}
+}
+
 func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	collection := s.db.DB.Collection("users")
+	collection := s.db.Collection(ctx, "users")
 
 	var user models.User
 	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, notFoundError("user not found")
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	return &user, nil
-}
\ No newline at end of file
+}
+
+// RecordLastSeen stores when each user was last seen. Times only move forward, so nodes
+// flushing out of order cannot set one back.
+func (s *UserService) RecordLastSeen(ctx context.Context, seen map[string]time.Time) error {
+	writes := make([]mongo.WriteModel, 0, len(seen))
+	for userID, at := range seen {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": userID}).
+			SetUpdate(bson.M{"$max": bson.M{"lastSeenAt": at}}))
+	}
+	_, err := s.db.Collection(ctx, "users").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("failed to record last seen: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// User cache modes, selected with USER_CACHE
+const (
+	UserCacheLRU = "lru" // per-node LRU, invalidated across nodes over NATS
+	UserCacheKV  = "kv"  // one JetStream key-value bucket shared by all nodes
+	UserCacheOff = "off"
+)
+
+// UserCache holds user profiles for UserService's hot read paths. Entries expire after a TTL;
+// UpsertUser invalidates a user's entry on every node.
+type UserCache interface {
+	Get(ctx context.Context, userID string) (*models.User, bool)
+	Put(ctx context.Context, user *models.User)
+	Invalidate(ctx context.Context, userID string)
+}
+
+// LRUUserCache keeps up to size profiles in memory, evicting the least recently used. Each
+// node has its own copy; invalidations are broadcast on nats.UserInvalidationSubject so no
+// node keeps serving a profile another node changed.
+type LRUUserCache struct {
+	natsConn *nats.NATSConnection
+	clock    clock.Clock
+	logger   *slog.Logger
+	size     int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	sub     *natsgo.Subscription
+}
+
+type lruUserEntry struct {
+	user     models.User
+	cachedAt time.Time
+}
+
+func NewLRUUserCache(natsConn *nats.NATSConnection, clk clock.Clock, logger *slog.Logger, size int, ttl time.Duration) *LRUUserCache {
+	return &LRUUserCache{
+		natsConn: natsConn,
+		clock:    clk,
+		logger:   logger,
+		size:     size,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Start subscribes to invalidations from other nodes
+func (c *LRUUserCache) Start() error {
+	sub, err := c.natsConn.Conn.Subscribe(nats.UserInvalidationSubject, func(msg *natsgo.Msg) {
+		c.remove(string(msg.Data))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to user invalidations: %w", err)
+	}
+	c.sub = sub
+
+	// Invalidations sent while NATS was down were missed, so start over
+	c.natsConn.OnReconnect(c.clear)
+	return nil
+}
+
+func (c *LRUUserCache) Stop() {
+	if c.sub != nil {
+		c.sub.Unsubscribe()
+	}
+}
+
+func (c *LRUUserCache) Get(ctx context.Context, userID string) (*models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruUserEntry)
+	if c.clock.Now().Sub(entry.cachedAt) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, userID)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	user := entry.user
+	return &user, true
+}
+
+func (c *LRUUserCache) Put(ctx context.Context, user *models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruUserEntry{user: *user, cachedAt: c.clock.Now()}
+	if elem, ok := c.entries[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[user.ID] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruUserEntry).user.ID)
+	}
+}
+
+func (c *LRUUserCache) Invalidate(ctx context.Context, userID string) {
+	c.remove(userID)
+	if err := c.natsConn.PublishUserInvalidation(userID); err != nil {
+		c.logger.ErrorContext(ctx, "Failed to publish user invalidation", logging.UserID, userID, logging.Err(err))
+	}
+}
+
+func (c *LRUUserCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *LRUUserCache) remove(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[userID]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, userID)
+	}
+}
+
+// KVUserCache stores profiles in a JetStream key-value bucket shared by every node, so a
+// profile is loaded from Mongo once per TTL for the whole deployment and an invalidation is
+// seen everywhere at once. It costs a NATS round trip per lookup.
+type KVUserCache struct {
+	kv     jetstream.KeyValue
+	logger *slog.Logger
+}
+
+func NewKVUserCache(ctx context.Context, natsConn *nats.NATSConnection, logger *slog.Logger, ttl time.Duration) (*KVUserCache, error) {
+	kv, err := natsConn.UserProfileStore(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &KVUserCache{kv: kv, logger: logger}, nil
+}
+
+func (c *KVUserCache) Get(ctx context.Context, userID string) (*models.User, bool) {
+	entry, err := c.kv.Get(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			c.logger.WarnContext(ctx, "Failed to read cached user", logging.UserID, userID, logging.Err(err))
+		}
+		return nil, false
+	}
+
+	var user models.User
+	if err := json.Unmarshal(entry.Value(), &user); err != nil {
+		return nil, false
+	}
+	return &user, true
+}
+
+func (c *KVUserCache) Put(ctx context.Context, user *models.User) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return
+	}
+	if _, err := c.kv.Put(ctx, user.ID, data); err != nil {
+		c.logger.WarnContext(ctx, "Failed to cache user", logging.UserID, user.ID, logging.Err(err))
+	}
+}
+
+func (c *KVUserCache) Invalidate(ctx context.Context, userID string) {
+	if err := c.kv.Purge(ctx, userID); err != nil {
+		c.logger.ErrorContext(ctx, "Failed to invalidate cached user", logging.UserID, userID, logging.Err(err))
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Usernames are handles for mentions and search. They are stored as the user typed them and
+// compared ignoring case, through the unique users.username index and its collation.
+
+const usernameHistoryCollection = "username_history"
+
+// usernamePattern allows 3 to 30 letters, digits, underscores and dots, not at either end
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.]{1,28}[A-Za-z0-9_]$`)
+
+// reservedUsernames would read as mention keywords or routes
+var reservedUsernames = map[string]bool{
+	"all": true, "channel": true, "everyone": true, "here": true,
+	"me": true, "admin": true, "system": true,
+}
+
+// checkUsername returns why a username cannot be used, or "" if its form is fine
+func checkUsername(username string) string {
+	if !usernamePattern.MatchString(username) || strings.Contains(username, "..") {
+		return "invalid"
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return "reserved"
+	}
+	return ""
+}
+
+// CheckUsername reports whether a username is free for userID to take. Their own current
+// username counts as available, so a change of case is allowed.
+func (s *UserService) CheckUsername(ctx context.Context, userID, username string) (*models.UsernameAvailability, error) {
+	availability := &models.UsernameAvailability{Username: username}
+	if reason := checkUsername(username); reason != "" {
+		availability.Reason = reason
+		return availability, nil
+	}
+
+	owner, err := s.GetUserByUsername(ctx, username)
+	switch {
+	case err == nil && owner.ID != userID:
+		availability.Reason = "taken"
+	case err == nil, errors.Is(err, ErrNotFound):
+		availability.Available = true
+	default:
+		return nil, err
+	}
+	return availability, nil
+}
+
+// GetUserByUsername finds a user by handle, ignoring case
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := s.db.Collection(ctx, "users").FindOne(ctx, bson.M{"username": username},
+		options.FindOne().SetCollation(database.UsernameCollation)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, notFoundError("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// SetUsername changes the user's handle and records the change
+func (s *UserService) SetUsername(ctx context.Context, userID, username string) (*models.User, error) {
+	switch checkUsername(username) {
+	case "invalid":
+		return nil, validationError("username must be 3 to 30 letters, digits, underscores or dots, not starting or ending with a dot")
+	case "reserved":
+		return nil, validationError("username is reserved")
+	}
+
+	users := s.db.Collection(ctx, "users")
+	history := s.db.Collection(ctx, usernameHistoryCollection)
+	err := withTransaction(ctx, s.db, func(txCtx mongo.SessionContext) error {
+		var current models.User
+		if err := users.FindOne(txCtx, bson.M{"_id": userID}).Decode(&current); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return notFoundError("user not found")
+			}
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if current.Username == username {
+			return nil
+		}
+
+		_, err := users.UpdateOne(txCtx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"username": username}})
+		if mongo.IsDuplicateKeyError(err) {
+			return conflictError("username is taken")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set username: %w", err)
+		}
+
+		_, err = history.InsertOne(txCtx, &models.UsernameChange{
+			ID:        s.ids.NewID(),
+			UserID:    userID,
+			From:      current.Username,
+			To:        username,
+			ChangedAt: s.clock.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record username change: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil {
+		s.cache.Invalidate(ctx, userID)
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// UsernameHistory lists the user's handle changes, newest first
+func (s *UserService) UsernameHistory(ctx context.Context, userID string) ([]models.UsernameChange, error) {
+	cursor, err := s.db.Collection(ctx, usernameHistoryCollection).Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "changedAt", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find username history: %w", err)
+	}
+	changes := []models.UsernameChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, fmt.Errorf("failed to decode username history: %w", err)
+	}
+	return changes, nil
+}
+
+// resolveMembers turns "@username" handles among conversation members into user IDs; other
+// entries are taken as IDs already. Every member must be a user in the workspace: users elsewhere
+// are reported exactly as if they did not exist.
+func (s *UserService) resolveMembers(ctx context.Context, workspaceID string, members []string) ([]string, error) {
+	resolved := make([]string, len(members))
+	for i, member := range members {
+		handle, ok := strings.CutPrefix(member, "@")
+		if !ok {
+			resolved[i] = member
+			continue
+		}
+		user, err := s.GetUserByUsername(ctx, handle)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, validationError("no user has the username " + member)
+			}
+			return nil, err
+		}
+		if user.WorkspaceID != workspaceID {
+			return nil, validationError("no user has the username " + member)
+		}
+		resolved[i] = user.ID
+	}
+
+	unique := make(map[string]bool, len(resolved))
+	for _, userID := range resolved {
+		unique[userID] = true
+	}
+	ids := make([]string, 0, len(unique))
+	for userID := range unique {
+		ids = append(ids, userID)
+	}
+	count, err := s.db.Collection(ctx, "users").CountDocuments(ctx, bson.M{
+		"_id":         bson.M{"$in": ids},
+		"workspaceId": workspaceID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up members: %w", err)
+	}
+	if int(count) != len(ids) {
+		return nil, validationError("members must be users in the workspace")
+	}
+	return resolved, nil
+}
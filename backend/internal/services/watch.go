@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxWatchDuration caps how long a single watch grant may last
+const maxWatchDuration = 30 * 24 * time.Hour
+
+// WatchService manages watch-only access for compliance investigations. Workspace admins
+// grant a compliance user time-boxed read access to a conversation; every grant, revocation
+// and read through a grant is written to the audit log.
+type WatchService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	userService         *UserService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+	ids                 IDGenerator
+}
+
+func NewWatchService(db *database.MongoDB, conversationService *ConversationService, userService *UserService, auditService *AuditService, clk clock.Clock, logger *slog.Logger, ids IDGenerator) *WatchService {
+	return &WatchService{
+		db:                  db,
+		conversationService: conversationService,
+		userService:         userService,
+		auditService:        auditService,
+		clock:               clk,
+		logger:              logger,
+		ids:                 ids,
+	}
+}
+
+func (s *WatchService) Grant(ctx context.Context, actorID, conversationID string, req *models.CreateWatchGrantRequest) (*models.WatchGrant, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	if req.Reason == "" || duration <= 0 || duration > maxWatchDuration {
+		return nil, validationError("a reason and a duration of up to 30 days are required")
+	}
+
+	if err := s.requireInWorkspace(ctx, actor.WorkspaceID, conversationID); err != nil {
+		return nil, err
+	}
+
+	watcher, err := s.userService.GetUserByID(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if watcher.WorkspaceID != actor.WorkspaceID {
+		return nil, notFoundError("user not found")
+	}
+	if !permissions.CheckPermission(nil, watcher, permissions.WatchConversations) {
+		return nil, validationError("watch grants can only be given to compliance users")
+	}
+
+	now := s.clock.Now()
+	grant := &models.WatchGrant{
+		ID:             s.ids.NewID(),
+		ConversationID: conversationID,
+		UserID:         req.UserID,
+		GrantedBy:      actorID,
+		Reason:         req.Reason,
+		ExpiresAt:      now.Add(duration),
+		CreatedAt:      now,
+	}
+
+	if _, err := s.db.Collection(ctx, "watch_grants").InsertOne(ctx, grant); err != nil {
+		return nil, fmt.Errorf("failed to create watch grant: %w", err)
+	}
+
+	s.audit(ctx, AuditWatchGranted, actorID, grant, nil)
+	return grant, nil
+}
+
+func (s *WatchService) ListGrants(ctx context.Context, actorID, conversationID string) ([]models.WatchGrant, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireInWorkspace(ctx, actor.WorkspaceID, conversationID); err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.db.Collection(ctx, "watch_grants").Find(ctx,
+		bson.M{"conversationId": conversationID},
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watch grants: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	grants := []models.WatchGrant{}
+	if err := cursor.All(ctx, &grants); err != nil {
+		return nil, fmt.Errorf("failed to decode watch grants: %w", err)
+	}
+
+	return grants, nil
+}
+
+func (s *WatchService) Revoke(ctx context.Context, actorID, grantID string) error {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return err
+	}
+
+	var grant models.WatchGrant
+	err = s.db.Collection(ctx, "watch_grants").FindOne(ctx, bson.M{"_id": grantID}).Decode(&grant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("watch grant not found")
+		}
+		return fmt.Errorf("failed to get watch grant: %w", err)
+	}
+	if err := s.requireInWorkspace(ctx, actor.WorkspaceID, grant.ConversationID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return notFoundError("watch grant not found")
+		}
+		return err
+	}
+
+	err = s.db.Collection(ctx, "watch_grants").FindOneAndUpdate(ctx,
+		bson.M{"_id": grantID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": s.clock.Now()}},
+	).Decode(&grant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return notFoundError("watch grant not found")
+		}
+		return fmt.Errorf("failed to revoke watch grant: %w", err)
+	}
+
+	s.audit(ctx, AuditWatchRevoked, actorID, &grant, nil)
+	return nil
+}
+
+// requireInWorkspace reports conversations outside the admin's workspace as not found
+func (s *WatchService) requireInWorkspace(ctx context.Context, workspaceID, conversationID string) error {
+	conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	if conversation.WorkspaceID != workspaceID {
+		return notFoundError("conversation not found")
+	}
+	return nil
+}
+
+// AuthorizeRead allows participants, and compliance users holding an active grant, to read a
+// conversation. For watchers it returns the grant (nil for participants) and audits the access.
+func (s *WatchService) AuthorizeRead(ctx context.Context, conversationID, userID, via string) (*models.WatchGrant, error) {
+	isParticipant, err := s.conversationService.IsUserParticipant(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isParticipant {
+		return nil, nil
+	}
+
+	var grant models.WatchGrant
+	err = s.db.Collection(ctx, "watch_grants").FindOne(ctx, bson.M{
+		"conversationId": conversationID,
+		"userId":         userID,
+		"expiresAt":      bson.M{"$gt": s.clock.Now()},
+		"revokedAt":      bson.M{"$exists": false},
+	}).Decode(&grant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, forbiddenError("user is not a participant in this conversation")
+		}
+		return nil, fmt.Errorf("failed to find watch grant: %w", err)
+	}
+
+	// The role may have been removed since the grant was made
+	watcher, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CheckPermission(nil, watcher, permissions.WatchConversations) {
+		return nil, forbiddenError("compliance role required")
+	}
+
+	s.audit(ctx, AuditWatchAccessed, userID, &grant, map[string]interface{}{"via": via})
+	return &grant, nil
+}
+
+func (s *WatchService) audit(ctx context.Context, action, actorID string, grant *models.WatchGrant, extra map[string]interface{}) {
+	details := map[string]interface{}{
+		"grantId":   grant.ID,
+		"watcherId": grant.UserID,
+		"reason":    grant.Reason,
+		"expiresAt": grant.ExpiresAt,
+	}
+	for k, v := range extra {
+		details[k] = v
+	}
+
+	if err := s.auditService.Record(ctx, action, actorID, grant.ConversationID, details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", action, logging.UserID, actorID, logging.ConversationID, grant.ConversationID, logging.Err(err))
+	}
+}
+
+// checkWatchExpiry ends watch-only subscriptions whose grant has expired. Revocations take
+// effect on the next subscribe, or when the grant's original expiry passes.
+func (h *WebSocketHub) checkWatchExpiry() {
+	h.clientsMu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.clientsMu.RUnlock()
+
+	now := h.clock.Now()
+	for _, client := range clients {
+		var expired []string
+		client.subscriptionsMu.RLock()
+		for conversationID, expiresAt := range client.watching {
+			if !now.Before(expiresAt) {
+				expired = append(expired, conversationID)
+			}
+		}
+		client.subscriptionsMu.RUnlock()
+
+		for _, conversationID := range expired {
+			h.unsubscribeClient(client, conversationID)
+			client.sendError("WATCH_EXPIRED", "Watch grant expired for conversation "+conversationID)
+		}
+	}
+}
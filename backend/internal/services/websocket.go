@@ -4,79 +4,302 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"sync"
 	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/internal/middleware"
 	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/problem"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clientip"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
 	"github.com/JohnBPerkins/chat-service/backend/pkg/nats"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/validate"
 	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"nhooyr.io/websocket"
 )
 
 type WebSocketHub struct {
-	messageService *MessageService
-	natsConn       *nats.NATSConnection
-	clients        map[string]*Client
-	clientsMu      sync.RWMutex
-	subscriptions  map[string]*ConversationSubscription
-	subsMu         sync.RWMutex
+	messageService      *MessageService
+	conversationService *ConversationService
+	userService         *UserService
+	watchService        *WatchService
+	botService          *BotService
+	challengeService    *ChallengeService
+	natsConn            *nats.NATSConnection
+	verifier            TokenVerifier
+	config              HubConfig
+	clock               clock.Clock
+	logger              *slog.Logger
+	clients             map[string]*Client
+	userClients         map[string]map[string]*Client // clients by user ID, then client ID
+	invisible           map[string]bool               // users connected here whose status is invisible
+	streams             map[string]*EventStream       // server-sent event streams by ID, see events.go
+	clientsMu           sync.RWMutex
+	subscriptions       map[string]*ConversationSubscription
+	subsMu              sync.RWMutex
+
+	// Node-wide fan-out, set up by Start
+	feed     jetstream.ConsumeContext
+	natsSubs []*natsgo.Subscription
+
+	// Cluster-wide presence, see presence_cluster.go
+	presenceRegistry presenceRegistry
+	presenceKV       jetstream.KeyValue
+	presenceWatcher  jetstream.KeyWatcher
+	presenceWrites   chan presenceWrite
+	presenceDone     chan struct{}
+	presenceStopped  chan struct{}
+
+	// Every node's connections, see sessions.go
+	sessionKV jetstream.KeyValue
+
+	backpressure backpressureStats
+	frameSizes   frameStats
+	lastSeen     lastSeenState
+}
+
+// HubConfig holds tunables for the WebSocket hub
+type HubConfig struct {
+	// Conversations with more participants than this receive batched presence roll-ups
+	PresenceRollupThreshold int
+	PresenceRollupInterval  time.Duration
+
+	// Clients get an auth.expiring frame this long before their token lapses
+	AuthExpiryWarning time.Duration
+	AuthCheckInterval time.Duration
+
+	// Messages collected for disconnected users; a zero limit disables offline delivery
+	OfflineDeliveryLimit int
+	OfflineRetention     time.Duration
+
+	// Frames queued per client; a client whose queue fills is treated as slow
+	SendBufferSize int
+	// Typing and presence frames queued per client apart from the rest; the oldest is dropped
+	// when full
+	EphemeralBufferSize int
+
+	// permessage-deflate mode (Compression* constants) and the smallest frame compressed
+	Compression          string
+	CompressionThreshold int
+
+	// Presence heartbeats and session entries outlive a silent node by at most PresenceTTL.
+	// NodeName tells this node's heartbeats apart from the others'.
+	PresenceTTL time.Duration
+	NodeName    string
+
+	// Concurrent connections allowed per user across the cluster; zero means no limit
+	MaxConnectionsPerUser int
+	// Frames a connection may send per minute, in bursts of up to as many; zero means no limit
+	FrameBudget int
+
+	// How often users' last seen times are written, see lastseen.go; zero disables them
+	LastSeenInterval time.Duration
+}
+
+// ClientConn is the transport under a Client: a *websocket.Conn, or a gRPC Chat stream
+// adapted to the same calls
+type ClientConn interface {
+	Read(ctx context.Context) (websocket.MessageType, []byte, error)
+	Write(ctx context.Context, typ websocket.MessageType, p []byte) error
+	Ping(ctx context.Context) error
+	Close(code websocket.StatusCode, reason string) error
 }
 
 type Client struct {
-	ID             string
-	UserID         string
-	Conn           *websocket.Conn
-	Send           chan *models.WSFrame
-	Hub            *WebSocketHub
-	subscriptions  map[string]bool
+	ID              string
+	UserID          string
+	APIKeyID        string     // set for bot connections, which the conversation bot allow-lists apply to
+	clientIP        netip.Addr // the client behind the upgrade request; unset for gRPC streams
+	workspaceID     string     // the tenant the connection's storage is routed to; see package tenant
+	Conn            ClientConn
+	fixedEncoding   bool // the transport dictates the encoding, so the auth frame cannot switch it
+	Send            chan *outboundFrame
+	encoding        frameEncoding // for writing; only the write pump changes it
+	readEncoding    frameEncoding // for reading binary frames; only the read pump changes it
+	ephemeral       *ephemeralQueue
+	frameBudget     *middleware.TokenBucket // nil when frames are not limited
+	sendMu          sync.RWMutex
+	sendClosed      bool
+	closeCode       *CloseCode // why Send was closed, if not a normal disconnect
+	compressed      bool       // permessage-deflate was negotiated
+	Hub             *WebSocketHub
+	subscriptions   map[string]bool
+	watching        map[string]time.Time // conversations viewed through a watch grant, and when it expires
 	subscriptionsMu sync.RWMutex
+
+	authMu         sync.Mutex
+	tokenExpiresAt time.Time // zero when the token has no expiry
+	expiryWarned   bool
+
+	session models.Session
+
+	// logger carries the user, client and upgrade request IDs
+	logger *slog.Logger
 }
 
 type ConversationSubscription struct {
 	ConversationID string
+	workspaceID    string // the conversation's tenant, for reads made on the subscription's behalf
 	Clients        map[string]*Client
+	streams        map[*EventStream]bool // server-sent event listeners, see events.go
 	ClientsMu      sync.RWMutex
-	NATSSub        *natsgo.Subscription
-	TypingSub      *natsgo.Subscription
-	PresenceSub    *natsgo.Subscription
+	presence       presenceState
+	sequence       sequenceState
 }
 
-func NewWebSocketHub(messageService *MessageService, natsConn *nats.NATSConnection) *WebSocketHub {
+func NewWebSocketHub(messageService *MessageService, conversationService *ConversationService, userService *UserService, watchService *WatchService, botService *BotService, challengeService *ChallengeService, natsConn *nats.NATSConnection, verifier TokenVerifier, clk clock.Clock, logger *slog.Logger, config HubConfig) *WebSocketHub {
 	return &WebSocketHub{
-		messageService: messageService,
-		natsConn:       natsConn,
-		clients:        make(map[string]*Client),
-		subscriptions:  make(map[string]*ConversationSubscription),
+		messageService:      messageService,
+		conversationService: conversationService,
+		userService:         userService,
+		watchService:        watchService,
+		botService:          botService,
+		challengeService:    challengeService,
+		natsConn:            natsConn,
+		verifier:            verifier,
+		config:              config,
+		clock:               clk,
+		logger:              logger,
+		clients:             make(map[string]*Client),
+		userClients:         make(map[string]map[string]*Client),
+		invisible:           make(map[string]bool),
+		streams:             make(map[string]*EventStream),
+		subscriptions:       make(map[string]*ConversationSubscription),
 	}
 }
 
-func (h *WebSocketHub) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID string) {
+// Run drives the hub's periodic work until ctx is cancelled
+func (h *WebSocketHub) Run(ctx context.Context) {
+	presenceTicker := time.NewTicker(h.config.PresenceRollupInterval)
+	defer presenceTicker.Stop()
+	authTicker := time.NewTicker(h.config.AuthCheckInterval)
+	defer authTicker.Stop()
+	heartbeatTicker := time.NewTicker(h.config.PresenceTTL / 3)
+	defer heartbeatTicker.Stop()
+	var lastSeenFlush <-chan time.Time
+	if h.config.LastSeenInterval > 0 {
+		lastSeenTicker := time.NewTicker(h.config.LastSeenInterval)
+		defer lastSeenTicker.Stop()
+		lastSeenFlush = lastSeenTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.flushLastSeen(context.Background())
+			return
+		case <-presenceTicker.C:
+			h.flushPresenceRollups()
+		case <-authTicker.C:
+			h.checkTokenExpiry()
+			h.checkWatchExpiry()
+		case <-heartbeatTicker.C:
+			h.heartbeatPresence()
+			h.expirePresence()
+			go h.refreshSessions()
+		case <-lastSeenFlush:
+			h.flushLastSeen(ctx)
+		}
+	}
+}
+
+func (h *WebSocketHub) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID, apiKeyID string, tokenExpiresAt time.Time) {
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"}, // Configure properly for production
+		OriginPatterns:       []string{"*"}, // Configure properly for production
+		Subprotocols:         []string{ProtobufSubprotocol, MsgpackSubprotocol, "bearer"},
+		CompressionMode:      compressionMode(h.config.Compression),
+		CompressionThreshold: h.config.CompressionThreshold,
 	})
 	if err != nil {
-		log.Printf("Failed to accept websocket connection: %v", err)
+		h.logger.WarnContext(r.Context(), "Failed to accept websocket connection", logging.UserID, userID, logging.Err(err))
 		return
 	}
 
-	clientID := fmt.Sprintf("%s-%d", userID, time.Now().UnixNano())
-	client := &Client{
-		ID:            clientID,
-		UserID:        userID,
-		Conn:          conn,
-		Send:          make(chan *models.WSFrame, 256),
-		Hub:           h,
-		subscriptions: make(map[string]bool),
+	device, ip := ConnectionOrigin(r)
+	client := h.newClient(r.Context(), conn, userID, apiKeyID, tokenExpiresAt, device, ip)
+	client.clientIP = clientip.FromContext(r.Context())
+	client.encoding = connectionEncoding(conn.Subprotocol())
+	client.readEncoding = client.encoding
+	client.compressed = negotiatedDeflate(w)
+	h.registerClient(client)
+
+	go client.writePump()
+	go client.readPump()
+	go h.deliverOffline(client)
+}
+
+// ServeStream serves a gRPC Chat stream as a client speaking protobuf frames, returning when
+// the stream ends. conn adapts the stream; its Close ends it.
+func (h *WebSocketHub) ServeStream(ctx context.Context, conn ClientConn, userID, apiKeyID string, tokenExpiresAt time.Time, device, peerAddr string) {
+	client := h.newClient(ctx, conn, userID, apiKeyID, tokenExpiresAt, device, remoteIP(peerAddr))
+	client.encoding = encodingProtobuf
+	client.readEncoding = encodingProtobuf
+	client.fixedEncoding = true
+	h.registerClient(client)
+
+	go client.readPump()
+	go h.deliverOffline(client)
+	// A stream may only be written from its handler's goroutine
+	client.writePump()
+}
+
+func (h *WebSocketHub) newClient(ctx context.Context, conn ClientConn, userID, apiKeyID string, tokenExpiresAt time.Time, device, ip string) *Client {
+	connectedAt := h.clock.Now()
+	clientID := fmt.Sprintf("%s-%d", userID, connectedAt.UnixNano())
+	return &Client{
+		ID:             clientID,
+		UserID:         userID,
+		APIKeyID:       apiKeyID,
+		workspaceID:    tenant.FromContext(ctx),
+		Conn:           conn,
+		Send:           make(chan *outboundFrame, h.config.SendBufferSize),
+		ephemeral:      newEphemeralQueue(h.config.EphemeralBufferSize),
+		frameBudget:    newFrameBudget(h.config.FrameBudget, h.clock),
+		Hub:            h,
+		subscriptions:  make(map[string]bool),
+		watching:       make(map[string]time.Time),
+		tokenExpiresAt: tokenExpiresAt,
+		session:        newSession(clientID, device, ip, connectedAt),
+		logger:         h.logger.With(logging.UserID, userID, logging.ClientID, clientID, logging.RequestID, requestid.FromContext(ctx)),
 	}
+}
+
+// context returns a context for work done on the client's behalf outside any request
+func (c *Client) context() context.Context {
+	return tenant.NewContext(context.Background(), c.workspaceID)
+}
+
+func (h *WebSocketHub) registerClient(client *Client) {
+	invisible := h.loadInvisible(client.context(), client.UserID)
 
 	h.clientsMu.Lock()
-	h.clients[clientID] = client
+	if invisible {
+		h.invisible[client.UserID] = true
+	}
+	h.clients[client.ID] = client
+	userClients := h.userClients[client.UserID]
+	if userClients == nil {
+		userClients = make(map[string]*Client)
+		h.userClients[client.UserID] = userClients
+	}
+	userClients[client.ID] = client
 	h.clientsMu.Unlock()
+	h.putSession(client.UserID, client.session)
+}
 
-	go client.writePump()
-	go client.readPump()
+// newFrameBudget returns the bucket limiting a connection to perMinute frames, or nil for no limit
+func newFrameBudget(perMinute int, clk clock.Clock) *middleware.TokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return middleware.NewTokenBucket(perMinute, time.Minute/time.Duration(perMinute), clk)
 }
 
 func (c *Client) readPump() {
@@ -87,18 +310,26 @@ func (c *Client) readPump() {
 
 	ctx := context.Background()
 	for {
-		_, messageBytes, err := c.Conn.Read(ctx)
+		messageType, messageBytes, err := c.Conn.Read(ctx)
 		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
+			c.logger.Debug("WebSocket read ended", logging.Err(err))
+			break
+		}
+		if c.frameBudget != nil && !c.frameBudget.Allow() {
+			c.logger.Warn("Frame budget exceeded")
+			c.closeWith(CloseRateLimited)
 			break
 		}
 
 		var frame models.WSFrame
-		if err := json.Unmarshal(messageBytes, &frame); err != nil {
-			log.Printf("Failed to unmarshal frame: %v", err)
-			continue
+		if err := decodeFrame(messageType, messageBytes, c.readEncoding, &frame); err != nil {
+			c.logger.Warn("Failed to unmarshal frame", logging.Err(err))
+			c.closeWith(CloseProtocolError)
+			break
 		}
 
+		frame.RequestID = requestid.OrNew(frame.RequestID)
+		c.Hub.markSeen(c)
 		c.handleFrame(&frame)
 	}
 }
@@ -112,33 +343,76 @@ func (c *Client) writePump() {
 		select {
 		case frame, ok := <-c.Send:
 			if !ok {
-				c.Conn.Close(websocket.StatusNormalClosure, "")
+				c.sendMu.RLock()
+				code := c.closeCode
+				c.sendMu.RUnlock()
+				if code != nil {
+					c.closeWith(*code)
+				} else {
+					c.Conn.Close(websocket.StatusNormalClosure, "")
+				}
 				return
 			}
 
-			frameBytes, err := json.Marshal(frame)
-			if err != nil {
-				log.Printf("Failed to marshal frame: %v", err)
-				continue
+			if !c.writeFrame(ctx, frame) {
+				return
 			}
 
-			if err := c.Conn.Write(ctx, websocket.MessageText, frameBytes); err != nil {
-				log.Printf("WebSocket write error: %v", err)
-				return
+		case <-c.ephemeral.ready:
+			for _, frame := range c.ephemeral.take() {
+				if !c.writeFrame(ctx, frame) {
+					return
+				}
 			}
 
 		case <-ticker.C:
 			if err := c.Conn.Ping(ctx); err != nil {
 				return
 			}
+			c.Hub.markSeen(c)
 		}
 	}
 }
 
+// writeFrame writes one frame to the socket in the client's encoding, reporting false if the
+// connection is gone
+func (c *Client) writeFrame(ctx context.Context, frame *outboundFrame) bool {
+	frameBytes, err := frame.encode(c.encoding)
+	if err != nil {
+		c.logger.Error("Failed to marshal frame", "type", frame.Type, logging.Err(err))
+		return true
+	}
+
+	if err := c.Conn.Write(ctx, c.encoding.messageType(), frameBytes); err != nil {
+		c.logger.Debug("WebSocket write failed", logging.Err(err))
+		return false
+	}
+	if frame.switchTo != nil {
+		c.encoding = *frame.switchTo
+	}
+	c.Hub.frameSizes.record(len(frameBytes), c.compressed && len(frameBytes) >= c.Hub.config.CompressionThreshold)
+	return true
+}
+
 func (c *Client) handleFrame(frame *models.WSFrame) {
-	ctx := context.Background()
+	ctx := requestid.NewContext(c.context(), frame.RequestID)
 
 	switch frame.Type {
+	case "heartbeat":
+		// Nothing to do: reading it marked the user seen
+
+	case "auth":
+		c.handleAuth(frame)
+
+	case "auth.refresh":
+		c.handleAuthRefresh(frame)
+
+	case "resume":
+		c.handleResume(frame)
+
+	case "members.page":
+		c.handleMembersPage(frame)
+
 	case "subscribe":
 		var data models.WSSubscribeData
 		dataBytes, err := json.Marshal(frame.Data)
@@ -150,7 +424,17 @@ func (c *Client) handleFrame(frame *models.WSFrame) {
 			c.sendError("INVALID_DATA", "Invalid subscribe data")
 			return
 		}
-		c.Hub.subscribeClient(c, data.ConversationID)
+
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessRead); err != nil {
+			c.sendError(ErrorCode(err, "SUBSCRIBE_FAILED"), PublicMessage(err, "Failed to subscribe"))
+			return
+		}
+		grant, err := c.Hub.watchService.AuthorizeRead(ctx, data.ConversationID, c.UserID, "websocket")
+		if err != nil {
+			c.sendError(ErrorCode(err, "SUBSCRIBE_FAILED"), PublicMessage(err, "Failed to subscribe"))
+			return
+		}
+		c.Hub.subscribeClient(c, data.ConversationID, grant)
 
 	case "unsubscribe":
 		var data models.WSUnsubscribeData
@@ -176,16 +460,34 @@ func (c *Client) handleFrame(frame *models.WSFrame) {
 			c.sendError("INVALID_DATA", "Invalid message data")
 			return
 		}
+		if err := validate.Struct(&data); err != nil {
+			c.sendError("INVALID_DATA", err.Error())
+			return
+		}
+
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessPost); err != nil {
+			c.sendError(ErrorCode(err, "SEND_FAILED"), PublicMessage(err, "Failed to send message"))
+			return
+		}
+		if c.APIKeyID == "" {
+			if err := c.Hub.challengeService.Screen(ctx, c.UserID, c.clientIP, data.Body); err != nil {
+				c.sendServiceError(err, "SEND_FAILED", "Failed to send message")
+				return
+			}
+		}
 
 		req := &models.SendMessageRequest{
 			ConversationID: data.ConversationID,
 			ClientMsgID:    data.ClientMsgID,
 			Body:           data.Body,
+			Format:         data.Format,
+			Type:           data.Type,
+			Payload:        data.Payload,
 		}
 
 		message, err := c.Hub.messageService.SendMessage(ctx, req, c.UserID)
 		if err != nil {
-			c.sendError("SEND_FAILED", fmt.Sprintf("Failed to send message: %v", err))
+			c.sendServiceError(err, "SEND_FAILED", "Failed to send message")
 			return
 		}
 
@@ -194,9 +496,33 @@ func (c *Client) handleFrame(frame *models.WSFrame) {
 			ClientMsgID: data.ClientMsgID,
 			ID:          message.ID,
 			CreatedAt:   message.CreatedAt,
+			RequestID:   frame.RequestID,
 		}
 		c.sendFrame("message.ack", ackData)
 
+	case "message.retract":
+		var data models.WSMessageRetractData
+		dataBytes, err := json.Marshal(frame.Data)
+		if err != nil {
+			c.sendError("INVALID_DATA", "Invalid retract data format")
+			return
+		}
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			c.sendError("INVALID_DATA", "Invalid retract data")
+			return
+		}
+
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessPost); err != nil {
+			c.sendError(ErrorCode(err, "RETRACT_FAILED"), PublicMessage(err, "Failed to retract message"))
+			return
+		}
+
+		// Success is announced by the message.retracted broadcast
+		if err := c.Hub.messageService.RetractMessage(ctx, data.ConversationID, data.ID, c.UserID); err != nil {
+			c.sendError(ErrorCode(err, "RETRACT_FAILED"), PublicMessage(err, "Failed to retract message"))
+			return
+		}
+
 	case "typing.update":
 		var data models.WSTypingUpdateData
 		dataBytes, err := json.Marshal(frame.Data)
@@ -209,9 +535,42 @@ func (c *Client) handleFrame(frame *models.WSFrame) {
 			return
 		}
 
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessPost); err != nil {
+			return
+		}
+
 		err = c.Hub.messageService.PublishTypingIndicator(data.ConversationID, c.UserID, data.IsTyping)
 		if err != nil {
-			log.Printf("Failed to publish typing indicator: %v", err)
+			c.logger.ErrorContext(ctx, "Failed to publish typing indicator", logging.ConversationID, data.ConversationID, logging.Err(err))
+		}
+
+	case "location.update":
+		var data models.WSLocationUpdateData
+		dataBytes, err := json.Marshal(frame.Data)
+		if err != nil {
+			c.sendError("INVALID_DATA", "Invalid location data format")
+			return
+		}
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			c.sendError("INVALID_DATA", "Invalid location data")
+			return
+		}
+
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessPost); err != nil {
+			c.sendError(ErrorCode(err, "LOCATION_FAILED"), PublicMessage(err, "Failed to update location"))
+			return
+		}
+
+		// Success is the location.update broadcast
+		req := &models.LocationUpdateRequest{
+			ConversationID: data.ConversationID,
+			Latitude:       data.Latitude,
+			Longitude:      data.Longitude,
+			Stop:           data.Stop,
+		}
+		if _, err := c.Hub.messageService.UpdateLiveLocation(ctx, data.MessageID, c.UserID, req); err != nil {
+			c.sendError(ErrorCode(err, "LOCATION_FAILED"), PublicMessage(err, "Failed to update location"))
+			return
 		}
 
 	case "receipt.read":
@@ -226,39 +585,152 @@ func (c *Client) handleFrame(frame *models.WSFrame) {
 			return
 		}
 
-		err = c.Hub.messageService.MarkMessageAsRead(ctx, data.ConversationID, c.UserID, data.MessageID)
+		if err := c.authorizeBot(ctx, data.ConversationID, models.BotAccessRead); err != nil {
+			return
+		}
+
+		err = c.Hub.messageService.MarkMessageAsRead(ctx, data.ConversationID, c.UserID, c.ID, data.MessageID)
 		if err != nil {
-			log.Printf("Failed to mark message as read: %v", err)
+			c.logger.ErrorContext(ctx, "Failed to mark message as read", logging.ConversationID, data.ConversationID, logging.MessageID, data.MessageID, logging.Err(err))
 		}
 	}
 }
 
-func (c *Client) sendFrame(frameType string, data interface{}) {
-	frame := &models.WSFrame{
+// newFrame wraps data in a frame envelope stamped with the hub's clock
+func (h *WebSocketHub) newFrame(frameType string, data interface{}) *models.WSFrame {
+	return &models.WSFrame{
 		Type: frameType,
-		TS:   time.Now().UnixMilli(),
+		TS:   h.clock.Now().UnixMilli(),
 		Data: data,
 	}
+}
+
+func (c *Client) sendFrame(frameType string, data interface{}) {
+	c.queue(newOutboundFrame(c.Hub.newFrame(frameType, data)), 0)
+}
+
+// sendFrameWait is sendFrame for bulk sends (e.g. replays) that may outrun the buffer: it waits up
+// to timeout for room before giving up on the client. It reports whether the frame was queued.
+func (c *Client) sendFrameWait(frameType string, data interface{}, timeout time.Duration) bool {
+	return c.queue(newOutboundFrame(c.Hub.newFrame(frameType, data)), timeout)
+}
+
+// queue hands a frame to the write pump, waiting up to timeout for buffer space (zero never
+// waits). A client that cannot keep up is disconnected, except that typing and presence frames
+// are dropped instead; see backpressure.go. Several goroutines send to a client (hub fan-out,
+// replays, offline delivery), so the channel is only closed through closeSend.
+func (c *Client) queue(frame *outboundFrame, timeout time.Duration) bool {
+	if droppableFrames[frame.Type] {
+		c.queueDroppable(frame)
+		return true
+	}
+
+	c.sendMu.RLock()
+	if c.sendClosed {
+		c.sendMu.RUnlock()
+		return false
+	}
+
+	var queued bool
+	if timeout <= 0 {
+		select {
+		case c.Send <- frame:
+			queued = true
+		default:
+		}
+	} else {
+		timer := time.NewTimer(timeout)
+		select {
+		case c.Send <- frame:
+			queued = true
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	c.sendMu.RUnlock()
+
+	if !queued {
+		c.disconnectSlow()
+	}
+	return queued
+}
+
+// closeSend closes the send channel once, which makes the write pump close the socket
+func (c *Client) closeSend() {
+	c.closeSendWith(nil)
+}
+
+// closeSendWith is closeSend with the close code the write pump should use. It reports
+// whether this call closed the channel.
+func (c *Client) closeSendWith(code *CloseCode) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 
-	select {
-	case c.Send <- frame:
-	default:
-		close(c.Send)
+	if c.sendClosed {
+		return false
 	}
+	c.sendClosed = true
+	c.closeCode = code
+	close(c.Send)
+	return true
 }
 
 func (c *Client) sendError(code, message string) {
 	errorData := &models.WSErrorData{
+		Type:    problem.Type(code),
 		Code:    code,
+		Detail:  message,
 		Message: message,
 	}
 	c.sendFrame("error", errorData)
 }
 
+// sendServiceError is sendError for a service failure, with the wait before retrying when known
+func (c *Client) sendServiceError(err error, fallbackCode, fallbackMessage string) {
+	code, message := ErrorCode(err, fallbackCode), PublicMessage(err, fallbackMessage)
+	c.sendFrame("error", &models.WSErrorData{
+		Type:              problem.Type(code),
+		Code:              code,
+		Detail:            message,
+		Message:           message,
+		RetryAfterSeconds: problem.RetryAfterSeconds(RetryAfter(err)),
+	})
+}
+
+// Shutdown tells every connected client to reconnect elsewhere. Hijacked WebSocket
+// connections are not covered by http.Server.Shutdown, so main calls this first.
+func (h *WebSocketHub) Shutdown() {
+	h.clientsMu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.clientsMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			c.closeWith(CloseServerDrain)
+		}(client)
+	}
+	wg.Wait()
+}
+
 func (h *WebSocketHub) unregisterClient(client *Client) {
 	h.clientsMu.Lock()
 	delete(h.clients, client.ID)
+	if userClients := h.userClients[client.UserID]; userClients != nil {
+		delete(userClients, client.ID)
+		if len(userClients) == 0 {
+			delete(h.userClients, client.UserID)
+			delete(h.invisible, client.UserID)
+		}
+	}
 	h.clientsMu.Unlock()
+	h.deleteSession(client.UserID, client.ID)
+	h.markSeen(client)
 
 	// Unsubscribe from all conversations
 	client.subscriptionsMu.RLock()
@@ -272,32 +744,61 @@ func (h *WebSocketHub) unregisterClient(client *Client) {
 		h.unsubscribeClient(client, convID)
 	}
 
-	close(client.Send)
+	// Bot connections neither receive nor trigger offline delivery for their user
+	if client.APIKeyID == "" && !h.userConnected(client.UserID) {
+		go h.parkUser(client.context(), client.UserID)
+	}
+
+	client.closeSend()
 }
 
-func (h *WebSocketHub) subscribeClient(client *Client, conversationID string) {
+// subscribeClient adds the client to a conversation's fan-out. A non-nil grant marks a
+// watch-only subscription, which never announces presence.
+func (h *WebSocketHub) subscribeClient(client *Client, conversationID string, grant *models.WatchGrant) {
 	h.subsMu.Lock()
 	defer h.subsMu.Unlock()
 
+	sub := h.subscriptionLocked(conversationID, client.workspaceID)
+
+	sub.ClientsMu.Lock()
+	firstForUser := !hasUserClient(sub, client.UserID)
+	sub.Clients[client.ID] = client
+	sub.ClientsMu.Unlock()
+
+	client.subscriptionsMu.Lock()
+	client.subscriptions[conversationID] = true
+	if grant != nil {
+		client.watching[conversationID] = grant.ExpiresAt
+	}
+	client.subscriptionsMu.Unlock()
+
+	if firstForUser && grant == nil {
+		h.publishPresence(conversationID, client.UserID, PresenceOnline)
+	}
+	h.sendPresenceSnapshot(client, sub)
+}
+
+// subscriptionLocked returns the conversation's subscription, creating it if needed. Callers
+// must hold h.subsMu for writing.
+func (h *WebSocketHub) subscriptionLocked(conversationID, workspaceID string) *ConversationSubscription {
 	sub, exists := h.subscriptions[conversationID]
 	if !exists {
 		sub = &ConversationSubscription{
 			ConversationID: conversationID,
+			workspaceID:    workspaceID,
 			Clients:        make(map[string]*Client),
+			streams:        make(map[*EventStream]bool),
+			presence: presenceState{
+				online:  h.onlineUsers(conversationID),
+				pending: make(map[string]string),
+			},
 		}
 
-		// Subscribe to NATS subjects
-		h.setupNATSSubscriptions(sub)
+		// Events reach it through the node-wide fan-out once it is registered
 		h.subscriptions[conversationID] = sub
+		go h.refreshMemberCount(sub)
 	}
-
-	sub.ClientsMu.Lock()
-	sub.Clients[client.ID] = client
-	sub.ClientsMu.Unlock()
-
-	client.subscriptionsMu.Lock()
-	client.subscriptions[conversationID] = true
-	client.subscriptionsMu.Unlock()
+	return sub
 }
 
 func (h *WebSocketHub) unsubscribeClient(client *Client, conversationID string) {
@@ -311,106 +812,64 @@ func (h *WebSocketHub) unsubscribeClient(client *Client, conversationID string)
 
 	sub.ClientsMu.Lock()
 	delete(sub.Clients, client.ID)
-	clientCount := len(sub.Clients)
+	clientCount := len(sub.Clients) + len(sub.streams)
+	lastForUser := !hasUserClient(sub, client.UserID)
 	sub.ClientsMu.Unlock()
 
 	client.subscriptionsMu.Lock()
 	delete(client.subscriptions, conversationID)
+	_, watching := client.watching[conversationID]
+	delete(client.watching, conversationID)
 	client.subscriptionsMu.Unlock()
 
-	// If no more clients, cleanup NATS subscriptions
+	if lastForUser && !watching {
+		h.publishPresence(conversationID, client.UserID, PresenceOffline)
+	}
+
+	// If no more clients or event streams, stop routing the conversation's events here
 	if clientCount == 0 {
-		if sub.NATSSub != nil {
-			sub.NATSSub.Unsubscribe()
-		}
-		if sub.TypingSub != nil {
-			sub.TypingSub.Unsubscribe()
-		}
-		if sub.PresenceSub != nil {
-			sub.PresenceSub.Unsubscribe()
-		}
 		delete(h.subscriptions, conversationID)
 	}
 }
 
-func (h *WebSocketHub) setupNATSSubscriptions(sub *ConversationSubscription) {
-	// Subscribe to messages (JetStream)
-	messageSubject := fmt.Sprintf("chat.conv.%s.msg", sub.ConversationID)
-	natsSub, err := h.natsConn.Conn.Subscribe(messageSubject, func(msg *natsgo.Msg) {
-		var messageData models.WSMessageNewData
-		if err := json.Unmarshal(msg.Data, &messageData); err != nil {
-			log.Printf("Failed to unmarshal message data: %v", err)
-			return
-		}
+func (h *WebSocketHub) broadcastToSubscription(sub *ConversationSubscription, frame *models.WSFrame) {
+	outbound := newOutboundFrame(frame)
 
-		frame := &models.WSFrame{
-			Type: "message.new",
-			TS:   time.Now().UnixMilli(),
-			Data: messageData,
-		}
+	sub.ClientsMu.RLock()
+	defer sub.ClientsMu.RUnlock()
 
-		h.broadcastToSubscription(sub, frame)
-	})
-	if err != nil {
-		log.Printf("Failed to subscribe to messages: %v", err)
+	for _, client := range sub.Clients {
+		// Slow clients are disconnected and removed when their read pump unregisters them
+		client.queue(outbound, 0)
 	}
-	sub.NATSSub = natsSub
-
-	// Subscribe to typing indicators
-	typingSubject := fmt.Sprintf("chat.conv.%s.typing", sub.ConversationID)
-	typingSub, err := h.natsConn.Conn.Subscribe(typingSubject, func(msg *natsgo.Msg) {
-		var typingData models.WSTypingUpdateEventData
-		if err := json.Unmarshal(msg.Data, &typingData); err != nil {
-			log.Printf("Failed to unmarshal typing data: %v", err)
-			return
-		}
-
-		frame := &models.WSFrame{
-			Type: "typing.update",
-			TS:   time.Now().UnixMilli(),
-			Data: typingData,
-		}
-
-		h.broadcastToSubscription(sub, frame)
-	})
-	if err != nil {
-		log.Printf("Failed to subscribe to typing: %v", err)
+	for stream := range sub.streams {
+		stream.send(frame)
 	}
-	sub.TypingSub = typingSub
+}
 
-	// Subscribe to presence/receipts
-	presenceSubject := fmt.Sprintf("chat.conv.%s.presence", sub.ConversationID)
-	presenceSub, err := h.natsConn.Conn.Subscribe(presenceSubject, func(msg *natsgo.Msg) {
-		var receiptData models.WSReceiptUpdateData
-		if err := json.Unmarshal(msg.Data, &receiptData); err != nil {
-			log.Printf("Failed to unmarshal receipt data: %v", err)
-			return
-		}
+// sendToUser queues frame for the user's connections on this node, except bots and the
+// connection with ID exceptID
+func (h *WebSocketHub) sendToUser(userID, exceptID string, frame *models.WSFrame) {
+	outbound := newOutboundFrame(frame)
 
-		frame := &models.WSFrame{
-			Type: "receipt.update",
-			TS:   time.Now().UnixMilli(),
-			Data: receiptData,
-		}
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
 
-		h.broadcastToSubscription(sub, frame)
-	})
-	if err != nil {
-		log.Printf("Failed to subscribe to presence: %v", err)
+	for _, client := range h.userClients[userID] {
+		if client.ID == exceptID || client.APIKeyID != "" {
+			continue
+		}
+		client.queue(outbound, 0)
 	}
-	sub.PresenceSub = presenceSub
 }
 
-func (h *WebSocketHub) broadcastToSubscription(sub *ConversationSubscription, frame *models.WSFrame) {
-	sub.ClientsMu.RLock()
-	defer sub.ClientsMu.RUnlock()
-
-	for _, client := range sub.Clients {
-		select {
-		case client.Send <- frame:
-		default:
-			close(client.Send)
-			delete(sub.Clients, client.ID)
+// hasUserClient reports whether any of the subscription's clients belong to userID.
+// Callers must hold sub.ClientsMu.
+func hasUserClient(sub *ConversationSubscription, userID string) bool {
+	for _, c := range sub.Clients {
+		if c.UserID == userID {
+			return true
 		}
 	}
-}
\ No newline at end of file
+	return false
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/permissions"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/database"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultWorkspaceID is the workspace holding data from before workspaces existed, and users
+// whose token names no workspace
+const DefaultWorkspaceID = tenant.Default
+
+// workspaceCollections hold documents owned directly by a workspace; everything else belongs
+// to one through its conversation or user
+var workspaceCollections = []string{"users", "conversations", "api_keys", "custom_emoji", purgeJobsCollection}
+
+var (
+	// workspaceIDPattern keeps workspace IDs usable in settings document IDs and URLs
+	workspaceIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	emailDomainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+)
+
+// WorkspaceService keeps the workspaces themselves: who joins which, and what their admins
+// configure for them. Conversation-level admin rights are separate and stay with each conversation.
+type WorkspaceService struct {
+	db                  *database.MongoDB
+	conversationService *ConversationService
+	userService         *UserService
+	auditService        *AuditService
+	clock               clock.Clock
+	logger              *slog.Logger
+	// github verifies profiles against GitHub before they are saved; nil trusts the client
+	github *GitHubVerifier
+
+	// claim is the token claim naming a user's workspace; empty puts everyone in the default one
+	claim string
+	// known caches the workspaces ForClaim has found; workspaces are never deleted
+	known sync.Map
+}
+
+func NewWorkspaceService(db *database.MongoDB, conversationService *ConversationService, userService *UserService, auditService *AuditService, github *GitHubVerifier, clk clock.Clock, logger *slog.Logger, claim string) *WorkspaceService {
+	return &WorkspaceService{
+		db:                  db,
+		conversationService: conversationService,
+		userService:         userService,
+		auditService:        auditService,
+		github:              github,
+		clock:               clk,
+		logger:              logger,
+		claim:               claim,
+	}
+}
+
+// Claim is the name of the token claim that places a new user in a workspace
+func (s *WorkspaceService) Claim() string {
+	return s.claim
+}
+
+// Start creates the default workspace and moves anything written before workspaces existed into
+// it. Both steps are idempotent, so every node runs them at startup.
+func (s *WorkspaceService) Start(ctx context.Context) error {
+	_, err := s.db.Collection(ctx, "workspaces").UpdateOne(ctx,
+		bson.M{"_id": DefaultWorkspaceID},
+		bson.M{"$setOnInsert": bson.M{"name": "Default", "createdAt": s.clock.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to create default workspace: %w", err)
+	}
+
+	for _, name := range workspaceCollections {
+		result, err := s.db.Collection(ctx, name).UpdateMany(ctx,
+			bson.M{"workspaceId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"workspaceId": DefaultWorkspaceID}})
+		if err != nil {
+			return fmt.Errorf("failed to backfill %s workspace: %w", name, err)
+		}
+		if result.ModifiedCount > 0 {
+			s.logger.Info("Moved documents into the default workspace", "collection", name, "count", result.ModifiedCount)
+		}
+	}
+	return nil
+}
+
+// CreateWorkspace adds an empty workspace; users join it by presenting its ID in the workspace claim
+func (s *WorkspaceService) CreateWorkspace(ctx context.Context, req *models.CreateWorkspaceRequest) (*models.Workspace, error) {
+	if !workspaceIDPattern.MatchString(req.ID) {
+		return nil, validationError("workspace id must be lowercase letters, digits and hyphens")
+	}
+	if req.Name == "" || len(req.Name) > 200 {
+		return nil, validationError("workspace name must be 1 to 200 characters")
+	}
+
+	workspace := &models.Workspace{
+		ID:        req.ID,
+		Name:      req.Name,
+		CreatedAt: s.clock.Now(),
+	}
+	if _, err := s.db.Collection(ctx, "workspaces").InsertOne(ctx, workspace); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, conflictError("workspace already exists")
+		}
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return workspace, nil
+}
+
+func (s *WorkspaceService) ListWorkspaces(ctx context.Context) ([]models.Workspace, error) {
+	cursor, err := s.db.Collection(ctx, "workspaces").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	workspaces := []models.Workspace{}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return nil, fmt.Errorf("failed to decode workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// UpsertUser saves the caller's profile. A user seen for the first time joins the workspace
+// their token's claim names, if their email domain is allowed there, and is added to its
+// default conversations; after that the claim is ignored. When profiles are verified, the
+// email, name and avatar come from the GitHub account githubToken belongs to instead.
+func (s *WorkspaceService) UpsertUser(ctx context.Context, user *models.User, claim, githubToken string) error {
+	if s.github != nil {
+		if err := s.github.Verify(ctx, user, githubToken); err != nil {
+			return err
+		}
+	}
+
+	existing, err := s.userService.GetUserByID(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if existing != nil {
+		user.WorkspaceID = existing.WorkspaceID
+	} else if user.WorkspaceID, err = s.ForClaim(ctx, claim); err != nil {
+		return err
+	}
+	workspace, err := s.get(ctx, user.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if existing == nil || !strings.EqualFold(existing.Email, user.Email) {
+		if !emailAllowed(workspace, user.Email) {
+			return forbiddenError("email domain is not allowed in this workspace")
+		}
+	}
+
+	if err := s.userService.UpsertUser(ctx, user); err != nil {
+		return err
+	}
+	if existing == nil {
+		if err := s.conversationService.JoinDefaults(ctx, user, workspace.DefaultConversationIDs); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to add user to default conversations", logging.UserID, user.ID, logging.Err(err))
+		}
+	}
+	return nil
+}
+
+// GetWorkspace returns the admin's workspace
+func (s *WorkspaceService) GetWorkspace(ctx context.Context, actorID string) (*models.Workspace, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	return s.get(ctx, actor.WorkspaceID)
+}
+
+// SetDefaultConversations replaces the group conversations new members are added to. Members
+// who joined before are not added.
+func (s *WorkspaceService) SetDefaultConversations(ctx context.Context, actorID string, req *models.DefaultConversationsRequest) (*models.Workspace, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	conversationIDs := []string{}
+	for _, conversationID := range req.ConversationIDs {
+		if slices.Contains(conversationIDs, conversationID) {
+			continue
+		}
+		conversation, err := s.conversationService.GetConversationByID(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		if conversation.WorkspaceID != actor.WorkspaceID {
+			return nil, notFoundError("conversation not found")
+		}
+		if conversation.Kind != "group" {
+			return nil, validationError("default conversations must be groups")
+		}
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+
+	return s.update(ctx, actorID, actor.WorkspaceID, "defaultConversationIds", conversationIDs)
+}
+
+// SetEmailDomains replaces the email domains users may join with. Existing members keep their
+// access, but may only change their email to an allowed domain.
+func (s *WorkspaceService) SetEmailDomains(ctx context.Context, actorID string, req *models.EmailDomainsRequest) (*models.Workspace, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := []string{}
+	for _, domain := range req.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if !emailDomainPattern.MatchString(domain) {
+			return nil, validationError("invalid email domain " + domain)
+		}
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	return s.update(ctx, actorID, actor.WorkspaceID, "allowedEmailDomains", domains)
+}
+
+// SetAttachmentPolicy replaces the rules for files members upload; an empty policy allows every
+// safe type up to the deployment's and plan's limits. Files already uploaded are kept.
+func (s *WorkspaceService) SetAttachmentPolicy(ctx context.Context, actorID string, req *models.AttachmentPolicy) (*models.Workspace, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := normalizeAttachmentPolicy(req)
+	if err != nil {
+		return nil, err
+	}
+	return s.setField(ctx, actorID, actor.WorkspaceID, "attachmentPolicy", policy, policy == nil)
+}
+
+// ListMembers returns up to limit of the admin's workspace users ordered by ID, starting after
+// cursor (a user ID; empty for the first page)
+func (s *WorkspaceService) ListMembers(ctx context.Context, actorID, cursor string, limit int) (*models.WorkspaceMembersPage, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"workspaceId": actor.WorkspaceID}
+	if cursor != "" {
+		filter["_id"] = bson.M{"$gt": cursor}
+	}
+	results, err := s.db.Collection(ctx, "users").Find(ctx, filter, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(limit+1))) // one extra to tell whether there are more
+	if err != nil {
+		return nil, fmt.Errorf("failed to find members: %w", err)
+	}
+	page := &models.WorkspaceMembersPage{Members: []models.User{}}
+	if err := results.All(ctx, &page.Members); err != nil {
+		return nil, fmt.Errorf("failed to decode members: %w", err)
+	}
+	if len(page.Members) > limit {
+		page.Members = page.Members[:limit]
+		page.NextCursor = page.Members[limit-1].ID
+	}
+	return page, nil
+}
+
+// SetMemberRoles replaces a member's workspace roles. Admins cannot drop their own admin role,
+// so a workspace always keeps the admin who could restore it.
+func (s *WorkspaceService) SetMemberRoles(ctx context.Context, actorID, userID string, req *models.MemberRolesRequest) (*models.User, error) {
+	actor, err := requireWorkspaceAdmin(ctx, s.userService, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	for _, role := range req.Roles {
+		if !permissions.IsWorkspaceRole(role) {
+			return nil, validationError("unknown role " + role)
+		}
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	if userID == actorID && !slices.Contains(roles, models.RoleWorkspaceAdmin) {
+		return nil, conflictError("you cannot remove your own workspace admin role")
+	}
+
+	user, err := s.userService.SetRoles(ctx, actor.WorkspaceID, userID, roles)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, AuditWorkspaceRolesUpdated, actorID, map[string]interface{}{
+		"userId": userID,
+		"roles":  roles,
+	})
+	return user, nil
+}
+
+func (s *WorkspaceService) get(ctx context.Context, workspaceID string) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := s.db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("workspace not found")
+		}
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// update sets one list of a workspace, clearing it when empty, and audits the change
+func (s *WorkspaceService) update(ctx context.Context, actorID, workspaceID, field string, value []string) (*models.Workspace, error) {
+	return s.setField(ctx, actorID, workspaceID, field, value, len(value) == 0)
+}
+
+// setField sets one field of a workspace, or clears it, and audits the change
+func (s *WorkspaceService) setField(ctx context.Context, actorID, workspaceID, field string, value interface{}, unset bool) (*models.Workspace, error) {
+	update := bson.M{"$set": bson.M{field: value}}
+	if unset {
+		update = bson.M{"$unset": bson.M{field: ""}}
+	}
+
+	var workspace models.Workspace
+	err := s.db.Collection(ctx, "workspaces").FindOneAndUpdate(ctx, bson.M{"_id": workspaceID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&workspace)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFoundError("workspace not found")
+		}
+		return nil, fmt.Errorf("failed to update workspace: %w", err)
+	}
+
+	s.audit(ctx, AuditWorkspaceUpdated, actorID, map[string]interface{}{field: value})
+	return &workspace, nil
+}
+
+func (s *WorkspaceService) audit(ctx context.Context, action, actorID string, details map[string]interface{}) {
+	if err := s.auditService.Record(ctx, action, actorID, "", details); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to audit", "action", action, logging.UserID, actorID, logging.Err(err))
+	}
+}
+
+// sweepEachTenant runs a periodic sweep once per workspace; see database.MongoDB.EachTenant
+func sweepEachTenant(ctx context.Context, db *database.MongoDB, logger *slog.Logger, sweep func(ctx context.Context)) {
+	err := db.EachTenant(ctx, func(ctx context.Context) error {
+		sweep(ctx)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to list workspaces to sweep", logging.Err(err))
+	}
+}
+
+// emailAllowed reports whether an email's domain may join the workspace
+func emailAllowed(workspace *models.Workspace, email string) bool {
+	if len(workspace.AllowedEmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return slices.Contains(workspace.AllowedEmailDomains, strings.ToLower(email[at+1:]))
+}
+
+// ForClaim returns the workspace a token's claim value names: the one a new user joins, and
+// when tenants are isolated, the one every request is served from. Tokens may only name
+// workspaces that exist, so a misconfigured identity provider cannot create them.
+func (s *WorkspaceService) ForClaim(ctx context.Context, claim string) (string, error) {
+	if claim == "" {
+		return DefaultWorkspaceID, nil
+	}
+	if _, ok := s.known.Load(claim); ok {
+		return claim, nil
+	}
+
+	err := s.db.Collection(ctx, "workspaces").FindOne(ctx, bson.M{"_id": claim},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", forbiddenError("unknown workspace")
+		}
+		return "", fmt.Errorf("failed to get workspace: %w", err)
+	}
+	s.known.Store(claim, true)
+	return claim, nil
+}
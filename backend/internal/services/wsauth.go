@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// TokenVerifier validates bearer tokens presented over an open socket
+type TokenVerifier interface {
+	Verify(tokenString string) (jwt.Token, error)
+}
+
+// handleAuth handles the optional auth frame. The connection was authenticated at the upgrade,
+// so a token in it is treated as a refresh; its encoding option switches the frame encoding
+// for clients that cannot choose a subprotocol.
+func (c *Client) handleAuth(frame *models.WSFrame) {
+	data, ok := c.decodeAuthData(frame)
+	if !ok {
+		return
+	}
+	if data.JWT != "" {
+		c.refreshToken(data.JWT)
+	}
+	if data.Encoding == "" {
+		return
+	}
+
+	encoding, ok := parseEncoding(data.Encoding)
+	if !ok {
+		c.sendError("INVALID_DATA", "Unknown encoding")
+		return
+	}
+	if c.fixedEncoding {
+		c.sendError("INVALID_DATA", "This connection's encoding cannot be changed")
+		return
+	}
+	// Client frames after this one are read in the new encoding; server frames switch after
+	// the confirmation, which is still written in the old one
+	c.readEncoding = encoding
+	confirmation := newOutboundFrame(c.Hub.newFrame("encoding.changed", &models.WSEncodingChangedData{Encoding: encoding.String()}))
+	confirmation.switchTo = &encoding
+	c.queue(confirmation, 0)
+}
+
+// handleAuthRefresh swaps in a newer token for the connection
+func (c *Client) handleAuthRefresh(frame *models.WSFrame) {
+	if data, ok := c.decodeAuthData(frame); ok {
+		c.refreshToken(data.JWT)
+	}
+}
+
+func (c *Client) decodeAuthData(frame *models.WSFrame) (models.WSAuthData, bool) {
+	var data models.WSAuthData
+	dataBytes, err := json.Marshal(frame.Data)
+	if err != nil {
+		c.sendError("INVALID_DATA", "Invalid auth data format")
+		return data, false
+	}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		c.sendError("INVALID_DATA", "Invalid auth data")
+		return data, false
+	}
+	return data, true
+}
+
+// refreshToken swaps in a newer token for the connection. An invalid token is reported but
+// does not end the session; the current token stays in force until it expires.
+func (c *Client) refreshToken(jwtString string) {
+	token, err := c.Hub.verifier.Verify(jwtString)
+	if err != nil {
+		c.sendError("AUTH_INVALID", "Refresh token rejected")
+		return
+	}
+
+	if token.Subject() != c.UserID {
+		// A different identity cannot take over an open connection
+		c.closeWith(CloseAuthFailed)
+		return
+	}
+
+	c.authMu.Lock()
+	c.tokenExpiresAt = token.Expiration()
+	c.expiryWarned = false
+	c.authMu.Unlock()
+
+	c.sendFrame("auth.refreshed", &models.WSAuthRefreshedData{ExpiresAt: token.Expiration()})
+}
+
+// checkTokenExpiry warns clients whose token is close to expiring and disconnects those whose token has lapsed
+func (h *WebSocketHub) checkTokenExpiry() {
+	h.clientsMu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.clientsMu.RUnlock()
+
+	now := h.clock.Now()
+	for _, client := range clients {
+		client.authMu.Lock()
+		expiresAt := client.tokenExpiresAt
+		warn := !expiresAt.IsZero() && !client.expiryWarned && expiresAt.Sub(now) <= h.config.AuthExpiryWarning
+		if warn {
+			client.expiryWarned = true
+		}
+		client.authMu.Unlock()
+
+		if expiresAt.IsZero() {
+			continue
+		}
+
+		if !now.Before(expiresAt) {
+			go client.closeWith(CloseAuthFailed)
+			continue
+		}
+
+		if warn {
+			client.sendFrame("auth.expiring", &models.WSAuthExpiringData{ExpiresAt: expiresAt})
+		}
+	}
+}
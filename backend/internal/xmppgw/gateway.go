@@ -0,0 +1,470 @@
+// Package xmppgw lets XMPP clients take part in conversations. It joins an XMPP server as an
+// external component (XEP-0114) serving one domain, and every XMPP resource that writes to the
+// domain gets a session the hub serves as it does a gRPC Chat stream, so the same permissions,
+// limits and frames apply. Group conversations are multi-user chat rooms (XEP-0045) at
+// <conversation id>@domain, and direct messages are 1:1 chats with <username>@domain. Typing
+// maps to chat states (XEP-0085) and conversation presence to room occupants.
+package xmppgw
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+)
+
+const (
+	dialTimeout   = 10 * time.Second
+	writeTimeout  = 10 * time.Second
+	maxRetryDelay = 30 * time.Second
+	// sessionIdleTimeout ends sessions in no rooms once their resource has been quiet this long;
+	// XMPP servers do not tell components when a resource that only sent 1:1 chats goes away
+	sessionIdleTimeout = 30 * time.Minute
+	sweepInterval      = time.Minute
+)
+
+var (
+	errNotConnected = errors.New("not connected to the XMPP server")
+	errUnregistered = errors.New("no user has this JID as their email")
+	errDeactivated  = errors.New("the user with this JID as their email is deactivated")
+)
+
+type Config struct {
+	Addr   string // the XMPP server's component port; empty disables the gateway
+	Domain string // the component's domain, e.g. chat.example.com
+	Secret string // shared secret for the component handshake
+}
+
+// Gateway is the XMPP component. Run it on one node: an XMPP server accepts a single
+// connection per component domain.
+type Gateway struct {
+	config        Config
+	hub           *services.WebSocketHub
+	users         *services.UserService
+	conversations *services.ConversationService
+	clock         clock.Clock
+	logger        *slog.Logger
+
+	writeMu sync.Mutex
+	conn    net.Conn // set while connected
+
+	sessionsMu sync.Mutex
+	sessions   map[string]*session // by full JID
+}
+
+func New(config Config, hub *services.WebSocketHub, users *services.UserService, conversations *services.ConversationService, clk clock.Clock, logger *slog.Logger) *Gateway {
+	return &Gateway{
+		config:        config,
+		hub:           hub,
+		users:         users,
+		conversations: conversations,
+		clock:         clk,
+		logger:        logger,
+		sessions:      make(map[string]*session),
+	}
+}
+
+// Run keeps the component connected until ctx is cancelled, reconnecting with backoff
+func (g *Gateway) Run(ctx context.Context) {
+	if g.config.Addr == "" {
+		g.logger.Info("XMPP gateway disabled")
+		return
+	}
+
+	delay := time.Second
+	for {
+		started := g.clock.Now()
+		err := g.connectAndServe(ctx)
+		g.closeSessions()
+		if ctx.Err() != nil {
+			return
+		}
+		if g.clock.Now().Sub(started) > maxRetryDelay {
+			delay = time.Second
+		}
+		g.logger.Warn("XMPP gateway disconnected", "addr", g.config.Addr, "retry_in", delay, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+func (g *Gateway) connectAndServe(ctx context.Context) error {
+	var dialer net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	conn, err := dialer.DialContext(dialCtx, "tcp", g.config.Addr)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	decoder := xml.NewDecoder(conn)
+	if err := g.handshake(conn, decoder); err != nil {
+		return err
+	}
+	g.writeMu.Lock()
+	g.conn = conn
+	g.writeMu.Unlock()
+	defer func() {
+		g.writeMu.Lock()
+		g.conn = nil
+		g.writeMu.Unlock()
+	}()
+	g.logger.Info("XMPP gateway connected", "addr", g.config.Addr, "domain", g.config.Domain)
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go g.sweepSessions(serveCtx)
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			if end, ok := token.(xml.EndElement); ok && end.Name.Local == "stream" {
+				return errors.New("stream closed by the XMPP server")
+			}
+			continue
+		}
+
+		var stanza inboundStanza
+		if err := decoder.DecodeElement(&stanza, &start); err != nil {
+			return err
+		}
+		switch {
+		case start.Name.Space == nsStreams && start.Name.Local == "error":
+			return fmt.Errorf("stream error: %s", streamCondition(&stanza))
+		case start.Name.Local == "message":
+			g.handleMessage(serveCtx, &stanza)
+		case start.Name.Local == "presence":
+			g.handlePresence(serveCtx, &stanza)
+		case start.Name.Local == "iq":
+			g.handleIQ(serveCtx, &stanza)
+		}
+	}
+}
+
+// handshake opens the component stream and authenticates with the shared secret
+func (g *Gateway) handshake(conn net.Conn, decoder *xml.Decoder) error {
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var domain strings.Builder
+	xml.EscapeText(&domain, []byte(g.config.Domain))
+	header := fmt.Sprintf(`<stream:stream xmlns="%s" xmlns:stream="%s" to="%s">`, nsComponent, nsStreams, domain.String())
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return err
+	}
+
+	var streamID string
+	for streamID == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "stream" {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "id" {
+				streamID = attr.Value
+			}
+		}
+		if streamID == "" {
+			return errors.New("stream header has no id")
+		}
+	}
+
+	digest := sha1.Sum([]byte(streamID + g.config.Secret))
+	data, err := xml.Marshal(&handshake{Digest: hex.EncodeToString(digest[:])})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "handshake" {
+			return decoder.Skip()
+		}
+		var stanza inboundStanza
+		decoder.DecodeElement(&stanza, &start)
+		return fmt.Errorf("handshake refused: %s", streamCondition(&stanza))
+	}
+}
+
+// streamCondition names the condition of a stream error
+func streamCondition(stanza *inboundStanza) string {
+	for _, child := range stanza.Children {
+		if child.XMLName.Local != "text" {
+			return child.XMLName.Local
+		}
+	}
+	return stanza.XMLName.Local
+}
+
+// send writes a stanza to the XMPP server
+func (g *Gateway) send(stanza interface{}) error {
+	data, err := xml.Marshal(stanza)
+	if err != nil {
+		return err
+	}
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	if g.conn == nil {
+		return errNotConnected
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = g.conn.Write(data)
+	return err
+}
+
+func (g *Gateway) handleMessage(ctx context.Context, stanza *inboundStanza) {
+	to := parseJID(stanza.To)
+	if to.local == "" || to.domain != g.config.Domain || stanza.Type == "error" {
+		return
+	}
+	body, state := stanza.child("body"), stanza.chatState()
+	if body == nil && state == "" {
+		return
+	}
+
+	sess, err := g.session(ctx, stanza.From)
+	if err != nil {
+		g.bounceMessage(stanza, err)
+		return
+	}
+	if stanza.Type == "groupchat" {
+		sess.sendToRoom(ctx, roomID(to.local), stanza, body, state)
+	} else {
+		sess.sendToContact(ctx, to.local, stanza, body, state)
+	}
+}
+
+// handlePresence handles joining and leaving rooms; presence subscriptions are not supported,
+// since contacts come from conversations
+func (g *Gateway) handlePresence(ctx context.Context, stanza *inboundStanza) {
+	to := parseJID(stanza.To)
+	if to.local == "" || to.domain != g.config.Domain || to.resource == "" {
+		return
+	}
+
+	switch stanza.Type {
+	case "":
+		sess, err := g.session(ctx, stanza.From)
+		if err != nil {
+			g.send(&presence{From: stanza.To, To: stanza.From, Type: "error", ID: stanza.ID, Error: errorFor(err)})
+			return
+		}
+		sess.join(ctx, roomID(to.local), to.resource, stanza)
+	case "unavailable":
+		g.sessionsMu.Lock()
+		sess := g.sessions[stanza.From]
+		g.sessionsMu.Unlock()
+		if sess != nil {
+			sess.leave(roomID(to.local))
+		}
+	}
+}
+
+// handleIQ answers service discovery, which clients use to find rooms, and refuses the rest
+func (g *Gateway) handleIQ(ctx context.Context, stanza *inboundStanza) {
+	if stanza.Type != "get" && stanza.Type != "set" {
+		return
+	}
+	reply := &iq{From: stanza.To, To: stanza.From, ID: stanza.ID, Type: "result"}
+	query := stanza.child("query")
+	if stanza.Type == "get" && query != nil && query.XMLName.Space == nsDiscoInfo {
+		to := parseJID(stanza.To)
+		info := &discoInfo{Identity: discoIdentity{Category: "conference", Type: "text", Name: "Chat"}}
+		switch {
+		case to.local == "":
+			info.Features = []discoFeature{{Var: nsDiscoInfo}, {Var: nsMUC}}
+		case to.resource == "":
+			if conversation, err := g.conversations.GetConversationByID(ctx, roomID(to.local)); err == nil {
+				info.Identity.Name = conversation.Title
+			}
+			info.Features = []discoFeature{{Var: nsDiscoInfo}, {Var: nsMUC}, {Var: "muc_membersonly"}, {Var: "muc_persistent"}, {Var: "muc_nonanonymous"}}
+		}
+		if info.Features != nil {
+			reply.Query = info
+			g.send(reply)
+			return
+		}
+	}
+	reply.Type = "error"
+	reply.Error = newStanzaError("cancel", "service-unavailable", "")
+	g.send(reply)
+}
+
+// session returns the session of an XMPP resource, starting one for its user if needed.
+// Users are found by their bare JID, which must be their email address.
+func (g *Gateway) session(ctx context.Context, fullJID string) (*session, error) {
+	g.sessionsMu.Lock()
+	sess := g.sessions[fullJID]
+	g.sessionsMu.Unlock()
+	if sess != nil {
+		sess.touch()
+		return sess, nil
+	}
+
+	user, err := g.users.GetUserByEmail(ctx, parseJID(fullJID).bare())
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, errUnregistered
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errDeactivated
+	}
+	if err := g.hub.CheckConnectionLimit(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	sess, err = newSession(ctx, g, fullJID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	g.sessionsMu.Lock()
+	g.sessions[fullJID] = sess
+	g.sessionsMu.Unlock()
+	go func() {
+		g.hub.ServeStream(ctx, sess.conn, user.ID, "", time.Time{}, "xmpp", "")
+		sess.Close()
+		g.sessionsMu.Lock()
+		if g.sessions[fullJID] == sess {
+			delete(g.sessions, fullJID)
+		}
+		g.sessionsMu.Unlock()
+	}()
+	sess.subscribeContacts()
+	return sess, nil
+}
+
+// sweepSessions ends sessions in no rooms that have been idle for sessionIdleTimeout
+func (g *Gateway) sweepSessions(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cutoff := g.clock.Now().Add(-sessionIdleTimeout)
+		g.sessionsMu.Lock()
+		var idle []*session
+		for _, sess := range g.sessions {
+			if sess.idleSince(cutoff) {
+				idle = append(idle, sess)
+			}
+		}
+		g.sessionsMu.Unlock()
+		for _, sess := range idle {
+			sess.Close()
+		}
+	}
+}
+
+// closeSessions ends every session once the component connection is gone
+func (g *Gateway) closeSessions() {
+	g.sessionsMu.Lock()
+	sessions := make([]*session, 0, len(g.sessions))
+	for _, sess := range g.sessions {
+		sessions = append(sessions, sess)
+	}
+	g.sessionsMu.Unlock()
+	for _, sess := range sessions {
+		sess.Close()
+	}
+}
+
+// nickname is a user's nick in rooms, from the profile a frame carried when it has one
+func (g *Gateway) nickname(ctx context.Context, userID string, profile *models.User) string {
+	if profile == nil {
+		var err error
+		if profile, err = g.users.GetUserProfile(ctx, userID); err != nil {
+			return userID
+		}
+	}
+	return nickname(profile)
+}
+
+// bounceMessage returns a message the gateway could not deliver to its sender
+func (g *Gateway) bounceMessage(stanza *inboundStanza, err error) {
+	g.send(&message{From: stanza.To, To: stanza.From, Type: "error", ID: stanza.ID, Error: errorFor(err)})
+}
+
+// roomID recovers a conversation ID from a room JID. XMPP servers lowercase localparts and
+// conversation IDs are uppercase ULIDs.
+func roomID(local string) string {
+	return strings.ToUpper(local)
+}
+
+func (g *Gateway) roomJID(conversationID string) string {
+	return strings.ToLower(conversationID) + "@" + g.config.Domain
+}
+
+// stanzaConditions maps service and hub error codes to stanza error types and conditions
+var stanzaConditions = map[string][2]string{
+	"NOT_FOUND":           {"cancel", "item-not-found"},
+	"FORBIDDEN":           {"auth", "forbidden"},
+	"CONFLICT":            {"cancel", "conflict"},
+	"VALIDATION":          {"modify", "bad-request"},
+	"INVALID_DATA":        {"modify", "bad-request"},
+	"RATE_LIMITED":        {"wait", "resource-constraint"},
+	"QUOTA_EXCEEDED":      {"cancel", "resource-constraint"},
+	"CONVERSATION_LOCKED": {"cancel", "not-allowed"},
+	"UPSTREAM":            {"wait", "remote-server-timeout"},
+	"UNAVAILABLE":         {"wait", "service-unavailable"},
+}
+
+// errorFor is a stanza error for a service error; internal errors are not described
+func errorFor(err error) *stanzaError {
+	if errors.Is(err, errUnregistered) {
+		return newStanzaError("auth", "registration-required", "No account uses this address as its email")
+	}
+	if errors.Is(err, errDeactivated) {
+		return newStanzaError("auth", "forbidden", "Account deactivated")
+	}
+	return codeError(services.ErrorCode(err, ""), services.PublicMessage(err, "Request failed"))
+}
+
+func codeError(code, text string) *stanzaError {
+	condition, ok := stanzaConditions[code]
+	if !ok {
+		condition = [2]string{"wait", "internal-server-error"}
+	}
+	return newStanzaError(condition[0], condition[1], text)
+}
@@ -0,0 +1,460 @@
+package xmppgw
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/internal/hubconn"
+	"github.com/JohnBPerkins/chat-service/backend/internal/models"
+	"github.com/JohnBPerkins/chat-service/backend/internal/services"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"nhooyr.io/websocket"
+)
+
+var (
+	errNotRoom  = &services.Error{Kind: services.ErrNotFound, Message: "only group conversations you are in can be joined"}
+	errSelfChat = &services.Error{Kind: services.ErrValidation, Message: "you cannot chat with yourself"}
+)
+
+// session is one XMPP resource taking part as its user. Its stanzas become frames on conn, and
+// the frames the hub writes to conn become stanzas.
+type session struct {
+	gw     *Gateway
+	jid    string // the resource's full JID
+	userID string
+	nick   string
+	conn   *hubconn.Conn
+
+	mu         sync.Mutex
+	rooms      map[string]bool   // conversation IDs joined as rooms
+	contacts   map[string]string // DM conversation ID -> the other user's username
+	dms        map[string]string // lowercased username -> DM conversation ID
+	pending    []*inboundStanza  // messages sent to the hub and not yet acknowledged, in order
+	lastActive time.Time
+}
+
+// newSession returns a session knowing the user's direct messages with people who have a
+// username, the only ones reachable over XMPP
+func newSession(ctx context.Context, g *Gateway, fullJID string, user *models.User) (*session, error) {
+	conversations, err := g.conversations.GetUserConversations(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		gw:         g,
+		jid:        fullJID,
+		userID:     user.ID,
+		nick:       nickname(user),
+		rooms:      make(map[string]bool),
+		contacts:   make(map[string]string),
+		dms:        make(map[string]string),
+		lastActive: g.clock.Now(),
+	}
+	s.conn = hubconn.New(g.clock, s.handleFrame, s.closed)
+	for _, conversation := range conversations {
+		if conversation.Kind != "dm" {
+			continue
+		}
+		for _, participant := range conversation.Participants {
+			if participant.ID != user.ID && participant.Username != "" {
+				s.contacts[conversation.ID] = participant.Username
+				s.dms[strings.ToLower(participant.Username)] = conversation.ID
+			}
+		}
+	}
+	return s, nil
+}
+
+// subscribeContacts subscribes to the direct messages known at the start, once the hub serves
+// the session
+func (s *session) subscribeContacts() {
+	s.mu.Lock()
+	conversationIDs := make([]string, 0, len(s.contacts))
+	for conversationID := range s.contacts {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	s.mu.Unlock()
+	for _, conversationID := range conversationIDs {
+		s.conn.Send("subscribe", &models.WSSubscribeData{ConversationID: conversationID})
+	}
+}
+
+// nickname is how a user appears in rooms
+func nickname(user *models.User) string {
+	switch {
+	case user.Username != "":
+		return user.Username
+	case user.Name != "":
+		return user.Name
+	default:
+		return user.ID
+	}
+}
+
+func (s *session) touch() {
+	s.mu.Lock()
+	s.lastActive = s.gw.clock.Now()
+	s.mu.Unlock()
+}
+
+// idleSince reports whether the session is in no rooms and has been quiet since cutoff
+func (s *session) idleSince(cutoff time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.rooms) == 0 && s.lastActive.Before(cutoff)
+}
+
+// addContact records a direct message and subscribes to it, unless it was known
+func (s *session) addContact(conversationID, username string) {
+	s.mu.Lock()
+	_, known := s.contacts[conversationID]
+	s.contacts[conversationID] = username
+	s.dms[strings.ToLower(username)] = conversationID
+	s.mu.Unlock()
+	if !known {
+		s.conn.Send("subscribe", &models.WSSubscribeData{ConversationID: conversationID})
+	}
+}
+
+// join enters a group conversation's room. The nick is always the user's own; clients are told
+// when it differs from the one they asked for.
+func (s *session) join(ctx context.Context, conversationID, requestedNick string, stanza *inboundStanza) {
+	conversation, err := s.gw.conversations.GetConversationByID(ctx, conversationID)
+	if err == nil && conversation.Kind != "group" {
+		err = errNotRoom
+	}
+	if err == nil {
+		var member bool
+		member, err = s.gw.conversations.IsUserParticipant(ctx, conversationID, s.userID)
+		if err == nil && !member {
+			err = errNotRoom
+		}
+	}
+	if err != nil {
+		s.gw.send(&presence{From: stanza.To, To: s.jid, Type: "error", ID: stanza.ID, Error: errorFor(err)})
+		return
+	}
+
+	s.mu.Lock()
+	joined := s.rooms[conversationID]
+	s.rooms[conversationID] = true
+	s.lastActive = s.gw.clock.Now()
+	s.mu.Unlock()
+	if !joined {
+		s.conn.Send("subscribe", &models.WSSubscribeData{ConversationID: conversationID})
+	}
+
+	// Occupants, then the user's own presence, then the subject (XEP-0045 §7.2)
+	for _, userID := range s.gw.hub.OnlineUsers(conversationID) {
+		if userID != s.userID {
+			s.sendOccupant(ctx, conversationID, userID, true)
+		}
+	}
+	self := &mucUser{
+		Item:     mucItem{Affiliation: "member", Role: "participant"},
+		Statuses: []mucStatus{{Code: statusSelfPresence}},
+	}
+	if requestedNick != s.nick {
+		self.Statuses = append(self.Statuses, mucStatus{Code: statusNickAssigned})
+	}
+	room := s.gw.roomJID(conversationID)
+	s.gw.send(&presence{From: room + "/" + s.nick, To: s.jid, ID: stanza.ID, MUCUser: self})
+	s.gw.send(&message{From: room, To: s.jid, Type: "groupchat", Subject: &conversation.Title})
+}
+
+// leave exits a room, confirming with the user's own unavailable presence
+func (s *session) leave(conversationID string) {
+	s.mu.Lock()
+	joined := s.rooms[conversationID]
+	delete(s.rooms, conversationID)
+	s.mu.Unlock()
+	if joined {
+		s.conn.Send("unsubscribe", &models.WSSubscribeData{ConversationID: conversationID})
+	}
+	s.sendSelfUnavailable(conversationID)
+}
+
+func (s *session) sendSelfUnavailable(conversationID string) {
+	s.gw.send(&presence{
+		From: s.gw.roomJID(conversationID) + "/" + s.nick,
+		To:   s.jid,
+		Type: "unavailable",
+		MUCUser: &mucUser{
+			Item:     mucItem{Affiliation: "member", Role: "none"},
+			Statuses: []mucStatus{{Code: statusSelfPresence}},
+		},
+	})
+}
+
+// sendToRoom posts a groupchat message, or passes on a chat state, in a joined room
+func (s *session) sendToRoom(ctx context.Context, conversationID string, stanza *inboundStanza, body *element, state string) {
+	s.mu.Lock()
+	joined := s.rooms[conversationID]
+	s.mu.Unlock()
+	if !joined {
+		s.gw.send(&message{From: stanza.To, To: s.jid, Type: "error", ID: stanza.ID,
+			Error: newStanzaError("modify", "not-acceptable", "Join the room first")})
+		return
+	}
+	s.sendMessageOrState(conversationID, stanza, body, state)
+}
+
+// sendToContact sends a 1:1 chat to the direct message with a user, starting one if needed
+func (s *session) sendToContact(ctx context.Context, username string, stanza *inboundStanza, body *element, state string) {
+	s.mu.Lock()
+	conversationID := s.dms[strings.ToLower(username)]
+	s.mu.Unlock()
+	if conversationID == "" {
+		if body == nil {
+			return // a chat state to someone there is no conversation with yet
+		}
+		contact, err := s.gw.users.GetUserByUsername(ctx, username)
+		if err == nil && contact.ID == s.userID {
+			err = errSelfChat
+		}
+		var conversation *models.Conversation
+		if err == nil {
+			conversation, err = s.gw.conversations.CreateConversation(ctx, &models.CreateConversationRequest{
+				Kind:    "dm",
+				Members: []string{contact.ID},
+			}, s.userID)
+		}
+		if err != nil {
+			s.gw.bounceMessage(stanza, err)
+			return
+		}
+		conversationID = conversation.ID
+		s.addContact(conversationID, contact.Username)
+	}
+	s.sendMessageOrState(conversationID, stanza, body, state)
+}
+
+func (s *session) sendMessageOrState(conversationID string, stanza *inboundStanza, body *element, state string) {
+	if body != nil && body.Text != "" {
+		clientMsgID := stanza.ID
+		if clientMsgID == "" || len(clientMsgID) > 100 {
+			clientMsgID = strconv.FormatInt(s.gw.clock.Now().UnixNano(), 36)
+		}
+		s.mu.Lock()
+		s.pending = append(s.pending, stanza)
+		s.mu.Unlock()
+		s.conn.Send("message.send", &models.WSMessageSendData{
+			ConversationID: conversationID,
+			ClientMsgID:    "xmpp:" + clientMsgID,
+			Body:           body.Text,
+		})
+		return
+	}
+	if state != "" {
+		s.conn.Send("typing.update", &models.WSTypingUpdateData{
+			ConversationID: conversationID,
+			IsTyping:       state == "composing",
+		})
+	}
+}
+
+// messageNew is the part of a message.new frame stanzas need. Message IDs beyond 2^53 arrive
+// as strings, which json.Number takes as well as numbers.
+type messageNew struct {
+	ID             json.Number  `json:"id"`
+	ConversationID string       `json:"conversationId"`
+	SenderID       string       `json:"senderId"`
+	Body           string       `json:"body"`
+	Sender         *models.User `json:"sender"`
+}
+
+// handleFrame turns a frame from the hub into stanzas. Frames with no XMPP counterpart are
+// dropped.
+func (s *session) handleFrame(ctx context.Context, frameType string, data json.RawMessage) {
+	var err error
+	switch frameType {
+	case "message.new":
+		var m messageNew
+		if err = json.Unmarshal(data, &m); err == nil {
+			s.deliverMessage(ctx, &m)
+		}
+	case "message.ack":
+		s.popPending()
+	case "typing.update":
+		var typing models.WSTypingUpdateEventData
+		if err = json.Unmarshal(data, &typing); err == nil {
+			s.deliverTyping(ctx, &typing)
+		}
+	case "presence.update":
+		var update models.WSPresenceUpdateData
+		if err = json.Unmarshal(data, &update); err == nil && s.inRoom(update.ConversationID) {
+			s.sendOccupant(ctx, update.ConversationID, update.UserID, update.Status == "online")
+		}
+	case "presence.snapshot", "presence.delta":
+		var delta models.WSPresenceDeltaData
+		if err = json.Unmarshal(data, &delta); err == nil && s.inRoom(delta.ConversationID) {
+			for _, userID := range delta.Online {
+				s.sendOccupant(ctx, delta.ConversationID, userID, true)
+			}
+			for _, userID := range delta.Offline {
+				s.sendOccupant(ctx, delta.ConversationID, userID, false)
+			}
+		}
+	case models.ConversationCreated, models.ConversationDeleted, models.MemberRemoved:
+		var event models.WSConversationEventData
+		if err = json.Unmarshal(data, &event); err == nil {
+			s.conversationEvent(ctx, frameType, &event)
+		}
+	case "error":
+		var failure models.WSErrorData
+		if err = json.Unmarshal(data, &failure); err == nil {
+			s.failed(&failure)
+		}
+	}
+	if err != nil {
+		s.gw.logger.Warn("Failed to translate frame for XMPP", "frame", frameType, logging.UserID, s.userID, logging.Err(err))
+	}
+}
+
+func (s *session) inRoom(conversationID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[conversationID]
+}
+
+// deliverMessage sends a new message to the room it was posted in, echoing the user's own as
+// rooms do, or as a 1:1 chat from the other person in a direct message
+func (s *session) deliverMessage(ctx context.Context, m *messageNew) {
+	s.mu.Lock()
+	inRoom := s.rooms[m.ConversationID]
+	contact := s.contacts[m.ConversationID]
+	s.mu.Unlock()
+
+	id := "msg-" + m.ID.String()
+	switch {
+	case inRoom:
+		nick := s.gw.nickname(ctx, m.SenderID, m.Sender)
+		s.gw.send(&message{From: s.gw.roomJID(m.ConversationID) + "/" + nick, To: s.jid, Type: "groupchat", ID: id, Body: m.Body})
+	case contact != "" && m.SenderID != s.userID:
+		s.gw.send(&message{From: contact + "@" + s.gw.config.Domain, To: s.jid, Type: "chat", ID: id, Body: m.Body, ChatState: newChatState("active")})
+	}
+}
+
+func (s *session) deliverTyping(ctx context.Context, typing *models.WSTypingUpdateEventData) {
+	if typing.UserID == s.userID {
+		return
+	}
+	state := newChatState("paused")
+	if typing.IsTyping {
+		state = newChatState("composing")
+	}
+
+	s.mu.Lock()
+	inRoom := s.rooms[typing.ConversationID]
+	contact := s.contacts[typing.ConversationID]
+	s.mu.Unlock()
+	switch {
+	case inRoom:
+		nick := s.gw.nickname(ctx, typing.UserID, nil)
+		s.gw.send(&message{From: s.gw.roomJID(typing.ConversationID) + "/" + nick, To: s.jid, Type: "groupchat", ChatState: state})
+	case contact != "":
+		s.gw.send(&message{From: contact + "@" + s.gw.config.Domain, To: s.jid, Type: "chat", ChatState: state})
+	}
+}
+
+// sendOccupant announces another user joining or leaving a room
+func (s *session) sendOccupant(ctx context.Context, conversationID, userID string, online bool) {
+	if userID == s.userID {
+		return
+	}
+	p := &presence{
+		From:    s.gw.roomJID(conversationID) + "/" + s.gw.nickname(ctx, userID, nil),
+		To:      s.jid,
+		MUCUser: &mucUser{Item: mucItem{Affiliation: "member", Role: "participant"}},
+	}
+	if !online {
+		p.Type = "unavailable"
+		p.MUCUser.Item.Role = "none"
+	}
+	s.gw.send(p)
+}
+
+// conversationEvent follows new direct messages and drops conversations the user lost
+func (s *session) conversationEvent(ctx context.Context, event string, data *models.WSConversationEventData) {
+	switch event {
+	case models.ConversationCreated:
+		if data.Conversation == nil || data.Conversation.Kind != "dm" {
+			return
+		}
+		for _, userID := range data.UserIDs {
+			if userID == s.userID {
+				continue
+			}
+			if contact, err := s.gw.users.GetUserProfile(ctx, userID); err == nil && contact.Username != "" {
+				s.addContact(data.ConversationID, contact.Username)
+			}
+		}
+		return
+	case models.MemberRemoved:
+		removed := false
+		for _, userID := range data.UserIDs {
+			removed = removed || userID == s.userID
+		}
+		if !removed {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	joined := s.rooms[data.ConversationID]
+	delete(s.rooms, data.ConversationID)
+	if username, ok := s.contacts[data.ConversationID]; ok {
+		delete(s.contacts, data.ConversationID)
+		delete(s.dms, strings.ToLower(username))
+	}
+	s.mu.Unlock()
+	if joined {
+		s.sendSelfUnavailable(data.ConversationID)
+	}
+}
+
+func (s *session) popPending() *inboundStanza {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	stanza := s.pending[0]
+	s.pending = s.pending[1:]
+	return stanza
+}
+
+// failed returns an error to the message it most likely answers. The hub handles a
+// connection's frames in order and answers each message with an ack or an error, so that is
+// the oldest one not yet acknowledged.
+func (s *session) failed(failure *models.WSErrorData) {
+	stanza := s.popPending()
+	if stanza == nil {
+		return
+	}
+	s.gw.send(&message{From: stanza.To, To: s.jid, Type: "error", ID: stanza.ID, Error: codeError(failure.Code, failure.Detail)})
+}
+
+// Close ends the session
+func (s *session) Close() {
+	s.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// closed tells the resource it has left its rooms
+func (s *session) closed() {
+	s.mu.Lock()
+	rooms := make([]string, 0, len(s.rooms))
+	for conversationID := range s.rooms {
+		rooms = append(rooms, conversationID)
+	}
+	s.rooms = make(map[string]bool)
+	s.mu.Unlock()
+	for _, conversationID := range rooms {
+		s.sendSelfUnavailable(conversationID)
+	}
+}
@@ -0,0 +1,185 @@
+package xmppgw
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Namespaces of the protocols the gateway speaks
+const (
+	nsComponent  = "jabber:component:accept"
+	nsStreams    = "http://etherx.jabber.org/streams"
+	nsMUC        = "http://jabber.org/protocol/muc"
+	nsChatStates = "http://jabber.org/protocol/chatstates"
+	nsDiscoInfo  = "http://jabber.org/protocol/disco#info"
+	nsStanzas    = "urn:ietf:params:xml:ns:xmpp-stanzas"
+)
+
+// element is any child element, kept by name and text for inbound stanzas
+type element struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+}
+
+// inboundStanza is a message or presence routed to the gateway's domain
+type inboundStanza struct {
+	XMLName  xml.Name
+	From     string    `xml:"from,attr"`
+	To       string    `xml:"to,attr"`
+	Type     string    `xml:"type,attr"`
+	ID       string    `xml:"id,attr"`
+	Children []element `xml:",any"`
+}
+
+// child returns the first child element with the given local name, in any namespace
+func (s *inboundStanza) child(local string) *element {
+	for i := range s.Children {
+		if s.Children[i].XMLName.Local == local {
+			return &s.Children[i]
+		}
+	}
+	return nil
+}
+
+// chatState returns the XEP-0085 state the stanza carries, or ""
+func (s *inboundStanza) chatState() string {
+	for _, child := range s.Children {
+		if child.XMLName.Space == nsChatStates {
+			return child.XMLName.Local
+		}
+	}
+	return ""
+}
+
+type message struct {
+	XMLName   xml.Name     `xml:"message"`
+	From      string       `xml:"from,attr"`
+	To        string       `xml:"to,attr"`
+	Type      string       `xml:"type,attr,omitempty"`
+	ID        string       `xml:"id,attr,omitempty"`
+	Subject   *string      `xml:"subject"`
+	Body      string       `xml:"body,omitempty"`
+	ChatState *chatState   `xml:",omitempty"`
+	Error     *stanzaError `xml:",omitempty"`
+}
+
+// chatState is a XEP-0085 element such as <composing/>; its name is the state
+type chatState struct {
+	XMLName xml.Name
+}
+
+func newChatState(state string) *chatState {
+	return &chatState{XMLName: xml.Name{Space: nsChatStates, Local: state}}
+}
+
+type presence struct {
+	XMLName xml.Name     `xml:"presence"`
+	From    string       `xml:"from,attr"`
+	To      string       `xml:"to,attr"`
+	Type    string       `xml:"type,attr,omitempty"`
+	ID      string       `xml:"id,attr,omitempty"`
+	MUCUser *mucUser     `xml:",omitempty"`
+	Error   *stanzaError `xml:",omitempty"`
+}
+
+// mucUser describes an occupant in room presence
+type mucUser struct {
+	XMLName  xml.Name    `xml:"http://jabber.org/protocol/muc#user x"`
+	Item     mucItem     `xml:"item"`
+	Statuses []mucStatus `xml:"status"`
+}
+
+type mucItem struct {
+	Affiliation string `xml:"affiliation,attr"`
+	Role        string `xml:"role,attr"`
+}
+
+type mucStatus struct {
+	Code int `xml:"code,attr"`
+}
+
+// MUC status codes (XEP-0045 §15.6)
+const (
+	statusSelfPresence = 110
+	statusNickAssigned = 210
+)
+
+// stanzaError is an RFC 6120 stanza error with one defined condition
+type stanzaError struct {
+	XMLName   xml.Name `xml:"error"`
+	Type      string   `xml:"type,attr"`
+	Condition element
+	Text      *errorText `xml:",omitempty"`
+}
+
+type errorText struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-stanzas text"`
+	Text    string   `xml:",chardata"`
+}
+
+func newStanzaError(errorType, condition, text string) *stanzaError {
+	e := &stanzaError{
+		Type:      errorType,
+		Condition: element{XMLName: xml.Name{Space: nsStanzas, Local: condition}},
+	}
+	if text != "" {
+		e.Text = &errorText{Text: text}
+	}
+	return e
+}
+
+type iq struct {
+	XMLName xml.Name     `xml:"iq"`
+	From    string       `xml:"from,attr"`
+	To      string       `xml:"to,attr"`
+	Type    string       `xml:"type,attr"`
+	ID      string       `xml:"id,attr"`
+	Query   *discoInfo   `xml:",omitempty"`
+	Error   *stanzaError `xml:",omitempty"`
+}
+
+// discoInfo answers a service discovery (XEP-0030) info query
+type discoInfo struct {
+	XMLName  xml.Name       `xml:"http://jabber.org/protocol/disco#info query"`
+	Identity discoIdentity  `xml:"identity"`
+	Features []discoFeature `xml:"feature"`
+}
+
+type discoIdentity struct {
+	Category string `xml:"category,attr"`
+	Type     string `xml:"type,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+}
+
+type discoFeature struct {
+	Var string `xml:"var,attr"`
+}
+
+type handshake struct {
+	XMLName xml.Name `xml:"handshake"`
+	Digest  string   `xml:",chardata"`
+}
+
+// jid is a parsed Jabber ID, local@domain/resource
+type jid struct {
+	local, domain, resource string
+}
+
+func parseJID(s string) jid {
+	var j jid
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s, j.resource = s[:i], s[i+1:]
+	}
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		j.local, s = s[:i], s[i+1:]
+	}
+	j.domain = s
+	return j
+}
+
+func (j jid) bare() string {
+	if j.local == "" {
+		return j.domain
+	}
+	return j.local + "@" + j.domain
+}
@@ -0,0 +1,164 @@
+// WebSocket frames in binary form, for clients that connect with the "chat.v1.proto"
+// subprotocol. Each WebSocket binary message carries one Frame. Payloads keep the shape of
+// the JSON protocol (see DESIGN.md, WebSocket protocol), so field names and values in data
+// are exactly those of the JSON frame's "data".
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: ws.proto
+
+package chatpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Frame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Frame type, e.g. "message.new" or "subscribe"
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// Milliseconds since the Unix epoch
+	Ts int64 `protobuf:"varint,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	// The JSON frame's "data". Numbers are doubles, except integers beyond 2^53 (such as
+	// message IDs), which are decimal strings as in the proto3 JSON mapping of int64.
+	Data *structpb.Value `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// Correlates a client frame with the server's reply; the server assigns one when absent
+	RequestId     string `protobuf:"bytes,4,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Frame) Reset() {
+	*x = Frame{}
+	mi := &file_ws_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frame) ProtoMessage() {}
+
+func (x *Frame) ProtoReflect() protoreflect.Message {
+	mi := &file_ws_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frame.ProtoReflect.Descriptor instead.
+func (*Frame) Descriptor() ([]byte, []int) {
+	return file_ws_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Frame) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Frame) GetTs() int64 {
+	if x != nil {
+		return x.Ts
+	}
+	return 0
+}
+
+func (x *Frame) GetData() *structpb.Value {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Frame) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+var File_ws_proto protoreflect.FileDescriptor
+
+const file_ws_proto_rawDesc = "" +
+	"\n" +
+	"\bws.proto\x12\n" +
+	"chat.ws.v1\x1a\x1cgoogle/protobuf/struct.proto\"v\n" +
+	"\x05Frame\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
+	"\x02ts\x18\x02 \x01(\x03R\x02ts\x12*\n" +
+	"\x04data\x18\x03 \x01(\v2\x16.google.protobuf.ValueR\x04data\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x04 \x01(\tR\trequestIdB9Z7github.com/JohnBPerkins/chat-service/backend/pkg/chatpbb\x06proto3"
+
+var (
+	file_ws_proto_rawDescOnce sync.Once
+	file_ws_proto_rawDescData []byte
+)
+
+func file_ws_proto_rawDescGZIP() []byte {
+	file_ws_proto_rawDescOnce.Do(func() {
+		file_ws_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ws_proto_rawDesc), len(file_ws_proto_rawDesc)))
+	})
+	return file_ws_proto_rawDescData
+}
+
+var file_ws_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_ws_proto_goTypes = []any{
+	(*Frame)(nil),          // 0: chat.ws.v1.Frame
+	(*structpb.Value)(nil), // 1: google.protobuf.Value
+}
+var file_ws_proto_depIdxs = []int32{
+	1, // 0: chat.ws.v1.Frame.data:type_name -> google.protobuf.Value
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_ws_proto_init() }
+func file_ws_proto_init() {
+	if File_ws_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ws_proto_rawDesc), len(file_ws_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_ws_proto_goTypes,
+		DependencyIndexes: file_ws_proto_depIdxs,
+		MessageInfos:      file_ws_proto_msgTypes,
+	}.Build()
+	File_ws_proto = out.File
+	file_ws_proto_goTypes = nil
+	file_ws_proto_depIdxs = nil
+}
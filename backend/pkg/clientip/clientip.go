@@ -0,0 +1,21 @@
+// Package clientip carries the address of the client behind a request through contexts, so
+// services can act on it without depending on the HTTP layer that worked it out.
+package clientip
+
+import (
+	"context"
+	"net/netip"
+)
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, contextKey{}, addr)
+}
+
+// FromContext returns the client address, invalid when the context has none, as outside
+// HTTP requests
+func FromContext(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(contextKey{}).(netip.Addr)
+	return addr
+}
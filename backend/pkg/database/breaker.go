@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+)
+
+// ErrUnavailable is returned without touching MongoDB while the circuit breaker is open
+var ErrUnavailable = errors.New("database unavailable")
+
+// Breaker opens after a run of consecutive transient failures and rejects work until the
+// cooldown has passed. It then lets a single trial through: success closes it again, failure
+// re-opens it for another cooldown.
+type Breaker struct {
+	clock     clock.Clock
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	trial    bool      // a half-open trial is in flight
+}
+
+// NewBreaker returns a closed breaker; a threshold of 0 disables it
+func NewBreaker(clk clock.Clock, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		clock:     clk,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns ErrUnavailable while the breaker is open
+func (b *Breaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.trial || b.clock.Now().Sub(b.openedAt) < b.cooldown {
+		return ErrUnavailable
+	}
+	b.trial = true
+	return nil
+}
+
+// RetryAfter returns how long until the breaker next lets a trial through; zero when closed
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0
+	}
+	if wait := b.cooldown - b.clock.Now().Sub(b.openedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Success records a healthy round trip and closes the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+// Failure records a transient failure, opening the breaker at the threshold
+func (b *Breaker) Failure() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.trial = false
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -12,13 +14,28 @@ import (
 type MongoDB struct {
 	Client *mongo.Client
 	DB     *mongo.Database
+
+	policy  Policy
+	breaker *Breaker
+	tenants TenantResolver
 }
 
-func NewMongoDB(uri, dbName string) (*MongoDB, error) {
+func NewMongoDB(uri, dbName string, policy Policy, clk clock.Clock) (*MongoDB, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	breaker := NewBreaker(clk, policy.BreakerThreshold, policy.BreakerCooldown)
+	clientOptions := options.Client().ApplyURI(uri).
+		// Heartbeats notice an outage even when no request has failed yet
+		SetServerMonitor(&event.ServerMonitor{
+			ServerHeartbeatSucceeded: func(*event.ServerHeartbeatSucceededEvent) { breaker.Success() },
+			ServerHeartbeatFailed:    func(*event.ServerHeartbeatFailedEvent) { breaker.Failure() },
+		})
+	if policy.OpTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(policy.OpTimeout)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -37,8 +54,10 @@ func NewMongoDB(uri, dbName string) (*MongoDB, error) {
 	// }
 
 	return &MongoDB{
-		Client: client,
-		DB:     db,
+		Client:  client,
+		DB:      db,
+		policy:  policy,
+		breaker: breaker,
 	}, nil
 }
 
@@ -48,6 +67,10 @@ func (m *MongoDB) Close() error {
 	return m.Client.Disconnect(ctx)
 }
 
+// UsernameCollation compares usernames ignoring case. Queries on users.username must use it
+// to match the unique index.
+var UsernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 func createIndexes(ctx context.Context, db *mongo.Database) error {
 	// Users collection indexes
 	usersCollection := db.Collection("users")
@@ -59,6 +82,26 @@ func createIndexes(ctx context.Context, db *mongo.Database) error {
 		return err
 	}
 
+	// Handles are unique ignoring case; users without one are left out of the index
+	_, err = usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetCollation(UsernameCollation).
+			SetPartialFilterExpression(bson.M{"username": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Member checks and purges find a workspace's users
+	_, err = usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspaceId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
 	// Conversations collection indexes
 	conversationsCollection := db.Collection("conversations")
 	_, err = conversationsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
@@ -68,6 +111,13 @@ func createIndexes(ctx context.Context, db *mongo.Database) error {
 		return err
 	}
 
+	_, err = conversationsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspaceId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
 	// Participants collection indexes
 	participantsCollection := db.Collection("participants")
 
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Policy bounds how long MongoDB work may take and how failures are handled
+type Policy struct {
+	// OpTimeout caps each attempt made through Do, and server selection for every operation,
+	// so requests fail quickly instead of hanging while MongoDB is unreachable
+	OpTimeout time.Duration
+
+	// Transient failures are retried this many times, waiting RetryBackoff, then twice that, ...
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// BreakerThreshold consecutive transient failures open the circuit breaker for
+	// BreakerCooldown; a threshold of 0 disables it
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// IsTransient reports whether err is a network failure or timeout that may clear on retry.
+// Query and write errors such as duplicate keys are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return false
+	}
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && (labeled.HasErrorLabel("TransientTransactionError") || labeled.HasErrorLabel("RetryableWriteError")) {
+		return true
+	}
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
+
+// Do runs op with the policy's per-attempt timeout, retrying transient failures with
+// exponential backoff. While the circuit breaker is open it returns ErrUnavailable at once.
+// op may run more than once, so it must be safe to repeat.
+func (m *MongoDB) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	backoff := m.policy.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err := m.breaker.Allow(); err != nil {
+			return err
+		}
+
+		err := m.attempt(ctx, op)
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about MongoDB's health
+			return err
+		}
+		if !IsTransient(err) {
+			m.breaker.Success()
+			return err
+		}
+		m.breaker.Failure()
+		if attempt >= m.policy.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (m *MongoDB) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if m.policy.OpTimeout <= 0 {
+		return op(ctx)
+	}
+	opCtx, cancel := context.WithTimeout(ctx, m.policy.OpTimeout)
+	defer cancel()
+	return op(opCtx)
+}
+
+// Available returns ErrUnavailable while the circuit breaker is open and cooling down. Once
+// the cooldown has passed work is let through again, and Do picks the trial attempt.
+func (m *MongoDB) Available() error {
+	if m.breaker.RetryAfter() > 0 {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// RetryAfter returns how long until an open breaker next tries MongoDB
+func (m *MongoDB) RetryAfter() time.Duration {
+	return m.breaker.RetryAfter()
+}
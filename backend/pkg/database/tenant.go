@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tenant isolation modes, selected with TENANT_ISOLATION
+const (
+	TenantsShared   = "shared"   // every workspace in one database, told apart by workspaceId
+	TenantsDatabase = "database" // each workspace in a database of its own
+	TenantsPrefix   = "prefix"   // each workspace in its own collections, named "<workspace>.<collection>"
+)
+
+// sharedCollections describe the deployment rather than any one workspace's data, and stay in
+// the configured database whatever the mode: the workspace directory, API keys (looked up by
+// hash before the workspace is known), the journal's and stream's bookkeeping, usage metering,
+// the IP ban list and CAPTCHA challenges, which are kept by address as well as by user.
+var sharedCollections = map[string]bool{
+	"workspaces":       true,
+	"api_keys":         true,
+	"journal_gaps":     true,
+	"stream_reconfigs": true,
+	"link_previews":    true, // a cache of public pages, not anyone's messages
+	"usage":            true,
+	"ip_bans":          true,
+	"challenges":       true,
+}
+
+// TenantResolver picks the collection holding a workspace's documents. The default workspace's
+// always resolve to the configured database, so a deployment can move to an isolated mode
+// without migrating it.
+type TenantResolver interface {
+	Collection(workspaceID, name string) *mongo.Collection
+}
+
+type sharedTenants struct {
+	db *mongo.Database
+}
+
+func (t sharedTenants) Collection(_, name string) *mongo.Collection {
+	return t.db.Collection(name)
+}
+
+type databaseTenants struct {
+	db     *mongo.Database
+	prefix string
+}
+
+func (t databaseTenants) Collection(workspaceID, name string) *mongo.Collection {
+	if workspaceID == "" || workspaceID == tenant.Default {
+		return t.db.Collection(name)
+	}
+	return t.db.Client().Database(t.prefix + workspaceID).Collection(name)
+}
+
+type prefixTenants struct {
+	db *mongo.Database
+}
+
+func (t prefixTenants) Collection(workspaceID, name string) *mongo.Collection {
+	if workspaceID == "" || workspaceID == tenant.Default {
+		return t.db.Collection(name)
+	}
+	return t.db.Collection(workspaceID + "." + name)
+}
+
+// NewTenantResolver returns the resolver for mode. In database mode a workspace's database is
+// named prefix followed by its ID.
+func NewTenantResolver(mode string, db *mongo.Database, prefix string) (TenantResolver, error) {
+	switch mode {
+	case TenantsShared, "":
+		return sharedTenants{db: db}, nil
+	case TenantsDatabase:
+		return databaseTenants{db: db, prefix: prefix}, nil
+	case TenantsPrefix:
+		return prefixTenants{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown tenant isolation mode %q", mode)
+	}
+}
+
+// SetTenantResolver routes workspace collections through r; before it is called, every
+// workspace shares the configured database
+func (m *MongoDB) SetTenantResolver(r TenantResolver) {
+	m.tenants = r
+}
+
+// Isolated reports whether workspaces are kept in separate databases or collections, so that
+// work spanning every workspace must visit each of them
+func (m *MongoDB) Isolated() bool {
+	_, shared := m.tenants.(sharedTenants)
+	return m.tenants != nil && !shared
+}
+
+// Collection returns the named collection of the workspace in ctx (see package tenant). Only
+// deployment-wide collections ignore it. Aggregation stages naming another collection, such as
+// $lookup, must use the Name of the collection this returns.
+func (m *MongoDB) Collection(ctx context.Context, name string) *mongo.Collection {
+	if m.tenants == nil || sharedCollections[name] {
+		return m.DB.Collection(name)
+	}
+	return m.tenants.Collection(tenant.FromContext(ctx), name)
+}
+
+// EachTenant runs fn once per workspace, with the workspace in its context, for background
+// work such as sweeps. When workspaces share a database it runs fn once for all of them. A
+// workspace that fails does not hold up the others; their errors are returned together.
+func (m *MongoDB) EachTenant(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !m.Isolated() {
+		return fn(ctx)
+	}
+
+	cursor, err := m.DB.Collection("workspaces").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	var workspaces []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &workspaces); err != nil {
+		return fmt.Errorf("failed to decode workspaces: %w", err)
+	}
+	var errs []error
+	for _, workspace := range workspaces {
+		if err := fn(tenant.NewContext(ctx, workspace.ID)); err != nil {
+			errs = append(errs, fmt.Errorf("workspace %s: %w", workspace.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,9 @@
+// Package id generates the identifiers used for stored documents: snowflakes for messages,
+// ULIDs for everything else.
+package id
+
+// Participant returns the ID of a user's participant document in a conversation. It derives
+// from the conversation ID, so it is unique wherever that is.
+func Participant(conversationID, userID string) string {
+	return conversationID + ":" + userID
+}
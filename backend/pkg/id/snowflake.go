@@ -0,0 +1,77 @@
+package id
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+)
+
+// Snowflake IDs are laid out as | 41 bits ms since Epoch | 5 bits node | 7 bits sequence |.
+// The layout is narrower than Twitter's 63 bits so IDs stay within 2^53 and survive JSON
+// clients that parse numbers as doubles; that still allows 32 nodes issuing 128 IDs per
+// millisecond each, for about 69 years from Epoch.
+const (
+	NodeBits     = 5
+	SequenceBits = 7
+	MaxNode      = 1<<NodeBits - 1
+
+	maxSequence = 1<<SequenceBits - 1
+	nodeShift   = SequenceBits
+	timeShift   = SequenceBits + NodeBits
+)
+
+// Epoch is the zero point of snowflake timestamps
+var Epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates unique, time-ordered int64 IDs for one node. IDs from a single
+// generator strictly increase, even if the clock stalls or steps backwards.
+type Snowflake struct {
+	clock  clock.Clock
+	nodeID int64
+
+	mu       sync.Mutex
+	lastTime int64 // ms since Epoch of the last ID
+	sequence int64
+}
+
+// NewSnowflake returns a generator for nodeID, which must be unique among running instances
+func NewSnowflake(clk clock.Clock, nodeID int64) (*Snowflake, error) {
+	if nodeID < 0 || nodeID > MaxNode {
+		return nil, fmt.Errorf("snowflake node ID must be between 0 and %d, got %d", MaxNode, nodeID)
+	}
+
+	return &Snowflake{
+		clock:    clk,
+		nodeID:   nodeID,
+		lastTime: -1,
+	}, nil
+}
+
+// Next returns the next ID
+func (s *Snowflake) Next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now().Sub(Epoch).Milliseconds()
+	if now > s.lastTime {
+		s.lastTime = now
+		s.sequence = 0
+	} else {
+		// Same millisecond, or the clock went backwards: keep counting from the last
+		// timestamp, borrowing the next millisecond once the sequence runs out
+		s.sequence++
+		if s.sequence > maxSequence {
+			s.lastTime++
+			s.sequence = 0
+		}
+	}
+
+	return s.lastTime<<timeShift | s.nodeID<<nodeShift | s.sequence
+}
+
+// Time returns when an ID was generated, to millisecond precision
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>timeShift) * time.Millisecond)
+}
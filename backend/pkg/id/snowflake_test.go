@@ -0,0 +1,131 @@
+package id
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+)
+
+func newTestSnowflake(t *testing.T, clk clock.Clock) *Snowflake {
+	t.Helper()
+	s, err := NewSnowflake(clk, 3)
+	if err != nil {
+		t.Fatalf("NewSnowflake: %v", err)
+	}
+	return s
+}
+
+func TestSnowflakeConcurrentUnique(t *testing.T) {
+	clk := clock.NewFake(Epoch.Add(time.Hour))
+	s := newTestSnowflake(t, clk)
+
+	const workers, perWorker = 8, 1000
+	ids := make(chan int64, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if i%100 == 0 {
+					clk.Advance(time.Millisecond)
+				}
+				ids <- s.Next()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int64]bool, workers*perWorker)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("ID %d issued twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("got %d IDs, want %d", len(seen), workers*perWorker)
+	}
+}
+
+func TestSnowflakeMonotonic(t *testing.T) {
+	start := Epoch.Add(time.Hour)
+	tests := []struct {
+		name string
+		step func(clk *clock.Fake, i int)
+	}{
+		{"advancing", func(clk *clock.Fake, i int) { clk.Advance(time.Millisecond) }},
+		{"stalled", func(clk *clock.Fake, i int) {}},
+		{"stepping backwards", func(clk *clock.Fake, i int) { clk.Advance(-time.Second) }},
+		{"jumping back and forth", func(clk *clock.Fake, i int) {
+			if i%2 == 0 {
+				clk.Set(start.Add(-time.Minute))
+			} else {
+				clk.Set(start)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			s := newTestSnowflake(t, clk)
+
+			last := s.Next()
+			for i := 0; i < 1000; i++ {
+				tt.step(clk, i)
+				next := s.Next()
+				if next <= last {
+					t.Fatalf("ID %d after %d is not greater", next, last)
+				}
+				last = next
+			}
+		})
+	}
+}
+
+func TestSnowflakeSequenceOverflow(t *testing.T) {
+	start := Epoch.Add(time.Hour)
+	clk := clock.NewFake(start)
+	s := newTestSnowflake(t, clk)
+
+	for i := 0; i <= maxSequence; i++ {
+		id := s.Next()
+		if got := Time(id); !got.Equal(start) {
+			t.Fatalf("ID %d of the first millisecond is stamped %v, want %v", i, got, start)
+		}
+		if seq := id & maxSequence; seq != int64(i) {
+			t.Fatalf("ID %d has sequence %d", i, seq)
+		}
+	}
+
+	// The sequence has run out, so the next ID borrows the following millisecond
+	id := s.Next()
+	if got, want := Time(id), start.Add(time.Millisecond); !got.Equal(want) {
+		t.Fatalf("overflowing ID is stamped %v, want %v", got, want)
+	}
+	if seq := id & maxSequence; seq != 0 {
+		t.Fatalf("overflowing ID has sequence %d, want 0", seq)
+	}
+
+	// Once the clock catches up with the borrowed millisecond, IDs keep increasing
+	clk.Advance(time.Millisecond)
+	if next := s.Next(); next <= id {
+		t.Fatalf("ID %d after %d is not greater", next, id)
+	}
+}
+
+func TestSnowflakeNode(t *testing.T) {
+	s := newTestSnowflake(t, clock.NewFake(Epoch.Add(time.Hour)))
+	if node := s.Next() >> nodeShift & MaxNode; node != 3 {
+		t.Fatalf("ID carries node %d, want 3", node)
+	}
+	for _, node := range []int64{-1, MaxNode + 1} {
+		if _, err := NewSnowflake(clock.System(), node); err == nil {
+			t.Errorf("NewSnowflake accepted node %d", node)
+		}
+	}
+}
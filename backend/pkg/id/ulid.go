@@ -0,0 +1,121 @@
+package id
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/clock"
+)
+
+// ULIDs are 128 bits, | 48 bits ms since the Unix epoch | 80 bits random |, written as 26
+// Crockford base32 characters. They sort lexically in creation order and need no node
+// coordination, so they replace the old nanosecond-timestamp string IDs, which could collide
+// across instances. Older IDs remain valid; IDs are opaque strings everywhere else.
+const ULIDLength = 26
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates monotonic ULIDs. Within a millisecond (or if the clock steps backwards) the
+// random part of the previous ID is incremented instead of redrawn, so IDs from a single
+// generator strictly increase.
+type ULID struct {
+	clock   clock.Clock
+	entropy io.Reader
+
+	mu       sync.Mutex
+	lastTime uint64 // ms since the Unix epoch of the last ID
+	hi       uint16 // top 16 bits of the last random part
+	lo       uint64 // bottom 64 bits of the last random part
+}
+
+// NewULID returns a generator reading randomness from crypto/rand
+func NewULID(clk clock.Clock) *ULID {
+	return &ULID{clock: clk, entropy: rand.Reader}
+}
+
+// Next returns the next ULID. It panics if the system's random source fails, which leaves
+// no safe way to issue IDs.
+func (u *ULID) Next() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := uint64(u.clock.Now().UnixMilli())
+	if now > u.lastTime {
+		u.draw()
+		u.lastTime = now
+	} else {
+		u.lo++
+		if u.lo == 0 {
+			u.hi++
+			if u.hi == 0 {
+				// 2^80 IDs in one millisecond: borrow the next one
+				u.draw()
+				u.lastTime++
+			}
+		}
+	}
+
+	return encodeULID(u.lastTime, u.hi, u.lo)
+}
+
+// draw replaces the random part with fresh entropy. Callers must hold u.mu.
+func (u *ULID) draw() {
+	var b [10]byte
+	if _, err := io.ReadFull(u.entropy, b[:]); err != nil {
+		panic(fmt.Sprintf("failed to read ULID entropy: %v", err))
+	}
+	u.hi = uint16(b[0])<<8 | uint16(b[1])
+	u.lo = 0
+	for _, c := range b[2:] {
+		u.lo = u.lo<<8 | uint64(c)
+	}
+}
+
+// encodeULID writes the 128-bit value | ms (48) | hi (16) | lo (64) | as base32, five bits
+// per character from the most significant end; the leading character carries three bits
+func encodeULID(ms uint64, hi uint16, lo uint64) string {
+	var out [ULIDLength]byte
+	for i := ULIDLength - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		// Shift the 128-bit value right by five
+		lo = lo>>5 | uint64(hi)<<59
+		hi = hi>>5 | uint16(ms<<11)
+		ms >>= 5
+	}
+	return string(out[:])
+}
+
+// ULIDTime returns when a ULID was generated, to millisecond precision. ok is false for
+// strings that are not ULIDs, such as IDs issued before ULIDs were introduced.
+func ULIDTime(s string) (t time.Time, ok bool) {
+	if len(s) != ULIDLength || s[0] > '7' {
+		return time.Time{}, false
+	}
+
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		v := decodeCrockford(s[i])
+		if v < 0 {
+			return time.Time{}, false
+		}
+		ms = ms<<5 | uint64(v)
+	}
+	for i := 10; i < ULIDLength; i++ {
+		if decodeCrockford(s[i]) < 0 {
+			return time.Time{}, false
+		}
+	}
+	return time.UnixMilli(int64(ms)).UTC(), true
+}
+
+func decodeCrockford(c byte) int {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,162 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LDAP messages are ASN.1 encoded with BER (RFC 4511 §5.1). Only what LDAP uses is handled:
+// single-byte tags and definite lengths.
+
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+
+	// maxElement bounds what the server may make us buffer for one message
+	maxElement = 16 << 20
+)
+
+// element is one decoded BER value; constructed values are parsed into children on demand
+type element struct {
+	tag   byte
+	value []byte
+}
+
+func encode(tag byte, value []byte) []byte {
+	n := len(value)
+	var header []byte
+	switch {
+	case n < 0x80:
+		header = []byte{tag, byte(n)}
+	case n <= 0xff:
+		header = []byte{tag, 0x81, byte(n)}
+	case n <= 0xffff:
+		header = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		header = []byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(header, value...)
+}
+
+func encodeConstructed(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	return encode(tag, value)
+}
+
+func encodeInteger(tag byte, v int64) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(v)}, value...)
+		if (v < 0x80 && v >= -0x80) || len(value) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return encode(tag, value)
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func encodeBool(tag byte, b bool) []byte {
+	if b {
+		return encode(tag, []byte{0xff})
+	}
+	return encode(tag, []byte{0x00})
+}
+
+// readElement reads one element from a stream
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	if tag&0x1f == 0x1f {
+		return element{}, errors.New("ldap: multi-byte tags are not supported")
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return element{}, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return element{}, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxElement {
+		return element{}, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return element{}, err
+	}
+	return element{tag: tag, value: value}, nil
+}
+
+// children parses a constructed element's contents
+func (e element) children() ([]element, error) {
+	var children []element
+	rest := e.value
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, errors.New("ldap: truncated element")
+		}
+		tag, length, header := rest[0], int(rest[1]), 2
+		if rest[1]&0x80 != 0 {
+			size := int(rest[1] & 0x7f)
+			if size == 0 || size > 4 || len(rest) < 2+size {
+				return nil, errors.New("ldap: unsupported length encoding")
+			}
+			length = 0
+			for _, b := range rest[2 : 2+size] {
+				length = length<<8 | int(b)
+			}
+			header += size
+		}
+		if length < 0 || len(rest) < header+length {
+			return nil, errors.New("ldap: truncated element")
+		}
+		children = append(children, element{tag: tag, value: rest[header : header+length]})
+		rest = rest[header+length:]
+	}
+	return children, nil
+}
+
+func (e element) integer() int64 {
+	var v int64
+	for i, b := range e.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (e element) string() string {
+	return string(e.value)
+}
@@ -0,0 +1,214 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choices (RFC 4511 §4.5.1.7)
+const (
+	filterAnd        = classContext | constructed | 0
+	filterOr         = classContext | constructed | 1
+	filterNot        = classContext | constructed | 2
+	filterEquality   = classContext | constructed | 3
+	filterSubstrings = classContext | constructed | 4
+	filterGreater    = classContext | constructed | 5
+	filterLess       = classContext | constructed | 6
+	filterPresent    = classContext | 7
+	filterApprox     = classContext | constructed | 8
+	filterExtensible = classContext | constructed | 9
+)
+
+// compileFilter encodes a string filter as RFC 4515 writes them, e.g.
+// (&(objectClass=user)(!(userAccountControl:1.2.840.113556.1.4.803:=2)))
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return encoded, nil
+}
+
+// parseFilter encodes the parenthesized filter at the start of s and returns what follows it
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter %q must start with (", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		s = s[1:]
+		var children [][]byte
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return encodeConstructed(tag, children...), s[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return encodeConstructed(filterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	encoded, err := parseItem(item)
+	return encoded, rest, err
+}
+
+// parseItem encodes a simple filter such as cn=Jo*, mail=* or givenName>=M
+func parseItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attribute, value := item[:eq], item[eq+1:]
+
+	switch attribute[len(attribute)-1] {
+	case '>', '<', '~':
+		tag := map[byte]byte{'>': filterGreater, '<': filterLess, '~': filterApprox}[attribute[len(attribute)-1]]
+		v, err := unescape(value)
+		if err != nil {
+			return nil, err
+		}
+		return encodeConstructed(tag, encodeString(tagOctetString, attribute[:len(attribute)-1]), encodeString(tagOctetString, v)), nil
+	case ':':
+		return parseExtensible(attribute[:len(attribute)-1], value)
+	}
+
+	if value == "*" {
+		return encodeString(filterPresent, attribute), nil
+	}
+	if !strings.Contains(value, "*") {
+		v, err := unescape(value)
+		if err != nil {
+			return nil, err
+		}
+		return encodeConstructed(filterEquality, encodeString(tagOctetString, attribute), encodeString(tagOctetString, v)), nil
+	}
+
+	parts := strings.Split(value, "*")
+	var substrings [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := unescape(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(classContext | 1) // any
+		switch i {
+		case 0:
+			tag = classContext | 0 // initial
+		case len(parts) - 1:
+			tag = classContext | 2 // final
+		}
+		substrings = append(substrings, encodeString(tag, v))
+	}
+	return encodeConstructed(filterSubstrings, encodeString(tagOctetString, attribute), encodeConstructed(tagSequence, substrings...)), nil
+}
+
+// parseExtensible encodes attr[:dn][:rule]:=value, the form Active Directory uses for bit tests
+func parseExtensible(spec, value string) ([]byte, error) {
+	v, err := unescape(value)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(spec, ":")
+	var attribute, rule string
+	dnAttributes := false
+	attribute = parts[0]
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else {
+			rule = part
+		}
+	}
+	if attribute == "" && rule == "" {
+		return nil, fmt.Errorf("ldap: extensible filter needs an attribute or a matching rule")
+	}
+
+	var children [][]byte
+	if rule != "" {
+		children = append(children, encodeString(classContext|1, rule))
+	}
+	if attribute != "" {
+		children = append(children, encodeString(classContext|2, attribute))
+	}
+	children = append(children, encodeString(classContext|3, v))
+	if dnAttributes {
+		children = append(children, encodeBool(classContext|4, true))
+	}
+	return encodeConstructed(filterExtensible, children...), nil
+}
+
+// unescape decodes the \XX escapes of a filter value
+func unescape(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeFilter escapes a value for use in a filter
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,323 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511): a simple bind and paged subtree
+// searches, which is what reading users and groups from a directory needs.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// pageSize stays under Active Directory's default MaxPageSize of 1000
+	pageSize = 500
+
+	// pagedResultsControl is the simple paged results control (RFC 2696)
+	pagedResultsControl = "1.2.840.113556.1.4.319"
+)
+
+// Protocol operations (RFC 4511 §4.2 to §4.5)
+const (
+	opBindRequest     = classApplication | constructed | 0
+	opBindResponse    = classApplication | constructed | 1
+	opUnbindRequest   = classApplication | 2
+	opSearchRequest   = classApplication | constructed | 3
+	opSearchEntry     = classApplication | constructed | 4
+	opSearchDone      = classApplication | constructed | 5
+	opSearchReference = classApplication | constructed | 19
+
+	tagControls = classContext | constructed | 0
+)
+
+// ResultError is a non-success LDAP result, such as 49 for invalid credentials
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a search result: a DN and its requested attributes' values
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // keyed by lowercased attribute name
+}
+
+// Get returns an attribute's first value, or ""
+func (e *Entry) Get(attribute string) string {
+	if values := e.Attributes[strings.ToLower(attribute)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns all of an attribute's values
+func (e *Entry) Values(attribute string) []string {
+	return e.Attributes[strings.ToLower(attribute)]
+}
+
+// Conn is a connection to a directory server. Requests are sent one at a time.
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+}
+
+// Dial connects to an ldap:// or ldaps:// URL; the port defaults to 389 or 636
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: URL scheme must be ldap or ldaps, not %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Close unbinds and closes the connection
+func (c *Conn) Close() error {
+	c.send(encode(opUnbindRequest, nil), nil)
+	return c.conn.Close()
+}
+
+// Bind authenticates with a DN and password. An empty password would be an anonymous bind
+// under another name (RFC 4513 §5.1.2), so it is refused.
+func (c *Conn) Bind(ctx context.Context, dn, password string) error {
+	if password == "" {
+		return errors.New("ldap: bind needs a password")
+	}
+	c.deadline(ctx)
+	request := encodeConstructed(opBindRequest,
+		encodeInteger(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	)
+	id, err := c.send(request, nil)
+	if err != nil {
+		return err
+	}
+	op, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%x to bind", op.tag)
+	}
+	return resultError(op)
+}
+
+// Search returns every entry under baseDN matching filter, with the named attributes,
+// following paged results until the server has sent them all
+func (c *Conn) Search(ctx context.Context, baseDN, filter string, attributes []string) ([]Entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	requested := make([][]byte, len(attributes))
+	for i, attribute := range attributes {
+		requested[i] = encodeString(tagOctetString, attribute)
+	}
+	request := encodeConstructed(opSearchRequest,
+		encodeString(tagOctetString, baseDN),
+		encodeInteger(tagEnumerated, 2), // wholeSubtree
+		encodeInteger(tagEnumerated, 0), // neverDerefAliases
+		encodeInteger(tagInteger, 0),    // no size limit
+		encodeInteger(tagInteger, 0),    // no time limit
+		encodeBool(tagBoolean, false),
+		compiled,
+		encodeConstructed(tagSequence, requested...),
+	)
+
+	var entries []Entry
+	var cookie []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.deadline(ctx)
+		id, err := c.send(request, pagingControl(cookie))
+		if err != nil {
+			return nil, err
+		}
+
+		for done := false; !done; {
+			op, controls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			switch op.tag {
+			case opSearchEntry:
+				entry, err := parseEntry(op)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, entry)
+			case opSearchReference:
+				// Referrals to other servers are not followed
+			case opSearchDone:
+				if err := resultError(op); err != nil {
+					return nil, err
+				}
+				cookie = pagingCookie(controls)
+				done = true
+			default:
+				return nil, fmt.Errorf("ldap: unexpected response 0x%x to search", op.tag)
+			}
+		}
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// deadline bounds the next exchange by ctx, if it has a deadline
+func (c *Conn) deadline(ctx context.Context) {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+}
+
+func (c *Conn) send(op []byte, controls []byte) (int64, error) {
+	c.messageID++
+	message := [][]byte{encodeInteger(tagInteger, c.messageID), op}
+	if controls != nil {
+		message = append(message, controls)
+	}
+	if _, err := c.conn.Write(encodeConstructed(tagSequence, message...)); err != nil {
+		return 0, err
+	}
+	return c.messageID, nil
+}
+
+// receive reads the next message, which must answer request id, returning its protocol
+// operation and controls
+func (c *Conn) receive(id int64) (element, []element, error) {
+	message, err := readElement(c.reader)
+	if err != nil {
+		return element{}, nil, err
+	}
+	parts, err := message.children()
+	if err != nil {
+		return element{}, nil, err
+	}
+	if message.tag != tagSequence || len(parts) < 2 {
+		return element{}, nil, errors.New("ldap: malformed message")
+	}
+	if got := parts[0].integer(); got != id {
+		return element{}, nil, fmt.Errorf("ldap: response to message %d while waiting for %d", got, id)
+	}
+	var controls []element
+	if len(parts) > 2 && parts[2].tag == tagControls {
+		if controls, err = parts[2].children(); err != nil {
+			return element{}, nil, err
+		}
+	}
+	return parts[1], controls, nil
+}
+
+// resultError reads an LDAPResult, returning nil for success
+func resultError(op element) error {
+	fields, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	if code := fields[0].integer(); code != 0 {
+		return &ResultError{Code: code, Message: fields[2].string()}
+	}
+	return nil
+}
+
+func parseEntry(op element) (Entry, error) {
+	fields, err := op.children()
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(fields) < 2 {
+		return Entry{}, errors.New("ldap: malformed entry")
+	}
+	entry := Entry{DN: fields[0].string(), Attributes: map[string][]string{}}
+	attributes, err := fields[1].children()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, attribute := range attributes {
+		parts, err := attribute.children()
+		if err != nil || len(parts) < 2 {
+			return Entry{}, errors.New("ldap: malformed attribute")
+		}
+		values, err := parts[1].children()
+		if err != nil {
+			return Entry{}, err
+		}
+		name := strings.ToLower(parts[0].string())
+		for _, value := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], value.string())
+		}
+	}
+	return entry, nil
+}
+
+// pagingControl asks for the page after cookie, or the first page
+func pagingControl(cookie []byte) []byte {
+	value := encodeConstructed(tagSequence,
+		encodeInteger(tagInteger, pageSize),
+		encode(tagOctetString, cookie),
+	)
+	return encodeConstructed(tagControls, encodeConstructed(tagSequence,
+		encodeString(tagOctetString, pagedResultsControl),
+		encode(tagOctetString, value),
+	))
+}
+
+// pagingCookie returns the cookie for the next page; empty when there is none
+func pagingCookie(controls []element) []byte {
+	for _, control := range controls {
+		fields, err := control.children()
+		if err != nil || len(fields) < 2 || fields[0].string() != pagedResultsControl {
+			continue
+		}
+		value := fields[len(fields)-1]
+		outer, err := element{tag: tagSequence, value: value.value}.children()
+		if err != nil || len(outer) != 1 {
+			continue
+		}
+		inner, err := outer[0].children()
+		if err != nil || len(inner) < 2 {
+			continue
+		}
+		return inner[1].value
+	}
+	return nil
+}
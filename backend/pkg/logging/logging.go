@@ -0,0 +1,74 @@
+// Package logging builds the service's structured logger and names the fields log lines share,
+// so a user, conversation or message can be searched for across every component.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+)
+
+// Field names used on every log line that concerns them
+const (
+	UserID         = "user_id"
+	ConversationID = "conversation_id"
+	MessageID      = "message_id"
+	RequestID      = "request_id"
+	ClientID       = "client_id"
+	JobID          = "job_id"
+)
+
+// Formats, selected with LOG_FORMAT
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing to w at level (debug, info, warn, error) in format. Records
+// logged with a context carrying a request ID get a request_id field.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Err is the attribute for an error
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		record.AddAttrs(slog.String(RequestID, id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,291 @@
+// Package markdown renders the Markdown subset chat messages may use to HTML that is safe to
+// show as is:
+//
+//	**bold**  *italic* or _italic_  ~~struck~~  `code`  [text](https://…)  bare https://… links
+//	```fenced code blocks```  > quotes  - or * bullet lists  1. numbered lists
+//
+// Everything else, raw HTML included, comes out as escaped text, and links are limited to
+// http, https and mailto. Line breaks are kept, as people expect in chat. The output only ever
+// contains the tags p, br, strong, em, del, code, pre, a, blockquote, ul, ol and li.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	bulletItem   = regexp.MustCompile(`^[-*+] +`)
+	numberedItem = regexp.MustCompile(`^[0-9]{1,9}[.)] +`)
+	bareLink     = regexp.MustCompile(`^https?://[^\s<>"]+`)
+)
+
+// Render returns source as sanitized HTML
+func Render(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	var out strings.Builder
+	renderBlocks(&out, strings.Split(source, "\n"))
+	return out.String()
+}
+
+// renderBlocks writes lines as a sequence of paragraphs, code blocks, quotes and lists
+func renderBlocks(out *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case strings.HasPrefix(line, "```"):
+			// Runs to the closing fence, or to the end if there is none
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(lines[end], "```") {
+				end++
+			}
+			out.WriteString("<pre><code>")
+			out.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+			out.WriteString("</code></pre>")
+			i = min(end+1, len(lines))
+
+		case strings.HasPrefix(line, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(lines[i], ">"), " "))
+			}
+			out.WriteString("<blockquote>")
+			renderBlocks(out, quoted)
+			out.WriteString("</blockquote>")
+
+		case bulletItem.MatchString(line):
+			i = renderList(out, lines, i, "ul", bulletItem)
+
+		case numberedItem.MatchString(line):
+			i = renderList(out, lines, i, "ol", numberedItem)
+
+		default:
+			out.WriteString("<p>")
+			for first := true; i < len(lines) && startsParagraphLine(lines[i]); i++ {
+				if !first {
+					out.WriteString("<br>")
+				}
+				first = false
+				renderInline(out, lines[i])
+			}
+			out.WriteString("</p>")
+		}
+	}
+}
+
+// startsParagraphLine reports whether line continues a paragraph rather than starting a block
+func startsParagraphLine(line string) bool {
+	return strings.TrimSpace(line) != "" &&
+		!strings.HasPrefix(line, "```") &&
+		!strings.HasPrefix(line, ">") &&
+		!bulletItem.MatchString(line) &&
+		!numberedItem.MatchString(line)
+}
+
+// renderList writes the list items starting at lines[i] and returns the index after them
+func renderList(out *strings.Builder, lines []string, i int, tag string, marker *regexp.Regexp) int {
+	out.WriteString("<" + tag + ">")
+	for ; i < len(lines) && marker.MatchString(lines[i]); i++ {
+		out.WriteString("<li>")
+		renderInline(out, marker.ReplaceAllString(lines[i], ""))
+		out.WriteString("</li>")
+	}
+	out.WriteString("</" + tag + ">")
+	return i
+}
+
+// spans are the paired inline markers, longest first so ** is not read as two *
+var spans = []struct {
+	marker string
+	tag    string
+}{
+	{"**", "strong"},
+	{"~~", "del"},
+	{"*", "em"},
+	{"_", "em"},
+}
+
+// renderInline writes one line's text with its inline formatting
+func renderInline(out *strings.Builder, text string) {
+	renderText(out, text, true)
+}
+
+// renderText writes text with its inline formatting; without links, as inside a link's own
+// text, links are left as text so they do not nest
+func renderText(out *strings.Builder, text string, links bool) {
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		switch rest[0] {
+		case '\\':
+			// A backslash makes the punctuation after it literal
+			if len(rest) > 1 && strings.ContainsRune("\\`*_~[]()>#+-.!", rune(rest[1])) {
+				out.WriteString(html.EscapeString(rest[1:2]))
+				i += 2
+				continue
+			}
+
+		case '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				out.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+
+		case '[':
+			if !links {
+				break
+			}
+			if n := renderLink(out, rest); n > 0 {
+				i += n
+				continue
+			}
+
+		case 'h':
+			if link := trimLink(bareLink.FindString(rest)); links && link != "" && wordStart(text, i) {
+				writeLink(out, link, html.EscapeString(link))
+				i += len(link)
+				continue
+			}
+		}
+
+		if n := renderSpan(out, text, i, links); n > 0 {
+			i += n
+			continue
+		}
+
+		// Copy up to the next character that may start formatting
+		next := strings.IndexAny(rest[1:], "\\`[h*_~")
+		if next < 0 {
+			next = len(rest) - 1
+		}
+		out.WriteString(html.EscapeString(rest[:1+next]))
+		i += 1 + next
+	}
+}
+
+// renderSpan writes the emphasis span starting at text[i], if there is one, and returns its
+// length. Spans need text right inside both markers, and _ only counts at word boundaries, so
+// 2 * 3 * 4 and snake_case_names stay as they are.
+func renderSpan(out *strings.Builder, text string, i int, links bool) int {
+	rest := text[i:]
+	for _, span := range spans {
+		if !strings.HasPrefix(rest, span.marker) {
+			continue
+		}
+		if span.marker == "_" && !wordStart(text, i) {
+			return 0
+		}
+		width := len(span.marker)
+		inner := rest[width:]
+		if inner == "" || inner[0] == ' ' {
+			return 0
+		}
+
+		for from := 0; from < len(inner); {
+			end := strings.Index(inner[from:], span.marker)
+			if end < 0 {
+				return 0
+			}
+			end += from
+			closing := end + width
+			// A closer is not preceded by a space, and a single * is not half of **
+			ok := end > 0 && inner[end-1] != ' ' &&
+				!(width == 1 && closing < len(inner) && inner[closing] == span.marker[0])
+			if ok && span.marker == "_" {
+				ok = closing == len(inner) || !isWordByte(inner[closing])
+			}
+			// In ***text*** the ** closes last, leaving *text* inside
+			for ok && width == 2 && closing < len(inner) && inner[closing] == span.marker[0] {
+				end++
+				closing++
+			}
+			if ok {
+				out.WriteString("<" + span.tag + ">")
+				renderText(out, inner[:end], links)
+				out.WriteString("</" + span.tag + ">")
+				return width + closing
+			}
+			from = end + 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// renderLink writes a [text](url) link at the start of rest and returns its length, or 0 if
+// rest does not start with one or the URL is not allowed
+func renderLink(out *strings.Builder, rest string) int {
+	closeText := strings.Index(rest, "](")
+	if closeText < 0 {
+		return 0
+	}
+	closeURL := strings.IndexByte(rest[closeText+2:], ')')
+	if closeURL < 0 {
+		return 0
+	}
+	label := rest[1:closeText]
+	target := strings.TrimSpace(rest[closeText+2 : closeText+2+closeURL])
+	if label == "" || !allowedLink(target) {
+		return 0
+	}
+
+	var text strings.Builder
+	renderText(&text, label, false)
+	writeLink(out, target, text.String())
+	return closeText + 2 + closeURL + 1
+}
+
+func writeLink(out *strings.Builder, target, text string) {
+	out.WriteString(`<a href="` + html.EscapeString(target) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+	out.WriteString(text)
+	out.WriteString("</a>")
+}
+
+// allowedLink reports whether target is an absolute http, https or mailto URL
+func allowedLink(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+// trimLink drops trailing punctuation from a bare link, and a closing parenthesis the link
+// did not open
+func trimLink(link string) string {
+	for {
+		trimmed := strings.TrimRight(link, `.,;:!?'*_~`)
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == link {
+			break
+		}
+		link = trimmed
+	}
+	if !allowedLink(link) {
+		return ""
+	}
+	return link
+}
+
+// wordStart reports whether text[i] begins a word
+func wordStart(text string, i int) bool {
+	return i == 0 || !isWordByte(text[i-1])
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
@@ -3,21 +3,115 @@ package nats
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/JohnBPerkins/chat-service/backend/pkg/logging"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/requestid"
+	"github.com/JohnBPerkins/chat-service/backend/pkg/tenant"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// ChatStream is the JetStream stream holding chat.conv.*.msg
+const ChatStream = "CHAT"
+
+// EventHeader names the kind of event a chat.conv.*.msg entry carries; absent means EventMessageCreated
+const EventHeader = "Chat-Event"
+
+// Events carried on chat.conv.*.msg
+const (
+	EventMessageCreated   = "message.created"
+	EventMessageRetracted = "message.retracted"
+	EventMessageUpdated   = "message.updated"
+)
+
 type NATSConnection struct {
-	Conn *nats.Conn
-	JS   jetstream.JetStream
+	Conn   *nats.Conn
+	JS     jetstream.JetStream
+	logger *slog.Logger
+
+	reconnectMu       sync.Mutex
+	reconnectHandlers []func()
+}
+
+// ConnectionConfig says where and how to connect to NATS
+type ConnectionConfig struct {
+	URL string
+
+	// Publishes made while disconnected are buffered, up to ReconnectBufSize bytes
+	ReconnectWait    time.Duration
+	ReconnectBufSize int
+
+	// Authentication; set at most one of CredsFile, NKeySeedFile, Token or User/Password
+	CredsFile    string // decentralized JWT auth (.creds file holding the user JWT and seed)
+	NKeySeedFile string
+	Token        string
+	User         string
+	Password     string
+
+	// TLS. TLSCAFile trusts a private CA; TLSCertFile/TLSKeyFile present a client certificate
+	// for servers that verify clients. Any of them, or a tls:// URL, makes TLS required.
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// authOptions returns the nats.go options for config's credentials and TLS settings
+func (config ConnectionConfig) authOptions() ([]nats.Option, error) {
+	var opts []nats.Option
+	switch {
+	case config.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	case config.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(config.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NATS nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case config.Token != "":
+		opts = append(opts, nats.Token(config.Token))
+	case config.User != "":
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	}
+
+	if config.TLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(config.TLSCAFile))
+	}
+	if config.TLSCertFile != "" {
+		opts = append(opts, nats.ClientCert(config.TLSCertFile, config.TLSKeyFile))
+	}
+	return opts, nil
 }
 
-func NewConnection(url string) (*NATSConnection, error) {
+// NewConnection connects to NATS and keeps reconnecting for as long as the process runs
+func NewConnection(config ConnectionConfig, logger *slog.Logger) (*NATSConnection, error) {
+	conn := &NATSConnection{logger: logger}
+
+	authOpts, err := config.authOptions()
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to NATS
-	nc, err := nats.Connect(url)
+	nc, err := nats.Connect(config.URL, append(authOpts,
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(config.ReconnectWait),
+		nats.ReconnectBufSize(config.ReconnectBufSize),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			logger.Warn("NATS disconnected", logging.Err(err))
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("NATS reconnected", "url", nc.ConnectedUrlRedacted())
+			conn.notifyReconnect()
+		}),
+		nats.ClosedHandler(func(_ *nats.Conn) {
+			logger.Info("NATS connection closed")
+		}),
+	)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
@@ -29,71 +123,305 @@ func NewConnection(url string) (*NATSConnection, error) {
 	}
 
 	// Create or update the CHAT stream
-	if err := createChatStream(js); err != nil {
+	if err := createChatStream(js, logger); err != nil {
 		return nil, fmt.Errorf("failed to create CHAT stream: %w", err)
 	}
 
-	return &NATSConnection{
-		Conn: nc,
-		JS:   js,
-	}, nil
+	conn.Conn = nc
+	conn.JS = js
+	return conn, nil
+}
+
+// OnReconnect registers fn to run each time the connection is re-established. Core NATS
+// delivers nothing while disconnected, so subscribers use this to catch up.
+func (nc *NATSConnection) OnReconnect(fn func()) {
+	nc.reconnectMu.Lock()
+	defer nc.reconnectMu.Unlock()
+	nc.reconnectHandlers = append(nc.reconnectHandlers, fn)
+}
+
+func (nc *NATSConnection) notifyReconnect() {
+	nc.reconnectMu.Lock()
+	handlers := append([]func(){}, nc.reconnectHandlers...)
+	nc.reconnectMu.Unlock()
+
+	// Handlers may do slow work; keep them off the client's callback goroutine
+	for _, fn := range handlers {
+		go fn()
+	}
 }
 
 func (nc *NATSConnection) Close() {
 	nc.Conn.Close()
 }
 
-func createChatStream(js jetstream.JetStream) error {
-	streamConfig := jetstream.StreamConfig{
-		Name:        "CHAT",
+// DefaultChatStreamConfig is the configuration the CHAT stream is created with
+func DefaultChatStreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:        ChatStream,
 		Description: "Chat messages stream",
 		Subjects:    []string{"chat.conv.*.msg"},
 		Storage:     jetstream.FileStorage,
-		MaxAge:      0, // Keep messages indefinitely
+		MaxAge:      0,                  // Keep messages indefinitely
 		MaxBytes:    1024 * 1024 * 1024, // 1GB max
-		MaxMsgs:     -1, // No message limit
+		MaxMsgs:     -1,                 // No message limit
 		Replicas:    1,
+		Duplicates:  2 * time.Minute, // Nats-Msg-Id dedup window for retried publishes
 	}
+}
 
-	// Try to create stream, if it exists, update it
+// createChatStream creates the CHAT stream if it is missing. An existing stream is left as
+// it is: changing replicas or limits can disrupt delivery, so that only happens through a
+// scheduled reconfiguration (see services.StreamConfigService).
+func createChatStream(js jetstream.JetStream, logger *slog.Logger) error {
 	ctx := context.Background()
-	_, err := js.CreateStream(ctx, streamConfig)
-	if err != nil {
-		// If stream already exists, try to update it
-		if err.Error() == "stream name already in use" {
-			_, err = js.UpdateStream(ctx, streamConfig)
-			if err != nil {
-				return fmt.Errorf("failed to update stream: %w", err)
-			}
-			log.Println("Updated existing CHAT stream")
-		} else {
+	streamConfig := DefaultChatStreamConfig()
+
+	stream, err := js.Stream(ctx, ChatStream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		if _, err := js.CreateStream(ctx, streamConfig); err != nil {
 			return fmt.Errorf("failed to create stream: %w", err)
 		}
-	} else {
-		log.Println("Created CHAT stream")
+		logger.Info("Created CHAT stream")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up stream: %w", err)
+	}
+
+	current := stream.CachedInfo().Config
+	if current.Replicas != streamConfig.Replicas || current.MaxBytes != streamConfig.MaxBytes || current.MaxAge != streamConfig.MaxAge {
+		logger.Warn("CHAT stream config differs from defaults; leaving it unchanged",
+			"replicas", current.Replicas, "max_bytes", current.MaxBytes, "max_age", current.MaxAge)
 	}
 
 	return nil
 }
 
-// PublishMessage publishes a message to the appropriate JetStream subject
-func (nc *NATSConnection) PublishMessage(conversationID string, data interface{}) error {
+// MessageFeed returns an ordered consumer delivering every conversation's new chat.conv.*.msg
+// entries, for a node's fan-out. It is ephemeral; the client recreates it, resuming after the
+// last entry it delivered, if the server drops it or the connection is lost.
+func (nc *NATSConnection) MessageFeed(ctx context.Context) (jetstream.Consumer, error) {
+	consumer, err := nc.JS.OrderedConsumer(ctx, ChatStream, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{"chat.conv.*.msg"},
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message feed: %w", err)
+	}
+	return consumer, nil
+}
+
+// ChatStreamInfo returns the CHAT stream's current configuration and state
+func (nc *NATSConnection) ChatStreamInfo(ctx context.Context) (*jetstream.StreamInfo, error) {
+	stream, err := nc.JS.Stream(ctx, ChatStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up stream: %w", err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream info: %w", err)
+	}
+	return info, nil
+}
+
+// UpdateChatStream applies a new configuration to the CHAT stream
+func (nc *NATSConnection) UpdateChatStream(ctx context.Context, config jetstream.StreamConfig) (*jetstream.StreamInfo, error) {
+	stream, err := nc.JS.UpdateStream(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update stream: %w", err)
+	}
+	return stream.CachedInfo(), nil
+}
+
+// ConsumerLag returns the number of undelivered messages for each CHAT consumer
+func (nc *NATSConnection) ConsumerLag(ctx context.Context) (map[string]uint64, error) {
+	stream, err := nc.JS.Stream(ctx, ChatStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up stream: %w", err)
+	}
+
+	lag := make(map[string]uint64)
+	consumers := stream.ListConsumers(ctx)
+	for info := range consumers.Info() {
+		lag[info.Name] = info.NumPending
+	}
+	if err := consumers.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list consumers: %w", err)
+	}
+
+	return lag, nil
+}
+
+// PublishMessage publishes a message event to the appropriate JetStream subject and returns its
+// stream sequence. msgID is sent as Nats-Msg-Id, so a retried publish within the stream's dedup
+// window is dropped by the server; duplicate then reports true and the sequence is the original's.
+// workspaceID names the conversation's tenant for consumers that read storage.
+func (nc *NATSConnection) PublishMessage(conversationID, event, msgID, requestID, workspaceID string, data interface{}) (seq uint64, duplicate bool, err error) {
 	subject := fmt.Sprintf("chat.conv.%s.msg", conversationID)
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message data: %w", err)
+		return 0, false, fmt.Errorf("failed to marshal message data: %w", err)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = jsonData
+	if event != EventMessageCreated {
+		msg.Header.Set(EventHeader, event)
+	}
+	if requestID != "" {
+		msg.Header.Set(requestid.Header, requestID)
+	}
+	if workspaceID != "" {
+		msg.Header.Set(tenant.Header, workspaceID)
 	}
 
 	ctx := context.Background()
-	_, err = nc.JS.Publish(ctx, subject, jsonData)
+	ack, err := nc.JS.PublishMsg(ctx, msg, jetstream.WithMsgID(msgID))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to publish message: %w", err)
+	}
+	if ack.Stream != ChatStream || ack.Sequence == 0 {
+		return 0, false, fmt.Errorf("unexpected publish ack from stream %q (seq %d)", ack.Stream, ack.Sequence)
+	}
+
+	return ack.Sequence, ack.Duplicate, nil
+}
+
+// ParkConsumer (re)creates the durable consumer name so that it collects messages on subjects
+// published from now on. Consumers unused for ttl are removed by the server.
+func (nc *NATSConnection) ParkConsumer(ctx context.Context, name string, subjects []string, ttl time.Duration) error {
+	if err := nc.JS.DeleteConsumer(ctx, ChatStream, name); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return fmt.Errorf("failed to delete consumer: %w", err)
+	}
+	if len(subjects) == 0 {
+		return nil
+	}
+
+	stream, err := nc.JS.Stream(ctx, ChatStream)
+	if err != nil {
+		return fmt.Errorf("failed to look up stream: %w", err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read stream info: %w", err)
+	}
+
+	_, err = nc.JS.CreateConsumer(ctx, ChatStream, jetstream.ConsumerConfig{
+		Durable:           name,
+		FilterSubjects:    subjects,
+		DeliverPolicy:     jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:       info.State.LastSeq + 1,
+		AckPolicy:         jetstream.AckNonePolicy,
+		InactiveThreshold: ttl,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		return fmt.Errorf("failed to create consumer: %w", err)
 	}
 
 	return nil
 }
 
+// DrainConsumer reads up to limit messages collected by a parked consumer, first widening its
+// filter to subjects. A missing consumer yields nothing.
+func (nc *NATSConnection) DrainConsumer(ctx context.Context, name string, subjects []string, limit int) ([]ReplayedMessage, bool, error) {
+	consumer, err := nc.JS.Consumer(ctx, ChatStream, name)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to look up consumer: %w", err)
+	}
+
+	if len(subjects) > 0 {
+		config := consumer.CachedInfo().Config
+		config.FilterSubject = ""
+		config.FilterSubjects = subjects
+		if consumer, err = nc.JS.UpdateConsumer(ctx, ChatStream, config); err != nil {
+			return nil, false, fmt.Errorf("failed to update consumer: %w", err)
+		}
+	}
+
+	return fetchAll(consumer, limit)
+}
+
+// ReplayedMessage is a stored chat.conv.<id>.msg entry
+type ReplayedMessage struct {
+	Sequence uint64
+	Event    string
+	Data     []byte
+}
+
+// ReplayMessages reads up to limit of a conversation's messages from the CHAT stream, starting at
+// startSeq, or at startTime when startSeq is zero. more reports whether messages remain after them.
+func (nc *NATSConnection) ReplayMessages(ctx context.Context, conversationID string, startSeq uint64, startTime time.Time, limit int) ([]ReplayedMessage, bool, error) {
+	config := jetstream.ConsumerConfig{
+		FilterSubject:     fmt.Sprintf("chat.conv.%s.msg", conversationID),
+		AckPolicy:         jetstream.AckNonePolicy,
+		InactiveThreshold: 30 * time.Second,
+	}
+	if startSeq > 0 {
+		config.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		config.OptStartSeq = startSeq
+	} else {
+		config.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		config.OptStartTime = &startTime
+	}
+
+	consumer, err := nc.JS.CreateConsumer(ctx, ChatStream, config)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create replay consumer: %w", err)
+	}
+	defer nc.JS.DeleteConsumer(context.Background(), ChatStream, consumer.CachedInfo().Name)
+
+	return fetchAll(consumer, limit)
+}
+
+// fetchAll reads up to limit messages from consumer without waiting for new ones
+func fetchAll(consumer jetstream.Consumer, limit int) ([]ReplayedMessage, bool, error) {
+	var replayed []ReplayedMessage
+	for len(replayed) < limit {
+		batch, err := consumer.FetchNoWait(min(limit-len(replayed), 100))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to fetch replay batch: %w", err)
+		}
+
+		received := 0
+		var pending uint64
+		for msg := range batch.Messages() {
+			received++
+			meta, err := msg.Metadata()
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to read replay metadata: %w", err)
+			}
+			replayed = append(replayed, ReplayedMessage{
+				Sequence: meta.Sequence.Stream,
+				Event:    MessageEvent(msg.Headers()),
+				Data:     msg.Data(),
+			})
+			pending = meta.NumPending
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) {
+			return nil, false, fmt.Errorf("failed to fetch replay batch: %w", err)
+		}
+
+		if received == 0 || pending == 0 {
+			return replayed, false, nil
+		}
+	}
+
+	return replayed, true, nil
+}
+
+// MessageEvent returns the event a chat.conv.*.msg entry carries, given its headers
+func MessageEvent(header nats.Header) string {
+	if event := header.Get(EventHeader); event != "" {
+		return event
+	}
+	return EventMessageCreated
+}
+
 // PublishTyping publishes a typing indicator (ephemeral)
 func (nc *NATSConnection) PublishTyping(conversationID string, data interface{}) error {
 	subject := fmt.Sprintf("chat.conv.%s.typing", conversationID)
@@ -112,20 +440,239 @@ func (nc *NATSConnection) PublishTyping(conversationID string, data interface{})
 	return nil
 }
 
-// PublishPresence publishes presence information (ephemeral)
-func (nc *NATSConnection) PublishPresence(conversationID string, data interface{}) error {
+// PublishStatus publishes a user's status to one of their conversations (ephemeral)
+func (nc *NATSConnection) PublishStatus(conversationID string, data interface{}) error {
 	subject := fmt.Sprintf("chat.conv.%s.presence", conversationID)
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal presence data: %w", err)
+		return fmt.Errorf("failed to marshal status data: %w", err)
+	}
+	if err := nc.Conn.Publish(subject, jsonData); err != nil {
+		return fmt.Errorf("failed to publish status: %w", err)
+	}
+	return nil
+}
+
+// PublishReceipt publishes a read receipt update (ephemeral)
+func (nc *NATSConnection) PublishReceipt(conversationID string, data interface{}) error {
+	subject := fmt.Sprintf("chat.conv.%s.receipt", conversationID)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal receipt data: %w", err)
 	}
 
 	// Use regular NATS publish for ephemeral data
 	err = nc.Conn.Publish(subject, jsonData)
 	if err != nil {
-		return fmt.Errorf("failed to publish presence: %w", err)
+		return fmt.Errorf("failed to publish read receipt: %w", err)
+	}
+
+	return nil
+}
+
+// PublishBotEvent publishes a change to a conversation's bot allow-list (ephemeral)
+func (nc *NATSConnection) PublishBotEvent(conversationID string, data interface{}) error {
+	subject := fmt.Sprintf("chat.conv.%s.bots", conversationID)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bot event: %w", err)
+	}
+
+	err = nc.Conn.Publish(subject, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to publish bot event: %w", err)
+	}
+
+	return nil
+}
+
+// PublishPollUpdate publishes a poll's tally (ephemeral; the message holds the latest)
+func (nc *NATSConnection) PublishPollUpdate(conversationID string, data interface{}) error {
+	subject := fmt.Sprintf("chat.conv.%s.poll", conversationID)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal poll update: %w", err)
+	}
+
+	err = nc.Conn.Publish(subject, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to publish poll update: %w", err)
+	}
+
+	return nil
+}
+
+// PublishLocationUpdate publishes a live location's new position (ephemeral; never stored)
+func (nc *NATSConnection) PublishLocationUpdate(conversationID string, data interface{}) error {
+	subject := fmt.Sprintf("chat.conv.%s.location", conversationID)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal location update: %w", err)
+	}
+
+	err = nc.Conn.Publish(subject, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to publish location update: %w", err)
+	}
+
+	return nil
+}
+
+// PublishMembership publishes a membership change for a conversation (ephemeral)
+func (nc *NATSConnection) PublishMembership(conversationID string, data interface{}) error {
+	subject := fmt.Sprintf("chat.conv.%s.members", conversationID)
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal membership data: %w", err)
+	}
+
+	err = nc.Conn.Publish(subject, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to publish membership change: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// UserInvalidationSubject carries the IDs of users whose cached profiles are stale
+const UserInvalidationSubject = "chat.users.invalidate"
+
+// PublishUserInvalidation tells every node to drop its cached profile of userID (ephemeral)
+func (nc *NATSConnection) PublishUserInvalidation(userID string) error {
+	if err := nc.Conn.Publish(UserInvalidationSubject, []byte(userID)); err != nil {
+		return fmt.Errorf("failed to publish user invalidation: %w", err)
+	}
+	return nil
+}
+
+// SelfReceiptSubject carries users' own read positions to their other connections
+const SelfReceiptSubject = "chat.users.receipt"
+
+// PublishSelfReceipt tells every node that a user read a conversation (ephemeral)
+func (nc *NATSConnection) PublishSelfReceipt(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal self receipt data: %w", err)
+	}
+	if err := nc.Conn.Publish(SelfReceiptSubject, jsonData); err != nil {
+		return fmt.Errorf("failed to publish self receipt: %w", err)
+	}
+	return nil
+}
+
+// AwaySummarySubject carries summaries of the notifications users missed during do-not-disturb
+const AwaySummarySubject = "chat.users.away"
+
+// PublishAwaySummary sends a user's away summary to their connections on every node (ephemeral)
+func (nc *NATSConnection) PublishAwaySummary(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal away summary: %w", err)
+	}
+	if err := nc.Conn.Publish(AwaySummarySubject, jsonData); err != nil {
+		return fmt.Errorf("failed to publish away summary: %w", err)
+	}
+	return nil
+}
+
+// ConversationEventSubject carries conversation lifecycle events for the users they concern
+const ConversationEventSubject = "chat.users.conversations"
+
+// PublishConversationEvent tells every node about a conversation created, deleted or whose
+// members changed (ephemeral)
+func (nc *NATSConnection) PublishConversationEvent(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation event: %w", err)
+	}
+	if err := nc.Conn.Publish(ConversationEventSubject, jsonData); err != nil {
+		return fmt.Errorf("failed to publish conversation event: %w", err)
+	}
+	return nil
+}
+
+// SessionRevokeSubject carries the IDs of WebSocket connections to close, wherever they are
+const SessionRevokeSubject = "chat.sessions.revoke"
+
+// PublishSessionRevoke tells every node to close the connection with the given ID (ephemeral)
+func (nc *NATSConnection) PublishSessionRevoke(sessionID string) error {
+	if err := nc.Conn.Publish(SessionRevokeSubject, []byte(sessionID)); err != nil {
+		return fmt.Errorf("failed to publish session revocation: %w", err)
+	}
+	return nil
+}
+
+// SessionSupersedeSubject carries the IDs of WebSocket connections displaced by newer ones
+const SessionSupersedeSubject = "chat.sessions.supersede"
+
+// PublishSessionSupersede tells every node to close the connection with the given ID as
+// replaced by a newer one (ephemeral)
+func (nc *NATSConnection) PublishSessionSupersede(sessionID string) error {
+	if err := nc.Conn.Publish(SessionSupersedeSubject, []byte(sessionID)); err != nil {
+		return fmt.Errorf("failed to publish session supersede: %w", err)
+	}
+	return nil
+}
+
+// SessionBucket lists every node's WebSocket connections, one key per connection
+const SessionBucket = "ws_sessions"
+
+// SessionStore opens the session bucket, creating it if needed. Entries not refreshed within
+// ttl expire.
+func (nc *NATSConnection) SessionStore(ctx context.Context, ttl time.Duration) (jetstream.KeyValue, error) {
+	kv, err := nc.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      SessionBucket,
+		Description: "WebSocket connections",
+		TTL:         ttl,
+		History:     1,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session bucket: %w", err)
+	}
+	return kv, nil
+}
+
+// UserProfileBucket is the JetStream key-value bucket shared by nodes caching user profiles
+const UserProfileBucket = "user_profiles"
+
+// PresenceBucket holds a heartbeat per node, conversation and online user
+const PresenceBucket = "presence"
+
+// PresenceStore opens the presence bucket, creating it if needed. Heartbeats not refreshed
+// within ttl expire.
+func (nc *NATSConnection) PresenceStore(ctx context.Context, ttl time.Duration) (jetstream.KeyValue, error) {
+	kv, err := nc.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      PresenceBucket,
+		Description: "Presence heartbeats",
+		TTL:         ttl,
+		History:     1,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open presence bucket: %w", err)
+	}
+	return kv, nil
+}
+
+// UserProfileStore opens the shared profile cache bucket, creating it if needed. Entries expire
+// after ttl.
+func (nc *NATSConnection) UserProfileStore(ctx context.Context, ttl time.Duration) (jetstream.KeyValue, error) {
+	kv, err := nc.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      UserProfileBucket,
+		Description: "Cached user profiles",
+		TTL:         ttl,
+		History:     1,
+		Storage:     jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user profile bucket: %w", err)
+	}
+	return kv, nil
+}
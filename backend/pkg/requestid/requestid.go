@@ -0,0 +1,62 @@
+// Package requestid carries the correlation ID of a request through contexts, logs, error
+// responses, NATS headers and WebSocket acks, so one operation can be traced end to end.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the ID on HTTP requests and responses and on NATS messages
+const Header = "X-Request-ID"
+
+// maxLength bounds IDs supplied by clients, which end up in logs and stored documents
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a random ID
+func New() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Valid reports whether a client-supplied ID may be used as is: non-empty, at most 128
+// characters of letters, digits and . _ : -
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// OrNew returns id if it is valid, otherwise a new ID
+func OrNew(id string) string {
+	if Valid(id) {
+		return id
+	}
+	return New()
+}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID, or "" outside a request
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,27 @@
+// Package tenant carries the workspace an operation acts in through contexts and NATS headers,
+// so storage can be routed to the workspace's own database when tenants are isolated.
+package tenant
+
+import "context"
+
+const (
+	// Header carries the workspace ID on NATS messages
+	Header = "Chat-Workspace"
+
+	// Default is the workspace that existed before workspaces did; its data always stays in
+	// the configured database
+	Default = "default"
+)
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, workspaceID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, workspaceID)
+}
+
+// FromContext returns the workspace ID, or "" when the context names none (the default
+// workspace, or every workspace when they share one database)
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,169 @@
+// Package validate checks request structs against rules declared in `validate` struct tags,
+// so handlers share one set of checks instead of repeating them:
+//
+//	Body string `json:"body" validate:"required,max=4000"`
+//
+// Rules are comma separated:
+//
+//	required     the value is not empty: non-blank string, non-empty slice, non-nil pointer
+//	min=N, max=N bounds on length in characters for strings, on length for slices and on the
+//	             value for numbers; nil pointers and empty optional values are skipped
+//	oneof=a|b|c  a string is one of the listed values (empty passes unless also required)
+//
+// Errors name the field by its JSON name.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error reports the first field that failed its rules
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Struct validates v, a struct or pointer to one. Nested structs are not descended into.
+// It panics on malformed tags, which are programming errors.
+func Struct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		if err := checkField(jsonName(field), rv.Field(i), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkField(name string, value reflect.Value, tag string) error {
+	rules := strings.Split(tag, ",")
+
+	required := false
+	for _, rule := range rules {
+		if rule == "required" {
+			required = true
+		}
+	}
+
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			if required {
+				return &Error{Field: name, Message: "is required"}
+			}
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if isEmpty(value) {
+		if required {
+			return &Error{Field: name, Message: "is required"}
+		}
+		return nil
+	}
+
+	for _, rule := range rules {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+		case "min", "max":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: bad %s rule on %s: %q", key, name, rule))
+			}
+			if err := checkBound(name, value, key, limit); err != nil {
+				return err
+			}
+		case "oneof":
+			if value.Kind() != reflect.String {
+				panic(fmt.Sprintf("validate: oneof on non-string field %s", name))
+			}
+			options := strings.Split(arg, "|")
+			if !contains(options, value.String()) {
+				return &Error{Field: name, Message: "must be one of " + strings.Join(options, ", ")}
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+	}
+	return nil
+}
+
+func checkBound(name string, value reflect.Value, key string, limit int) error {
+	var n int64
+	unit := ""
+	switch value.Kind() {
+	case reflect.String:
+		n = int64(utf8.RuneCountInString(value.String()))
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = int64(value.Len())
+		unit = " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int64(value.Uint())
+	default:
+		panic(fmt.Sprintf("validate: %s on unsupported field %s", key, name))
+	}
+
+	if key == "min" && n < int64(limit) {
+		return &Error{Field: name, Message: fmt.Sprintf("must be at least %d%s", limit, unit)}
+	}
+	if key == "max" && n > int64(limit) {
+		return &Error{Field: name, Message: fmt.Sprintf("must be at most %d%s", limit, unit)}
+	}
+	return nil
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func contains(options []string, s string) bool {
+	for _, option := range options {
+		if option == s {
+			return true
+		}
+	}
+	return false
+}
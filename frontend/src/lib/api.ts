@@ -13,18 +13,14 @@ const API_BASE_URL = process.env.NEXT_PUBLIC_API_BASE_URL || 'http://localhost:8
 
 class ApiClient {
   private async getAuthHeaders(): Promise<HeadersInit> {
-    return {
-      'Content-Type': 'application/json',
-    }
-  }
-
-  private async getUserId(): Promise<string> {
     const session = await getSession()
-    if (!session?.user?.email) {
+    if (!session?.backendToken) {
       throw new Error('No authenticated user')
     }
-    // Use email as user ID for now (in production, map this properly)
-    return session.user.email
+    return {
+      'Content-Type': 'application/json',
+      Authorization: `Bearer ${session.backendToken}`,
+    }
   }
 
   private async request<T>(
@@ -60,37 +56,31 @@ class ApiClient {
 
   // User APIs
   async getCurrentUser(): Promise<User> {
-    const userId = await this.getUserId()
-    return this.request<User>(`/v1/me?userId=${encodeURIComponent(userId)}`)
+    return this.request<User>('/v1/me')
   }
 
   async upsertUser(userData: Partial<User>): Promise<User> {
-    const userId = await this.getUserId()
-    // Include the user ID in the userData
-    const userDataWithId = { ...userData, id: userId }
+    // The backend takes the user ID from the token
     return this.request<User>('/v1/users/me', {
       method: 'PUT',
-      body: JSON.stringify(userDataWithId),
+      body: JSON.stringify(userData),
     })
   }
 
   // Conversation APIs
   async getConversations(): Promise<Conversation[]> {
-    const userId = await this.getUserId()
-    return this.request<Conversation[]>(`/v1/conversations?userId=${encodeURIComponent(userId)}`)
+    return this.request<Conversation[]>('/v1/conversations')
   }
 
   async createConversation(data: CreateConversationRequest): Promise<Conversation> {
-    const userId = await this.getUserId()
-    return this.request<Conversation>(`/v1/conversations?userId=${encodeURIComponent(userId)}`, {
+    return this.request<Conversation>('/v1/conversations', {
       method: 'POST',
       body: JSON.stringify(data),
     })
   }
 
   async deleteConversation(conversationId: string): Promise<void> {
-    const userId = await this.getUserId()
-    await this.request(`/v1/conversations/${conversationId}?userId=${encodeURIComponent(userId)}`, {
+    await this.request(`/v1/conversations/${conversationId}`, {
       method: 'DELETE',
     })
   }
@@ -101,10 +91,8 @@ class ApiClient {
     before?: string,
     limit: number = 50
   ): Promise<PaginatedMessagesResponse> {
-    const userId = await this.getUserId()
     const params = new URLSearchParams({
       limit: limit.toString(),
-      userId: userId
     })
     if (before) {
       params.append('before', before)
@@ -116,16 +104,14 @@ class ApiClient {
   }
 
   async sendMessage(data: SendMessageRequest): Promise<Message> {
-    const userId = await this.getUserId()
-    return this.request<Message>(`/v1/messages?userId=${encodeURIComponent(userId)}`, {
+    return this.request<Message>('/v1/messages', {
       method: 'POST',
       body: JSON.stringify(data),
     })
   }
 
   async markMessageAsRead(conversationId: string, messageId: number): Promise<void> {
-    const userId = await this.getUserId()
-    await this.request(`/v1/messages/${messageId}/read?userId=${encodeURIComponent(userId)}`, {
+    await this.request(`/v1/messages/${messageId}/read`, {
       method: 'POST',
       body: JSON.stringify({ conversationId }),
     })
@@ -1,6 +1,7 @@
 import { NextAuthOptions } from 'next-auth'
 import GithubProvider from 'next-auth/providers/github'
 import GoogleProvider from 'next-auth/providers/google'
+import { backendTokenNeedsRenewal, signBackendToken } from '@/lib/backend-token'
 
 const providers = []
 
@@ -39,8 +40,22 @@ export const authOptions: NextAuthOptions = {
       if (account && user) {
         token.accessToken = account.access_token
         token.provider = account.provider
+      }
+
+      // The backend identifies users by email, the subject of its token
+      if (token.email && backendTokenNeedsRenewal(token.backendTokenExpiresAt)) {
+        const { token: backendToken, expiresAt } = signBackendToken(token.email)
+        token.backendToken = backendToken
+        token.backendTokenExpiresAt = expiresAt
+      }
+
+      if (account && user && token.backendToken) {
         // Upsert user in our backend
-        await upsertUser(user)
+        await upsertUser(
+          user,
+          token.backendToken,
+          account.provider === 'github' ? account.access_token : undefined
+        )
       }
 
       return token
@@ -53,6 +68,9 @@ export const authOptions: NextAuthOptions = {
       if (token.provider) {
         session.provider = token.provider as string
       }
+      if (token.backendToken) {
+        session.backendToken = token.backendToken
+      }
       if (token.sub) {
         session.user.id = token.sub
       }
@@ -66,16 +84,17 @@ export const authOptions: NextAuthOptions = {
   },
 }
 
-async function upsertUser(user: { email?: string | null; name?: string | null; image?: string | null }) {
+async function upsertUser(user: { email?: string | null; name?: string | null; image?: string | null }, backendToken: string, githubToken?: string) {
   try {
     const response = await fetch(`${process.env.NEXT_PUBLIC_API_BASE_URL || 'http://localhost:8080'}/v1/users/me`, {
       method: 'PUT',
       headers: {
         'Content-Type': 'application/json',
-        // Note: In production, we'd send the JWT token here
+        Authorization: `Bearer ${backendToken}`,
+        // Lets a backend with GITHUB_VERIFY_PROFILES check the profile against GitHub
+        ...(githubToken ? { 'X-GitHub-Token': githubToken } : {}),
       },
       body: JSON.stringify({
-        id: user.email, // Use email as user ID
         email: user.email,
         name: user.name,
         avatarUrl: user.image,
@@ -0,0 +1,40 @@
+import { createSign } from 'crypto'
+
+// The backend only accepts RS256 tokens it can verify with JWT_PUBLIC_KEY_PEM. They are signed
+// here, on the server, with the matching private key and handed to the browser in the session.
+// They are short-lived and renewed whenever the session is read close to their expiry.
+const TOKEN_LIFETIME_SECONDS = 60 * 60
+const TOKEN_RENEW_SECONDS = 5 * 60
+
+function base64url(data: string): string {
+  return Buffer.from(data).toString('base64url')
+}
+
+export function signBackendToken(subject: string): { token: string; expiresAt: number } {
+  const privateKey = process.env.JWT_PRIVATE_KEY_PEM?.replace(/\\n/g, '\n')
+  if (!privateKey) {
+    throw new Error('JWT_PRIVATE_KEY_PEM is not set')
+  }
+
+  const now = Math.floor(Date.now() / 1000)
+  const expiresAt = now + TOKEN_LIFETIME_SECONDS
+  const header = base64url(JSON.stringify({ alg: 'RS256', typ: 'JWT' }))
+  const payload = base64url(
+    JSON.stringify({
+      sub: subject,
+      iss: process.env.JWT_ISSUER || 'chat-service',
+      aud: process.env.JWT_AUDIENCE || 'chat-frontend',
+      iat: now,
+      exp: expiresAt,
+    })
+  )
+  const signature = createSign('RSA-SHA256')
+    .update(`${header}.${payload}`)
+    .sign(privateKey, 'base64url')
+
+  return { token: `${header}.${payload}.${signature}`, expiresAt }
+}
+
+export function backendTokenNeedsRenewal(expiresAt?: number): boolean {
+  return !expiresAt || expiresAt - Math.floor(Date.now() / 1000) < TOKEN_RENEW_SECONDS
+}
@@ -44,14 +44,14 @@ export class ChatWebSocket {
     }
 
     const session = await getSession()
-    if (!session?.user) {
+    if (!session?.backendToken) {
       throw new Error('No authenticated user')
     }
 
     try {
-      // Connect to WebSocket endpoint with user ID as query parameter
-      const userId = encodeURIComponent(session.user.email || '')
-      this.ws = new WebSocket(`${this.wsUrl}/ws?userId=${userId}`)
+      // Browsers cannot set headers on WebSocket requests, so the token goes in the
+      // subprotocol list the backend reads it from
+      this.ws = new WebSocket(`${this.wsUrl}/ws`, ['bearer', session.backendToken])
 
       this.ws.onopen = () => {
         console.log('WebSocket connected')
@@ -4,6 +4,7 @@ declare module 'next-auth' {
   interface Session {
     accessToken?: string
     provider?: string
+    backendToken?: string
     user: {
       id: string
       name?: string | null
@@ -24,5 +25,7 @@ declare module 'next-auth/jwt' {
   interface JWT {
     accessToken?: string
     provider?: string
+    backendToken?: string
+    backendTokenExpiresAt?: number
   }
 }
\ No newline at end of file